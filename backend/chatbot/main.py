@@ -1,18 +1,117 @@
-from fastapi import FastAPI, HTTPException, Depends, status, Request
+from fastapi import (FastAPI, HTTPException, Depends, status, Request, UploadFile, File, Form, Header,
+                     WebSocket, WebSocketDisconnect, Query)
 from fastapi.middleware.cors import CORSMiddleware
+from fastapi.responses import PlainTextResponse
+from fastapi.encoders import jsonable_encoder
 from fastapi.security import HTTPBearer, HTTPAuthorizationCredentials
 from pydantic import BaseModel, Field, EmailStr
-from typing import List, Optional, Dict, Any
-from datetime import datetime, timezone
+from typing import List, Optional, Dict, Any, Set
+from datetime import datetime, timezone, timedelta
 import structlog
 import os
 import re
 import asyncio
+import time
 from jose import jwt
 from jose.exceptions import JWTError
 from pymongo import ReturnDocument
 from shared.db.database import DatabaseConnection
-from shared.db.models import ChatRequest, StatusEnum, DepartmentEnum, GuestProfile
+from shared.db.models import (ChatRequest, StatusEnum, DepartmentEnum, GuestProfile, ChatAttachment,
+                              ConversationStatusEnum, DispositionEnum, Venue, Reservation, VenueTypeEnum,
+                              Recommendation, RecommendationCategoryEnum, TransportModeEnum, TransportRequest,
+                              TransportStatusEnum, LostItemReport, FoundItem, ReturnMethodEnum, RetentionPolicy,
+                              RetentionModeEnum, RetentionStrategyEnum, GuestLocation)
+from shared.venue_map import shared_location
+from shared.blob_storage import upload_blob, generate_signed_url, BlobStorageError
+from shared.dnd import set_room_dnd
+from shared.routing_rules import RoutingRules, rules_from_keywords, in_rollout
+from shared.text_normalization import normalize_message
+from shared import fault_injection
+from shared.contracts import ChatRequestMessage, WorkOrderStatusEvent
+from shared.errors import install_error_handlers, error_response, ApiError, ErrorCode
+from shared.quotas import check_open_order_quota
+from shared.sentiment import score_sentiment, SENTIMENT_ALERT_THRESHOLD
+from shared.timeparse import TimeResolution, parse_requested_time, resolve_time
+from shared.wakeup import detect_wake_up_command, schedule_wake_up_call, cancel_wake_up_calls, confirm_wake_up_call
+from shared.devices import DeviceCommand, parse_device_command, actuate
+from shared.workflows import detect_workflow
+from shared.bookings import (BOOKING_HOLD_MINUTES, CONFIRM_PATTERN, BookingError, detect_booking, list_venues, get_venue, save_venue,
+                             pick_venue, availability, hold_slot, confirm_reservation, cancel_reservation, get_reservation,
+                             get_held_reservation, list_reservations, release_expired_holds, reservation_ics,
+                             ensure_booking_indexes)
+from shared.recommendations import (RecommendationError, detect_recommendation, recommend, list_recommendations,
+                                    save_recommendation, delete_recommendation, ensure_recommendation_indexes)
+from shared.transport import (TransportError, detect_transport, create_transport_request, dispatch,
+                              get_transport_request, list_transport_requests, apply_update, cancel_transport_request,
+                              ensure_transport_indexes)
+from shared.incidents import classify_emergency, open_incident, alert_payload, ensure_incident_indexes
+from shared.transcripts import RetentionError, get_policy, save_policy, enforce_retention, ensure_retention_indexes
+from shared.lost_found import (LostFoundError, detect_lost_item, create_report, get_report, list_reports,
+                               log_found_item, list_found_items, add_item_photo, suggest_matches, confirm_match,
+                               arrange_return, mark_returned, close_report, ensure_lost_found_indexes)
+from shared.access import (ACCESS_CODE_PATTERN, ACCESS_CODE_TTL_MINUTES, LockSystemError, VerificationLimitError,
+                           detect_access_request, default_extension_until, extension_limit, create_challenge, get_pending_challenge,
+                           verify_challenge, grant_access, record_security_event, ensure_access_indexes)
+from shared.handoff import (wants_human, conversation_message, get_open_conversation, open_conversation,
+                            append_message, list_conversations, get_conversation, active_conversation_count,
+                            claim_conversation, release_conversation, transfer_conversation, close_conversation,
+                            mark_read, unread_count, ConversationError, AGENT_MAX_CONVERSATIONS)
+from shared.security.keys import KeyRing
+from shared.security.oidc import OidcVerifier
+from shared.security.field_crypto import field_cipher
+from shared.event_store import install as install_event_store
+from shared.security.policy import staff_departments
+from shared.response_templates import (ResponseTemplate, ResponseTemplates, TemplateError, list_templates,
+                                       upsert_template, delete_template)
+from shared.security.policy import resolve_guest_id
+from shared import metrics
+from shared.middleware import RequestIdMiddleware, TimeoutMiddleware, RecoveryMiddleware, CompressionMiddleware, remaining_time
+from shared.conditional import conditional_response
+from shared.draining import drain, DRAIN_RETRY_AFTER_SECONDS
+from shared.runtime_config import RuntimeConfigError, runtime_config
+from shared.clock import as_utc, format_local, hotel_timezone, local_time, utc_offset
+from shared.bootstrap import guest_profile, hotel_info, open_requests, unread_notifications
+from shared import idempotency
+from shared.idempotency import IdempotencyError, REPLAYED_HEADER, ensure_idempotency_indexes
+from shared.persona import (PersonaError, PersonaUpdate, ResponsePersona, apply_persona, delete_persona,
+                            ensure_persona_indexes, get_persona, response_personas, save_persona)
+from shared.promotions import (PROMOTION_TAG, Promotion, PromotionError, PromotionUpdate, delete_promotion,
+                               ensure_promotion_indexes, fulfilment_request, get_promotion, list_promotions,
+                               offer_report, offer_view, promotions, respond_to_offer, save_promotion)
+from shared.security.device_auth import (DEVICE_TOKEN_PREFIX, DeviceError, DeviceRegistration, authenticate_device,
+                                         ensure_device_indexes, list_devices, register_device, reset_device_session,
+                                         revoke_device)
+from shared.messages import (MessageCatalogError, MessageOverride, delete_override, ensure_message_indexes, fallback_chain,
+                             list_overrides, normalize_locale, save_override)
+from shared.messages import catalog as message_catalog
+from shared.knowledge_base import (KnowledgeArticle, KnowledgeArticleUpdate, KnowledgeError, delete_article,
+                                   ensure_knowledge_indexes, knowledge_base, list_articles, save_article)
+from shared.onboarding import (OnboardingCodeRequest, OnboardingError, OnboardingRedemption,
+                               ensure_onboarding_indexes, issue_code, redeem_code)
+from shared.rooms import (ROOM_CHOICE_TAG, LinkedRoomsUpdate, RoomError, ensure_room_indexes, hold_for_room_choice,
+                          link_rooms, linked_rooms, resolve_room, take_held_message)
+from shared.time_choices import TIME_CHOICE_TAG, ensure_time_choice_indexes, hold_for_time_choice, take_time_choice
+from shared.feature_flags import (FeatureFlag, FeatureFlagError, FeatureFlagUpdate, feature_flags, list_flags, save_flag,
+                                  delete_flag, ensure_feature_flag_indexes)
+from shared.tracing import REQUEST_ID_PROPERTY, current_request_id
+from shared.http_client import ResilientClient
+from shared.capacity import DEFERRED_TAG, CapacityClient, LoadLevelEnum, should_defer
+from shared.business_hours import business_calendars, is_open, next_open, to_local
+from shared.guest_blocks import (BLOCKED_TAG, FLAGGED_TAG, GuestBlockError, GuestRestrictionRequest, RestrictionLevelEnum,
+                                 get_restriction, list_restrictions, restrict_guest, lift_restriction,
+                                 record_suppressed, restriction_audit, ensure_guest_block_indexes)
+from shared.intents import (INTENT_RULES_CONFIDENCE, ChatEntities, IntentMatch, clu_confidence, direct_intent,
+                            extract_entities, extract_line_items, merge_clu_entities)
+from shared.chat_edits import ChatEditError, edit_request, recall_request
+from shared.shadow_classifier import (SHADOW_CLASSIFIER, SHADOW_CLASSIFIER_SAMPLE_PERCENT, classify_with_llm,
+                                      record_decision, comparison_report, ensure_shadow_classifier_indexes)
+from shared.quick_actions import (QuickAction, QuickActionError, QuickActionUpdate, list_quick_actions, get_quick_action,
+                                  save_quick_action, delete_quick_action, guest_view, work_order_tags,
+                                  ensure_quick_action_indexes)
+from shared.leases import Lease, ensure_lease_indexes, release_held_leases
+from shared.migrations.runner import migrate_on_startup
+from shared.sandbox import install as install_sandbox
+from shared.events import EventPublisher, IncidentOpened, StatusChanged
 import uuid
 from passlib.context import CryptContext
 from azure.ai.textanalytics.aio import TextAnalyticsClient
@@ -62,7 +161,12 @@ app.add_middleware(
     allow_credentials=True,
     allow_methods=["*"],
     allow_headers=["*"],
+    expose_headers=["X-Request-ID", "ETag", "Last-Modified", "Idempotent-Replayed"],
 )
+app.add_middleware(CompressionMiddleware)
+app.add_middleware(TimeoutMiddleware)
+app.add_middleware(RecoveryMiddleware, service="chatbot")
+app.add_middleware(RequestIdMiddleware)
 
 security = HTTPBearer()
 JWT_SECRET = os.getenv("JWT_SECRET")
@@ -71,64 +175,163 @@ AZURE_LUIS_ENDPOINT = os.getenv("AZURE_LUIS_ENDPOINT")
 AZURE_LUIS_KEY = os.getenv("AZURE_LUIS_KEY")
 AZURE_SERVICE_BUS_CONN_STR = os.getenv("AZURE_SERVICE_BUS_CONN_STR")
 AZURE_SERVICE_BUS_QUEUE = os.getenv("AZURE_SERVICE_BUS_QUEUE", "chat-requests")
+# Session-enabled queues keep each guest's messages in order (session id = guest id)
+SERVICE_BUS_SESSIONS_ENABLED = os.getenv("SERVICE_BUS_SESSIONS_ENABLED", "false").lower() == "true"
+# Scheduled requests reach the work-order queue this long before the requested time
+SCHEDULE_LEAD_MINUTES = int(os.getenv("SCHEDULE_LEAD_MINUTES", "15"))
 NOTIFICATION_SERVICE_WEBHOOK = os.getenv("NOTIFICATION_SERVICE_WEBHOOK")
+MAX_ATTACHMENT_BYTES = int(os.getenv("MAX_ATTACHMENT_MB", "10")) * 1024 * 1024
+ATTACHMENT_URL_TTL_MINUTES = int(os.getenv("ATTACHMENT_URL_TTL_MINUTES", "15"))
+INTERNAL_EVENTS_TOKEN = os.getenv("INTERNAL_EVENTS_TOKEN")
+DUTY_MANAGER_WEBHOOK_URL = os.getenv("DUTY_MANAGER_WEBHOOK_URL")
+SENTIMENT_ALERT_COOLDOWN_HOURS = int(os.getenv("SENTIMENT_ALERT_COOLDOWN_HOURS", "12"))
+VERIFICATION_CODE_URL = os.getenv("VERIFICATION_CODE_URL", "http://localhost:8003/internal/verification-codes")
+CAPACITY_URL = os.getenv("CAPACITY_URL", "http://localhost:8002/internal/capacity")
+RESERVATION_CONFIRMATION_URL = os.getenv("RESERVATION_CONFIRMATION_URL",
+                                         "http://localhost:8003/internal/reservation-confirmations")
+# Calls to the notification service and the duty-manager webhook
+http_client = ResilientClient("chatbot", timeout=10.0)
+capacity = CapacityClient(CAPACITY_URL, INTERNAL_EVENTS_TOKEN, http_client)
+ALLOWED_ATTACHMENT_TYPES = {"image/jpeg": ".jpg", "image/png": ".png", "image/webp": ".webp", "image/heic": ".heic"}
 
 pwd_context = CryptContext(schemes=["bcrypt"], deprecated="auto")
 
-def verify_jwt(credentials: HTTPAuthorizationCredentials = Depends(security)):
-    if JWT_SECRET is None:
-        logger.error("jwt_secret_missing", error="JWT_SECRET environment variable is not set")
+domain_events = EventPublisher("chatbot")
+oidc = OidcVerifier()
+key_ring = KeyRing(legacy_secret=JWT_SECRET, legacy_algorithm=JWT_ALGORITHM, federated=oidc)
+
+async def verify_device(credentials: HTTPAuthorizationCredentials = Depends(security)):
+    """A kiosk or in-room tablet, authenticated by its device token (shared/security/device_auth.py)."""
+    try:
+        return await authenticate_device(credentials.credentials)
+    except DeviceError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+
+async def verify_jwt(credentials: HTTPAuthorizationCredentials = Depends(security)):
+    if credentials.credentials.startswith(DEVICE_TOKEN_PREFIX):
+        principal = await verify_device(credentials)
+        if not principal.get("sub"):
+            raise HTTPException(status_code=403, detail="No guest is checked in to this room")
+        return principal
+    if JWT_SECRET is None and key_ring.signing is None:
+        logger.error("jwt_secret_missing", error="No signing key in jwt_keys and JWT_SECRET is not set")
         raise HTTPException(
             status_code=status.HTTP_500_INTERNAL_SERVER_ERROR,
             detail="JWT secret is not configured"
         )
     try:
-        payload = jwt.decode(credentials.credentials, JWT_SECRET, algorithms=[JWT_ALGORITHM])
-        return payload
+        payload = await key_ring.verify(credentials.credentials)
     except JWTError as e:
         logger.error("jwt_verification_failed", error=str(e))
         raise HTTPException(
             status_code=status.HTTP_401_UNAUTHORIZED,
             detail="Invalid or expired token",
         )
+    return payload
+
+def require_staff(payload=Depends(verify_jwt)):
+    if payload.get("role") not in ("staff", "admin"):
+        raise HTTPException(status_code=403, detail="Staff access required")
+    return payload
+
+def require_admin(payload=Depends(verify_jwt)):
+    if payload.get("role") != "admin":
+        raise HTTPException(status_code=403, detail="Admin access required")
+    return payload
 
-RATE_LIMIT = 10 
 rate_limit_cache: Dict[str, List[datetime]] = {}
 
-def rate_limit(guest_id: str):
-    now = datetime.utcnow()
+def rate_limit(guest_id: str, per_minute: Optional[int] = None):
+    now = datetime.now(timezone.utc)
     window = [t for t in rate_limit_cache.get(guest_id, []) if (now - t).seconds < 60]
-    if len(window) >= RATE_LIMIT:
+    if len(window) >= (per_minute or runtime_config.settings.rate_limits.chat_per_minute):
         raise HTTPException(status_code=429, detail="Rate limit exceeded. Please wait.")
     window.append(now)
     rate_limit_cache[guest_id] = window
 
-def classify_intent(message: str) -> Optional[DepartmentEnum]:
+INTENT_KEYWORDS = {
+    DepartmentEnum.HOUSEKEEPING: [r"towel|clean|linen|sheet|pillow|blanket|toiletries"],
+    DepartmentEnum.MAINTENANCE: [r"ac|air.?condition|fix|repair|leak|broken|light|bulb|plumbing"],
+    DepartmentEnum.ROOM_SERVICE: [r"food|order|menu|breakfast|dinner|lunch|drink|water|coffee"],
+    DepartmentEnum.IT: [r"wifi|internet|tv|remote|network|connect"],
+    DepartmentEnum.FRONT_DESK: [r"checkout|check.?out|late|early|bill|invoice|key|card"],
+    DepartmentEnum.SECURITY: [r"safe|security|lost|theft|emergency|alarm"],
+    DepartmentEnum.CONCIERGE: [r"taxi|tour|spa|reservation|booking|recommend|restaurant"]
+}
+# Admin-managed rulesets (with canary/shadow rollout) override the builtin keywords once activated
+intent_rules = RoutingRules(builtin=rules_from_keywords(INTENT_KEYWORDS))
+
+def classify_intent(message: str, routing_key: Optional[str] = None) -> Optional[DepartmentEnum]:
     # Fallback: keyword matching
+    return intent_rules.decide(message, routing_key)
+
+def keyword_intent(message: str) -> IntentMatch:
+    match = intent_rules.match(message)
+    if not match:
+        return IntentMatch(None, None, "rules")
+    # A misspelling matched fuzzily counts for less, the further it was from the keyword
+    return IntentMatch(match.department, round(INTENT_RULES_CONFIDENCE * match.similarity, 3), "rules",
+                       suggestion=match.corrected)
+
+DND_PATTERN = re.compile(r"do.?not.?disturb|don'?t disturb|\bdnd\b|no housekeeping")
+DND_OFF_PATTERN = re.compile(r"\boff\b|cancel|clear|remove|no longer|stop|resume")
+
+def detect_dnd_command(message: str) -> Optional[bool]:
+    """Returns True/False if the message sets/clears Do-Not-Disturb, or None if it is not a DND command."""
     text = message.lower()
-    if re.search(r"towel|clean|linen|sheet|pillow|blanket", text):
-        return DepartmentEnum.HOUSEKEEPING
-    if re.search(r"ac|air.?condition|fix|repair|leak|broken|light|bulb|plumbing", text):
-        return DepartmentEnum.MAINTENANCE
-    if re.search(r"food|order|menu|breakfast|dinner|lunch|drink|water|coffee", text):
-        return DepartmentEnum.ROOM_SERVICE
-    if re.search(r"wifi|internet|tv|remote|network|connect", text):
-        return DepartmentEnum.IT
-    if re.search(r"checkout|check.?out|late|early|bill|invoice|key|card", text):
-        return DepartmentEnum.FRONT_DESK
-    if re.search(r"safe|security|lost|theft|emergency|alarm", text):
-        return DepartmentEnum.SECURITY
-    if re.search(r"taxi|tour|spa|reservation|booking|recommend|restaurant", text):
-        return DepartmentEnum.CONCIERGE
-    return None
+    if not DND_PATTERN.search(text):
+        return None
+    return not DND_OFF_PATTERN.search(text)
+
+def is_direct_action(message: str) -> bool:
+    """Messages handled without creating a work order don't count against the open-request quota."""
+    return (classify_emergency(message) is not None or detect_dnd_command(message) is not None or detect_wake_up_command(message) is not None
+            or detect_access_request(message) is not None or bool(ACCESS_CODE_PATTERN.match(message))
+            or detect_booking(message) is not None or detect_recommendation(message) is not None
+            or detect_lost_item(message) is not None)
 
 class ChatMessage(BaseModel):
+    guest_id: Optional[str] = None  # must match the token subject unless the caller is an admin
     text: Optional[str] = None
     voice_transcript: Optional[str] = None
     images: Optional[List[str]] = None  
     quick_reply: Optional[str] = None
+    location: Optional[GuestLocation] = Field(None, description="Dropped unless `consent` is set")
     metadata: Dict[str, Any] = Field(default_factory=dict)
 
+class ChatResponse(ChatRequest):
+    """The stored chat request plus what the bot made of it."""
+    intent: Optional[str] = Field(None, description="Department the request was routed to, or the action the bot handled itself")
+    confidence: Optional[float] = Field(None, ge=0.0, le=1.0)
+    intent_source: Optional[str] = Field(None, description="clu, rules, quick_action or direct")
+    entities: ChatEntities = Field(default_factory=ChatEntities)
+    work_order_created: bool = False
+
+def persona_reply(chat_request: ChatRequest) -> Optional[str]:
+    """The stored reply in the hotel's voice; emergency replies go out exactly as written."""
+    metadata = chat_request.metadata or {}
+    if "emergency" in chat_request.tags:
+        return metadata.get("reply")
+    name = chat_request.guest_profile.name if chat_request.guest_profile else metadata.get("guest_name")
+    return apply_persona(metadata.get("reply"), response_personas.persona, chat_request.language,
+                         datetime.now(hotel_timezone()), name=name, department=getattr(chat_request.department, "value", chat_request.department))
+
+def chat_response(chat_request: ChatRequest, entities: ChatEntities) -> ChatResponse:
+    metadata = chat_request.metadata or {}
+    intent = metadata.get("intent") or {}
+    if not intent and direct_intent(chat_request.tags):
+        intent = {"name": direct_intent(chat_request.tags), "confidence": 1.0, "source": "direct"}
+    if metadata.get("reply"):
+        metadata = {**metadata, "reply": persona_reply(chat_request)}
+    return ChatResponse(
+        **(chat_request.model_dump() | {"metadata": metadata}),
+        intent=intent.get("name"),
+        confidence=intent.get("confidence"),
+        intent_source=intent.get("source"),
+        entities=ChatEntities(**intent["entities"]) if intent.get("entities") else entities,
+        work_order_created=bool(metadata.get("work_order_created"))
+    )
+
 class ChatSessionContext(BaseModel):
     guest_id: str
     session_id: str
@@ -157,6 +360,7 @@ class MenuItem(BaseModel):
 class FoodOrderRequest(BaseModel):
     items: List[Dict[str, Any]]  # e.g. [{"item_id": "burger1", "quantity": 2, "notes": "No onions"}]
     special_instructions: Optional[str] = None
+    room_number: Optional[str] = Field(None, description="Required when the guest has more than one room")
 
 # --- Azure LUIS Intent Classification ---
 async def classify_intent_azure_luis(message: str) -> Optional[DepartmentEnum]:
@@ -227,7 +431,7 @@ async def classify_intent_azure_luis(message: str) -> Optional[DepartmentEnum]:
 # --- Conversational Language Understanding (CLU) Intent Classification ---
 from typing import Optional
 
-async def classify_intent_clu(message: str, conversation_id: Optional[str] = None, user_id: Optional[str] = None) -> Optional[DepartmentEnum]:
+async def classify_intent_clu(message: str, conversation_id: Optional[str] = None, user_id: Optional[str] = None) -> IntentMatch:
     """
     Uses Azure Conversational Language Understanding (CLU) to extract the top intent from a message.
     Requires the following environment variables:
@@ -243,7 +447,7 @@ async def classify_intent_clu(message: str, conversation_id: Optional[str] = Non
     AZURE_CLU_DEPLOYMENT = os.getenv("AZURE_CLU_DEPLOYMENT")
     if not (AZURE_CLU_ENDPOINT and AZURE_CLU_KEY and AZURE_CLU_PROJECT and AZURE_CLU_DEPLOYMENT):
         logger.warning("CLU not configured, falling back to keyword intent.")
-        return classify_intent(message)
+        return keyword_intent(message)
     url = f"{AZURE_CLU_ENDPOINT}/language/:analyze-conversations?api-version=2023-04-01"
     headers = {
         "Ocp-Apim-Subscription-Key": AZURE_CLU_KEY,
@@ -271,7 +475,7 @@ async def classify_intent_clu(message: str, conversation_id: Optional[str] = Non
             response = await client.post(url, headers=headers, json=payload)
             if response.status_code != 200:
                 logger.error("clu_api_failed", status=response.status_code, body=response.text)
-                return classify_intent(message)
+                return keyword_intent(message)
             data = response.json()
             # Example CLU response structure:
             # {
@@ -301,26 +505,67 @@ async def classify_intent_clu(message: str, conversation_id: Optional[str] = Non
             }
             for key, value in intent_map.items():
                 if key in top_intent.replace(" ", "").replace("_", "").lower():
-                    return value
-        return classify_intent(message)
+                    return IntentMatch(value, clu_confidence(prediction), "clu", prediction)
+        return keyword_intent(message)
     except Exception as e:
         logger.error("clu_intent_failed", error=str(e))
-        return classify_intent(message)
+        return keyword_intent(message)
+
+# --- Shadow Classifier (dark launch) ---
+async def shadow_classify(request_id: str, text: str, primary: IntentMatch, session_id: str, guest_id: str):
+    """Runs SHADOW_CLASSIFIER after the guest has their reply and records both decisions; never routes anything."""
+    started = time.monotonic()
+    try:
+        if SHADOW_CLASSIFIER == "rules":
+            shadow = keyword_intent(text)
+        elif SHADOW_CLASSIFIER == "clu":
+            shadow = await classify_intent_clu(text, conversation_id=session_id, user_id=guest_id)
+        elif SHADOW_CLASSIFIER == "llm":
+            if not feature_flags.is_enabled("llm_classifier", guest_id):
+                return
+            shadow = await classify_with_llm(text, http_client)
+        else:
+            logger.warning("unknown_shadow_classifier", classifier=SHADOW_CLASSIFIER)
+            return
+        await record_decision(request_id, primary, shadow, SHADOW_CLASSIFIER, (time.monotonic() - started) * 1000)
+    except Exception as e:
+        logger.error("shadow_classifier_failed", classifier=SHADOW_CLASSIFIER, request_id=request_id, error=str(e))
+
+@app.get("/api/v1/admin/classifier-comparison", tags=["Admin"])
+async def get_classifier_comparison(start: Optional[datetime] = None, end: Optional[datetime] = None,
+                                    classifier: Optional[str] = None, user=Depends(require_admin)):
+    """Live vs shadow classifier accuracy against where work orders ended up; defaults to the last 14 days."""
+    end = end or datetime.now(timezone.utc)
+    return await comparison_report(start or end - timedelta(days=14), end, classifier)
 
 # --- Azure Service Bus Integration ---
-async def publish_to_service_bus(message: dict):
+async def publish_to_service_bus(message: ChatRequestMessage):
     if not AZURE_SERVICE_BUS_CONN_STR or not AZURE_SERVICE_BUS_QUEUE:
         logger.warning("service_bus_not_configured")
         return
     try:
+        fault_injection.service_bus_error("send")
         async with ServiceBusClient.from_connection_string(AZURE_SERVICE_BUS_CONN_STR) as sb_client:
             sender: ServiceBusSender = sb_client.get_queue_sender(queue_name=AZURE_SERVICE_BUS_QUEUE)
             async with sender:
-                sb_message = ServiceBusMessage(str(message))
-                await sender.send_messages(sb_message)
-        logger.info("published_to_service_bus", message=message)
+                # message_id = request_id lets Service Bus duplicate detection drop resends of the same request
+                sb_message = ServiceBusMessage(
+                    message.to_json(),
+                    content_type="application/json",
+                    message_id=message.request_id,
+                    correlation_id=message.session_id,
+                    session_id=message.guest_id if SERVICE_BUS_SESSIONS_ENABLED else None,
+                    application_properties={REQUEST_ID_PROPERTY: current_request_id.get()} if current_request_id.get() else None
+                )
+                if message.scheduled_for:
+                    # Future-dated requests are held by Service Bus and only become work orders near the requested time
+                    enqueue_at = message.scheduled_for - timedelta(minutes=SCHEDULE_LEAD_MINUTES)
+                    if enqueue_at > datetime.now(timezone.utc):
+                        sb_message.scheduled_enqueue_time_utc = enqueue_at
+                await sender.send_messages(sb_message, timeout=remaining_time(default=30.0))
+        logger.info("published_to_service_bus", request_id=message.request_id, scheduled_for=message.scheduled_for)
         # Notify notification service webhook
-        await notify_webhook(message)
+        await notify_webhook(message.model_dump(mode="json"))
     except Exception as e:
         logger.error("service_bus_publish_failed", error=str(e))
 
@@ -329,9 +574,10 @@ async def notify_webhook(message: dict):
     if not NOTIFICATION_SERVICE_WEBHOOK:
         logger.warning("notification_webhook_not_configured")
         return
+    if fault_injection.drop_notification("webhook"):
+        return
     try:
-        async with httpx.AsyncClient() as client:
-            await client.post(NOTIFICATION_SERVICE_WEBHOOK, json=message)
+        await http_client.post(NOTIFICATION_SERVICE_WEBHOOK, json=message)
         logger.info("notified_webhook", webhook=NOTIFICATION_SERVICE_WEBHOOK)
     except Exception as e:
         logger.error("webhook_notify_failed", error=str(e))
@@ -346,7 +592,7 @@ async def audit_log(event: str, data: dict):
                 await conn.virtualbutler.audit_logs.insert_one({
                     "event": event,
                     "data": anonymized_data,
-                    "timestamp": datetime.utcnow()
+                    "timestamp": datetime.now(timezone.utc)
                 })
     except Exception as e:
         logger.error("audit_log_failed", error=str(e))
@@ -359,7 +605,9 @@ async def authenticate_guest(auth: AuthRequest):
         if conn is None or not hasattr(conn, "virtualbutler"):
             logger.error("db_connection_failed", error="Database connection is None or missing 'virtualbutler' attribute")
             raise HTTPException(status_code=500, detail="Database connection error")
-        guest_doc = await conn.virtualbutler.guest_profiles.find_one({"room_number": auth.room_number})
+        guest_doc = await conn.virtualbutler.guest_profiles.find_one(
+            {"$or": [{"room_number": auth.room_number}, {"rooms": auth.room_number}]}
+        )
         if not guest_doc:
             logger.warning("auth_failed", reason="Room not found", room_number=auth.room_number)
             raise HTTPException(status_code=401, detail="Invalid room number or PIN")
@@ -369,18 +617,45 @@ async def authenticate_guest(auth: AuthRequest):
             raise HTTPException(status_code=401, detail="Invalid room number or PIN")
         guest_id = guest_doc["guest_id"]
         payload = {"sub": guest_id, "room": auth.room_number, "role": "guest"}
-        if JWT_SECRET is None:
-            logger.error("jwt_secret_missing", error="JWT_SECRET environment variable is not set")
+        try:
+            token = key_ring.encode(payload)
+        except JWTError:
+            logger.error("jwt_secret_missing", error="No signing key in jwt_keys and JWT_SECRET is not set")
             raise HTTPException(status_code=500, detail="JWT secret is not configured")
-        token = jwt.encode(payload, JWT_SECRET, algorithm=JWT_ALGORITHM)
         return AuthResponse(token=token, guest_id=guest_id)
 
+@app.post("/api/v1/onboarding/codes", status_code=201, tags=["Auth"])
+async def post_onboarding_code(data: OnboardingCodeRequest, user=Depends(require_staff)):
+    """A single-use QR code that signs the guest in for their stay (shared/onboarding.py)."""
+    try:
+        code = await issue_code(data, created_by=user.get("sub"))
+    except OnboardingError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await audit_log("onboarding_code_issued", {"staff": user.get("sub"), "guest_id": code["guest_id"],
+                                               "room_number": code["room_number"]})
+    return code
+
+@app.post("/api/v1/onboarding/redeem", response_model=AuthResponse, tags=["Auth"])
+async def redeem_onboarding_code(data: OnboardingRedemption):
+    """Exchanges a scanned code for a guest JWT that expires at checkout."""
+    try:
+        session = await redeem_code(data.token)
+    except OnboardingError as e:
+        logger.warning("onboarding_failed", reason=str(e))
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    try:
+        token = key_ring.encode(session["claims"], ttl=session["ttl"])
+    except JWTError:
+        logger.error("jwt_secret_missing", error="No signing key in jwt_keys and JWT_SECRET is not set")
+        raise HTTPException(status_code=500, detail="JWT secret is not configured")
+    return AuthResponse(token=token, guest_id=session["claims"]["sub"])
+
 
 # --- Multi-turn Conversation Context ---
 @app.get("/api/v1/chat/history", response_model=List[ChatRequest], tags=["Chat"])
-async def get_chat_history(user=Depends(verify_jwt)):
+async def get_chat_history(request: Request, user=Depends(verify_jwt)):
     guest_id = user["sub"]
-    return await get_chat_history_for_guest(guest_id)
+    return conditional_response(request, await get_chat_history_for_guest(guest_id))
 
 # New: Admin/staff can view any guest's chat history
 @app.get("/api/v1/chat/history/{guest_id}", response_model=List[ChatRequest], tags=["Chat"])
@@ -406,7 +681,7 @@ async def get_chat_history_for_guest(guest_id: str):
         raise HTTPException(status_code=500, detail="Failed to fetch chat history")
 
 @app.get("/api/v1/chat/notifications", tags=["Chat"])
-async def get_notifications(user=Depends(verify_jwt)):
+async def get_notifications(request: Request, user=Depends(verify_jwt)):
     guest_id = user["sub"]
     try:
         notifications = []
@@ -422,11 +697,15 @@ async def get_notifications(user=Depends(verify_jwt)):
                 # Remove sensitive/internal fields if any
                 doc.pop("_id", None)
                 notifications.append(doc)
-        return {"notifications": notifications}
+        return conditional_response(request, {"notifications": notifications})
     except Exception as e:
         logger.error("get_notifications_failed", error=str(e))
         raise HTTPException(status_code=500, detail="Failed to fetch notifications")
 
+@app.get("/metrics", include_in_schema=False)
+async def metrics_endpoint():
+    return PlainTextResponse(metrics.render_prometheus(), media_type="text/plain; version=0.0.4")
+
 @app.get("/healthz")
 async def health_check():
     try:
@@ -436,9 +715,42 @@ async def health_check():
         return {"status": "unhealthy", "error": str(e)}
 
 @app.get("/readiness")
-async def readiness_check():
+async def readiness_check(request: Request):
+    if drain.draining:
+        return error_response(503, "Draining", request=request, headers={"Retry-After": str(DRAIN_RETRY_AFTER_SECONDS)})
     return {"status": "ready"}
 
+@app.post("/api/v1/admin/drain", status_code=202, tags=["Admin"])
+async def start_drain(user=Depends(require_admin)):
+    """Stops taking chat requests, finishes background sends, then exits (see shared/draining.py)."""
+    if drain.start(f"admin {user.get('sub')}"):
+        await audit_log("drain_started", {"admin": user.get("sub")})
+    return drain.status()
+
+@app.get("/api/v1/admin/drain", tags=["Admin"])
+async def get_drain_status(user=Depends(require_admin)):
+    return drain.status()
+
+# --- Runtime Config ---
+@app.get("/api/v1/admin/config", tags=["Admin"])
+async def get_runtime_config(user=Depends(require_admin)):
+    """The settings this replica is using, their sources and version hash, and the last reload error."""
+    return runtime_config.status()
+
+@app.put("/api/v1/admin/config", tags=["Admin"])
+async def put_runtime_config(overrides: Dict[str, Any], user=Depends(require_admin)):
+    try:
+        status = await runtime_config.save_overrides(overrides, user.get("sub"))
+    except RuntimeConfigError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    await audit_log("runtime_config_saved", {"admin": user.get("sub"), "version": status["version"]})
+    return status
+
+@app.post("/api/v1/admin/config/reload", tags=["Admin"])
+async def reload_runtime_config(user=Depends(require_admin)):
+    """Reloads now instead of at the next refresh; `last_error` is set if the new settings were rejected."""
+    return await runtime_config.reload(reason="admin")
+
 @app.post("/api/v1/chat/plugin/{plugin_name}", tags=["Plugins"])
 async def plugin_handler(plugin_name: str, payload: Dict[str, Any], user=Depends(verify_jwt)):
     logger.info("plugin_invoked", plugin=plugin_name, guest_id=user["sub"])
@@ -456,58 +768,104 @@ async def plugin_handler(plugin_name: str, payload: Dict[str, Any], user=Depends
 from fastapi.responses import JSONResponse
 import json
 
-def load_translations():
-    translations = {}
-    i18n_dir = os.path.join(os.path.dirname(__file__), "i18n")
-    allowed_langs = {"en", "fr", "es", "zh"}
-    for lang_code in allowed_langs:
-        # Only allow known language codes to prevent path traversal
-        path = os.path.join(i18n_dir, f"{lang_code}.json")
-        try:
-            with open(path, encoding="utf-8") as f:
-                translations[lang_code] = json.load(f)
-        except Exception as e:
-            logger.error("translation_load_failed", lang=lang_code, error=str(e))
-            translations[lang_code] = {}
-    return translations
-
-TRANSLATIONS = load_translations()
+def translate(key: str, lang: str = "en", **kwargs) -> str:
+    """The guest-facing message in the guest's locale, with this hotel's overrides (shared/messages.py)."""
+    return message_catalog.translate(key, lang, **kwargs)
 
 @app.get("/api/v1/chat/i18n/{lang}", tags=["i18n"])
-async def get_translations(lang: str):
+async def get_translations(lang: str, request: Request):
     """
-    Returns translation dictionary for supported languages.
-    Supported: en (English), fr (French), es (Spanish), zh (Mandarin Chinese)
+    Returns the message catalog for a locale, fallbacks and hotel overrides applied; plural
+    messages come as objects keyed by plural form.
     """
-    allowed_langs = {"en", "fr", "es", "zh"}
-    lang = lang.lower()
-    if lang not in allowed_langs or not TRANSLATIONS.get(lang):
-        return JSONResponse(
-            status_code=404,
-            content={
-                "detail": f"Language '{lang}' not supported or translation file missing.",
-                "supported_languages": [k for k, v in TRANSLATIONS.items() if v]
-            }
+    lang = normalize_locale(lang)
+    if not set(fallback_chain(lang)[:2]) & set(message_catalog.languages()):
+        return error_response(
+            404,
+            f"Language '{lang}' not supported or translation file missing.",
+            request=request,
+            supported_languages=message_catalog.languages()
         )
-    return {"lang": lang, "translations": TRANSLATIONS[lang]}
-
-@app.exception_handler(Exception)
-async def global_exception_handler(request: Request, exc: Exception):
-    logger.error("unhandled_exception", error=str(exc))
-    return JSONResponse(
-        status_code=500,
-        content={"detail": "An unexpected error occurred. Please try again later."}
-    )
+    return {"lang": lang, "translations": message_catalog.messages(lang)}
+
+@app.get("/api/v1/admin/translations", tags=["Admin"])
+async def get_translation_overrides(locale: Optional[str] = None, user=Depends(require_admin)):
+    """This hotel's overrides of the base catalog."""
+    return await list_overrides(locale=locale)
+
+@app.put("/api/v1/admin/translations/{locale}/{key}", tags=["Admin"])
+async def put_translation_override(locale: str, key: str, data: MessageOverride, user=Depends(require_admin)):
+    """Overrides one message for one locale, e.g. {"value": "Welcome to the Grand!"} or a plural object."""
+    try:
+        override = await save_override(locale, key, data.value, updated_by=user.get("sub"))
+    except MessageCatalogError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await audit_log("translation_override_saved", {"locale": override["locale"], "key": key, "admin": user.get("sub")})
+    return override
+
+@app.delete("/api/v1/admin/translations/{locale}/{key}", status_code=204, tags=["Admin"])
+async def delete_translation_override(locale: str, key: str, user=Depends(require_admin)):
+    try:
+        await delete_override(locale, key)
+    except MessageCatalogError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await audit_log("translation_override_deleted", {"locale": locale, "key": key, "admin": user.get("sub")})
+
+install_error_handlers(app)
 
 @app.on_event("startup")
 async def startup_db_client():
     await DatabaseConnection.connect()
+    install_event_store()
+    install_sandbox()
+    await field_cipher.start()
+    await key_ring.refresh()
+    asyncio.create_task(key_ring.refresh_loop())
+    await oidc.refresh()
+    asyncio.create_task(oidc.refresh_loop())
+    asyncio.create_task(intent_rules.refresh_loop())
+    asyncio.create_task(response_templates.refresh_loop())
+    await ensure_access_indexes()
+    await ensure_booking_indexes()
+    await ensure_recommendation_indexes()
+    await ensure_transport_indexes()
+    await ensure_lost_found_indexes()
+    await ensure_incident_indexes()
+    await ensure_retention_indexes()
+    await ensure_lease_indexes()
+    await migrate_on_startup()
+    await ensure_guest_block_indexes()
+    await ensure_quick_action_indexes()
+    await ensure_shadow_classifier_indexes()
+    await ensure_feature_flag_indexes()
+    await ensure_idempotency_indexes()
+    await ensure_persona_indexes()
+    await ensure_promotion_indexes()
+    await ensure_room_indexes()
+    await ensure_device_indexes()
+    await ensure_onboarding_indexes()
+    await ensure_knowledge_indexes()
+    await ensure_message_indexes()
+    await ensure_time_choice_indexes()
+    asyncio.create_task(feature_flags.refresh_loop())
+    asyncio.create_task(response_personas.refresh_loop())
+    asyncio.create_task(promotions.refresh_loop())
+    asyncio.create_task(knowledge_base.refresh_loop())
+    asyncio.create_task(message_catalog.refresh_loop())
+    drain.install_signal_handler()
+    runtime_config.on_reload(intent_rules.refresh)
+    await runtime_config.reload(reason="startup")
+    asyncio.create_task(runtime_config.refresh_loop())
+    asyncio.create_task(booking_hold_loop())
+    asyncio.create_task(transcript_retention_loop())
 
 @app.on_event("shutdown")
 async def shutdown_db_client():
+    await release_held_leases()
+    await http_client.aclose()
     await DatabaseConnection.close()
 
-@app.post("/api/v1/order", tags=["Room Service"])
+@app.post("/api/v1/order", tags=["Room Service"], dependencies=[Depends(drain.ensure_accepting)])
 async def place_food_order(
     order: FoodOrderRequest,
     request: Request,
@@ -515,6 +873,11 @@ async def place_food_order(
 ):
     guest_id = user["sub"]
     rate_limit(guest_id)
+    restriction = await get_restriction(guest_id)
+    if restriction and restriction["level"] == RestrictionLevelEnum.BLOCKED.value:
+        await record_suppressed(guest_id, f"order_{datetime.now(timezone.utc).timestamp()}", "room_service")
+        raise ApiError(403, blocked_reply(restriction, "en"))
+    await enforce_open_order_quota(guest_id, user)
     try:
         async with DatabaseConnection.get_connection() as conn:
             if conn is None or not hasattr(conn, "virtualbutler"):
@@ -523,6 +886,7 @@ async def place_food_order(
             guest_doc = await conn.virtualbutler.guest_profiles.find_one({"guest_id": guest_id})
             guest_profile = GuestProfile(**guest_doc) if guest_doc else None
 
+        room_number = room_for_request(guest_profile, order.room_number, user)
         session_id = request.headers.get("X-Session-Id", str(uuid.uuid4()))
         order_summary = ", ".join([f"{item['quantity']}x {item['item_id']}" for item in order.items])
         msg_text = f"Room service order: {order_summary}"
@@ -536,12 +900,12 @@ async def place_food_order(
             message=msg_text,
             department=DepartmentEnum.ROOM_SERVICE,
             status=StatusEnum.PENDING,
-            tags=["room_service", "order"],
+            tags=["room_service", "order"] + ([FLAGGED_TAG] if restriction else []),
             created_at=datetime.now(timezone.utc),
             updated_at=datetime.now(timezone.utc),
             metadata={
                 "session_id": session_id,
-                "room_number": guest_profile.room_number if guest_profile else None,
+                "room_number": room_number,
                 "guest_name": guest_profile.name if guest_profile else None,
                 "order_items": order.items,
                 "special_instructions": order.special_instructions
@@ -554,9 +918,13 @@ async def place_food_order(
                 logger.error("db_connection_failed", error="Database connection is None or missing 'virtualbutler' attribute")
                 raise HTTPException(status_code=500, detail="Database connection error")
             await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
-            asyncio.create_task(publish_to_service_bus(chat_request.dict()))
+            drain.track(publish_to_service_bus(ChatRequestMessage.from_chat_request(chat_request)))
             logger.info("food_order_created", request_id=chat_request.request_id, guest_id=guest_id)
-            return {"status": "order_placed", "request_id": chat_request.request_id}
+        offer = await make_offer("room_service", guest_id, guest_doc, chat_request.language, chat_request.request_id)
+        return {"status": "order_placed", "request_id": chat_request.request_id,
+                "offer": offer_view(offer) if offer else None}
+    except HTTPException:
+        raise
     except Exception as e:
         logger.error("food_order_failed", error=str(e))
         raise HTTPException(status_code=500, detail="Failed to place food order")
@@ -582,7 +950,7 @@ async def get_order_history(user=Depends(verify_jwt)):
         raise HTTPException(status_code=500, detail="Failed to fetch order history")
 
 @app.get("/api/v1/order/status/{request_id}", tags=["Room Service"])
-async def get_order_status(request_id: str, user=Depends(verify_jwt)):
+async def get_order_status(request_id: str, request: Request, user=Depends(verify_jwt)):
     guest_id = user["sub"]
     try:
         async with DatabaseConnection.get_connection() as conn:
@@ -596,21 +964,2048 @@ async def get_order_status(request_id: str, user=Depends(verify_jwt)):
             })
             if not doc:
                 raise HTTPException(status_code=404, detail="Order not found")
-            return {"request_id": request_id, "status": doc.get("status"), "updated_at": doc.get("updated_at")}
+            return conditional_response(request, {"request_id": request_id, "status": doc.get("status"),
+                                                  "updated_at": doc.get("updated_at")}, doc.get("updated_at"))
     except Exception as e:
         logger.error("get_order_status_failed", error=str(e))
         raise HTTPException(status_code=500, detail="Failed to fetch order status")
 
 
-# --- Multi-turn Chat: Store and retrieve context ---
-@app.post("/api/v1/chat", response_model=ChatRequest, status_code=201, tags=["Chat"])
-async def create_chat_request(
-    message: ChatMessage,
-    request: Request,
+# --- Acknowledgement Templates ---
+# Admin-managed templates override the i18n "ack_<department>" defaults per intent and language
+response_templates = ResponseTemplates()
+
+class ResponseTemplateUpdate(BaseModel):
+    text: str
+
+def acknowledgement(department: DepartmentEnum, language: str, **values) -> str:
+    intent = DepartmentEnum(department).value
+    default = message_catalog.lookup(f"ack_{intent}", language) or translate("chat_created", language)
+    return response_templates.render(intent, language, default, department=intent.replace("_", " "), **values)
+
+@app.get("/api/v1/admin/response-templates", tags=["Admin"])
+async def get_response_templates(intent: Optional[str] = None, user=Depends(require_admin)):
+    return await list_templates(intent)
+
+@app.put("/api/v1/admin/response-templates/{intent}/{language}", response_model=ResponseTemplate, tags=["Admin"])
+async def put_response_template(intent: DepartmentEnum, language: str, data: ResponseTemplateUpdate,
+                                user=Depends(require_admin)):
+    try:
+        template = ResponseTemplate(intent=intent.value, language=language.lower(), text=data.text,
+                                    updated_by=user.get("sub"))
+    except ValueError as e:
+        raise HTTPException(status_code=422, detail=str(e))
+    saved = await upsert_template(template)
+    await response_templates.refresh()
+    await audit_log("response_template_saved", {"intent": intent.value, "language": language, "admin": user.get("sub")})
+    return saved
+
+@app.delete("/api/v1/admin/response-templates/{intent}/{language}", status_code=204, tags=["Admin"])
+async def delete_response_template(intent: DepartmentEnum, language: str, user=Depends(require_admin)):
+    try:
+        await delete_template(intent.value, language.lower())
+    except TemplateError as e:
+        raise HTTPException(status_code=404, detail=str(e))
+    await response_templates.refresh()
+    await audit_log("response_template_deleted", {"intent": intent.value, "language": language, "admin": user.get("sub")})
+
+# --- Transcript Retention ---
+TRANSCRIPT_RETENTION_POLL_SECONDS = int(os.getenv("TRANSCRIPT_RETENTION_POLL_SECONDS", "3600"))
+
+class RetentionPolicyUpdate(BaseModel):
+    retention_days: int = Field(..., ge=1, le=3650)
+    mode: RetentionModeEnum = RetentionModeEnum.ANONYMIZE
+    strategy: RetentionStrategyEnum = RetentionStrategyEnum.SCHEDULED
+
+@app.get("/api/v1/admin/retention-policy", response_model=RetentionPolicy, tags=["Admin"])
+async def get_retention_policy(user=Depends(require_admin)):
+    return await get_policy()
+
+@app.put("/api/v1/admin/retention-policy", response_model=RetentionPolicy, tags=["Admin"])
+async def put_retention_policy(data: RetentionPolicyUpdate, user=Depends(require_admin)):
+    current = await get_policy()
+    policy = current.model_copy(update={**data.model_dump(), "updated_by": user.get("sub")})
+    try:
+        policy = await save_policy(policy)
+    except RetentionError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    await audit_log("retention_policy_updated", {"admin": user.get("sub"), **data.model_dump(mode="json")})
+    return policy
+
+@app.post("/api/v1/admin/retention-policy/run", tags=["Admin"])
+async def run_transcript_retention(user=Depends(require_admin)):
+    """Applies the policy now instead of waiting for the next scheduled run."""
+    counts = await enforce_retention()
+    await audit_log("retention_run", {"admin": user.get("sub"), "processed": counts})
+    return {"processed": counts}
+
+transcript_retention_lease = Lease("transcript_retention", TRANSCRIPT_RETENTION_POLL_SECONDS)
+
+async def transcript_retention_loop():
+    while True:
+        try:
+            await transcript_retention_lease.run(enforce_retention)
+        except Exception as e:
+            logger.error("transcript_retention_failed", error=str(e))
+        await asyncio.sleep(TRANSCRIPT_RETENTION_POLL_SECONDS)
+
+# --- Guest Sentiment ---
+async def track_sentiment(guest_id: str, sentiment: Optional[float], msg_text: str,
+                          room_number: Optional[str], session_id: str):
+    """Keeps a rolling sentiment history on the guest's conversation and alerts the duty manager on a sharp drop."""
+    if sentiment is None:
+        return
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        context = await conn.virtualbutler.chat_contexts.find_one_and_update(
+            {"guest_id": guest_id},
+            {"$set": {"last_sentiment": sentiment, "updated_at": now},
+             "$push": {"sentiment_history": {"$each": [{"score": sentiment, "timestamp": now}], "$slice": -50}}},
+            upsert=True,
+            return_document=ReturnDocument.BEFORE
+        )
+    if sentiment > SENTIMENT_ALERT_THRESHOLD:
+        return
+    alerted_at = (context or {}).get("sentiment_alerted_at")
+    if alerted_at and alerted_at.replace(tzinfo=timezone.utc) > now - timedelta(hours=SENTIMENT_ALERT_COOLDOWN_HOURS):
+        return
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_contexts.update_one({"guest_id": guest_id}, {"$set": {"sentiment_alerted_at": now}})
+    await alert_duty_manager(guest_id, sentiment, msg_text, room_number, session_id)
+
+async def alert_duty_manager(guest_id: str, sentiment: float, msg_text: str, room_number: Optional[str], session_id: str):
+    alert = {
+        "type": "unhappy_guest",
+        "guest_id": guest_id,
+        "room_number": room_number,
+        "session_id": session_id,
+        "sentiment": sentiment,
+        "message": msg_text[:500],
+        "timestamp": datetime.now(timezone.utc).isoformat()
+    }
+    logger.warning("unhappy_guest_detected", guest_id=guest_id, room_number=room_number, sentiment=sentiment)
+    metrics.increment("butler_unhappy_guest_alerts_total")
+    await audit_log("unhappy_guest_alert", alert)
+    if not DUTY_MANAGER_WEBHOOK_URL:
+        logger.warning("duty_manager_webhook_not_configured")
+        return
+    try:
+        await http_client.post(DUTY_MANAGER_WEBHOOK_URL, json=alert)
+    except Exception as e:
+        logger.error("duty_manager_alert_failed", guest_id=guest_id, error=str(e))
+
+# --- Open Request Quota ---
+async def enforce_open_order_quota(guest_id: str, user: dict, language: str = "en"):
+    # Admins acting for a guest are not subject to the cap
+    if user.get("role") == "admin":
+        return
+    allowed, count, limit = await check_open_order_quota(guest_id)
+    if not allowed:
+        logger.warning("open_order_quota_exceeded", guest_id=guest_id, open_orders=count, limit=limit)
+        raise ApiError(429, translate("open_request_limit", language, count=count), ErrorCode.QUOTA_EXCEEDED)
+
+# --- Guest Blocks ---
+def blocked_reply(restriction: dict, language: str) -> str:
+    return restriction.get("message") or translate("guest_blocked", language)
+
+async def handle_blocked_chat(guest_id: str, restriction: dict, msg_text: str, session_id: str,
+                              language: str) -> ChatRequest:
+    """Answers a blocked guest with the managed message; the request is kept but no work order is created."""
+    reply = blocked_reply(restriction, language)
+    chat_request = ChatRequest(
+        request_id=f"req_{datetime.now(timezone.utc).timestamp()}",
+        guest_id=guest_id,
+        message=msg_text,
+        department=DepartmentEnum.FRONT_DESK,
+        status=StatusEnum.CANCELLED,
+        tags=[BLOCKED_TAG],
+        language=language,
+        created_at=datetime.now(timezone.utc),
+        updated_at=datetime.now(timezone.utc),
+        metadata={"session_id": session_id, "room_number": restriction.get("room_number"), "reply": reply}
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    await record_suppressed(guest_id, chat_request.request_id, "chat")
+    metrics.increment("butler_requests_suppressed_total", channel="chat")
+    return chat_request
+
+@app.get("/api/v1/admin/guest-blocks", tags=["Admin"])
+async def get_guest_blocks(user=Depends(require_admin)):
+    """Guests currently flagged or blocked."""
+    return await list_restrictions()
+
+@app.put("/api/v1/admin/guests/{guest_id}/block", tags=["Admin"])
+async def put_guest_block(guest_id: str, data: GuestRestrictionRequest, user=Depends(require_admin)):
+    try:
+        return await restrict_guest(guest_id, data, user.get("sub"))
+    except GuestBlockError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+
+@app.delete("/api/v1/admin/guests/{guest_id}/block", tags=["Admin"])
+async def delete_guest_block(guest_id: str, reason: Optional[str] = None, user=Depends(require_admin)):
+    try:
+        return await lift_restriction(guest_id, user.get("sub"), reason)
+    except GuestBlockError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+
+@app.get("/api/v1/admin/guests/{guest_id}/block/audit", tags=["Admin"])
+async def get_guest_block_audit(guest_id: str, limit: int = 100, user=Depends(require_admin)):
+    return await restriction_audit(guest_id, min(limit, 500))
+
+# --- Linked Rooms ---
+def guest_rooms(guest_profile: Optional[GuestProfile]) -> List[str]:
+    """Only rooms linked to the profile count; a room claim in the token doesn't make it the guest's room."""
+    return linked_rooms(guest_profile)
+
+def device_room(user: dict) -> Optional[str]:
+    return user.get("room") if user.get("device_id") else None
+
+def room_for_request(guest_profile: Optional[GuestProfile], requested: Optional[str], user: dict) -> Optional[str]:
+    """The room for an order or quick action; a guest with several rooms has to say which."""
+    rooms = guest_rooms(guest_profile)
+    device = device_room(user)
+    try:
+        # An in-room tablet is bound to its room when it is paired, so its room stands without a link
+        room_number = device if device and not requested else resolve_room(rooms, "", requested)
+    except RoomError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    if room_number is None and len(rooms) > 1:
+        raise HTTPException(status_code=422, detail=f"room_number is required, one of: {', '.join(rooms)}")
+    return room_number
+
+async def ask_which_room(guest_id: str, message: ChatMessage, rooms: List[str], msg_text: str, session_id: str,
+                         language: str) -> ChatRequest:
+    """Holds the message until the guest picks a room; the rooms come back as quick replies."""
+    await hold_for_room_choice(guest_id, message.model_dump(), rooms)
+    chat_request = ChatRequest(
+        request_id=f"req_{datetime.now(timezone.utc).timestamp()}",
+        guest_id=guest_id,
+        message=msg_text,
+        department=DepartmentEnum.FRONT_DESK,
+        status=StatusEnum.COMPLETED,
+        tags=[ROOM_CHOICE_TAG],
+        language=language,
+        created_at=datetime.now(timezone.utc),
+        updated_at=datetime.now(timezone.utc),
+        metadata={"session_id": session_id, "reply": translate("which_room", language, rooms=", ".join(rooms)),
+                  "quick_replies": rooms}
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    return chat_request
+
+def requested_time(metadata: dict) -> Optional[datetime]:
+    """A time already settled: picked in the app, or the answer to "which time?"."""
+    value = metadata.get("scheduled_for")
+    if not value:
+        return None
+    try:
+        return as_utc(datetime.fromisoformat(value))
+    except (TypeError, ValueError):
+        logger.warning("scheduled_for_invalid", value=str(value))
+        return None
+
+async def ask_which_time(guest_id: str, message: ChatMessage, options: List[datetime], msg_text: str,
+                         session_id: str, language: str) -> ChatRequest:
+    """Holds the message until the guest says which time they meant; the times come back as quick replies."""
+    await hold_for_time_choice(guest_id, message.model_dump(), options)
+    labels = [format_local(option) for option in options]
+    chat_request = ChatRequest(
+        request_id=f"req_{datetime.now(timezone.utc).timestamp()}",
+        guest_id=guest_id,
+        message=msg_text,
+        department=DepartmentEnum.FRONT_DESK,
+        status=StatusEnum.COMPLETED,
+        tags=[TIME_CHOICE_TAG],
+        language=language,
+        created_at=datetime.now(timezone.utc),
+        updated_at=datetime.now(timezone.utc),
+        metadata={"session_id": session_id, "reply": translate("which_time", language, options=" / ".join(labels)),
+                  "quick_replies": labels}
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    return chat_request
+
+async def ask_to_clarify(guest_id: str, suggestion: str, msg_text: str, session_id: str, language: str) -> ChatRequest:
+    """Checks a guess at a misspelled request; the corrected message comes back as a quick reply to send."""
+    chat_request = ChatRequest(
+        request_id=f"req_{datetime.now(timezone.utc).timestamp()}",
+        guest_id=guest_id,
+        message=msg_text,
+        department=DepartmentEnum.FRONT_DESK,
+        status=StatusEnum.COMPLETED,
+        tags=["clarify"],
+        language=language,
+        created_at=datetime.now(timezone.utc),
+        updated_at=datetime.now(timezone.utc),
+        metadata={"session_id": session_id, "reply": translate("did_you_mean", language, text=suggestion),
+                  "quick_replies": [suggestion]}
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    logger.info("intent_clarification_requested", guest_id=guest_id, suggestion=suggestion)
+    return chat_request
+
+@app.put("/api/v1/admin/guests/{guest_id}/rooms", tags=["Admin"])
+async def put_guest_rooms(guest_id: str, data: LinkedRoomsUpdate, user=Depends(require_admin)):
+    """Links the guest to these rooms, the first being the primary one (family suites, group bookings)."""
+    try:
+        linked = await link_rooms(guest_id, data.rooms)
+    except RoomError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await audit_log("guest_rooms_linked", {"admin": user.get("sub"), **linked})
+    return linked
+
+# --- Kiosks and In-room Tablets ---
+@app.get("/api/v1/device/session", tags=["Devices"])
+async def get_device_session(device=Depends(verify_device)):
+    """The device's current session; a new session_id means a new guest, so the screen starts afresh."""
+    guest_name = None
+    if device.get("role") == "guest" and device.get("sub"):
+        async with DatabaseConnection.get_connection() as conn:
+            guest = await conn.virtualbutler.guest_profiles.find_one({"guest_id": device["sub"]}, {"name": 1})
+        guest_name = (guest or {}).get("name")
+    return {"device_id": device["device_id"], "kind": device["device_kind"], "room_number": device.get("room"),
+            "session_id": device["session_id"], "occupied": bool(device.get("sub")), "guest_name": guest_name}
+
+@app.get("/api/v1/admin/devices", tags=["Admin"])
+async def get_devices(include_revoked: bool = False, user=Depends(require_admin)):
+    return [device.public_view() for device in await list_devices(include_revoked)]
+
+@app.post("/api/v1/admin/devices", status_code=201, tags=["Admin"])
+async def post_device(data: DeviceRegistration, user=Depends(require_admin)):
+    """Registers a kiosk or tablet; the token is shown once and goes into the device's setup."""
+    try:
+        device, token = await register_device(data, created_by=user.get("sub"))
+    except DeviceError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await audit_log("device_registered", {"admin": user.get("sub"), "device_id": device.device_id,
+                                          "kind": device.kind, "room_number": device.room_number})
+    return {**device.public_view(), "token": token}
+
+@app.post("/api/v1/admin/devices/{device_id}/reset-session", status_code=204, tags=["Admin"])
+async def post_device_session_reset(device_id: str, user=Depends(require_admin)):
+    try:
+        await reset_device_session(device_id)
+    except DeviceError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await audit_log("device_session_reset", {"admin": user.get("sub"), "device_id": device_id})
+
+@app.delete("/api/v1/admin/devices/{device_id}", tags=["Admin"])
+async def delete_device(device_id: str, user=Depends(require_admin)):
+    try:
+        device = await revoke_device(device_id, revoked_by=user.get("sub"))
+    except DeviceError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await audit_log("device_revoked", {"admin": user.get("sub"), "device_id": device_id})
+    return device.public_view()
+
+# --- Chat Attachments ---
+async def ensure_own_request(request_id: str, guest_id: str):
+    """Attachments only go on the caller's own requests; anyone else's is reported as not found."""
+    async with DatabaseConnection.get_connection() as conn:
+        query = {"request_id": request_id, "guest_id": guest_id}
+        found = (await conn.virtualbutler.chat_requests.find_one(query, {"_id": 1})
+                 or await conn.virtualbutler.work_orders.find_one(query, {"_id": 1}))
+    if not found:
+        raise HTTPException(status_code=404, detail="Request not found")
+
+async def link_attachments(attachment_ids: List[str], guest_id: str, request_id: str):
+    """Associates uploaded attachments with a chat request and any work order created from it."""
+    if not attachment_ids:
+        return
+    await ensure_own_request(request_id, guest_id)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_attachments.update_many(
+            {"attachment_id": {"$in": attachment_ids}, "guest_id": guest_id},
+            {"$set": {"request_id": request_id, "updated_at": datetime.now(timezone.utc)}}
+        )
+        work_order = await conn.virtualbutler.work_orders.find_one({"request_id": request_id, "guest_id": guest_id})
+        if work_order:
+            await link_attachments_to_work_order(conn, attachment_ids, guest_id, work_order["work_order_id"])
+
+async def link_attachments_to_work_order(conn, attachment_ids: List[str], guest_id: str, work_order_id: str):
+    query = {"attachment_id": {"$in": attachment_ids}, "guest_id": guest_id}
+    cursor = conn.virtualbutler.chat_attachments.find(query)
+    blob_names = [doc["blob_name"] async for doc in cursor]
+    await conn.virtualbutler.chat_attachments.update_many(query, {"$set": {"work_order_id": work_order_id}})
+    if blob_names:
+        await conn.virtualbutler.work_orders.update_one(
+            {"work_order_id": work_order_id},
+            {"$addToSet": {"attachments": {"$each": blob_names}}, "$inc": {"version": 1}}
+        )
+
+@app.post("/api/v1/chat/attachment", status_code=201, tags=["Chat"])
+async def upload_chat_attachment(
+    file: UploadFile = File(...),
+    request_id: Optional[str] = Form(None),
+    session_id: Optional[str] = Form(None),
     user=Depends(verify_jwt)
 ):
     guest_id = user["sub"]
     rate_limit(guest_id)
+    extension = ALLOWED_ATTACHMENT_TYPES.get(file.content_type)
+    if not extension:
+        raise HTTPException(
+            status_code=415,
+            detail=f"Unsupported content type. Allowed: {', '.join(sorted(ALLOWED_ATTACHMENT_TYPES))}"
+        )
+    data = await file.read(MAX_ATTACHMENT_BYTES + 1)
+    if len(data) > MAX_ATTACHMENT_BYTES:
+        raise HTTPException(status_code=413, detail="Attachment exceeds maximum allowed size")
+    if not data:
+        raise HTTPException(status_code=400, detail="Attachment is empty")
+    if request_id:
+        # Checked before storing anything, so a refused upload leaves no orphaned blob
+        await ensure_own_request(request_id, guest_id)
+
+    attachment_id = f"att_{uuid.uuid4().hex}"
+    blob_name = f"chat/{guest_id}/{attachment_id}{extension}"
+    try:
+        await upload_blob(blob_name, data, file.content_type)
+    except BlobStorageError as e:
+        logger.error("chat_attachment_upload_failed", guest_id=guest_id, error=str(e))
+        raise HTTPException(status_code=502, detail="Failed to store attachment")
+
+    attachment = ChatAttachment(
+        attachment_id=attachment_id,
+        guest_id=guest_id,
+        session_id=session_id,
+        blob_name=blob_name,
+        content_type=file.content_type,
+        size_bytes=len(data),
+        filename=file.filename
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_attachments.insert_one(attachment.model_dump(by_alias=True, exclude={"id"}))
+    if request_id:
+        await link_attachments([attachment_id], guest_id, request_id)
+    await audit_log("chat_attachment_uploaded", {"attachment_id": attachment_id, "guest_id": guest_id, "size": len(data)})
+    return {
+        "attachment_id": attachment_id,
+        "url": generate_signed_url(blob_name, ATTACHMENT_URL_TTL_MINUTES),
+        "expires_in": ATTACHMENT_URL_TTL_MINUTES * 60
+    }
+
+@app.get("/api/v1/chat/attachment/{attachment_id}", tags=["Chat"])
+async def get_chat_attachment(attachment_id: str, user=Depends(verify_jwt)):
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn.virtualbutler.chat_attachments.find_one({"attachment_id": attachment_id})
+    if not doc:
+        raise HTTPException(status_code=404, detail="Attachment not found")
+    if user.get("role") not in ("staff", "admin") and doc["guest_id"] != user["sub"]:
+        raise HTTPException(status_code=403, detail="Insufficient privileges")
+    return {
+        "attachment_id": attachment_id,
+        "request_id": doc.get("request_id"),
+        "work_order_id": doc.get("work_order_id"),
+        "content_type": doc["content_type"],
+        "url": generate_signed_url(doc["blob_name"], ATTACHMENT_URL_TTL_MINUTES),
+        "expires_in": ATTACHMENT_URL_TTL_MINUTES * 60
+    }
+
+# --- Emergencies ---
+async def handle_emergency_chat(guest_id: str, room_number: Optional[str], incident_type: str, msg_text: str,
+                                session_id: str, language: str) -> ChatRequest:
+    """
+    Opens an incident and alerts security/duty manager on every channel before anything else happens;
+    the guest gets safety instructions straight away instead of a place in the queue.
+    """
+    request_id = f"req_{datetime.now(timezone.utc).timestamp()}"
+    incident = await open_incident(incident_type, msg_text, guest_id=guest_id, room_number=room_number,
+                                   source="chat", request_id=request_id)
+    await broadcast_to_agents(alert_payload(incident))
+    await domain_events.publish(IncidentOpened.from_incident(incident))
+    chat_request = ChatRequest(
+        request_id=request_id,
+        guest_id=guest_id,
+        message=msg_text,
+        department=DepartmentEnum.SECURITY,
+        status=StatusEnum.COMPLETED,
+        tags=["emergency", incident_type],
+        language=language,
+        created_at=datetime.now(timezone.utc),
+        updated_at=datetime.now(timezone.utc),
+        metadata={"session_id": session_id, "room_number": room_number, "incident_id": incident["incident_id"],
+                  "reply": translate(f"emergency_{incident_type}", language)}
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    await audit_log("incident_reported_via_chat", {"guest_id": guest_id, "room_number": room_number,
+                                                   "incident_id": incident["incident_id"],
+                                                   "incident_type": incident_type})
+    return chat_request
+
+# --- Do-Not-Disturb via chat ---
+async def handle_dnd_chat(guest_id: str, room_number: str, active: bool, msg_text: str, session_id: str) -> ChatRequest:
+    """Toggles the room DND flag instead of creating a work order; the work-order service releases held orders."""
+    await set_room_dnd(room_number, active, set_by=guest_id)
+    chat_request = ChatRequest(
+        request_id=f"req_{datetime.now(timezone.utc).timestamp()}",
+        guest_id=guest_id,
+        message=msg_text,
+        department=DepartmentEnum.HOUSEKEEPING,
+        status=StatusEnum.COMPLETED,
+        tags=["dnd_on" if active else "dnd_off"],
+        created_at=datetime.now(timezone.utc),
+        updated_at=datetime.now(timezone.utc),
+        metadata={"session_id": session_id, "room_number": room_number, "dnd_active": active}
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    await audit_log("room_dnd_set_via_chat", {"guest_id": guest_id, "room_number": room_number, "dnd_active": active})
+    return chat_request
+
+# --- Door Access ---
+async def send_verification_code(guest_id: str, code: str, language: str) -> bool:
+    if not INTERNAL_EVENTS_TOKEN:
+        return False
+    try:
+        resp = await http_client.post(
+            VERIFICATION_CODE_URL,
+            json={"guest_id": guest_id, "code": code, "expires_in_minutes": ACCESS_CODE_TTL_MINUTES,
+                  "language": language},
+            headers={"X-Internal-Token": INTERNAL_EVENTS_TOKEN}
+        )
+        resp.raise_for_status()
+        return bool(resp.json().get("sent"))
+    except Exception as e:
+        logger.error("verification_code_send_failed", guest_id=guest_id, error=str(e))
+        return False
+
+async def save_access_chat(guest_id: str, message: str, session_id: str, language: str, room_number: str,
+                           tag: str, reply: str, **metadata) -> ChatRequest:
+    chat_request = ChatRequest(
+        request_id=f"req_{datetime.now(timezone.utc).timestamp()}",
+        guest_id=guest_id,
+        message=message,
+        department=DepartmentEnum.SECURITY,
+        status=StatusEnum.COMPLETED,
+        tags=[tag],
+        language=language,
+        created_at=datetime.now(timezone.utc),
+        updated_at=datetime.now(timezone.utc),
+        metadata={"session_id": session_id, "room_number": room_number, "reply": reply, **metadata}
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    return chat_request
+
+async def handle_access_chat(guest_id: str, room_number: str, purpose: str, msg_text: str,
+                             session_id: str, language: str) -> ChatRequest:
+    """Starts identity verification for a lock-out or key extension; no key is touched until the code checks out."""
+    valid_until = None
+    if purpose == "extension":
+        now = datetime.now(timezone.utc)
+        async with DatabaseConnection.get_connection() as conn:
+            guest = await conn.virtualbutler.guest_profiles.find_one({"guest_id": guest_id}, {"check_out_date": 1})
+        limit = extension_limit(now, (guest or {}).get("check_out_date"), hotel_timezone())
+        wanted = parse_requested_time(msg_text, now, hotel_timezone(), explicit=True)
+        if wanted and wanted > limit:
+            return await request_extension_approval(guest_id, room_number, wanted, msg_text, session_id, language)
+        valid_until = min(wanted or default_extension_until(now, hotel_timezone()), limit).astimezone(timezone.utc)
+    try:
+        challenge, code = await create_challenge(guest_id, room_number, purpose, valid_until)
+    except VerificationLimitError:
+        return await save_access_chat(guest_id, msg_text, session_id, language, room_number, f"access_{purpose}",
+                                      translate("access_visit_front_desk", language))
+    if await send_verification_code(guest_id, code, language):
+        reply = translate("access_code_sent", language, minutes=ACCESS_CODE_TTL_MINUTES)
+    else:
+        await record_security_event("verification_unavailable", guest_id, room_number, "failed",
+                                    purpose=purpose, challenge_id=challenge["challenge_id"])
+        reply = translate("access_visit_front_desk", language)
+    return await save_access_chat(guest_id, msg_text, session_id, language, room_number, f"access_{purpose}",
+                                  reply, challenge_id=challenge["challenge_id"])
+
+async def request_extension_approval(guest_id: str, room_number: str, wanted: datetime, msg_text: str,
+                                     session_id: str, language: str) -> ChatRequest:
+    """A key extension past the self-serve limit: the front desk decides, so no code is sent."""
+    await record_security_event("extension_approval_required", guest_id, room_number, "pending",
+                                requested_until=wanted.astimezone(timezone.utc))
+    chat_request = await save_access_chat(guest_id, msg_text, session_id, language, room_number, "access_extension",
+                                          translate("access_extension_needs_approval", language, time=format_local(wanted)),
+                                          requested_until=wanted.astimezone(timezone.utc))
+    approval = ChatRequest(
+        request_id=f"req_{datetime.now(timezone.utc).timestamp()}",
+        guest_id=guest_id,
+        message=f"Key extension for room {room_number} until {format_local(wanted)} needs approval",
+        department=DepartmentEnum.FRONT_DESK,
+        status=StatusEnum.PENDING,
+        tags=["access_extension_approval"],
+        language=language,
+        created_at=datetime.now(timezone.utc),
+        updated_at=datetime.now(timezone.utc),
+        metadata={"session_id": session_id, "room_number": room_number, "requested_until": wanted.astimezone(timezone.utc).isoformat()}
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(approval.dict(by_alias=True))
+    await publish_to_service_bus(ChatRequestMessage.from_chat_request(approval))
+    return chat_request
+
+async def handle_access_code(guest_id: str, challenge: dict, code: str, session_id: str, language: str) -> ChatRequest:
+    room_number = challenge["room_number"]
+    outcome = await verify_challenge(challenge, code)
+    key = None
+    if outcome == "invalid":
+        reply = translate("access_code_invalid", language)
+    elif outcome == "locked":
+        reply = translate("access_visit_front_desk", language)
+    else:
+        try:
+            key = await grant_access(challenge)
+        except LockSystemError:
+            reply = translate("access_visit_front_desk", language)
+        else:
+            if challenge["purpose"] == "lockout":
+                reply = translate("access_key_issued", language)
+            else:
+                reply = translate("access_key_extended", language, time=format_local(challenge["valid_until"]))
+            await push_to_guest(guest_id, {"type": "mobile_key", "room_number": room_number, "key": key})
+    # Never keep the code itself in the chat history
+    return await save_access_chat(guest_id, "******", session_id, language, room_number, f"access_{outcome}",
+                                  reply, challenge_id=challenge["challenge_id"], key_issued=key is not None)
+
+@app.get("/api/v1/admin/security-events", tags=["Admin"])
+async def get_security_events(room_number: Optional[str] = None, guest_id: Optional[str] = None,
+                              event_type: Optional[str] = None, limit: int = Query(100, ge=1, le=500),
+                              user=Depends(require_admin)):
+    query = {k: v for k, v in {"room_number": room_number, "guest_id": guest_id, "event_type": event_type}.items() if v}
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn.virtualbutler.security_events.find(query, {"_id": 0}).sort("created_at", -1).to_list(length=limit)
+    return docs
+
+# --- Wake-up Calls via chat ---
+async def handle_wake_up_chat(guest_id: str, room_number: str, command: str, msg_text: str,
+                              session_id: str, language: str) -> ChatRequest:
+    """Schedules, cancels or confirms a wake-up call; the work-order service rings the room and escalates."""
+    metadata = {"session_id": session_id, "room_number": room_number}
+    if command == "confirm":
+        call = await confirm_wake_up_call("chat", guest_id=guest_id)
+        metadata["wake_up_call_id"] = call["call_id"] if call else None
+        reply = translate("wakeup_confirmed", language)
+    elif command == "cancel":
+        cancelled = await cancel_wake_up_calls(guest_id)
+        reply = translate("wakeup_cancelled" if cancelled else "wakeup_none_scheduled", language)
+    else:
+        wake_at = parse_requested_time(msg_text, datetime.now(timezone.utc), hotel_timezone(), prefer_morning=True,
+                                       explicit=True)
+        if wake_at is None:
+            reply = translate("wakeup_need_time", language)
+        else:
+            call = await schedule_wake_up_call(guest_id, room_number, wake_at, hotel_timezone().key,
+                                               language=language, created_by=guest_id)
+            local_wake_at = local_time(wake_at)
+            reply = translate("wakeup_scheduled", language, time=local_wake_at.strftime("%H:%M"),
+                              day=local_wake_at.strftime("%d/%m"))
+            metadata["wake_up_call_id"] = call.call_id
+    metadata["reply"] = reply
+    chat_request = ChatRequest(
+        request_id=f"req_{datetime.now(timezone.utc).timestamp()}",
+        guest_id=guest_id,
+        message=msg_text,
+        department=DepartmentEnum.FRONT_DESK,
+        status=StatusEnum.COMPLETED,
+        tags=[f"wake_up_{command}"],
+        language=language,
+        created_at=datetime.now(timezone.utc),
+        updated_at=datetime.now(timezone.utc),
+        metadata=metadata
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    await audit_log("wake_up_call_via_chat", {"guest_id": guest_id, "room_number": room_number, "command": command})
+    return chat_request
+
+# --- In-room Device Control ---
+async def handle_device_chat(guest_id: str, room_number: str, command: DeviceCommand, msg_text: str,
+                             session_id: str, language: str) -> ChatRequest:
+    """Records a request the room's devices already carried out, so no work order is needed."""
+    chat_request = ChatRequest(
+        request_id=f"req_{datetime.now(timezone.utc).timestamp()}",
+        guest_id=guest_id,
+        message=msg_text,
+        department=DepartmentEnum.MAINTENANCE,
+        status=StatusEnum.COMPLETED,
+        tags=["device_control", command.device],
+        language=language,
+        created_at=datetime.now(timezone.utc),
+        updated_at=datetime.now(timezone.utc),
+        metadata={"session_id": session_id, "room_number": room_number,
+                  "device_command": command.model_dump(), "reply": translate(f"device_{command.device}", language)}
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    await audit_log("device_controlled_via_chat", {"guest_id": guest_id, "room_number": room_number,
+                                                   **command.model_dump()})
+    return chat_request
+
+# --- Restaurant & Spa Bookings ---
+BOOKING_HOLD_POLL_SECONDS = int(os.getenv("BOOKING_HOLD_POLL_SECONDS", "60"))
+
+class ReservationCreate(BaseModel):
+    venue_id: str
+    starts_at: datetime
+    party_size: int = Field(2, ge=1)
+    notes: Optional[str] = None
+
+booking_hold_lease = Lease("booking_hold_release", BOOKING_HOLD_POLL_SECONDS)
+
+async def booking_hold_loop():
+    while True:
+        await asyncio.sleep(BOOKING_HOLD_POLL_SECONDS)
+        try:
+            await booking_hold_lease.run(release_expired_holds)
+        except Exception as e:
+            logger.error("booking_hold_release_failed", error=str(e))
+
+def booking_slot_text(starts_at: datetime) -> str:
+    return format_local(starts_at)
+
+async def save_booking_chat(guest_id: str, msg_text: str, session_id: str, language: str, room_number: str,
+                            tag: str, reply: str, **metadata) -> ChatRequest:
+    chat_request = ChatRequest(
+        request_id=f"req_{datetime.now(timezone.utc).timestamp()}",
+        guest_id=guest_id,
+        message=msg_text,
+        department=DepartmentEnum.CONCIERGE,
+        status=StatusEnum.COMPLETED,
+        tags=["booking", tag],
+        language=language,
+        created_at=datetime.now(timezone.utc),
+        updated_at=datetime.now(timezone.utc),
+        metadata={"session_id": session_id, "room_number": room_number, "reply": reply, **metadata}
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    return chat_request
+
+async def handle_booking_chat(guest_id: str, room_number: Optional[str], venue_type: str, party_size: int,
+                              msg_text: str, session_id: str, language: str) -> Optional[ChatRequest]:
+    """
+    Holds the requested slot (or offers the next open ones) and asks the guest to confirm.
+    Returns None when no venue of that type takes bookings, so the request goes to the concierge.
+    """
+    venues = await list_venues(venue_type)
+    if not venues:
+        return None
+    venue = pick_venue(venues, msg_text)
+    if venue is None:
+        reply = translate("booking_which_venue", language, venues=", ".join(v.name for v in venues))
+        return await save_booking_chat(guest_id, msg_text, session_id, language, room_number, "choose_venue", reply)
+    now = datetime.now(timezone.utc)
+    wanted = parse_requested_time(msg_text, now, hotel_timezone(), explicit=True)
+    day = (wanted or now).astimezone(hotel_timezone()).date()
+    if wanted:
+        try:
+            reservation = await hold_slot(venue, guest_id, wanted, party_size, hotel_timezone(),
+                                          room_number=room_number, language=language)
+        except BookingError:
+            pass
+        else:
+            reply = translate("booking_held", language, venue=venue.name, party_size=party_size,
+                              time=booking_slot_text(reservation.starts_at), minutes=BOOKING_HOLD_MINUTES)
+            return await save_booking_chat(guest_id, msg_text, session_id, language, room_number, "held", reply,
+                                           reservation_id=reservation.reservation_id)
+    open_slots = [s["starts_at"] for s in await availability(venue, day, hotel_timezone())
+                  if s["available"] >= party_size and s["starts_at"] > now][:3]
+    if open_slots:
+        reply = translate("booking_suggest", language, venue=venue.name,
+                          times=", ".join(booking_slot_text(s) for s in open_slots))
+    else:
+        reply = translate("booking_full", language, venue=venue.name)
+    return await save_booking_chat(guest_id, msg_text, session_id, language, room_number, "availability", reply,
+                                   venue_id=venue.venue_id)
+
+async def send_reservation_confirmation(reservation: dict):
+    """Hands the confirmed booking to the notification service, which emails it with an .ics invite attached."""
+    if not INTERNAL_EVENTS_TOKEN:
+        return
+    try:
+        resp = await http_client.post(
+            RESERVATION_CONFIRMATION_URL,
+            json={
+                "guest_id": reservation["guest_id"],
+                "reservation_id": reservation["reservation_id"],
+                "venue_name": reservation["venue_name"],
+                "party_size": reservation["party_size"],
+                "starts_at": reservation["starts_at"].isoformat(),
+                "language": reservation.get("language", "en"),
+                "calendar": reservation_ics(reservation)
+            },
+            headers={"X-Internal-Token": INTERNAL_EVENTS_TOKEN}
+        )
+        resp.raise_for_status()
+    except Exception as e:
+        logger.error("reservation_confirmation_failed", reservation_id=reservation["reservation_id"], error=str(e))
+
+async def awaiting_booking_confirmation(guest_id: str) -> Optional[dict]:
+    """The held reservation, but only if our last reply to the guest was the prompt to confirm it."""
+    held = await get_held_reservation(guest_id)
+    if not held:
+        return None
+    async with DatabaseConnection.get_connection() as conn:
+        last = await conn.virtualbutler.chat_requests.find_one({"guest_id": guest_id}, sort=[("created_at", -1)])
+    if not last or "held" not in last.get("tags", []) or "booking" not in last.get("tags", []):
+        return None
+    return held if (last.get("metadata") or {}).get("reservation_id") == held["reservation_id"] else None
+
+async def handle_booking_confirmation(guest_id: str, held: dict, msg_text: str, session_id: str,
+                                      language: str) -> ChatRequest:
+    extra = {}
+    try:
+        reservation = await confirm_reservation(held["reservation_id"], guest_id)
+    except BookingError:
+        reply = translate("booking_expired", language)
+        tag = "expired"
+    else:
+        await send_reservation_confirmation(reservation)
+        reply = translate("booking_confirmed", language, venue=reservation["venue_name"],
+                          time=booking_slot_text(reservation["starts_at"]))
+        tag = "confirmed"
+        offer = await make_offer(f"{reservation.get('venue_type')}_booking", guest_id,
+                                 await guest_profile(guest_id), language, reservation_id=held["reservation_id"])
+        if offer:
+            reply = f"{reply}\n\n{offer['message']}"
+            extra = {"offer": offer_view(offer)}
+    return await save_booking_chat(guest_id, msg_text, session_id, language, held.get("room_number"), tag, reply,
+                                   reservation_id=held["reservation_id"], **extra)
+
+@app.get("/api/v1/venues", response_model=List[Venue], tags=["Bookings"])
+async def get_venues(venue_type: Optional[VenueTypeEnum] = None, user=Depends(verify_jwt)):
+    return await list_venues(venue_type.value if venue_type else None)
+
+@app.get("/api/v1/venues/{venue_id}/availability", tags=["Bookings"])
+async def get_venue_availability(venue_id: str, day: Optional[str] = None, user=Depends(verify_jwt)):
+    try:
+        venue = await get_venue(venue_id)
+        local_day = datetime.fromisoformat(day).date() if day else datetime.now(hotel_timezone()).date()
+    except BookingError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    except ValueError:
+        raise HTTPException(status_code=422, detail="day must be YYYY-MM-DD")
+    return {"venue_id": venue_id, "day": local_day.isoformat(),
+            "slots": await availability(venue, local_day, hotel_timezone())}
+
+@app.put("/api/v1/admin/venues/{venue_id}", response_model=Venue, tags=["Admin"])
+async def put_venue(venue_id: str, venue: Venue, user=Depends(require_admin)):
+    venue.venue_id = venue_id
+    saved = await save_venue(venue)
+    await audit_log("venue_saved", {"venue_id": venue_id, "admin": user.get("sub")})
+    return saved
+
+@app.post("/api/v1/reservations", response_model=Reservation, status_code=201, tags=["Bookings"])
+async def create_reservation(data: ReservationCreate, user=Depends(verify_jwt)):
+    """Holds a slot for BOOKING_HOLD_MINUTES; POST .../confirm to keep it."""
+    guest_id = resolve_guest_id(user, None)
+    try:
+        venue = await get_venue(data.venue_id)
+        return await hold_slot(venue, guest_id, data.starts_at, data.party_size, hotel_timezone(),
+                               room_number=user.get("room"), notes=data.notes)
+    except BookingError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.get("/api/v1/reservations", response_model=List[Reservation], tags=["Bookings"])
+async def get_my_reservations(user=Depends(verify_jwt)):
+    return await list_reservations(guest_id=resolve_guest_id(user, None))
+
+@app.post("/api/v1/reservations/{reservation_id}/confirm", response_model=Reservation, tags=["Bookings"])
+async def confirm_guest_reservation(reservation_id: str, user=Depends(verify_jwt)):
+    try:
+        reservation = await confirm_reservation(reservation_id, resolve_guest_id(user, None))
+    except BookingError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    await send_reservation_confirmation(reservation)
+    return Reservation(**reservation)
+
+@app.delete("/api/v1/reservations/{reservation_id}", response_model=Reservation, tags=["Bookings"])
+async def cancel_guest_reservation(reservation_id: str, user=Depends(verify_jwt)):
+    # Staff can cancel any booking; guests only their own
+    guest_id = None if user.get("role") in ("staff", "admin") else resolve_guest_id(user, None)
+    try:
+        return Reservation(**await cancel_reservation(reservation_id, guest_id))
+    except BookingError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.get("/api/v1/reservations/{reservation_id}/calendar.ics", tags=["Bookings"])
+async def get_reservation_calendar(reservation_id: str, user=Depends(verify_jwt)):
+    try:
+        reservation = await get_reservation(reservation_id, resolve_guest_id(user, None))
+    except BookingError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    return PlainTextResponse(reservation_ics(reservation), media_type="text/calendar")
+
+# --- Concierge Recommendations ---
+async def handle_recommendation_chat(guest_id: str, room_number: Optional[str], category: str, msg_text: str,
+                                     session_id: str, language: str, latitude: Optional[float] = None,
+                                     longitude: Optional[float] = None) -> ChatRequest:
+    """Answers "where should I eat tonight?" with cards for places open at the time the guest means."""
+    now = datetime.now(timezone.utc)
+    at = parse_requested_time(msg_text, now, hotel_timezone(), explicit=True) or now
+    cards = await recommend(category, at, hotel_timezone(), latitude, longitude)
+    reply = translate(f"recommendation_{category}" if cards else "recommendation_none", language)
+    chat_request = ChatRequest(
+        request_id=f"req_{datetime.now(timezone.utc).timestamp()}",
+        guest_id=guest_id,
+        message=msg_text,
+        department=DepartmentEnum.CONCIERGE,
+        status=StatusEnum.COMPLETED,
+        tags=["recommendation", category],
+        language=language,
+        created_at=datetime.now(timezone.utc),
+        updated_at=datetime.now(timezone.utc),
+        metadata={"session_id": session_id, "room_number": room_number, "reply": reply, "cards": cards}
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    logger.info("recommendations_sent", guest_id=guest_id, category=category, count=len(cards))
+    return chat_request
+
+@app.get("/api/v1/recommendations", tags=["Concierge"])
+async def get_recommendations(category: RecommendationCategoryEnum, latitude: Optional[float] = Query(None, ge=-90, le=90),
+                              longitude: Optional[float] = Query(None, ge=-180, le=180), tag: Optional[str] = None,
+                              limit: int = Query(5, ge=1, le=20), user=Depends(verify_jwt)):
+    return await recommend(category.value, datetime.now(timezone.utc), hotel_timezone(), latitude, longitude, tag, limit)
+
+@app.get("/api/v1/admin/recommendations", response_model=List[Recommendation], tags=["Admin"])
+async def get_all_recommendations(category: Optional[RecommendationCategoryEnum] = None, user=Depends(require_admin)):
+    return await list_recommendations(category.value if category else None, active_only=False)
+
+@app.put("/api/v1/admin/recommendations/{recommendation_id}", response_model=Recommendation, tags=["Admin"])
+async def put_recommendation(recommendation_id: str, rec: Recommendation, user=Depends(require_admin)):
+    rec.recommendation_id = recommendation_id
+    try:
+        saved = await save_recommendation(rec)
+    except ValueError as e:
+        raise HTTPException(status_code=422, detail=str(e))
+    await audit_log("recommendation_saved", {"recommendation_id": recommendation_id, "admin": user.get("sub")})
+    return saved
+
+@app.delete("/api/v1/admin/recommendations/{recommendation_id}", status_code=204, tags=["Admin"])
+async def remove_recommendation(recommendation_id: str, user=Depends(require_admin)):
+    try:
+        await delete_recommendation(recommendation_id)
+    except RecommendationError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    await audit_log("recommendation_deleted", {"recommendation_id": recommendation_id, "admin": user.get("sub")})
+
+# --- Real-time Guest Connections ---
+guest_connections: Dict[str, Set[WebSocket]] = {}
+agent_connections: Dict[str, Set[WebSocket]] = {}
+
+async def decode_ws_token(token: str) -> Optional[dict]:
+    try:
+        return await key_ring.verify(token)
+    except (JWTError, AttributeError) as e:
+        logger.warning("websocket_auth_failed", error=str(e))
+        return None
+
+async def receive_frame(websocket: WebSocket) -> dict:
+    """Reads the next inbound frame; anything that is not a JSON object is treated as a keep-alive."""
+    raw = await websocket.receive_text()
+    try:
+        frame = json.loads(raw)
+    except ValueError:
+        return {}
+    return frame if isinstance(frame, dict) else {}
+
+@app.websocket("/api/v1/chat/ws")
+async def chat_websocket(websocket: WebSocket, token: str):
+    user = await decode_ws_token(token)
+    if not user:
+        await websocket.close(code=4401)
+        return
+    guest_id = user["sub"]
+    if not feature_flags.is_enabled("websocket_chat", guest_id):
+        # The app falls back to polling
+        await websocket.close(code=4403)
+        return
+    await websocket.accept()
+    guest_connections.setdefault(guest_id, set()).add(websocket)
+    logger.info("websocket_connected", guest_id=guest_id)
+    await announce_guest_presence(guest_id, True)
+    try:
+        while True:
+            # New requests go through POST /api/v1/chat; frames here are keep-alives or live-agent traffic
+            frame = await receive_frame(websocket)
+            if frame.get("type") in ("message", "typing", "delivered", "read"):
+                await handle_guest_frame(guest_id, frame)
+    except WebSocketDisconnect:
+        pass
+    finally:
+        guest_connections.get(guest_id, set()).discard(websocket)
+        logger.info("websocket_disconnected", guest_id=guest_id)
+        if not guest_connections.get(guest_id):
+            await announce_guest_presence(guest_id, False)
+
+@app.get("/api/v1/chat/conversation", tags=["Chat"])
+async def get_guest_conversation(user=Depends(verify_jwt)):
+    """The guest's open live-agent conversation with both read markers, so the app can restore receipts on reconnect."""
+    conversation = await get_open_conversation(user["sub"])
+    if not conversation:
+        raise HTTPException(status_code=404, detail="No open conversation")
+    doc = {k: v for k, v in conversation.items() if k not in ("_id", "transfers")}
+    doc["unread"] = unread_count(conversation, "guest")
+    return doc
+
+async def push_to_sockets(connections: Dict[str, Set[WebSocket]], key: str, payload: dict) -> bool:
+    delivered = False
+    for websocket in list(connections.get(key, set())):
+        try:
+            await websocket.send_json(payload)
+            delivered = True
+        except Exception as e:
+            logger.warning("websocket_send_failed", recipient=key, error=str(e))
+            connections[key].discard(websocket)
+    return delivered
+
+async def push_to_guest(guest_id: str, payload: dict) -> bool:
+    return await push_to_sockets(guest_connections, guest_id, payload)
+
+async def push_to_agent(agent_id: str, payload: dict) -> bool:
+    return await push_to_sockets(agent_connections, agent_id, payload)
+
+async def broadcast_to_agents(payload: dict):
+    for agent_id in list(agent_connections):
+        await push_to_agent(agent_id, payload)
+
+# --- Live Agent Handoff ---
+def conversation_event(conversation_id: str, message: dict) -> dict:
+    return {"type": "agent_message", "conversation_id": conversation_id,
+            **message, "timestamp": message["timestamp"].isoformat()}
+
+def queue_entry(conversation: dict) -> dict:
+    return {
+        "conversation_id": conversation["conversation_id"],
+        "guest_id": conversation["guest_id"],
+        "room_number": conversation.get("room_number"),
+        "reason": conversation.get("reason"),
+        "department": conversation.get("department"),
+        "guest_online": bool(guest_connections.get(conversation["guest_id"])),
+        "created_at": conversation["created_at"].isoformat()
+    }
+
+async def start_handoff(guest_id: str, reason: str, msg_text: str, session_id: str,
+                        room_number: Optional[str], language: str) -> dict:
+    conversation = await open_conversation(guest_id, reason, msg_text, room_number=room_number,
+                                           session_id=session_id, language=language)
+    if conversation.get("status") == ConversationStatusEnum.WAITING:
+        await broadcast_to_agents({"type": "handoff_queued", **queue_entry(conversation)})
+    await audit_log("handoff_queued", {"guest_id": guest_id, "conversation_id": conversation["conversation_id"],
+                                       "reason": reason})
+    return conversation
+
+async def bridge_guest_message(guest_id: str, conversation: dict, msg_text: str) -> dict:
+    """Stores the guest's message on the conversation and relays it to the assigned agent, if any."""
+    message = conversation_message("guest", msg_text, guest_id)
+    await append_message(conversation["conversation_id"], message)
+    if conversation.get("agent_id"):
+        await push_to_agent(conversation["agent_id"], conversation_event(conversation["conversation_id"], message))
+    return message
+
+async def handle_agent_mode_chat(guest_id: str, conversation: dict, msg_text: str, session_id: str,
+                                 language: str, queued: bool) -> ChatRequest:
+    """Chat messages bypass intent routing while a live-agent conversation is open."""
+    if not queued:
+        await bridge_guest_message(guest_id, conversation, msg_text)
+    chat_request = ChatRequest(
+        request_id=f"req_{datetime.now(timezone.utc).timestamp()}",
+        guest_id=guest_id,
+        message=msg_text,
+        department=conversation.get("department", DepartmentEnum.FRONT_DESK),
+        status=StatusEnum.IN_PROGRESS,
+        tags=["agent_mode"],
+        language=language,
+        created_at=datetime.now(timezone.utc),
+        updated_at=datetime.now(timezone.utc),
+        metadata={
+            "session_id": session_id,
+            "conversation_id": conversation["conversation_id"],
+            "agent_mode": True,
+            "reply": translate("handoff_queued", language) if queued else None
+        }
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    return chat_request
+
+async def relay_receipt(conversation: dict, role: str, frame: dict):
+    """
+    Passes a "delivered" or "read" receipt for a message on to the other side. Read receipts also move the
+    reader's stored marker, so they survive reconnects; one for a message already read is dropped.
+    """
+    message_id = str(frame.get("message_id") or "")
+    if not message_id:
+        return
+    at = datetime.now(timezone.utc)
+    if frame["type"] == "read":
+        marker = await mark_read(conversation, role, message_id)
+        if not marker:
+            return
+        at = marker["read_at"]
+    receipt = {"type": frame["type"], "role": role, "conversation_id": conversation["conversation_id"],
+               "message_id": message_id, "at": at.isoformat()}
+    if role == "agent":
+        await push_to_guest(conversation["guest_id"], receipt)
+    elif conversation.get("agent_id"):
+        await push_to_agent(conversation["agent_id"], receipt)
+
+async def handle_guest_frame(guest_id: str, frame: dict):
+    conversation = await get_open_conversation(guest_id)
+    if not conversation:
+        return
+    if frame["type"] in ("delivered", "read"):
+        await relay_receipt(conversation, "guest", frame)
+        return
+    if frame["type"] == "typing":
+        if conversation.get("agent_id"):
+            await push_to_agent(conversation["agent_id"], {"type": "typing", "role": "guest",
+                                                           "conversation_id": conversation["conversation_id"],
+                                                           "typing": bool(frame.get("typing", True))})
+        return
+    text = str(frame.get("text") or "").strip()[:1000]
+    if text:
+        incident_type = classify_emergency(text)
+        if incident_type:
+            # The agent sees the message too, but alerts can't depend on them noticing it
+            incident = await open_incident(incident_type, text, guest_id=guest_id,
+                                           room_number=conversation.get("room_number"), source="chat")
+            await broadcast_to_agents(alert_payload(incident))
+            await domain_events.publish(IncidentOpened.from_incident(incident))
+        await bridge_guest_message(guest_id, conversation, text)
+
+async def announce_guest_presence(guest_id: str, online: bool):
+    conversation = await get_open_conversation(guest_id)
+    if conversation and conversation.get("agent_id"):
+        await push_to_agent(conversation["agent_id"], {"type": "presence", "role": "guest", "online": online,
+                                                       "conversation_id": conversation["conversation_id"]})
+
+async def announce_agent_presence(agent_id: str, online: bool):
+    async with DatabaseConnection.get_connection() as conn:
+        cursor = conn.virtualbutler.agent_conversations.find(
+            {"agent_id": agent_id, "status": ConversationStatusEnum.ACTIVE},
+            {"conversation_id": 1, "guest_id": 1}
+        )
+        conversations = [doc async for doc in cursor]
+    for conversation in conversations:
+        await push_to_guest(conversation["guest_id"], {"type": "presence", "role": "agent", "online": online,
+                                                       "conversation_id": conversation["conversation_id"]})
+
+async def agent_joined(conversation: dict, agent_id: str):
+    """Tells the other agents the conversation is taken and lets the guest know someone is there."""
+    conversation_id = conversation["conversation_id"]
+    await broadcast_to_agents({"type": "handoff_claimed", "conversation_id": conversation_id, "agent_id": agent_id})
+    message = conversation_message("system", translate("handoff_agent_joined", conversation.get("language", "en")))
+    await append_message(conversation_id, message)
+    await push_to_guest(conversation["guest_id"], conversation_event(conversation_id, message))
+
+async def send_agent_message(conversation: dict, agent_id: str, text: str) -> dict:
+    message = conversation_message("agent", text, agent_id)
+    await append_message(conversation["conversation_id"], message)
+    await push_to_guest(conversation["guest_id"], conversation_event(conversation["conversation_id"], message))
+    return message
+
+async def handle_agent_frame(agent_id: str, frame: dict):
+    conversation_id = frame.get("conversation_id")
+    if frame.get("type") == "join":
+        try:
+            conversation = await claim_conversation(conversation_id, agent_id)
+        except ConversationError as e:
+            await push_to_agent(agent_id, {"type": "error", "conversation_id": conversation_id, "detail": str(e)})
+            return
+        await agent_joined(conversation, agent_id)
+        return
+    async with DatabaseConnection.get_connection() as conn:
+        conversation = await conn.virtualbutler.agent_conversations.find_one(
+            {"conversation_id": conversation_id, "agent_id": agent_id, "status": ConversationStatusEnum.ACTIVE}
+        )
+    if not conversation:
+        await push_to_agent(agent_id, {"type": "error", "conversation_id": conversation_id,
+                                       "detail": "Conversation is not assigned to you"})
+        return
+    if frame.get("type") in ("delivered", "read"):
+        await relay_receipt(conversation, "agent", frame)
+    elif frame.get("type") == "typing":
+        await push_to_guest(conversation["guest_id"], {"type": "typing", "role": "agent",
+                                                       "conversation_id": conversation_id,
+                                                       "typing": bool(frame.get("typing", True))})
+    elif frame.get("type") == "message":
+        text = str(frame.get("text") or "").strip()[:1000]
+        if text:
+            await send_agent_message(conversation, agent_id, text)
+
+@app.websocket("/api/v1/agent/ws")
+async def agent_websocket(websocket: WebSocket, token: str):
+    user = await decode_ws_token(token)
+    if not user or user.get("role") not in ("staff", "admin"):
+        await websocket.close(code=4403)
+        return
+    agent_id = user["sub"]
+    await websocket.accept()
+    agent_connections.setdefault(agent_id, set()).add(websocket)
+    logger.info("agent_websocket_connected", agent_id=agent_id)
+    async with DatabaseConnection.get_connection() as conn:
+        cursor = conn.virtualbutler.agent_conversations.find(
+            {"status": ConversationStatusEnum.WAITING}
+        ).sort("created_at", 1)
+        waiting = [queue_entry(doc) async for doc in cursor]
+    await websocket.send_json({"type": "queue", "waiting": waiting})
+    await announce_agent_presence(agent_id, True)
+    try:
+        while True:
+            frame = await receive_frame(websocket)
+            if frame.get("type") in ("join", "message", "typing", "delivered", "read"):
+                await handle_agent_frame(agent_id, frame)
+    except WebSocketDisconnect:
+        pass
+    finally:
+        agent_connections.get(agent_id, set()).discard(websocket)
+        logger.info("agent_websocket_disconnected", agent_id=agent_id)
+        if not agent_connections.get(agent_id):
+            agent_connections.pop(agent_id, None)
+            await announce_agent_presence(agent_id, False)
+
+# --- Agent Console ---
+class AgentReply(BaseModel):
+    text: str = Field(..., min_length=1, max_length=1000)
+
+class ConversationTransfer(BaseModel):
+    department: DepartmentEnum
+    note: Optional[str] = Field(None, max_length=500)
+
+class ConversationClose(BaseModel):
+    disposition: DispositionEnum
+    note: Optional[str] = Field(None, max_length=500)
+
+def console_departments(user: dict) -> Optional[List[str]]:
+    """Departments whose queues the caller sees; None means all (admin)."""
+    return None if user.get("role") == "admin" else sorted(staff_departments(user))
+
+async def get_console_conversation(conversation_id: str, user: dict) -> dict:
+    conversation = await get_conversation(conversation_id)
+    departments = console_departments(user)
+    visible = conversation and (
+        departments is None
+        or conversation.get("agent_id") == user["sub"]
+        or conversation.get("department") in departments
+    )
+    if not visible:
+        raise HTTPException(status_code=404, detail="Conversation not found")
+    return conversation
+
+def serialize_conversation(doc: dict) -> dict:
+    doc = {k: v for k, v in doc.items() if k != "_id"}
+    doc["guest_online"] = bool(guest_connections.get(doc["guest_id"]))
+    if "messages" in doc:
+        doc["unread"] = unread_count(doc, "agent")
+    return doc
+
+@app.get("/api/v1/agent/conversations", tags=["Agent Console"])
+async def list_agent_conversations(status: ConversationStatusEnum = ConversationStatusEnum.WAITING,
+                                   mine: bool = False, user=Depends(require_staff)):
+    docs = await list_conversations(status, departments=None if mine else console_departments(user),
+                                    agent_id=user["sub"] if mine else None)
+    return [serialize_conversation(doc) for doc in docs]
+
+@app.get("/api/v1/agent/me", tags=["Agent Console"])
+async def get_agent_status(user=Depends(require_staff)):
+    return {
+        "agent_id": user["sub"],
+        "active_conversations": await active_conversation_count(user["sub"]),
+        "max_conversations": AGENT_MAX_CONVERSATIONS,
+        "online": bool(agent_connections.get(user["sub"]))
+    }
+
+@app.get("/api/v1/agent/conversations/{conversation_id}", tags=["Agent Console"])
+async def get_agent_conversation(conversation_id: str, user=Depends(require_staff)):
+    return serialize_conversation(await get_console_conversation(conversation_id, user))
+
+@app.post("/api/v1/agent/conversations/{conversation_id}/claim", tags=["Agent Console"])
+async def claim_agent_conversation(conversation_id: str, user=Depends(require_staff)):
+    await get_console_conversation(conversation_id, user)
+    try:
+        conversation = await claim_conversation(conversation_id, user["sub"])
+    except ConversationError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await agent_joined(conversation, user["sub"])
+    await audit_log("handoff_claimed", {"conversation_id": conversation_id, "agent_id": user["sub"]})
+    return serialize_conversation(conversation)
+
+@app.post("/api/v1/agent/conversations/{conversation_id}/release", tags=["Agent Console"])
+async def release_agent_conversation(conversation_id: str, user=Depends(require_staff)):
+    try:
+        conversation = await release_conversation(conversation_id, user["sub"])
+    except ConversationError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await broadcast_to_agents({"type": "handoff_queued", **queue_entry(conversation)})
+    await audit_log("handoff_released", {"conversation_id": conversation_id, "agent_id": user["sub"]})
+    return serialize_conversation(conversation)
+
+@app.post("/api/v1/agent/conversations/{conversation_id}/messages", status_code=201, tags=["Agent Console"])
+async def reply_as_agent(conversation_id: str, reply: AgentReply, user=Depends(require_staff)):
+    conversation = await get_console_conversation(conversation_id, user)
+    if conversation.get("agent_id") != user["sub"] or conversation.get("status") != ConversationStatusEnum.ACTIVE:
+        raise HTTPException(status_code=409, detail="Conversation is not assigned to you")
+    message = await send_agent_message(conversation, user["sub"], reply.text.strip())
+    return {**message, "timestamp": message["timestamp"].isoformat()}
+
+@app.post("/api/v1/agent/conversations/{conversation_id}/transfer", tags=["Agent Console"])
+async def transfer_agent_conversation(conversation_id: str, transfer: ConversationTransfer,
+                                      user=Depends(require_staff)):
+    try:
+        conversation = await transfer_conversation(conversation_id, user["sub"], transfer.department, transfer.note)
+    except ConversationError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await broadcast_to_agents({"type": "handoff_queued", **queue_entry(conversation)})
+    await audit_log("handoff_transferred", {"conversation_id": conversation_id, "agent_id": user["sub"],
+                                            "department": transfer.department})
+    return serialize_conversation(conversation)
+
+@app.post("/api/v1/agent/conversations/{conversation_id}/close", tags=["Agent Console"])
+async def close_agent_conversation(conversation_id: str, close: ConversationClose, user=Depends(require_staff)):
+    try:
+        conversation = await close_conversation(conversation_id, user["sub"], close.disposition, close.note,
+                                                force=user.get("role") == "admin")
+    except ConversationError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await push_to_guest(conversation["guest_id"], {"type": "conversation_closed", "conversation_id": conversation_id})
+    await broadcast_to_agents({"type": "handoff_closed", "conversation_id": conversation_id})
+    await audit_log("handoff_closed", {"conversation_id": conversation_id, "agent_id": user["sub"],
+                                       "disposition": close.disposition})
+    return serialize_conversation(conversation)
+
+# --- Proactive Messages from Work-Order Events ---
+PROACTIVE_STATUS_MESSAGES = {
+    StatusEnum.IN_PROGRESS: "work_order_in_progress",
+    StatusEnum.COMPLETED: "work_order_completed",
+}
+
+def verify_internal_token(x_internal_token: Optional[str] = Header(None)):
+    if not INTERNAL_EVENTS_TOKEN or x_internal_token != INTERNAL_EVENTS_TOKEN:
+        raise HTTPException(status_code=401, detail="Invalid internal token")
+
+@app.post("/internal/events/work-order", status_code=202, tags=["Internal"])
+async def handle_work_order_event(event: WorkOrderStatusEvent, _=Depends(verify_internal_token)):
+    translation_key = PROACTIVE_STATUS_MESSAGES.get(event.status)
+    if event.event == "workflow_step" and event.workflow:
+        # Valet/luggage orders tell the guest about every step, not just start and finish
+        translation_key = f"workflow_{event.workflow}_{event.workflow_step}"
+    elif event.workflow:
+        translation_key = None
+    if not translation_key:
+        return {"delivered": False, "reason": "no_message_for_status"}
+    async with DatabaseConnection.get_connection() as conn:
+        chat_doc = await conn.virtualbutler.chat_requests.find_one({"request_id": event.request_id})
+    language = (chat_doc or {}).get("language", "en")
+    department = str(event.department).replace("_", " ")
+    text = translate(translation_key, language, department=department)
+    delivered = await deliver_bot_message(event.guest_id, text, request_id=event.request_id, status=event.status,
+                                          order_number=event.order_number)
+    return {"delivered": delivered}
+
+async def deliver_bot_message(guest_id: str, text: str, **fields) -> bool:
+    bot_message = {"role": "bot", "message": text, **fields, "timestamp": datetime.now(timezone.utc).isoformat()}
+    # Keep the proactive message in the guest's conversation so it survives reconnects
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_contexts.update_one(
+            {"guest_id": guest_id},
+            {"$push": {"history": bot_message}, "$set": {"updated_at": datetime.now(timezone.utc)}}
+        )
+    delivered = await push_to_guest(guest_id, {"type": "bot_message", **bot_message})
+    logger.info("proactive_message_sent", guest_id=guest_id, request_id=fields.get("request_id"),
+                status=fields.get("status"), delivered=delivered)
+    return delivered
+
+# --- Transport (taxi / shuttle) ---
+class TransportRequestCreate(BaseModel):
+    mode: TransportModeEnum = TransportModeEnum.TAXI
+    pickup_at: Optional[datetime] = None   # now when omitted
+    destination: Optional[str] = None
+    passengers: int = Field(1, ge=1, le=20)
+    pickup_location: Optional[str] = None
+
+class TransportUpdate(BaseModel):
+    transport_id: Optional[str] = None
+    provider_ref: Optional[str] = None    # providers call back with their own ride id
+    status: Optional[TransportStatusEnum] = None
+    eta_minutes: Optional[int] = Field(None, ge=0)
+    driver: Optional[Dict[str, Any]] = None
+
+def transport_reply(doc: dict, language: str) -> str:
+    eta = doc.get("eta_at")
+    eta_text = format_local(eta) if eta else ""
+    driver = doc.get("driver") or {}
+    key = f"transport_{doc['status']}"
+    if doc["status"] in (TransportStatusEnum.CONFIRMED, TransportStatusEnum.DRIVER_ASSIGNED) and eta:
+        key += "_eta"
+    return translate(key, language, mode=doc["mode"], destination=doc.get("destination") or "",
+                     eta=eta_text, driver=driver.get("name") or "", vehicle=driver.get("vehicle") or "",
+                     plate=driver.get("plate") or "")
+
+async def notify_transport_update(doc: dict):
+    await deliver_bot_message(doc["guest_id"], transport_reply(doc, doc.get("language", "en")),
+                              request_id=doc.get("request_id"), transport_id=doc["transport_id"],
+                              status=doc["status"])
+
+async def handle_transport_chat(guest_id: str, room_number: Optional[str], details: dict, msg_text: str,
+                                session_id: str, language: str) -> ChatRequest:
+    """
+    Books through the ride connector when one is configured; otherwise (or if the provider refuses)
+    the request goes to the concierge as a work order and staff post the driver details later.
+    """
+    now = datetime.now(timezone.utc)
+    pickup_at = parse_requested_time(msg_text, now, hotel_timezone(), explicit=True) or now
+    request_id = f"req_{now.timestamp()}"
+    transport = await create_transport_request(guest_id, details["mode"], pickup_at, details["destination"],
+                                               details["passengers"], room_number=room_number,
+                                               language=language, request_id=request_id)
+    dispatched = await dispatch(transport)
+    if dispatched:
+        reply, status = transport_reply(dispatched, language), StatusEnum.COMPLETED
+    else:
+        reply = translate("transport_arranging", language, mode=details["mode"], time=format_local(transport.pickup_at))
+        status = StatusEnum.PENDING
+    chat_request = ChatRequest(
+        request_id=request_id,
+        guest_id=guest_id,
+        message=msg_text,
+        department=DepartmentEnum.CONCIERGE,
+        status=status,
+        tags=["transport", details["mode"]],
+        language=language,
+        created_at=now,
+        updated_at=now,
+        # Not scheduled_for: the concierge has to book the car ahead of the pickup time, not at it
+        metadata={"session_id": session_id, "room_number": room_number, "reply": reply,
+                  "transport_id": transport.transport_id, "work_order_created": not dispatched}
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    if not dispatched:
+        await publish_to_service_bus(ChatRequestMessage.from_chat_request(chat_request))
+    await audit_log("transport_requested_via_chat", {"guest_id": guest_id, "transport_id": transport.transport_id,
+                                                     "provider": (dispatched or {}).get("provider", "concierge")})
+    return chat_request
+
+@app.post("/api/v1/transport", response_model=TransportRequest, status_code=201, tags=["Transport"],
+          dependencies=[Depends(drain.ensure_accepting)])
+async def request_transport(data: TransportRequestCreate, user=Depends(verify_jwt)):
+    guest_id = resolve_guest_id(user, None)
+    # Set up front so the work-order consumer can link the concierge's order back to the ride
+    request_id = f"req_{datetime.now(timezone.utc).timestamp()}"
+    try:
+        transport = await create_transport_request(guest_id, data.mode.value, data.pickup_at or datetime.now(timezone.utc),
+                                                   data.destination, data.passengers, room_number=user.get("room"),
+                                                   pickup_location=data.pickup_location, request_id=request_id)
+    except TransportError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    dispatched = await dispatch(transport)
+    if dispatched:
+        return TransportRequest(**dispatched)
+    chat_request = ChatRequest(
+        request_id=request_id,
+        guest_id=guest_id,
+        message=f"{data.mode.value.title()} to {data.destination or 'destination to confirm'} "
+                f"for {data.passengers} at {format_local(transport.pickup_at)}",
+        department=DepartmentEnum.CONCIERGE,
+        status=StatusEnum.PENDING,
+        tags=["transport", data.mode.value],
+        created_at=datetime.now(timezone.utc),
+        updated_at=datetime.now(timezone.utc),
+        metadata={"room_number": user.get("room"), "transport_id": transport.transport_id}
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    await publish_to_service_bus(ChatRequestMessage.from_chat_request(chat_request))
+    return transport
+
+@app.get("/api/v1/transport", response_model=List[TransportRequest], tags=["Transport"])
+async def get_my_transport_requests(user=Depends(verify_jwt)):
+    return await list_transport_requests(guest_id=resolve_guest_id(user, None))
+
+@app.get("/api/v1/transport/{transport_id}", response_model=TransportRequest, tags=["Transport"])
+async def get_transport(transport_id: str, user=Depends(verify_jwt)):
+    guest_id = None if user.get("role") in ("staff", "admin") else resolve_guest_id(user, None)
+    try:
+        return TransportRequest(**await get_transport_request(transport_id, guest_id))
+    except TransportError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.delete("/api/v1/transport/{transport_id}", response_model=TransportRequest, tags=["Transport"])
+async def cancel_transport(transport_id: str, user=Depends(verify_jwt)):
+    guest_id = None if user.get("role") in ("staff", "admin") else resolve_guest_id(user, None)
+    try:
+        doc = await cancel_transport_request(transport_id, guest_id, by=user.get("sub"))
+    except TransportError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    return TransportRequest(**doc)
+
+@app.post("/api/v1/transport/{transport_id}/status", response_model=TransportRequest, tags=["Transport"])
+async def update_transport(transport_id: str, update: TransportUpdate, user=Depends(require_staff)):
+    """Concierge updates for rides they arranged themselves (driver details, ETA, arrival)."""
+    try:
+        doc = await apply_update(transport_id, status=update.status.value if update.status else None,
+                                 eta_minutes=update.eta_minutes, driver=update.driver, by=user.get("sub"))
+    except TransportError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    await notify_transport_update(doc)
+    return TransportRequest(**doc)
+
+# --- Lost & Found ---
+class LostItemReportCreate(BaseModel):
+    description: str = Field(..., min_length=2, max_length=500)
+    lost_location: Optional[str] = None
+    lost_at: Optional[datetime] = None
+
+class FoundItemCreate(BaseModel):
+    description: str = Field(..., min_length=2, max_length=500)
+    found_location: str
+    found_at: Optional[datetime] = None
+    category: Optional[str] = None
+    storage_location: Optional[str] = None
+
+class LostItemMatch(BaseModel):
+    item_id: str
+
+class LostItemReturn(BaseModel):
+    method: ReturnMethodEnum
+    shipping_address: Optional[str] = None
+    carrier: Optional[str] = None           # staff fill these in once the parcel is sent
+    tracking_number: Optional[str] = None
+
+class LostItemClose(BaseModel):
+    reason: Optional[str] = None
+
+async def notify_lost_item_update(report: dict, key: str, **values):
+    await deliver_bot_message(report["guest_id"], translate(key, report.get("language", "en"), **values),
+                              report_id=report["report_id"], status=report["status"])
+
+async def handle_lost_item_chat(guest_id: str, room_number: Optional[str], item: str, where: Optional[str],
+                                msg_text: str, session_id: str, language: str) -> ChatRequest:
+    report = await create_report(guest_id, item, room_number=room_number, lost_location=where, language=language)
+    chat_request = ChatRequest(
+        request_id=f"req_{datetime.now(timezone.utc).timestamp()}",
+        guest_id=guest_id,
+        message=msg_text,
+        department=DepartmentEnum.FRONT_DESK,
+        status=StatusEnum.COMPLETED,
+        tags=["lost_and_found"] + ([report.category] if report.category else []),
+        language=language,
+        created_at=datetime.now(timezone.utc),
+        updated_at=datetime.now(timezone.utc),
+        metadata={"session_id": session_id, "room_number": room_number, "report_id": report.report_id,
+                  "reply": translate("lost_item_reported", language, item=item)}
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    await audit_log("lost_item_reported_via_chat", {"guest_id": guest_id, "report_id": report.report_id})
+    return chat_request
+
+def lost_found_guest_filter(user: dict) -> Optional[str]:
+    return None if user.get("role") in ("staff", "admin") else resolve_guest_id(user, None)
+
+@app.post("/api/v1/lost-found/reports", response_model=LostItemReport, status_code=201, tags=["Lost & Found"])
+async def report_lost_item(data: LostItemReportCreate, user=Depends(verify_jwt)):
+    return await create_report(resolve_guest_id(user, None), data.description, room_number=user.get("room"),
+                               lost_location=data.lost_location, lost_at=data.lost_at)
+
+@app.get("/api/v1/lost-found/reports", response_model=List[LostItemReport], tags=["Lost & Found"])
+async def get_lost_item_reports(status: Optional[str] = None, user=Depends(verify_jwt)):
+    """Guests see their own reports; staff see all of them."""
+    return await list_reports(guest_id=lost_found_guest_filter(user), status=status)
+
+@app.get("/api/v1/lost-found/reports/{report_id}", response_model=LostItemReport, tags=["Lost & Found"])
+async def get_lost_item_report(report_id: str, user=Depends(verify_jwt)):
+    try:
+        return LostItemReport(**await get_report(report_id, lost_found_guest_filter(user)))
+    except LostFoundError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.get("/api/v1/lost-found/reports/{report_id}/matches", tags=["Lost & Found"])
+async def get_lost_item_matches(report_id: str, user=Depends(require_staff)):
+    """Found items that look like the reported one, best first, with signed photo URLs."""
+    try:
+        matches = await suggest_matches(report_id)
+    except LostFoundError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    for match in matches:
+        match["item"]["photo_urls"] = [generate_signed_url(p, ATTACHMENT_URL_TTL_MINUTES)
+                                       for p in match["item"].get("photos", [])]
+    return matches
+
+@app.post("/api/v1/lost-found/reports/{report_id}/match", response_model=LostItemReport, tags=["Lost & Found"])
+async def match_lost_item(report_id: str, data: LostItemMatch, user=Depends(require_staff)):
+    try:
+        report = await confirm_match(report_id, data.item_id, user.get("sub"))
+    except LostFoundError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    await notify_lost_item_update(report, "lost_item_found", item=report["description"])
+    return LostItemReport(**report)
+
+@app.post("/api/v1/lost-found/reports/{report_id}/return", response_model=LostItemReport, tags=["Lost & Found"])
+async def arrange_lost_item_return(report_id: str, data: LostItemReturn, user=Depends(verify_jwt)):
+    """Guests choose pickup or give a shipping address; staff add the carrier and tracking number."""
+    is_staff = user.get("role") in ("staff", "admin")
+    try:
+        await get_report(report_id, None if is_staff else resolve_guest_id(user, None))
+        report = await arrange_return(report_id, data.method, user.get("sub"), data.shipping_address,
+                                      data.carrier if is_staff else None, data.tracking_number if is_staff else None)
+    except LostFoundError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    if is_staff and report.get("tracking_number"):
+        await notify_lost_item_update(report, "lost_item_shipped", carrier=report.get("carrier") or "",
+                                      tracking_number=report["tracking_number"])
+    elif is_staff and data.method == ReturnMethodEnum.PICKUP:
+        await notify_lost_item_update(report, "lost_item_ready_for_pickup", item=report["description"])
+    return LostItemReport(**report)
+
+@app.post("/api/v1/lost-found/reports/{report_id}/returned", response_model=LostItemReport, tags=["Lost & Found"])
+async def mark_lost_item_returned(report_id: str, user=Depends(require_staff)):
+    try:
+        return LostItemReport(**await mark_returned(report_id, user.get("sub")))
+    except LostFoundError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.post("/api/v1/lost-found/reports/{report_id}/close", response_model=LostItemReport, tags=["Lost & Found"])
+async def close_lost_item_report(report_id: str, data: LostItemClose, user=Depends(verify_jwt)):
+    """Staff close reports that can't be resolved; guests can withdraw their own."""
+    try:
+        await get_report(report_id, lost_found_guest_filter(user))
+        report = await close_report(report_id, user.get("sub"), data.reason)
+    except LostFoundError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    if user.get("role") in ("staff", "admin"):
+        await notify_lost_item_update(report, "lost_item_closed", item=report["description"])
+    return LostItemReport(**report)
+
+@app.post("/api/v1/lost-found/items", response_model=FoundItem, status_code=201, tags=["Lost & Found"])
+async def log_lost_found_item(data: FoundItemCreate, user=Depends(require_staff)):
+    item = await log_found_item(data.description, data.found_location, data.found_at, data.category,
+                                data.storage_location, logged_by=user.get("sub"))
+    await audit_log("found_item_logged", {"item_id": item.item_id, "staff": user.get("sub")})
+    return item
+
+@app.get("/api/v1/lost-found/items", response_model=List[FoundItem], tags=["Lost & Found"])
+async def get_found_items(status: Optional[str] = None, category: Optional[str] = None, user=Depends(require_staff)):
+    return await list_found_items(status, category)
+
+@app.post("/api/v1/lost-found/items/{item_id}/photos", response_model=FoundItem, tags=["Lost & Found"])
+async def upload_found_item_photo(item_id: str, file: UploadFile = File(...), user=Depends(require_staff)):
+    extension = ALLOWED_ATTACHMENT_TYPES.get(file.content_type)
+    if not extension:
+        raise HTTPException(status_code=415, detail=f"Unsupported file type '{file.content_type}'")
+    data = await file.read(MAX_ATTACHMENT_BYTES + 1)
+    if len(data) > MAX_ATTACHMENT_BYTES:
+        raise HTTPException(status_code=413, detail="Photo exceeds maximum allowed size")
+    if not data:
+        raise HTTPException(status_code=400, detail="Photo is empty")
+    blob_name = f"lost-found/{item_id}/{uuid.uuid4().hex}{extension}"
+    try:
+        await upload_blob(blob_name, data, file.content_type)
+        return FoundItem(**await add_item_photo(item_id, blob_name))
+    except BlobStorageError:
+        raise HTTPException(status_code=502, detail="Failed to store photo")
+    except LostFoundError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.post("/internal/transport-updates", status_code=202, tags=["Internal"])
+async def handle_transport_update(update: TransportUpdate, _=Depends(verify_internal_token)):
+    """Callback for the ride connector: confirmation, driver assignment and ETA changes."""
+    if not update.transport_id and not update.provider_ref:
+        raise HTTPException(status_code=422, detail="transport_id or provider_ref is required")
+    try:
+        doc = await apply_update(update.transport_id, update.provider_ref,
+                                 status=update.status.value if update.status else None,
+                                 eta_minutes=update.eta_minutes, driver=update.driver, by="provider")
+    except TransportError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    await notify_transport_update(doc)
+    return {"transport_id": doc["transport_id"], "status": doc["status"]}
+
+# --- Quick Actions ---
+class QuickActionRequest(BaseModel):
+    guest_id: Optional[str] = None  # must match the token subject unless the caller is an admin
+    note: Optional[str] = Field(None, max_length=200, description="Passed to staff as-is, never classified")
+    language: str = "en"
+    room_number: Optional[str] = Field(None, description="Required when the guest has more than one room")
+    location: Optional[GuestLocation] = Field(None, description="Dropped unless `consent` is set")
+
+@app.get("/api/v1/chat/quick-actions", tags=["Chat"])
+async def get_quick_actions(language: str = "en", user=Depends(verify_jwt)):
+    return [guest_view(action, language) for action in await list_quick_actions()]
+
+@app.post("/api/v1/chat/quick-actions/{action_id}", response_model=ChatResponse, status_code=201, tags=["Chat"],
+          dependencies=[Depends(drain.ensure_accepting)])
+async def create_quick_action_request(action_id: str, data: QuickActionRequest, request: Request,
+                                      user=Depends(verify_jwt)):
+    """Creates the action's pre-structured request; department and priority come from the action, not the text."""
+    guest_id = resolve_guest_id(user, data.guest_id)
+    rate_limit(guest_id)
+    try:
+        action = await get_quick_action(action_id)
+    except QuickActionError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    session_id = request.headers.get("X-Session-Id", str(uuid.uuid4()))
+    msg_text = f"{action.description} | Note: {data.note}" if data.note else action.description
+    restriction = await get_restriction(guest_id)
+    if restriction and restriction["level"] == RestrictionLevelEnum.BLOCKED.value:
+        return chat_response(await handle_blocked_chat(guest_id, restriction, msg_text, session_id, data.language),
+                             ChatEntities())
+    await enforce_open_order_quota(guest_id, user, data.language)
+
+    async with DatabaseConnection.get_connection() as conn:
+        guest_doc = await conn.virtualbutler.guest_profiles.find_one({"guest_id": guest_id})
+    guest_profile = GuestProfile(**guest_doc) if guest_doc else None
+    room_number = room_for_request(guest_profile, data.room_number, user)
+    reply = acknowledgement(action.department, data.language,
+                            guest_name=guest_profile.name if guest_profile else None,
+                            room_number=room_number)
+    now = datetime.now(timezone.utc)
+    calendar = await business_calendars.get(action.department.value)
+    if not is_open(calendar, now):
+        opens_at = next_open(calendar, now)
+        if opens_at:
+            local = to_local(calendar, opens_at)
+            reply = f"{reply} " + translate("department_closed", data.language,
+                                            department=action.department.value.replace("_", " "),
+                                            time=local.strftime("%H:%M"), day=local.strftime("%d/%m"))
+    chat_request = ChatRequest(
+        request_id=f"req_{now.timestamp()}",
+        guest_id=guest_id,
+        guest_profile=guest_profile,
+        message=msg_text,
+        department=action.department,
+        status=StatusEnum.PENDING,
+        tags=work_order_tags(action) + ([FLAGGED_TAG] if restriction else []),
+        language=data.language,
+        created_at=now,
+        updated_at=now,
+        metadata={
+            "session_id": session_id,
+            "room_number": room_number,
+            "guest_name": guest_profile.name if guest_profile else None,
+            "reply": reply,
+            "priority": action.priority.value,
+            "quick_action": action.action_id,
+            "location": shared_location(data.location),
+            "intent": {"name": action.department.value, "confidence": 1.0, "source": "quick_action"},
+            "work_order_created": True
+        }
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    await publish_to_service_bus(ChatRequestMessage.from_chat_request(chat_request))
+    metrics.increment("butler_quick_actions_total", action=action.action_id)
+    logger.info("quick_action_requested", request_id=chat_request.request_id, guest_id=guest_id, action_id=action_id)
+    return chat_response(chat_request, extract_entities(data.note or "", now, hotel_timezone(),
+                                                        runtime_config.settings.item_synonyms))
+
+@app.get("/api/v1/admin/quick-actions", response_model=List[QuickAction], tags=["Admin"])
+async def get_admin_quick_actions(user=Depends(require_admin)):
+    return await list_quick_actions(include_disabled=True)
+
+@app.put("/api/v1/admin/quick-actions/{action_id}", response_model=QuickAction, tags=["Admin"])
+async def put_quick_action(action_id: str, data: QuickActionUpdate, user=Depends(require_admin)):
+    try:
+        action = QuickAction(action_id=action_id, updated_by=user.get("sub"), **data.model_dump())
+    except ValueError as e:
+        raise HTTPException(status_code=422, detail=str(e))
+    saved = await save_quick_action(action)
+    await audit_log("quick_action_saved", {"action_id": action_id, "admin": user.get("sub")})
+    return saved
+
+@app.delete("/api/v1/admin/quick-actions/{action_id}", status_code=204, tags=["Admin"])
+async def remove_quick_action(action_id: str, user=Depends(require_admin)):
+    try:
+        await delete_quick_action(action_id)
+    except QuickActionError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await audit_log("quick_action_deleted", {"action_id": action_id, "admin": user.get("sub")})
+
+# --- Feature Flags ---
+@app.get("/api/v1/feature-flags", tags=["Chat"])
+async def get_my_feature_flags(user=Depends(verify_jwt)):
+    """Every flag as it applies to the caller, so the app can hide what isn't rolled out to them."""
+    return feature_flags.snapshot(user["sub"])
+
+@app.get("/api/v1/admin/feature-flags", response_model=List[FeatureFlag], tags=["Admin"])
+async def get_feature_flags(user=Depends(require_admin)):
+    return await list_flags()
+
+@app.put("/api/v1/admin/feature-flags/{key}", response_model=FeatureFlag, tags=["Admin"])
+async def put_feature_flag(key: str, data: FeatureFlagUpdate, user=Depends(require_admin)):
+    try:
+        flag = await save_flag(FeatureFlag(key=key, updated_by=user.get("sub"), **data.model_dump()))
+    except ValueError as e:
+        raise HTTPException(status_code=422, detail=str(e))
+    except FeatureFlagError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await audit_log("feature_flag_saved", {"key": key, "admin": user.get("sub"), **data.model_dump(mode="json")})
+    return flag
+
+@app.delete("/api/v1/admin/feature-flags/{key}", status_code=204, tags=["Admin"])
+async def remove_feature_flag(key: str, user=Depends(require_admin)):
+    try:
+        await delete_flag(key)
+    except FeatureFlagError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await audit_log("feature_flag_deleted", {"key": key, "admin": user.get("sub")})
+
+# --- Response Persona ---
+@app.get("/api/v1/admin/persona", response_model=Optional[ResponsePersona], tags=["Admin"])
+async def get_response_persona(user=Depends(require_admin)):
+    """The hotel's greeting style, sign-offs and emoji policy; null means replies go out as written."""
+    return await get_persona()
+
+@app.put("/api/v1/admin/persona", response_model=ResponsePersona, tags=["Admin"])
+async def put_response_persona(data: PersonaUpdate, user=Depends(require_admin)):
+    persona = await save_persona(ResponsePersona(updated_by=user.get("sub"), **data.model_dump()))
+    await audit_log("response_persona_saved", {"admin": user.get("sub"), **data.model_dump(mode="json")})
+    return persona
+
+@app.delete("/api/v1/admin/persona", status_code=204, tags=["Admin"])
+async def remove_response_persona(user=Depends(require_admin)):
+    try:
+        await delete_persona()
+    except PersonaError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await audit_log("response_persona_deleted", {"admin": user.get("sub")})
+
+# --- Promotions ---
+async def make_offer(trigger: str, guest_id: str, profile: Optional[dict], language: str,
+                     request_id: Optional[str] = None, reservation_id: Optional[str] = None) -> Optional[dict]:
+    """The upsell to make after an order or booking, if any; a failed offer never fails what the guest asked for."""
+    if not feature_flags.is_enabled("promotions", guest_id):
+        return None
+    try:
+        return await promotions.offer(trigger, guest_id, profile, language, request_id or reservation_id)
+    except Exception as e:
+        logger.error("promotion_offer_failed", trigger=trigger, guest_id=guest_id, error=str(e))
+        return None
+
+@app.post("/api/v1/offers/{offer_id}/accept", tags=["Chat"], dependencies=[Depends(drain.ensure_accepting)])
+async def accept_offer(offer_id: str, request: Request, user=Depends(verify_jwt)):
+    """Accepts an upsell; the promotion's department gets a request to fulfil it."""
+    guest_id = user["sub"]
+    try:
+        offer = await respond_to_offer(offer_id, guest_id, accepted=True)
+        promotion = await get_promotion(offer["promotion_id"])
+    except PromotionError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    profile = await guest_profile(guest_id)
+    fulfilment = fulfilment_request(promotion)
+    now = datetime.now(timezone.utc)
+    chat_request = ChatRequest(
+        request_id=f"req_{now.timestamp()}",
+        guest_id=guest_id,
+        guest_profile=GuestProfile(**profile) if profile else None,
+        message=fulfilment["description"],
+        department=fulfilment["department"],
+        status=StatusEnum.PENDING,
+        tags=fulfilment["tags"],
+        language=offer.get("language", "en"),
+        created_at=now,
+        updated_at=now,
+        metadata={
+            "session_id": request.headers.get("X-Session-Id", str(uuid.uuid4())),
+            "room_number": (profile or {}).get("room_number") or user.get("room"),
+            "guest_name": (profile or {}).get("name"),
+            "priority": fulfilment["priority"].value,
+            "offer_id": offer_id,
+            "related_request_id": offer.get("request_id"),
+            "intent": {"name": promotion.department.value, "confidence": 1.0, "source": PROMOTION_TAG},
+            "work_order_created": True
+        }
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.chat_requests.insert_one(chat_request.dict(by_alias=True))
+    drain.track(publish_to_service_bus(ChatRequestMessage.from_chat_request(chat_request)))
+    metrics.increment("butler_promotions_accepted_total", promotion=promotion.promotion_id)
+    return {"offer_id": offer_id, "status": "accepted", "request_id": chat_request.request_id}
+
+@app.post("/api/v1/offers/{offer_id}/decline", tags=["Chat"])
+async def decline_offer(offer_id: str, user=Depends(verify_jwt)):
+    try:
+        await respond_to_offer(offer_id, user["sub"], accepted=False)
+    except PromotionError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    return {"offer_id": offer_id, "status": "declined"}
+
+@app.get("/api/v1/admin/promotions", response_model=List[Promotion], tags=["Admin"])
+async def get_promotions(user=Depends(require_admin)):
+    return await list_promotions(include_inactive=True)
+
+@app.put("/api/v1/admin/promotions/{promotion_id}", response_model=Promotion, tags=["Admin"])
+async def put_promotion(promotion_id: str, data: PromotionUpdate, user=Depends(require_admin)):
+    try:
+        promotion = await save_promotion(Promotion(promotion_id=promotion_id, updated_by=user.get("sub"),
+                                                   **data.model_dump()))
+    except ValueError as e:
+        raise HTTPException(status_code=422, detail=str(e))
+    except PromotionError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await audit_log("promotion_saved", {"promotion_id": promotion_id, "admin": user.get("sub")})
+    return promotion
+
+@app.delete("/api/v1/admin/promotions/{promotion_id}", status_code=204, tags=["Admin"])
+async def remove_promotion(promotion_id: str, user=Depends(require_admin)):
+    try:
+        await delete_promotion(promotion_id)
+    except PromotionError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await audit_log("promotion_deleted", {"promotion_id": promotion_id, "admin": user.get("sub")})
+
+@app.get("/api/v1/admin/promotions/report", tags=["Admin"])
+async def get_promotion_report(start: Optional[datetime] = None, end: Optional[datetime] = None,
+                               hotel_id: Optional[str] = None, user=Depends(require_admin)):
+    """Offers, acceptances and conversion per promotion; defaults to the last 30 days."""
+    end = end or datetime.now(timezone.utc)
+    return await offer_report(start or end - timedelta(days=30), end, hotel_id)
+
+# --- Inquiries and Knowledge Base ---
+class InquiryRequest(BaseModel):
+    text: str = Field(..., min_length=1, max_length=500)
+    language: str = "en"
+
+@app.post("/api/v1/inquiries", tags=["Inquiries"])
+async def post_inquiry(data: InquiryRequest, request: Request):
+    """
+    Questions from people who aren't signed in (rates, amenities). No JWT; answered from the public
+    knowledge base articles only, and never creates a request or work order.
+    """
+    rate_limit(f"inquiry:{request.client.host if request.client else 'unknown'}",
+               runtime_config.settings.rate_limits.inquiries_per_minute)
+    found = knowledge_base.answer(data.text, data.language, public_only=True)
+    metrics.increment("butler_inquiries_total", answered=str(bool(found)).lower())
+    logger.info("inquiry_answered" if found else "inquiry_unanswered",
+                article_id=found[0].article_id if found else None, language=data.language)
+    if not found:
+        return {"answered": False, "answer": translate("inquiry_no_answer", data.language), "article_id": None}
+    return {"answered": True, "answer": found[1], "article_id": found[0].article_id}
+
+@app.get("/api/v1/admin/knowledge", response_model=List[KnowledgeArticle], tags=["Admin"])
+async def get_knowledge_articles(user=Depends(require_admin)):
+    return await list_articles(include_disabled=True)
+
+@app.put("/api/v1/admin/knowledge/{article_id}", response_model=KnowledgeArticle, tags=["Admin"])
+async def put_knowledge_article(article_id: str, data: KnowledgeArticleUpdate, user=Depends(require_admin)):
+    try:
+        article = await save_article(KnowledgeArticle(article_id=article_id, updated_by=user.get("sub"),
+                                                      **data.model_dump()))
+    except ValueError as e:
+        raise HTTPException(status_code=422, detail=str(e))
+    await audit_log("knowledge_article_saved", {"article_id": article_id, "admin": user.get("sub")})
+    return article
+
+@app.delete("/api/v1/admin/knowledge/{article_id}", status_code=204, tags=["Admin"])
+async def remove_knowledge_article(article_id: str, user=Depends(require_admin)):
+    try:
+        await delete_article(article_id)
+    except KnowledgeError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await audit_log("knowledge_article_deleted", {"article_id": article_id, "admin": user.get("sub")})
+
+# --- App Bootstrap ---
+@app.get("/api/v1/bootstrap", tags=["Chat"])
+async def get_bootstrap(request: Request, language: Optional[str] = None, user=Depends(verify_jwt)):
+    """
+    Everything the guest app shows on launch: profile, open requests, unread notifications, quick
+    actions, hotel info and feature flags. Carries an ETag, so a relaunch with nothing new gets 304.
+    """
+    guest_id = user["sub"]
+    profile, requests, notifications, actions = await asyncio.gather(
+        guest_profile(guest_id), open_requests(guest_id), unread_notifications(guest_id), list_quick_actions()
+    )
+    language = language or ((profile or {}).get("preferences") or {}).get("language") or "en"
+    return conditional_response(request, {
+        "guest": profile or {"guest_id": guest_id},
+        "language": language,
+        "open_requests": requests,
+        "notifications": notifications,
+        "quick_actions": [guest_view(action, language) for action in actions],
+        "hotel": hotel_info(str(hotel_timezone()), message_catalog.languages(), utc_offset()),
+        "feature_flags": feature_flags.snapshot(guest_id),
+    })
+
+# --- Multi-turn Chat: Store and retrieve context ---
+@app.post("/api/v1/chat", response_model=ChatResponse, status_code=201, tags=["Chat"],
+          dependencies=[Depends(drain.ensure_accepting)])
+async def create_chat_request(message: ChatMessage, request: Request, user=Depends(verify_jwt),
+                              idempotency_key: Optional[str] = Header(None, alias="Idempotency-Key")):
+    """A retry carrying the same Idempotency-Key gets the original response back (see shared/idempotency.py)."""
+    scope = user["sub"]
+    if idempotency_key:
+        try:
+            idempotency_key = idempotency.check_key(idempotency_key)
+            replay = await idempotency.begin(scope, idempotency_key, idempotency.fingerprint(message.model_dump(mode="json")))
+        except IdempotencyError as e:
+            raise ApiError(e.status_code, str(e), headers=e.headers)
+        if replay:
+            return JSONResponse(replay["response"], status_code=replay["status_code"], headers={REPLAYED_HEADER: "true"})
+    try:
+        chat_request = await handle_chat_message(message, request, user)
+    except BaseException:
+        # Including cancellation by the request timeout: the retry should run for real
+        if idempotency_key:
+            await idempotency.release(scope, idempotency_key)
+        raise
+    entities = extract_entities(message.text or message.voice_transcript or "", datetime.now(timezone.utc), hotel_timezone(),
+                                runtime_config.settings.item_synonyms)
+    result = chat_response(chat_request, entities)
+    if idempotency_key:
+        await idempotency.complete(scope, idempotency_key, 201, jsonable_encoder(result))
+    return result
+
+class ChatEdit(BaseModel):
+    text: str = Field(..., min_length=1, max_length=1000)
+
+@app.patch("/api/v1/chat/{request_id}", response_model=ChatRequest, tags=["Chat"])
+async def edit_chat_request(request_id: str, edit: ChatEdit, user=Depends(verify_jwt)):
+    """Rewords a request shortly after sending, before staff pick it up (see shared/chat_edits.py)."""
+    text = edit.text.strip()
+    normalized = normalize_message(text, runtime_config.settings.shorthand)
+    line_items = extract_line_items(normalized, runtime_config.settings.item_synonyms)
+    # Routed again, as a new message would be; a different team's job is refused
+    department = classify_intent(normalized.lower(), routing_key=user["sub"])
+    try:
+        doc = await edit_request(request_id, user["sub"], text, [line_item.model_dump() for line_item in line_items],
+                                 department=department and DepartmentEnum(department).value)
+    except ChatEditError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await audit_log("chat_request_edited", {"guest_id": user["sub"], "request_id": request_id})
+    return ChatRequest(**doc)
+
+@app.post("/api/v1/chat/{request_id}/recall", response_model=ChatRequest, tags=["Chat"])
+async def recall_chat_request(request_id: str, user=Depends(verify_jwt)):
+    """Withdraws a request shortly after sending, cancelling its work order if it has one yet."""
+    try:
+        doc, work_order = await recall_request(request_id, user["sub"])
+    except ChatEditError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    if work_order:
+        await domain_events.publish(StatusChanged.from_work_order(work_order, StatusEnum.PENDING, user["sub"]))
+    await audit_log("chat_request_recalled", {"guest_id": user["sub"], "request_id": request_id})
+    return ChatRequest(**doc)
+
+async def handle_chat_message(message: ChatMessage, request: Request, user: dict) -> ChatRequest:
+    guest_id = resolve_guest_id(user, message.guest_id)
+    emergency = classify_emergency(message.text or message.voice_transcript or "")
+    if not emergency:
+        # A guest reporting a fire is never told to slow down
+        rate_limit(guest_id)
+    # A blocked guest reporting an emergency is still heard
+    restriction = None if emergency else await get_restriction(guest_id)
+    if restriction and restriction["level"] == RestrictionLevelEnum.BLOCKED.value:
+        msg_text = message.text or message.voice_transcript or ""
+        if not msg_text.strip():
+            raise HTTPException(status_code=400, detail="Message text required.")
+        return await handle_blocked_chat(guest_id, restriction, msg_text,
+                                         request.headers.get("X-Session-Id", str(uuid.uuid4())),
+                                         message.metadata.get("language", "en"))
+    if not is_direct_action(message.text or message.voice_transcript or ""):
+        await enforce_open_order_quota(guest_id, user, message.metadata.get("language", "en"))
     try:
         async with DatabaseConnection.get_connection() as conn:
             if conn is None or not hasattr(conn, "virtualbutler"):
@@ -622,22 +3017,165 @@ async def create_chat_request(
             # Retrieve last context for this guest (if any)
             last_context = await conn.virtualbutler.chat_contexts.find_one({"guest_id": guest_id})
 
-        session_id = request.headers.get("X-Session-Id", str(uuid.uuid4()))
+        # A kiosk or tablet files everything under its own session, which resets between guests
+        session_id = user.get("session_id") or request.headers.get("X-Session-Id", str(uuid.uuid4()))
         msg_text = message.text or message.voice_transcript or ""
         if not msg_text.strip():
             raise HTTPException(status_code=400, detail="Message text required.")
 
+        rooms = guest_rooms(guest_profile)
+        if len(rooms) > 1 and not emergency:
+            held = await take_held_message(guest_id, message.quick_reply or msg_text, rooms)
+            if held:
+                # The answer to "which room?": carry on with the message the guest first sent
+                message = ChatMessage(**held[0])
+                message.metadata["room_number"] = held[1]
+                msg_text = message.text or message.voice_transcript or ""
+        if not emergency:
+            held = await take_time_choice(guest_id, message.quick_reply or msg_text, hotel_timezone())
+            if held:
+                # The answer to "which time?": carry on with the held message, now for that time
+                message = ChatMessage(**held[0])
+                message.metadata["scheduled_for"] = held[1].isoformat()
+                msg_text = message.text or message.voice_transcript or ""
+        # What the classifiers read: emoji and shorthand spelled out ("🧻 pls" -> "toilet paper please")
+        text = normalize_message(msg_text, runtime_config.settings.shorthand)
+        try:
+            room_number = resolve_room(rooms, msg_text, message.metadata.get("room_number"))
+        except RoomError:
+            # Not one of the guest's rooms: ask (or fall back to their only room) rather than trust it
+            room_number = resolve_room(rooms, msg_text)
+        # On an in-room tablet the room speaks for itself
+        room_number = room_number or device_room(user)
+        if emergency:
+            return await handle_emergency_chat(guest_id, room_number or (rooms[0] if rooms else None), emergency,
+                                               msg_text, session_id, message.metadata.get("language", "en"))
+        if room_number is None and len(rooms) > 1 and not await get_open_conversation(guest_id):
+            return await ask_which_room(guest_id, message, rooms, msg_text, session_id,
+                                        message.metadata.get("language", "en"))
+        dnd_command = detect_dnd_command(text)
+        if dnd_command is not None and room_number:
+            return await handle_dnd_chat(guest_id, room_number, dnd_command, msg_text, session_id)
+
+        language = message.metadata.get("language", "en")
+        access_code = ACCESS_CODE_PATTERN.match(msg_text)
+        challenge = await get_pending_challenge(guest_id) if access_code else None
+        if challenge:
+            return await handle_access_code(guest_id, challenge, access_code.group(1), session_id, language)
+        access_purpose = detect_access_request(text)
+        if access_purpose and room_number:
+            return await handle_access_chat(guest_id, room_number, access_purpose, msg_text, session_id, language)
+        wake_up_command = detect_wake_up_command(text)
+        if wake_up_command and room_number:
+            return await handle_wake_up_chat(guest_id, room_number, wake_up_command, msg_text, session_id, language)
+        device_command = parse_device_command(text)
+        if device_command and room_number and await actuate(room_number, device_command):
+            return await handle_device_chat(guest_id, room_number, device_command, msg_text, session_id, language)
+        held = await awaiting_booking_confirmation(guest_id) if CONFIRM_PATTERN.match(text.lower()) else None
+        if held:
+            return await handle_booking_confirmation(guest_id, held, msg_text, session_id, language)
+        booking = detect_booking(text)
+        if booking:
+            booked = await handle_booking_chat(guest_id, room_number, *booking, msg_text, session_id, language)
+            if booked:
+                return booked
+        transport = detect_transport(text)
+        if transport:
+            return await handle_transport_chat(guest_id, room_number, transport, msg_text, session_id, language)
+        lost_item = detect_lost_item(text)
+        if lost_item:
+            return await handle_lost_item_chat(guest_id, room_number, *lost_item, msg_text, session_id, language)
+        recommendation_category = detect_recommendation(text)
+        if recommendation_category:
+            return await handle_recommendation_chat(guest_id, room_number, recommendation_category, msg_text,
+                                                    session_id, language, message.metadata.get("latitude"),
+                                                    message.metadata.get("longitude"))
+        sentiment = await score_sentiment(msg_text, language)
+        await track_sentiment(guest_id, sentiment, msg_text, room_number, session_id)
+        conversation = await get_open_conversation(guest_id)
+        if conversation:
+            return await handle_agent_mode_chat(guest_id, conversation, msg_text, session_id, language, queued=False)
+        if wants_human(text):
+            conversation = await start_handoff(guest_id, "guest_request", msg_text, session_id, room_number, language)
+            return await handle_agent_mode_chat(guest_id, conversation, msg_text, session_id, language, queued=True)
+
         # Use Azure CLU for intent classification
-        department = await classify_intent_clu(msg_text, conversation_id=session_id, user_id=guest_id)
+        match = await classify_intent_clu(text, conversation_id=session_id, user_id=guest_id)
+        department = match.department
+        intent = {"confidence": match.confidence, "source": match.source}
+        if device_command:
+            # The room couldn't carry it out remotely, so someone has to go and adjust it
+            department = DepartmentEnum.MAINTENANCE
+            intent = {"confidence": 1.0, "source": "direct"}
+        workflow = detect_workflow(text)
+        if workflow:
+            department = DepartmentEnum.CONCIERGE
+            intent = {"confidence": 1.0, "source": "direct"}
+        if department and not (device_command or workflow) and match.needs_clarification():
+            return await ask_to_clarify(guest_id, match.suggestion, msg_text, session_id, language)
         if not department:
-            department = DepartmentEnum.FRONT_DESK
+            # The bot can't tell what the guest needs; hand over to the front desk rather than guess
+            conversation = await start_handoff(guest_id, "unclassified", msg_text, session_id, room_number, language)
+            return await handle_agent_mode_chat(guest_id, conversation, msg_text, session_id, language, queued=True)
+        entities = extract_entities(text, datetime.now(timezone.utc), hotel_timezone(),
+                                    runtime_config.settings.item_synonyms)
+        if match.prediction:
+            merge_clu_entities(entities, match.prediction)
+        settled_time = requested_time(message.metadata)
+        if settled_time:
+            entities.time = settled_time
+        intent.update(name=DepartmentEnum(department).value, entities=entities.model_dump())
+
+        reply = acknowledgement(
+            department, language,
+            guest_name=guest_profile.name if guest_profile else None,
+            room_number=room_number
+        )
+        now = datetime.now(timezone.utc)
+        calendar = await business_calendars.get(DepartmentEnum(department).value)
+        resolution = TimeResolution(settled_time) if settled_time else resolve_time(text, now, hotel_timezone())
+        if resolution and resolution.alternatives:
+            # "at 7" with both 07:00 and 19:00 still ahead: ask rather than guess
+            return await ask_which_time(guest_id, message, [resolution.time, *resolution.alternatives], msg_text,
+                                        session_id, language)
+        scheduled_for = resolution.time if resolution else None
+        if scheduled_for:
+            # A time outside the team's hours moves to when they next open
+            scheduled_for = next_open(calendar, scheduled_for) or scheduled_for
+            local = to_local(calendar, scheduled_for)
+            reply = translate("request_scheduled", language, time=local.strftime("%H:%M"),
+                              day=local.strftime("%d/%m"))
+            scheduled_for = scheduled_for.astimezone(timezone.utc)
+        tags = [message.quick_reply] if message.quick_reply else []
+        if restriction:
+            tags.append(FLAGGED_TAG)
+        closed = not scheduled_for and not is_open(calendar, now)
+        load = None if scheduled_for or workflow or closed else await capacity.load_for(DepartmentEnum(department).value)
+        opens_at = next_open(calendar, now) if closed else None
+        if opens_at:
+            local = to_local(calendar, opens_at)
+            reply = f"{reply} " + translate("department_closed", language,
+                                            department=DepartmentEnum(department).value.replace("_", " "),
+                                            time=local.strftime("%H:%M"), day=local.strftime("%d/%m"))
+        elif should_defer(load, sentiment, vip=bool(guest_profile and guest_profile.vip_status)):
+            reply = translate("request_deferred", language, department=load["department"].replace("_", " "),
+                              minutes=load["estimated_wait_minutes"])
+            tags.append(DEFERRED_TAG)
+            # Held back like a scheduled request, so the backlog clears first; its priority is left alone
+            scheduled_for = now + timedelta(minutes=load["estimated_wait_minutes"])
+            metrics.increment("butler_requests_deferred_total", department=load["department"])
+        elif load and load["level"] != LoadLevelEnum.NORMAL:
+            # Set expectations rather than promise the usual turnaround
+            reply = f"{reply} " + translate("high_demand_wait", language, department=load["department"].replace("_", " "),
+                                            minutes=load["estimated_wait_minutes"])
 
         # Build/extend context
         context_history = last_context["history"] if last_context and "history" in last_context else []
         context_history.append({
             "message": msg_text,
             "timestamp": datetime.now(timezone.utc).isoformat(),
-            "department": str(department)
+            "department": str(department),
+            "sentiment": sentiment
         })
         context_obj = {
             "guest_id": guest_id,
@@ -656,17 +3194,25 @@ async def create_chat_request(
             voice_transcript=message.voice_transcript,
             department=department,
             status=StatusEnum.PENDING,
-            tags=[message.quick_reply] if message.quick_reply else [],
+            tags=tags,
+            language=language,
             created_at=datetime.now(timezone.utc),
             updated_at=datetime.now(timezone.utc),
             metadata={
                 "session_id": session_id,
                 "images": message.images or [],
-                "room_number": guest_profile.room_number if guest_profile else None,
+                "room_number": room_number,
                 "guest_name": guest_profile.name if guest_profile else None,
-                "context": context_obj
+                "context": context_obj,
+                "reply": reply,
+                "scheduled_for": scheduled_for,
+                "workflow": workflow,
+                "intent": intent,
+                "line_items": [line_item.model_dump() for line_item in entities.line_items],
+                "location": shared_location(message.location),
+                "work_order_created": True
             },
-            sentiment=None
+            sentiment=sentiment
         )
 
         async with DatabaseConnection.get_connection() as conn:
@@ -680,7 +3226,11 @@ async def create_chat_request(
                 {"$set": context_obj},
                 upsert=True
             )
-            await publish_to_service_bus(chat_request.dict())
+            await publish_to_service_bus(ChatRequestMessage.from_chat_request(chat_request))
+            if (SHADOW_CLASSIFIER and intent["source"] != "direct"
+                    and in_rollout(chat_request.request_id, SHADOW_CLASSIFIER_SAMPLE_PERCENT)):
+                drain.track(shadow_classify(chat_request.request_id, msg_text, match, session_id, guest_id))
+            await link_attachments(message.images or [], guest_id, chat_request.request_id)
             await audit_log("chat_created", chat_request.dict())
             logger.info("chat_created", request_id=chat_request.request_id, guest_id=guest_id)
             return chat_request
@@ -1,5 +1,4 @@
 import asyncio
-import uvicorn
 from typing import List, Dict, Any
 import multiprocessing
 import structlog
@@ -14,6 +13,7 @@ sys.path.append(str(backend_dir))
 
 from shared.db.database import DatabaseConnection
 from shared.logger import setup_logger
+from shared.server import serve
 
 logger = setup_logger("virtual_butler")
 
@@ -46,17 +46,10 @@ class ServiceManager:
         self.shutdown_event = asyncio.Event()
 
     def run_service(self, service: Dict[str, Any]) -> None:
-        """Run a single service using uvicorn"""
+        """Run a single service (HTTP/2 and keep-alive settings live in shared.server)"""
         try:
             logger.info(f"Starting {service['name']} service on port {service['port']}")
-            uvicorn.run(
-                service["module"],
-                host=service["host"],
-                port=service["port"],
-                reload=True,
-                reload_dirs=["backend"],
-                log_level="info"
-            )
+            serve(service["name"], service["module"], service["host"], service["port"])
         except Exception as e:
             logger.error(f"Error in {service['name']} service: {str(e)}")
 
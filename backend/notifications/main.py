@@ -1,15 +1,39 @@
-from fastapi import FastAPI, HTTPException, Depends, status, Request, Body
+from fastapi import FastAPI, HTTPException, Depends, status, Request, Body, Header
 from fastapi.middleware.cors import CORSMiddleware
+from fastapi.responses import PlainTextResponse
 from fastapi.security import HTTPBearer, HTTPAuthorizationCredentials
-from pydantic import BaseModel, Field
+from pydantic import BaseModel, Field, validator
 from typing import List, Optional, Dict, Any
-from datetime import datetime, timedelta
+from datetime import datetime, timedelta, timezone
+from zoneinfo import ZoneInfo, ZoneInfoNotFoundError
 import structlog
 import os
 import asyncio
 from shared.db.database import DatabaseConnection
-from shared.db.models import Notification, NotificationTypeEnum, PriorityEnum
+from shared.db.models import (Notification, NotificationTypeEnum, PriorityEnum, NotificationPreferences,
+                              ReportRecipient)
+from shared.reporting import department_summaries, format_department_digest
+from shared.clock import format_local, resolve_timezone
+from email.message import EmailMessage
+import smtplib
 from jose import jwt, JWTError
+from shared import fault_injection
+from shared.errors import install_error_handlers
+from shared import metrics
+from shared.security.keys import KeyRing
+from shared.security.oidc import OidcVerifier
+from shared.security.api_keys import ApiKeyRing, Authenticator, ensure_api_key_indexes
+from shared.security.field_crypto import field_cipher
+from shared.leases import Lease, ensure_lease_indexes, release_held_leases
+from shared.feature_flags import feature_flags
+from shared.sandbox import install as install_sandbox, suppress_staff_alert
+from shared.guest_blocks import end_at_checkout, expire_due_restrictions
+from shared.messages import catalog as message_catalog
+from shared.events import EventPublisher, FeedbackReceived
+from shared.surveys import (SurveyError, SurveyResponse, create_survey, mark_delivery, stays_ended, survey_link,
+                            get_survey_by_token, record_response, nps_report, ensure_survey_indexes)
+from shared.middleware import RequestIdMiddleware, TimeoutMiddleware, RecoveryMiddleware, CompressionMiddleware
+from shared.conditional import conditional_response
 
 logger = structlog.get_logger()
 app = FastAPI(
@@ -24,17 +48,40 @@ app.add_middleware(
     allow_credentials=True,
     allow_methods=["*"],
     allow_headers=["*"],
+    expose_headers=["X-Request-ID", "ETag", "Last-Modified"],
 )
+app.add_middleware(CompressionMiddleware)
+app.add_middleware(TimeoutMiddleware)
+app.add_middleware(RecoveryMiddleware, service="notifications")
+app.add_middleware(RequestIdMiddleware)
 
 security = HTTPBearer()
 JWT_SECRET = os.getenv("JWT_SECRET", "supersecret")
 JWT_ALGORITHM = os.getenv("JWT_ALGORITHM", "HS256")
 NOTIFICATION_TTL_DAYS = int(os.getenv("NOTIFICATION_TTL_DAYS", "30"))
+DIGEST_INTERVAL_SECONDS = int(os.getenv("DIGEST_INTERVAL_SECONDS", "300"))
+SMTP_HOST = os.getenv("SMTP_HOST")
+# SMS has no sender yet, and email needs SMTP; a guest can't opt in to a channel that never delivers
+SUPPORTED_CHANNELS = {"app", "push"} | ({"email"} if SMTP_HOST else set())
+SMTP_PORT = int(os.getenv("SMTP_PORT", "587"))
+SMTP_USER = os.getenv("SMTP_USER")
+SMTP_PASSWORD = os.getenv("SMTP_PASSWORD")
+SMTP_FROM = os.getenv("SMTP_FROM", "butler@example.com")
+REPORT_CHECK_INTERVAL_SECONDS = int(os.getenv("REPORT_CHECK_INTERVAL_SECONDS", "300"))
+INTERNAL_EVENTS_TOKEN = os.getenv("INTERNAL_EVENTS_TOKEN")
+INCIDENT_ALERT_EMAILS = [e.strip() for e in os.getenv("INCIDENT_ALERT_EMAILS", "").split(",") if e.strip()]
+SURVEY_CHECK_INTERVAL_SECONDS = int(os.getenv("SURVEY_CHECK_INTERVAL_SECONDS", "900"))
 
 
-def verify_jwt(credentials: HTTPAuthorizationCredentials = Depends(security)):
+oidc = OidcVerifier()
+key_ring = KeyRing(legacy_secret=JWT_SECRET, legacy_algorithm=JWT_ALGORITHM, federated=oidc)
+api_keys = ApiKeyRing()
+auth = Authenticator(key_ring, api_keys)
+domain_events = EventPublisher("notifications")
+
+async def verify_jwt(credentials: HTTPAuthorizationCredentials = Depends(security)):
     try:
-        payload = jwt.decode(credentials.credentials, JWT_SECRET, algorithms=[JWT_ALGORITHM])
+        payload = await key_ring.verify(credentials.credentials)
         return payload
     except JWTError as e:
         logger.error("jwt_verification_failed", error=str(e))
@@ -43,6 +90,11 @@ def verify_jwt(credentials: HTTPAuthorizationCredentials = Depends(security)):
             detail="Invalid or expired token",
         )
 
+def require_admin(payload=Depends(verify_jwt)):
+    if payload.get("role") != "admin":
+        raise HTTPException(status_code=403, detail="Admin access required")
+    return payload
+
 async def ensure_ttl_index():
     async with DatabaseConnection.get_connection() as conn:
         if conn is None:
@@ -71,36 +123,488 @@ async def push_mobile_notification(notification: dict, guest_id: str):
     # TODO: Integrate with APNS/FCM
     logger.info("mobile_notification_pushed", guest_id=guest_id, notification_id=notification.get("notification_id"))
 
-# --- Notification Formatting & Localization (Stub) ---
+def _send_smtp(message: EmailMessage):
+    with smtplib.SMTP(SMTP_HOST, SMTP_PORT, timeout=10) as smtp:
+        smtp.starttls()
+        if SMTP_USER:
+            smtp.login(SMTP_USER, SMTP_PASSWORD)
+        smtp.send_message(message)
+
+async def send_email(to: str, subject: str, body: str, attachments: Optional[List[tuple]] = None) -> bool:
+    """`attachments` are (filename, text, MIME subtype) tuples, e.g. ("booking.ics", ics, "calendar")."""
+    if not SMTP_HOST:
+        logger.warning("email_not_configured", to=to, subject=subject)
+        return False
+    message = EmailMessage()
+    message["From"] = SMTP_FROM
+    message["To"] = to
+    message["Subject"] = subject
+    message.set_content(body)
+    for filename, content, subtype in attachments or []:
+        message.add_attachment(content, subtype=subtype, filename=filename)
+    try:
+        await asyncio.to_thread(_send_smtp, message)
+    except Exception as e:
+        logger.error("email_send_failed", to=to, error=str(e))
+        return False
+    logger.info("email_sent", to=to, subject=subject)
+    return True
+
+async def push_email_notification(notification: dict, guest_id: str):
+    async with DatabaseConnection.get_connection() as conn:
+        guest = await conn.virtualbutler.guest_profiles.find_one({"guest_id": guest_id}, {"email": 1})
+    if not guest or not guest.get("email"):
+        logger.warning("email_notification_skipped", guest_id=guest_id, reason="no_email_on_profile")
+        return
+    await send_email(guest["email"], "Virtual Butler update", notification.get("message", ""))
+
+# --- Verification Codes ---
+VERIFICATION_MESSAGES = {
+    "en": ("Your Virtual Butler verification code", "Your verification code is {code}. It expires in {minutes} minutes. If you didn't ask for it, please contact the front desk."),
+    "fr": ("Votre code de vérification Virtual Butler", "Votre code de vérification est {code}. Il expire dans {minutes} minutes. Si vous ne l'avez pas demandé, contactez la réception."),
+    "es": ("Su código de verificación de Virtual Butler", "Su código de verificación es {code}. Caduca en {minutes} minutos. Si no lo solicitó, contacte con la recepción."),
+}
+
+class VerificationCodeRequest(BaseModel):
+    guest_id: str
+    code: str = Field(..., pattern=r"^\d{6}$")
+    expires_in_minutes: int = 10
+    language: str = "en"
+
+def verify_internal_token(x_internal_token: Optional[str] = Header(None)):
+    if not INTERNAL_EVENTS_TOKEN or x_internal_token != INTERNAL_EVENTS_TOKEN:
+        raise HTTPException(status_code=401, detail="Invalid internal token")
+
+@app.post("/internal/verification-codes", tags=["Internal"])
+async def send_verification_code(data: VerificationCodeRequest, _=Depends(verify_internal_token)):
+    """Sends a one-time code to the contact details on the guest's profile (never to an address from the request)."""
+    async with DatabaseConnection.get_connection() as conn:
+        guest = await conn.virtualbutler.guest_profiles.find_one({"guest_id": data.guest_id}, {"email": 1})
+    if not guest or not guest.get("email"):
+        return {"sent": False, "reason": "no_contact_on_profile"}
+    subject, body = VERIFICATION_MESSAGES.get(data.language, VERIFICATION_MESSAGES["en"])
+    sent = await send_email(guest["email"], subject, body.format(code=data.code, minutes=data.expires_in_minutes))
+    return {"sent": sent}
+
+# --- Reservation Confirmations ---
+RESERVATION_MESSAGES = {
+    "en": ("Your booking at {venue} is confirmed", "Your table at {venue} for {party_size} is confirmed for {time}. The invite is attached; reply in the chat if you need to change it."),
+    "fr": ("Votre réservation à {venue} est confirmée", "Votre réservation à {venue} pour {party_size} est confirmée pour {time}. L'invitation est jointe ; répondez dans le chat pour la modifier."),
+    "es": ("Su reserva en {venue} está confirmada", "Su reserva en {venue} para {party_size} está confirmada para las {time}. Adjuntamos la invitación; responda en el chat si necesita cambiarla."),
+}
+
+class ReservationConfirmation(BaseModel):
+    guest_id: str
+    reservation_id: str
+    venue_name: str
+    party_size: int
+    starts_at: datetime
+    language: str = "en"
+    calendar: str = Field(..., description="The booking as an iCalendar (.ics) invite")
+
+@app.post("/internal/reservation-confirmations", tags=["Internal"])
+async def send_reservation_confirmation(data: ReservationConfirmation, _=Depends(verify_internal_token)):
+    """Emails the confirmed booking, with its calendar invite, to the address on the guest's profile."""
+    async with DatabaseConnection.get_connection() as conn:
+        guest = await conn.virtualbutler.guest_profiles.find_one({"guest_id": data.guest_id}, {"email": 1})
+    if not guest or not guest.get("email"):
+        return {"sent": False, "reason": "no_contact_on_profile"}
+    subject, body = RESERVATION_MESSAGES.get(data.language, RESERVATION_MESSAGES["en"])
+    sent = await send_email(
+        guest["email"], subject.format(venue=data.venue_name),
+        body.format(venue=data.venue_name, party_size=data.party_size, time=format_local(data.starts_at, "%d/%m %H:%M")),
+        attachments=[(f"{data.reservation_id}.ics", data.calendar, "calendar")]
+    )
+    return {"sent": sent}
+
+class IncidentAlert(BaseModel):
+    incident_id: str
+    incident_type: str
+    status: str
+    message: str
+    room_number: Optional[str] = None
+    location: Optional[str] = None
+    alert_round: int = 1
+    reported_at: Optional[str] = None
+
+@app.post("/internal/incident-alerts", tags=["Internal"])
+async def send_incident_alert(alert: IncidentAlert, _=Depends(verify_internal_token)):
+    """Emails the emergency contact list; fails when no address accepted it so the caller records the channel as down."""
+    if not INCIDENT_ALERT_EMAILS:
+        raise HTTPException(status_code=503, detail="No incident alert recipients configured")
+    where = alert.room_number and f"Room {alert.room_number}" or alert.location or "Unknown location"
+    prefix = "REMINDER - " if alert.alert_round > 1 else ""
+    subject = f"{prefix}EMERGENCY: {alert.incident_type.upper()} - {where}"
+    body = (f"{alert.incident_type.upper()} incident {alert.incident_id} reported at {alert.reported_at or 'now'}.\n"
+            f"Location: {where}\nMessage: {alert.message}\n\n"
+            f"Acknowledge in the staff console; alerts repeat until someone does.")
+    sent = 0
+    for address in INCIDENT_ALERT_EMAILS:
+        sent += bool(await send_email(address, subject, body))
+    if not sent:
+        raise HTTPException(status_code=502, detail="Incident alert email could not be sent")
+    return {"sent": sent}
+
+# --- Notification Formatting & Localization ---
+NOTIFICATION_STATUSES = {"pending", "in_progress", "completed"}
 
 def format_notification_message(event: dict, lang: str = "en") -> str:
-    # TODO: Pull from i18n templates
     status = event.get("status", "update")
-    if status == "pending":
-        return "Your request has been received."
-    if status == "in_progress":
-        return "Your request is now in progress."
-    if status == "completed":
-        return "Your request has been completed."
-    return "You have a new update."
+    return message_catalog.translate(f"notification_{status if status in NOTIFICATION_STATUSES else 'update'}", lang)
+
+def format_digest_message(count: int, lang: str = "en") -> str:
+    return message_catalog.translate("notification_digest", lang, count=count)
+
+# --- Preferences & Quiet Hours ---
+
+class NotificationPreferencesUpdate(BaseModel):
+    channels: Optional[List[str]] = None
+    quiet_hours_start: Optional[str] = Field(None, pattern=r"^([01]\d|2[0-3]):[0-5]\d$")
+    quiet_hours_end: Optional[str] = Field(None, pattern=r"^([01]\d|2[0-3]):[0-5]\d$")
+    timezone: Optional[str] = None
+    language: Optional[str] = None
+    digest_enabled: Optional[bool] = None
+
+    @validator("timezone")
+    def validate_timezone(cls, v):
+        if v is None:
+            return v
+        try:
+            ZoneInfo(v)
+        except (ZoneInfoNotFoundError, ValueError):
+            # ValueError: not a key ZoneInfo will even look up, e.g. "../UTC" or ""
+            raise ValueError(f"Unknown timezone '{v}'")
+        return v
+
+async def get_preferences(guest_id: str) -> NotificationPreferences:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn.virtualbutler.notification_preferences.find_one({"guest_id": guest_id})
+    if not doc:
+        return NotificationPreferences(guest_id=guest_id)
+    doc.pop("_id", None)
+    return NotificationPreferences(**doc)
+
+def is_quiet_hours(prefs: NotificationPreferences, now: Optional[datetime] = None) -> bool:
+    if not (prefs.quiet_hours_start and prefs.quiet_hours_end):
+        return False
+    local = format_local(now or datetime.now(timezone.utc), tz=resolve_timezone(prefs.timezone))
+    start, end = prefs.quiet_hours_start, prefs.quiet_hours_end
+    if start <= end:
+        return start <= local < end
+    # Window spans midnight, e.g. 22:00-07:00
+    return local >= start or local < end
+
+async def deliver_notification(notification: dict, guest_id: str, prefs: NotificationPreferences):
+    if fault_injection.drop_notification("delivery"):
+        return
+    if "app" in prefs.channels:
+        await push_signalr_notification(notification, guest_id)
+    if "push" in prefs.channels:
+        await push_mobile_notification(notification, guest_id)
+    if "email" in prefs.channels:
+        await push_email_notification(notification, guest_id)
+
+def ensure_preferences_access(guest_id: str, user: dict):
+    if user.get("role") not in ("staff", "admin") and user.get("sub") != guest_id:
+        raise HTTPException(status_code=403, detail="Insufficient privileges")
+
+@app.get("/api/v1/guests/{guest_id}/preferences", response_model=NotificationPreferences)
+async def get_notification_preferences(guest_id: str, user=Depends(verify_jwt)):
+    ensure_preferences_access(guest_id, user)
+    return await get_preferences(guest_id)
+
+@app.put("/api/v1/guests/{guest_id}/preferences", response_model=NotificationPreferences)
+async def update_notification_preferences(
+    guest_id: str,
+    update: NotificationPreferencesUpdate,
+    user=Depends(verify_jwt)
+):
+    ensure_preferences_access(guest_id, user)
+    if update.channels is not None:
+        unknown = set(update.channels) - SUPPORTED_CHANNELS
+        if unknown:
+            raise HTTPException(status_code=400, detail=f"Unsupported channels: {', '.join(sorted(unknown))}")
+    prefs = await get_preferences(guest_id)
+    prefs = prefs.model_copy(update={**update.model_dump(exclude_unset=True), "updated_at": datetime.now(timezone.utc)})
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.notification_preferences.update_one(
+            {"guest_id": guest_id},
+            {"$set": prefs.model_dump()},
+            upsert=True
+        )
+    logger.info("notification_preferences_updated", guest_id=guest_id, updated_by=user.get("sub"))
+    return prefs
+
+async def send_quiet_hours_digests():
+    """Delivers one digest per guest for notifications suppressed during quiet hours, once quiet hours end."""
+    async with DatabaseConnection.get_connection() as conn:
+        guest_ids = await conn.virtualbutler.notifications.distinct("guest_id", {"metadata.digest_pending": True})
+        for guest_id in guest_ids:
+            prefs = await get_preferences(guest_id)
+            if is_quiet_hours(prefs):
+                continue
+            pending = await conn.virtualbutler.notifications.find(
+                {"guest_id": guest_id, "metadata.digest_pending": True}
+            ).to_list(length=None)
+            if not pending:
+                continue
+            digest = {
+                "type": "digest",
+                "guest_id": guest_id,
+                "message": format_digest_message(len(pending), prefs.language),
+                "notification_ids": [n.get("notification_id") for n in pending],
+                "created_at": datetime.now(timezone.utc).isoformat()
+            }
+            await deliver_notification(digest, guest_id, prefs)
+            await conn.virtualbutler.notifications.update_many(
+                {"_id": {"$in": [n["_id"] for n in pending]}},
+                {"$set": {"metadata.digest_pending": False, "metadata.digest_sent_at": datetime.now(timezone.utc)}}
+            )
+            logger.info("quiet_hours_digest_sent", guest_id=guest_id, count=len(pending))
+
+digest_lease = Lease("quiet_hours_digests", DIGEST_INTERVAL_SECONDS)
 
+async def digest_loop():
+    while True:
+        await asyncio.sleep(DIGEST_INTERVAL_SECONDS)
+        try:
+            await digest_lease.run(send_quiet_hours_digests)
+        except Exception as e:
+            logger.error("digest_delivery_failed", error=str(e))
+
+# --- Department Head Digests ---
+
+async def send_department_digest(recipient: ReportRecipient, now: Optional[datetime] = None) -> bool:
+    """Emails the recipient a summary of the previous local day for their departments."""
+    if suppress_staff_alert("department_digest", recipient_id=recipient.recipient_id):
+        # Counts as sent, so the digest isn't retried (and logged) on every check today
+        return True
+    tz = ZoneInfo(recipient.timezone)
+    local_now = (now or datetime.now(timezone.utc)).astimezone(tz)
+    day_start = datetime.combine(local_now.date() - timedelta(days=1), datetime.min.time(), tzinfo=tz)
+    start = day_start.astimezone(timezone.utc).replace(tzinfo=None)
+    end = (day_start + timedelta(days=1)).astimezone(timezone.utc).replace(tzinfo=None)
+    summaries = await department_summaries(
+        start, end, now=local_now.astimezone(timezone.utc).replace(tzinfo=None),
+        departments=[str(d) for d in recipient.departments] or None
+    )
+    subject, body = format_department_digest(summaries, day_start.date().isoformat())
+    return await send_email(recipient.email, subject, body)
+
+async def send_due_department_digests(now: Optional[datetime] = None):
+    now = now or datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn.virtualbutler.report_recipients.find({"active": True}).to_list(length=None)
+    for doc in docs:
+        recipient = ReportRecipient(**doc)
+        try:
+            local_now = now.astimezone(ZoneInfo(recipient.timezone))
+        except ZoneInfoNotFoundError:
+            logger.error("report_recipient_bad_timezone", recipient_id=recipient.recipient_id, timezone=recipient.timezone)
+            continue
+        today = local_now.date().isoformat()
+        if recipient.last_sent_on == today or local_now.strftime("%H:%M") < recipient.send_at:
+            continue
+        if await send_department_digest(recipient, now):
+            async with DatabaseConnection.get_connection() as conn:
+                await conn.virtualbutler.report_recipients.update_one(
+                    {"recipient_id": recipient.recipient_id}, {"$set": {"last_sent_on": today}}
+                )
+            logger.info("department_digest_sent", recipient_id=recipient.recipient_id, report_date=today)
+
+department_digest_lease = Lease("department_digests", REPORT_CHECK_INTERVAL_SECONDS)
+
+async def department_digest_loop():
+    while True:
+        try:
+            await department_digest_lease.run(send_due_department_digests)
+        except Exception as e:
+            logger.error("department_digest_failed", error=str(e))
+        await asyncio.sleep(REPORT_CHECK_INTERVAL_SECONDS)
+
+@app.get("/api/v1/admin/report-recipients", response_model=List[ReportRecipient])
+async def list_report_recipients(user=Depends(require_admin)):
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn.virtualbutler.report_recipients.find().sort("recipient_id", 1).to_list(length=None)
+    return [ReportRecipient(**doc) for doc in docs]
+
+@app.put("/api/v1/admin/report-recipients/{recipient_id}", response_model=ReportRecipient)
+async def put_report_recipient(recipient_id: str, recipient: ReportRecipient, user=Depends(require_admin)):
+    if recipient.recipient_id != recipient_id:
+        raise HTTPException(status_code=400, detail="recipient_id in the body must match the URL")
+    try:
+        ZoneInfo(recipient.timezone)
+    except ZoneInfoNotFoundError:
+        raise HTTPException(status_code=400, detail=f"Unknown timezone '{recipient.timezone}'")
+    recipient.updated_at = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.virtualbutler.report_recipients.update_one(
+            {"recipient_id": recipient_id},
+            {"$set": recipient.model_dump(exclude={"id", "created_at"}),
+             "$setOnInsert": {"created_at": datetime.now(timezone.utc)}},
+            upsert=True
+        )
+    logger.info("report_recipient_saved", recipient_id=recipient_id, admin=user.get("sub"))
+    return recipient
+
+@app.delete("/api/v1/admin/report-recipients/{recipient_id}", status_code=204)
+async def delete_report_recipient(recipient_id: str, user=Depends(require_admin)):
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn.virtualbutler.report_recipients.delete_one({"recipient_id": recipient_id})
+    if result.deleted_count == 0:
+        raise HTTPException(status_code=404, detail="Report recipient not found")
+
+@app.post("/api/v1/admin/report-recipients/{recipient_id}/send", status_code=202)
+async def send_report_now(recipient_id: str, user=Depends(require_admin)):
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn.virtualbutler.report_recipients.find_one({"recipient_id": recipient_id})
+    if not doc:
+        raise HTTPException(status_code=404, detail="Report recipient not found")
+    return {"sent": await send_department_digest(ReportRecipient(**doc))}
+
+# --- Checkout NPS Surveys ---
+SURVEY_MESSAGES = {
+    "en": ("How was your stay?", "Thank you for staying with us. On a scale of 0 to 10, how likely are you to recommend us to a friend? It takes a few seconds: {link}"),
+    "fr": ("Comment s'est passé votre séjour ?", "Merci de votre séjour. Sur une échelle de 0 à 10, quelle est la probabilité que vous nous recommandiez à un ami ? Quelques secondes suffisent : {link}"),
+    "es": ("¿Qué tal su estancia?", "Gracias por alojarse con nosotros. Del 0 al 10, ¿con qué probabilidad nos recomendaría a un amigo? Solo le llevará unos segundos: {link}"),
+}
+
+class CheckoutEvent(BaseModel):
+    guest_id: str
+    checked_out_at: Optional[datetime] = None
+
+async def deliver_survey(guest_id: str, token: str, prefs: NotificationPreferences) -> Optional[str]:
+    """Sends the survey on the first of the guest's channels that can reach them; returns that channel."""
+    subject, body = SURVEY_MESSAGES.get(prefs.language, SURVEY_MESSAGES["en"])
+    message = {"type": "nps_survey", "guest_id": guest_id, "message": body.format(link=survey_link(token)),
+               "created_at": datetime.now(timezone.utc).isoformat()}
+    for channel in prefs.channels:
+        if channel == "email":
+            async with DatabaseConnection.get_connection() as conn:
+                guest = await conn.virtualbutler.guest_profiles.find_one({"guest_id": guest_id}, {"email": 1})
+            if guest and guest.get("email") and await send_email(guest["email"], subject, message["message"]):
+                return channel
+        elif channel == "push":
+            await push_mobile_notification(message, guest_id)
+            return channel
+        elif channel == "app":
+            await push_signalr_notification(message, guest_id)
+            return channel
+    return None
+
+async def send_checkout_survey(guest: dict, check_out: datetime) -> Optional[dict]:
+    prefs = await get_preferences(guest["guest_id"])
+    created = await create_survey(guest, check_out, prefs.language)
+    if not created:
+        return None
+    survey, token = created
+    channel = await deliver_survey(guest["guest_id"], token, prefs)
+    await mark_delivery(survey.survey_id, channel)
+    logger.info("nps_survey_sent", survey_id=survey.survey_id, guest_id=guest["guest_id"], channel=channel)
+    return {"survey_id": survey.survey_id, "channel": channel}
+
+async def send_due_checkout_surveys():
+    for guest in await stays_ended(datetime.now(timezone.utc)):
+        await send_checkout_survey(guest, guest["check_out_date"])
+    # Flags and blocks end with the stay
+    await expire_due_restrictions()
+
+survey_lease = Lease("checkout_surveys", SURVEY_CHECK_INTERVAL_SECONDS)
+
+async def checkout_survey_loop():
+    """Catches stays that ended without a checkout event from the PMS."""
+    while True:
+        await asyncio.sleep(SURVEY_CHECK_INTERVAL_SECONDS)
+        try:
+            await survey_lease.run(send_due_checkout_surveys)
+        except Exception as e:
+            logger.error("checkout_surveys_failed", error=str(e))
+
+@app.post("/api/v1/stays/checkout", status_code=202, tags=["Surveys"])
+async def checkout(event: CheckoutEvent, user=Depends(auth.require("stays:write"))):
+    """Called by the PMS (or the front desk) when a guest checks out."""
+    checked_out_at = event.checked_out_at or datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        guest = await conn.virtualbutler.guest_profiles.find_one_and_update(
+            {"guest_id": event.guest_id}, {"$set": {"check_out_date": checked_out_at}},
+            projection={"_id": 0, "guest_id": 1, "room_number": 1, "check_in_date": 1}
+        )
+    if not guest:
+        raise HTTPException(status_code=404, detail="Guest not found")
+    await end_at_checkout(event.guest_id, checked_out_at)
+    sent = await send_checkout_survey(guest, checked_out_at)
+    return {"survey": sent, "already_surveyed": sent is None}
+
+@app.get("/api/v1/surveys/{token}", tags=["Surveys"])
+async def get_survey(token: str):
+    """The survey behind a link; the token is the guest's only credential, since they've checked out."""
+    try:
+        doc = await get_survey_by_token(token)
+    except SurveyError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    return {k: doc.get(k) for k in ("survey_id", "departments", "language", "status", "expires_at")}
+
+@app.post("/api/v1/surveys/{token}/response", tags=["Surveys"])
+async def answer_survey(token: str, response: SurveyResponse):
+    try:
+        doc = await record_response(token, response)
+    except SurveyError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
+    await domain_events.publish(FeedbackReceived(guest_id=doc["guest_id"], score=doc["score"], comment=doc.get("comment")))
+    for department, score in doc["department_scores"].items():
+        await domain_events.publish(FeedbackReceived(guest_id=doc["guest_id"], score=score, department=department))
+    return {"survey_id": doc["survey_id"], "status": doc["status"]}
+
+@app.get("/api/v1/admin/nps", tags=["Surveys"])
+async def get_nps(start: Optional[datetime] = None, end: Optional[datetime] = None, interval: str = "week",
+                  hotel_id: Optional[str] = None, user=Depends(require_admin)):
+    """NPS overall, per department and per day/week/month; defaults to the last 90 days."""
+    end = end or datetime.now(timezone.utc)
+    start = start or end - timedelta(days=90)
+    try:
+        return await nps_report(start, end, interval, **({"hotel_id": hotel_id} if hotel_id else {}))
+    except SurveyError as e:
+        raise HTTPException(status_code=e.status_code, detail=str(e))
 
 @app.on_event("startup")
 async def startup_db_client():
     await DatabaseConnection.connect()
+    install_sandbox()
+    await field_cipher.start()
     await ensure_ttl_index()
+    await key_ring.refresh()
+    asyncio.create_task(key_ring.refresh_loop())
+    await oidc.refresh()
+    asyncio.create_task(oidc.refresh_loop())
+    await ensure_api_key_indexes()
+    await ensure_lease_indexes()
+    await ensure_survey_indexes()
+    await api_keys.refresh()
+    asyncio.create_task(api_keys.refresh_loop())
     asyncio.create_task(subscribe_to_status_events())
+    asyncio.create_task(digest_loop())
+    asyncio.create_task(department_digest_loop())
+    asyncio.create_task(checkout_survey_loop())
+    asyncio.create_task(message_catalog.refresh_loop())
+    asyncio.create_task(feature_flags.refresh_loop())
 
 @app.on_event("shutdown")
 async def shutdown_db_client():
+    await release_held_leases()
     await DatabaseConnection.close()
 
 @app.post("/api/v1/notifications", response_model=Notification, status_code=201)
 async def create_notification(
     notification: Notification,
-    user=Depends(verify_jwt)
+    user=Depends(auth.require("notifications:write", roles=None))
 ):
     try:
+        prefs = await get_preferences(notification.guest_id)
+        # Non-urgent notifications wait for the end of quiet hours and go out as a digest
+        suppressed = notification.priority != PriorityEnum.URGENT and is_quiet_hours(prefs)
+        if suppressed:
+            notification.metadata["suppressed_quiet_hours"] = True
+            notification.metadata["digest_pending"] = prefs.digest_enabled
         async with DatabaseConnection.get_connection() as conn:
             if conn is None:
                 logger.error("database_connection_failed", error="Connection object is None in create_notification")
@@ -109,9 +613,10 @@ async def create_notification(
             notification_data = notification.model_dump()
             notification_data["id"] = str(result.inserted_id)
 
-        await push_signalr_notification(notification_data, notification.guest_id)
-        await push_mobile_notification(notification_data, notification.guest_id)
-        logger.info("notification_created", notification_id=notification.notification_id, guest_id=notification.guest_id)
+        if not suppressed:
+            await deliver_notification(notification_data, notification.guest_id, prefs)
+        logger.info("notification_created", notification_id=notification.notification_id,
+                    guest_id=notification.guest_id, suppressed=suppressed)
         return notification
     except Exception as e:
         logger.error("notification_creation_failed", error=str(e))
@@ -119,6 +624,7 @@ async def create_notification(
 
 @app.get("/api/v1/notifications/history", response_model=List[Notification])
 async def get_notification_history(
+    request: Request,
     user=Depends(verify_jwt)
 ):
     guest_id = user["sub"]
@@ -131,7 +637,7 @@ async def get_notification_history(
             cursor = conn.virtualbutler.notifications.find({"guest_id": guest_id})
             async for doc in cursor:
                 notifications.append(Notification(**doc))
-        return notifications
+        return conditional_response(request, notifications)
     except Exception as e:
         logger.error("get_notification_history_failed", error=str(e))
         raise HTTPException(status_code=500, detail="Failed to fetch notification history")
@@ -149,7 +655,7 @@ async def mark_notification_read(
                 raise HTTPException(status_code=500, detail="Database connection failed")
             result = await conn.virtualbutler.notifications.update_one(
                 {"notification_id": notification_id, "guest_id": guest_id},
-                {"$set": {"read": True, "read_at": datetime.utcnow()}}
+                {"$set": {"read": True, "read_at": datetime.now(timezone.utc)}}
             )
             if result.modified_count == 0:
                 raise HTTPException(status_code=404, detail="Notification not found or already read")
@@ -158,6 +664,10 @@ async def mark_notification_read(
         logger.error("mark_notification_read_failed", error=str(e))
         raise HTTPException(status_code=500, detail="Failed to mark notification as read")
 
+@app.get("/metrics", include_in_schema=False)
+async def metrics_endpoint():
+    return PlainTextResponse(metrics.render_prometheus(), media_type="text/plain; version=0.0.4")
+
 @app.get("/healthz")
 async def health_check():
     return await DatabaseConnection.health_check()
@@ -174,16 +684,8 @@ async def audit_log(event: str, data: dict):
         await conn.virtualbutler.notification_logs.insert_one({
             "event": event,
             "data": data,
-            "timestamp": datetime.utcnow()
+            "timestamp": datetime.now(timezone.utc)
         })
 
 
-from fastapi.responses import JSONResponse
-
-@app.exception_handler(Exception)
-async def global_exception_handler(request: Request, exc: Exception):
-    logger.error("unhandled_exception", error=str(exc))
-    return JSONResponse(
-        status_code=500,
-        content={"detail": "An unexpected error occurred. Please try again."}
-    )
\ No newline at end of file
+install_error_handlers(app)
@@ -38,7 +38,7 @@ async def run_plugin(payload: Dict[str, Any], user: Dict[str, Any]) -> Dict[str,
         "echo": payload,
         "processed_message": processed,
         "user": user_info,
-        "timestamp": datetime.datetime.utcnow().isoformat() + "Z"
+        "timestamp": datetime.datetime.now(datetime.timezone.utc).isoformat()
     }
 
     # Example: Add a warning if message is too long
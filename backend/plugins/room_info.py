@@ -31,7 +31,7 @@ async def run_plugin(payload: Dict[str, Any], user: Dict[str, Any]) -> Dict[str,
     response = {
         "user_info": user_info,
         "payload": payload,
-        "timestamp": datetime.datetime.utcnow().isoformat() + "Z",
+        "timestamp": datetime.datetime.now(datetime.timezone.utc).isoformat(),
         "summary": f"User {guest_id} ({role}) requested room info for room {room}."
     }
 
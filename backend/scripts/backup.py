@@ -0,0 +1,66 @@
+"""
+Exports butler data to a portable archive and restores it into this or another cluster (shared/backup.py).
+
+Run: python backend/scripts/backup.py export butler.tar.gz [--only work_orders --only config]
+     python backend/scripts/backup.py inspect butler.tar.gz
+     python backend/scripts/backup.py restore butler.tar.gz [--only guests] [--drop] [--dry-run]
+                                      [--id-prefix north_] [--map-hotel default=north]
+
+Talks to Mongo directly (MONGODB_URL) rather than through the admin APIs like butlerctl, since an
+archive can be far bigger than a request should carry. --only takes a group (work_orders, conversations,
+guests, staff, config) or a single collection and can be repeated. Run migrations on the target
+(`butlerctl migrate`) before restoring so its indexes are in place.
+"""
+import argparse
+import asyncio
+import json
+import tarfile
+
+from shared.backup import BACKUP_GROUPS, BackupError, export_archive, read_manifest, restore_archive
+from shared.db.database import DatabaseConnection
+from shared.security.field_crypto import field_cipher
+
+def hotel_mapping(pairs) -> dict:
+    mapping = {}
+    for pair in pairs or []:
+        old, sep, new = pair.partition("=")
+        if not sep or not old or not new:
+            raise BackupError(f"--map-hotel takes old=new, not '{pair}'")
+        mapping[old] = new
+    return mapping
+
+async def run(args) -> dict:
+    if args.command == "inspect":
+        with tarfile.open(args.archive, "r:gz") as archive:
+            return read_manifest(archive)
+    await DatabaseConnection.connect()
+    await field_cipher.start()
+    try:
+        if args.command == "export":
+            return await export_archive(args.archive, args.only)
+        return await restore_archive(args.archive, args.only, id_prefix=args.id_prefix,
+                                     hotel_ids=hotel_mapping(args.map_hotel), drop=args.drop, dry_run=args.dry_run)
+    finally:
+        await DatabaseConnection.close()
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Back up and restore Virtual Butler data")
+    commands = parser.add_subparsers(dest="command", required=True)
+    export = commands.add_parser("export", help="Write the selected collections to an archive")
+    export.add_argument("archive")
+    export.add_argument("--only", action="append", help=f"Group ({', '.join(BACKUP_GROUPS)}) or collection")
+    inspect = commands.add_parser("inspect", help="Print an archive's manifest")
+    inspect.add_argument("archive")
+    restore = commands.add_parser("restore", help="Load an archive into the database")
+    restore.add_argument("archive")
+    restore.add_argument("--only", action="append", help=f"Group ({', '.join(BACKUP_GROUPS)}) or collection")
+    restore.add_argument("--drop", action="store_true", help="Empty each restored collection first")
+    restore.add_argument("--dry-run", action="store_true", help="Show what would be restored without writing")
+    restore.add_argument("--id-prefix",
+                         help="Prefix order, request, guest and conversation IDs and renumber orders (tenant moves)")
+    restore.add_argument("--map-hotel", action="append", metavar="OLD=NEW", help="Rename a hotel_id (repeatable)")
+    args = parser.parse_args()
+    try:
+        print(json.dumps(asyncio.run(run(args)), indent=2, default=str))
+    except BackupError as e:
+        parser.error(str(e))
@@ -0,0 +1,216 @@
+"""
+butlerctl: operator CLI for the Virtual Butler admin APIs.
+
+Authenticates with an admin JWT (--token or BUTLER_TOKEN). Service base URLs come from
+BUTLER_CHATBOT_URL, BUTLER_WORK_ORDERS_URL and BUTLER_NOTIFICATIONS_URL.
+
+  python backend/scripts/butlerctl.py health
+  python backend/scripts/butlerctl.py work-orders list --status pending --department maintenance
+  python backend/scripts/butlerctl.py work-orders get wo_123 [--activity]
+  python backend/scripts/butlerctl.py dlq list
+  python backend/scripts/butlerctl.py dlq replay --max 20 [--id <message id> ...]
+  python backend/scripts/butlerctl.py routing list | reload
+  python backend/scripts/butlerctl.py token mint --sub guest_42 --role guest --room 301
+  python backend/scripts/butlerctl.py events tail [--domain]
+  python backend/scripts/butlerctl.py migrate [--dry-run | --status] [--json]
+
+Lives in scripts/ rather than a top-level cmd/ package: backend/ is on sys.path for the services,
+and a `cmd` package there would shadow the standard library module of that name.
+"""
+import argparse
+import json
+import os
+import sys
+from typing import Optional
+
+import httpx
+
+SERVICES = {
+    "chatbot": os.getenv("BUTLER_CHATBOT_URL", "http://localhost:8001"),
+    "work_orders": os.getenv("BUTLER_WORK_ORDERS_URL", "http://localhost:8002"),
+    "notifications": os.getenv("BUTLER_NOTIFICATIONS_URL", "http://localhost:8003"),
+}
+
+def fail(message: str) -> None:
+    print(f"butlerctl: {message}", file=sys.stderr)
+    raise SystemExit(1)
+
+def headers(args) -> dict:
+    if not args.token:
+        fail("an admin token is required (--token or BUTLER_TOKEN)")
+    return {"Authorization": f"Bearer {args.token}"}
+
+def call(args, method: str, path: str, service: str = "work_orders", **kwargs):
+    try:
+        response = httpx.request(method, SERVICES[service] + path, headers=headers(args), timeout=args.timeout, **kwargs)
+    except httpx.HTTPError as e:
+        fail(f"{service} unreachable: {e}")
+    if response.status_code >= 400:
+        try:
+            error = response.json().get("error", {})
+            fail(f"{response.status_code} {error.get('code')}: {error.get('message')} (trace {error.get('traceID')})")
+        except ValueError:
+            fail(f"{response.status_code}: {response.text[:200]}")
+    return response.json() if response.content else None
+
+def show(data) -> None:
+    print(json.dumps(data, indent=2, default=str))
+
+def table(rows, columns) -> None:
+    widths = {c: max([len(c)] + [len(str(r.get(c) or "")) for r in rows]) for c in columns}
+    print("  ".join(c.upper().ljust(widths[c]) for c in columns))
+    for row in rows:
+        print("  ".join(str(row.get(c) or "").ljust(widths[c]) for c in columns))
+
+# --- Commands ---
+
+def cmd_health(args) -> None:
+    healthy = True
+    for name, base in SERVICES.items():
+        try:
+            status = httpx.get(f"{base}/healthz", timeout=args.timeout).json().get("status", "unknown")
+        except (httpx.HTTPError, ValueError) as e:
+            status = f"unreachable ({type(e).__name__})"
+        healthy = healthy and status == "healthy"
+        print(f"{name:<14} {status}")
+    if not healthy:
+        raise SystemExit(1)
+
+def cmd_work_orders_list(args) -> None:
+    params = {k: v for k, v in {"status": args.status, "department": args.department, "guest_id": args.guest,
+                                "limit": args.limit}.items() if v}
+    orders = call(args, "GET", "/work-orders", params=params)
+    if args.json:
+        return show(orders)
+    for order in orders:
+        order["description"] = (order.get("description") or "")[:40]
+    table(orders, ["work_order_id", "order_number", "status", "department", "priority", "created_at", "description"])
+
+def cmd_work_orders_get(args) -> None:
+    show(call(args, "GET", f"/work-orders/{args.work_order_id}"))
+    if args.activity:
+        show(call(args, "GET", f"/api/v1/admin/workorder/{args.work_order_id}/activity"))
+
+def cmd_dlq_list(args) -> None:
+    messages = call(args, "GET", "/api/v1/admin/dlq", params={"limit": args.limit})
+    if args.json:
+        return show(messages)
+    table(messages, ["message_id", "enqueued_at", "delivery_count", "reason"])
+
+def cmd_dlq_replay(args) -> None:
+    show(call(args, "POST", "/api/v1/admin/dlq/replay", json={"max_messages": args.max, "message_ids": args.id}))
+
+def cmd_routing_list(args) -> None:
+    rulesets = call(args, "GET", "/api/v1/admin/routing-rules")
+    table(rulesets, ["version", "mode", "rollout_percent", "created_by", "notes"])
+
+def cmd_routing_reload(args) -> None:
+    show(call(args, "POST", "/api/v1/admin/routing-rules/reload"))
+
+def cmd_token_mint(args) -> None:
+    result = call(args, "POST", "/api/v1/admin/test-tokens", json={
+        "sub": args.sub, "role": args.role, "room": args.room, "departments": args.department or [],
+        "ttl_minutes": args.ttl
+    })
+    print(result["token"])
+
+def cmd_events_tail(args) -> None:
+    path = "/api/v1/admin/events/stream" if args.domain else "/api/v1/workorder/events"
+    try:
+        with httpx.stream("GET", SERVICES["work_orders"] + path, headers=headers(args), timeout=None) as response:
+            if response.status_code >= 400:
+                fail(f"{response.status_code} from {path}")
+            event: Optional[str] = None
+            for line in response.iter_lines():
+                if line.startswith("event: "):
+                    event = line[7:]
+                elif line.startswith("data: "):
+                    print(f"{event or 'message'}\t{line[6:]}", flush=True)
+    except KeyboardInterrupt:
+        pass
+    except httpx.HTTPError as e:
+        fail(f"event stream closed: {e}")
+
+def cmd_migrate(args) -> None:
+    if args.status:
+        result = call(args, "GET", "/api/v1/admin/migrations")
+    else:
+        result = call(args, "POST", "/api/v1/admin/migrations/run", json={"dry_run": args.dry_run})
+    if args.json:
+        show(result)
+        return
+    for migration in result.get("applied", []):
+        print(f"applied  {migration['version']:>3}  {migration['name']}  ({migration['applied_at']})")
+    for migration in result.get("migrated", []):
+        print(f"applied  {migration['version']:>3}  {migration['name']}  {json.dumps(migration['result'], default=str)}")
+    for migration in result.get("pending", []):
+        print(f"pending  {migration['version']:>3}  {migration['name']}  {migration['description']}")
+    if result.get("failed"):
+        print(f"FAILED   {result['failed']['version']:>3}  {result['failed']['name']}  {result['failed']['error']}")
+    if result["indexes"]:
+        table(result["indexes"], ["collection", "name", "action", "error"])
+    else:
+        print("indexes up to date")
+    if result.get("failed") or any(step.get("error") for step in result["indexes"]):
+        raise SystemExit(1)
+
+def build_parser() -> argparse.ArgumentParser:
+    parser = argparse.ArgumentParser(prog="butlerctl", description="Virtual Butler operator CLI")
+    parser.add_argument("--token", default=os.getenv("BUTLER_TOKEN"), help="Admin JWT (default: $BUTLER_TOKEN)")
+    parser.add_argument("--timeout", type=float, default=15.0)
+    commands = parser.add_subparsers(dest="command", required=True)
+
+    commands.add_parser("health", help="Check every service's /healthz").set_defaults(func=cmd_health)
+
+    work_orders = commands.add_parser("work-orders", help="List and inspect work orders").add_subparsers(required=True)
+    listing = work_orders.add_parser("list")
+    listing.add_argument("--status")
+    listing.add_argument("--department")
+    listing.add_argument("--guest")
+    listing.add_argument("--limit", type=int, default=50)
+    listing.add_argument("--json", action="store_true")
+    listing.set_defaults(func=cmd_work_orders_list)
+    get = work_orders.add_parser("get")
+    get.add_argument("work_order_id", help="work order ID or order number, e.g. HK-2045")
+    get.add_argument("--activity", action="store_true", help="Also print the activity log")
+    get.set_defaults(func=cmd_work_orders_get)
+
+    dlq = commands.add_parser("dlq", help="Dead-lettered chat requests").add_subparsers(required=True)
+    dlq_list = dlq.add_parser("list")
+    dlq_list.add_argument("--limit", type=int, default=20)
+    dlq_list.add_argument("--json", action="store_true")
+    dlq_list.set_defaults(func=cmd_dlq_list)
+    replay = dlq.add_parser("replay")
+    replay.add_argument("--max", type=int, default=10)
+    replay.add_argument("--id", action="append", help="Only replay this message id (repeatable)")
+    replay.set_defaults(func=cmd_dlq_replay)
+
+    routing = commands.add_parser("routing", help="Routing rulesets").add_subparsers(required=True)
+    routing.add_parser("list").set_defaults(func=cmd_routing_list)
+    routing.add_parser("reload").set_defaults(func=cmd_routing_reload)
+
+    token = commands.add_parser("token", help="Test tokens (needs ALLOW_TEST_TOKENS=true)").add_subparsers(required=True)
+    mint = token.add_parser("mint")
+    mint.add_argument("--sub", required=True)
+    mint.add_argument("--role", default="guest", choices=["guest", "staff", "admin"])
+    mint.add_argument("--room")
+    mint.add_argument("--department", action="append")
+    mint.add_argument("--ttl", type=int, default=60, help="Minutes")
+    mint.set_defaults(func=cmd_token_mint)
+
+    events = commands.add_parser("events", help="Live events").add_subparsers(required=True)
+    tail = events.add_parser("tail")
+    tail.add_argument("--domain", action="store_true", help="Domain events instead of work-order status changes")
+    tail.set_defaults(func=cmd_events_tail)
+
+    migrate = commands.add_parser("migrate", help="Apply schema migrations and sync indexes")
+    mode = migrate.add_mutually_exclusive_group()
+    mode.add_argument("--dry-run", action="store_true", help="Show what would change without writing")
+    mode.add_argument("--status", action="store_true", help="Show applied and pending migrations")
+    migrate.add_argument("--json", action="store_true")
+    migrate.set_defaults(func=cmd_migrate)
+    return parser
+
+if __name__ == "__main__":
+    args = build_parser().parse_args()
+    args.func(args)
@@ -0,0 +1,108 @@
+"""
+Load generator for the chat -> queue -> work-order pipeline.
+Drives synthetic chat requests at a fixed rate and measures end-to-end latency from the
+chat POST until the work order is visible through the status API. Both ends live in the work-order
+service: its chat request consumer creates the order and GET /api/v1/workorder/status/{request_id}
+reports it, so the run needs that service up with Service Bus configured.
+
+Run: python backend/scripts/loadgen.py --token <guest JWT> --rps 5 --duration 60
+"""
+import argparse
+import asyncio
+import random
+import statistics
+import time
+from typing import List, Optional
+
+import httpx
+
+SAMPLE_MESSAGES = [
+    "Need extra towels please",
+    "The AC is not cooling",
+    "Can I get a club sandwich and a coke",
+    "WiFi keeps disconnecting",
+    "Can I get a late checkout",
+    "Please book a taxi to the airport",
+    "Extra pillows and a blanket please",
+    "The bathroom light is broken",
+]
+
+class Results:
+    def __init__(self):
+        self.latencies: List[float] = []
+        self.submit_errors = 0
+        self.timeouts = 0
+
+    def percentile(self, pct: float) -> float:
+        ordered = sorted(self.latencies)
+        index = min(len(ordered) - 1, int(round(pct / 100 * (len(ordered) - 1))))
+        return ordered[index]
+
+    def report(self, sent: int, elapsed: float) -> str:
+        lines = [
+            f"sent={sent} completed={len(self.latencies)} submit_errors={self.submit_errors} "
+            f"timeouts={self.timeouts} elapsed={elapsed:.1f}s achieved_rps={sent / elapsed:.2f}"
+        ]
+        if self.latencies:
+            lines.append(
+                f"latency_ms p50={self.percentile(50) * 1000:.0f} p90={self.percentile(90) * 1000:.0f} "
+                f"p99={self.percentile(99) * 1000:.0f} max={max(self.latencies) * 1000:.0f} "
+                f"mean={statistics.mean(self.latencies) * 1000:.0f}"
+            )
+        return "\n".join(lines)
+
+async def wait_for_work_order(client: httpx.AsyncClient, status_url: str, request_id: str,
+                              headers: dict, timeout: float, poll_interval: float) -> Optional[float]:
+    deadline = time.monotonic() + timeout
+    while time.monotonic() < deadline:
+        response = await client.get(status_url.format(request_id=request_id), headers=headers)
+        if response.status_code == 200:
+            return time.monotonic()
+        await asyncio.sleep(poll_interval)
+    return None
+
+async def run_one(client: httpx.AsyncClient, args, results: Results):
+    headers = {"Authorization": f"Bearer {args.token}"}
+    started = time.monotonic()
+    try:
+        response = await client.post(args.chat_url, json={"text": random.choice(SAMPLE_MESSAGES)}, headers=headers)
+        response.raise_for_status()
+        request_id = response.json()["request_id"]
+    except Exception:
+        results.submit_errors += 1
+        return
+    finished = await wait_for_work_order(client, args.status_url, request_id, headers, args.timeout, args.poll_interval)
+    if finished is None:
+        results.timeouts += 1
+    else:
+        results.latencies.append(finished - started)
+
+async def main(args):
+    results = Results()
+    tasks = []
+    interval = 1.0 / args.rps
+    async with httpx.AsyncClient(timeout=args.timeout) as client:
+        started = time.monotonic()
+        sent = 0
+        while time.monotonic() - started < args.duration:
+            tasks.append(asyncio.create_task(run_one(client, args, results)))
+            sent += 1
+            # Schedule against the start time so slow iterations don't reduce the rate
+            await asyncio.sleep(max(0.0, started + sent * interval - time.monotonic()))
+        await asyncio.gather(*tasks)
+        elapsed = time.monotonic() - started
+    print(results.report(sent, elapsed))
+    if args.max_p99_ms and results.latencies and results.percentile(99) * 1000 > args.max_p99_ms:
+        raise SystemExit(f"p99 latency above threshold of {args.max_p99_ms}ms")
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Virtual Butler pipeline load generator")
+    parser.add_argument("--token", required=True, help="Guest JWT used for chat and status calls")
+    parser.add_argument("--chat-url", default="http://localhost:8001/api/v1/chat")
+    parser.add_argument("--status-url", default="http://localhost:8002/api/v1/workorder/status/{request_id}")
+    parser.add_argument("--rps", type=float, default=5.0, help="Chat requests per second")
+    parser.add_argument("--duration", type=float, default=30.0, help="Test duration in seconds")
+    parser.add_argument("--timeout", type=float, default=30.0, help="Max seconds to wait for a work order")
+    parser.add_argument("--poll-interval", type=float, default=0.25)
+    parser.add_argument("--max-p99-ms", type=float, default=None, help="Exit non-zero if p99 exceeds this")
+    asyncio.run(main(parser.parse_args()))
@@ -0,0 +1,263 @@
+"""
+Populates Mongo with a realistic hotel for demos and integration tests: rooms, guests in their stays,
+staff for every department, a routing ruleset, and a day of chat requests, work orders and
+live-agent conversations.
+
+Run: python backend/scripts/seed.py --size medium [--rooms 220] [--occupancy 0.8] [--date 2026-03-14]
+                                    [--seed 7] [--reset]
+
+The data is reproducible for a given --seed. Everything written is marked `seeded: true` and --reset
+removes the previous run's documents first, so the tool can be re-run against a shared database
+without touching real data. Writes go through the field-encryption wrapper when it is enabled.
+"""
+import argparse
+import asyncio
+import random
+import uuid
+from datetime import date, datetime, time, timedelta, timezone
+from typing import Dict, List, Optional
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import DepartmentEnum, PriorityEnum, StatusEnum
+from shared.quick_actions import QuickAction, list_quick_actions, save_quick_action
+from shared.routing_rules import RoutingRule, create_ruleset, list_rulesets, promote_ruleset
+from shared.security.field_crypto import field_cipher
+
+SIZES = {"small": 40, "medium": 150, "large": 400}
+ROOMS_PER_FLOOR = 20
+# Requests per occupied room per day, and their spread over the hours of the day
+REQUESTS_PER_ROOM = 0.6
+HOURLY_WEIGHTS = [1, 1, 0, 0, 0, 1, 2, 4, 6, 5, 4, 3, 3, 3, 3, 4, 5, 5, 6, 6, 5, 4, 3, 2]
+HANDOFF_RATE = 0.05
+# One member of staff per this many rooms, at least one per department
+STAFF_RATIO = {
+    DepartmentEnum.HOUSEKEEPING: 15, DepartmentEnum.MAINTENANCE: 40, DepartmentEnum.FRONT_DESK: 50,
+    DepartmentEnum.ROOM_SERVICE: 40, DepartmentEnum.IT: 150, DepartmentEnum.SECURITY: 100,
+    DepartmentEnum.CONCIERGE: 150,
+}
+SEEDED_COLLECTIONS = ["rooms", "guest_profiles", "staff_profiles", "chat_requests", "work_orders",
+                      "agent_conversations"]
+
+FIRST_NAMES = ["Amara", "Ben", "Chen", "Daniela", "Elif", "Farid", "Grace", "Hiro", "Ines", "Jonas", "Kofi",
+               "Lena", "Mateo", "Nadia", "Omar", "Priya", "Quinn", "Rosa", "Sven", "Tariq", "Uma", "Wanjiru"]
+LAST_NAMES = ["Achieng", "Berg", "Costa", "Dubois", "Evans", "Fischer", "Garcia", "Haddad", "Ito", "Jensen",
+              "Kim", "Lopez", "Mwangi", "Novak", "Okafor", "Patel", "Rossi", "Schmidt", "Tanaka", "Weber"]
+LANGUAGES = ["en"] * 6 + ["fr", "es", "de", "sw"]
+
+# (guest message, work order description, estimated minutes, priority weights low/medium/high/urgent)
+REQUESTS = {
+    DepartmentEnum.HOUSEKEEPING: [
+        ("Could I get two extra towels please?", "Deliver two bath towels", 15, (3, 6, 1, 0)),
+        ("Can someone clean the room this afternoon?", "Room clean requested for the afternoon", 45, (5, 4, 1, 0)),
+        ("We need an extra pillow and blanket", "Deliver extra pillow and blanket", 15, (3, 6, 1, 0)),
+    ],
+    DepartmentEnum.MAINTENANCE: [
+        ("The AC isn't cooling at all", "AC not cooling", 60, (0, 3, 6, 1)),
+        ("There's a leak under the bathroom sink", "Leak under bathroom sink", 45, (0, 2, 6, 2)),
+        ("The bedside light bulb is broken", "Replace bedside light bulb", 20, (4, 5, 1, 0)),
+    ],
+    DepartmentEnum.ROOM_SERVICE: [
+        ("Can I order breakfast for two at 8?", "Breakfast for two", 30, (1, 7, 2, 0)),
+        ("Please send up a bottle of water and coffee", "Water and coffee", 20, (2, 7, 1, 0)),
+    ],
+    DepartmentEnum.IT: [
+        ("The wifi keeps disconnecting", "Wi-Fi keeps disconnecting", 30, (1, 5, 4, 0)),
+        ("The TV remote doesn't work", "TV remote not working", 20, (4, 5, 1, 0)),
+    ],
+    DepartmentEnum.FRONT_DESK: [
+        ("Is a late checkout possible tomorrow?", "Late checkout request", 10, (3, 6, 1, 0)),
+        ("My key card stopped working", "Key card not working", 10, (0, 4, 5, 1)),
+        ("Can you send me a copy of the bill?", "Send copy of the bill", 10, (6, 4, 0, 0)),
+    ],
+    DepartmentEnum.SECURITY: [
+        ("I think I lost my wallet in the lobby", "Lost wallet reported in the lobby", 30, (0, 3, 6, 1)),
+    ],
+    DepartmentEnum.CONCIERGE: [
+        ("Could you book a taxi to the airport at 6am?", "Airport taxi at 06:00", 15, (2, 7, 1, 0)),
+        ("Can you recommend a restaurant nearby?", "Restaurant recommendation", 10, (6, 4, 0, 0)),
+    ],
+}
+DEPARTMENT_WEIGHTS = {
+    DepartmentEnum.HOUSEKEEPING: 30, DepartmentEnum.MAINTENANCE: 18, DepartmentEnum.ROOM_SERVICE: 20,
+    DepartmentEnum.IT: 8, DepartmentEnum.FRONT_DESK: 12, DepartmentEnum.SECURITY: 2, DepartmentEnum.CONCIERGE: 10,
+}
+DEMO_QUICK_ACTIONS = [
+    QuickAction(action_id="extra_towels", label="Extra towels", labels={"fr": "Serviettes supplémentaires", "es": "Toallas extra"},
+                department=DepartmentEnum.HOUSEKEEPING, description="Extra towels", icon="towel", sort_order=10),
+    QuickAction(action_id="late_checkout", label="Late checkout", labels={"fr": "Départ tardif", "es": "Salida tardía"},
+                department=DepartmentEnum.FRONT_DESK, description="Late checkout request", icon="clock", sort_order=20),
+    QuickAction(action_id="iron_board", label="Iron & board", labels={"fr": "Fer et planche", "es": "Plancha y tabla"},
+                department=DepartmentEnum.HOUSEKEEPING, priority=PriorityEnum.LOW, description="Iron and ironing board",
+                icon="iron", sort_order=30),
+]
+
+DEMO_RULES = [
+    RoutingRule(department=DepartmentEnum.HOUSEKEEPING, pattern=r"towel|clean|linen|sheet|pillow|blanket"),
+    RoutingRule(department=DepartmentEnum.MAINTENANCE, pattern=r"\bac\b|air.?con|leak|broken|bulb|plumbing"),
+    RoutingRule(department=DepartmentEnum.ROOM_SERVICE, pattern=r"breakfast|dinner|lunch|water|coffee|menu"),
+    RoutingRule(department=DepartmentEnum.IT, pattern=r"wi-?fi|internet|tv|remote"),
+    RoutingRule(department=DepartmentEnum.FRONT_DESK, pattern=r"check.?out|bill|invoice|key card"),
+    RoutingRule(department=DepartmentEnum.SECURITY, pattern=r"lost|theft|stolen|alarm"),
+    RoutingRule(department=DepartmentEnum.CONCIERGE, pattern=r"taxi|tour|restaurant|recommend"),
+]
+
+def seeded_id(rng: random.Random, prefix: str) -> str:
+    return f"{prefix}_{uuid.UUID(int=rng.getrandbits(128)).hex[:16]}"
+
+def room_numbers(rooms: int) -> List[str]:
+    return [f"{i // ROOMS_PER_FLOOR + 1}{i % ROOMS_PER_FLOOR + 1:02d}" for i in range(rooms)]
+
+def status_for(age_minutes: float, estimate: int, rng: random.Random) -> StatusEnum:
+    """Older orders are mostly done; the last hour's are still in the queue."""
+    if age_minutes > estimate * 3:
+        return rng.choices([StatusEnum.COMPLETED, StatusEnum.CANCELLED, StatusEnum.ON_HOLD], [90, 6, 4])[0]
+    if age_minutes > estimate:
+        return rng.choices([StatusEnum.COMPLETED, StatusEnum.IN_PROGRESS, StatusEnum.ASSIGNED], [60, 30, 10])[0]
+    return rng.choices([StatusEnum.PENDING, StatusEnum.ASSIGNED, StatusEnum.IN_PROGRESS], [50, 30, 20])[0]
+
+def build_dataset(rooms: int, occupancy: float, day: date, seed: int, now: Optional[datetime] = None) -> Dict[str, List[dict]]:
+    """Every document for one hotel and one day, keyed by collection; deterministic for a given seed."""
+    rng = random.Random(seed)
+    now = now or datetime.now(timezone.utc)
+    start = datetime.combine(day, time.min, tzinfo=timezone.utc)
+    end = min(now, start + timedelta(days=1))
+    data: Dict[str, List[dict]] = {name: [] for name in SEEDED_COLLECTIONS}
+
+    numbers = room_numbers(rooms)
+    for number in numbers:
+        smart = rng.random() < 0.3
+        data["rooms"].append({"room_number": number, "dnd_active": rng.random() < 0.05, "smart_room": smart,
+                              "devices": ["thermostat", "lights", "curtains", "tv"] if smart else [],
+                              "created_at": start, "updated_at": start, "seeded": True})
+
+    for number in rng.sample(numbers, round(rooms * occupancy)):
+        first, last = rng.choice(FIRST_NAMES), rng.choice(LAST_NAMES)
+        # Every guest is mid-stay on the seeded day
+        check_in = start - timedelta(days=rng.randint(1, 4)) + timedelta(hours=rng.randint(14, 20))
+        check_out = start + timedelta(days=rng.randint(1, 4), hours=rng.randint(8, 11))
+        data["guest_profiles"].append({
+            "guest_id": seeded_id(rng, "guest"), "room_number": number, "name": f"{first} {last}",
+            "email": f"{first}.{last}.{number}@guest.example".lower(), "phone": f"+2547{rng.randint(10000000, 99999999)}",
+            "vip_status": rng.random() < 0.08, "preferences": {"language": rng.choice(LANGUAGES)},
+            "check_in_date": check_in, "check_out_date": check_out,
+            "created_at": check_in, "seeded": True
+        })
+
+    for department, ratio in STAFF_RATIO.items():
+        for i in range(max(1, rooms // ratio)):
+            first, last = rng.choice(FIRST_NAMES), rng.choice(LAST_NAMES)
+            data["staff_profiles"].append({
+                "staff_id": f"staff_{department.value}_{i + 1}", "name": f"{first} {last}",
+                "email": f"{first}.{last}.{i + 1}@staff.example".lower(), "role": "staff",
+                "department": department.value, "created_at": start, "seeded": True
+            })
+    data["staff_profiles"].append({"staff_id": "admin_1", "name": "Demo Admin", "email": "admin@staff.example",
+                                   "role": "admin", "department": DepartmentEnum.FRONT_DESK.value,
+                                   "created_at": start, "seeded": True})
+    staff_by_department: Dict[str, List[str]] = {}
+    for staff in data["staff_profiles"]:
+        if staff["role"] == "staff":
+            staff_by_department.setdefault(staff["department"], []).append(staff["staff_id"])
+
+    guests = data["guest_profiles"]
+    hours = [h for h in range(24) if start + timedelta(hours=h) < end]
+    count = round(len(guests) * REQUESTS_PER_ROOM * sum(HOURLY_WEIGHTS[h] for h in hours) / sum(HOURLY_WEIGHTS))
+    departments, weights = list(DEPARTMENT_WEIGHTS), list(DEPARTMENT_WEIGHTS.values())
+    for _ in range(count if guests and hours else 0):
+        guest = rng.choice(guests)
+        hour = rng.choices(hours, [HOURLY_WEIGHTS[h] for h in hours])[0]
+        created_at = min(start + timedelta(hours=hour, seconds=rng.randint(0, 3599)), end - timedelta(minutes=1))
+        department = rng.choices(departments, weights)[0]
+        message, description, estimate, priority_weights = rng.choice(REQUESTS[department])
+        priority = rng.choices(list(PriorityEnum), priority_weights)[0]
+        status = status_for((end - created_at).total_seconds() / 60, estimate, rng)
+        request_id = seeded_id(rng, "req")
+
+        data["chat_requests"].append({
+            "request_id": request_id, "guest_id": guest["guest_id"], "message": message,
+            "department": department.value, "status": status.value, "tags": [department.value],
+            "sentiment": round(rng.uniform(-0.6, 0.8), 2), "language": guest["preferences"]["language"],
+            "metadata": {"room_number": guest["room_number"]}, "created_at": created_at,
+            "updated_at": created_at, "seeded": True
+        })
+
+        order = {
+            "request_id": request_id, "work_order_id": seeded_id(rng, "wo"), "guest_id": guest["guest_id"],
+            "department": department.value, "description": f"{description} (room {guest['room_number']})",
+            "status": status.value, "priority": priority.value, "estimated_duration": estimate,
+            "location": guest["room_number"], "notes": [], "tags": [], "metadata": {"room_number": guest["room_number"]},
+            "created_at": created_at, "updated_at": created_at, "seeded": True
+        }
+        if status != StatusEnum.PENDING:
+            order["staff_id"] = rng.choice(staff_by_department[department.value])
+            order["assigned_at"] = created_at + timedelta(minutes=rng.randint(1, 10))
+            order["updated_at"] = order["assigned_at"]
+        if status in (StatusEnum.IN_PROGRESS, StatusEnum.COMPLETED):
+            order["started_at"] = order["assigned_at"] + timedelta(minutes=rng.randint(1, 15))
+            order["updated_at"] = order["started_at"]
+        if status == StatusEnum.COMPLETED:
+            actual = max(5, round(rng.gauss(estimate, estimate / 3)))
+            order["completed_at"] = min(order["started_at"] + timedelta(minutes=actual), end)
+            order["actual_duration"] = actual
+            order["updated_at"] = order["completed_at"]
+        data["work_orders"].append(order)
+
+        if rng.random() < HANDOFF_RATE:
+            closed = status == StatusEnum.COMPLETED
+            messages = [{"message_id": seeded_id(rng, "msg"), "sender": "guest", "sender_id": guest["guest_id"],
+                         "text": message, "timestamp": created_at},
+                        {"message_id": seeded_id(rng, "msg"), "sender": "guest", "sender_id": guest["guest_id"],
+                         "text": "Can I talk to someone please?", "timestamp": created_at + timedelta(minutes=1)}]
+            conversation = {
+                "conversation_id": seeded_id(rng, "conv"), "guest_id": guest["guest_id"],
+                "room_number": guest["room_number"], "department": DepartmentEnum.FRONT_DESK.value,
+                "status": "closed" if closed else "waiting", "reason": "guest_request",
+                "language": guest["preferences"]["language"], "messages": messages, "transfers": [],
+                "created_at": created_at, "updated_at": created_at + timedelta(minutes=1), "seeded": True
+            }
+            if closed:
+                agent = rng.choice(staff_by_department[DepartmentEnum.FRONT_DESK.value])
+                messages.append({"message_id": seeded_id(rng, "msg"), "sender": "agent", "sender_id": agent,
+                                 "text": "Of course, I've sorted that out for you.",
+                                 "timestamp": created_at + timedelta(minutes=4)})
+                conversation.update(agent_id=agent, disposition="resolved", closed_by=agent,
+                                    closed_at=created_at + timedelta(minutes=5))
+            data["agent_conversations"].append(conversation)
+    return data
+
+async def seed(rooms: int, occupancy: float, day: date, seed_value: int, reset: bool) -> None:
+    await DatabaseConnection.connect()
+    await field_cipher.start()
+    data = build_dataset(rooms, occupancy, day, seed_value)
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        for name, docs in data.items():
+            if reset:
+                removed = await db[name].delete_many({"seeded": True})
+                print(f"Removed {removed.deleted_count} seeded documents from {name}.")
+            if docs:
+                await db[name].insert_many(docs)
+            print(f"Inserted {len(docs)} documents into {name}.")
+    if not await list_rulesets():
+        ruleset = await create_ruleset(DEMO_RULES, created_by="seed", notes="Demo ruleset from scripts/seed.py")
+        await promote_ruleset(ruleset.version)
+        print(f"Activated demo routing ruleset v{ruleset.version}.")
+    if not await list_quick_actions(include_disabled=True):
+        for action in DEMO_QUICK_ACTIONS:
+            await save_quick_action(action)
+        print(f"Added {len(DEMO_QUICK_ACTIONS)} demo quick actions.")
+    await DatabaseConnection.close()
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Seed demo data for the Virtual Butler")
+    parser.add_argument("--size", choices=list(SIZES), default="small")
+    parser.add_argument("--rooms", type=int, help="Overrides --size")
+    parser.add_argument("--occupancy", type=float, default=0.8)
+    parser.add_argument("--date", type=date.fromisoformat, default=datetime.now(timezone.utc).date(),
+                        help="Day of activity to generate (default: today, up to now)")
+    parser.add_argument("--seed", type=int, default=7)
+    parser.add_argument("--reset", action="store_true", help="Remove previously seeded documents first")
+    args = parser.parse_args()
+    if not 0 < args.occupancy <= 1:
+        parser.error("--occupancy must be in (0, 1]")
+    asyncio.run(seed(args.rooms or SIZES[args.size], args.occupancy, args.date, args.seed, args.reset))
@@ -2,7 +2,7 @@ import asyncio
 import sys
 import os
 import uuid
-from datetime import datetime, timedelta
+from datetime import datetime, timedelta, timezone
 from pathlib import Path
 from colorama import init, Fore, Style
 
@@ -29,7 +29,7 @@ async def insert_documents_bulk(collection, documents, label):
 async def seed_database():
     await Database.connect()
 
-    now = datetime.utcnow()
+    now = datetime.now(timezone.utc)
 
     chat_requests = [
         {"guest_id": f"G00{i+1}", "message": msg, "status": status,
@@ -3,7 +3,7 @@ Script to seed the database with dummy data for all collections.
 Run: python backend/scripts/seed_dummy_data.py
 """
 import asyncio
-from datetime import datetime
+from datetime import datetime, timezone
 from shared.db.database import DatabaseConnection
 from shared.db.models import GuestProfile, User, Notification, ChatRequest, DepartmentEnum, StatusEnum, NotificationTypeEnum
 
@@ -45,7 +45,7 @@ async def seed_notifications(conn):
             guest_id="g1",
             type=NotificationTypeEnum.CHAT,
             message="Welcome Alice!",
-            created_at=datetime.utcnow(),
+            created_at=datetime.now(timezone.utc),
         ).dict(by_alias=True),
         Notification(
             notification_id="n2",
@@ -53,7 +53,7 @@ async def seed_notifications(conn):
             guest_id="g2",
             type=NotificationTypeEnum.CHAT,
             message="Your room is ready.",
-            created_at=datetime.utcnow(),
+            created_at=datetime.now(timezone.utc),
         ).dict(by_alias=True),
     ]
     await conn["notifications"].delete_many({})
@@ -68,8 +68,8 @@ async def seed_chat_requests(conn):
             department=DepartmentEnum.FRONT_DESK,
             status=StatusEnum.PENDING,
             sentiment=0.5,
-            created_at=datetime.utcnow(),
-            updated_at=datetime.utcnow()
+            created_at=datetime.now(timezone.utc),
+            updated_at=datetime.now(timezone.utc)
         ).model_dump(by_alias=True),
         ChatRequest(
             request_id="req2",
@@ -78,8 +78,8 @@ async def seed_chat_requests(conn):
             department=DepartmentEnum.FRONT_DESK,
             status=StatusEnum.PENDING,
             sentiment=0.2,
-            created_at=datetime.utcnow(),
-            updated_at=datetime.utcnow()
+            created_at=datetime.now(timezone.utc),
+            updated_at=datetime.now(timezone.utc)
         ).model_dump(by_alias=True),
     ]
     await conn["chat_requests"].delete_many({})
@@ -0,0 +1,227 @@
+"""
+Door access requests ("I'm locked out", late-checkout key extension).
+
+Keys are never issued on the strength of a chat message alone: the guest first proves who they are
+with a one-time code sent to the contact details on their profile, then the lock-system connector
+issues or extends the key. Every step is written to `security_events`, which is append-only.
+
+A key extension runs at most ACCESS_EXTENSION_MAX_HOURS past the booked checkout. Asking for longer
+doesn't start verification at all; it goes to the front desk, who approve it (or not) in person.
+"""
+import hashlib
+import hmac
+import os
+import re
+import secrets
+import uuid
+from datetime import datetime, time, timedelta, timezone, tzinfo
+from typing import Optional
+
+import httpx
+import structlog
+from pymongo import ReturnDocument
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import SecurityEvent
+from shared.http_client import ResilientClient
+
+logger = structlog.get_logger()
+
+ACCESS_CODE_TTL_MINUTES = int(os.getenv("ACCESS_CODE_TTL_MINUTES", "10"))
+ACCESS_CODE_MAX_ATTEMPTS = int(os.getenv("ACCESS_CODE_MAX_ATTEMPTS", "5"))
+ACCESS_MAX_CHALLENGES_PER_HOUR = int(os.getenv("ACCESS_MAX_CHALLENGES_PER_HOUR", "3"))
+LOCK_SYSTEM = os.getenv("LOCK_SYSTEM", "none").lower()
+LOCK_SYSTEM_URL = os.getenv("LOCK_SYSTEM_URL")
+LOCK_SYSTEM_TOKEN = os.getenv("LOCK_SYSTEM_TOKEN")
+LATE_CHECKOUT_TIME = time.fromisoformat(os.getenv("LATE_CHECKOUT_TIME", "14:00"))
+ACCESS_EXTENSION_MAX_HOURS = float(os.getenv("ACCESS_EXTENSION_MAX_HOURS", "3"))
+
+lock_client = ResilientClient("lock_system")
+
+LOCKOUT_PATTERN = re.compile(
+    r"locked (myself )?out|lost my (room )?key|key ?card (doesn'?t|does not|isn'?t|is not|stopped) work"
+    r"|key (doesn'?t|does not|stopped) work|can'?t (get|open) (in|into|the door)|new (room )?key"
+)
+EXTENSION_PATTERN = re.compile(r"late check.?out|extend (my )?(room )?key|key (extension|extended)")
+ACCESS_CODE_PATTERN = re.compile(r"^\s*(\d{6})\s*$")
+
+class LockSystemError(Exception): pass
+
+class VerificationLimitError(Exception): pass
+
+def detect_access_request(message: str) -> Optional[str]:
+    """Returns 'lockout' or 'extension' for door access requests, None otherwise."""
+    text = message.lower()
+    if LOCKOUT_PATTERN.search(text):
+        return "lockout"
+    if EXTENSION_PATTERN.search(text) and "key" in text:
+        return "extension"
+    return None
+
+def default_extension_until(now: datetime, tz: tzinfo) -> datetime:
+    """The next LATE_CHECKOUT_TIME in the hotel's timezone, for extensions that don't name a time."""
+    local_now = now.astimezone(tz)
+    candidate = datetime.combine(local_now.date(), LATE_CHECKOUT_TIME, tzinfo=tz)
+    return candidate if candidate > local_now else candidate + timedelta(days=1)
+
+def extension_limit(now: datetime, check_out: Optional[datetime], tz: tzinfo) -> datetime:
+    """The latest a key can be extended to without staff: shortly after the booked checkout, if known."""
+    if check_out is None:
+        return default_extension_until(now, tz)
+    if check_out.tzinfo is None:
+        check_out = check_out.replace(tzinfo=timezone.utc)
+    return check_out + timedelta(hours=ACCESS_EXTENSION_MAX_HOURS)
+
+def _hash_code(challenge_id: str, code: str) -> str:
+    return hashlib.sha256(f"{challenge_id}:{code}".encode()).hexdigest()
+
+async def record_security_event(event_type: str, guest_id: Optional[str], room_number: Optional[str],
+                                outcome: str, actor: Optional[str] = None, **details) -> SecurityEvent:
+    event = SecurityEvent(
+        event_id=f"sec_{uuid.uuid4().hex[:12]}",
+        event_type=event_type,
+        guest_id=guest_id,
+        room_number=room_number,
+        actor=actor or guest_id,
+        outcome=outcome,
+        details=details
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["security_events"].insert_one(event.model_dump(exclude={"id"}))
+    logger.info("security_event", event_type=event_type, guest_id=guest_id, room_number=room_number, outcome=outcome)
+    return event
+
+# --- Identity verification ---
+
+async def create_challenge(guest_id: str, room_number: str, purpose: str,
+                           valid_until: Optional[datetime] = None) -> tuple:
+    """
+    Starts a one-time-code challenge, replacing any pending one. Returns (challenge, plaintext code).
+    Raises VerificationLimitError after ACCESS_MAX_CHALLENGES_PER_HOUR so codes can't be farmed.
+    """
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        recent = await conn["virtualbutler"]["access_challenges"].count_documents(
+            {"guest_id": guest_id, "created_at": {"$gte": now - timedelta(hours=1)}}
+        )
+    if recent >= ACCESS_MAX_CHALLENGES_PER_HOUR:
+        await record_security_event("verification_rate_limited", guest_id, room_number, "blocked", purpose=purpose)
+        raise VerificationLimitError("Too many verification attempts")
+    code = f"{secrets.randbelow(10 ** 6):06d}"
+    challenge_id = f"chal_{uuid.uuid4().hex[:12]}"
+    challenge = {
+        "challenge_id": challenge_id,
+        "guest_id": guest_id,
+        "room_number": room_number,
+        "purpose": purpose,
+        "valid_until": valid_until,
+        "code_hash": _hash_code(challenge_id, code),
+        "attempts": 0,
+        "status": "pending",
+        "expires_at": now + timedelta(minutes=ACCESS_CODE_TTL_MINUTES),
+        "created_at": now
+    }
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["access_challenges"]
+        await coll.update_many({"guest_id": guest_id, "status": "pending"}, {"$set": {"status": "superseded"}})
+        await coll.insert_one(challenge)
+    await record_security_event("verification_started", guest_id, room_number, "pending",
+                                purpose=purpose, challenge_id=challenge_id)
+    return challenge, code
+
+async def get_pending_challenge(guest_id: str) -> Optional[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["access_challenges"].find_one(
+            {"guest_id": guest_id, "status": "pending", "expires_at": {"$gt": datetime.now(timezone.utc)}}
+        )
+
+async def verify_challenge(challenge: dict, code: str) -> str:
+    """Checks a code against the challenge; returns 'verified', 'invalid' or 'locked'."""
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["access_challenges"]
+        if hmac.compare_digest(challenge["code_hash"], _hash_code(challenge["challenge_id"], code)):
+            updated = await coll.find_one_and_update(
+                {"challenge_id": challenge["challenge_id"], "status": "pending"},
+                {"$set": {"status": "verified", "verified_at": datetime.now(timezone.utc)}}
+            )
+            outcome = "verified" if updated else "invalid"
+        else:
+            updated = await coll.find_one_and_update(
+                {"challenge_id": challenge["challenge_id"], "status": "pending"},
+                {"$inc": {"attempts": 1}},
+                return_document=ReturnDocument.AFTER
+            )
+            outcome = "invalid"
+            if updated and updated["attempts"] >= ACCESS_CODE_MAX_ATTEMPTS:
+                await coll.update_one({"challenge_id": challenge["challenge_id"]}, {"$set": {"status": "locked"}})
+                outcome = "locked"
+    await record_security_event(
+        "verification_succeeded" if outcome == "verified" else "verification_failed",
+        challenge["guest_id"], challenge["room_number"], outcome,
+        purpose=challenge["purpose"], challenge_id=challenge["challenge_id"]
+    )
+    return outcome
+
+# --- Lock system connectors ---
+
+class LockConnector:
+    name = "none"
+
+    async def issue_key(self, room_number: str, guest_id: str, valid_until: Optional[datetime]) -> dict:
+        raise LockSystemError("No lock system is configured")
+
+    async def extend_key(self, room_number: str, guest_id: str, valid_until: datetime) -> dict:
+        raise LockSystemError("No lock system is configured")
+
+class RestLockConnector(LockConnector):
+    """Generic adapter for lock vendors' REST APIs; the response is passed back as the key details."""
+    name = "rest"
+
+    async def _post(self, path: str, payload: dict) -> dict:
+        headers = {"Authorization": f"Bearer {LOCK_SYSTEM_TOKEN}"} if LOCK_SYSTEM_TOKEN else {}
+        try:
+            resp = await lock_client.post(f"{LOCK_SYSTEM_URL.rstrip('/')}{path}", json=payload, headers=headers)
+            resp.raise_for_status()
+            return resp.json()
+        except httpx.HTTPError as e:
+            raise LockSystemError(str(e))
+
+    async def issue_key(self, room_number: str, guest_id: str, valid_until: Optional[datetime]) -> dict:
+        return await self._post("/keys", {"room_number": room_number, "guest_id": guest_id,
+                                          "valid_until": valid_until.isoformat() if valid_until else None})
+
+    async def extend_key(self, room_number: str, guest_id: str, valid_until: datetime) -> dict:
+        return await self._post("/keys/extend", {"room_number": room_number, "guest_id": guest_id,
+                                                 "valid_until": valid_until.isoformat()})
+
+def get_lock_connector() -> LockConnector:
+    if LOCK_SYSTEM == "rest" and LOCK_SYSTEM_URL:
+        return RestLockConnector()
+    return LockConnector()
+
+lock_connector = get_lock_connector()
+
+async def grant_access(challenge: dict) -> dict:
+    """Issues or extends the key for a verified challenge; raises LockSystemError if the lock system refuses."""
+    guest_id, room_number = challenge["guest_id"], challenge["room_number"]
+    event_type = "key_issued" if challenge["purpose"] == "lockout" else "key_extended"
+    try:
+        if challenge["purpose"] == "lockout":
+            key = await lock_connector.issue_key(room_number, guest_id, challenge.get("valid_until"))
+        else:
+            key = await lock_connector.extend_key(room_number, guest_id, challenge["valid_until"])
+    except LockSystemError as e:
+        await record_security_event(event_type, guest_id, room_number, "failed",
+                                    connector=lock_connector.name, error=str(e))
+        raise
+    await record_security_event(event_type, guest_id, room_number, "succeeded", connector=lock_connector.name,
+                                key_id=key.get("key_id"), valid_until=challenge.get("valid_until"))
+    return key
+
+async def ensure_access_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["access_challenges"].create_index([("guest_id", 1), ("status", 1)])
+        # Expired challenges are purged; security events are kept
+        await conn["virtualbutler"]["access_challenges"].create_index("expires_at", expireAfterSeconds=86400)
+        await conn["virtualbutler"]["security_events"].create_index([("created_at", -1)])
+        await conn["virtualbutler"]["security_events"].create_index([("room_number", 1), ("created_at", -1)])
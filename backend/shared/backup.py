@@ -0,0 +1,247 @@
+"""
+Backup archives of butler data, for restoring a hotel into another cluster or moving it to another tenant.
+
+An archive is a .tar.gz holding manifest.json (when and where it was taken, the collections and their
+document counts, the schema migrations applied) and one collections/<name>.jsonl per collection in
+canonical extended JSON, so ObjectIds and dates survive the trip. Collections are grouped (work_orders,
+conversations, guests, staff, config) and either groups or single collections can be exported or
+restored. Signing and encryption keys (jwt_keys, field_keys, api_keys) are never exported: they stay
+with the cluster, and the target's own keys sign and encrypt from then on. Derived state (read models,
+leases, rate limits, dedup ledgers) isn't exported either; read models are rebuilt after a restore.
+
+Documents are read and written through the field-encryption wrapper, so encrypted fields are in the
+clear in the archive and are re-encrypted with the target's keys. The wrapper doesn't know the order
+snapshots inside work_order_events, so those are decrypted and re-encrypted here. Keep archives wherever
+the database backups are kept.
+
+For a tenant move, restore can remap IDs: `id_prefix` is put in front of every work order, request,
+guest and conversation ID (and the references to them), and `hotel_ids` renames hotel_id values, so
+the moved data can't collide with what the target already holds. Remapped documents get new _ids, and
+work orders get new order numbers from the target's counters (subtasks keep their parent's, with their
+suffix) and the short codes of their new IDs; the archive's counters are not restored.
+
+Documents already in the target (duplicate key) are skipped and counted; any other write error stops
+the restore.
+"""
+import io
+import json
+import os
+import tarfile
+import tempfile
+from datetime import datetime, timezone
+from typing import Dict, Iterable, List, Optional
+
+import structlog
+from bson import json_util
+from pymongo.errors import BulkWriteError
+
+from shared.db.database import DatabaseConnection
+from shared.event_store import EVENTS_COLLECTION
+from shared.order_numbers import next_order_number
+from shared.read_models import rebuild_read_models
+from shared.scan_codes import short_code_for
+from shared.security.field_crypto import field_cipher
+
+logger = structlog.get_logger()
+
+ARCHIVE_FORMAT_VERSION = 1
+RESTORE_BATCH_SIZE = 500
+DUPLICATE_KEY = 11000
+HOTEL_ID = os.getenv("HOTEL_ID", "default")
+
+BACKUP_GROUPS: Dict[str, List[str]] = {
+    "work_orders": ["work_orders", "work_order_activity", "work_order_events", "maintenance_schedules",
+                    "incidents", "event_groups", "counters"],
+    "conversations": ["chat_requests", "chat_contexts", "agent_conversations", "chat_attachments", "notifications",
+                      "nps_surveys"],
+    "guests": ["guest_profiles", "reservations", "guest_restrictions", "wake_up_calls", "transport_requests",
+               "lost_reports", "found_items", "promotion_offers", "devices"],
+    "staff": ["staff_profiles", "users"],
+    "config": ["routing_rulesets", "quick_actions", "response_templates", "response_personas", "knowledge_articles",
+               "translation_overrides", "feature_flags", "runtime_config", "custom_field_definitions",
+               "checklist_templates", "business_calendars", "retention_policies", "zones", "rooms", "assets",
+               "venues", "venue_areas", "service_hubs", "promotions", "recommendations", "report_recipients",
+               "notification_preferences", "oidc_providers"],
+}
+# Fields holding IDs that a tenant move prefixes, wherever they appear in a document
+REMAPPED_ID_FIELDS = {"work_order_id", "request_id", "guest_id", "conversation_id", "parent_id", "depends_on"}
+# Keyed by the source cluster's numbering; a remapped restore numbers orders from the target's instead
+NOT_REMAPPED = {"counters"}
+# Where work_order_events keep (partial) work orders, encrypted as work_orders documents are
+EVENT_PAYLOADS = ("document", "set")
+
+class BackupError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+def collections_for(selection: Optional[Iterable[str]] = None) -> List[str]:
+    """Collections named by a selection of groups and collection names; all of them for none."""
+    known = [c for group in BACKUP_GROUPS.values() for c in group]
+    if not selection:
+        return known
+    chosen = []
+    for name in selection:
+        if name not in BACKUP_GROUPS and name not in known:
+            raise BackupError(f"Unknown group or collection '{name}'; groups are {', '.join(BACKUP_GROUPS)}")
+        chosen += [c for c in BACKUP_GROUPS.get(name, [name]) if c not in chosen]
+    return chosen
+
+def remap_ids(value, id_prefix: Optional[str] = None, hotel_ids: Optional[Dict[str, str]] = None, field: str = ""):
+    """`value` with IDs prefixed and hotel IDs renamed, for a tenant move."""
+    if isinstance(value, dict):
+        return {k: remap_ids(v, id_prefix, hotel_ids, k) for k, v in value.items()}
+    if isinstance(value, list):
+        return [remap_ids(v, id_prefix, hotel_ids, field) for v in value]
+    if field == "hotel_id" and hotel_ids and value in hotel_ids:
+        return hotel_ids[value]
+    if field in REMAPPED_ID_FIELDS and id_prefix and isinstance(value, str):
+        return id_prefix + value
+    return value
+
+async def _event_payloads(event: dict, transform) -> dict:
+    data = event.get("data") or {}
+    for key in EVENT_PAYLOADS:
+        if isinstance(data.get(key), dict):
+            data[key] = await transform(data[key])
+    return event
+
+async def _decrypted(collection: str, doc: dict) -> dict:
+    if collection != EVENTS_COLLECTION or not field_cipher.enabled:
+        return doc
+    return await _event_payloads(doc, lambda payload: field_cipher.decrypt_document("work_orders", payload))
+
+async def _encrypted(collection: str, doc: dict) -> dict:
+    if collection != EVENTS_COLLECTION or not field_cipher.enabled:
+        return doc
+    async def encrypt(payload):
+        return field_cipher.encrypt_document("work_orders", payload)
+    return await _event_payloads(doc, encrypt)
+
+class OrderRenumbering:
+    """New order numbers and short codes for remapped work orders, shared by orders and their events."""
+
+    def __init__(self, next_number=next_order_number):
+        self.next_number = next_number
+        self.numbers: Dict[str, str] = {}
+
+    async def apply(self, order: dict) -> dict:
+        if order.get("work_order_id") and "short_code" in order:
+            order["short_code"] = short_code_for(order["work_order_id"])
+        number = order.get("order_number")
+        if isinstance(number, str):
+            base, dot, suffix = number.partition(".")
+            if base not in self.numbers and order.get("department"):
+                self.numbers[base] = await self.next_number(order["department"])
+            if base in self.numbers:
+                order["order_number"] = self.numbers[base] + dot + suffix
+        return order
+
+def _add(archive: tarfile.TarFile, name: str, data: bytes) -> None:
+    info = tarfile.TarInfo(name)
+    info.size = len(data)
+    info.mtime = int(datetime.now(timezone.utc).timestamp())
+    archive.addfile(info, io.BytesIO(data))
+
+async def export_archive(path: str, selection: Optional[Iterable[str]] = None) -> dict:
+    collections = collections_for(selection)
+    counts = {}
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        migrations = await db["schema_migrations"].distinct("version")
+        with tempfile.TemporaryDirectory() as workdir, tarfile.open(path, "w:gz") as archive:
+            for collection in collections:
+                file = os.path.join(workdir, f"{collection}.jsonl")
+                counts[collection] = 0
+                with open(file, "w", encoding="utf-8") as out:
+                    async for doc in db[collection].find({}):
+                        doc = await _decrypted(collection, doc)
+                        out.write(json_util.dumps(doc, json_options=json_util.CANONICAL_JSON_OPTIONS) + "\n")
+                        counts[collection] += 1
+                archive.add(file, arcname=f"collections/{collection}.jsonl")
+            manifest = {
+                "format_version": ARCHIVE_FORMAT_VERSION,
+                "created_at": datetime.now(timezone.utc).isoformat(),
+                "hotel_id": HOTEL_ID,
+                "schema_migrations": sorted(migrations),
+                "collections": counts,
+            }
+            _add(archive, "manifest.json", json.dumps(manifest, indent=2).encode())
+    logger.info("backup_exported", path=path, collections=len(counts), documents=sum(counts.values()))
+    return manifest
+
+def read_manifest(archive: tarfile.TarFile) -> dict:
+    try:
+        manifest = json.load(archive.extractfile("manifest.json"))
+    except KeyError:
+        raise BackupError("Not a butler backup: manifest.json is missing")
+    if manifest.get("format_version") != ARCHIVE_FORMAT_VERSION:
+        raise BackupError(f"Unsupported backup format {manifest.get('format_version')}")
+    return manifest
+
+async def _insert(coll, docs: List[dict]) -> int:
+    """Inserted count; documents already in the target (same _id or unique key) are skipped."""
+    try:
+        return len((await coll.insert_many(docs, ordered=False)).inserted_ids)
+    except BulkWriteError as e:
+        failed = [error for error in e.details["writeErrors"] if error["code"] != DUPLICATE_KEY]
+        if failed:
+            raise BackupError(f"Restoring {coll.name} failed: {failed[0]['errmsg']} "
+                              f"({len(failed)} documents, {e.details['nInserted']} inserted before)", 500)
+        return e.details["nInserted"]
+
+async def restore_archive(path: str, selection: Optional[Iterable[str]] = None, id_prefix: Optional[str] = None,
+                          hotel_ids: Optional[Dict[str, str]] = None, drop: bool = False,
+                          dry_run: bool = False) -> dict:
+    """
+    Restores the selected collections (all in the archive by default). With `drop`, each restored
+    collection is emptied first; otherwise documents already present are kept and reported as skipped.
+    """
+    remapping = bool(id_prefix or hotel_ids)
+    renumbering = OrderRenumbering()
+    result = {"dry_run": dry_run, "collections": {}}
+    with tarfile.open(path, "r:gz") as archive:
+        manifest = read_manifest(archive)
+        wanted = collections_for(selection) if selection else list(manifest["collections"])
+        missing = [c for c in wanted if c not in manifest["collections"]]
+        if selection and missing:
+            raise BackupError(f"Not in this backup: {', '.join(missing)}")
+        result["manifest"] = manifest
+        async with DatabaseConnection.get_connection() as conn:
+            db = conn["virtualbutler"]
+            for collection in [c for c in wanted if c in manifest["collections"]]:
+                if remapping and collection in NOT_REMAPPED:
+                    result["collections"][collection] = {"documents": manifest["collections"][collection],
+                                                         "not_restored": "remapped restores use the target's"}
+                    continue
+                if dry_run:
+                    result["collections"][collection] = {"documents": manifest["collections"][collection],
+                                                         "existing": await db[collection].count_documents({})}
+                    continue
+                if drop:
+                    await db[collection].delete_many({})
+                inserted = read = 0
+                batch = []
+                for line in archive.extractfile(f"collections/{collection}.jsonl"):
+                    doc = json_util.loads(line)
+                    if remapping:
+                        doc.pop("_id", None)
+                        doc = remap_ids(doc, id_prefix, hotel_ids)
+                        if collection == "work_orders":
+                            doc = await renumbering.apply(doc)
+                        elif collection == EVENTS_COLLECTION:
+                            doc = await _event_payloads(doc, renumbering.apply)
+                    batch.append(await _encrypted(collection, doc))
+                    read += 1
+                    if len(batch) >= RESTORE_BATCH_SIZE:
+                        inserted += await _insert(db[collection], batch)
+                        batch = []
+                if batch:
+                    inserted += await _insert(db[collection], batch)
+                result["collections"][collection] = {"documents": read, "inserted": inserted,
+                                                     "skipped": read - inserted}
+    if not dry_run and "work_orders" in result["collections"]:
+        result["read_models"] = await rebuild_read_models()
+    logger.info("backup_restored", path=path, dry_run=dry_run, id_prefix=id_prefix, drop=drop,
+                collections=len(result["collections"]))
+    return result
@@ -0,0 +1,63 @@
+import os
+from datetime import datetime, timedelta, timezone
+from typing import Optional
+
+import structlog
+from azure.storage.blob import BlobSasPermissions, ContentSettings, generate_blob_sas
+from azure.storage.blob.aio import BlobServiceClient
+
+logger = structlog.get_logger()
+
+AZURE_STORAGE_CONN_STR = os.getenv("AZURE_STORAGE_CONN_STR")
+AZURE_STORAGE_CONTAINER = os.getenv("AZURE_STORAGE_CONTAINER", "attachments")
+
+class BlobStorageError(Exception): pass
+
+def _account_credentials() -> dict:
+    """Parse AccountName/AccountKey out of the storage connection string (needed for SAS signing)."""
+    parts = dict(
+        segment.split("=", 1) for segment in (AZURE_STORAGE_CONN_STR or "").split(";") if "=" in segment
+    )
+    return {"account_name": parts.get("AccountName"), "account_key": parts.get("AccountKey")}
+
+async def upload_blob(blob_name: str, data: bytes, content_type: str, container: Optional[str] = None) -> str:
+    """
+    Uploads a file to Azure Blob Storage and returns the blob URL.
+    Requires the following environment variables:
+      - AZURE_STORAGE_CONN_STR: storage account connection string
+      - AZURE_STORAGE_CONTAINER: container name (optional, defaults to 'attachments')
+    """
+    if not AZURE_STORAGE_CONN_STR:
+        logger.error("blob_storage_not_configured")
+        raise BlobStorageError("Blob storage is not configured")
+    container = container or AZURE_STORAGE_CONTAINER
+    try:
+        async with BlobServiceClient.from_connection_string(AZURE_STORAGE_CONN_STR) as service:
+            blob_client = service.get_blob_client(container=container, blob=blob_name)
+            await blob_client.upload_blob(
+                data,
+                overwrite=True,
+                content_settings=ContentSettings(content_type=content_type)
+            )
+            logger.info("blob_uploaded", container=container, blob=blob_name, size=len(data))
+            return blob_client.url
+    except Exception as e:
+        logger.error("blob_upload_failed", container=container, blob=blob_name, error=str(e))
+        raise BlobStorageError(f"Blob upload failed: {e}") from e
+
+def generate_signed_url(blob_name: str, expiry_minutes: int = 15, container: Optional[str] = None) -> str:
+    """Returns a short-lived, read-only SAS URL for a blob."""
+    creds = _account_credentials()
+    if not creds["account_name"] or not creds["account_key"]:
+        raise BlobStorageError("Blob storage account key is not configured")
+    container = container or AZURE_STORAGE_CONTAINER
+    expiry = datetime.now(timezone.utc) + timedelta(minutes=expiry_minutes)
+    sas = generate_blob_sas(
+        account_name=creds["account_name"],
+        container_name=container,
+        blob_name=blob_name,
+        account_key=creds["account_key"],
+        permission=BlobSasPermissions(read=True),
+        expiry=expiry
+    )
+    return f"https://{creds['account_name']}.blob.core.windows.net/{container}/{blob_name}?{sas}"
@@ -0,0 +1,276 @@
+"""
+Restaurant and spa bookings. Deliberately separate from work orders: a booking takes capacity
+from a venue's slot and belongs to the guest, it isn't a task for staff.
+
+Each slot has a counter document in `booking_slots`; holding a slot increments it atomically
+against the venue's capacity, so two guests can never take the last table at once. Holds lapse
+after BOOKING_HOLD_MINUTES unless the guest confirms, and the capacity is given back.
+"""
+import os
+import re
+import uuid
+from datetime import date, datetime, time, timedelta, timezone, tzinfo
+from typing import List, Optional
+
+import structlog
+from pymongo import ReturnDocument
+from pymongo.errors import DuplicateKeyError
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import Reservation, ReservationStatusEnum, Venue, VenueTypeEnum
+
+logger = structlog.get_logger()
+
+BOOKING_HOLD_MINUTES = int(os.getenv("BOOKING_HOLD_MINUTES", "10"))
+
+BOOKING_PATTERN = re.compile(r"\b(book|reserve|reservation|table for)\b")
+SPA_PATTERN = re.compile(r"\b(spa|massage|facial|treatment)\b")
+RESTAURANT_PATTERN = re.compile(r"\b(table|restaurant|dinner|lunch|breakfast|brunch)\b")
+PARTY_PATTERN = re.compile(r"\bfor (\d{1,2}|two|three|four|five|six)\b(?! ?(?:am|pm|o'?clock|:))")
+CONFIRM_PATTERN = re.compile(r"^\s*(yes|yes please|confirm|book it|ok|okay|oui|s[ií])\b[\s.!]*$")
+NUMBER_WORDS = {"two": 2, "three": 3, "four": 4, "five": 5, "six": 6}
+
+class BookingError(Exception):
+    def __init__(self, message: str, status_code: int = 409):
+        super().__init__(message)
+        self.status_code = status_code
+
+def detect_booking(message: str) -> Optional[tuple]:
+    """Returns (venue_type, party_size) for booking requests, None otherwise."""
+    text = message.lower()
+    if not BOOKING_PATTERN.search(text):
+        return None
+    if SPA_PATTERN.search(text):
+        venue_type = VenueTypeEnum.SPA.value
+    elif RESTAURANT_PATTERN.search(text):
+        venue_type = VenueTypeEnum.RESTAURANT.value
+    else:
+        return None
+    party = PARTY_PATTERN.search(text)
+    party_size = 1 if venue_type == VenueTypeEnum.SPA.value else 2
+    if party:
+        party_size = NUMBER_WORDS.get(party.group(1)) or int(party.group(1))
+    return venue_type, party_size
+
+def pick_venue(venues: List[Venue], message: str) -> Optional[Venue]:
+    """The venue the guest named (the longest name found wins), the only one there is, or None to ask."""
+    text = message.lower()
+    named = [v for v in venues if re.search(rf"\b{re.escape(v.name.lower())}\b", text)]
+    if named:
+        return max(named, key=lambda v: len(v.name))
+    return venues[0] if len(venues) == 1 else None
+
+def slot_starts(venue: Venue, day: date, tz: tzinfo) -> List[datetime]:
+    """Bookable slot start times (UTC) for a local day; the last slot ends by closing time."""
+    opens = datetime.combine(day, time.fromisoformat(venue.opens_at), tzinfo=tz)
+    closes = datetime.combine(day, time.fromisoformat(venue.closes_at), tzinfo=tz)
+    if closes <= opens:
+        closes += timedelta(days=1)   # open past midnight
+    length = timedelta(minutes=venue.slot_minutes)
+    starts = []
+    current = opens
+    while current + length <= closes:
+        starts.append(current.astimezone(timezone.utc))
+        current += length
+    return starts
+
+def _as_utc(value: datetime) -> datetime:
+    return value.replace(tzinfo=timezone.utc) if value.tzinfo is None else value.astimezone(timezone.utc)
+
+# --- Venues ---
+
+async def list_venues(venue_type: Optional[str] = None, active_only: bool = True) -> List[Venue]:
+    query = {"active": True} if active_only else {}
+    if venue_type:
+        query["venue_type"] = venue_type
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["venues"].find(query).sort("name", 1).to_list(length=100)
+    return [Venue(**doc) for doc in docs]
+
+async def get_venue(venue_id: str) -> Venue:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["venues"].find_one({"venue_id": venue_id})
+    if not doc:
+        raise BookingError(f"Venue '{venue_id}' not found", 404)
+    return Venue(**doc)
+
+async def save_venue(venue: Venue) -> Venue:
+    data = venue.model_dump(exclude={"id", "created_at"})
+    data["updated_at"] = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["venues"].update_one(
+            {"venue_id": venue.venue_id},
+            {"$set": data, "$setOnInsert": {"created_at": datetime.now(timezone.utc)}},
+            upsert=True
+        )
+    logger.info("venue_saved", venue_id=venue.venue_id, venue_type=venue.venue_type)
+    return venue
+
+# --- Availability & holds ---
+
+async def availability(venue: Venue, day: date, tz: tzinfo) -> List[dict]:
+    starts = slot_starts(venue, day, tz)
+    if not starts:
+        return []
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["booking_slots"].find(
+            {"venue_id": venue.venue_id, "starts_at": {"$gte": starts[0], "$lte": starts[-1]}}
+        ).to_list(length=None)
+    booked = {_as_utc(d["starts_at"]): d.get("booked", 0) for d in docs}
+    return [{"starts_at": s, "available": max(venue.capacity - booked.get(s, 0), 0)} for s in starts]
+
+async def _release_capacity(conn, venue_id: str, starts_at: datetime, party_size: int) -> None:
+    await conn["virtualbutler"]["booking_slots"].update_one(
+        {"venue_id": venue_id, "starts_at": starts_at}, {"$inc": {"booked": -party_size}}
+    )
+
+async def hold_slot(venue: Venue, guest_id: str, starts_at: datetime, party_size: int, tz: tzinfo,
+                    room_number: Optional[str] = None, language: str = "en",
+                    notes: Optional[str] = None) -> Reservation:
+    """Takes capacity for the slot and records a held reservation; raises BookingError if it doesn't fit."""
+    starts_at = _as_utc(starts_at)
+    if party_size > venue.capacity:
+        raise BookingError(f"{venue.name} can take at most {venue.capacity} guests per booking", 422)
+    if starts_at <= datetime.now(timezone.utc):
+        raise BookingError("That time has already passed", 422)
+    if starts_at not in slot_starts(venue, starts_at.astimezone(tz).date(), tz):
+        raise BookingError(f"{venue.name} isn't taking bookings at that time", 422)
+    async with DatabaseConnection.get_connection() as conn:
+        try:
+            # The unique (venue_id, starts_at) index turns a full slot's failed match into a
+            # DuplicateKeyError instead of a second counter document
+            await conn["virtualbutler"]["booking_slots"].find_one_and_update(
+                {"venue_id": venue.venue_id, "starts_at": starts_at, "booked": {"$lte": venue.capacity - party_size}},
+                {"$inc": {"booked": party_size}},
+                upsert=True,
+                return_document=ReturnDocument.AFTER
+            )
+        except DuplicateKeyError:
+            raise BookingError("That time is fully booked")
+        now = datetime.now(timezone.utc)
+        reservation = Reservation(
+            reservation_id=f"res_{uuid.uuid4().hex[:12]}",
+            venue_id=venue.venue_id,
+            venue_name=venue.name,
+            venue_type=venue.venue_type,
+            guest_id=guest_id,
+            room_number=room_number,
+            party_size=party_size,
+            starts_at=starts_at,
+            ends_at=starts_at + timedelta(minutes=venue.slot_minutes),
+            hold_expires_at=now + timedelta(minutes=BOOKING_HOLD_MINUTES),
+            language=language,
+            notes=notes
+        )
+        # A guest holds one slot at a time while deciding
+        for previous in await conn["virtualbutler"]["reservations"].find(
+                {"guest_id": guest_id, "status": ReservationStatusEnum.HELD}).to_list(length=None):
+            await _end_reservation(conn, previous, ReservationStatusEnum.CANCELLED)
+        await conn["virtualbutler"]["reservations"].insert_one(reservation.model_dump(exclude={"id"}))
+    logger.info("reservation_held", reservation_id=reservation.reservation_id, venue_id=venue.venue_id,
+                starts_at=starts_at.isoformat(), party_size=party_size)
+    return reservation
+
+async def _end_reservation(conn, doc: dict, status: ReservationStatusEnum) -> Optional[dict]:
+    """Moves a held/confirmed reservation to cancelled/expired and gives its capacity back (once)."""
+    ended = await conn["virtualbutler"]["reservations"].find_one_and_update(
+        {"reservation_id": doc["reservation_id"],
+         "status": {"$in": [ReservationStatusEnum.HELD, ReservationStatusEnum.CONFIRMED]}},
+        {"$set": {"status": status, "updated_at": datetime.now(timezone.utc)}},
+        return_document=ReturnDocument.AFTER
+    )
+    if ended:
+        await _release_capacity(conn, doc["venue_id"], _as_utc(doc["starts_at"]), doc["party_size"])
+    return ended
+
+async def get_reservation(reservation_id: str, guest_id: Optional[str] = None) -> dict:
+    query = {"reservation_id": reservation_id}
+    if guest_id:
+        query["guest_id"] = guest_id
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["reservations"].find_one(query)
+    if not doc:
+        raise BookingError("Reservation not found", 404)
+    return doc
+
+async def get_held_reservation(guest_id: str) -> Optional[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["reservations"].find_one(
+            {"guest_id": guest_id, "status": ReservationStatusEnum.HELD,
+             "hold_expires_at": {"$gt": datetime.now(timezone.utc)}}
+        )
+
+async def list_reservations(guest_id: Optional[str] = None, venue_id: Optional[str] = None,
+                            day_start: Optional[datetime] = None, day_end: Optional[datetime] = None) -> List[Reservation]:
+    query = {}
+    if guest_id:
+        query["guest_id"] = guest_id
+    if venue_id:
+        query["venue_id"] = venue_id
+    if day_start and day_end:
+        query["starts_at"] = {"$gte": day_start, "$lt": day_end}
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["reservations"].find(query).sort("starts_at", 1).to_list(length=500)
+    return [Reservation(**doc) for doc in docs]
+
+async def confirm_reservation(reservation_id: str, guest_id: str) -> dict:
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["reservations"].find_one_and_update(
+            {"reservation_id": reservation_id, "guest_id": guest_id, "status": ReservationStatusEnum.HELD,
+             "hold_expires_at": {"$gt": now}},
+            {"$set": {"status": ReservationStatusEnum.CONFIRMED, "confirmed_at": now, "updated_at": now},
+             "$unset": {"hold_expires_at": ""}},
+            return_document=ReturnDocument.AFTER
+        )
+    if not doc:
+        raise BookingError("The hold on this booking has expired or it was already confirmed")
+    logger.info("reservation_confirmed", reservation_id=reservation_id, guest_id=guest_id)
+    return doc
+
+async def cancel_reservation(reservation_id: str, guest_id: Optional[str] = None) -> dict:
+    doc = await get_reservation(reservation_id, guest_id)
+    async with DatabaseConnection.get_connection() as conn:
+        ended = await _end_reservation(conn, doc, ReservationStatusEnum.CANCELLED)
+    if not ended:
+        raise BookingError(f"Reservation is already {doc['status']}")
+    logger.info("reservation_cancelled", reservation_id=reservation_id, guest_id=guest_id)
+    return ended
+
+async def release_expired_holds() -> int:
+    released = 0
+    async with DatabaseConnection.get_connection() as conn:
+        async for doc in conn["virtualbutler"]["reservations"].find(
+                {"status": ReservationStatusEnum.HELD, "hold_expires_at": {"$lte": datetime.now(timezone.utc)}}):
+            if await _end_reservation(conn, doc, ReservationStatusEnum.EXPIRED):
+                released += 1
+    if released:
+        logger.info("reservation_holds_expired", count=released)
+    return released
+
+def reservation_ics(reservation: dict) -> str:
+    """A single-event iCalendar file so the guest can add the booking to their calendar."""
+    def stamp(value: datetime) -> str:
+        return _as_utc(value).strftime("%Y%m%dT%H%M%SZ")
+    return "\r\n".join([
+        "BEGIN:VCALENDAR",
+        "VERSION:2.0",
+        "PRODID:-//Virtual Butler//Reservations//EN",
+        "BEGIN:VEVENT",
+        f"UID:{reservation['reservation_id']}@virtualbutler",
+        f"DTSTAMP:{stamp(reservation.get('confirmed_at') or datetime.now(timezone.utc))}",
+        f"DTSTART:{stamp(reservation['starts_at'])}",
+        f"DTEND:{stamp(reservation['ends_at'])}",
+        f"SUMMARY:{reservation['venue_name']} ({reservation['party_size']})",
+        "END:VEVENT",
+        "END:VCALENDAR",
+        ""
+    ])
+
+async def ensure_booking_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["venues"].create_index("venue_id", unique=True)
+        await conn["virtualbutler"]["booking_slots"].create_index([("venue_id", 1), ("starts_at", 1)], unique=True)
+        await conn["virtualbutler"]["reservations"].create_index("reservation_id", unique=True)
+        await conn["virtualbutler"]["reservations"].create_index([("guest_id", 1), ("status", 1)])
+        await conn["virtualbutler"]["reservations"].create_index([("status", 1), ("hold_expires_at", 1)])
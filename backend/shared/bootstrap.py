@@ -0,0 +1,67 @@
+"""
+What the guest app needs on launch, gathered for GET /api/v1/bootstrap so a cold start is one round
+trip instead of six.
+
+Open requests include those the chatbot has accepted but that don't have a work order yet, reported
+as "queued", the same as the status endpoint does.
+"""
+import os
+from typing import Dict, List, Optional, Set
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import StatusEnum
+from shared.quotas import OPEN_STATUSES
+
+HOTEL_ID = os.getenv("HOTEL_ID", "default")
+HOTEL_NAME = os.getenv("HOTEL_NAME", "Virtual Butler Hotel")
+HOTEL_FRONT_DESK_PHONE = os.getenv("HOTEL_FRONT_DESK_PHONE")
+HOTEL_CHECKOUT_TIME = os.getenv("HOTEL_CHECKOUT_TIME", "11:00")
+BOOTSTRAP_NOTIFICATION_LIMIT = int(os.getenv("BOOTSTRAP_NOTIFICATION_LIMIT", "20"))
+
+QUEUED_STATUS = "queued"
+REQUEST_FIELDS = {"_id": 0, "request_id": 1, "work_order_id": 1, "order_number": 1, "department": 1, "status": 1,
+                  "priority": 1, "description": 1, "created_at": 1, "updated_at": 1}
+
+def hotel_info(timezone: str, languages: List[str], utc_offset: Optional[str] = None) -> dict:
+    return {"hotel_id": HOTEL_ID, "name": HOTEL_NAME, "timezone": timezone, "utc_offset": utc_offset,
+            "front_desk_phone": HOTEL_FRONT_DESK_PHONE,
+            "checkout_time": HOTEL_CHECKOUT_TIME, "languages": sorted(languages)}
+
+def merge_open_requests(orders: List[dict], chat_requests: List[dict], ordered: Set[str]) -> List[dict]:
+    """
+    Open work orders, plus pending chat requests that never got an order (`ordered` holds the request IDs
+    that did, open or not) standing in as queued. Newest first.
+    """
+    queued = [{"request_id": c["request_id"], "work_order_id": None, "order_number": None,
+               "department": c.get("department"), "status": QUEUED_STATUS, "priority": c.get("priority"),
+               "description": c.get("message"), "created_at": c.get("created_at"),
+               "updated_at": c.get("updated_at") or c.get("created_at")}
+              for c in chat_requests if c["request_id"] not in ordered]
+    return sorted([*orders, *queued], key=lambda r: r.get("created_at") or 0, reverse=True)
+
+async def open_requests(guest_id: str) -> List[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        orders = await db["work_orders"].find(
+            {"guest_id": guest_id, "parent_id": None, "status": {"$in": OPEN_STATUSES}}, REQUEST_FIELDS
+        ).to_list(length=None)
+        chat_requests = await db["chat_requests"].find(
+            {"guest_id": guest_id, "status": {"$in": [StatusEnum.PENDING, None]}},
+            {"_id": 0, "request_id": 1, "department": 1, "priority": 1, "message": 1, "created_at": 1, "updated_at": 1}
+        ).to_list(length=None)
+        ordered = await db["work_orders"].distinct(
+            "request_id", {"request_id": {"$in": [c["request_id"] for c in chat_requests]}}
+        ) if chat_requests else []
+    return merge_open_requests(orders, chat_requests, set(ordered))
+
+async def unread_notifications(guest_id: str, limit: int = BOOTSTRAP_NOTIFICATION_LIMIT) -> Dict[str, object]:
+    query = {"guest_id": guest_id, "read": {"$ne": True}}
+    async with DatabaseConnection.get_connection() as conn:
+        notifications = conn["virtualbutler"]["notifications"]
+        count = await notifications.count_documents(query)
+        latest = await notifications.find(query, {"_id": 0}).sort("created_at", -1).limit(limit).to_list(length=None)
+    return {"count": count, "items": latest}
+
+async def guest_profile(guest_id: str) -> Optional[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["guest_profiles"].find_one({"guest_id": guest_id}, {"_id": 0})
@@ -0,0 +1,192 @@
+"""
+Per-department working-hours calendars, so SLA clocks only run while a team is working.
+
+A calendar lists opening windows per weekday, in the department's timezone (HOTEL_TIMEZONE by
+default), and holidays on which the department is closed all day. A window whose close is at or
+before its open runs past midnight ("22:00"-"02:00"); "24:00" closes at midnight. Departments
+without a calendar are open around the clock, as before.
+
+Used by the SLA engine (breach checks, report figures and dashboard deadlines count working minutes
+only), the preventive-maintenance scheduler (no orders generated while maintenance is closed) and
+the chatbot (tells guests when a closed team will pick their request up, and moves scheduled
+requests to the next opening).
+"""
+import os
+import time
+from datetime import date, datetime, timedelta, timezone
+from enum import Enum
+from typing import Dict, Iterator, List, Optional, Tuple
+from zoneinfo import ZoneInfo, ZoneInfoNotFoundError
+
+import structlog
+from pydantic import BaseModel, Field
+
+from shared.clock import HOTEL_TIMEZONE, local_time
+from shared.db.database import DatabaseConnection
+from shared.db.models import DepartmentEnum
+
+logger = structlog.get_logger()
+
+BUSINESS_HOURS_CACHE_SECONDS = float(os.getenv("BUSINESS_HOURS_CACHE_SECONDS", "60"))
+# How far ahead to look for an opening before giving up on a calendar
+SEARCH_DAYS = 366
+
+class WeekdayEnum(str, Enum):
+    MON = "mon"
+    TUE = "tue"
+    WED = "wed"
+    THU = "thu"
+    FRI = "fri"
+    SAT = "sat"
+    SUN = "sun"
+
+WEEKDAYS = list(WeekdayEnum)
+
+class BusinessHoursError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+class OpeningWindow(BaseModel):
+    open: str = Field(..., pattern=r"^([01]\d|2[0-3]):[0-5]\d$")
+    close: str = Field(..., pattern=r"^(([01]\d|2[0-3]):[0-5]\d|24:00)$")
+
+class Holiday(BaseModel):
+    date: date
+    name: Optional[str] = None
+
+class BusinessCalendar(BaseModel):
+    department: DepartmentEnum
+    timezone: str = HOTEL_TIMEZONE
+    hours: Dict[WeekdayEnum, List[OpeningWindow]] = Field(..., description="Days left out are closed")
+    holidays: List[Holiday] = Field(default_factory=list)
+
+def _minutes(hhmm: str) -> int:
+    hours, minutes = hhmm.split(":")
+    return int(hours) * 60 + int(minutes)
+
+def _aware(moment: datetime) -> datetime:
+    return moment.replace(tzinfo=timezone.utc) if moment.tzinfo is None else moment
+
+def validate_calendar(calendar: BusinessCalendar) -> None:
+    try:
+        ZoneInfo(calendar.timezone)
+    except (ZoneInfoNotFoundError, ValueError):
+        raise BusinessHoursError(f"Unknown timezone '{calendar.timezone}'", 422)
+    if not any(calendar.hours.values()):
+        raise BusinessHoursError("A calendar needs at least one opening window", 422)
+
+def windows_on(calendar: BusinessCalendar, day: date) -> List[Tuple[datetime, datetime]]:
+    """Opening windows starting on local `day`, as UTC (start, end) pairs."""
+    if any(h.date == day for h in calendar.holidays):
+        return []
+    tz = ZoneInfo(calendar.timezone)
+    windows = []
+    for window in calendar.hours.get(WEEKDAYS[day.weekday()], []):
+        opens, closes = _minutes(window.open), _minutes(window.close)
+        if closes <= opens:
+            closes += 24 * 60
+        start = datetime.combine(day, datetime.min.time(), tz) + timedelta(minutes=opens)
+        end = datetime.combine(day, datetime.min.time(), tz) + timedelta(minutes=closes)
+        windows.append((start.astimezone(timezone.utc), end.astimezone(timezone.utc)))
+    return sorted(windows)
+
+def _windows_from(calendar: BusinessCalendar, moment: datetime, days: int = SEARCH_DAYS) -> Iterator[Tuple[datetime, datetime]]:
+    # Start a day early: last night's window may still be open
+    first = moment.astimezone(ZoneInfo(calendar.timezone)).date() - timedelta(days=1)
+    for offset in range(days + 1):
+        for start, end in windows_on(calendar, first + timedelta(days=offset)):
+            if end > moment:
+                yield start, end
+
+def is_open(calendar: Optional[BusinessCalendar], moment: datetime) -> bool:
+    if calendar is None:
+        return True
+    moment = _aware(moment)
+    return any(start <= moment for start, _ in _windows_from(calendar, moment, days=1))
+
+def next_open(calendar: Optional[BusinessCalendar], moment: datetime) -> Optional[datetime]:
+    """`moment` itself if open, else the start of the next window; None if nothing opens within a year."""
+    moment = _aware(moment)
+    if calendar is None:
+        return moment
+    for start, _ in _windows_from(calendar, moment):
+        return max(start, moment)
+    return None
+
+def business_minutes_between(calendar: Optional[BusinessCalendar], start: datetime, end: datetime) -> float:
+    start, end = _aware(start), _aware(end)
+    if end <= start:
+        return 0.0
+    if calendar is None:
+        return (end - start).total_seconds() / 60
+    days = (end - start).days + 2
+    total = 0.0
+    for opens, closes in _windows_from(calendar, start, days):
+        if opens >= end:
+            break
+        total += (min(closes, end) - max(opens, start)).total_seconds() / 60
+    return total
+
+def add_business_minutes(calendar: Optional[BusinessCalendar], start: datetime, minutes: float) -> Optional[datetime]:
+    """When `minutes` of working time will have passed after `start`; None if the calendar never gets there."""
+    start = _aware(start)
+    if calendar is None:
+        return start + timedelta(minutes=minutes)
+    remaining = timedelta(minutes=minutes)
+    for opens, closes in _windows_from(calendar, start):
+        opens = max(opens, start)
+        if closes - opens >= remaining:
+            return opens + remaining
+        remaining -= closes - opens
+    return None
+
+def to_local(calendar: Optional[BusinessCalendar], moment: datetime) -> datetime:
+    return local_time(moment, ZoneInfo(calendar.timezone) if calendar else None)
+
+# --- Storage ---
+
+async def list_calendars() -> List[BusinessCalendar]:
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["business_calendars"].find({}, {"_id": 0}).sort("department", 1).to_list(length=None)
+    return [BusinessCalendar(**doc) for doc in docs]
+
+async def save_calendar(calendar: BusinessCalendar) -> BusinessCalendar:
+    validate_calendar(calendar)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["business_calendars"].replace_one(
+            {"department": calendar.department.value}, calendar.model_dump(mode="json"), upsert=True
+        )
+    business_calendars.invalidate()
+    logger.info("business_calendar_saved", department=calendar.department.value, timezone=calendar.timezone)
+    return calendar
+
+async def delete_calendar(department: str) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["business_calendars"].delete_one({"department": department})
+    if not result.deleted_count:
+        raise BusinessHoursError("No calendar for that department", 404)
+    business_calendars.invalidate()
+    logger.info("business_calendar_deleted", department=department)
+
+class BusinessCalendars:
+    """Calendars by department, reloaded every BUSINESS_HOURS_CACHE_SECONDS (other replicas see edits within that)."""
+
+    def __init__(self):
+        self.calendars: Dict[str, BusinessCalendar] = {}
+        self.loaded_at = 0.0
+
+    def invalidate(self) -> None:
+        self.loaded_at = 0.0
+
+    async def get(self, department: str) -> Optional[BusinessCalendar]:
+        if time.monotonic() - self.loaded_at > BUSINESS_HOURS_CACHE_SECONDS:
+            self.calendars = {c.department.value: c for c in await list_calendars()}
+            self.loaded_at = time.monotonic()
+        return self.calendars.get(DepartmentEnum(department).value)
+
+business_calendars = BusinessCalendars()
+
+async def ensure_business_hours_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["business_calendars"].create_index("department", unique=True)
@@ -0,0 +1,139 @@
+"""
+Per-department load, so the chatbot can set expectations before it acknowledges a request.
+
+A new order's expected wait is the department's open queue divided by its recent throughput (orders
+completed in the last CAPACITY_WINDOW_MINUTES), or DEPARTMENT_THROUGHPUT_PER_HOUR when nothing was
+completed recently. The wait is compared with the department's SLA target (shared.reporting):
+- busy: at least CAPACITY_BUSY_FRACTION of the target; the chatbot quotes the wait,
+- overloaded: at or past the target; non-urgent requests are deferred too (tagged `deferred` and held
+  back until the quoted wait is nearly up, like a scheduled request, keeping their priority) and the
+  guest is told so.
+
+The work-order service measures it (GET /internal/capacity, cached for CAPACITY_CACHE_SECONDS) and
+keeps the butler_department_queue_depth and butler_department_wait_minutes gauges current.
+"""
+import json
+import math
+import os
+import time
+from datetime import datetime, timedelta
+from enum import Enum
+from typing import Dict, List, Optional
+
+import httpx
+import structlog
+
+from shared import metrics
+from shared.db.database import DatabaseConnection
+from shared.db.models import DepartmentEnum, StatusEnum
+from shared.reporting import sla_minutes
+
+logger = structlog.get_logger()
+
+CAPACITY_WINDOW_MINUTES = int(os.getenv("CAPACITY_WINDOW_MINUTES", "60"))
+CAPACITY_CACHE_SECONDS = float(os.getenv("CAPACITY_CACHE_SECONDS", "15"))
+CAPACITY_BUSY_FRACTION = float(os.getenv("CAPACITY_BUSY_FRACTION", "0.75"))
+# Orders a department gets through per hour when there's no recent history to go on.
+# Override with DEPARTMENT_THROUGHPUT_PER_HOUR='{"housekeeping": 20}'.
+DEFAULT_THROUGHPUT_PER_HOUR = 6.0
+DEPARTMENT_THROUGHPUT_PER_HOUR: Dict[str, float] = {
+    DepartmentEnum.HOUSEKEEPING.value: 12.0,
+    DepartmentEnum.MAINTENANCE.value: 4.0,
+    DepartmentEnum.ROOM_SERVICE.value: 10.0,
+    **json.loads(os.getenv("DEPARTMENT_THROUGHPUT_PER_HOUR", "{}"))
+}
+DEFERRED_TAG = "deferred"
+# Never held back however busy they are
+NEVER_DEFERRED = {DepartmentEnum.SECURITY.value}
+QUEUED_STATUSES = [StatusEnum.PENDING, StatusEnum.ASSIGNED, StatusEnum.IN_PROGRESS]
+
+class LoadLevelEnum(str, Enum):
+    NORMAL = "normal"
+    BUSY = "busy"
+    OVERLOADED = "overloaded"
+
+def department_load(department: str, queue_depth: int, completed_in_window: int,
+                    window_minutes: int = CAPACITY_WINDOW_MINUTES) -> dict:
+    throughput = completed_in_window * 60 / window_minutes or DEPARTMENT_THROUGHPUT_PER_HOUR.get(
+        department, DEFAULT_THROUGHPUT_PER_HOUR)
+    wait = math.ceil(queue_depth / throughput * 60) if queue_depth else 0
+    target = sla_minutes(department)
+    if wait >= target:
+        level = LoadLevelEnum.OVERLOADED
+    elif wait >= target * CAPACITY_BUSY_FRACTION:
+        level = LoadLevelEnum.BUSY
+    else:
+        level = LoadLevelEnum.NORMAL
+    return {
+        "department": department,
+        "queue_depth": queue_depth,
+        "throughput_per_hour": round(throughput, 1),
+        "estimated_wait_minutes": wait,
+        "sla_target_minutes": target,
+        "level": level.value,
+    }
+
+def should_defer(load: Optional[dict], sentiment: Optional[float] = None, vip: bool = False) -> bool:
+    """Overloaded departments defer requests from guests who aren't VIPs or already unhappy."""
+    if not load or load["level"] != LoadLevelEnum.OVERLOADED or load["department"] in NEVER_DEFERRED:
+        return False
+    return not vip and (sentiment is None or sentiment >= 0)
+
+async def measure_capacity(now: datetime) -> List[dict]:
+    """Current load for every department (top-level orders only; subtasks are staff bookkeeping)."""
+    async with DatabaseConnection.get_connection() as conn:
+        orders = conn["virtualbutler"]["work_orders"]
+        queued = await orders.aggregate([
+            {"$match": {"status": {"$in": QUEUED_STATUSES}, "parent_id": None}},
+            {"$group": {"_id": "$department", "count": {"$sum": 1}}}
+        ]).to_list(length=None)
+        completed = await orders.aggregate([
+            {"$match": {"status": StatusEnum.COMPLETED, "parent_id": None,
+                        "completed_at": {"$gte": now - timedelta(minutes=CAPACITY_WINDOW_MINUTES)}}},
+            {"$group": {"_id": "$department", "count": {"$sum": 1}}}
+        ]).to_list(length=None)
+    queued_by = {d["_id"]: d["count"] for d in queued}
+    completed_by = {d["_id"]: d["count"] for d in completed}
+    loads = [department_load(d.value, queued_by.get(d.value, 0), completed_by.get(d.value, 0)) for d in DepartmentEnum]
+    for load in loads:
+        metrics.set_gauge("butler_department_queue_depth", load["queue_depth"], department=load["department"])
+        metrics.set_gauge("butler_department_wait_minutes", load["estimated_wait_minutes"], department=load["department"])
+    return loads
+
+class CapacityMonitor:
+    """Work-order side: caches the measurement so a burst of chats costs one pair of aggregations."""
+
+    def __init__(self):
+        self.loads: List[dict] = []
+        self.measured_at = 0.0
+
+    async def current(self, now: datetime) -> List[dict]:
+        if time.monotonic() - self.measured_at > CAPACITY_CACHE_SECONDS:
+            self.loads = await measure_capacity(now)
+            self.measured_at = time.monotonic()
+        return self.loads
+
+class CapacityClient:
+    """Chatbot side: reads /internal/capacity; any failure means no expectation-setting, never a blocked chat."""
+
+    def __init__(self, url: str, token: Optional[str], client):
+        self.url = url
+        self.token = token
+        self.client = client
+        self.loads: Dict[str, dict] = {}
+        self.fetched_at = 0.0
+
+    async def load_for(self, department: str) -> Optional[dict]:
+        if not self.token:
+            return None
+        if time.monotonic() - self.fetched_at > CAPACITY_CACHE_SECONDS:
+            try:
+                response = await self.client.get(self.url, headers={"X-Internal-Token": self.token})
+                response.raise_for_status()
+                self.loads = {d["department"]: d for d in response.json()["departments"]}
+            except (httpx.HTTPError, KeyError, ValueError) as e:
+                logger.warning("capacity_lookup_failed", error=str(e))
+                self.loads = {}
+            # Failures are cached too, so an unreachable service isn't called on every chat
+            self.fetched_at = time.monotonic()
+        return self.loads.get(department)
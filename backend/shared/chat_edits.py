@@ -0,0 +1,150 @@
+"""
+Guest edits and recalls of a just-sent request.
+
+A request can be reworded or withdrawn for CHAT_EDIT_GRACE_SECONDS after it was sent, as long as nobody
+has started on it: either there is no work order yet (the message is still on the queue, or scheduled for
+later) or the order is still pending. An edit rewrites the request and the order's description and line
+items, keeping each earlier wording in `edit_history`; a recall cancels both. The new wording's sentiment
+is scored again, so the priority follows it, but it must still be for the same team: an edit that reads
+as another department's job is refused, and the guest recalls the request and sends a new one instead.
+When the order is only created afterwards, the work-order consumer reads the request's current state
+(current_request()) and uses the edited wording, or skips a recalled request.
+"""
+import os
+from datetime import datetime, timedelta, timezone
+from typing import List, Optional, Tuple
+
+import structlog
+from pymongo import ReturnDocument
+
+from shared.clock import as_utc
+from shared.db.database import DatabaseConnection
+from shared.db.models import PriorityEnum, StatusEnum
+from shared.loyalty import boost_priority
+from shared.sentiment import priority_for_sentiment, score_sentiment
+
+logger = structlog.get_logger()
+
+CHAT_EDIT_GRACE_SECONDS = int(os.getenv("CHAT_EDIT_GRACE_SECONDS", "120"))
+RECALLED_TAG = "recalled"
+
+class ChatEditError(Exception):
+    def __init__(self, message: str, status_code: int = 409):
+        super().__init__(message)
+        self.status_code = status_code
+
+def check_editable(chat_doc: dict, work_order: Optional[dict], now: datetime,
+                   grace_seconds: int = CHAT_EDIT_GRACE_SECONDS) -> None:
+    """Raises ChatEditError unless the request may still be edited or recalled."""
+    if RECALLED_TAG in chat_doc.get("tags", []):
+        raise ChatEditError("Request was already recalled")
+    if chat_doc.get("status") != StatusEnum.PENDING:
+        # Direct actions (DND, bookings, ...) are done the moment they are sent
+        raise ChatEditError("Only requests waiting for staff can be changed")
+    if as_utc(chat_doc["created_at"]) + timedelta(seconds=grace_seconds) < now:
+        raise ChatEditError(f"Requests can only be changed within {grace_seconds} seconds of sending")
+    if work_order and work_order.get("status") != StatusEnum.PENDING:
+        raise ChatEditError("Staff are already handling this request")
+
+def check_same_department(chat_doc: dict, department: Optional[str]) -> None:
+    """`department` is where the new wording would be routed; None when the rules can't tell."""
+    current = chat_doc.get("department")
+    if department and current and str(getattr(current, "value", current)) != str(department):
+        raise ChatEditError("That reads as a different request; recall this one and send a new message", 422)
+
+def edited_priority(chat_doc: dict, work_order: dict, sentiment: Optional[float]) -> str:
+    """The order's priority as the work-order service would have set it from the new wording."""
+    base = (chat_doc.get("metadata") or {}).get("priority") or PriorityEnum.MEDIUM.value
+    return boost_priority(priority_for_sentiment(base, sentiment), work_order.get("loyalty_tier"))
+
+async def load_request(request_id: str, guest_id: str) -> Tuple[dict, Optional[dict]]:
+    async with DatabaseConnection.get_connection() as conn:
+        chat_doc = await conn["virtualbutler"]["chat_requests"].find_one({"request_id": request_id,
+                                                                           "guest_id": guest_id})
+        if not chat_doc:
+            raise ChatEditError("Request not found", 404)
+        work_order = await conn["virtualbutler"]["work_orders"].find_one({"request_id": request_id})
+    return chat_doc, work_order
+
+async def record_guest_activity(work_order_id: str, action: str, guest_id: str, changes: dict):
+    """Same shape as the work-order service's activity log, so the change shows up in the order's history."""
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["work_order_activity"].insert_one({
+            "work_order_id": work_order_id,
+            "action": action,
+            "actor": guest_id,
+            "changes": changes,
+            "reason": None,
+            "timestamp": datetime.now(timezone.utc)
+        })
+
+async def edit_request(request_id: str, guest_id: str, text: str, line_items: List[dict],
+                       department: Optional[str] = None) -> dict:
+    """Rewords a pending request (and its order); returns the updated chat request."""
+    now = datetime.now(timezone.utc)
+    chat_doc, work_order = await load_request(request_id, guest_id)
+    check_editable(chat_doc, work_order, now)
+    check_same_department(chat_doc, department)
+    sentiment = await score_sentiment(text, chat_doc.get("language") or "en")
+    entry = {"message": chat_doc["message"], "edited_at": now}
+    changes = {"description": {"from": work_order.get("description"), "to": text[:500]}} if work_order else {}
+    async with DatabaseConnection.get_connection() as conn:
+        if work_order:
+            priority = edited_priority(chat_doc, work_order, sentiment)
+            if priority != work_order.get("priority"):
+                changes["priority"] = {"from": work_order.get("priority"), "to": priority}
+            # The order goes first: if staff picked it up meanwhile, nothing changes
+            result = await conn["virtualbutler"]["work_orders"].update_one(
+                {"work_order_id": work_order["work_order_id"], "status": StatusEnum.PENDING},
+                {"$set": {"description": text[:500], "line_items": line_items, "priority": priority,
+                          "metadata.sentiment": sentiment, "updated_at": now},
+                 "$push": {"metadata.edit_history": entry}, "$inc": {"version": 1}}
+            )
+            if not result.modified_count:
+                raise ChatEditError("Staff are already handling this request")
+        doc = await conn["virtualbutler"]["chat_requests"].find_one_and_update(
+            {"request_id": request_id},
+            {"$set": {"message": text, "metadata.line_items": line_items, "sentiment": sentiment, "updated_at": now},
+             "$push": {"edit_history": entry}},
+            return_document=ReturnDocument.AFTER
+        )
+    if work_order:
+        await record_guest_activity(work_order["work_order_id"], "edited_by_guest", guest_id, changes)
+    logger.info("chat_request_edited", request_id=request_id, guest_id=guest_id,
+                work_order_id=work_order and work_order["work_order_id"])
+    return doc
+
+async def recall_request(request_id: str, guest_id: str) -> Tuple[dict, Optional[dict]]:
+    """Withdraws a pending request; returns it and the cancelled work order, if there was one yet."""
+    now = datetime.now(timezone.utc)
+    chat_doc, work_order = await load_request(request_id, guest_id)
+    check_editable(chat_doc, work_order, now)
+    async with DatabaseConnection.get_connection() as conn:
+        if work_order:
+            work_order = await conn["virtualbutler"]["work_orders"].find_one_and_update(
+                {"work_order_id": work_order["work_order_id"], "status": StatusEnum.PENDING},
+                {"$set": {"status": StatusEnum.CANCELLED, "updated_at": now,
+                          "metadata.cancel_reason": "recalled_by_guest"}, "$inc": {"version": 1}},
+                return_document=ReturnDocument.AFTER
+            )
+            if not work_order:
+                raise ChatEditError("Staff are already handling this request")
+        doc = await conn["virtualbutler"]["chat_requests"].find_one_and_update(
+            {"request_id": request_id},
+            {"$set": {"status": StatusEnum.CANCELLED, "metadata.recalled_at": now, "updated_at": now},
+             "$addToSet": {"tags": RECALLED_TAG}},
+            return_document=ReturnDocument.AFTER
+        )
+    if work_order:
+        await record_guest_activity(work_order["work_order_id"], "recalled_by_guest", guest_id,
+                                    {"status": {"from": StatusEnum.PENDING, "to": StatusEnum.CANCELLED}})
+    logger.info("chat_request_recalled", request_id=request_id, guest_id=guest_id,
+                work_order_id=work_order and work_order["work_order_id"])
+    return doc, work_order
+
+async def current_request(request_id: str) -> Optional[dict]:
+    """The parts of a chat request a guest may have changed since it was queued."""
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["chat_requests"].find_one(
+            {"request_id": request_id}, {"message": 1, "tags": 1, "sentiment": 1, "metadata.line_items": 1, "edit_history": 1}
+        )
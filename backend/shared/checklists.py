@@ -0,0 +1,105 @@
+"""
+Step checklists for order types that need more than "done" (a deep clean is eight steps).
+
+Admins keep one template per order type. The type is a tag or quick action on the order (staff tag
+`deep_clean`, or the guest's quick action), optionally narrowed to a department. When an order of that
+type is created the template's steps are copied onto it; staff can also attach one by hand later.
+Template edits never touch checklists already on orders. Staff tick steps off as they go, and an order
+can't be completed while a required step is open.
+"""
+from datetime import datetime, timezone
+from typing import List, Optional, Set
+
+import structlog
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import ChecklistTemplate, StatusEnum
+
+logger = structlog.get_logger()
+
+class ChecklistError(Exception):
+    def __init__(self, message: str, status_code: int = 409):
+        super().__init__(message)
+        self.status_code = status_code
+
+def order_types(work_order: dict) -> Set[str]:
+    metadata = work_order.get("metadata") or {}
+    # Chat orders keep the guest's tags in metadata; `tags` are the ones staff set
+    return {*work_order.get("tags", []), *metadata.get("tags", []), metadata.get("quick_action")} - {None}
+
+def template_for(work_order: dict, templates: List[dict]) -> Optional[dict]:
+    types = order_types(work_order)
+    department = str(getattr(work_order.get("department"), "value", work_order.get("department")))
+    for template in sorted(templates, key=lambda t: t["order_type"]):
+        if not template.get("active", True) or template["order_type"] not in types:
+            continue
+        if template.get("department") in (None, department):
+            return template
+    return None
+
+def instantiate(template: dict) -> dict:
+    return {
+        "order_type": template["order_type"],
+        "name": template["name"],
+        "steps": [{"step_id": f"step_{i}", "title": step["title"], "required": step.get("required", True),
+                   "done": False, "done_by": None, "done_at": None}
+                  for i, step in enumerate(template["steps"], 1)],
+    }
+
+def tick(checklist: Optional[dict], step_id: str, done: bool, staff_id: str, now: datetime) -> dict:
+    """The step after ticking (or unticking) it."""
+    step = next((s for s in (checklist or {}).get("steps", []) if s["step_id"] == step_id), None)
+    if not step:
+        raise ChecklistError(f"Checklist step '{step_id}' not found", 404)
+    if done:
+        return {**step, "done": True, "done_by": staff_id, "done_at": now}
+    return {**step, "done": False, "done_by": None, "done_at": None}
+
+def open_required_steps(checklist: Optional[dict]) -> List[str]:
+    return [s["title"] for s in (checklist or {}).get("steps", []) if s.get("required", True) and not s.get("done")]
+
+def check_completion(work_order: dict, new_status: Optional[str]) -> None:
+    """Raises ChecklistError when the order is being completed with required steps still open."""
+    if new_status != StatusEnum.COMPLETED:
+        return
+    remaining = open_required_steps(work_order.get("checklist"))
+    if remaining:
+        raise ChecklistError(f"{len(remaining)} required checklist step(s) still open: {', '.join(remaining)}")
+
+async def list_templates(active_only: bool = True) -> List[ChecklistTemplate]:
+    query = {"active": True} if active_only else {}
+    async with DatabaseConnection.get_connection() as conn:
+        cursor = conn["virtualbutler"]["checklist_templates"].find(query, {"_id": 0}).sort("order_type", 1)
+        return [ChecklistTemplate(**doc) async for doc in cursor]
+
+async def save_template(template: ChecklistTemplate) -> ChecklistTemplate:
+    data = template.model_dump(exclude={"id", "created_at"})
+    data["updated_at"] = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["checklist_templates"].update_one(
+            {"order_type": template.order_type},
+            {"$set": data, "$setOnInsert": {"created_at": datetime.now(timezone.utc)}},
+            upsert=True
+        )
+    logger.info("checklist_template_saved", order_type=template.order_type, steps=len(template.steps))
+    return template
+
+async def deactivate_template(order_type: str) -> None:
+    """Orders that already carry the checklist keep it."""
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["checklist_templates"].update_one(
+            {"order_type": order_type}, {"$set": {"active": False, "updated_at": datetime.now(timezone.utc)}}
+        )
+    if result.matched_count == 0:
+        raise ChecklistError(f"Checklist template '{order_type}' not found", 404)
+    logger.info("checklist_template_deactivated", order_type=order_type)
+
+async def checklist_for(work_order: dict) -> Optional[dict]:
+    """A fresh checklist for a new order, if its type has a template."""
+    templates = [t.model_dump() for t in await list_templates()]
+    template = template_for(work_order, templates)
+    return instantiate(template) if template else None
+
+async def ensure_checklist_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["checklist_templates"].create_index("order_type", unique=True)
@@ -0,0 +1,50 @@
+"""
+Time handling shared by every service.
+
+Timestamps are stored and compared in UTC and are always timezone-aware: the Mongo client hands dates
+back as aware UTC (tz_aware in shared/db/database.py), so API responses carry the offset
+("2026-10-16T08:30:00+00:00") instead of a bare local-looking time. A naive value, from documents
+written before this or a client that sent no offset, is taken to be UTC.
+
+Guests read times in the property's timezone: the runtime config's `hotel_timezone` (shared/
+runtime_config.py, changeable without a restart) or else HOTEL_TIMEZONE. ETAs, schedules and
+confirmations go through local_time()/format_local() rather than formatting UTC values directly.
+"""
+import os
+from datetime import datetime, timezone, tzinfo
+from typing import Optional
+from zoneinfo import ZoneInfo, ZoneInfoNotFoundError
+
+from shared.runtime_config import runtime_config
+
+HOTEL_TIMEZONE = os.getenv("HOTEL_TIMEZONE", "UTC")
+
+def as_utc(value: Optional[datetime]) -> Optional[datetime]:
+    if value is None:
+        return None
+    return value.replace(tzinfo=timezone.utc) if value.tzinfo is None else value.astimezone(timezone.utc)
+
+def resolve_timezone(name: Optional[str], default: Optional[tzinfo] = None) -> tzinfo:
+    """The named zone, or the default (the hotel's) when the name is empty or unknown."""
+    try:
+        return ZoneInfo(name) if name else default or hotel_timezone()
+    except (ZoneInfoNotFoundError, ValueError):
+        return default or hotel_timezone()
+
+def hotel_timezone() -> ZoneInfo:
+    name = runtime_config.settings.hotel_timezone or HOTEL_TIMEZONE
+    try:
+        return ZoneInfo(name)
+    except (ZoneInfoNotFoundError, ValueError):
+        return ZoneInfo("UTC")
+
+def local_time(value: datetime, tz: Optional[tzinfo] = None) -> datetime:
+    return as_utc(value).astimezone(tz or hotel_timezone())
+
+def format_local(value: datetime, fmt: str = "%H:%M", tz: Optional[tzinfo] = None) -> str:
+    return local_time(value, tz).strftime(fmt)
+
+def utc_offset(tz: Optional[tzinfo] = None, at: Optional[datetime] = None) -> str:
+    """e.g. "+02:00"; it depends on the date where there is daylight saving."""
+    minutes = int(local_time(at or datetime.now(timezone.utc), tz).utcoffset().total_seconds() // 60)
+    return f"{'-' if minutes < 0 else '+'}{abs(minutes) // 60:02d}:{abs(minutes) % 60:02d}"
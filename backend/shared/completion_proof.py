@@ -0,0 +1,41 @@
+"""
+Proof of service: a photo and/or note staff leave when they finish an order.
+
+Policy (runtime_config `completion_proof`) names the departments and tags whose orders can't be marked
+completed without one, e.g. maintenance fixes or orders tagged vip_amenity. Supervisors (staff of the
+department, admins) always see the proof; the guest only when the attendant chose to share it.
+"""
+import os
+from typing import Optional
+
+from shared.db.models import StatusEnum
+from shared.runtime_config import CompletionProofPolicy
+
+COMPLETION_PROOF_URL_TTL_MINUTES = int(os.getenv("COMPLETION_PROOF_URL_TTL_MINUTES", "15"))
+
+class CompletionProofError(Exception):
+    def __init__(self, message: str, status_code: int = 409):
+        super().__init__(message)
+        self.status_code = status_code
+
+def proof_required(work_order: dict, policy: CompletionProofPolicy) -> bool:
+    if work_order.get("subtasks"):
+        # A parent completes when its subtasks do; the proof belongs on those
+        return False
+    department = str(work_order.get("department", "")).lower()
+    return (department in {str(getattr(d, "value", d)) for d in policy.departments}
+            or bool(set(work_order.get("tags", [])) & set(policy.tags)))
+
+def check_completion(work_order: dict, new_status: Optional[str], policy: CompletionProofPolicy) -> None:
+    """Raises CompletionProofError when the order is being completed without the proof policy asks for."""
+    if new_status != StatusEnum.COMPLETED or work_order.get("completion_proof"):
+        return
+    if proof_required(work_order, policy):
+        raise CompletionProofError("A completion photo or note is required before this order can be completed")
+
+def guest_view(work_order: dict) -> dict:
+    """The order as its guest may see it: without the proof unless it was shared with them."""
+    proof = work_order.get("completion_proof")
+    if proof and not proof.get("shared_with_guest"):
+        return {**work_order, "completion_proof": None}
+    return work_order
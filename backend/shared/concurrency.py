@@ -0,0 +1,47 @@
+"""
+Optimistic concurrency for work orders.
+
+Every write to a work order increments its `version` (use `versioned()` on the update document), and
+responses carry the version as a strong ETag, e.g. "3". A client that sends back the ETag it last saw
+in If-Match gets a compare-and-swap update: if someone else changed the order in the meantime nothing
+is written, and the client gets 409 with the current document and its ETag to merge against.
+
+Without If-Match updates apply unconditionally, as before. WORK_ORDER_REQUIRE_IF_MATCH=true makes the
+header mandatory on the staff update endpoints (428 when missing).
+"""
+import os
+from typing import Optional
+
+from shared.errors import ApiError, ErrorCode
+
+WORK_ORDER_REQUIRE_IF_MATCH = os.getenv("WORK_ORDER_REQUIRE_IF_MATCH", "false").lower() == "true"
+
+def etag(doc: dict) -> str:
+    return f'"{doc.get("version") or 0}"'
+
+def parse_if_match(value: Optional[str]) -> Optional[int]:
+    """The version an If-Match header expects; None for no header or `*` (any version)."""
+    if value is None or value.strip() == "*":
+        return None
+    tag = value.split(",")[0].strip()
+    if tag.startswith("W/"):
+        tag = tag[2:]
+    try:
+        return int(tag.strip('"'))
+    except ValueError:
+        raise ApiError(400, "If-Match must be an ETag returned by this API")
+
+def expected_version(if_match: Optional[str], required: bool = WORK_ORDER_REQUIRE_IF_MATCH) -> Optional[int]:
+    if if_match is None and required:
+        raise ApiError(428, "Send If-Match with the work order's ETag", ErrorCode.PRECONDITION_REQUIRED)
+    return parse_if_match(if_match)
+
+def version_filter(version: Optional[int]) -> dict:
+    if version is None:
+        return {}
+    # Orders written before versioning have no field; they are version 0
+    return {"version": version} if version else {"version": {"$in": [0, None]}}
+
+def versioned(update: dict) -> dict:
+    """Adds the version increment to a Mongo update document."""
+    return {**update, "$inc": {**update.get("$inc", {}), "version": 1}}
@@ -0,0 +1,77 @@
+"""
+Conditional GET for the endpoints clients poll: order status, work order lists, chat and notification
+history.
+
+Responses carry an ETag and, for single resources, Last-Modified from the resource's updated_at. A
+client that sends them back (If-None-Match / If-Modified-Since) gets an empty 304 while nothing has
+changed. Lists only get an ETag: an item leaving the list doesn't make anything in it newer, so a date
+can't tell whether the list changed.
+
+- The ETag is a weak hash of the JSON body unless the endpoint has a better one (a work order's version).
+- `Cache-Control: private, no-cache` keeps shared caches out and makes clients revalidate on every
+  poll, so a status change is never served stale.
+"""
+import hashlib
+import json
+from datetime import datetime, timezone
+from email.utils import format_datetime, parsedate_to_datetime
+from typing import Any, Mapping, Optional
+
+from fastapi import Request, Response
+from fastapi.encoders import jsonable_encoder
+from fastapi.responses import JSONResponse
+
+CACHE_CONTROL = "private, no-cache"
+
+def body_etag(content: Any) -> str:
+    encoded = json.dumps(content, sort_keys=True, separators=(",", ":"), default=str).encode()
+    return f'W/"{hashlib.sha256(encoded).hexdigest()[:32]}"'
+
+def _opaque_tag(tag: str) -> str:
+    tag = tag.strip()
+    return tag[2:] if tag.startswith("W/") else tag
+
+def etag_matches(if_none_match: Optional[str], tag: str) -> bool:
+    """Weak comparison, as If-None-Match uses: W/"3" matches "3"."""
+    if not if_none_match:
+        return False
+    if if_none_match.strip() == "*":
+        return True
+    return _opaque_tag(tag) in {_opaque_tag(t) for t in if_none_match.split(",")}
+
+def as_utc(value: datetime) -> datetime:
+    # Mongo hands back naive datetimes that are UTC
+    return value.replace(tzinfo=timezone.utc) if value.tzinfo is None else value.astimezone(timezone.utc)
+
+def http_date(value: datetime) -> str:
+    return format_datetime(as_utc(value), usegmt=True)
+
+def parse_http_date(value: Optional[str]) -> Optional[datetime]:
+    if not value:
+        return None
+    try:
+        return as_utc(parsedate_to_datetime(value))
+    except (TypeError, ValueError, IndexError):
+        return None
+
+def is_not_modified(headers: Mapping[str, str], tag: str, last_modified: Optional[datetime] = None) -> bool:
+    """If-None-Match wins over If-Modified-Since when a client sends both (RFC 9110 13.2.2)."""
+    if_none_match = headers.get("if-none-match")
+    if if_none_match is not None:
+        return etag_matches(if_none_match, tag)
+    since = parse_http_date(headers.get("if-modified-since"))
+    if since is None or last_modified is None:
+        return False
+    # HTTP dates have whole seconds
+    return as_utc(last_modified).replace(microsecond=0) <= since
+
+def conditional_response(request: Request, content: Any, last_modified: Optional[datetime] = None,
+                         tag: Optional[str] = None) -> Response:
+    body = jsonable_encoder(content)
+    tag = tag or body_etag(body)
+    headers = {"ETag": tag, "Cache-Control": CACHE_CONTROL}
+    if last_modified:
+        headers["Last-Modified"] = http_date(last_modified)
+    if request.method in ("GET", "HEAD") and is_not_modified(request.headers, tag, last_modified):
+        return Response(status_code=304, headers=headers)
+    return JSONResponse(body, headers=headers)
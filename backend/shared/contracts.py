@@ -0,0 +1,111 @@
+"""
+Canonical messages exchanged between services over Service Bus.
+Both the publisher (chatbot) and the consumer (work orders) import these models so the wire
+format is defined in exactly one place. Unknown fields are rejected so drift fails loudly, except on
+the chat request queue: a message from a newer chatbot must not dead-letter while the work-order service
+is still being rolled out, so its extra fields are logged and dropped.
+"""
+import json
+from datetime import datetime, timezone
+from typing import Any, Dict, List, Optional
+
+import structlog
+from pydantic import BaseModel, ConfigDict, Field
+
+from shared.db.models import ChatRequest, DepartmentEnum, LineItem, PriorityEnum, StatusEnum, WorkflowTypeEnum
+
+logger = structlog.get_logger()
+
+CHAT_REQUEST_CONTRACT_VERSION = 1
+WORK_ORDER_EVENT_CONTRACT_VERSION = 1
+
+class ChatRequestMessage(BaseModel):
+    """Published by the chatbot for every chat request that should become a work order."""
+    model_config = ConfigDict(extra="ignore", use_enum_values=True)
+
+    contract_version: int = CHAT_REQUEST_CONTRACT_VERSION
+    request_id: str
+    guest_id: str
+    message: str = Field(..., min_length=1)
+    department: Optional[DepartmentEnum] = None
+    language: str = "en"
+    tags: List[str] = Field(default_factory=list)
+    room_number: Optional[str] = None
+    session_id: Optional[str] = None
+    attachment_ids: List[str] = Field(default_factory=list)
+    sentiment: Optional[float] = Field(None, ge=-1.0, le=1.0)
+    scheduled_for: Optional[datetime] = Field(None, description="Requested time for future-dated requests (UTC)")
+    workflow: Optional[WorkflowTypeEnum] = Field(None, description="Start a multi-step valet/luggage workflow")
+    priority: Optional[PriorityEnum] = Field(None, description="Base priority set by a quick action; medium otherwise")
+    quick_action: Optional[str] = Field(None, description="ID of the quick action the guest tapped")
+    line_items: List[LineItem] = Field(default_factory=list, description="Items and quantities picked out of the message")
+    location: Optional[Dict[str, Any]] = Field(None, description="Where the guest was, when they agreed to share it "
+                                                           "(shared/venue_map.py)")
+    created_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+
+    @classmethod
+    def from_chat_request(cls, chat_request: ChatRequest) -> "ChatRequestMessage":
+        metadata = chat_request.metadata or {}
+        return cls(
+            request_id=chat_request.request_id,
+            guest_id=chat_request.guest_id,
+            message=chat_request.message,
+            department=chat_request.department,
+            language=chat_request.language,
+            tags=chat_request.tags,
+            room_number=metadata.get("room_number"),
+            session_id=metadata.get("session_id"),
+            attachment_ids=metadata.get("images") or [],
+            sentiment=chat_request.sentiment,
+            scheduled_for=metadata.get("scheduled_for"),
+            workflow=metadata.get("workflow"),
+            priority=metadata.get("priority"),
+            quick_action=metadata.get("quick_action"),
+            line_items=metadata.get("line_items") or [],
+            location=metadata.get("location"),
+            created_at=chat_request.created_at
+        )
+
+    def to_json(self) -> str:
+        return self.model_dump_json()
+
+    @classmethod
+    def from_json(cls, data: str) -> "ChatRequestMessage":
+        payload = json.loads(data)
+        unknown = sorted(set(payload) - set(cls.model_fields)) if isinstance(payload, dict) else []
+        if unknown:
+            logger.warning("chat_message_unknown_fields", request_id=payload.get("request_id"),
+                           contract_version=payload.get("contract_version"), fields=unknown)
+        return cls.model_validate(payload)
+
+class WorkOrderStatusEvent(BaseModel):
+    """Sent by the work-order service to the chatbot whenever an order changes state."""
+    model_config = ConfigDict(extra="forbid", use_enum_values=True)
+
+    contract_version: int = WORK_ORDER_EVENT_CONTRACT_VERSION
+    event: str = "status_changed"
+    request_id: str
+    work_order_id: str
+    order_number: Optional[str] = None
+    guest_id: str
+    department: DepartmentEnum
+    status: StatusEnum
+    session_id: Optional[str] = None
+    workflow: Optional[WorkflowTypeEnum] = None
+    workflow_step: Optional[str] = None
+    occurred_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+
+    @classmethod
+    def from_work_order(cls, work_order: dict, event: str = "status_changed") -> "WorkOrderStatusEvent":
+        return cls(
+            event=event,
+            request_id=work_order["request_id"],
+            work_order_id=work_order["work_order_id"],
+            order_number=work_order.get("order_number"),
+            guest_id=work_order["guest_id"],
+            department=work_order["department"],
+            status=work_order["status"],
+            session_id=(work_order.get("metadata") or {}).get("session_id"),
+            workflow=(work_order.get("workflow") or {}).get("type"),
+            workflow_step=(work_order.get("workflow") or {}).get("step")
+        )
@@ -0,0 +1,118 @@
+from datetime import date, datetime, timezone
+from typing import Any, Dict, List, Optional
+
+import structlog
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import CustomFieldDefinition, CustomFieldTypeEnum
+
+logger = structlog.get_logger()
+
+MAX_TAGS = 20
+MAX_TAG_LENGTH = 40
+
+class CustomFieldError(ValueError): pass
+
+def normalize_tags(tags: List[str]) -> List[str]:
+    """Lower-cases, trims and de-duplicates tags, keeping their order."""
+    normalized = []
+    for tag in tags:
+        tag = tag.strip().lower()
+        if not tag:
+            continue
+        if len(tag) > MAX_TAG_LENGTH:
+            raise CustomFieldError(f"Tag '{tag[:20]}...' is longer than {MAX_TAG_LENGTH} characters")
+        if tag not in normalized:
+            normalized.append(tag)
+    if len(normalized) > MAX_TAGS:
+        raise CustomFieldError(f"At most {MAX_TAGS} tags are allowed")
+    return normalized
+
+async def list_field_definitions(active_only: bool = True) -> List[CustomFieldDefinition]:
+    query = {"active": True} if active_only else {}
+    async with DatabaseConnection.get_connection() as conn:
+        cursor = conn["virtualbutler"]["custom_field_definitions"].find(query).sort("key", 1)
+        return [CustomFieldDefinition(**doc) async for doc in cursor]
+
+def coerce_value(definition: CustomFieldDefinition, value: Any) -> Any:
+    """Converts a JSON or query-string value to the field's type, raising CustomFieldError if it doesn't fit."""
+    field_type = CustomFieldTypeEnum(definition.type)
+    try:
+        if field_type == CustomFieldTypeEnum.NUMBER:
+            if isinstance(value, bool):
+                raise ValueError
+            return float(value) if not isinstance(value, int) else value
+        if field_type == CustomFieldTypeEnum.BOOLEAN:
+            if isinstance(value, bool):
+                return value
+            if str(value).lower() in ("true", "1", "yes"):
+                return True
+            if str(value).lower() in ("false", "0", "no"):
+                return False
+            raise ValueError
+        if field_type == CustomFieldTypeEnum.DATE:
+            # Stored as ISO date strings so they sort and compare correctly
+            return (value if isinstance(value, date) else date.fromisoformat(str(value))).isoformat()
+        if field_type == CustomFieldTypeEnum.ENUM:
+            if str(value) not in definition.options:
+                raise ValueError
+            return str(value)
+        return str(value)[:500]
+    except (TypeError, ValueError):
+        raise CustomFieldError(f"Invalid value for custom field '{definition.key}' ({definition.type})")
+
+def validate_custom_fields(values: Dict[str, Any], definitions: List[CustomFieldDefinition],
+                           partial: bool = False) -> Dict[str, Any]:
+    """
+    Validates custom field values against the active definitions.
+    Unknown keys are rejected; required fields are only enforced when partial is False (i.e. on create).
+    """
+    by_key = {d.key: d for d in definitions}
+    unknown = set(values) - set(by_key)
+    if unknown:
+        raise CustomFieldError(f"Unknown custom fields: {sorted(unknown)}")
+    cleaned = {key: coerce_value(by_key[key], value) for key, value in values.items() if value is not None}
+    if not partial:
+        missing = [d.key for d in definitions if d.required and d.key not in cleaned]
+        if missing:
+            raise CustomFieldError(f"Missing required custom fields: {missing}")
+    return cleaned
+
+def custom_field_filter(params: Dict[str, str], definitions: List[CustomFieldDefinition]) -> Dict[str, Any]:
+    """Builds a Mongo filter from `cf.<key>=<value>` query parameters."""
+    by_key = {d.key: d for d in definitions}
+    query = {}
+    for name, value in params.items():
+        if not name.startswith("cf."):
+            continue
+        key = name[3:]
+        if key not in by_key:
+            raise CustomFieldError(f"Unknown custom field '{key}'")
+        query[f"custom_fields.{key}"] = coerce_value(by_key[key], value)
+    return query
+
+# --- Definition administration ---
+
+async def save_field_definition(definition: CustomFieldDefinition) -> CustomFieldDefinition:
+    if definition.type == CustomFieldTypeEnum.ENUM and not definition.options:
+        raise CustomFieldError("Enum fields need at least one option")
+    data = definition.model_dump(exclude={"id", "created_at"})
+    data["updated_at"] = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["custom_field_definitions"].update_one(
+            {"key": definition.key},
+            {"$set": data, "$setOnInsert": {"created_at": datetime.now(timezone.utc)}},
+            upsert=True
+        )
+    logger.info("custom_field_saved", key=definition.key, type=definition.type)
+    return definition
+
+async def deactivate_field_definition(key: str) -> None:
+    """Definitions are never hard-deleted so existing values stay interpretable in exports."""
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["custom_field_definitions"].update_one(
+            {"key": key}, {"$set": {"active": False, "updated_at": datetime.now(timezone.utc)}}
+        )
+    if result.matched_count == 0:
+        raise CustomFieldError(f"Custom field '{key}' not found")
+    logger.info("custom_field_deactivated", key=key)
@@ -1,7 +1,7 @@
 import os
 import asyncio
-from typing import Optional, Dict, Any
-from datetime import datetime
+from typing import Optional, Dict, Any, Callable
+from datetime import datetime, timezone
 from contextlib import asynccontextmanager
 
 from dotenv import load_dotenv
@@ -11,6 +11,8 @@ from pymongo.errors import (ServerSelectionTimeoutError, OperationFailure,
 from motor.motor_asyncio import AsyncIOMotorClient
 import structlog
 
+from shared import fault_injection
+
 # --- Structured Logging Setup ---
 structlog.configure(
     processors=[
@@ -58,8 +60,57 @@ class DatabaseConnection:
         "work_orders": None,
         "notifications": None,
         "message_threads": None,
-        "guest_profiles": None
+        "guest_profiles": None,
+        "assets": None,
+        "rooms": None,
+        "agent_conversations": None,
+        "wake_up_calls": None,
+        "security_events": None,
+        "venues": None,
+        "reservations": None,
+        "recommendations": None,
+        "transport_requests": None,
+        "lost_reports": None,
+        "found_items": None,
+        "incidents": None,
+        "maintenance_schedules": None,
+        "retention_policies": None,
+        "field_keys": None,
+        "api_keys": None,
+        "api_key_usage": None,
+        "oidc_providers": None,
+        "oidc_logouts": None,
+        "leases": None,
+        "zones": None,
+        "nps_surveys": None,
+        "counters": None,
+        "work_order_events": None,
+        "rm_department_stats": None,
+        "rm_sla_timers": None,
+        "rm_leaderboard": None,
+        "rm_applied_events": None,
+        "business_calendars": None,
+        "guest_restrictions": None,
+        "guest_block_audit": None,
+        "quick_actions": None,
+        "routing_corrections": None,
+        "classifier_decisions": None,
+        "runtime_config": None,
+        "feature_flags": None,
+        "idempotency_keys": None,
+        "response_personas": None,
+        "promotions": None,
+        "promotion_offers": None,
+        "event_groups": None,
+        "room_choices": None,
+        "devices": None,
+        "onboarding_codes": None,
+        "knowledge_articles": None,
+        "translation_overrides": None,
+        "time_choices": None
     }
+    # Set by shared.event_store and shared.security.field_crypto when event sourcing or field-level encryption is on
+    client_wrapper: Optional[Callable[[Any], Any]] = None
 
     # Connection pool settings
     MIN_POOL_SIZE = 10
@@ -90,6 +141,9 @@ class DatabaseConnection:
                 maxPoolSize=cls.MAX_POOL_SIZE,
                 maxIdleTimeMS=cls.MAX_IDLE_TIME_MS,
                 retryWrites=True,
+                # Dates come back as aware UTC, so they serialize with their offset
+                tz_aware=True,
+                tzinfo=timezone.utc,
                 event_listeners=[MongoDBListener()]
             )
             cls.db = cls.client[db_name]
@@ -125,8 +179,9 @@ class DatabaseConnection:
     async def get_connection(cls):
         if not cls.client:
             await cls.connect()
+        await fault_injection.mongo_latency()
         try:
-            yield cls.client
+            yield cls.client_wrapper(cls.client) if cls.client_wrapper else cls.client
         except PyMongoError as e:
             logger.error("operation_failed", error=str(e))
             raise OperationError(f"Operation failed: {e}") from e
@@ -142,7 +197,7 @@ class DatabaseConnection:
         while True:
             try:
                 cls._health_status = await cls.health_check()
-                cls._last_health_check = datetime.utcnow()
+                cls._last_health_check = datetime.now(timezone.utc)
                 await asyncio.sleep(cls.HEALTH_CHECK_INTERVAL)
             except Exception as e:
                 logger.error("health_check_failed", error=str(e))
@@ -151,12 +206,12 @@ class DatabaseConnection:
     @classmethod
     async def health_check(cls) -> Dict[str, Any]:
         try:
-            start = datetime.utcnow()
+            start = datetime.now(timezone.utc)
             alive = await cls.ping()
-            duration = (datetime.utcnow() - start).total_seconds() * 1000
+            duration = (datetime.now(timezone.utc) - start).total_seconds() * 1000
             return {
                 "status": "healthy" if alive else "unhealthy",
-                "timestamp": datetime.utcnow(),
+                "timestamp": datetime.now(timezone.utc),
                 "response_time_ms": duration,
                 "collections": await cls._collection_stats() if alive else {},
                 "last_error": None
@@ -165,7 +220,7 @@ class DatabaseConnection:
             return {
                 "status": "unhealthy",
                 "error": str(e),
-                "timestamp": datetime.utcnow()
+                "timestamp": datetime.now(timezone.utc)
             }
 
     @classmethod
@@ -1,9 +1,11 @@
 from pydantic import BaseModel, Field, validator, EmailStr
-from datetime import datetime
+from datetime import datetime, timezone
 from typing import Optional, List, Dict, Any
 from bson import ObjectId
 from enum import Enum
 
+from shared.scan_codes import short_code_for
+
 class PyObjectId(ObjectId):
     @classmethod
     def __get_validators__(cls):
@@ -42,6 +44,120 @@ class DepartmentEnum(str, Enum):
     SECURITY = "security"
     CONCIERGE = "concierge"
 
+class FaultCodeEnum(str, Enum):
+    HVAC_NOT_COOLING = "hvac_not_cooling"
+    HVAC_NOT_HEATING = "hvac_not_heating"
+    PLUMBING_LEAK = "plumbing_leak"
+    PLUMBING_BLOCKAGE = "plumbing_blockage"
+    ELECTRICAL = "electrical"
+    LIGHTING = "lighting"
+    APPLIANCE = "appliance"
+    FURNITURE = "furniture"
+    DOOR_LOCK = "door_lock"
+    OTHER = "other"
+
+class ConversationStatusEnum(str, Enum):
+    WAITING = "waiting"    # queued for a live agent
+    ACTIVE = "active"      # an agent has joined and messages are bridged
+    CLOSED = "closed"
+
+class DispositionEnum(str, Enum):
+    RESOLVED = "resolved"
+    WORK_ORDER_CREATED = "work_order_created"
+    INFORMATION_PROVIDED = "information_provided"
+    GUEST_UNRESPONSIVE = "guest_unresponsive"
+    DUPLICATE = "duplicate"
+    OTHER = "other"
+
+class WakeUpCallStatusEnum(str, Enum):
+    SCHEDULED = "scheduled"
+    RINGING = "ringing"        # delivered at least once, waiting for the guest to confirm
+    CONFIRMED = "confirmed"
+    ESCALATED = "escalated"    # not confirmed; the front desk has a work order to follow up
+    CANCELLED = "cancelled"
+
+class WorkflowTypeEnum(str, Enum):
+    VALET = "valet"
+    LUGGAGE = "luggage"
+
+class VenueTypeEnum(str, Enum):
+    RESTAURANT = "restaurant"
+    SPA = "spa"
+
+class ReservationStatusEnum(str, Enum):
+    HELD = "held"              # slot reserved while the guest confirms
+    CONFIRMED = "confirmed"
+    CANCELLED = "cancelled"
+    EXPIRED = "expired"        # hold lapsed without confirmation
+
+class RecommendationCategoryEnum(str, Enum):
+    RESTAURANT = "restaurant"
+    ATTRACTION = "attraction"
+    TRANSPORT = "transport"
+
+class TransportModeEnum(str, Enum):
+    TAXI = "taxi"
+    SHUTTLE = "shuttle"
+
+class TransportStatusEnum(str, Enum):
+    REQUESTED = "requested"              # waiting on the provider or the concierge
+    CONFIRMED = "confirmed"
+    DRIVER_ASSIGNED = "driver_assigned"
+    ARRIVED = "arrived"                  # driver is at the pickup point
+    COMPLETED = "completed"
+    CANCELLED = "cancelled"
+    FAILED = "failed"
+
+class LostReportStatusEnum(str, Enum):
+    OPEN = "open"
+    MATCHED = "matched"                  # staff linked a found item to the report
+    RETURN_ARRANGED = "return_arranged"  # pickup agreed or parcel on its way
+    RETURNED = "returned"
+    CLOSED = "closed"                    # not found / withdrawn
+
+class FoundItemStatusEnum(str, Enum):
+    STORED = "stored"
+    MATCHED = "matched"
+    RETURNED = "returned"
+    DISPOSED = "disposed"
+
+class ReturnMethodEnum(str, Enum):
+    PICKUP = "pickup"
+    SHIPPING = "shipping"
+
+class IncidentTypeEnum(str, Enum):
+    FIRE = "fire"
+    MEDICAL = "medical"
+    SECURITY = "security"
+
+class IncidentStatusEnum(str, Enum):
+    REPORTED = "reported"            # alerts out, nobody has taken it yet
+    ACKNOWLEDGED = "acknowledged"
+    RESPONDING = "responding"        # staff or emergency services on their way / on site
+    RESOLVED = "resolved"
+    CLOSED = "closed"                # reviewed after resolution
+    FALSE_ALARM = "false_alarm"
+
+class RetentionModeEnum(str, Enum):
+    DELETE = "delete"          # transcripts are removed outright
+    ANONYMIZE = "anonymize"    # message content is removed, counts/departments/sentiment are kept
+
+class RetentionStrategyEnum(str, Enum):
+    SCHEDULED = "scheduled"    # periodic purge job
+    TTL = "ttl"                # Mongo TTL indexes (delete mode only)
+
+class CustomFieldTypeEnum(str, Enum):
+    STRING = "string"
+    NUMBER = "number"
+    BOOLEAN = "boolean"
+    DATE = "date"
+    ENUM = "enum"
+
+class SurveyStatusEnum(str, Enum):
+    SENT = "sent"
+    ANSWERED = "answered"
+    UNDELIVERED = "undelivered"      # no channel the guest could be reached on
+
 class NotificationTypeEnum(str, Enum):
     CHAT = "chat"
     WORK_ORDER = "work_order"
@@ -51,8 +167,8 @@ class NotificationTypeEnum(str, Enum):
 
 class BaseDBModel(BaseModel):
     id: Optional[PyObjectId] = Field(default=None, alias="_id")
-    created_at: datetime = Field(default_factory=datetime.utcnow)
-    updated_at: datetime = Field(default_factory=datetime.utcnow)
+    created_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+    updated_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
 
     class Config:
         json_encoders = {ObjectId: str}
@@ -93,11 +209,15 @@ class User(BaseDBModel):
 class GuestProfile(BaseModel):
     guest_id: str = Field(..., description="Unique identifier for the guest")
     room_number: Optional[str] = None
+    rooms: List[str] = Field(default_factory=list, description="Other rooms on the account (shared/rooms.py)")
     name: Optional[str] = None
     email: Optional[EmailStr] = None
     phone: Optional[str] = None
     vip_status: bool = False
     preferences: Dict[str, Any] = Field(default_factory=dict)
+    check_in_date: Optional[datetime] = None
+    check_out_date: Optional[datetime] = None
+    loyalty_tier: Optional[str] = Field(None, description="From the PMS, e.g. gold or platinum (shared/loyalty.py)")
 
 class ChatRequest(BaseDBModel):
     request_id: str = Field(..., description="Unique identifier for the request")
@@ -108,9 +228,12 @@ class ChatRequest(BaseDBModel):
     department: DepartmentEnum
     status: StatusEnum = StatusEnum.PENDING
     tags: List[str] = Field(default_factory=list)
+    synthetic: bool = Field(False, description="Sandbox traffic, purged nightly (shared/sandbox.py)")
     sentiment: Optional[float] = Field(None, ge=-1.0, le=1.0)
     language: str = "en"
     metadata: Dict[str, Any] = Field(default_factory=dict)
+    edit_history: List[Dict[str, Any]] = Field(default_factory=list, description="Earlier wordings the guest "
+                                               "edited away (message, edited_at)")
 
     class Config:
         schema_extra = {
@@ -124,9 +247,132 @@ class ChatRequest(BaseDBModel):
             }
         }
 
+class Asset(BaseDBModel):
+    asset_id: str = Field(..., description="Unique identifier for the asset (e.g. tag number)")
+    room_number: Optional[str] = Field(None, description="Room the asset is installed in")
+    asset_type: str = Field(..., description="Equipment type, e.g. air_conditioner, minibar, tv")
+    manufacturer: Optional[str] = None
+    model: Optional[str] = None
+    serial_number: Optional[str] = None
+    installed_at: Optional[datetime] = None
+    active: bool = True
+    metadata: Dict[str, Any] = Field(default_factory=dict)
+
+    class Config:
+        schema_extra = {
+            "example": {
+                "asset_id": "AC-0512",
+                "room_number": "512",
+                "asset_type": "air_conditioner",
+                "manufacturer": "Daikin",
+                "model": "FTXM35"
+            }
+        }
+
+class PartUsage(BaseModel):
+    part_number: str
+    description: Optional[str] = None
+    quantity: int = Field(1, ge=1)
+
+class LineItem(BaseModel):
+    item: str = Field(..., min_length=1, max_length=60, description="Canonical item name, e.g. towels")
+    quantity: Optional[int] = Field(None, ge=1, le=99, description="Empty when the guest didn't say how many")
+
+class GuestLocation(BaseModel):
+    """Where the guest was when sending a request; only kept when they agreed to share it."""
+    latitude: float = Field(..., ge=-90, le=90)
+    longitude: float = Field(..., ge=-180, le=180)
+    accuracy_m: Optional[float] = Field(None, ge=0, description="Reported by the device")
+    consent: bool = Field(False, description="The guest agreed to share their location with this request")
+
+class CompletionProof(BaseModel):
+    blob_name: Optional[str] = Field(None, description="Completion photo in Blob Storage")
+    note: Optional[str] = Field(None, max_length=1000)
+    submitted_by: str
+    submitted_at: datetime
+    shared_with_guest: bool = False
+
+class LaborEntry(BaseModel):
+    staff_id: str
+    started_at: datetime
+    stopped_at: Optional[datetime] = Field(None, description="Empty while the timer runs")
+    minutes: Optional[float] = None
+
+class CostEntry(BaseModel):
+    cost_id: str
+    description: str = Field(..., min_length=1, max_length=200, description="e.g. Shower mixer cartridge")
+    quantity: float = Field(1, gt=0)
+    unit_cost: float = Field(..., ge=0)
+    total: float
+    added_by: str
+    added_at: datetime
+
+class ChecklistTemplateStep(BaseModel):
+    title: str = Field(..., min_length=1, max_length=200)
+    required: bool = True
+
+class ChecklistTemplate(BaseDBModel):
+    order_type: str = Field(..., pattern=r"^[a-z][a-z0-9_]{0,39}$",
+                            description="Tag or quick action the checklist is for, e.g. deep_clean")
+    name: str = Field(..., min_length=1, max_length=100)
+    department: Optional[DepartmentEnum] = Field(None, description="Only for orders of this department; empty for any")
+    steps: List[ChecklistTemplateStep] = Field(..., min_length=1, max_length=50)
+    active: bool = True
+
+    class Config:
+        use_enum_values = True
+        schema_extra = {
+            "example": {
+                "order_type": "deep_clean",
+                "name": "Deep clean",
+                "department": "housekeeping",
+                "steps": [{"title": "Strip and remake beds"}, {"title": "Descale shower", "required": False}]
+            }
+        }
+
+class ChecklistStep(BaseModel):
+    step_id: str
+    title: str
+    required: bool = True
+    done: bool = False
+    done_by: Optional[str] = None
+    done_at: Optional[datetime] = None
+
+class Checklist(BaseModel):
+    order_type: str
+    name: str
+    steps: List[ChecklistStep]
+
+class MaintenanceDetails(BaseModel):
+    asset_id: Optional[str] = Field(None, description="Asset registry ID of the faulty equipment")
+    fault_code: Optional[FaultCodeEnum] = None
+    parts_used: List[PartUsage] = Field(default_factory=list)
+    schedule_id: Optional[str] = Field(None, description="Preventive-maintenance schedule that generated the order")
+
+    class Config:
+        use_enum_values = True
+
+class MaintenanceSchedule(BaseDBModel):
+    schedule_id: str
+    asset_id: Optional[str] = Field(None, description="Asset the task is for; room-level tasks leave this empty")
+    room_number: Optional[str] = None
+    task: str = Field(..., min_length=1, max_length=300, description="e.g. Replace AC filter")
+    interval_days: int = Field(..., ge=1, le=3650)
+    lead_days: int = Field(0, ge=0, le=90, description="Generate the work order this many days before it is due")
+    priority: PriorityEnum = PriorityEnum.LOW
+    next_due_at: datetime
+    last_completed_at: Optional[datetime] = None
+    open_work_order_id: Optional[str] = None
+    completions: List[Dict[str, Any]] = Field(default_factory=list, description="Most recent completions, newest last")
+    active: bool = True
+
 class WorkOrder(BaseDBModel):
     request_id: str = Field(..., description="Reference to original chat request")
     work_order_id: str = Field(..., description="Unique identifier for the work order")
+    order_number: Optional[str] = Field(None, description="Human-friendly number, e.g. HK-2045 (shared/order_numbers.py)")
+    short_code: Optional[str] = Field(None, validate_default=True,
+                                      description="Printed and in the QR, for scan lookup (shared/scan_codes.py)")
+    version: int = Field(0, description="Incremented on every write; sent as the ETag (shared/concurrency.py)")
     guest_id: str
     staff_id: Optional[str] = None
     department: DepartmentEnum
@@ -142,8 +388,33 @@ class WorkOrder(BaseDBModel):
     location: Optional[str] = None
     materials_needed: List[str] = Field(default_factory=list)
     attachments: List[str] = Field(default_factory=list)
+    line_items: List[LineItem] = Field(default_factory=list, description="What the guest asked for, e.g. 3 towels, 2 pillows")
+    maintenance: Optional[MaintenanceDetails] = None
+    completion_proof: Optional[CompletionProof] = Field(None, description="Photo and/or note left on completion "
+                                                        "(shared/completion_proof.py)")
+    labor: List[LaborEntry] = Field(default_factory=list, description="Staff time on the order (shared/work_costs.py)")
+    labor_minutes: float = Field(0, description="Sum of the stopped labor entries")
+    costs: List[CostEntry] = Field(default_factory=list, description="Materials and other costs booked to the order")
+    material_cost: float = Field(0, description="Sum of `costs`")
+    checklist: Optional[Checklist] = Field(None, description="Steps copied from the order type's template "
+                                           "(shared/checklists.py)")
+    tags: List[str] = Field(default_factory=list)
+    synthetic: bool = Field(False, description="Sandbox traffic, purged nightly (shared/sandbox.py)")
+    custom_fields: Dict[str, Any] = Field(default_factory=dict, description="Values for admin-defined custom fields")
+    workflow: Optional[Dict[str, Any]] = Field(None, description="Step state for valet/luggage workflows (shared/workflows.py)")
+    parent_id: Optional[str] = Field(None, description="work_order_id of the guest-facing parent for subtasks")
+    depends_on: List[str] = Field(default_factory=list, description="Sibling subtasks that must complete first")
+    subtasks: Optional[Dict[str, int]] = Field(None, description="Roll-up counts on a parent (shared/subtasks.py)")
+    trace_id: Optional[str] = Field(None, description="X-Request-ID of the request that created the order")
+    sla_breached_at: Optional[datetime] = Field(None, description="When the order passed its department's SLA target")
+    loyalty_tier: Optional[str] = Field(None, description="The guest's tier when the order was created (shared/loyalty.py)")
     metadata: Dict[str, Any] = Field(default_factory=dict)
 
+    @validator("short_code", always=True)
+    def default_short_code(cls, v, values):
+        # Derived from the ID, so orders stored before scan codes get the same code on read
+        return v or (short_code_for(values["work_order_id"]) if values.get("work_order_id") else None)
+
     class Config:
         schema_extra = {
             "example": {
@@ -158,6 +429,43 @@ class WorkOrder(BaseDBModel):
             }
         }
 
+class CustomFieldDefinition(BaseDBModel):
+    key: str = Field(..., pattern=r"^[a-z][a-z0-9_]{0,39}$", description="Field name as stored on work orders")
+    label: str = Field(..., min_length=1, max_length=100)
+    type: CustomFieldTypeEnum
+    options: List[str] = Field(default_factory=list, description="Allowed values for enum fields")
+    required: bool = False
+    active: bool = True
+
+    class Config:
+        schema_extra = {
+            "example": {
+                "key": "wing",
+                "label": "Tower / wing",
+                "type": "enum",
+                "options": ["north", "south"]
+            }
+        }
+
+class Room(BaseDBModel):
+    room_number: str
+    dnd_active: bool = False
+    dnd_updated_at: Optional[datetime] = None
+    dnd_set_by: Optional[str] = None
+    smart_room: bool = False
+    devices: List[str] = Field(default_factory=list, description="Remotely controllable devices (thermostat, lights, curtains, tv)")
+
+class ChatAttachment(BaseDBModel):
+    attachment_id: str = Field(..., description="Unique identifier for the attachment")
+    guest_id: str
+    session_id: Optional[str] = None
+    request_id: Optional[str] = Field(None, description="Chat request the attachment belongs to")
+    work_order_id: Optional[str] = None
+    blob_name: str
+    content_type: str
+    size_bytes: int
+    filename: Optional[str] = None
+
 class Notification(BaseDBModel):
     notification_id: str = Field(..., description="Unique identifier for the notification")
     request_id: str
@@ -191,10 +499,251 @@ class Notification(BaseDBModel):
 
     @validator("expiry")
     def validate_expiry(cls, v, values):
-        if v and v < values.get("created_at", datetime.utcnow()):
+        if v and v < values.get("created_at", datetime.now(timezone.utc)):
             raise ValueError("Expiry time must be in the future")
         return v
 
+class NotificationPreferences(BaseModel):
+    guest_id: str
+    channels: List[str] = Field(default_factory=lambda: ["app", "push"], description="Enabled channels (app, push, email when SMTP is set up)")
+    quiet_hours_start: Optional[str] = Field(None, pattern=r"^([01]\d|2[0-3]):[0-5]\d$", description="HH:MM local time")
+    quiet_hours_end: Optional[str] = Field(None, pattern=r"^([01]\d|2[0-3]):[0-5]\d$", description="HH:MM local time")
+    timezone: Optional[str] = Field(None, description="Defaults to the hotel's timezone")
+    language: str = "en"
+    digest_enabled: bool = True
+    updated_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+
+    class Config:
+        schema_extra = {
+            "example": {
+                "guest_id": "guest_456",
+                "channels": ["app", "email"],
+                "quiet_hours_start": "22:00",
+                "quiet_hours_end": "07:00",
+                "timezone": "Europe/London",
+                "language": "en"
+            }
+        }
+
+class AgentConversation(BaseDBModel):
+    conversation_id: str = Field(..., description="Unique identifier for the live-agent conversation")
+    guest_id: str
+    room_number: Optional[str] = None
+    session_id: Optional[str] = None
+    department: DepartmentEnum = DepartmentEnum.FRONT_DESK
+    status: ConversationStatusEnum = ConversationStatusEnum.WAITING
+    agent_id: Optional[str] = None
+    reason: str = Field(..., description="Why the bot handed off (guest_request, unclassified)")
+    language: str = "en"
+    messages: List[Dict[str, Any]] = Field(default_factory=list)
+    transfers: List[Dict[str, Any]] = Field(default_factory=list)
+    read_markers: Dict[str, Dict[str, Any]] = Field(default_factory=dict, description="guest/agent -> the last "
+                                                    "message they read (message_id, read_at)")
+    disposition: Optional[DispositionEnum] = None
+    disposition_note: Optional[str] = None
+    closed_by: Optional[str] = None
+    closed_at: Optional[datetime] = None
+
+class WakeUpCall(BaseDBModel):
+    call_id: str = Field(..., description="Unique identifier for the wake-up call")
+    guest_id: str
+    room_number: str
+    scheduled_for: datetime = Field(..., description="When to ring (UTC)")
+    timezone: str = "UTC"
+    language: str = "en"
+    status: WakeUpCallStatusEnum = WakeUpCallStatusEnum.SCHEDULED
+    attempts: int = 0
+    next_attempt_at: Optional[datetime] = None
+    last_channel: Optional[str] = Field(None, description="voice or room_device")
+    confirmed_at: Optional[datetime] = None
+    confirmed_via: Optional[str] = None
+    escalated_at: Optional[datetime] = None
+    work_order_id: Optional[str] = Field(None, description="Front-desk follow-up created on escalation")
+    created_by: Optional[str] = None
+
+    class Config:
+        schema_extra = {
+            "example": {
+                "call_id": "wake_123",
+                "guest_id": "guest_456",
+                "room_number": "1204",
+                "scheduled_for": "2024-05-02T05:30:00Z",
+                "timezone": "Europe/London",
+                "status": "scheduled"
+            }
+        }
+
+class SecurityEvent(BaseDBModel):
+    event_id: str = Field(..., description="Unique identifier for the security event")
+    event_type: str = Field(..., description="verification_started, verification_failed, key_issued, key_extended, ...")
+    guest_id: Optional[str] = None
+    room_number: Optional[str] = None
+    actor: Optional[str] = None
+    outcome: str
+    details: Dict[str, Any] = Field(default_factory=dict)
+
+class Venue(BaseDBModel):
+    venue_id: str = Field(..., description="Unique identifier for the restaurant or spa")
+    name: str
+    venue_type: VenueTypeEnum
+    capacity: int = Field(..., ge=1, description="Guests that can be booked into one slot")
+    slot_minutes: int = Field(60, ge=15, le=240)
+    opens_at: str = Field("18:00", pattern=r"^([01]\d|2[0-3]):[0-5]\d$", description="HH:MM hotel time")
+    closes_at: str = Field("22:00", pattern=r"^([01]\d|2[0-3]):[0-5]\d$", description="HH:MM hotel time; last slot ends by then")
+    active: bool = True
+
+class Reservation(BaseDBModel):
+    reservation_id: str = Field(..., description="Unique identifier for the reservation")
+    venue_id: str
+    venue_name: str
+    venue_type: Optional[VenueTypeEnum] = None
+    guest_id: str
+    room_number: Optional[str] = None
+    party_size: int = Field(..., ge=1)
+    starts_at: datetime = Field(..., description="UTC")
+    ends_at: datetime = Field(..., description="UTC")
+    status: ReservationStatusEnum = ReservationStatusEnum.HELD
+    hold_expires_at: Optional[datetime] = None
+    confirmed_at: Optional[datetime] = None
+    language: str = "en"
+    notes: Optional[str] = None
+
+class Recommendation(BaseDBModel):
+    recommendation_id: str = Field(..., description="Unique identifier for the curated place")
+    name: str
+    category: RecommendationCategoryEnum
+    description: Optional[str] = None
+    address: Optional[str] = None
+    latitude: Optional[float] = Field(None, ge=-90, le=90)
+    longitude: Optional[float] = Field(None, ge=-180, le=180)
+    opening_hours: Dict[str, List[str]] = Field(
+        default_factory=dict,
+        description='Local hours per weekday, e.g. {"mon": ["12:00-14:30", "19:00-23:00"]}; empty means always open'
+    )
+    tags: List[str] = Field(default_factory=list, description="e.g. seafood, family, vegan")
+    price_level: Optional[int] = Field(None, ge=1, le=4)
+    phone: Optional[str] = None
+    url: Optional[str] = None
+    image_url: Optional[str] = None
+    priority: int = Field(0, description="Higher is shown first among places at a similar distance")
+    active: bool = True
+
+class TransportRequest(BaseDBModel):
+    transport_id: str = Field(..., description="Unique identifier for the taxi/shuttle request")
+    guest_id: str
+    room_number: Optional[str] = None
+    mode: TransportModeEnum
+    pickup_at: datetime = Field(..., description="UTC")
+    pickup_location: str
+    destination: Optional[str] = None
+    passengers: int = Field(1, ge=1, le=20)
+    status: TransportStatusEnum = TransportStatusEnum.REQUESTED
+    provider: str = Field("concierge", description="Connector that took the booking; 'concierge' when arranged by staff")
+    provider_ref: Optional[str] = None
+    driver: Optional[Dict[str, Any]] = Field(None, description="name, phone, vehicle, plate as reported by the provider")
+    eta_at: Optional[datetime] = Field(None, description="When the driver is expected at the pickup point (UTC)")
+    request_id: Optional[str] = Field(None, description="Chat request that created it")
+    work_order_id: Optional[str] = Field(None, description="Concierge work order, when staff arrange the ride")
+    language: str = "en"
+    history: List[Dict[str, Any]] = Field(default_factory=list)
+
+class LostItemReport(BaseDBModel):
+    report_id: str = Field(..., description="Unique identifier for the guest's lost-item report")
+    guest_id: str
+    room_number: Optional[str] = None
+    description: str = Field(..., min_length=2, max_length=500)
+    category: Optional[str] = None
+    lost_location: Optional[str] = None
+    lost_at: Optional[datetime] = None
+    status: LostReportStatusEnum = LostReportStatusEnum.OPEN
+    matched_item_id: Optional[str] = None
+    return_method: Optional[ReturnMethodEnum] = None
+    shipping_address: Optional[str] = None
+    carrier: Optional[str] = None
+    tracking_number: Optional[str] = None
+    returned_at: Optional[datetime] = None
+    language: str = "en"
+    history: List[Dict[str, Any]] = Field(default_factory=list)
+
+class FoundItem(BaseDBModel):
+    item_id: str = Field(..., description="Unique identifier for the found item")
+    description: str = Field(..., min_length=2, max_length=500)
+    category: Optional[str] = None
+    found_location: str = Field(..., description="Room number or area where it was found")
+    found_at: datetime
+    storage_location: Optional[str] = Field(None, description="Where it is kept, e.g. 'Security office, shelf B'")
+    photos: List[str] = Field(default_factory=list, description="Blob names")
+    logged_by: Optional[str] = None
+    status: FoundItemStatusEnum = FoundItemStatusEnum.STORED
+    report_id: Optional[str] = None
+
+class Incident(BaseDBModel):
+    incident_id: str = Field(..., description="Unique identifier for the emergency incident")
+    incident_type: IncidentTypeEnum
+    status: IncidentStatusEnum = IncidentStatusEnum.REPORTED
+    guest_id: Optional[str] = None
+    room_number: Optional[str] = None
+    location: Optional[str] = Field(None, description="Where it is happening if not the guest's room")
+    message: str = Field(..., max_length=1000)
+    source: str = Field("chat", description="chat, staff or an integration such as the fire panel")
+    request_id: Optional[str] = None
+    alerts: List[Dict[str, Any]] = Field(default_factory=list, description="Per-channel delivery results of each alert round")
+    alert_rounds: int = 0
+    last_alert_at: Optional[datetime] = None
+    acknowledged_by: Optional[str] = None
+    acknowledged_at: Optional[datetime] = None
+    resolved_at: Optional[datetime] = None
+    timeline: List[Dict[str, Any]] = Field(default_factory=list)
+
+class NpsSurvey(BaseDBModel):
+    survey_id: str = Field(..., description="Unique identifier for the checkout survey")
+    stay_id: str = Field(..., description="guest_id and checkout date; one survey per stay")
+    hotel_id: str
+    guest_id: str
+    room_number: Optional[str] = None
+    check_in_date: Optional[datetime] = None
+    check_out_date: datetime
+    departments: List[DepartmentEnum] = Field(default_factory=list, description="Teams that handled requests during the stay")
+    language: str = "en"
+    channel: Optional[str] = Field(None, description="Channel the survey went out on")
+    status: SurveyStatusEnum = SurveyStatusEnum.SENT
+    expires_at: datetime
+    score: Optional[int] = Field(None, ge=0, le=10)
+    department_scores: Dict[str, int] = Field(default_factory=dict)
+    comment: Optional[str] = Field(None, max_length=2000)
+    answered_at: Optional[datetime] = None
+
+class RetentionPolicy(BaseDBModel):
+    hotel_id: str = Field(..., description="Property the policy applies to (HOTEL_ID)")
+    retention_days: int = Field(..., ge=1, le=3650, description="How long conversation content is kept")
+    mode: RetentionModeEnum = RetentionModeEnum.ANONYMIZE
+    strategy: RetentionStrategyEnum = RetentionStrategyEnum.SCHEDULED
+    updated_by: Optional[str] = None
+    last_run_at: Optional[datetime] = None
+    last_run: Dict[str, int] = Field(default_factory=dict, description="Documents purged/anonymized per collection")
+
+class ReportRecipient(BaseDBModel):
+    recipient_id: str = Field(..., description="Unique identifier for the report recipient")
+    name: Optional[str] = None
+    email: EmailStr
+    departments: List[DepartmentEnum] = Field(default_factory=list, description="Empty means all departments")
+    timezone: str = "UTC"
+    send_at: str = Field("07:00", pattern=r"^([01]\d|2[0-3]):[0-5]\d$", description="HH:MM local time")
+    active: bool = True
+    last_sent_on: Optional[str] = Field(None, description="Local date (YYYY-MM-DD) of the last digest sent")
+
+    class Config:
+        schema_extra = {
+            "example": {
+                "recipient_id": "hk_head",
+                "name": "Head of Housekeeping",
+                "email": "housekeeping.head@example.com",
+                "departments": ["housekeeping"],
+                "timezone": "Europe/London",
+                "send_at": "07:30"
+            }
+        }
+
 class MessageThread(BaseDBModel):
     thread_id: str = Field(..., description="Unique identifier for the message thread")
     request_id: str
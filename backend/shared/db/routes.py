@@ -1,6 +1,6 @@
 from fastapi import APIRouter, Depends, HTTPException, Query, WebSocket, WebSocketDisconnect, status, Body
 from typing import List, Optional
-from datetime import datetime
+from datetime import datetime, timezone
 from bson import ObjectId
 from fastapi_limiter.depends import RateLimiter
 from shared.db.database import DatabaseConnection
@@ -141,14 +141,14 @@ async def chat_websocket(websocket: WebSocket, guest_id: str):
                 continue
             # Store chat request
             chat_doc = ChatRequest(
-                request_id=f"req_{datetime.utcnow().timestamp()}",
+                request_id=f"req_{datetime.now(timezone.utc).timestamp()}",
                 guest_id=guest_id,
                 message=message,
                 department=DepartmentEnum.FRONT_DESK,  # Optionally route
                 status=StatusEnum.PENDING,
                 sentiment=0.0,
-                created_at=datetime.utcnow(),
-                updated_at=datetime.utcnow()
+                created_at=datetime.now(timezone.utc),
+                updated_at=datetime.now(timezone.utc)
             )
             async with DatabaseConnection.get_connection() as conn:
                 await conn["virtualbutler"]["chat_requests"].insert_one(chat_doc.model_dump(by_alias=True))
@@ -0,0 +1,156 @@
+"""
+In-room device control for smart rooms. Recognised requests ("turn up the AC", "lights off")
+are sent to the room's devices through the configured connector:
+
+- DEVICE_CONNECTOR=rest: POST {DEVICE_REST_URL}/rooms/{room}/devices/{device} on the vendor's API
+- DEVICE_CONNECTOR=mqtt: publish to {MQTT_TOPIC_PREFIX}/{room}/{device}/set on the building broker
+
+Rooms opt in through `rooms.smart_room` / `rooms.devices`. When the room isn't smart, the device
+isn't installed or the connector fails, callers fall back to a maintenance work order.
+"""
+import json
+import os
+import re
+from typing import List, Optional
+
+import aiomqtt
+import httpx
+import structlog
+from pydantic import BaseModel
+
+from shared.db.database import DatabaseConnection
+from shared.sandbox import suppress_staff_alert
+
+logger = structlog.get_logger()
+
+DEVICE_CONNECTOR = os.getenv("DEVICE_CONNECTOR", "none").lower()
+DEVICE_REST_URL = os.getenv("DEVICE_REST_URL")
+DEVICE_REST_TOKEN = os.getenv("DEVICE_REST_TOKEN")
+MQTT_HOST = os.getenv("MQTT_HOST", "localhost")
+MQTT_PORT = int(os.getenv("MQTT_PORT", "1883"))
+MQTT_USERNAME = os.getenv("MQTT_USERNAME")
+MQTT_PASSWORD = os.getenv("MQTT_PASSWORD")
+MQTT_TOPIC_PREFIX = os.getenv("MQTT_TOPIC_PREFIX", "hotel/rooms")
+THERMOSTAT_STEP = float(os.getenv("THERMOSTAT_STEP", "2"))
+
+DEVICE_TYPES = ("thermostat", "lights", "curtains", "tv")
+
+class DeviceCommand(BaseModel):
+    device: str
+    action: str                        # on, off, up, down, set, open, close
+    value: Optional[float] = None
+
+THERMOSTAT = r"(?:the )?(?:ac|a/c|air ?con(?:ditioning)?|heat(?:ing|er)?|temperature|thermostat)"
+LIGHTS = r"(?:the )?(?:lights?|lamps?)"
+CURTAINS = r"(?:the )?(?:curtains|blinds|drapes)"
+COMMAND_PATTERNS = [
+    (re.compile(rf"\bset {THERMOSTAT} to (\d{{2}}(?:\.\d)?)"), "thermostat", "set"),
+    (re.compile(rf"\bturn (up|down) {THERMOSTAT}|\bturn {THERMOSTAT} (up|down)"), "thermostat", None),
+    (re.compile(rf"\bturn (on|off) {THERMOSTAT}|\b{THERMOSTAT} (on|off)\b"), "thermostat", None),
+    (re.compile(rf"\bturn (on|off) {LIGHTS}|\b{LIGHTS} (on|off)\b|\bswitch (on|off) {LIGHTS}"), "lights", None),
+    (re.compile(rf"\b(dim) {LIGHTS}"), "lights", "down"),
+    (re.compile(rf"\b(open|close) {CURTAINS}"), "curtains", None),
+    (re.compile(r"\bturn (on|off) (?:the )?(?:tv|television)"), "tv", None),
+]
+# Messages describing a fault are maintenance jobs, not remote-control requests
+FAULT_PATTERN = re.compile(r"\b(broken|not working|doesn'?t work|won'?t|leak|noise|noisy|smell)")
+
+def parse_device_command(message: str) -> Optional[DeviceCommand]:
+    text = message.lower()
+    if FAULT_PATTERN.search(text):
+        return None
+    for pattern, device, action in COMMAND_PATTERNS:
+        match = pattern.search(text)
+        if not match:
+            continue
+        captured = next(g for g in match.groups() if g)
+        if action == "set":
+            return DeviceCommand(device=device, action="set", value=float(captured))
+        if action == "down":
+            return DeviceCommand(device=device, action="down")
+        if device == "thermostat" and captured in ("up", "down"):
+            return DeviceCommand(device=device, action=captured, value=THERMOSTAT_STEP)
+        return DeviceCommand(device=device, action=captured)
+    return None
+
+# --- Connectors ---
+
+class DeviceConnector:
+    name = "none"
+
+    async def send(self, room_number: str, command: DeviceCommand) -> bool:
+        return False
+
+class RestDeviceConnector(DeviceConnector):
+    name = "rest"
+
+    async def send(self, room_number: str, command: DeviceCommand) -> bool:
+        headers = {"Authorization": f"Bearer {DEVICE_REST_TOKEN}"} if DEVICE_REST_TOKEN else {}
+        async with httpx.AsyncClient(timeout=5.0) as client:
+            resp = await client.post(
+                f"{DEVICE_REST_URL.rstrip('/')}/rooms/{room_number}/devices/{command.device}",
+                json=command.model_dump(exclude_none=True),
+                headers=headers
+            )
+            resp.raise_for_status()
+        return True
+
+class MqttDeviceConnector(DeviceConnector):
+    name = "mqtt"
+
+    async def send(self, room_number: str, command: DeviceCommand) -> bool:
+        async with aiomqtt.Client(MQTT_HOST, port=MQTT_PORT, username=MQTT_USERNAME,
+                                  password=MQTT_PASSWORD, timeout=5) as client:
+            await client.publish(
+                f"{MQTT_TOPIC_PREFIX}/{room_number}/{command.device}/set",
+                payload=json.dumps(command.model_dump(exclude_none=True)),
+                qos=1
+            )
+        return True
+
+def get_connector() -> DeviceConnector:
+    if DEVICE_CONNECTOR == "rest" and DEVICE_REST_URL:
+        return RestDeviceConnector()
+    if DEVICE_CONNECTOR == "mqtt":
+        return MqttDeviceConnector()
+    return DeviceConnector()
+
+connector = get_connector()
+
+# --- Room capabilities ---
+
+async def room_devices(room_number: str) -> List[str]:
+    """Devices that can be controlled remotely in the room; empty unless it is a smart room."""
+    async with DatabaseConnection.get_connection() as conn:
+        room = await conn["virtualbutler"]["rooms"].find_one({"room_number": room_number})
+    if not room or not room.get("smart_room"):
+        return []
+    return room.get("devices") or list(DEVICE_TYPES)
+
+async def set_room_devices(room_number: str, smart_room: bool, devices: List[str]) -> dict:
+    unknown = set(devices) - set(DEVICE_TYPES)
+    if unknown:
+        raise ValueError(f"Unknown device types {sorted(unknown)}; supported: {list(DEVICE_TYPES)}")
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["rooms"].find_one_and_update(
+            {"room_number": room_number},
+            {"$set": {"room_number": room_number, "smart_room": smart_room, "devices": devices}},
+            upsert=True,
+            return_document=True
+        )
+
+async def actuate(room_number: str, command: DeviceCommand) -> bool:
+    """Sends the command if the room supports it; False means the caller should raise a work order."""
+    if connector.name == "none" or command.device not in await room_devices(room_number):
+        return False
+    if suppress_staff_alert("device", room_number=room_number, device=command.device, action=command.action):
+        return True
+    try:
+        sent = await connector.send(room_number, command)
+    except Exception as e:
+        logger.error("device_command_failed", room_number=room_number, device=command.device,
+                     action=command.action, connector=connector.name, error=str(e))
+        return False
+    logger.info("device_command_sent", room_number=room_number, device=command.device,
+                action=command.action, value=command.value, connector=connector.name)
+    return sent
@@ -0,0 +1,65 @@
+from datetime import datetime, timezone
+from typing import Optional, List
+
+import structlog
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import DepartmentEnum, PriorityEnum, StatusEnum
+
+logger = structlog.get_logger()
+
+DND_HOLD_REASON = "dnd"
+
+async def is_room_dnd(room_number: Optional[str]) -> bool:
+    if not room_number:
+        return False
+    async with DatabaseConnection.get_connection() as conn:
+        room = await conn["virtualbutler"]["rooms"].find_one({"room_number": room_number})
+    return bool(room and room.get("dnd_active"))
+
+async def set_room_dnd(room_number: str, active: bool, set_by: Optional[str] = None) -> dict:
+    """Sets or clears the Do-Not-Disturb flag for a room and returns the updated room document."""
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        room = await conn["virtualbutler"]["rooms"].find_one_and_update(
+            {"room_number": room_number},
+            {"$set": {"room_number": room_number, "dnd_active": active, "dnd_updated_at": now,
+                      "dnd_set_by": set_by, "updated_at": now}},
+            upsert=True,
+            return_document=True
+        )
+    logger.info("room_dnd_updated", room_number=room_number, dnd_active=active, set_by=set_by)
+    return room
+
+def should_hold_for_dnd(department: str, priority: str) -> bool:
+    """Only non-urgent housekeeping work waits for DND to clear."""
+    return department == DepartmentEnum.HOUSEKEEPING and priority != PriorityEnum.URGENT
+
+async def release_dnd_holds(room_number: Optional[str] = None) -> List[dict]:
+    """
+    Releases housekeeping orders held for DND whose room no longer has DND active.
+    If room_number is given only that room is considered.
+    Returns the released work order documents.
+    """
+    query = {"status": StatusEnum.ON_HOLD, "metadata.hold_reason": DND_HOLD_REASON}
+    if room_number:
+        query["metadata.room_number"] = room_number
+    released = []
+    async with DatabaseConnection.get_connection() as conn:
+        async for doc in conn["virtualbutler"]["work_orders"].find(query):
+            room = doc.get("metadata", {}).get("room_number")
+            if await is_room_dnd(room):
+                continue
+            restored_status = doc.get("metadata", {}).get("held_status") or StatusEnum.PENDING
+            updated = await conn["virtualbutler"]["work_orders"].find_one_and_update(
+                {"_id": doc["_id"], "status": StatusEnum.ON_HOLD},
+                {"$set": {"status": restored_status, "updated_at": datetime.now(timezone.utc),
+                          "metadata.dnd_released_at": datetime.now(timezone.utc)},
+                 "$unset": {"metadata.hold_reason": "", "metadata.held_status": ""},
+                 "$inc": {"version": 1}},
+                return_document=True
+            )
+            if updated:
+                released.append(updated)
+                logger.info("dnd_hold_released", work_order_id=updated.get("work_order_id"), room_number=room)
+    return released
@@ -0,0 +1,121 @@
+"""
+Draining for rolling deploys: take a replica out of service without losing a message.
+
+An admin POST to /api/v1/admin/drain, or SIGUSR1 to the process, starts the drain:
+1. The replica stops taking new work. Chat requests get 503 with Retry-After, so clients retry against
+   another replica. /readiness fails, so the load balancer stops routing here. The queue consumer
+   stops receiving; a message that arrives mid-drain is abandoned straight back to the queue.
+2. Work already started is finished. That covers queue messages being processed and the outbox of
+   background sends started with `track()`: Service Bus publishes, event webhooks, shadow decisions.
+3. With DRAIN_EXIT=true (the default) the process then sends itself SIGTERM for the server's normal
+   graceful shutdown. The deploy can also poll GET /api/v1/admin/drain until it reports `drained`.
+
+After DRAIN_TIMEOUT_SECONDS the replica exits anyway. A queue message still locked by it is redelivered
+once its lock expires, so it is late but not lost.
+"""
+import asyncio
+import os
+import signal
+from contextlib import asynccontextmanager
+from datetime import datetime, timezone
+from typing import Coroutine, Optional, Set
+
+import structlog
+
+from shared.errors import ApiError, ErrorCode
+
+logger = structlog.get_logger()
+
+DRAIN_TIMEOUT_SECONDS = float(os.getenv("DRAIN_TIMEOUT_SECONDS", "120"))
+DRAIN_RETRY_AFTER_SECONDS = int(os.getenv("DRAIN_RETRY_AFTER_SECONDS", "5"))
+DRAIN_EXIT = os.getenv("DRAIN_EXIT", "true").lower() == "true"
+
+class Drain:
+    def __init__(self):
+        self.draining = False
+        self.drained = False
+        self.started_at: Optional[datetime] = None
+        self.reason: Optional[str] = None
+        self.in_flight = 0
+        self.tasks: Set[asyncio.Task] = set()
+        self._changed: Optional[asyncio.Event] = None
+
+    def _notify(self) -> None:
+        if self._changed is not None:
+            self._changed.set()
+
+    def track(self, coro: Coroutine) -> asyncio.Task:
+        """create_task() for sends that must finish before the process exits."""
+        task = asyncio.create_task(coro)
+        self.tasks.add(task)
+
+        def done(finished: asyncio.Task) -> None:
+            self.tasks.discard(finished)
+            self._notify()
+
+        task.add_done_callback(done)
+        return task
+
+    @asynccontextmanager
+    async def processing(self):
+        """Wraps handling of one queue message."""
+        self.in_flight += 1
+        try:
+            yield
+        finally:
+            self.in_flight -= 1
+            self._notify()
+
+    def ensure_accepting(self) -> None:
+        if self.draining:
+            raise ApiError(503, "This server is restarting; please retry", ErrorCode.UNAVAILABLE,
+                           headers={"Retry-After": str(DRAIN_RETRY_AFTER_SECONDS)})
+
+    @property
+    def idle(self) -> bool:
+        return self.in_flight == 0 and not self.tasks
+
+    def status(self) -> dict:
+        return {"draining": self.draining, "drained": self.drained, "reason": self.reason,
+                "started_at": self.started_at, "in_flight_messages": self.in_flight, "pending_sends": len(self.tasks)}
+
+    async def wait_idle(self, timeout: float = DRAIN_TIMEOUT_SECONDS) -> bool:
+        self._changed = self._changed or asyncio.Event()
+        loop = asyncio.get_running_loop()
+        deadline = loop.time() + timeout
+        while not self.idle:
+            remaining = deadline - loop.time()
+            if remaining <= 0:
+                return False
+            self._changed.clear()
+            try:
+                await asyncio.wait_for(self._changed.wait(), min(remaining, 1.0))
+            except asyncio.TimeoutError:
+                pass
+        return True
+
+    def start(self, reason: str, exit_when_done: bool = DRAIN_EXIT) -> bool:
+        """Starts draining in the background; False if a drain is already under way."""
+        if self.draining:
+            return False
+        self.draining, self.reason, self.started_at = True, reason, datetime.now(timezone.utc)
+        logger.warning("drain_started", reason=reason, in_flight=self.in_flight, pending_sends=len(self.tasks))
+        asyncio.create_task(self._finish(exit_when_done))
+        return True
+
+    async def _finish(self, exit_when_done: bool) -> None:
+        idle = await self.wait_idle()
+        self.drained = True
+        if idle:
+            logger.info("drain_completed", seconds=round((datetime.now(timezone.utc) - self.started_at).total_seconds(), 1))
+        else:
+            logger.error("drain_timed_out", in_flight=self.in_flight, pending_sends=len(self.tasks))
+        if exit_when_done:
+            os.kill(os.getpid(), signal.SIGTERM)
+
+    def install_signal_handler(self, sig: int = signal.SIGUSR1) -> None:
+        """Lets the deploy tooling start the drain with a signal; call from a startup hook."""
+        asyncio.get_running_loop().add_signal_handler(sig, lambda: self.start(f"signal {signal.Signals(sig).name}"))
+
+# One per process: each service runs in its own
+drain = Drain()
@@ -0,0 +1,134 @@
+"""
+Common JSON error envelope for all services:
+
+    {"error": {"code": "not_found", "message": "Work order not found", "traceID": "..."}}
+
+Error codes (stable, machine-readable; clients should switch on `code`, not on `message`):
+  - invalid_request         400  malformed or semantically invalid input
+  - unauthorized            401  missing, invalid or expired credentials
+  - forbidden               403  authenticated but not allowed
+  - not_found               404  resource does not exist (or is not visible to the caller)
+  - conflict                409  state conflict (duplicate, version mismatch, invalid transition)
+  - precondition_required   428  the update must be conditional (If-Match)
+  - payload_too_large       413  upload or body exceeds limits
+  - unsupported_media_type  415  content type not accepted
+  - validation_failed       422  request body/query failed schema validation
+  - rate_limited            429  too many requests
+  - quota_exceeded          429  guest has too many open requests
+  - internal_error          500  unexpected server error (details are logged, never returned)
+  - upstream_error          502  a dependency (blob storage, queue, connector) failed
+  - unavailable             503  service draining or temporarily unavailable
+  - timeout                 504  request exceeded its time budget
+
+ERROR_INCLUDE_LEGACY_DETAIL=true (default) also emits the old top-level "detail" field so existing
+clients keep working during migration.
+"""
+import os
+import uuid
+from enum import Enum
+from typing import Optional
+
+import structlog
+from fastapi import FastAPI, HTTPException, Request
+from fastapi.exceptions import RequestValidationError
+from fastapi.responses import JSONResponse
+from starlette.exceptions import HTTPException as StarletteHTTPException
+
+from shared.tracing import clean_request_id, current_request_id
+
+logger = structlog.get_logger()
+
+INCLUDE_LEGACY_DETAIL = os.getenv("ERROR_INCLUDE_LEGACY_DETAIL", "true").lower() == "true"
+
+class ErrorCode(str, Enum):
+    INVALID_REQUEST = "invalid_request"
+    UNAUTHORIZED = "unauthorized"
+    FORBIDDEN = "forbidden"
+    NOT_FOUND = "not_found"
+    CONFLICT = "conflict"
+    PRECONDITION_REQUIRED = "precondition_required"
+    PAYLOAD_TOO_LARGE = "payload_too_large"
+    UNSUPPORTED_MEDIA_TYPE = "unsupported_media_type"
+    VALIDATION_FAILED = "validation_failed"
+    RATE_LIMITED = "rate_limited"
+    QUOTA_EXCEEDED = "quota_exceeded"
+    INTERNAL_ERROR = "internal_error"
+    UPSTREAM_ERROR = "upstream_error"
+    UNAVAILABLE = "unavailable"
+    TIMEOUT = "timeout"
+
+STATUS_CODES = {
+    400: ErrorCode.INVALID_REQUEST,
+    401: ErrorCode.UNAUTHORIZED,
+    403: ErrorCode.FORBIDDEN,
+    404: ErrorCode.NOT_FOUND,
+    409: ErrorCode.CONFLICT,
+    428: ErrorCode.PRECONDITION_REQUIRED,
+    413: ErrorCode.PAYLOAD_TOO_LARGE,
+    415: ErrorCode.UNSUPPORTED_MEDIA_TYPE,
+    422: ErrorCode.VALIDATION_FAILED,
+    429: ErrorCode.RATE_LIMITED,
+    500: ErrorCode.INTERNAL_ERROR,
+    502: ErrorCode.UPSTREAM_ERROR,
+    503: ErrorCode.UNAVAILABLE,
+    504: ErrorCode.TIMEOUT,
+}
+
+class ApiError(Exception):
+    """Raise from handlers when a specific error code (rather than the status default) is needed."""
+
+    def __init__(self, status_code: int, message: str, code: Optional[ErrorCode] = None, headers: Optional[dict] = None,
+                 **extra):
+        super().__init__(message)
+        self.status_code = status_code
+        self.message = message
+        self.code = code or code_for_status(status_code)
+        self.headers = headers
+        # Extra fields for the error object, e.g. the current document on a version conflict
+        self.extra = extra
+
+def code_for_status(status_code: int) -> ErrorCode:
+    if status_code in STATUS_CODES:
+        return STATUS_CODES[status_code]
+    return ErrorCode.INTERNAL_ERROR if status_code >= 500 else ErrorCode.INVALID_REQUEST
+
+def get_trace_id(request: Optional[Request]) -> str:
+    if request is None:
+        return current_request_id.get() or uuid.uuid4().hex
+    trace_id = (getattr(request.state, "trace_id", None) or current_request_id.get()
+                or clean_request_id(request.headers.get("X-Request-ID")))
+    return trace_id or uuid.uuid4().hex
+
+def error_response(status_code: int, message: str, code: Optional[ErrorCode] = None,
+                   request: Optional[Request] = None, headers: Optional[dict] = None, **extra) -> JSONResponse:
+    code = code or code_for_status(status_code)
+    body = {"error": {"code": code.value if isinstance(code, ErrorCode) else code,
+                      "message": message,
+                      "traceID": get_trace_id(request),
+                      **extra}}
+    if INCLUDE_LEGACY_DETAIL:
+        body["detail"] = message
+    return JSONResponse(status_code=status_code, content=body, headers=headers)
+
+def install_error_handlers(app: FastAPI) -> None:
+    @app.exception_handler(ApiError)
+    async def api_error_handler(request: Request, exc: ApiError):
+        return error_response(exc.status_code, exc.message, exc.code, request, exc.headers, **exc.extra)
+
+    @app.exception_handler(StarletteHTTPException)
+    async def http_exception_handler(request: Request, exc: StarletteHTTPException):
+        message = exc.detail if isinstance(exc.detail, str) else "Request failed"
+        return error_response(exc.status_code, message, request=request, headers=getattr(exc, "headers", None))
+
+    @app.exception_handler(RequestValidationError)
+    async def validation_exception_handler(request: Request, exc: RequestValidationError):
+        fields = [{"field": ".".join(str(p) for p in err.get("loc", [])), "message": err.get("msg")} for err in exc.errors()]
+        return error_response(422, "Request validation failed", ErrorCode.VALIDATION_FAILED, request, fields=fields)
+
+    @app.exception_handler(Exception)
+    async def unhandled_exception_handler(request: Request, exc: Exception):
+        trace_id = get_trace_id(request)
+        logger.error("unhandled_exception", error=str(exc), trace_id=trace_id, path=request.url.path)
+        request.state.trace_id = trace_id
+        return error_response(500, "An unexpected error occurred. Please try again later.",
+                              ErrorCode.INTERNAL_ERROR, request)
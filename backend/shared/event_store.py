@@ -0,0 +1,340 @@
+"""
+Event-sourced persistence for work orders, selected per deployment with WORK_ORDER_PERSISTENCE=events
+(the default, `state`, updates documents in place as before).
+
+In `events` mode the source of truth is `work_order_events`, an append-only stream per order, and the
+`work_orders` collection is a projection of it:
+- An event records what happened to the order: `created` (or `snapshot`, for orders that predate the
+  stream) carries the whole document, `changed` the top-level fields that got new values and those
+  that were removed, `deleted` nothing. Its `sequence` is the order's version after the event
+  (shared/concurrency.py).
+- (work_order_id, sequence) is unique, so of two writers racing on the same order only one can append;
+  the other re-reads and tries again. The projection is written after the append, and a writer that
+  finds the stream ahead of the projection replays the missing events first.
+- Handlers don't change: DatabaseConnection hands out a client whose `work_orders` collection turns
+  each update or delete into an append plus a projection write. Mongo still evaluates the filter; the
+  update operators the services use ($set, $unset, $inc, $push, $addToSet) are applied by
+  `apply_update`. New orders are inserted first, so unique indexes still reject duplicates, and then
+  their `created` event is appended.
+- Replaying a stream up to a moment gives the order as it was then (GET .../as-of?at=...), and
+  POST /api/v1/admin/event-store/rebuild re-derives every projection from the streams, snapshotting
+  any order that has none yet (run it once when switching an existing deployment over).
+
+install() must run before field_cipher.start() so the stream holds the same ciphertext as the projection.
+"""
+import copy
+import os
+import uuid
+from datetime import datetime, timezone
+from typing import Any, List, Optional
+
+import structlog
+from pymongo.errors import DuplicateKeyError
+from pymongo.results import DeleteResult, UpdateResult
+
+from shared.db.database import DatabaseConnection
+from shared.security.field_crypto import field_cipher
+from shared.tracing import current_request_id
+
+logger = structlog.get_logger()
+
+WORK_ORDER_PERSISTENCE = os.getenv("WORK_ORDER_PERSISTENCE", "state").lower()
+EVENT_SOURCED = WORK_ORDER_PERSISTENCE == "events"
+EVENTS_COLLECTION = "work_order_events"
+APPEND_RETRIES = 5
+
+CREATED = "created"
+SNAPSHOT = "snapshot"
+CHANGED = "changed"
+DELETED = "deleted"
+# Projection bookkeeping, not part of what an event records
+UNRECORDED_FIELDS = {"_id", "version"}
+
+class EventStoreError(Exception):
+    def __init__(self, message: str, status_code: int = 409):
+        super().__init__(message)
+        self.status_code = status_code
+
+def require_event_sourcing() -> None:
+    if not EVENT_SOURCED:
+        raise EventStoreError("Work orders are not event-sourced in this deployment (WORK_ORDER_PERSISTENCE=state)")
+
+# --- Pure helpers: applying updates, diffing and replaying ---
+
+def _parent(doc: dict, path: str, create: bool):
+    *parents, leaf = path.split(".")
+    for key in parents:
+        if not isinstance(doc.get(key), dict):
+            if not create:
+                return None, leaf
+            doc[key] = {}
+        doc = doc[key]
+    return doc, leaf
+
+def apply_update(doc: dict, update: dict) -> dict:
+    """Mongo update semantics for the operators work-order writes use; returns a new document."""
+    result = copy.deepcopy(doc)
+    for operator, fields in update.items():
+        for path, value in fields.items():
+            if operator == "$unset":
+                parent, leaf = _parent(result, path, create=False)
+                if parent is not None:
+                    parent.pop(leaf, None)
+                continue
+            parent, leaf = _parent(result, path, create=True)
+            if operator == "$set":
+                parent[leaf] = value
+            elif operator == "$inc":
+                parent[leaf] = (parent.get(leaf) or 0) + value
+            elif operator in ("$push", "$addToSet"):
+                items = value["$each"] if isinstance(value, dict) and "$each" in value else [value]
+                target = parent.setdefault(leaf, [])
+                target.extend(i for i in items if operator == "$push" or i not in target)
+            elif operator != "$setOnInsert":
+                raise EventStoreError(f"{operator} is not supported on event-sourced work orders", 500)
+    return result
+
+def changes_between(before: dict, after: dict) -> dict:
+    return {
+        "set": {k: v for k, v in after.items() if k not in UNRECORDED_FIELDS and (k not in before or before[k] != v)},
+        "unset": [k for k in before if k not in UNRECORDED_FIELDS and k not in after],
+    }
+
+def stream_event(work_order_id: str, sequence: int, event_type: str, data: Optional[dict] = None) -> dict:
+    return {
+        "event_id": uuid.uuid4().hex,
+        "work_order_id": work_order_id,
+        "sequence": sequence,
+        "type": event_type,
+        "data": data or {},
+        "occurred_at": datetime.now(timezone.utc),
+        "trace_id": current_request_id.get(),
+    }
+
+def document_event(doc: dict, event_type: str = CREATED) -> dict:
+    document = {k: v for k, v in doc.items() if k not in UNRECORDED_FIELDS}
+    return stream_event(doc["work_order_id"], doc.get("version") or 0, event_type, {"document": document})
+
+def _utc(moment: datetime) -> datetime:
+    return moment.replace(tzinfo=timezone.utc) if moment.tzinfo is None else moment
+
+def apply_event(state: Optional[dict], event: dict) -> Optional[dict]:
+    if event["type"] in (CREATED, SNAPSHOT):
+        state = copy.deepcopy(event["data"]["document"])
+    elif event["type"] == DELETED:
+        return None
+    elif state is None:
+        raise EventStoreError(f"Stream for {event['work_order_id']} changes an order it never created", 500)
+    else:
+        state = {**state, **copy.deepcopy(event["data"]["set"])}
+        for field in event["data"]["unset"]:
+            state.pop(field, None)
+    state["version"] = event["sequence"]
+    return state
+
+def replay(events: List[dict], state: Optional[dict] = None, as_of: Optional[datetime] = None) -> Optional[dict]:
+    """Folds events (in sequence order) onto `state`, stopping at the first one after `as_of`."""
+    for event in events:
+        if as_of is not None and _utc(event["occurred_at"]) > _utc(as_of):
+            break
+        state = apply_event(state, event)
+    return state
+
+# --- Projection ---
+
+async def project(orders, state: dict) -> None:
+    """Writes `state` unless the projection already reflects a later event."""
+    await orders.replace_one(
+        {"work_order_id": state["work_order_id"],
+         "$or": [{"version": {"$lt": state["version"]}}, {"version": None}]},
+        {k: v for k, v in state.items() if k != "_id"}
+    )
+
+async def catch_up(orders, events, work_order_id: str) -> Optional[dict]:
+    """Applies any events the projection is missing (e.g. after a crash between append and projection)."""
+    current = await orders.find_one({"work_order_id": work_order_id})
+    after = (current.get("version") or 0) if current else -1
+    missing = await events.find({"work_order_id": work_order_id, "sequence": {"$gt": after}},
+                                {"_id": 0}).sort("sequence", 1).to_list(length=None)
+    if not missing:
+        return current
+    state = replay(missing, current)
+    if state is None:
+        await orders.delete_one({"work_order_id": work_order_id})
+    else:
+        await orders.replace_one({"work_order_id": work_order_id}, {k: v for k, v in state.items() if k != "_id"},
+                                 upsert=True)
+    logger.warning("work_order_projection_caught_up", work_order_id=work_order_id, events=len(missing))
+    return state
+
+# --- Transparent client wrapper ---
+
+class EventSourcedCollection:
+    """Stands in for `work_orders`: writes are appended to the stream, then projected."""
+
+    def __init__(self, collection, events):
+        self._coll = collection
+        self._events = events
+
+    def __getattr__(self, name):
+        return getattr(self._coll, name)
+
+    async def _ensure_stream(self, doc: dict) -> None:
+        if not await self._events.find_one({"work_order_id": doc["work_order_id"]}, {"_id": 1}):
+            try:
+                await self._events.insert_one(document_event(doc, SNAPSHOT))
+            except DuplicateKeyError:
+                pass
+
+    async def _write(self, filter: dict, update: Optional[dict], sort=None) -> Optional[tuple]:
+        """Applies `update` (None deletes) to the order matching `filter`; returns (before, after) or None."""
+        for _ in range(APPEND_RETRIES):
+            before = await self._coll.find_one(filter, sort=sort)
+            if not before:
+                return None
+            await self._ensure_stream(before)
+            sequence = (before.get("version") or 0) + 1
+            after = None
+            if update is None:
+                event = stream_event(before["work_order_id"], sequence, DELETED)
+            else:
+                after = {**apply_update(before, update), "version": sequence}
+                event = stream_event(before["work_order_id"], sequence, CHANGED, changes_between(before, after))
+            try:
+                await self._events.insert_one(event)
+            except DuplicateKeyError:
+                # Another writer got there first, or the projection is behind: catch up and re-evaluate
+                await catch_up(self._coll, self._events, before["work_order_id"])
+                continue
+            if after is None:
+                await self._coll.delete_one({"_id": before["_id"]})
+            else:
+                await project(self._coll, after)
+            return before, after
+        raise EventStoreError("Work order changed too many times while updating; retry")
+
+    async def insert_one(self, document, *args, **kwargs):
+        result = await self._coll.insert_one(document, *args, **kwargs)
+        await self._events.insert_one(document_event(document))
+        return result
+
+    async def insert_many(self, documents, *args, **kwargs):
+        result = await self._coll.insert_many(documents, *args, **kwargs)
+        await self._events.insert_many([document_event(d) for d in documents])
+        return result
+
+    async def find_one_and_update(self, filter, update, *args, sort=None, upsert: bool = False,
+                                  return_document: Any = False, **kwargs):
+        if upsert:
+            raise EventStoreError("Upserts are not supported on event-sourced work orders", 500)
+        written = await self._write(filter, update, sort)
+        if not written:
+            return None
+        return written[1] if return_document else written[0]
+
+    async def update_one(self, filter, update, *args, **kwargs):
+        written = await self._write(filter, update)
+        return UpdateResult({"n": int(bool(written)), "nModified": int(bool(written))}, True)
+
+    async def update_many(self, filter, update, *args, **kwargs):
+        ids = await self._coll.distinct("work_order_id", filter)
+        written = [w for w in [await self._write({**filter, "work_order_id": i}, update) for i in ids] if w]
+        return UpdateResult({"n": len(written), "nModified": len(written)}, True)
+
+    async def delete_one(self, filter, *args, **kwargs):
+        written = await self._write(filter, None)
+        return DeleteResult({"n": int(bool(written))}, True)
+
+    async def delete_many(self, filter, *args, **kwargs):
+        ids = await self._coll.distinct("work_order_id", filter)
+        deleted = [w for w in [await self._write({**filter, "work_order_id": i}, None) for i in ids] if w]
+        return DeleteResult({"n": len(deleted)}, True)
+
+class _EventSourcingDatabase:
+    def __init__(self, database):
+        self._db = database
+
+    def __getitem__(self, name):
+        collection = self._db[name]
+        return EventSourcedCollection(collection, self._db[EVENTS_COLLECTION]) if name == "work_orders" else collection
+
+    def __getattr__(self, name):
+        if name.startswith("_") or hasattr(type(self._db), name):
+            return getattr(self._db, name)
+        return self[name]
+
+class EventSourcingClient:
+    def __init__(self, client):
+        self._client = client
+
+    def __getitem__(self, name):
+        return _EventSourcingDatabase(self._client[name])
+
+    def __getattr__(self, name):
+        if name.startswith("_") or hasattr(type(self._client), name):
+            return getattr(self._client, name)
+        return self[name]
+
+def install() -> None:
+    """Routes work-order writes through the stream when WORK_ORDER_PERSISTENCE=events; call after connect."""
+    if not EVENT_SOURCED:
+        return
+    DatabaseConnection.client_wrapper = EventSourcingClient
+    logger.info("work_order_event_sourcing_enabled")
+
+# --- History, temporal queries and rebuilds ---
+
+async def _decrypt(doc: Optional[dict]) -> Optional[dict]:
+    return await field_cipher.decrypt_document("work_orders", doc) if field_cipher.enabled else doc
+
+async def load_stream(work_order_id: str) -> List[dict]:
+    require_event_sourcing()
+    events = await DatabaseConnection.client["virtualbutler"][EVENTS_COLLECTION].find(
+        {"work_order_id": work_order_id}, {"_id": 0}
+    ).sort("sequence", 1).to_list(length=None)
+    for event in events:
+        for key in ("document", "set"):
+            if key in event["data"]:
+                event["data"][key] = await _decrypt(event["data"][key])
+    return events
+
+async def state_as_of(work_order_id: str, moment: datetime) -> Optional[dict]:
+    """The order as it stood at `moment`; None if it didn't exist then."""
+    require_event_sourcing()
+    events = await DatabaseConnection.client["virtualbutler"][EVENTS_COLLECTION].find(
+        {"work_order_id": work_order_id, "occurred_at": {"$lte": _utc(moment)}}, {"_id": 0}
+    ).sort("sequence", 1).to_list(length=None)
+    return await _decrypt(replay(events))
+
+async def rebuild_projections() -> dict:
+    require_event_sourcing()
+    db = DatabaseConnection.client["virtualbutler"]
+    orders, events = db["work_orders"], db[EVENTS_COLLECTION]
+    streamed = set(await events.distinct("work_order_id"))
+    seeded = 0
+    async for doc in orders.find({}):
+        if doc["work_order_id"] in streamed:
+            continue
+        try:
+            await events.insert_one(document_event(doc, SNAPSHOT))
+            seeded += 1
+        except DuplicateKeyError:
+            pass
+    rebuilt = 0
+    for work_order_id in streamed:
+        stream = await events.find({"work_order_id": work_order_id}, {"_id": 0}).sort("sequence", 1).to_list(length=None)
+        state = replay(stream)
+        if state is None:
+            await orders.delete_one({"work_order_id": work_order_id})
+        else:
+            await orders.replace_one({"work_order_id": work_order_id}, state, upsert=True)
+        rebuilt += 1
+    return {"seeded": seeded, "rebuilt": rebuilt}
+
+async def ensure_event_store_indexes() -> None:
+    if not EVENT_SOURCED:
+        return
+    async with DatabaseConnection.get_connection() as conn:
+        events = conn["virtualbutler"][EVENTS_COLLECTION]
+        await events.create_index([("work_order_id", 1), ("sequence", 1)], unique=True)
+        await events.create_index([("work_order_id", 1), ("occurred_at", 1)])
@@ -0,0 +1,247 @@
+"""
+Domain events: the public catalog of things that happen in the butler, with one JSON encoding for
+in-process subscribers and external webhook consumers alike.
+
+Every event is sent in the same envelope:
+
+    {"id": "...", "type": "work_order.status_changed", "version": 1, "occurred_at": "...",
+     "source": "work_orders", "trace_id": "...", "data": {...}}
+
+- `type` names never change. A change that could break a consumer (removing or renaming a field,
+  changing its meaning) bumps the event's `version`; adding an optional field does not.
+- Consumers should ignore unknown types and fields they don't use.
+- The catalog with each event's data schema is served at GET /api/v1/events/catalog.
+
+Webhook consumers are configured with EVENT_WEBHOOKS='[{"url": "...", "types": ["work_order.*"]}]'.
+Deliveries carry X-Butler-Event and, when EVENT_WEBHOOK_SECRET is set, an X-Butler-Signature
+header: "sha256=" + HMAC-SHA256 of the raw body.
+"""
+import asyncio
+import hashlib
+import hmac
+import json
+import os
+import uuid
+from datetime import datetime, timezone
+from typing import ClassVar, Dict, List, Optional, Type
+
+import httpx
+import structlog
+from pydantic import BaseModel, ConfigDict, Field
+
+from shared import metrics
+from shared.db.models import DepartmentEnum, PriorityEnum, StatusEnum
+from shared.draining import drain
+from shared.http_client import ResilientClient
+from shared.notifier import EventBus
+from shared.tracing import current_request_id
+
+logger = structlog.get_logger()
+
+EVENT_WEBHOOKS: List[dict] = json.loads(os.getenv("EVENT_WEBHOOKS", "[]"))
+EVENT_WEBHOOK_SECRET = os.getenv("EVENT_WEBHOOK_SECRET")
+EVENT_WEBHOOK_TIMEOUT_SECONDS = float(os.getenv("EVENT_WEBHOOK_TIMEOUT_SECONDS", "5"))
+
+ENVELOPE_FIELDS = {"event_id", "occurred_at", "source", "trace_id"}
+# Consumers dedupe on the event ID, so a delivery is safe to retry
+webhook_client = ResilientClient("event_webhooks", timeout=EVENT_WEBHOOK_TIMEOUT_SECONDS, retry_posts=True)
+
+class EventContractError(Exception):
+    def __init__(self, message: str, status_code: int = 422):
+        super().__init__(message)
+        self.status_code = status_code
+
+class DomainEvent(BaseModel):
+    model_config = ConfigDict(extra="forbid", use_enum_values=True)
+
+    TYPE: ClassVar[str]
+    VERSION: ClassVar[int] = 1
+
+    event_id: str = Field(default_factory=lambda: uuid.uuid4().hex)
+    occurred_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+    source: Optional[str] = None
+    trace_id: Optional[str] = Field(default_factory=current_request_id.get)
+
+    def encode(self) -> dict:
+        return {
+            "id": self.event_id,
+            "type": self.TYPE,
+            "version": self.VERSION,
+            "occurred_at": self.occurred_at.isoformat(),
+            "source": self.source,
+            "trace_id": self.trace_id,
+            "data": self.model_dump(mode="json", exclude=ENVELOPE_FIELDS),
+        }
+
+    @classmethod
+    def data_schema(cls) -> dict:
+        schema = cls.model_json_schema()
+        schema["properties"] = {k: v for k, v in schema.get("properties", {}).items() if k not in ENVELOPE_FIELDS}
+        schema["required"] = [k for k in schema.get("required", []) if k not in ENVELOPE_FIELDS]
+        return schema
+
+EVENT_TYPES: Dict[str, Type[DomainEvent]] = {}
+
+def register(cls: Type[DomainEvent]) -> Type[DomainEvent]:
+    EVENT_TYPES[cls.TYPE] = cls
+    return cls
+
+# --- Catalog ---
+
+@register
+class WorkOrderCreated(DomainEvent):
+    """A work order was created, from a chat request, by staff, by an integration or by a schedule."""
+    TYPE: ClassVar[str] = "work_order.created"
+
+    work_order_id: str
+    request_id: str
+    guest_id: str
+    department: DepartmentEnum
+    priority: PriorityEnum
+    status: StatusEnum
+    room_number: Optional[str] = None
+    parent_id: Optional[str] = None
+    loyalty_tier: Optional[str] = None
+    origin: str = Field(..., description="chat, staff, integration, group or preventive_maintenance")
+
+    @classmethod
+    def from_work_order(cls, work_order: dict, origin: str) -> "WorkOrderCreated":
+        return cls(work_order_id=work_order["work_order_id"], request_id=work_order["request_id"],
+                   guest_id=work_order["guest_id"], department=work_order["department"],
+                   priority=work_order["priority"], status=work_order["status"],
+                   room_number=(work_order.get("metadata") or {}).get("room_number"),
+                   parent_id=work_order.get("parent_id"), loyalty_tier=work_order.get("loyalty_tier"), origin=origin)
+
+@register
+class StatusChanged(DomainEvent):
+    """A work order moved to another status."""
+    TYPE: ClassVar[str] = "work_order.status_changed"
+
+    work_order_id: str
+    request_id: str
+    department: DepartmentEnum
+    previous_status: Optional[StatusEnum] = None
+    status: StatusEnum
+    actor: Optional[str] = Field(None, description="Who made the change; absent for automatic changes")
+
+    @classmethod
+    def from_work_order(cls, work_order: dict, previous_status: Optional[str], actor: Optional[str] = None) -> "StatusChanged":
+        return cls(work_order_id=work_order["work_order_id"], request_id=work_order["request_id"],
+                   department=work_order["department"], previous_status=previous_status,
+                   status=work_order["status"], actor=actor)
+
+@register
+class WorkOrderAssigned(DomainEvent):
+    """A work order was assigned to a member of staff."""
+    TYPE: ClassVar[str] = "work_order.assigned"
+
+    work_order_id: str
+    department: DepartmentEnum
+    assigned_staff: str
+
+@register
+class SLABreached(DomainEvent):
+    """An open work order passed its department's completion target (SLA_TARGET_MINUTES)."""
+    TYPE: ClassVar[str] = "work_order.sla_breached"
+
+    work_order_id: str
+    request_id: str
+    department: DepartmentEnum
+    priority: PriorityEnum
+    status: StatusEnum
+    target_minutes: int
+    open_minutes: int
+
+    @classmethod
+    def from_work_order(cls, work_order: dict, target_minutes: int, now: datetime) -> "SLABreached":
+        created_at = work_order["created_at"]
+        if created_at.tzinfo is None:
+            created_at = created_at.replace(tzinfo=timezone.utc)
+        return cls(work_order_id=work_order["work_order_id"], request_id=work_order["request_id"],
+                   department=work_order["department"], priority=work_order["priority"],
+                   status=work_order["status"], target_minutes=target_minutes,
+                   open_minutes=int((now - created_at).total_seconds() // 60))
+
+@register
+class IncidentOpened(DomainEvent):
+    """An emergency incident was reported."""
+    TYPE: ClassVar[str] = "incident.opened"
+
+    incident_id: str
+    incident_type: str
+    room_number: Optional[str] = None
+    location: Optional[str] = None
+    reported_via: str
+
+    @classmethod
+    def from_incident(cls, incident: dict) -> "IncidentOpened":
+        return cls(incident_id=incident["incident_id"], incident_type=incident["incident_type"],
+                   room_number=incident.get("room_number"), location=incident.get("location"),
+                   reported_via=incident.get("source", "chat").split(":", 1)[0])
+
+@register
+class FeedbackReceived(DomainEvent):
+    """A guest rated their stay or a completed request."""
+    TYPE: ClassVar[str] = "feedback.received"
+
+    guest_id: str
+    score: int = Field(..., ge=0, le=10)
+    comment: Optional[str] = None
+    work_order_id: Optional[str] = None
+    department: Optional[DepartmentEnum] = None
+
+def decode(envelope: dict) -> DomainEvent:
+    cls = EVENT_TYPES.get(envelope.get("type"))
+    if cls is None:
+        raise EventContractError(f"Unknown event type '{envelope.get('type')}'")
+    if envelope.get("version", 1) > cls.VERSION:
+        raise EventContractError(f"{cls.TYPE} version {envelope['version']} is newer than this build understands")
+    return cls(event_id=envelope["id"], occurred_at=envelope["occurred_at"], source=envelope.get("source"),
+               trace_id=envelope.get("trace_id"), **envelope.get("data", {}))
+
+def event_catalog() -> List[dict]:
+    return [{"type": cls.TYPE, "version": cls.VERSION, "description": (cls.__doc__ or "").strip(),
+             "data_schema": cls.data_schema()} for cls in EVENT_TYPES.values()]
+
+# --- Publishing ---
+
+def webhook_targets(event_type: str, hooks: List[dict]) -> List[str]:
+    """Hooks without `types` get everything; `work_order.*` matches every work-order event."""
+    targets = []
+    for hook in hooks:
+        types = hook.get("types") or ["*"]
+        if any(t in ("*", event_type) or (t.endswith(".*") and event_type.startswith(t[:-1])) for t in types):
+            targets.append(hook["url"])
+    return targets
+
+def sign(body: bytes, secret: str) -> str:
+    return "sha256=" + hmac.new(secret.encode(), body, hashlib.sha256).hexdigest()
+
+class EventPublisher:
+    """Publishes to the in-process `bus` and to the configured webhooks, without blocking the caller."""
+
+    def __init__(self, source: str):
+        self.source = source
+        self.bus = EventBus("domain_events")
+
+    async def publish(self, event: DomainEvent) -> dict:
+        event.source = event.source or self.source
+        envelope = event.encode()
+        self.bus.publish(envelope)
+        metrics.increment("butler_domain_events_total", type=event.TYPE, source=self.source)
+        for url in webhook_targets(event.TYPE, EVENT_WEBHOOKS):
+            drain.track(self._deliver(url, envelope))
+        return envelope
+
+    async def _deliver(self, url: str, envelope: dict) -> None:
+        body = json.dumps(envelope).encode()
+        headers = {"Content-Type": "application/json", "X-Butler-Event": envelope["type"]}
+        if EVENT_WEBHOOK_SECRET:
+            headers["X-Butler-Signature"] = sign(body, EVENT_WEBHOOK_SECRET)
+        try:
+            response = await webhook_client.post(url, content=body, headers=headers, trace_id=envelope.get("trace_id"))
+            response.raise_for_status()
+        except httpx.HTTPError as e:
+            metrics.increment("butler_event_webhook_failures_total", type=envelope["type"])
+            logger.error("event_webhook_failed", url=url, event_type=envelope["type"], event_id=envelope["id"],
+                         error=str(e))
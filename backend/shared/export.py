@@ -0,0 +1,121 @@
+"""
+Streaming tabular exports (CSV / XLSX) for admin reports.
+Rows come from an async iterator (typically a Mongo cursor) and are never all held in memory:
+CSV is written in chunks straight to the response; XLSX is written row by row to a temporary
+file with xlsxwriter's constant-memory mode and then streamed.
+"""
+import csv
+import io
+import os
+import tempfile
+from datetime import datetime, timezone
+from typing import Any, AsyncIterator, Callable, Dict, List, Optional
+from zoneinfo import ZoneInfo, ZoneInfoNotFoundError
+
+import xlsxwriter
+
+CSV_CHUNK_ROWS = 500
+# Spreadsheet apps run cells starting with these as formulas, so a guest's message could execute on open
+FORMULA_PREFIXES = ("=", "+", "-", "@", "\t", "\r")
+FILE_CHUNK_BYTES = 64 * 1024
+
+EXPORT_MEDIA_TYPES = {
+    "csv": "text/csv; charset=utf-8",
+    "xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+class ExportError(ValueError): pass
+
+def resolve_timezone(name: str) -> ZoneInfo:
+    try:
+        return ZoneInfo(name)
+    except (ZoneInfoNotFoundError, ValueError):
+        raise ExportError(f"Unknown timezone '{name}'")
+
+def parse_bound(value: Optional[str], tz: ZoneInfo) -> Optional[datetime]:
+    """Parses a from/to bound (date or datetime); naive values are read in the export timezone. Returns naive UTC."""
+    if not value:
+        return None
+    try:
+        parsed = datetime.fromisoformat(value)
+    except ValueError:
+        raise ExportError(f"Invalid date '{value}', expected ISO 8601")
+    if parsed.tzinfo is None:
+        parsed = parsed.replace(tzinfo=tz)
+    return parsed.astimezone(timezone.utc).replace(tzinfo=None)
+
+def escape_formula(value: Any) -> Any:
+    if isinstance(value, str) and value.startswith(FORMULA_PREFIXES):
+        return "'" + value
+    return value
+
+def format_cell(value: Any, tz: ZoneInfo) -> Any:
+    return escape_formula(_format_cell(value, tz))
+
+def _format_cell(value: Any, tz: ZoneInfo) -> Any:
+    if value is None:
+        return ""
+    if isinstance(value, datetime):
+        # Mongo returns naive UTC datetimes
+        aware = value if value.tzinfo else value.replace(tzinfo=timezone.utc)
+        return aware.astimezone(tz).isoformat(timespec="seconds")
+    if isinstance(value, (list, tuple, set)):
+        return ";".join(str(v) for v in value)
+    if isinstance(value, dict):
+        return ";".join(f"{k}={v}" for k, v in value.items())
+    if isinstance(value, bool):
+        return "true" if value else "false"
+    return value
+
+def select_columns(requested: Optional[str], available: List[str], default: List[str],
+                   dynamic_prefix: Optional[str] = None) -> List[str]:
+    """Validates a comma-separated column list; columns starting with dynamic_prefix are allowed as-is."""
+    if not requested:
+        return default
+    columns = [c.strip() for c in requested.split(",") if c.strip()]
+    unknown = [c for c in columns
+               if c not in available and not (dynamic_prefix and c.startswith(dynamic_prefix))]
+    if unknown:
+        raise ExportError(f"Unknown columns {unknown}; available: {available}")
+    return columns
+
+async def stream_csv(rows: AsyncIterator[Dict[str, Any]], columns: List[str],
+                     extract: Callable[[Dict[str, Any], str], Any], tz: ZoneInfo) -> AsyncIterator[bytes]:
+    buffer = io.StringIO()
+    writer = csv.writer(buffer)
+    writer.writerow(columns)
+    count = 0
+    async for row in rows:
+        writer.writerow([format_cell(extract(row, column), tz) for column in columns])
+        count += 1
+        if count % CSV_CHUNK_ROWS == 0:
+            yield buffer.getvalue().encode("utf-8")
+            buffer.seek(0)
+            buffer.truncate()
+    yield buffer.getvalue().encode("utf-8")
+
+async def stream_xlsx(rows: AsyncIterator[Dict[str, Any]], columns: List[str],
+                      extract: Callable[[Dict[str, Any], str], Any], tz: ZoneInfo,
+                      sheet_name: str = "Export") -> AsyncIterator[bytes]:
+    handle, path = tempfile.mkstemp(suffix=".xlsx")
+    os.close(handle)
+    try:
+        workbook = xlsxwriter.Workbook(path, {"constant_memory": True, "strings_to_urls": False})
+        sheet = workbook.add_worksheet(sheet_name[:31])
+        header = workbook.add_format({"bold": True})
+        for col, name in enumerate(columns):
+            sheet.write(0, col, name, header)
+        row_number = 1
+        async for row in rows:
+            for col, column in enumerate(columns):
+                sheet.write(row_number, col, format_cell(extract(row, column), tz))
+            row_number += 1
+        workbook.close()
+        with open(path, "rb") as f:
+            while chunk := f.read(FILE_CHUNK_BYTES):
+                yield chunk
+    finally:
+        os.remove(path)
+
+def export_filename(prefix: str, fmt: str, tz: ZoneInfo) -> str:
+    return f"{prefix}_{datetime.now(tz).strftime('%Y%m%d_%H%M')}.{fmt}"
@@ -0,0 +1,84 @@
+"""
+Optional fault-injection hooks for resilience testing (retries, DLQ, escalation paths).
+Opt-in twice: FAULT_INJECTION_ENABLED=true only takes effect when ENVIRONMENT is explicitly set to
+one of FAULT_INJECTION_ENVIRONMENTS (development, test, staging by default). A deployment that never
+set ENVIRONMENT, production included, never injects faults.
+
+Environment variables (probabilities are 0.0-1.0):
+  - FAULT_MONGO_LATENCY_MS / FAULT_MONGO_LATENCY_PROBABILITY: delay before Mongo operations
+    (capped at MAX_MONGO_LATENCY_MS; a malformed value means no delay)
+  - FAULT_SERVICE_BUS_ERROR_PROBABILITY: raise on Service Bus send/receive processing
+  - FAULT_NOTIFICATION_DROP_PROBABILITY: silently drop outgoing notifications
+"""
+import asyncio
+import os
+import random
+
+import structlog
+
+logger = structlog.get_logger()
+
+class InjectedFault(Exception): pass
+
+def _float_env(name: str) -> float:
+    try:
+        return max(0.0, min(1.0, float(os.getenv(name, "0"))))
+    except ValueError:
+        return 0.0
+
+MAX_MONGO_LATENCY_MS = 30000
+
+def _latency_env(name: str) -> int:
+    try:
+        return max(0, min(MAX_MONGO_LATENCY_MS, int(os.getenv(name, "0"))))
+    except ValueError:
+        logger.warning("fault_injection_bad_setting", setting=name, value=os.getenv(name))
+        return 0
+
+ENVIRONMENT = os.getenv("ENVIRONMENT", "").lower()
+FAULT_INJECTION_ENVIRONMENTS = {e.strip().lower() for e in
+                                os.getenv("FAULT_INJECTION_ENVIRONMENTS", "development,test,staging").split(",")
+                                if e.strip() and e.strip().lower() != "production"}
+ENABLED = (os.getenv("FAULT_INJECTION_ENABLED", "false").lower() == "true"
+           and ENVIRONMENT in FAULT_INJECTION_ENVIRONMENTS)
+MONGO_LATENCY_MS = _latency_env("FAULT_MONGO_LATENCY_MS")
+MONGO_LATENCY_PROBABILITY = _float_env("FAULT_MONGO_LATENCY_PROBABILITY")
+SERVICE_BUS_ERROR_PROBABILITY = _float_env("FAULT_SERVICE_BUS_ERROR_PROBABILITY")
+NOTIFICATION_DROP_PROBABILITY = _float_env("FAULT_NOTIFICATION_DROP_PROBABILITY")
+
+if os.getenv("FAULT_INJECTION_ENABLED", "false").lower() == "true" and not ENABLED:
+    logger.warning("fault_injection_refused", environment=ENVIRONMENT)
+elif ENABLED:
+    logger.warning("fault_injection_enabled", mongo_latency_ms=MONGO_LATENCY_MS,
+                   mongo_latency_probability=MONGO_LATENCY_PROBABILITY,
+                   service_bus_error_probability=SERVICE_BUS_ERROR_PROBABILITY,
+                   notification_drop_probability=NOTIFICATION_DROP_PROBABILITY)
+
+def _roll(probability: float) -> bool:
+    return ENABLED and probability > 0 and random.random() < probability
+
+async def mongo_latency() -> None:
+    if MONGO_LATENCY_MS and _roll(MONGO_LATENCY_PROBABILITY):
+        logger.info("fault_injected", fault="mongo_latency", latency_ms=MONGO_LATENCY_MS)
+        await asyncio.sleep(MONGO_LATENCY_MS / 1000)
+
+def service_bus_error(operation: str) -> None:
+    if _roll(SERVICE_BUS_ERROR_PROBABILITY):
+        logger.info("fault_injected", fault="service_bus_error", operation=operation)
+        raise InjectedFault(f"Injected Service Bus failure during {operation}")
+
+def drop_notification(kind: str) -> bool:
+    if _roll(NOTIFICATION_DROP_PROBABILITY):
+        logger.info("fault_injected", fault="notification_dropped", kind=kind)
+        return True
+    return False
+
+def status() -> dict:
+    return {
+        "enabled": ENABLED,
+        "environment": ENVIRONMENT,
+        "mongo_latency_ms": MONGO_LATENCY_MS,
+        "mongo_latency_probability": MONGO_LATENCY_PROBABILITY,
+        "service_bus_error_probability": SERVICE_BUS_ERROR_PROBABILITY,
+        "notification_drop_probability": NOTIFICATION_DROP_PROBABILITY
+    }
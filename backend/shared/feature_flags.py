@@ -0,0 +1,156 @@
+"""
+Feature flags for rolling new capabilities out gradually: per hotel, and to a percentage of guests.
+
+A flag is decided in this order:
+1. An on/off override in the runtime config (`feature_flags` in shared/runtime_config.py), the kill
+   switch, which needs no flag edit.
+2. The hotel's own override (`hotel_overrides`), e.g. on for the pilot property, off for one that
+   isn't ready.
+3. `enabled`, then `rollout_percent` of subjects (usually guests), bucketed stickily per flag so a
+   guest doesn't flip between requests and each flag gets its own cohort.
+Flags nobody has configured fall back to FLAG_DEFAULTS, which keep today's behaviour.
+
+Flags live in the `feature_flags` collection (FEATURE_FLAG_SOURCE=mongo, edited with the admin API) or in
+Azure App Configuration's feature manager (FEATURE_FLAG_SOURCE=app_configuration). With App
+Configuration, a "Microsoft.Percentage" filter sets the rollout percentage and a "Hotels" filter
+(`{"Allow": [...], "Deny": [...]}`) sets the hotel overrides.
+"""
+import asyncio
+import json
+import os
+from datetime import datetime, timezone
+from typing import Dict, List, Optional
+
+import structlog
+from pydantic import BaseModel, Field
+
+from shared.db.database import DatabaseConnection
+from shared.routing_rules import in_rollout
+from shared.runtime_config import app_config_get, runtime_config
+
+logger = structlog.get_logger()
+
+HOTEL_ID = os.getenv("HOTEL_ID", "default")
+FEATURE_FLAG_SOURCE = os.getenv("FEATURE_FLAG_SOURCE", "mongo").lower()
+APP_CONFIG_FLAG_PREFIX = ".appconfig.featureflag/"
+
+# Flags the code checks, with their value when nobody has configured them
+FLAG_DEFAULTS: Dict[str, bool] = {
+    "websocket_chat": True,    # the guest chat WebSocket
+    "auto_assignment": True,   # nearest-attendant assignment of new orders
+    "llm_classifier": True,    # Azure OpenAI classification, when SHADOW_CLASSIFIER=llm
+    "promotions": True,        # upsell offers after orders and bookings (shared/promotions.py)
+    "sandbox": False,          # synthetic traffic only, purged nightly (shared/sandbox.py)
+}
+
+class FeatureFlagError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+class FeatureFlagUpdate(BaseModel):
+    description: Optional[str] = Field(None, max_length=200)
+    enabled: bool = False
+    rollout_percent: int = Field(100, ge=0, le=100, description="Share of subjects that get the flag when enabled")
+    hotel_overrides: Dict[str, bool] = Field(default_factory=dict, description="On/off per hotel ID, ahead of the rest")
+
+class FeatureFlag(FeatureFlagUpdate):
+    key: str = Field(..., pattern=r"^[a-z0-9][a-z0-9_.-]{0,63}$")
+    updated_by: Optional[str] = None
+    updated_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+
+def evaluate(flag: Optional[FeatureFlag], key: str, subject: Optional[str], hotel_id: str,
+             override: Optional[bool] = None) -> bool:
+    if override is not None:
+        return override
+    if flag is None:
+        return FLAG_DEFAULTS.get(key, False)
+    if hotel_id in flag.hotel_overrides:
+        return flag.hotel_overrides[hotel_id]
+    if not flag.enabled:
+        return False
+    if flag.rollout_percent >= 100:
+        return True
+    # Without a subject (background jobs) only a full rollout counts
+    return bool(subject) and in_rollout(f"{key}:{subject}", flag.rollout_percent)
+
+def from_app_config(value: dict) -> FeatureFlag:
+    """A flag in App Configuration's feature management schema."""
+    flag = FeatureFlag(key=value["id"], description=value.get("description"), enabled=bool(value.get("enabled")))
+    for client_filter in (value.get("conditions") or {}).get("client_filters") or []:
+        parameters = client_filter.get("parameters") or {}
+        if client_filter.get("name") == "Microsoft.Percentage":
+            flag.rollout_percent = max(0, min(100, int(float(parameters.get("Value", 100)))))
+        elif client_filter.get("name") == "Hotels":
+            flag.hotel_overrides = {**{h: True for h in parameters.get("Allow") or []},
+                                    **{h: False for h in parameters.get("Deny") or []}}
+    return flag
+
+# --- Flags in use ---
+
+class FeatureFlags:
+    """In-memory copy of the flags; evaluation is synchronous, refresh() (or refresh_loop()) picks up changes."""
+
+    def __init__(self, refresh_interval: int = 30, source: str = FEATURE_FLAG_SOURCE):
+        self.refresh_interval = refresh_interval
+        self.source = source
+        self.flags: Dict[str, FeatureFlag] = {}
+
+    async def refresh(self) -> None:
+        if self.source == "app_configuration":
+            listing = await app_config_get(f"/kv?key={APP_CONFIG_FLAG_PREFIX.replace('/', '%2F')}*")
+            flags = [from_app_config(json.loads(item["value"])) for item in (listing or {}).get("items", [])]
+        else:
+            async with DatabaseConnection.get_connection() as conn:
+                flags = [FeatureFlag(**doc) async for doc in conn["virtualbutler"]["feature_flags"].find({}, {"_id": 0})]
+        self.flags = {flag.key: flag for flag in flags}
+
+    async def refresh_loop(self) -> None:
+        while True:
+            try:
+                await self.refresh()
+            except Exception as e:
+                logger.error("feature_flags_refresh_failed", source=self.source, error=str(e))
+            await asyncio.sleep(self.refresh_interval)
+
+    def is_enabled(self, key: str, subject: Optional[str] = None, hotel_id: str = HOTEL_ID) -> bool:
+        return evaluate(self.flags.get(key), key, subject, hotel_id, runtime_config.settings.feature_flags.get(key))
+
+    def snapshot(self, subject: Optional[str] = None, hotel_id: str = HOTEL_ID) -> Dict[str, bool]:
+        """Every known flag as the given guest sees it, for client apps."""
+        return {key: self.is_enabled(key, subject, hotel_id) for key in sorted({*FLAG_DEFAULTS, *self.flags})}
+
+feature_flags = FeatureFlags()
+
+# --- Flag administration (Mongo source) ---
+
+def _ensure_editable() -> None:
+    if FEATURE_FLAG_SOURCE != "mongo":
+        raise FeatureFlagError("Feature flags are managed in Azure App Configuration", 409)
+
+async def list_flags() -> List[FeatureFlag]:
+    return sorted(feature_flags.flags.values(), key=lambda f: f.key)
+
+async def save_flag(flag: FeatureFlag) -> FeatureFlag:
+    _ensure_editable()
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["feature_flags"].replace_one(
+            {"key": flag.key}, flag.model_dump(mode="json") | {"updated_at": flag.updated_at}, upsert=True
+        )
+    await feature_flags.refresh()
+    logger.info("feature_flag_saved", key=flag.key, enabled=flag.enabled, rollout_percent=flag.rollout_percent,
+                hotel_overrides=flag.hotel_overrides, updated_by=flag.updated_by)
+    return flag
+
+async def delete_flag(key: str) -> None:
+    _ensure_editable()
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["feature_flags"].delete_one({"key": key})
+    if not result.deleted_count:
+        raise FeatureFlagError("Feature flag not found", 404)
+    await feature_flags.refresh()
+    logger.info("feature_flag_deleted", key=key)
+
+async def ensure_feature_flag_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["feature_flags"].create_index("key", unique=True)
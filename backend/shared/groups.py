@@ -0,0 +1,143 @@
+"""
+Event groups: a conference or wedding party whose requests are handled as one.
+
+A group links its guests to an event coordinator. The coordinator (or staff on their behalf) submits
+bulk requests in one call, e.g. "30 extra chairs to Ballroom A" and "2 projectors". Each line becomes
+its own work order, routed to its department, raised in the coordinator's name and tagged with the
+group so staff see what belongs together.
+
+The coordinator follows the group rather than each order: GET /groups/{group_id}/requests gives the
+orders with a roll-up, and instead of a notification per order they get one consolidated update per
+GROUP_DIGEST_SECONDS while anything in the group changed.
+"""
+import os
+from datetime import datetime, timezone
+from typing import Dict, List, Optional
+
+import structlog
+from pydantic import BaseModel, Field
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import DepartmentEnum, PriorityEnum, StatusEnum
+
+logger = structlog.get_logger()
+
+GROUP_DIGEST_SECONDS = int(os.getenv("GROUP_DIGEST_SECONDS", "300"))
+GROUP_TAG = "group"
+DONE = (StatusEnum.COMPLETED.value, StatusEnum.CANCELLED.value)
+
+class GroupError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+class EventGroupUpdate(BaseModel):
+    name: str = Field(..., min_length=1, max_length=100)
+    coordinator_id: str = Field(..., description="Guest ID of the event coordinator")
+    member_ids: List[str] = Field(default_factory=list, description="Guest IDs of the attendees")
+    location: Optional[str] = Field(None, max_length=100, description="Default location, e.g. Ballroom A")
+    starts_at: Optional[datetime] = None
+    ends_at: Optional[datetime] = None
+    active: bool = True
+
+class EventGroup(EventGroupUpdate):
+    group_id: str = Field(..., pattern=r"^[a-z0-9][a-z0-9_-]{0,39}$")
+    updated_by: Optional[str] = None
+    updated_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+
+class BulkRequestItem(BaseModel):
+    description: str = Field(..., min_length=1, max_length=300, description="e.g. extra chairs")
+    quantity: int = Field(1, ge=1, le=1000)
+    department: Optional[DepartmentEnum] = Field(None, description="Routed from the description when omitted")
+    location: Optional[str] = Field(None, max_length=100, description="Defaults to the group's location")
+    priority: PriorityEnum = PriorityEnum.MEDIUM
+
+class BulkRequest(BaseModel):
+    items: List[BulkRequestItem] = Field(..., min_length=1, max_length=50)
+    note: Optional[str] = Field(None, max_length=300)
+
+def group_tags(group_id: str) -> List[str]:
+    return [GROUP_TAG, f"{GROUP_TAG}:{group_id}"]
+
+def item_description(item: BulkRequestItem, location: Optional[str], note: Optional[str] = None) -> str:
+    text = f"{item.quantity} x {item.description}" if item.quantity > 1 else item.description
+    if location:
+        text += f" to {location}"
+    return f"{text} | Note: {note}"[:500] if note else text
+
+def can_manage(group: EventGroup, user: dict) -> bool:
+    return user.get("role") in ("staff", "admin") or user.get("sub") == group.coordinator_id
+
+def summarize(orders: List[dict]) -> Dict[str, object]:
+    by_status: Dict[str, int] = {}
+    for order in orders:
+        by_status[order["status"]] = by_status.get(order["status"], 0) + 1
+    done = sum(by_status.get(s, 0) for s in DONE)
+    return {"total": len(orders), "done": done, "open": len(orders) - done, "by_status": by_status,
+            "percent_complete": round(100 * done / len(orders)) if orders else 100}
+
+def digest_text(group: EventGroup, summary: dict) -> str:
+    text = f"{group.name}: {summary['done']} of {summary['total']} requests done"
+    in_progress = summary["by_status"].get(StatusEnum.IN_PROGRESS.value, 0)
+    return f"{text}, {in_progress} in progress." if in_progress else f"{text}."
+
+# --- Storage ---
+
+async def list_groups(active_only: bool = True, coordinator_id: Optional[str] = None) -> List[EventGroup]:
+    query = {"active": True} if active_only else {}
+    if coordinator_id:
+        query["coordinator_id"] = coordinator_id
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["event_groups"].find(query, {"_id": 0}).sort("group_id", 1).to_list(length=None)
+    return [EventGroup(**doc) for doc in docs]
+
+async def get_group(group_id: str) -> EventGroup:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["event_groups"].find_one({"group_id": group_id}, {"_id": 0})
+    if not doc:
+        raise GroupError("Group not found", 404)
+    return EventGroup(**doc)
+
+async def save_group(group: EventGroup) -> EventGroup:
+    if group.starts_at and group.ends_at and group.ends_at <= group.starts_at:
+        raise GroupError("ends_at must be after starts_at", 422)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["event_groups"].update_one(
+            {"group_id": group.group_id},
+            {"$set": group.model_dump(mode="json") | {"updated_at": group.updated_at, "starts_at": group.starts_at,
+                                                      "ends_at": group.ends_at}},
+            upsert=True
+        )
+    logger.info("event_group_saved", group_id=group.group_id, coordinator_id=group.coordinator_id,
+                members=len(group.member_ids), active=group.active)
+    return group
+
+async def group_orders(group_id: str) -> List[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["work_orders"].find(
+            {"metadata.group_id": group_id, "parent_id": None}, {"_id": 0}
+        ).sort("created_at", 1).to_list(length=None)
+
+# --- Consolidated updates ---
+
+async def mark_changed(group_id: str) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["event_groups"].update_one({"group_id": group_id},
+                                                               {"$set": {"digest_pending": True}})
+
+async def take_pending_digest() -> Optional[EventGroup]:
+    """The next group with changes to report, clearing its flag so each change is reported once."""
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["event_groups"].find_one_and_update(
+            {"digest_pending": True}, {"$set": {"digest_pending": False, "digest_sent_at": datetime.now(timezone.utc)}},
+            projection={"_id": 0, "digest_pending": 0, "digest_sent_at": 0}
+        )
+    return EventGroup(**doc) if doc else None
+
+async def ensure_group_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        await db["event_groups"].create_index("group_id", unique=True)
+        await db["event_groups"].create_index("coordinator_id")
+        await db["event_groups"].create_index("digest_pending", sparse=True)
+        await db["work_orders"].create_index("metadata.group_id", sparse=True)
@@ -0,0 +1,171 @@
+"""
+Guest flags and blocks, for abuse such as prank or repeated bogus requests.
+
+- flagged: requests go through as usual but carry the `flagged_guest` tag, so staff know to check
+  before sending someone.
+- blocked: the chatbot answers with a managed message (the admin's own text, or the `guest_blocked`
+  translation) and no work order is created; the suppressed message is kept for the record.
+  Emergencies always go through.
+
+A guest has at most one active restriction. It ends when an admin lifts it, at its `expires_at`, or at
+checkout: by default `expires_at` is the guest's check_out_date, and the checkout event from the PMS
+ends it straight away. Every change, and every suppressed request, is written to
+`guest_block_audit`.
+"""
+from datetime import datetime, timezone
+from enum import Enum
+from typing import List, Optional
+
+import structlog
+from pydantic import BaseModel, Field
+
+from shared.db.database import DatabaseConnection
+
+logger = structlog.get_logger()
+
+FLAGGED_TAG = "flagged_guest"
+BLOCKED_TAG = "blocked_guest"
+
+class RestrictionLevelEnum(str, Enum):
+    FLAGGED = "flagged"
+    BLOCKED = "blocked"
+
+class GuestBlockError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+class GuestRestrictionRequest(BaseModel):
+    level: RestrictionLevelEnum
+    reason: str = Field(..., min_length=1, max_length=500)
+    message: Optional[str] = Field(None, max_length=500, description="Reply sent to a blocked guest instead of the default")
+    expires_at: Optional[datetime] = Field(None, description="Defaults to the guest's checkout")
+
+class GuestRestriction(BaseModel):
+    guest_id: str
+    level: RestrictionLevelEnum
+    reason: str
+    message: Optional[str] = None
+    room_number: Optional[str] = None
+    created_by: Optional[str] = None
+    created_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+    expires_at: Optional[datetime] = None
+    ended_at: Optional[datetime] = None
+    ended_by: Optional[str] = None
+    end_reason: Optional[str] = None
+
+def _aware(moment: datetime) -> datetime:
+    return moment.replace(tzinfo=timezone.utc) if moment.tzinfo is None else moment
+
+def is_active(restriction: dict, now: datetime) -> bool:
+    if restriction.get("ended_at"):
+        return False
+    expires_at = restriction.get("expires_at")
+    return expires_at is None or _aware(expires_at) > now
+
+def default_expiry(guest: Optional[dict], now: datetime) -> Optional[datetime]:
+    """The guest's checkout, unless it's missing or already past (a stale date from an earlier stay)."""
+    check_out = (guest or {}).get("check_out_date")
+    if check_out and _aware(check_out) > now:
+        return _aware(check_out)
+    return None
+
+def audit_entry(guest_id: str, action: str, actor: Optional[str], at: datetime, **details) -> dict:
+    return {"guest_id": guest_id, "action": action, "actor": actor, "at": at, **details}
+
+async def _audit(db, guest_id: str, action: str, actor: Optional[str], at: datetime, **details) -> None:
+    await db["guest_block_audit"].insert_one(audit_entry(guest_id, action, actor, at, **details))
+    logger.info("guest_restriction_audit", guest_id=guest_id, action=action, actor=actor)
+
+async def get_restriction(guest_id: str, now: Optional[datetime] = None) -> Optional[dict]:
+    """The guest's active restriction, if any."""
+    now = now or datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["guest_restrictions"].find_one(
+            {"guest_id": guest_id, "ended_at": None}, {"_id": 0}
+        )
+    return doc if doc and is_active(doc, now) else None
+
+async def list_restrictions(now: Optional[datetime] = None) -> List[dict]:
+    now = now or datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["guest_restrictions"].find(
+            {"ended_at": None}, {"_id": 0}
+        ).sort("created_at", -1).to_list(length=None)
+    return [d for d in docs if is_active(d, now)]
+
+async def restrict_guest(guest_id: str, request: GuestRestrictionRequest, actor: Optional[str]) -> dict:
+    """Flags or blocks a guest, replacing any restriction they already have."""
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        guest = await db["guest_profiles"].find_one({"guest_id": guest_id}, {"room_number": 1, "check_out_date": 1})
+        if not guest:
+            raise GuestBlockError("Guest not found", 404)
+        if request.expires_at and _aware(request.expires_at) <= now:
+            raise GuestBlockError("expires_at must be in the future", 422)
+        await db["guest_restrictions"].update_many(
+            {"guest_id": guest_id, "ended_at": None},
+            {"$set": {"ended_at": now, "ended_by": actor, "end_reason": "replaced"}}
+        )
+        restriction = GuestRestriction(
+            guest_id=guest_id, level=request.level, reason=request.reason, message=request.message,
+            room_number=guest.get("room_number"), created_by=actor,
+            expires_at=_aware(request.expires_at) if request.expires_at else default_expiry(guest, now),
+            created_at=now,
+        ).model_dump()
+        restriction["level"] = request.level.value
+        await db["guest_restrictions"].insert_one(dict(restriction))
+        await _audit(db, guest_id, request.level.value, actor, now, reason=request.reason,
+                     expires_at=restriction["expires_at"])
+    return restriction
+
+async def _end(guest_id: str, action: str, actor: Optional[str], reason: Optional[str], at: datetime) -> Optional[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        doc = await db["guest_restrictions"].find_one_and_update(
+            {"guest_id": guest_id, "ended_at": None},
+            {"$set": {"ended_at": at, "ended_by": actor, "end_reason": action}},
+            projection={"_id": 0}
+        )
+        if doc:
+            await _audit(db, guest_id, action, actor, at, reason=reason, level=doc["level"])
+    return doc
+
+async def lift_restriction(guest_id: str, actor: Optional[str], reason: Optional[str] = None) -> dict:
+    doc = await _end(guest_id, "lifted", actor, reason, datetime.now(timezone.utc))
+    if not doc:
+        raise GuestBlockError("Guest has no active flag or block", 404)
+    return doc
+
+async def end_at_checkout(guest_id: str, checked_out_at: datetime) -> bool:
+    return await _end(guest_id, "expired", "checkout", "Guest checked out", checked_out_at) is not None
+
+async def expire_due_restrictions(now: Optional[datetime] = None) -> int:
+    """Closes restrictions past their expiry, so the audit trail shows when they stopped applying."""
+    now = now or datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        due = await conn["virtualbutler"]["guest_restrictions"].find(
+            {"ended_at": None, "expires_at": {"$lte": now}}, {"_id": 0, "guest_id": 1, "expires_at": 1}
+        ).to_list(length=None)
+    for doc in due:
+        await _end(doc["guest_id"], "expired", "system", "Reached expiry", _aware(doc["expires_at"]))
+    return len(due)
+
+async def record_suppressed(guest_id: str, request_id: str, channel: str) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await _audit(conn["virtualbutler"], guest_id, "request_suppressed", None, datetime.now(timezone.utc),
+                     request_id=request_id, channel=channel)
+
+async def restriction_audit(guest_id: str, limit: int = 100) -> List[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["guest_block_audit"].find(
+            {"guest_id": guest_id}, {"_id": 0}
+        ).sort("at", -1).limit(limit).to_list(length=None)
+
+async def ensure_guest_block_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        await db["guest_restrictions"].create_index([("guest_id", 1), ("ended_at", 1)])
+        await db["guest_restrictions"].create_index([("ended_at", 1), ("expires_at", 1)])
+        await db["guest_block_audit"].create_index([("guest_id", 1), ("at", -1)])
@@ -0,0 +1,199 @@
+import os
+import re
+import uuid
+from datetime import datetime, timezone
+from typing import List, Optional
+
+import structlog
+from pymongo import ReturnDocument
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import AgentConversation, ConversationStatusEnum, DepartmentEnum, DispositionEnum
+
+logger = structlog.get_logger()
+
+AGENT_MAX_CONVERSATIONS = int(os.getenv("AGENT_MAX_CONVERSATIONS", "3"))
+
+HANDOFF_PATTERN = re.compile(
+    r"(talk|speak|chat) (to|with) (a |an |someone|somebody|the )?(real |live )?(person|human|agent|someone|somebody|staff|reception)"
+    r"|\b(real|live) (person|human|agent)\b|\bhuman please\b"
+)
+OPEN_STATUSES = [ConversationStatusEnum.WAITING, ConversationStatusEnum.ACTIVE]
+
+class ConversationError(Exception):
+    def __init__(self, message: str, status_code: int = 409):
+        super().__init__(message)
+        self.status_code = status_code
+
+def wants_human(message: str) -> bool:
+    return bool(HANDOFF_PATTERN.search(message.lower()))
+
+def conversation_message(sender: str, text: str, sender_id: Optional[str] = None) -> dict:
+    return {
+        "message_id": f"msg_{uuid.uuid4().hex[:12]}",
+        "sender": sender,  # guest | agent | system
+        "sender_id": sender_id,
+        "text": text,
+        "timestamp": datetime.now(timezone.utc)
+    }
+
+def message_index(conversation: dict, message_id: Optional[str]) -> int:
+    """Position of the message in the conversation, or -1 if it isn't there."""
+    for i, message in enumerate(conversation.get("messages", [])):
+        if message.get("message_id") == message_id:
+            return i
+    return -1
+
+def unread_count(conversation: dict, reader: str) -> int:
+    """Messages from the other side (or the system) after the last one `reader` (guest or agent) read."""
+    marker = conversation.get("read_markers", {}).get(reader, {})
+    after = conversation.get("messages", [])[message_index(conversation, marker.get("message_id")) + 1:]
+    return sum(1 for message in after if message.get("sender") != reader)
+
+async def get_open_conversation(guest_id: str) -> Optional[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["agent_conversations"].find_one(
+            {"guest_id": guest_id, "status": {"$in": OPEN_STATUSES}}
+        )
+
+async def open_conversation(guest_id: str, reason: str, first_message: str, room_number: Optional[str] = None,
+                            session_id: Optional[str] = None, language: str = "en",
+                            department: DepartmentEnum = DepartmentEnum.FRONT_DESK) -> dict:
+    """Queues the guest for a live agent, or returns the conversation already open for them."""
+    existing = await get_open_conversation(guest_id)
+    if existing:
+        return existing
+    conversation = AgentConversation(
+        conversation_id=f"conv_{uuid.uuid4().hex[:12]}",
+        guest_id=guest_id,
+        room_number=room_number,
+        session_id=session_id,
+        department=department,
+        reason=reason,
+        language=language,
+        messages=[conversation_message("guest", first_message, guest_id)]
+    )
+    doc = conversation.dict(by_alias=True, exclude_none=True)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["agent_conversations"].insert_one(doc)
+    logger.info("handoff_queued", conversation_id=conversation.conversation_id, guest_id=guest_id, reason=reason)
+    return doc
+
+async def append_message(conversation_id: str, message: dict) -> Optional[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["agent_conversations"].find_one_and_update(
+            {"conversation_id": conversation_id, "status": {"$in": OPEN_STATUSES}},
+            {"$push": {"messages": message}, "$set": {"updated_at": datetime.now(timezone.utc)}},
+            return_document=ReturnDocument.AFTER
+        )
+
+async def mark_read(conversation: dict, reader: str, message_id: str) -> Optional[dict]:
+    """
+    Moves `reader`'s read marker up to the message. Returns the new marker, or None if the message isn't
+    in the conversation or is no further than what they had already read (receipts can arrive out of order).
+    """
+    index = message_index(conversation, message_id)
+    current = conversation.get("read_markers", {}).get(reader, {})
+    if index < 0 or index <= message_index(conversation, current.get("message_id")):
+        return None
+    marker = {"message_id": message_id, "read_at": datetime.now(timezone.utc)}
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["agent_conversations"].update_one(
+            {"conversation_id": conversation["conversation_id"]}, {"$set": {f"read_markers.{reader}": marker}}
+        )
+    return marker
+
+async def list_conversations(status: ConversationStatusEnum, departments: Optional[List[str]] = None,
+                             agent_id: Optional[str] = None) -> List[dict]:
+    query = {"status": status}
+    if departments is not None:
+        query["department"] = {"$in": departments}
+    if agent_id:
+        query["agent_id"] = agent_id
+    async with DatabaseConnection.get_connection() as conn:
+        cursor = conn["virtualbutler"]["agent_conversations"].find(query, {"messages": 0}).sort("created_at", 1)
+        return [doc async for doc in cursor]
+
+async def get_conversation(conversation_id: str) -> Optional[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["agent_conversations"].find_one({"conversation_id": conversation_id})
+
+async def active_conversation_count(agent_id: str) -> int:
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["agent_conversations"].count_documents(
+            {"agent_id": agent_id, "status": ConversationStatusEnum.ACTIVE}
+        )
+
+async def claim_conversation(conversation_id: str, agent_id: str, max_active: int = AGENT_MAX_CONVERSATIONS) -> dict:
+    """Assigns a waiting conversation to an agent, enforcing the per-agent concurrency limit."""
+    if max_active and await active_conversation_count(agent_id) >= max_active:
+        raise ConversationError(f"Agent already has {max_active} active conversations", 429)
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["agent_conversations"].find_one_and_update(
+            {"conversation_id": conversation_id, "status": ConversationStatusEnum.WAITING},
+            {"$set": {"status": ConversationStatusEnum.ACTIVE, "agent_id": agent_id,
+                      "updated_at": datetime.now(timezone.utc)}},
+            return_document=ReturnDocument.AFTER
+        )
+    if not doc:
+        raise ConversationError("Conversation is not waiting")
+    logger.info("handoff_claimed", conversation_id=conversation_id, agent_id=agent_id)
+    return doc
+
+async def release_conversation(conversation_id: str, agent_id: str) -> dict:
+    """Puts an active conversation back in the queue."""
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["agent_conversations"].find_one_and_update(
+            {"conversation_id": conversation_id, "agent_id": agent_id, "status": ConversationStatusEnum.ACTIVE},
+            {"$set": {"status": ConversationStatusEnum.WAITING, "agent_id": None,
+                      "updated_at": datetime.now(timezone.utc)}},
+            return_document=ReturnDocument.AFTER
+        )
+    if not doc:
+        raise ConversationError("Conversation is not assigned to you")
+    logger.info("handoff_released", conversation_id=conversation_id, agent_id=agent_id)
+    return doc
+
+async def transfer_conversation(conversation_id: str, agent_id: str, department: DepartmentEnum,
+                                note: Optional[str] = None) -> dict:
+    """Moves a conversation to another department's queue; it waits there for a new agent."""
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["agent_conversations"]
+        current = await coll.find_one({"conversation_id": conversation_id, "agent_id": agent_id,
+                                       "status": ConversationStatusEnum.ACTIVE})
+        if not current:
+            raise ConversationError("Conversation is not assigned to you")
+        transfer = {"from_department": current.get("department"), "to_department": DepartmentEnum(department).value,
+                    "by": agent_id, "note": note, "at": now}
+        doc = await coll.find_one_and_update(
+            {"_id": current["_id"], "status": ConversationStatusEnum.ACTIVE},
+            {"$set": {"status": ConversationStatusEnum.WAITING, "agent_id": None,
+                      "department": DepartmentEnum(department).value, "updated_at": now},
+             "$push": {"transfers": transfer}},
+            return_document=ReturnDocument.AFTER
+        )
+    if not doc:
+        raise ConversationError("Conversation changed while transferring")
+    logger.info("handoff_transferred", conversation_id=conversation_id, agent_id=agent_id,
+                from_department=transfer["from_department"], to_department=transfer["to_department"])
+    return doc
+
+async def close_conversation(conversation_id: str, agent_id: str, disposition: DispositionEnum,
+                             note: Optional[str] = None, force: bool = False) -> dict:
+    """Closes a conversation with a disposition code; force lets admins close one they don't own."""
+    query = {"conversation_id": conversation_id, "status": {"$in": OPEN_STATUSES}}
+    if not force:
+        query["agent_id"] = agent_id
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["agent_conversations"].find_one_and_update(
+            query,
+            {"$set": {"status": ConversationStatusEnum.CLOSED, "disposition": DispositionEnum(disposition).value,
+                      "disposition_note": note, "closed_by": agent_id, "closed_at": now, "updated_at": now}},
+            return_document=ReturnDocument.AFTER
+        )
+    if not doc:
+        raise ConversationError("Conversation is not open or not assigned to you")
+    logger.info("handoff_closed", conversation_id=conversation_id, agent_id=agent_id, disposition=disposition)
+    return doc
@@ -0,0 +1,185 @@
+"""
+Resilient outbound HTTP for connectors (PMS, locks, transport, webhooks, the other services).
+
+ResilientClient wraps one pooled httpx.AsyncClient and adds, per destination (host and port, so the
+services sharing localhost in development don't trip each other's breakers):
+- timeouts (HTTP_TIMEOUT_SECONDS unless the connector sets its own),
+- retries with full-jitter exponential backoff on connection errors, timeouts, 429 and 5xx, honouring
+  Retry-After. Only idempotent methods are retried unless the caller says a POST is safe to repeat
+  (e.g. webhooks carrying an event ID). A retry budget caps retries at HTTP_RETRY_BUDGET_RATIO of
+  recent requests, so an outage doesn't multiply the load on a struggling host,
+- a circuit breaker: after HTTP_BREAKER_FAILURES consecutive failures the host is skipped (fails fast
+  with CircuitOpenError) for HTTP_BREAKER_RESET_SECONDS, then a single trial request decides whether
+  to close it again,
+- the X-Request-ID of the current request (shared.tracing) on every call.
+
+CircuitOpenError is an httpx.HTTPError, so existing `except httpx.HTTPError` handling still applies.
+Responses are returned as-is after the last attempt; callers still call raise_for_status().
+"""
+import asyncio
+import os
+import random
+import time
+from typing import Dict, Optional
+from urllib.parse import urlsplit
+
+import httpx
+import structlog
+
+from shared import metrics
+from shared.tracing import trace_headers
+
+logger = structlog.get_logger()
+
+HTTP_TIMEOUT_SECONDS = float(os.getenv("HTTP_TIMEOUT_SECONDS", "10"))
+HTTP_RETRIES = int(os.getenv("HTTP_RETRIES", "2"))
+HTTP_RETRY_BACKOFF_SECONDS = float(os.getenv("HTTP_RETRY_BACKOFF_SECONDS", "0.2"))
+HTTP_RETRY_MAX_BACKOFF_SECONDS = float(os.getenv("HTTP_RETRY_MAX_BACKOFF_SECONDS", "5"))
+HTTP_RETRY_BUDGET_RATIO = float(os.getenv("HTTP_RETRY_BUDGET_RATIO", "0.2"))
+HTTP_BREAKER_FAILURES = int(os.getenv("HTTP_BREAKER_FAILURES", "5"))
+HTTP_BREAKER_RESET_SECONDS = float(os.getenv("HTTP_BREAKER_RESET_SECONDS", "30"))
+
+IDEMPOTENT_METHODS = {"GET", "HEAD", "OPTIONS", "PUT", "DELETE"}
+DEFAULT_PORTS = {"http": 80, "https": 443}
+RETRYABLE_STATUS = {429, 500, 502, 503, 504}
+
+def destination(url: str) -> str:
+    """host:port of `url`, the key breakers and retry budgets are kept under."""
+    parts = urlsplit(url)
+    return f"{parts.hostname}:{parts.port or DEFAULT_PORTS.get(parts.scheme, '')}"
+
+class CircuitOpenError(httpx.HTTPError):
+    def __init__(self, host: str):
+        super().__init__(f"Circuit open for {host}; not calling it until it recovers")
+        self.host = host
+
+class CircuitBreaker:
+    CLOSED, OPEN, HALF_OPEN = "closed", "open", "half_open"
+
+    def __init__(self, failure_threshold: int = HTTP_BREAKER_FAILURES, reset_seconds: float = HTTP_BREAKER_RESET_SECONDS):
+        self.failure_threshold = failure_threshold
+        self.reset_seconds = reset_seconds
+        self.state = self.CLOSED
+        self.failures = 0
+        self.opened_at = 0.0
+
+    def allow(self, now: float) -> bool:
+        if self.state == self.OPEN and now - self.opened_at >= self.reset_seconds:
+            # One trial request; everything else keeps failing fast until it reports back
+            self.state = self.HALF_OPEN
+            return True
+        return self.state == self.CLOSED
+
+    def record_success(self) -> None:
+        self.state = self.CLOSED
+        self.failures = 0
+
+    def record_failure(self, now: float) -> bool:
+        """Returns True if this failure opened the circuit."""
+        self.failures += 1
+        if self.state == self.HALF_OPEN or (self.state == self.CLOSED and self.failures >= self.failure_threshold):
+            self.state = self.OPEN
+            self.opened_at = now
+            return True
+        return False
+
+class RetryBudget:
+    """Each request earns `ratio` of a retry; a retry spends one. Starts with a few retries banked."""
+
+    def __init__(self, ratio: float = HTTP_RETRY_BUDGET_RATIO, max_tokens: float = 10.0):
+        self.ratio = ratio
+        self.max_tokens = max_tokens
+        self.tokens = max_tokens
+
+    def deposit(self) -> None:
+        self.tokens = min(self.max_tokens, self.tokens + self.ratio)
+
+    def withdraw(self) -> bool:
+        if self.tokens < 1:
+            return False
+        self.tokens -= 1
+        return True
+
+def backoff_delay(attempt: int, base: float = HTTP_RETRY_BACKOFF_SECONDS, cap: float = HTTP_RETRY_MAX_BACKOFF_SECONDS,
+                  rng: random.Random = random) -> float:
+    """Full jitter: anywhere between 0 and the exponential step, so retrying clients spread out."""
+    return rng.uniform(0, min(cap, base * 2 ** attempt))
+
+def retry_after_seconds(response: httpx.Response, cap: float = HTTP_RETRY_MAX_BACKOFF_SECONDS) -> Optional[float]:
+    value = response.headers.get("Retry-After")
+    try:
+        return min(cap, max(0.0, float(value))) if value is not None else None
+    except ValueError:
+        # HTTP-date form; not worth parsing for a capped wait
+        return None
+
+class ResilientClient:
+    def __init__(self, name: str, timeout: float = HTTP_TIMEOUT_SECONDS, retries: int = HTTP_RETRIES,
+                 retry_posts: bool = False, transport: Optional[httpx.AsyncBaseTransport] = None):
+        self.name = name
+        self.timeout = timeout
+        self.retries = retries
+        self.retry_posts = retry_posts
+        self.transport = transport
+        self.breakers: Dict[str, CircuitBreaker] = {}
+        self.budgets: Dict[str, RetryBudget] = {}
+        self._client: Optional[httpx.AsyncClient] = None
+
+    @property
+    def client(self) -> httpx.AsyncClient:
+        if self._client is None or self._client.is_closed:
+            self._client = httpx.AsyncClient(timeout=self.timeout, transport=self.transport)
+        return self._client
+
+    async def aclose(self) -> None:
+        if self._client is not None:
+            await self._client.aclose()
+
+    async def request(self, method: str, url: str, *, trace_id: Optional[str] = None,
+                      idempotent: Optional[bool] = None, **kwargs) -> httpx.Response:
+        method = method.upper()
+        host = destination(url)
+        breaker = self.breakers.setdefault(host, CircuitBreaker())
+        budget = self.budgets.setdefault(host, RetryBudget())
+        retryable = idempotent if idempotent is not None else (method in IDEMPOTENT_METHODS or self.retry_posts)
+        kwargs["headers"] = {**trace_headers(trace_id), **(kwargs.get("headers") or {})}
+        budget.deposit()
+        attempt = 0
+        while True:
+            if not breaker.allow(time.monotonic()):
+                metrics.increment("butler_http_client_requests_total", client=self.name, host=host, outcome="circuit_open")
+                raise CircuitOpenError(host)
+            response, error = None, None
+            try:
+                response = await self.client.request(method, url, **kwargs)
+            except httpx.TransportError as e:
+                error = e
+            if error is None and response.status_code not in RETRYABLE_STATUS:
+                breaker.record_success()
+                metrics.increment("butler_http_client_requests_total", client=self.name, host=host, outcome="ok")
+                return response
+
+            if breaker.record_failure(time.monotonic()):
+                metrics.increment("butler_http_circuit_opened_total", client=self.name, host=host)
+                logger.warning("http_circuit_opened", client=self.name, host=host, failures=breaker.failures)
+            if attempt >= self.retries or not retryable or breaker.state == CircuitBreaker.OPEN or not budget.withdraw():
+                metrics.increment("butler_http_client_requests_total", client=self.name, host=host, outcome="failed")
+                if error is not None:
+                    raise error
+                return response
+            delay = (retry_after_seconds(response) if response is not None else None) or backoff_delay(attempt)
+            metrics.increment("butler_http_client_retries_total", client=self.name, host=host)
+            logger.info("http_request_retry", client=self.name, host=host, method=method, attempt=attempt + 1,
+                        status=response.status_code if response is not None else None,
+                        error=str(error) if error else None, delay_seconds=round(delay, 3))
+            await asyncio.sleep(delay)
+            attempt += 1
+
+    async def get(self, url: str, **kwargs) -> httpx.Response:
+        return await self.request("GET", url, **kwargs)
+
+    async def post(self, url: str, **kwargs) -> httpx.Response:
+        return await self.request("POST", url, **kwargs)
+
+    async def put(self, url: str, **kwargs) -> httpx.Response:
+        return await self.request("PUT", url, **kwargs)
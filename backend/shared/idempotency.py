@@ -0,0 +1,89 @@
+"""
+Idempotency keys for chat submissions from clients that queue requests offline and retry them.
+
+The client sends a key of its own (a UUID per submission) in `Idempotency-Key`. The first request with
+a key runs normally and its response is stored. A retry with the same key within
+IDEMPOTENCY_WINDOW_HOURS gets that stored response back, with `Idempotent-Replayed: true`, instead of
+creating a second request.
+
+- Keys are scoped to the caller, so two guests can't collide or read each other's responses.
+- A retry that arrives while the first attempt is still running gets 409 with Retry-After.
+- Reusing a key for a different body is a client bug and gets 422.
+- A failed attempt releases the key, so the retry runs for real.
+"""
+import hashlib
+import json
+import os
+import re
+from datetime import datetime, timezone
+from typing import Any, Optional
+
+import structlog
+from pymongo.errors import DuplicateKeyError
+
+from shared.db.database import DatabaseConnection
+
+logger = structlog.get_logger()
+
+IDEMPOTENCY_WINDOW_HOURS = int(os.getenv("IDEMPOTENCY_WINDOW_HOURS", "24"))
+IDEMPOTENCY_RETRY_AFTER_SECONDS = int(os.getenv("IDEMPOTENCY_RETRY_AFTER_SECONDS", "2"))
+IDEMPOTENCY_KEY_HEADER = "Idempotency-Key"
+REPLAYED_HEADER = "Idempotent-Replayed"
+VALID_KEY = re.compile(r"^[\x21-\x7e]{1,255}$")
+
+class IdempotencyError(Exception):
+    def __init__(self, message: str, status_code: int = 400, headers: Optional[dict] = None):
+        super().__init__(message)
+        self.status_code = status_code
+        self.headers = headers
+
+def fingerprint(body: Any) -> str:
+    return hashlib.sha256(json.dumps(body, sort_keys=True, default=str).encode()).hexdigest()
+
+def check_key(key: str) -> str:
+    key = key.strip()
+    if not VALID_KEY.match(key):
+        raise IdempotencyError(f"{IDEMPOTENCY_KEY_HEADER} must be 1-255 visible ASCII characters")
+    return key
+
+def replay_of(record: dict, request_fingerprint: str) -> dict:
+    """The stored outcome for a retry, or why there isn't one to give."""
+    if record["fingerprint"] != request_fingerprint:
+        raise IdempotencyError(f"{IDEMPOTENCY_KEY_HEADER} was already used for a different request", 422)
+    if record.get("response") is None:
+        raise IdempotencyError("The original request is still being processed", 409,
+                               {"Retry-After": str(IDEMPOTENCY_RETRY_AFTER_SECONDS)})
+    return record
+
+async def begin(scope: str, key: str, request_fingerprint: str) -> Optional[dict]:
+    """Claims the key; None means go ahead, a record means return its stored response."""
+    async with DatabaseConnection.get_connection() as conn:
+        keys = conn["virtualbutler"]["idempotency_keys"]
+        try:
+            await keys.insert_one({"scope": scope, "key": key, "fingerprint": request_fingerprint,
+                                   "response": None, "created_at": datetime.now(timezone.utc)})
+            return None
+        except DuplicateKeyError:
+            record = await keys.find_one({"scope": scope, "key": key}, {"_id": 0})
+    if record is None:
+        # Expired between the insert and the read; treat it as new
+        return await begin(scope, key, request_fingerprint)
+    logger.info("idempotent_retry", scope=scope, key=key, completed=record.get("response") is not None)
+    return replay_of(record, request_fingerprint)
+
+async def complete(scope: str, key: str, status_code: int, response: Any) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["idempotency_keys"].update_one(
+            {"scope": scope, "key": key},
+            {"$set": {"status_code": status_code, "response": response, "completed_at": datetime.now(timezone.utc)}}
+        )
+
+async def release(scope: str, key: str) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["idempotency_keys"].delete_one({"scope": scope, "key": key, "response": None})
+
+async def ensure_idempotency_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        keys = conn["virtualbutler"]["idempotency_keys"]
+        await keys.create_index([("scope", 1), ("key", 1)], unique=True)
+        await keys.create_index("created_at", expireAfterSeconds=IDEMPOTENCY_WINDOW_HOURS * 3600)
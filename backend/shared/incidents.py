@@ -0,0 +1,237 @@
+"""
+Emergency tier. Messages about fire, medical emergencies or threats to safety are never queued as
+work orders: they open an incident, which alerts security and the duty manager on every configured
+channel at once and keeps re-alerting until someone acknowledges it.
+
+Incidents have their own lifecycle (reported -> acknowledged -> responding -> resolved -> closed,
+or false_alarm) and every change is kept on the incident's timeline.
+"""
+import os
+import re
+import uuid
+from datetime import datetime, timedelta, timezone
+from typing import List, Optional
+
+import httpx
+import structlog
+from pymongo import ReturnDocument
+
+from shared import metrics
+from shared.db.database import DatabaseConnection
+from shared.db.models import Incident, IncidentStatusEnum, IncidentTypeEnum
+from shared.sandbox import suppress_staff_alert
+
+logger = structlog.get_logger()
+
+# Comma-separated name=url pairs, e.g. "security=https://...,duty_manager=https://..."
+INCIDENT_ALERT_WEBHOOKS = os.getenv("INCIDENT_ALERT_WEBHOOKS", "")
+DUTY_MANAGER_WEBHOOK_URL = os.getenv("DUTY_MANAGER_WEBHOOK_URL")
+INCIDENT_EMAIL_ALERT_URL = os.getenv("INCIDENT_EMAIL_ALERT_URL", "http://localhost:8003/internal/incident-alerts")
+INTERNAL_EVENTS_TOKEN = os.getenv("INTERNAL_EVENTS_TOKEN")
+INCIDENT_REALERT_MINUTES = int(os.getenv("INCIDENT_REALERT_MINUTES", "2"))
+
+EMERGENCY_PATTERNS = [
+    (IncidentTypeEnum.FIRE, re.compile(
+        r"\bfire\b(?! ?(tv|stick|place|wood))|\bsmoke\b(?!-free| free|rs?\b)|\bflames?\b|\bburning smell\b"
+        r"|smell(s|ing)? (of )?(burning|gas)|\bgas leak\b|\bau feu\b|\bincendie\b|\bfuego\b|\bincendio\b"
+    )),
+    (IncidentTypeEnum.MEDICAL, re.compile(
+        r"\bheart attack\b|can'?t breathe|cannot breathe|not breathing|\bunconscious\b|\bcollapsed\b"
+        r"|\bseizure\b|\bstroke\b|chest pains?\b|\boverdose\b|bleeding (badly|heavily|a lot)|\bambulance\b"
+        r"|\banaphyla|allergic reaction|medical emergency|need a doctor (now|urgently|right now)"
+        r"|\bun m[ée]decin\b.*\burgen|\bambulancia\b|\bun m[ée]dico\b"
+    )),
+    (IncidentTypeEnum.SECURITY, re.compile(
+        r"\bintruder\b|break(ing)?[- ]in\b|broke into|someone (is )?(trying to get|breaking|in my room)"
+        r"|\b(being|been) (attacked|assaulted|followed|threatened)\b|\b(has|with|pulled|holding|waving) a (gun|knife|weapon)\b|\bassault\b"
+        r"|\bfight(ing)? (in|outside|in the)\b|call (the )?police|\bau secours\b|\bsocorro\b"
+    )),
+]
+# Requests that mention the keywords without describing an emergency
+NOT_EMERGENCY_PATTERN = re.compile(r"\b(smoking room|non.?smoking|fire (exit|escape) (map|route|where)|fire alarm test)\b")
+
+ALLOWED_TRANSITIONS = {
+    IncidentStatusEnum.REPORTED: {IncidentStatusEnum.ACKNOWLEDGED, IncidentStatusEnum.RESPONDING,
+                                  IncidentStatusEnum.FALSE_ALARM},
+    IncidentStatusEnum.ACKNOWLEDGED: {IncidentStatusEnum.RESPONDING, IncidentStatusEnum.RESOLVED,
+                                      IncidentStatusEnum.FALSE_ALARM},
+    IncidentStatusEnum.RESPONDING: {IncidentStatusEnum.RESOLVED, IncidentStatusEnum.FALSE_ALARM},
+    IncidentStatusEnum.RESOLVED: {IncidentStatusEnum.CLOSED},
+    IncidentStatusEnum.CLOSED: set(),
+    IncidentStatusEnum.FALSE_ALARM: {IncidentStatusEnum.CLOSED},
+}
+UNACKNOWLEDGED = [IncidentStatusEnum.REPORTED]
+
+class IncidentError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+def classify_emergency(message: str) -> Optional[str]:
+    """Returns the incident type for emergency messages, None for everything else."""
+    text = message.lower()
+    if NOT_EMERGENCY_PATTERN.search(text):
+        return None
+    for incident_type, pattern in EMERGENCY_PATTERNS:
+        if pattern.search(text):
+            return incident_type.value
+    return None
+
+def check_transition(current: str, new: str) -> None:
+    if IncidentStatusEnum(new) not in ALLOWED_TRANSITIONS[IncidentStatusEnum(current)]:
+        raise IncidentError(f"Incident cannot move from {current} to {new}", 409)
+
+def alert_webhooks() -> List[tuple]:
+    hooks = []
+    for entry in filter(None, (e.strip() for e in INCIDENT_ALERT_WEBHOOKS.split(","))):
+        name, _, url = entry.partition("=")
+        if url:
+            hooks.append((name.strip(), url.strip()))
+    if DUTY_MANAGER_WEBHOOK_URL and not any(name == "duty_manager" for name, _ in hooks):
+        hooks.append(("duty_manager", DUTY_MANAGER_WEBHOOK_URL))
+    return hooks
+
+def alert_payload(incident: dict) -> dict:
+    return {
+        "type": "incident",
+        "incident_id": incident["incident_id"],
+        "incident_type": incident["incident_type"],
+        "status": incident["status"],
+        "room_number": incident.get("room_number"),
+        "location": incident.get("location"),
+        "guest_id": incident.get("guest_id"),
+        "message": incident["message"][:500],
+        "alert_round": incident.get("alert_rounds", 0) + 1,
+        "reported_at": incident["created_at"].isoformat() if incident.get("created_at") else None
+    }
+
+async def send_incident_alerts(incident: dict) -> List[dict]:
+    """Fans the alert out to every channel at once and records which ones accepted it."""
+    payload = alert_payload(incident)
+    results = []
+    async with httpx.AsyncClient(timeout=5.0) as client:
+        channels = [(name, url, {}) for name, url in alert_webhooks()]
+        if INTERNAL_EVENTS_TOKEN and INCIDENT_EMAIL_ALERT_URL:
+            channels.append(("email", INCIDENT_EMAIL_ALERT_URL, {"X-Internal-Token": INTERNAL_EVENTS_TOKEN}))
+        suppressed = suppress_staff_alert("incident", incident_id=incident["incident_id"])
+        if suppressed:
+            channels = []
+        for name, url, headers in channels:
+            try:
+                resp = await client.post(url, json=payload, headers=headers)
+                resp.raise_for_status()
+                delivered = True
+            except Exception as e:
+                logger.error("incident_alert_failed", incident_id=incident["incident_id"], channel=name, error=str(e))
+                delivered = False
+            results.append({"channel": name, "delivered": delivered, "round": payload["alert_round"],
+                            "at": datetime.now(timezone.utc)})
+    if not suppressed and not any(r["delivered"] for r in results):
+        # Nothing got through; this must be loud in the logs and metrics even if every integration is down
+        metrics.increment("butler_incident_alerts_undelivered_total")
+        logger.critical("incident_alert_undelivered", incident_id=incident["incident_id"],
+                        incident_type=incident["incident_type"], room_number=incident.get("room_number"))
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["incidents"].update_one(
+            {"incident_id": incident["incident_id"]},
+            {"$push": {"alerts": {"$each": results}}, "$inc": {"alert_rounds": 1},
+             "$set": {"last_alert_at": now, "updated_at": now}}
+        )
+    return results
+
+async def open_incident(incident_type: str, message: str, guest_id: Optional[str] = None,
+                        room_number: Optional[str] = None, location: Optional[str] = None,
+                        source: str = "chat", request_id: Optional[str] = None) -> dict:
+    now = datetime.now(timezone.utc)
+    incident = Incident(
+        incident_id=f"inc_{uuid.uuid4().hex[:12]}",
+        incident_type=incident_type,
+        guest_id=guest_id,
+        room_number=room_number,
+        location=location,
+        message=message[:1000],
+        source=source,
+        request_id=request_id,
+        created_at=now,
+        updated_at=now,
+        timeline=[{"status": IncidentStatusEnum.REPORTED.value, "at": now, "by": guest_id or source}]
+    )
+    doc = incident.model_dump(exclude={"id"})
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["incidents"].insert_one(dict(doc))
+    metrics.increment("butler_incidents_opened_total", incident_type=incident_type)
+    logger.warning("incident_opened", incident_id=incident.incident_id, incident_type=incident_type,
+                   room_number=room_number, source=source)
+    doc["alerts"] = await send_incident_alerts(doc)
+    return doc
+
+async def get_incident(incident_id: str) -> dict:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["incidents"].find_one({"incident_id": incident_id})
+    if not doc:
+        raise IncidentError("Incident not found", 404)
+    return doc
+
+async def list_incidents(status: Optional[str] = None, active_only: bool = False, limit: int = 100) -> List[Incident]:
+    query = {}
+    if status:
+        query["status"] = status
+    elif active_only:
+        query["status"] = {"$in": [IncidentStatusEnum.REPORTED, IncidentStatusEnum.ACKNOWLEDGED,
+                                   IncidentStatusEnum.RESPONDING]}
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["incidents"].find(query).sort("created_at", -1).to_list(length=limit)
+    return [Incident(**doc) for doc in docs]
+
+async def update_incident_status(incident_id: str, new_status: str, by: str, note: Optional[str] = None) -> dict:
+    current = await get_incident(incident_id)
+    check_transition(current["status"], new_status)
+    now = datetime.now(timezone.utc)
+    changes = {"status": new_status, "updated_at": now}
+    if new_status == IncidentStatusEnum.ACKNOWLEDGED or (new_status == IncidentStatusEnum.RESPONDING
+                                                         and not current.get("acknowledged_at")):
+        changes.update({"acknowledged_by": by, "acknowledged_at": now})
+    if new_status in (IncidentStatusEnum.RESOLVED, IncidentStatusEnum.FALSE_ALARM):
+        changes["resolved_at"] = now
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["incidents"].find_one_and_update(
+            # Compare-and-set on the status we validated against, so two responders can't race
+            {"incident_id": incident_id, "status": current["status"]},
+            {"$set": changes, "$push": {"timeline": {"status": new_status, "at": now, "by": by, "note": note}}},
+            return_document=ReturnDocument.AFTER
+        )
+    if not doc:
+        raise IncidentError("Incident was updated by someone else; reload and try again", 409)
+    logger.warning("incident_status_changed", incident_id=incident_id, status=new_status, by=by)
+    return doc
+
+async def add_incident_note(incident_id: str, by: str, note: str) -> dict:
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["incidents"].find_one_and_update(
+            {"incident_id": incident_id},
+            {"$push": {"timeline": {"note": note, "at": now, "by": by}}, "$set": {"updated_at": now}},
+            return_document=ReturnDocument.AFTER
+        )
+    if not doc:
+        raise IncidentError("Incident not found", 404)
+    return doc
+
+async def claim_unacknowledged(now: datetime) -> Optional[dict]:
+    """Takes the next reported incident nobody has acknowledged within INCIDENT_REALERT_MINUTES of the last alert."""
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["incidents"].find_one_and_update(
+            {"status": {"$in": UNACKNOWLEDGED},
+             "last_alert_at": {"$lte": now - timedelta(minutes=INCIDENT_REALERT_MINUTES)}},
+            # Bump last_alert_at before sending so another replica doesn't re-alert the same round
+            {"$set": {"last_alert_at": now}},
+            sort=[("last_alert_at", 1)],
+            return_document=ReturnDocument.AFTER
+        )
+
+async def ensure_incident_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["incidents"].create_index("incident_id", unique=True)
+        await conn["virtualbutler"]["incidents"].create_index([("status", 1), ("last_alert_at", 1)])
+        await conn["virtualbutler"]["incidents"].create_index([("created_at", -1)])
@@ -0,0 +1,174 @@
+"""
+Typed intents for chat replies: what the bot understood, how sure it was, and the entities it picked
+out (quantity, item, time), so front-ends can confirm "2 towels, tonight at 8" and analytics can
+compare the bot's routing with where staff finally sent the order.
+
+A message asking for several things ("3 extra towels and 2 pillows") also yields line items, so the
+work order carries an itemized list. Item names are mapped to a canonical one through a synonym
+dictionary (DEFAULT_ITEM_SYNONYMS, extended or overridden by the runtime config's `item_synonyms`),
+so "bath towels" and "towel" both come out as "towels". Without a number, only dictionary items count,
+which keeps "the AC and the lights" from turning into line items.
+
+Confidence comes from CLU when it made the call. Keyword rules have no score of their own, so a match
+reports INTENT_RULES_CONFIDENCE, scaled down by how far a fuzzy match had to stretch; direct actions
+(DND, wake-up calls, bookings, ...) are matched on explicit patterns and report 1.0.
+"""
+import os
+import re
+from datetime import datetime, tzinfo
+from typing import Dict, List, NamedTuple, Optional
+
+from pydantic import BaseModel, Field
+
+from shared.db.models import DepartmentEnum, LineItem
+from shared.timeparse import WEEKDAYS, parse_requested_time
+
+INTENT_RULES_CONFIDENCE = float(os.getenv("INTENT_RULES_CONFIDENCE", "0.6"))
+# A rules match this unsure (a misspelling routed by fuzzy matching) is checked with the guest first
+INTENT_CLARIFY_BELOW = float(os.getenv("INTENT_CLARIFY_BELOW", "0.5"))
+
+class IntentMatch(NamedTuple):
+    department: Optional[DepartmentEnum]
+    confidence: Optional[float]
+    source: str  # "clu" or "rules"
+    prediction: Optional[dict] = None
+    suggestion: Optional[str] = None  # the message as the rules read it, when they corrected a misspelling
+
+    def needs_clarification(self) -> bool:
+        return bool(self.suggestion) and (self.confidence or 0) < INTENT_CLARIFY_BELOW
+
+class ChatEntities(BaseModel):
+    quantity: Optional[int] = None
+    item: Optional[str] = None
+    time: Optional[datetime] = None
+    line_items: List[LineItem] = Field(default_factory=list)
+
+NUMBER_WORDS = {"one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7, "eight": 8,
+                "nine": 9, "ten": 10, "a couple of": 2, "a pair of": 2}
+QUANTITY_ITEM = re.compile(
+    r"\b(\d{1,3}|" + "|".join(NUMBER_WORDS) + r")\s+(?:more\s+|extra\s+|additional\s+)?"
+    r"([a-z][a-z-]*(?:\s+of\s+[a-z][a-z-]*)?)"
+)
+UNQUANTIFIED_ITEM = re.compile(r"\b(?:extra|more|another|additional|fresh|clean|new)\s+([a-z][a-z-]*(?:\s+of\s+[a-z][a-z-]*)?)")
+# Numbers that belong to a time or a duration, not to an item
+NOT_ITEMS = {"am", "pm", "a", "p", "o'clock", "hour", "hours", "hr", "hrs", "minute", "minutes", "min", "mins",
+             "day", "days", "night", "nights", "times", "please", "today", "tonight", "tomorrow", "this", "next",
+             "on", "in", "at", "is", "are", "was", "people", "persons", "guests", "adults", "children", "kids",
+             *WEEKDAYS}
+# Canonical item -> other ways guests say it
+DEFAULT_ITEM_SYNONYMS = {
+    "towels": ["towel", "bath towel", "bath towels", "hand towel", "hand towels"],
+    "pillows": ["pillow"],
+    "blankets": ["blanket", "duvet", "throw"],
+    "water": ["bottle of water", "bottles of water", "water bottle", "water bottles"],
+    "toilet paper": ["toilet roll", "toilet rolls", "loo roll", "loo rolls"],
+    "toothbrushes": ["toothbrush", "toothbrush kit", "dental kit"],
+    "hangers": ["hanger", "coat hanger", "coat hangers"],
+    "bathrobes": ["bathrobe", "robe", "robes"],
+    "slippers": ["slipper"],
+    "coffee pods": ["coffee pod", "coffee capsule", "coffee capsules"],
+    "shampoo": ["shampoos"],
+    "soap": ["soaps", "bar of soap"],
+}
+LIST_SEPARATOR = re.compile(r",|;|&|\band\b|\bplus\b|\balso\b")
+SINGLE = re.compile(r"\b(?:an?|another|one more)\s+(?:more\s+|extra\s+)?$")
+MAX_LINE_ITEMS = 10
+# The first tag of a request the bot handled itself names the action
+DIRECT_INTENTS = {"emergency": "emergency", "dnd_on": "dnd", "dnd_off": "dnd", "device_control": "device_control",
+                  "booking": "booking", "recommendation": "recommendation", "transport": "transport",
+                  "lost_and_found": "lost_and_found", "agent_mode": "handoff", "blocked_guest": "blocked",
+                  "room_choice": "room_choice", "time_choice": "time_choice", "clarify": "clarify"}
+DIRECT_PREFIXES = {"wake_up_": "wake_up", "access_": "door_access"}
+
+def parse_quantity(value: str) -> Optional[int]:
+    value = value.strip().lower()
+    if value.isdigit():
+        return int(value)
+    return NUMBER_WORDS.get(value)
+
+def item_lookup(synonyms: Optional[Dict[str, List[str]]] = None) -> Dict[str, str]:
+    """Every name an item goes by -> its canonical name; configured entries replace the defaults."""
+    lookup = {}
+    for canonical, names in {**DEFAULT_ITEM_SYNONYMS, **(synonyms or {})}.items():
+        canonical = canonical.strip().lower()
+        for name in [canonical, *names]:
+            lookup[name.strip().lower()] = canonical
+    return lookup
+
+def _known_item(text: str, lookup: Dict[str, str], anchored: bool = False) -> Optional[re.Match]:
+    """The longest dictionary name in the text; at its start when anchored."""
+    for name in sorted(lookup, key=len, reverse=True):
+        match = re.search(("^" if anchored else r"\b") + re.escape(name) + r"\b", text)
+        if match:
+            return match
+    return None
+
+def extract_line_items(text: str, synonyms: Optional[Dict[str, List[str]]] = None) -> List[LineItem]:
+    """Itemizes a request: "3 extra towels and 2 pillows" -> towels x3, pillows x2. Repeats are added up."""
+    lookup = item_lookup(synonyms)
+    items: Dict[str, Optional[int]] = {}
+    for part in LIST_SEPARATOR.split(text.lower()):
+        item, quantity = None, None
+        for match in QUANTITY_ITEM.finditer(part):
+            if match.group(2).split()[0] in NOT_ITEMS or re.search(r"(?:room|#|no\.?)\s*$", part[:match.start()]):
+                continue
+            quantity = parse_quantity(match.group(1))
+            known = _known_item(part[match.start(2):], lookup, anchored=True)
+            item = lookup[known.group(0)] if known else match.group(2)
+            break
+        if item is None:
+            known = _known_item(part, lookup)
+            if not known:
+                continue
+            item = lookup[known.group(0)]
+            quantity = 1 if SINGLE.search(part[:known.start()]) else None
+        if quantity is not None and not 1 <= quantity <= 99:
+            continue
+        if item in items:
+            quantity = (items[item] or 0) + (quantity or 0) or None
+        items[item] = quantity
+    return [LineItem(item=item, quantity=quantity) for item, quantity in list(items.items())[:MAX_LINE_ITEMS]]
+
+def extract_entities(text: str, now: datetime, tz: tzinfo,
+                     synonyms: Optional[Dict[str, List[str]]] = None) -> ChatEntities:
+    lowered = text.lower()
+    entities = ChatEntities(time=parse_requested_time(text, now, tz), line_items=extract_line_items(text, synonyms))
+    for match in QUANTITY_ITEM.finditer(lowered):
+        if match.group(2).split()[0] in NOT_ITEMS:
+            continue
+        entities.quantity, entities.item = parse_quantity(match.group(1)), match.group(2)
+        return entities
+    match = UNQUANTIFIED_ITEM.search(lowered)
+    if match and match.group(1) not in NOT_ITEMS:
+        entities.item = match.group(1)
+    return entities
+
+def clu_confidence(prediction: dict) -> Optional[float]:
+    """The top intent's score; CLU lists intents, older LUIS-style payloads key them by name."""
+    top, intents = prediction.get("topIntent"), prediction.get("intents")
+    if isinstance(intents, list):
+        for intent in intents:
+            if intent.get("category") == top:
+                return intent.get("confidenceScore")
+    elif isinstance(intents, dict) and top in intents:
+        return intents[top].get("confidenceScore", intents[top].get("score"))
+    return None
+
+def merge_clu_entities(entities: ChatEntities, prediction: dict) -> ChatEntities:
+    """CLU's quantity and item entities, when the project defines them, win over the regex guesses."""
+    for entity in prediction.get("entities") or []:
+        category, value = (entity.get("category") or "").lower(), entity.get("text") or ""
+        if category == "quantity" and parse_quantity(value) is not None:
+            entities.quantity = parse_quantity(value)
+        elif category == "item" and value:
+            entities.item = value.lower()
+    return entities
+
+def direct_intent(tags: List[str]) -> Optional[str]:
+    for tag in tags:
+        if tag in DIRECT_INTENTS:
+            return DIRECT_INTENTS[tag]
+        for prefix, intent in DIRECT_PREFIXES.items():
+            if tag.startswith(prefix):
+                return intent
+    return None
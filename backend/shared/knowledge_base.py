@@ -0,0 +1,126 @@
+"""
+Knowledge base: answers to the general questions guests and prospective guests ask (rates, amenities,
+parking, pet policy), written by the hotel rather than generated.
+
+Each article has a question, an answer (per language, falling back to the default) and keywords.
+A question is matched to the article sharing the most words with it, keywords counting double; below
+KB_MIN_SCORE there is no answer rather than a guess. Only articles marked `public` are given on the
+unauthenticated inquiry path (POST /api/v1/inquiries), so internal ones (staff Wi-Fi, door codes)
+never reach people who aren't staying with us.
+"""
+import asyncio
+import os
+import re
+from datetime import datetime, timezone
+from typing import Dict, List, Optional, Set, Tuple
+
+import structlog
+from pydantic import BaseModel, Field
+
+from shared.db.database import DatabaseConnection
+
+logger = structlog.get_logger()
+
+HOTEL_ID = os.getenv("HOTEL_ID", "default")
+KB_MIN_SCORE = int(os.getenv("KB_MIN_SCORE", "2"))
+STOP_WORDS = {"a", "an", "the", "is", "are", "do", "does", "you", "your", "i", "we", "can", "to", "of", "for",
+              "in", "on", "at", "what", "how", "there", "have", "any", "it", "my", "me", "and", "or", "be"}
+
+class KnowledgeError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+class KnowledgeArticleUpdate(BaseModel):
+    question: str = Field(..., min_length=1, max_length=200, description="e.g. Do you have parking?")
+    answer: str = Field(..., min_length=1, max_length=1000)
+    answers: Dict[str, str] = Field(default_factory=dict, description="Answer by language code")
+    keywords: List[str] = Field(default_factory=list, description="e.g. parking, garage, car")
+    category: Optional[str] = Field(None, max_length=40, description="e.g. rates, amenities, policies")
+    public: bool = Field(True, description="May be given to people who aren't staying with us")
+    enabled: bool = True
+
+class KnowledgeArticle(KnowledgeArticleUpdate):
+    article_id: str = Field(..., pattern=r"^[a-z0-9][a-z0-9_-]{0,39}$")
+    hotel_id: str = HOTEL_ID
+    updated_by: Optional[str] = None
+    updated_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+
+def words(text: str) -> Set[str]:
+    return {w for w in re.findall(r"[a-z0-9]+", (text or "").lower()) if w not in STOP_WORDS}
+
+def score(article: KnowledgeArticle, asked: Set[str]) -> int:
+    keywords = {w for keyword in article.keywords for w in words(keyword)}
+    return 2 * len(asked & keywords) + len(asked & (words(article.question) - keywords))
+
+def best_match(articles: List[KnowledgeArticle], text: str, public_only: bool = False) -> Optional[KnowledgeArticle]:
+    asked = words(text)
+    scored = [(score(a, asked), a.article_id, a) for a in articles
+              if a.enabled and (a.public or not public_only)]
+    best = max(scored, key=lambda s: (s[0], s[1]), default=None)
+    return best[2] if best and best[0] >= KB_MIN_SCORE else None
+
+def answer_for(article: KnowledgeArticle, language: str) -> str:
+    return article.answers.get(language) or article.answer
+
+# --- Articles in use ---
+
+class KnowledgeBase:
+    """In-memory copy of the articles; call refresh() (or run refresh_loop()) to pick up admin changes."""
+
+    def __init__(self, refresh_interval: int = 60):
+        self.refresh_interval = refresh_interval
+        self.articles: List[KnowledgeArticle] = []
+
+    async def refresh(self) -> None:
+        self.articles = await list_articles()
+
+    async def refresh_loop(self) -> None:
+        while True:
+            try:
+                await self.refresh()
+            except Exception as e:
+                logger.error("knowledge_base_refresh_failed", error=str(e))
+            await asyncio.sleep(self.refresh_interval)
+
+    def answer(self, text: str, language: str, public_only: bool = False) -> Optional[Tuple[KnowledgeArticle, str]]:
+        article = best_match(self.articles, text, public_only)
+        return (article, answer_for(article, language)) if article else None
+
+knowledge_base = KnowledgeBase()
+
+# --- Article administration ---
+
+async def list_articles(hotel_id: str = HOTEL_ID, include_disabled: bool = False) -> List[KnowledgeArticle]:
+    query = {"hotel_id": hotel_id}
+    if not include_disabled:
+        query["enabled"] = True
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["knowledge_articles"].find(query, {"_id": 0}).sort("article_id", 1).to_list(length=None)
+    return [KnowledgeArticle(**doc) for doc in docs]
+
+async def save_article(article: KnowledgeArticle) -> KnowledgeArticle:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["knowledge_articles"].replace_one(
+            {"hotel_id": article.hotel_id, "article_id": article.article_id},
+            article.model_dump(mode="json") | {"updated_at": article.updated_at},
+            upsert=True
+        )
+    await knowledge_base.refresh()
+    logger.info("knowledge_article_saved", article_id=article.article_id, public=article.public,
+                enabled=article.enabled)
+    return article
+
+async def delete_article(article_id: str, hotel_id: str = HOTEL_ID) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["knowledge_articles"].delete_one({"hotel_id": hotel_id,
+                                                                               "article_id": article_id})
+    if not result.deleted_count:
+        raise KnowledgeError("Article not found", 404)
+    await knowledge_base.refresh()
+    logger.info("knowledge_article_deleted", article_id=article_id)
+
+async def ensure_knowledge_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["knowledge_articles"].create_index([("hotel_id", 1), ("article_id", 1)],
+                                                                      unique=True)
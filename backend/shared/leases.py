@@ -0,0 +1,131 @@
+"""
+Leases for background jobs that must run on one replica at a time (SLA checks, retention purges,
+the preventive-maintenance scheduler, digests, ...).
+
+Each job has a document in `leases` named after it. A replica runs the job only while it holds the
+lease: it takes a free or expired lease atomically (unique name + upsert, so two replicas can never
+both win), renews it while the job runs, and keeps it across runs by renewing on its next tick. If
+the holder dies the lease lapses after its TTL and another replica picks the job up. A holder that
+fails to renew (a long GC pause, a partition from Mongo) cancels its job rather than keep running it
+alongside the replica that has taken over.
+
+Per-replica work (cache refreshes, the Service Bus consumer, change streams) does not take a lease.
+"""
+import asyncio
+import os
+import socket
+import uuid
+from datetime import datetime, timedelta, timezone
+from typing import Awaitable, Callable, List, Optional
+
+import structlog
+from pymongo import ReturnDocument
+from pymongo.errors import DuplicateKeyError
+
+from shared import metrics
+from shared.db.database import DatabaseConnection
+
+logger = structlog.get_logger()
+
+LEASE_MIN_TTL_SECONDS = int(os.getenv("LEASE_MIN_TTL_SECONDS", "30"))
+REPLICA_ID = os.getenv("REPLICA_ID") or f"{socket.gethostname()}:{os.getpid()}:{uuid.uuid4().hex[:6]}"
+
+async def acquire_lease(name: str, holder: str, ttl: timedelta, now: Optional[datetime] = None) -> bool:
+    """Takes or renews the lease; False if another holder's lease is still live."""
+    now = now or datetime.now(timezone.utc)
+    try:
+        async with DatabaseConnection.get_connection() as conn:
+            previous = await conn["virtualbutler"]["leases"].find_one_and_update(
+                {"name": name, "$or": [{"holder": holder}, {"expires_at": {"$lte": now}}]},
+                {"$set": {"holder": holder, "expires_at": now + ttl, "renewed_at": now}},
+                upsert=True,
+                return_document=ReturnDocument.BEFORE
+            )
+    except DuplicateKeyError:
+        # The filter missed because someone else holds it, and the upsert hit the unique name
+        return False
+    if previous is None or previous.get("holder") != holder:
+        metrics.increment("butler_lease_acquired_total", lease=name)
+        logger.info("lease_acquired", lease=name, holder=holder,
+                    previous_holder=previous.get("holder") if previous else None)
+    return True
+
+async def release_lease(name: str, holder: str) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["leases"].update_one(
+            {"name": name, "holder": holder}, {"$set": {"expires_at": datetime.now(timezone.utc)}}
+        )
+
+async def release_held_leases(holder: str = REPLICA_ID) -> None:
+    """Frees this replica's leases on shutdown so another replica takes over without waiting out the TTL."""
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["leases"].update_many(
+            {"holder": holder}, {"$set": {"expires_at": datetime.now(timezone.utc)}}
+        )
+    if result.modified_count:
+        logger.info("leases_released", holder=holder, count=result.modified_count)
+
+async def list_leases() -> List[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["leases"].find({}, {"_id": 0}).sort("name", 1).to_list(length=None)
+    now = datetime.now(timezone.utc)
+    for doc in docs:
+        expires_at = doc["expires_at"].replace(tzinfo=timezone.utc) if doc["expires_at"].tzinfo is None else doc["expires_at"]
+        doc["live"] = expires_at > now
+    return docs
+
+class Lease:
+    """
+    One job's lease. `ttl` should outlast the loop's sleep so the holder keeps the job between runs;
+    it defaults to twice the interval (at least LEASE_MIN_TTL_SECONDS).
+    """
+
+    def __init__(self, name: str, interval_seconds: float, ttl_seconds: Optional[float] = None):
+        self.name = name
+        self.ttl = timedelta(seconds=ttl_seconds or max(LEASE_MIN_TTL_SECONDS, 2 * interval_seconds))
+        self.holder = REPLICA_ID
+
+    async def acquire(self) -> bool:
+        return await acquire_lease(self.name, self.holder, self.ttl)
+
+    async def _keep_alive(self) -> None:
+        while True:
+            await asyncio.sleep(self.ttl.total_seconds() / 3)
+            try:
+                renewed = await self.acquire()
+            except Exception as e:
+                # Unconfirmed is as good as lost: someone else may hold it once the TTL runs out
+                logger.error("lease_renewal_failed", lease=self.name, holder=self.holder, error=str(e))
+                renewed = False
+            if not renewed:
+                logger.warning("lease_lost", lease=self.name, holder=self.holder)
+                return
+
+    async def run(self, job: Callable[[], Awaitable]):
+        """
+        Runs `job` if this replica holds the lease, renewing it for as long as the job takes; None otherwise.
+        If the lease is lost meanwhile the job is cancelled and None is returned.
+        """
+        if not await self.acquire():
+            return None
+        job_task = asyncio.ensure_future(job())
+        keep_alive = asyncio.create_task(self._keep_alive())
+        try:
+            await asyncio.wait({job_task, keep_alive}, return_when=asyncio.FIRST_COMPLETED)
+            if job_task.done():
+                return job_task.result()
+            job_task.cancel()
+            try:
+                await job_task
+            except asyncio.CancelledError:
+                pass
+            metrics.increment("butler_lease_jobs_cancelled_total", lease=self.name)
+            logger.warning("lease_job_cancelled", lease=self.name, holder=self.holder)
+            return None
+        finally:
+            keep_alive.cancel()
+            job_task.cancel()
+
+async def ensure_lease_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["leases"].create_index("name", unique=True)
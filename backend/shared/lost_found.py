@@ -0,0 +1,275 @@
+"""
+Lost and found. Guests report lost items (from chat or the API); staff log found items with
+photos and where they were found. The matching assistant scores found items against a report
+so staff can confirm a match, after which the return (pickup or shipping) is tracked to closure.
+"""
+import re
+import uuid
+from datetime import datetime, timedelta, timezone
+from typing import List, Optional
+
+import structlog
+from pymongo import ReturnDocument
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import (FoundItem, FoundItemStatusEnum, LostItemReport, LostReportStatusEnum,
+                              ReturnMethodEnum)
+
+logger = structlog.get_logger()
+
+LOST_PATTERN = re.compile(
+    r"\b(lost|left|forgot|misplaced|can'?t find|cannot find)\b (?:behind )?(?:my|our) "
+    r"(?P<item>[a-z0-9' -]+?)(?= in | at | on | by | under | somewhere| yesterday| today| this| last|[.,!?]|$)"
+)
+CATEGORIES = {
+    "phone": ("phone", "iphone", "mobile", "smartphone"),
+    "electronics": ("charger", "laptop", "tablet", "ipad", "headphones", "earbuds", "airpods", "camera", "cable"),
+    "wallet": ("wallet", "purse", "cards", "card holder"),
+    "documents": ("passport", "id", "documents", "license", "licence"),
+    "jewellery": ("ring", "necklace", "earrings", "bracelet", "watch", "jewellery", "jewelry"),
+    "glasses": ("glasses", "sunglasses", "spectacles"),
+    "keys": ("keys", "car key", "key ring"),
+    "clothing": ("jacket", "coat", "scarf", "hat", "shirt", "dress", "shoes", "sweater", "jumper"),
+    "bag": ("bag", "backpack", "suitcase", "handbag"),
+    "toy": ("toy", "teddy", "doll"),
+}
+WHERE_PATTERN = re.compile(r"^ (?:in|at|on|by|under) (?:the )?(?P<where>[a-z0-9' -]+?)(?= yesterday| today| this| last|[.,!?]|$)")
+STOPWORDS = {"a", "an", "the", "my", "our", "and", "with", "of", "in", "on", "it", "is", "black", "small", "big"}
+MATCH_THRESHOLD = 0.2
+
+class LostFoundError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+def categorize(description: str) -> Optional[str]:
+    """The category of the last item word, which is usually the noun ("phone charger" is electronics)."""
+    text = description.lower()
+    best, best_at = None, -1
+    for category, words in CATEGORIES.items():
+        for word in words:
+            for match in re.finditer(rf"\b{re.escape(word)}\b", text):
+                if match.start() > best_at:
+                    best, best_at = category, match.start()
+    return best
+
+def detect_lost_item(message: str) -> Optional[tuple]:
+    """Returns (item, where) for a guest saying they lost something, None otherwise."""
+    text = message.lower()
+    match = LOST_PATTERN.search(text)
+    if not match:
+        return None
+    where = WHERE_PATTERN.search(text[match.end():])
+    return match.group("item").strip(), where.group("where").strip() if where else None
+
+def _tokens(text: Optional[str]) -> set:
+    return {t for t in re.findall(r"[a-z0-9]+", (text or "").lower()) if t not in STOPWORDS and len(t) > 1}
+
+def match_score(report: dict, item: dict) -> float:
+    """
+    0..1 likelihood that a found item is the reported one: description overlap (60%), same category
+    (25%) and where it turned up (15%). Items found well before the loss can't be a match.
+    """
+    lost_at, found_at = report.get("lost_at"), item.get("found_at")
+    if lost_at and found_at and found_at < lost_at - timedelta(days=1):
+        return 0.0
+    report_words, item_words = _tokens(report["description"]), _tokens(item["description"])
+    overlap = len(report_words & item_words) / len(report_words | item_words) if report_words and item_words else 0.0
+    score = 0.6 * overlap
+    if report.get("category") and report.get("category") == item.get("category"):
+        score += 0.25
+    places = _tokens(report.get("lost_location")) | _tokens(report.get("room_number"))
+    if places & _tokens(item.get("found_location")):
+        score += 0.15
+    return round(score, 2)
+
+def rank_matches(report: dict, items: List[dict], limit: int = 5) -> List[dict]:
+    scored = [(match_score(report, item), item) for item in items]
+    scored = [s for s in scored if s[0] >= MATCH_THRESHOLD]
+    scored.sort(key=lambda s: s[0], reverse=True)
+    return [{"score": score, "item": item} for score, item in scored[:limit]]
+
+def _event(action: str, by: Optional[str], **details) -> dict:
+    return {"action": action, "at": datetime.now(timezone.utc), "by": by,
+            **{k: v for k, v in details.items() if v is not None}}
+
+# --- Reports ---
+
+async def create_report(guest_id: str, description: str, room_number: Optional[str] = None,
+                        lost_location: Optional[str] = None, lost_at: Optional[datetime] = None,
+                        language: str = "en") -> LostItemReport:
+    report = LostItemReport(
+        report_id=f"lost_{uuid.uuid4().hex[:12]}",
+        guest_id=guest_id,
+        room_number=room_number,
+        description=description,
+        category=categorize(description),
+        lost_location=lost_location,
+        lost_at=lost_at,
+        language=language,
+        history=[_event("reported", guest_id)]
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["lost_reports"].insert_one(report.model_dump(exclude={"id"}))
+    logger.info("lost_item_reported", report_id=report.report_id, category=report.category)
+    return report
+
+async def get_report(report_id: str, guest_id: Optional[str] = None) -> dict:
+    query = {"report_id": report_id}
+    if guest_id:
+        query["guest_id"] = guest_id
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["lost_reports"].find_one(query)
+    if not doc:
+        raise LostFoundError("Lost-item report not found", 404)
+    return doc
+
+async def list_reports(guest_id: Optional[str] = None, status: Optional[str] = None) -> List[LostItemReport]:
+    query = {k: v for k, v in {"guest_id": guest_id, "status": status}.items() if v}
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["lost_reports"].find(query).sort("created_at", -1).to_list(length=200)
+    return [LostItemReport(**doc) for doc in docs]
+
+async def _update_report(report_id: str, from_statuses: List[LostReportStatusEnum], changes: dict,
+                         event: dict) -> dict:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["lost_reports"].find_one_and_update(
+            {"report_id": report_id, "status": {"$in": from_statuses}},
+            {"$set": {**changes, "updated_at": datetime.now(timezone.utc)}, "$push": {"history": event}},
+            return_document=ReturnDocument.AFTER
+        )
+    if not doc:
+        current = await get_report(report_id)
+        raise LostFoundError(f"Report is {current['status']}", 409)
+    return doc
+
+# --- Found items ---
+
+async def log_found_item(description: str, found_location: str, found_at: Optional[datetime] = None,
+                         category: Optional[str] = None, storage_location: Optional[str] = None,
+                         logged_by: Optional[str] = None) -> FoundItem:
+    item = FoundItem(
+        item_id=f"found_{uuid.uuid4().hex[:12]}",
+        description=description,
+        category=category or categorize(description),
+        found_location=found_location,
+        found_at=found_at or datetime.now(timezone.utc),
+        storage_location=storage_location,
+        logged_by=logged_by
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["found_items"].insert_one(item.model_dump(exclude={"id"}))
+    logger.info("found_item_logged", item_id=item.item_id, category=item.category, found_location=found_location)
+    return item
+
+async def get_found_item(item_id: str) -> dict:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["found_items"].find_one({"item_id": item_id})
+    if not doc:
+        raise LostFoundError("Found item not found", 404)
+    return doc
+
+async def list_found_items(status: Optional[str] = None, category: Optional[str] = None) -> List[FoundItem]:
+    query = {k: v for k, v in {"status": status, "category": category}.items() if v}
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["found_items"].find(query).sort("found_at", -1).to_list(length=500)
+    return [FoundItem(**doc) for doc in docs]
+
+async def add_item_photo(item_id: str, blob_name: str) -> dict:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["found_items"].find_one_and_update(
+            {"item_id": item_id},
+            {"$push": {"photos": blob_name}, "$set": {"updated_at": datetime.now(timezone.utc)}},
+            return_document=ReturnDocument.AFTER
+        )
+    if not doc:
+        raise LostFoundError("Found item not found", 404)
+    return doc
+
+async def suggest_matches(report_id: str, limit: int = 5) -> List[dict]:
+    """Candidate found items for a report, best first; only items still in storage are considered."""
+    report = await get_report(report_id)
+    async with DatabaseConnection.get_connection() as conn:
+        items = await conn["virtualbutler"]["found_items"].find(
+            {"status": FoundItemStatusEnum.STORED}, {"_id": 0}
+        ).to_list(length=1000)
+    return rank_matches(report, items, limit)
+
+# --- Matching & return ---
+
+async def confirm_match(report_id: str, item_id: str, by: Optional[str]) -> dict:
+    async with DatabaseConnection.get_connection() as conn:
+        item = await conn["virtualbutler"]["found_items"].find_one_and_update(
+            {"item_id": item_id, "status": FoundItemStatusEnum.STORED},
+            {"$set": {"status": FoundItemStatusEnum.MATCHED, "report_id": report_id,
+                      "updated_at": datetime.now(timezone.utc)}}
+        )
+    if not item:
+        raise LostFoundError("Found item is not in storage or already matched", 409)
+    try:
+        report = await _update_report(report_id, [LostReportStatusEnum.OPEN],
+                                      {"status": LostReportStatusEnum.MATCHED, "matched_item_id": item_id},
+                                      _event("matched", by, item_id=item_id))
+    except LostFoundError:
+        async with DatabaseConnection.get_connection() as conn:
+            await conn["virtualbutler"]["found_items"].update_one(
+                {"item_id": item_id}, {"$set": {"status": FoundItemStatusEnum.STORED, "report_id": None}}
+            )
+        raise
+    logger.info("lost_item_matched", report_id=report_id, item_id=item_id, by=by)
+    return report
+
+async def arrange_return(report_id: str, method: ReturnMethodEnum, by: Optional[str],
+                         shipping_address: Optional[str] = None, carrier: Optional[str] = None,
+                         tracking_number: Optional[str] = None) -> dict:
+    if method == ReturnMethodEnum.SHIPPING and not shipping_address:
+        existing = await get_report(report_id)
+        shipping_address = existing.get("shipping_address")
+        if not shipping_address:
+            raise LostFoundError("A shipping address is required", 422)
+    changes = {"status": LostReportStatusEnum.RETURN_ARRANGED, "return_method": method}
+    changes.update({k: v for k, v in {"shipping_address": shipping_address, "carrier": carrier,
+                                       "tracking_number": tracking_number}.items() if v})
+    report = await _update_report(
+        report_id, [LostReportStatusEnum.MATCHED, LostReportStatusEnum.RETURN_ARRANGED], changes,
+        _event("return_arranged", by, method=method, carrier=carrier, tracking_number=tracking_number)
+    )
+    logger.info("lost_item_return_arranged", report_id=report_id, method=method, by=by)
+    return report
+
+async def mark_returned(report_id: str, by: Optional[str]) -> dict:
+    report = await _update_report(
+        report_id, [LostReportStatusEnum.MATCHED, LostReportStatusEnum.RETURN_ARRANGED],
+        {"status": LostReportStatusEnum.RETURNED, "returned_at": datetime.now(timezone.utc)},
+        _event("returned", by)
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["found_items"].update_one(
+            {"item_id": report["matched_item_id"]},
+            {"$set": {"status": FoundItemStatusEnum.RETURNED, "updated_at": datetime.now(timezone.utc)}}
+        )
+    logger.info("lost_item_returned", report_id=report_id, by=by)
+    return report
+
+async def close_report(report_id: str, by: Optional[str], reason: Optional[str] = None) -> dict:
+    report = await _update_report(
+        report_id, [LostReportStatusEnum.OPEN, LostReportStatusEnum.MATCHED],
+        {"status": LostReportStatusEnum.CLOSED}, _event("closed", by, reason=reason)
+    )
+    if report.get("matched_item_id"):
+        # A match that is never collected goes back into storage for other reports
+        async with DatabaseConnection.get_connection() as conn:
+            await conn["virtualbutler"]["found_items"].update_one(
+                {"item_id": report["matched_item_id"]},
+                {"$set": {"status": FoundItemStatusEnum.STORED, "report_id": None}}
+            )
+    logger.info("lost_item_report_closed", report_id=report_id, by=by, reason=reason)
+    return report
+
+async def ensure_lost_found_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["lost_reports"].create_index("report_id", unique=True)
+        await conn["virtualbutler"]["lost_reports"].create_index([("guest_id", 1), ("created_at", -1)])
+        await conn["virtualbutler"]["lost_reports"].create_index([("status", 1), ("created_at", -1)])
+        await conn["virtualbutler"]["found_items"].create_index("item_id", unique=True)
+        await conn["virtualbutler"]["found_items"].create_index([("status", 1), ("found_at", -1)])
@@ -0,0 +1,70 @@
+"""
+Loyalty tiers: elite guests' requests go up the queue and run against a tighter SLA.
+
+The tier is on the guest profile (`loyalty_tier`), kept up to date by the PMS through
+PUT /api/v1/guests/{guest_id}/loyalty, and copied onto each order when it's created. What a tier gets
+is set in the runtime config (`loyalty_tiers`, see shared/runtime_config.py), e.g.
+    {"loyalty_tiers": {"platinum": {"priority_boost": 1, "sla_percent": 50, "rank": 2}}}
+- priority_boost: levels added to the priority of orders from chat, stopping at high; urgent stays a
+  human call. Orders staff create keep the priority they chose.
+- sla_percent: the order's SLA target as a share of its department's.
+- rank: among open orders of equal priority, higher ranks are served first (queue_key()).
+A tier without a rule is still recorded on the order but changes nothing.
+"""
+import math
+from datetime import datetime
+from typing import Optional
+
+import structlog
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import PriorityEnum
+from shared.runtime_config import LoyaltyTier, runtime_config
+
+logger = structlog.get_logger()
+
+PRIORITY_LEVELS = [PriorityEnum.LOW, PriorityEnum.MEDIUM, PriorityEnum.HIGH, PriorityEnum.URGENT]
+
+def normalize_tier(tier: Optional[str]) -> Optional[str]:
+    return (tier or "").strip().lower() or None
+
+def tier_rule(tier: Optional[str]) -> Optional[LoyaltyTier]:
+    tier = normalize_tier(tier)
+    return runtime_config.settings.loyalty_tiers.get(tier) if tier else None
+
+def boost_priority(priority: str, tier: Optional[str]) -> str:
+    priority = PriorityEnum(priority)
+    rule = tier_rule(tier)
+    if not rule or priority == PriorityEnum.URGENT:
+        return priority.value
+    ceiling = PRIORITY_LEVELS.index(PriorityEnum.HIGH)
+    return PRIORITY_LEVELS[min(PRIORITY_LEVELS.index(priority) + rule.priority_boost, ceiling)].value
+
+def tier_sla_minutes(target: int, tier: Optional[str]) -> int:
+    rule = tier_rule(tier)
+    return max(1, math.ceil(target * rule.sla_percent / 100)) if rule else target
+
+def shortest_sla_minutes(target: int) -> int:
+    """The tightest target any tier has against `target`, for narrowing breach candidates."""
+    return min([target, *(tier_sla_minutes(target, tier) for tier in runtime_config.settings.loyalty_tiers)])
+
+def queue_key(order: dict) -> tuple:
+    """Who to serve first: highest priority, then highest tier rank, then longest waiting."""
+    rule = tier_rule(order.get("loyalty_tier"))
+    return (-PRIORITY_LEVELS.index(PriorityEnum(order["priority"])), -(rule.rank if rule else 0),
+            order.get("created_at") or datetime.min)
+
+async def guest_tier(guest_id: str) -> Optional[str]:
+    async with DatabaseConnection.get_connection() as conn:
+        profile = await conn["virtualbutler"]["guest_profiles"].find_one({"guest_id": guest_id}, {"loyalty_tier": 1})
+    return normalize_tier((profile or {}).get("loyalty_tier"))
+
+async def set_guest_tier(guest_id: str, tier: Optional[str]) -> bool:
+    """False when there's no such guest."""
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["guest_profiles"].update_one(
+            {"guest_id": guest_id}, {"$set": {"loyalty_tier": normalize_tier(tier)}}
+        )
+    if result.matched_count:
+        logger.info("guest_loyalty_tier_updated", guest_id=guest_id, tier=normalize_tier(tier))
+    return bool(result.matched_count)
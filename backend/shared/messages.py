@@ -0,0 +1,189 @@
+"""
+Message catalog for everything the guest reads: chat replies, acknowledgements, errors and
+notification texts. The base catalog is shared/i18n/<locale>.json; hotels override individual
+messages per locale through the admin API (`translation_overrides`), without a release.
+
+Lookup follows a fallback chain: the requested locale, its language, then DEFAULT_LOCALE
+("fr-CA" -> "fr" -> "en"). At each step a hotel override wins over the base catalog, so an override
+in "fr" is used for "fr-CA" guests too. A key missing everywhere renders as the key itself.
+
+A message can be plural: an object with CLDR-style categories ("zero", "one", "few", "other") picked
+by the `count` argument with the language's plural rule, "other" being the fallback.
+Placeholders the caller doesn't supply are left as they are rather than failing the reply.
+"""
+import asyncio
+import json
+import os
+import re
+from datetime import datetime, timezone
+from typing import Dict, List, Optional, Union
+
+import structlog
+from pydantic import BaseModel
+
+from shared.db.database import DatabaseConnection
+
+logger = structlog.get_logger()
+
+HOTEL_ID = os.getenv("HOTEL_ID", "default")
+DEFAULT_LOCALE = os.getenv("DEFAULT_LOCALE", "en")
+CATALOG_DIR = os.path.join(os.path.dirname(__file__), "i18n")
+LOCALE_PATTERN = re.compile(r"^[a-z]{2,3}(-[a-z0-9]{2,8})?$")
+PLURAL_CATEGORIES = ("zero", "one", "two", "few", "many", "other")
+
+Message = Union[str, Dict[str, str]]
+
+class MessageOverride(BaseModel):
+    value: Message
+
+class MessageCatalogError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+def normalize_locale(locale: Optional[str]) -> str:
+    return (locale or DEFAULT_LOCALE).strip().lower().replace("_", "-")
+
+def fallback_chain(locale: Optional[str]) -> List[str]:
+    locale = normalize_locale(locale)
+    chain = [locale, locale.split("-")[0], DEFAULT_LOCALE]
+    return [loc for i, loc in enumerate(chain) if loc not in chain[:i]]
+
+def plural_category(language: str, count: int) -> str:
+    language = normalize_locale(language).split("-")[0]
+    if language in ("zh", "ja", "ko"):
+        return "other"
+    if language == "fr":
+        return "one" if count in (0, 1) else "other"
+    return "one" if count == 1 else "other"
+
+class _Placeholders(dict):
+    def __missing__(self, key):
+        return "{" + key + "}"
+
+def render(message: Message, language: str, **values) -> str:
+    if isinstance(message, dict):
+        count = values.get("count")
+        category = plural_category(language, count) if isinstance(count, int) else "other"
+        message = message.get(category) or message.get("other") or ""
+    try:
+        return message.format_map(_Placeholders(values))
+    except (ValueError, IndexError):
+        # A malformed override shouldn't take a reply down with it
+        logger.warning("message_format_failed", message=message)
+        return message
+
+def validate_message(value: Message) -> Message:
+    if isinstance(value, str):
+        if not value.strip():
+            raise MessageCatalogError("A message can't be empty", 422)
+        return value
+    if not isinstance(value, dict) or "other" not in value:
+        raise MessageCatalogError("A plural message needs at least an 'other' form", 422)
+    unknown = set(value) - set(PLURAL_CATEGORIES)
+    if unknown:
+        raise MessageCatalogError(f"Unknown plural form(s): {', '.join(sorted(unknown))}", 422)
+    return value
+
+def load_catalog(directory: str = CATALOG_DIR) -> Dict[str, Dict[str, Message]]:
+    catalog = {}
+    for name in sorted(os.listdir(directory)):
+        locale, ext = os.path.splitext(name)
+        if ext != ".json" or not LOCALE_PATTERN.match(locale):
+            continue
+        try:
+            with open(os.path.join(directory, name), encoding="utf-8") as f:
+                catalog[locale] = json.load(f)
+        except Exception as e:
+            logger.error("translation_load_failed", lang=locale, error=str(e))
+    return catalog
+
+class MessageCatalog:
+    """The base catalog plus this hotel's overrides; call refresh() (or run refresh_loop()) to pick up changes."""
+
+    def __init__(self, base: Optional[Dict[str, Dict[str, Message]]] = None, hotel_id: str = HOTEL_ID,
+                 refresh_interval: int = 60):
+        self.base = load_catalog() if base is None else base
+        self.hotel_id = hotel_id
+        self.refresh_interval = refresh_interval
+        self.overrides: Dict[str, Dict[str, Message]] = {}
+
+    def keys(self) -> set:
+        return {key for messages in self.base.values() for key in messages}
+
+    def languages(self) -> List[str]:
+        return sorted(locale for locale, messages in self.base.items() if messages)
+
+    def lookup(self, key: str, locale: Optional[str] = None) -> Optional[Message]:
+        for loc in fallback_chain(locale):
+            for layer in (self.overrides, self.base):
+                message = layer.get(loc, {}).get(key)
+                if message:
+                    return message
+        return None
+
+    def translate(self, key: str, locale: Optional[str] = None, **values) -> str:
+        return render(self.lookup(key, locale) or key, normalize_locale(locale), **values)
+
+    def messages(self, locale: str) -> Dict[str, Message]:
+        """Every key as a guest in this locale sees it, fallbacks applied."""
+        return {key: self.lookup(key, locale) for key in sorted(self.keys())}
+
+    async def refresh(self) -> None:
+        overrides: Dict[str, Dict[str, Message]] = {}
+        for doc in await list_overrides(self.hotel_id):
+            overrides.setdefault(doc["locale"], {})[doc["key"]] = doc["value"]
+        self.overrides = overrides
+
+    async def refresh_loop(self) -> None:
+        while True:
+            try:
+                await self.refresh()
+            except Exception as e:
+                logger.error("translation_overrides_refresh_failed", error=str(e))
+            await asyncio.sleep(self.refresh_interval)
+
+catalog = MessageCatalog()
+
+# --- Hotel overrides ---
+
+async def list_overrides(hotel_id: str = HOTEL_ID, locale: Optional[str] = None) -> List[dict]:
+    query = {"hotel_id": hotel_id}
+    if locale:
+        query["locale"] = normalize_locale(locale)
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["translation_overrides"].find(query, {"_id": 0}).sort(
+            [("locale", 1), ("key", 1)]).to_list(length=None)
+
+async def save_override(locale: str, key: str, value: Message, updated_by: Optional[str] = None,
+                        hotel_id: str = HOTEL_ID) -> dict:
+    locale = normalize_locale(locale)
+    if not LOCALE_PATTERN.match(locale):
+        raise MessageCatalogError(f"Invalid locale '{locale}'", 422)
+    if key not in catalog.keys():
+        raise MessageCatalogError(f"Unknown message key '{key}'", 404)
+    doc = {"hotel_id": hotel_id, "locale": locale, "key": key, "value": validate_message(value),
+           "updated_by": updated_by, "updated_at": datetime.now(timezone.utc)}
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["translation_overrides"].replace_one(
+            {"hotel_id": hotel_id, "locale": locale, "key": key}, doc, upsert=True
+        )
+    await catalog.refresh()
+    logger.info("translation_override_saved", locale=locale, key=key, updated_by=updated_by)
+    return doc
+
+async def delete_override(locale: str, key: str, hotel_id: str = HOTEL_ID) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["translation_overrides"].delete_one(
+            {"hotel_id": hotel_id, "locale": normalize_locale(locale), "key": key}
+        )
+    if not result.deleted_count:
+        raise MessageCatalogError("Override not found", 404)
+    await catalog.refresh()
+    logger.info("translation_override_deleted", locale=locale, key=key)
+
+async def ensure_message_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["translation_overrides"].create_index(
+            [("hotel_id", 1), ("locale", 1), ("key", 1)], unique=True
+        )
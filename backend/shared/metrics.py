@@ -0,0 +1,32 @@
+"""Minimal in-process counters exposed in Prometheus text format on each service's /metrics."""
+import threading
+from collections import defaultdict
+from typing import Dict, Tuple
+
+_lock = threading.Lock()
+_counters: Dict[Tuple[str, Tuple[Tuple[str, str], ...]], float] = defaultdict(float)
+_gauges: Dict[Tuple[str, Tuple[Tuple[str, str], ...]], float] = {}
+
+def increment(name: str, amount: float = 1.0, **labels) -> None:
+    key = (name, tuple(sorted((k, str(v)) for k, v in labels.items())))
+    with _lock:
+        _counters[key] += amount
+
+def set_gauge(name: str, value: float, **labels) -> None:
+    """Point-in-time values (queue depths and the like), replaced on every update."""
+    key = (name, tuple(sorted((k, str(v)) for k, v in labels.items())))
+    with _lock:
+        _gauges[key] = value
+
+def snapshot() -> Dict[str, float]:
+    with _lock:
+        return {_format(name, labels): value for (name, labels), value in [*_counters.items(), *_gauges.items()]}
+
+def render_prometheus() -> str:
+    return "\n".join(f"{key} {value}" for key, value in sorted(snapshot().items())) + "\n"
+
+def _format(name: str, labels: Tuple[Tuple[str, str], ...]) -> str:
+    if not labels:
+        return name
+    rendered = ",".join(f'{k}="{v}"' for k, v in labels)
+    return f"{name}{{{rendered}}}"
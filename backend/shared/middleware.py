@@ -0,0 +1,285 @@
+import asyncio
+import contextvars
+import os
+import time
+import traceback
+import zlib
+from typing import Dict, Iterable, Optional
+
+import httpx
+import pymongo
+import structlog
+from starlette.datastructures import MutableHeaders
+from starlette.requests import Request
+from starlette.types import ASGIApp, Receive, Scope, Send
+
+from shared import metrics
+from shared.errors import ApiError, ErrorCode, error_response
+from shared.tracing import REQUEST_ID_HEADER, clean_request_id, current_request_id, new_request_id
+
+logger = structlog.get_logger()
+
+REQUEST_TIMEOUT_SECONDS = float(os.getenv("REQUEST_TIMEOUT_SECONDS", "30"))
+OPS_ALERT_WEBHOOK_URL = os.getenv("OPS_ALERT_WEBHOOK_URL")
+OPS_ALERT_THROTTLE_SECONDS = int(os.getenv("OPS_ALERT_THROTTLE_SECONDS", "60"))
+COMPRESSION_MIN_BYTES = int(os.getenv("COMPRESSION_MIN_BYTES", "1024"))
+COMPRESSION_LEVEL = int(os.getenv("COMPRESSION_LEVEL", "6"))
+COMPRESSIBLE_TYPES = tuple(t.strip() for t in os.getenv(
+    "COMPRESSIBLE_TYPES", "application/json,text/csv,text/plain,text/html,application/x-ndjson"
+).split(",") if t.strip())
+
+# Absolute monotonic deadline of the current request, if any
+request_deadline: contextvars.ContextVar[Optional[float]] = contextvars.ContextVar("request_deadline", default=None)
+
+def remaining_time(default: Optional[float] = None) -> Optional[float]:
+    """Seconds left in the current request's budget, for passing as `timeout=` to outbound calls."""
+    deadline = request_deadline.get()
+    if deadline is None:
+        return default
+    return max(0.0, deadline - time.monotonic())
+
+class RequestIdMiddleware:
+    """
+    Assigns each HTTP request and WebSocket its request ID (shared/tracing.py), binds it to the
+    request's log lines and returns it in the X-Request-ID header of every response, including the
+    error responses produced further in. Add it last so it wraps the other middleware.
+    """
+
+    def __init__(self, app: ASGIApp):
+        self.app = app
+
+    async def __call__(self, scope: Scope, receive: Receive, send: Send):
+        if scope["type"] not in ("http", "websocket"):
+            await self.app(scope, receive, send)
+            return
+
+        headers = dict(scope.get("headers") or [])
+        request_id = clean_request_id(headers.get(b"x-request-id")) or new_request_id()
+        scope.setdefault("state", {})["trace_id"] = request_id
+
+        async def send_wrapper(message):
+            if message["type"] == "http.response.start":
+                MutableHeaders(scope=message)[REQUEST_ID_HEADER] = request_id
+            await send(message)
+
+        token = current_request_id.set(request_id)
+        try:
+            with structlog.contextvars.bound_contextvars(trace_id=request_id):
+                await self.app(scope, receive, send_wrapper)
+        finally:
+            current_request_id.reset(token)
+
+class TimeoutMiddleware:
+    """
+    Bounds handler execution to `timeout` seconds.
+    - The handler task is cancelled when the budget is exceeded, which cancels any awaited Mongo or
+      Service Bus call; pymongo.timeout() additionally caps each Mongo operation server-side.
+    - Returns 504 with the standard error envelope if no response has started yet.
+    - WebSockets and paths in `exclude_paths` (e.g. long-lived streams) are not bounded.
+    """
+
+    def __init__(self, app: ASGIApp, timeout: float = REQUEST_TIMEOUT_SECONDS, exclude_paths: Iterable[str] = ()):
+        self.app = app
+        self.timeout = timeout
+        self.exclude_paths = tuple(exclude_paths)
+
+    async def __call__(self, scope: Scope, receive: Receive, send: Send):
+        if scope["type"] != "http" or scope["path"].startswith(self.exclude_paths) or self.timeout <= 0:
+            await self.app(scope, receive, send)
+            return
+
+        response_started = False
+
+        async def send_wrapper(message):
+            nonlocal response_started
+            if message["type"] == "http.response.start":
+                response_started = True
+            await send(message)
+
+        token = request_deadline.set(time.monotonic() + self.timeout)
+        try:
+            with pymongo.timeout(self.timeout):
+                await asyncio.wait_for(self.app(scope, receive, send_wrapper), timeout=self.timeout)
+        except asyncio.TimeoutError:
+            logger.warning("request_timed_out", path=scope["path"], method=scope["method"], timeout=self.timeout)
+            if not response_started:
+                response = error_response(504, "The request took too long to process.", ErrorCode.TIMEOUT,
+                                          Request(scope))
+                await response(scope, receive, send)
+        finally:
+            request_deadline.reset(token)
+
+# --- Response compression ---
+
+# zlib window bits for each content coding; deflate means the zlib format (RFC 9110), not raw deflate
+ENCODINGS = {"gzip": 16 + zlib.MAX_WBITS, "deflate": zlib.MAX_WBITS}
+
+def negotiate_encoding(accept_encoding: Optional[str]) -> Optional[str]:
+    """The coding to use for an Accept-Encoding header: gzip over deflate at equal quality; None for identity."""
+    if not accept_encoding:
+        return None
+    weights: Dict[str, float] = {}
+    for part in accept_encoding.lower().split(","):
+        coding, _, params = part.strip().partition(";")
+        q = 1.0
+        if params.strip().startswith("q="):
+            try:
+                q = float(params.strip()[2:])
+            except ValueError:
+                q = 0.0
+        weights[coding.strip()] = q
+    wildcard = weights.get("*")
+    best = None
+    for coding in ENCODINGS:
+        q = weights.get(coding, wildcard if wildcard is not None else 0.0)
+        if q > 0 and (best is None or q > best[1]):
+            best = (coding, q)
+    return best[0] if best else None
+
+def is_compressible(content_type: str) -> bool:
+    media_type = content_type.split(";")[0].strip().lower()
+    return bool(media_type) and media_type.startswith(COMPRESSIBLE_TYPES)
+
+class CompressionMiddleware:
+    """
+    gzip/deflate for JSON, CSV and other text responses, negotiated with Accept-Encoding.
+    - Whole responses under `min_bytes` go out as they are; streamed bodies (exports) are compressed
+      chunk by chunk with a sync flush, so the client still gets each chunk as it's produced.
+    - Server-sent events are never compressed (a buffering compressor would hold events back), nor are
+      responses that already have a Content-Encoding.
+    - A compressed response gets `Vary: Accept-Encoding`, and its ETag is made weak: the bytes differ
+      from the identity representation, but If-None-Match / If-Match still compare by version.
+    """
+
+    def __init__(self, app: ASGIApp, min_bytes: int = COMPRESSION_MIN_BYTES, level: int = COMPRESSION_LEVEL):
+        self.app = app
+        self.min_bytes = min_bytes
+        self.level = level
+
+    async def __call__(self, scope: Scope, receive: Receive, send: Send):
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+        encoding = negotiate_encoding(dict(scope.get("headers") or []).get(b"accept-encoding", b"").decode("latin-1"))
+        if encoding is None:
+            await self.app(scope, receive, send)
+            return
+
+        start = None
+        compressor = None
+        passthrough = False
+
+        async def send_wrapper(message):
+            nonlocal start, compressor, passthrough
+            if message["type"] == "http.response.start":
+                headers = MutableHeaders(scope=message)
+                content_type = headers.get("content-type", "")
+                if (message["status"] < 200 or message["status"] in (204, 304) or "content-encoding" in headers
+                        or content_type.startswith("text/event-stream") or not is_compressible(content_type)):
+                    passthrough = True
+                    await send(message)
+                else:
+                    # Held until the first body chunk shows whether the response is worth compressing
+                    start = message
+                return
+            if passthrough or message["type"] != "http.response.body":
+                await send(message)
+                return
+
+            body, more_body = message.get("body", b""), message.get("more_body", False)
+            if compressor is None:
+                headers = MutableHeaders(scope=start)
+                headers.add_vary_header("Accept-Encoding")
+                if not more_body and len(body) < self.min_bytes:
+                    passthrough = True
+                    await send(start)
+                    await send(message)
+                    return
+                compressor = zlib.compressobj(self.level, zlib.DEFLATED, ENCODINGS[encoding])
+                headers["Content-Encoding"] = encoding
+                etag = headers.get("etag")
+                if etag and not etag.startswith("W/"):
+                    headers["ETag"] = f"W/{etag}"
+                if more_body:
+                    del headers["Content-Length"]
+                    data = compressor.compress(body) + compressor.flush(zlib.Z_SYNC_FLUSH)
+                else:
+                    data = compressor.compress(body) + compressor.flush()
+                    headers["Content-Length"] = str(len(data))
+                await send(start)
+                await send({"type": "http.response.body", "body": data, "more_body": more_body})
+                return
+            data = compressor.compress(body) + compressor.flush(zlib.Z_SYNC_FLUSH if more_body else zlib.Z_FINISH)
+            await send({"type": "http.response.body", "body": data, "more_body": more_body})
+
+        await self.app(scope, receive, send_wrapper)
+
+# --- Crash recovery ---
+
+_last_alert: Dict[str, float] = {}
+
+async def send_ops_alert(service: str, where: str, exc: BaseException, trace_id: str, stack: str) -> None:
+    """Posts to the ops webhook, at most once per exception type and location per throttle window."""
+    if not OPS_ALERT_WEBHOOK_URL:
+        return
+    key = f"{service}:{where}:{type(exc).__name__}"
+    now = time.monotonic()
+    if now - _last_alert.get(key, -OPS_ALERT_THROTTLE_SECONDS) < OPS_ALERT_THROTTLE_SECONDS:
+        return
+    _last_alert[key] = now
+    try:
+        async with httpx.AsyncClient(timeout=5.0) as client:
+            await client.post(OPS_ALERT_WEBHOOK_URL, json={
+                "service": service,
+                "where": where,
+                "error": f"{type(exc).__name__}: {exc}",
+                "trace_id": trace_id,
+                "stack": stack[-4000:]
+            })
+    except Exception as e:
+        logger.error("ops_alert_failed", error=str(e))
+
+def record_crash(service: str, where: str, exc: BaseException, trace_id: str) -> None:
+    stack = "".join(traceback.format_exception(type(exc), exc, exc.__traceback__))
+    logger.error("unhandled_exception_recovered", service=service, where=where, trace_id=trace_id,
+                 error=str(exc), error_type=type(exc).__name__, stack=stack)
+    metrics.increment("butler_recovered_panics_total", service=service, where=where)
+    asyncio.create_task(send_ops_alert(service, where, exc, trace_id, stack))
+
+class RecoveryMiddleware:
+    """
+    Global crash recovery: any exception escaping a handler is logged with its stack trace and
+    correlation ID, counted in butler_recovered_panics_total, optionally sent to the ops webhook,
+    and turned into a 500 with the standard error envelope (if the response has not started).
+    """
+
+    def __init__(self, app: ASGIApp, service: str):
+        self.app = app
+        self.service = service
+
+    async def __call__(self, scope: Scope, receive: Receive, send: Send):
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+
+        state = scope.setdefault("state", {})
+        headers = dict(scope.get("headers") or [])
+        state.setdefault("trace_id", clean_request_id(headers.get(b"x-request-id")) or new_request_id())
+        response_started = False
+
+        async def send_wrapper(message):
+            nonlocal response_started
+            if message["type"] == "http.response.start":
+                response_started = True
+            await send(message)
+
+        try:
+            await self.app(scope, receive, send_wrapper)
+        except Exception as exc:
+            route = scope.get("route")
+            where = getattr(route, "path", scope["path"])
+            record_crash(self.service, where, exc, state["trace_id"])
+            if not response_started:
+                response = error_response(500, "An unexpected error occurred. Please try again later.",
+                                          ErrorCode.INTERNAL_ERROR, Request(scope))
+                await response(scope, receive, send)
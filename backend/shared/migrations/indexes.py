@@ -0,0 +1,102 @@
+"""
+The indexes the services rely on, declared in one place rather than created ad hoc at startup.
+
+Each IndexSpec is compared with what the collection has. A missing index is created. One whose options
+changed is rebuilt, except a TTL change, which is applied in place with collMod. An index found under
+another name but with the same keys counts as present, so indexes created before this list existed are
+not rebuilt just for their names. Indexes that aren't listed are left alone; modules that own their
+collections (leases, idempotency keys, ...) still create theirs in their ensure_*_indexes().
+"""
+import os
+from dataclasses import dataclass, field
+from typing import Dict, List, Optional, Tuple, Union
+
+from shared.sandbox import SANDBOX_COLLECTIONS
+
+NOTIFICATION_LOG_TTL_DAYS = int(os.getenv("NOTIFICATION_LOG_TTL_DAYS", "90"))
+# Options that make two indexes on the same keys different; anything else Mongo reports (v, ns) is ignored
+COMPARED_OPTIONS = ("unique", "sparse", "expireAfterSeconds", "partialFilterExpression")
+
+Direction = Union[int, str]
+
+@dataclass
+class IndexSpec:
+    collection: str
+    keys: List[Tuple[str, Direction]]
+    options: Dict = field(default_factory=dict)
+    name: Optional[str] = None
+
+    def __post_init__(self):
+        # Mongo's own default name, so indexes created by plain create_index() calls match
+        self.name = self.name or "_".join(f"{key}_{direction}" for key, direction in self.keys)
+
+INDEXES: List[IndexSpec] = [
+    IndexSpec("work_orders", [("request_id", 1)], {"unique": True}),
+    IndexSpec("work_orders", [("guest_id", 1), ("status", 1)]),
+    IndexSpec("work_orders", [("tags", 1)]),
+    IndexSpec("work_orders", [("parent_id", 1)], {"sparse": True}),
+    IndexSpec("work_orders", [("metadata.location.hub_id", 1)], {"sparse": True}),
+    IndexSpec("work_orders", [("workflow.due_at", 1)], {"partialFilterExpression": {"workflow.overdue": False}}),
+    IndexSpec("work_orders", [("department", 1), ("sla_breached_at", 1), ("created_at", 1)]),
+    IndexSpec("work_orders", [("description", "text")]),
+    # Orders created before numbering, and subtasks of their parents, store None; only real numbers are unique
+    IndexSpec("work_orders", [("order_number", 1)], {"unique": True,
+                                                      "partialFilterExpression": {"order_number": {"$type": "string"}}}),
+    IndexSpec("chat_requests", [("request_id", 1)], {"unique": True}),
+    IndexSpec("chat_requests", [("guest_id", 1), ("status", 1)]),
+    IndexSpec("chat_requests", [("message", "text")]),
+    IndexSpec("agent_conversations", [("guest_id", 1), ("status", 1)]),
+    IndexSpec("agent_conversations", [("status", 1), ("created_at", 1)]),
+    IndexSpec("response_templates", [("intent", 1), ("language", 1)], {"unique": True}),
+    IndexSpec("assets", [("asset_id", 1)], {"unique": True}),
+    IndexSpec("rooms", [("room_number", 1)], {"unique": True}),
+    IndexSpec("routing_rulesets", [("version", 1)], {"unique": True}),
+    IndexSpec("custom_field_definitions", [("key", 1)], {"unique": True}),
+    IndexSpec("notification_logs", [("timestamp", 1)], {"expireAfterSeconds": NOTIFICATION_LOG_TTL_DAYS * 86400},
+              name="ttl_timestamp"),
+    IndexSpec("schema_migrations", [("version", 1)], {"unique": True}),
+    # The nightly sandbox purge (shared/sandbox.py); partial, so hotels not in sandbox pay nothing
+    *[IndexSpec(c, [("synthetic_at", 1)], {"partialFilterExpression": {"synthetic": True}}) for c in SANDBOX_COLLECTIONS],
+]
+
+def _keys(info: dict) -> List[Tuple[str, Direction]]:
+    """Keys as declared; Mongo reports a text index as _fts/_ftsx plus its weights."""
+    keys = [(k, d if isinstance(d, str) else int(d)) for k, d in info["key"]]
+    if ("_fts", "text") in keys:
+        return sorted((k, "text") for k in info.get("weights", {}))
+    return keys
+
+def _options(info: dict) -> dict:
+    return {k: info[k] for k in COMPARED_OPTIONS if k in info and info[k] not in (False, None)}
+
+def _without_ttl(options: dict) -> dict:
+    return {k: v for k, v in options.items() if k != "expireAfterSeconds"}
+
+def _spec_keys(spec: IndexSpec) -> List[Tuple[str, Direction]]:
+    return sorted(spec.keys) if any(d == "text" for _, d in spec.keys) else list(spec.keys)
+
+def plan_indexes(specs: List[IndexSpec], existing: Dict[str, Dict[str, dict]]) -> List[dict]:
+    """
+    What it takes to bring the collections in line with `specs`, given each collection's
+    index_information(); an empty list when nothing needs doing.
+    """
+    plan = []
+    for spec in specs:
+        current = existing.get(spec.collection, {})
+        name = spec.name if spec.name in current else next(
+            (n for n, info in current.items() if _keys(info) == _spec_keys(spec)), None)
+        step = {"collection": spec.collection, "name": spec.name, "keys": spec.keys, "options": spec.options}
+        if name is None:
+            plan.append({**step, "action": "create"})
+            continue
+        info = current[name]
+        have, want = _options(info), _options(spec.options)
+        if _keys(info) == _spec_keys(spec) and have == want:
+            continue
+        ttl_changed = "expireAfterSeconds" in have and "expireAfterSeconds" in want
+        if ttl_changed and _keys(info) == _spec_keys(spec) and _without_ttl(have) == _without_ttl(want):
+            plan.append({**step, "name": name, "action": "update_ttl",
+                         "from": have["expireAfterSeconds"], "to": want["expireAfterSeconds"]})
+        else:
+            plan.append({**step, "action": "replace", "drop": name})
+    return plan
@@ -0,0 +1,110 @@
+"""
+Runs the schema migrations and index sync, at service startup (unless MIGRATE_ON_STARTUP=false) or on
+demand through POST /api/v1/admin/migrations/run, which `butlerctl migrate` calls.
+
+Only one replica migrates at a time: the run holds the `migrations` lease, and a replica that doesn't
+get it skips the run at startup, leaving it to the holder. A dry run writes nothing and takes no lease;
+it reports the migrations that would be applied and the index changes that would be made.
+
+A failed migration stops the run, since later ones may depend on it; it is not recorded and is retried
+next time. A failed index build (e.g. a unique index over duplicates) is reported and the rest go on.
+Neither stops the service from starting.
+"""
+import os
+import time
+from datetime import datetime, timezone
+
+import structlog
+
+from shared.db.database import DatabaseConnection
+from shared.leases import REPLICA_ID, Lease, release_lease
+from shared.migrations.indexes import INDEXES, plan_indexes
+from shared.migrations.versions import MIGRATIONS, pending
+
+logger = structlog.get_logger()
+
+MIGRATE_ON_STARTUP = os.getenv("MIGRATE_ON_STARTUP", "true").lower() == "true"
+migrations_lease = Lease("migrations", 60)
+
+class MigrationError(Exception):
+    def __init__(self, message: str, status_code: int = 409):
+        super().__init__(message)
+        self.status_code = status_code
+
+async def _applied(db) -> list:
+    return await db["schema_migrations"].find({}, {"_id": 0}).sort("version", 1).to_list(length=None)
+
+async def _existing_indexes(db) -> dict:
+    return {collection: await db[collection].index_information() for collection in {s.collection for s in INDEXES}}
+
+async def _plan(db) -> dict:
+    applied = await _applied(db)
+    return {
+        "applied": applied,
+        "pending": [m.describe() for m in pending(MIGRATIONS, {doc["version"] for doc in applied})],
+        "indexes": plan_indexes(INDEXES, await _existing_indexes(db)),
+    }
+
+async def migration_status() -> dict:
+    async with DatabaseConnection.get_connection() as conn:
+        return await _plan(conn["virtualbutler"])
+
+async def _apply_index(db, step: dict) -> None:
+    collection = db[step["collection"]]
+    if step["action"] == "update_ttl":
+        await db.command("collMod", step["collection"],
+                         index={"name": step["name"], "expireAfterSeconds": step["to"]})
+        return
+    if step["action"] == "replace":
+        await collection.drop_index(step["drop"])
+    await collection.create_index(step["keys"], name=step["name"], **step["options"])
+
+async def _run(db) -> dict:
+    applied = {doc["version"] for doc in await _applied(db)}
+    migrated, failed = [], None
+    for migration in pending(MIGRATIONS, applied):
+        started = time.monotonic()
+        try:
+            result = await migration.apply(db)
+        except Exception as e:
+            failed = {**migration.describe(), "error": str(e)}
+            logger.error("migration_failed", version=migration.version, name=migration.name, error=str(e))
+            break
+        record = {**migration.describe(), "result": result, "applied_at": datetime.now(timezone.utc),
+                  "duration_ms": round((time.monotonic() - started) * 1000), "applied_by": REPLICA_ID}
+        await db["schema_migrations"].insert_one(dict(record))
+        migrated.append(record)
+        logger.info("migration_applied", version=migration.version, name=migration.name, result=result)
+    indexes = []
+    for step in plan_indexes(INDEXES, await _existing_indexes(db)):
+        try:
+            await _apply_index(db, step)
+            indexes.append(step)
+            logger.info("index_synced", collection=step["collection"], index=step["name"], action=step["action"])
+        except Exception as e:
+            indexes.append({**step, "error": str(e)})
+            logger.error("index_sync_failed", collection=step["collection"], index=step["name"], error=str(e))
+    return {"migrated": migrated, "failed": failed, "indexes": indexes}
+
+async def migrate(dry_run: bool = False) -> dict:
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        if dry_run:
+            plan = await _plan(db)
+            return {"dry_run": True, "pending": plan["pending"], "indexes": plan["indexes"]}
+        try:
+            result = await migrations_lease.run(lambda: _run(db))
+        finally:
+            # Unlike a polling job's, this lease isn't kept between runs
+            await release_lease(migrations_lease.name, migrations_lease.holder)
+    if result is None:
+        raise MigrationError("Another replica is migrating")
+    return {"dry_run": False, **result}
+
+async def migrate_on_startup() -> None:
+    if not MIGRATE_ON_STARTUP:
+        return
+    try:
+        await migrate()
+    except MigrationError:
+        logger.info("migrations_skipped", reason="another replica is migrating")
@@ -0,0 +1,62 @@
+"""
+Schema migrations: one-off data changes, applied in version order and recorded in `schema_migrations`
+so each runs once per database.
+
+Add a migration by appending a Migration with the next version; never renumber or edit one that has
+shipped, since databases that already applied it won't run it again. A migration must be safe to run
+again after failing part-way, because it is only recorded once it finishes. Migrations run before the
+index sync (indexes.py), so they can clean up data a new unique index would reject.
+"""
+from dataclasses import dataclass
+from typing import Any, Awaitable, Callable, Iterable, List, Set, Tuple
+
+import structlog
+from pymongo import UpdateOne
+
+from shared.scan_codes import backfill_short_codes
+
+logger = structlog.get_logger()
+
+@dataclass
+class Migration:
+    version: int
+    name: str
+    apply: Callable[[Any], Awaitable[Any]]
+    description: str = ""
+
+    def describe(self) -> dict:
+        return {"version": self.version, "name": self.name, "description": self.description}
+
+def pending(migrations: Iterable[Migration], applied: Set[int]) -> List[Migration]:
+    return sorted((m for m in migrations if m.version not in applied), key=lambda m: m.version)
+
+def renamed_duplicates(docs: List[dict]) -> List[Tuple[Any, str]]:
+    """(_id, new request_id) for all but the oldest of documents sharing a request_id."""
+    ordered = sorted(docs, key=lambda doc: (doc.get("created_at") is None, doc.get("created_at"), str(doc["_id"])))
+    return [(doc["_id"], f"{doc['request_id']}~{i}") for i, doc in enumerate(ordered[1:], 1)]
+
+async def dedupe_request_ids(db) -> dict:
+    # Request IDs were timestamps, so two requests in the same instant could share one
+    counts = {}
+    for collection in ("chat_requests", "work_orders"):
+        groups = db[collection].aggregate([
+            {"$group": {"_id": "$request_id", "count": {"$sum": 1},
+                        "docs": {"$push": {"_id": "$_id", "request_id": "$request_id", "created_at": "$created_at"}}}},
+            {"$match": {"count": {"$gt": 1}}}
+        ], allowDiskUse=True)
+        updates = [UpdateOne({"_id": _id}, {"$set": {"request_id": request_id}})
+                   async for group in groups for _id, request_id in renamed_duplicates(group["docs"])]
+        if updates:
+            await db[collection].bulk_write(updates, ordered=False)
+            logger.warning("duplicate_request_ids_renamed", collection=collection, count=len(updates))
+        counts[collection] = len(updates)
+    return counts
+
+async def store_short_codes(db) -> dict:
+    return {"work_orders": await backfill_short_codes()}
+
+MIGRATIONS: List[Migration] = [
+    Migration(1, "dedupe_request_ids", dedupe_request_ids,
+              "Suffix duplicate request IDs (~1, ~2) so request_id can be unique; the oldest keeps its ID"),
+    Migration(2, "store_short_codes", store_short_codes, "Store scan codes on orders created before them"),
+]
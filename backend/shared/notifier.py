@@ -0,0 +1,62 @@
+import asyncio
+from collections import defaultdict
+from typing import Dict, Set
+
+import structlog
+
+logger = structlog.get_logger()
+
+class ChangeNotifier:
+    """
+    In-process pub/sub keyed by resource ID, used to wake long-poll and streaming handlers.
+    Only covers changes made in this process; waiters should still re-check their source of truth
+    periodically to observe changes from other replicas.
+    """
+
+    def __init__(self):
+        self._waiters: Dict[str, Set[asyncio.Event]] = defaultdict(set)
+
+    def publish(self, key: str) -> None:
+        for event in self._waiters.pop(key, set()):
+            event.set()
+
+    async def wait(self, key: str, timeout: float) -> bool:
+        """Waits until `key` is published or `timeout` elapses; returns True if it was published."""
+        event = asyncio.Event()
+        self._waiters[key].add(event)
+        try:
+            await asyncio.wait_for(event.wait(), timeout=timeout)
+            return True
+        except asyncio.TimeoutError:
+            return False
+        finally:
+            waiters = self._waiters.get(key)
+            if waiters is not None:
+                waiters.discard(event)
+                if not waiters:
+                    self._waiters.pop(key, None)
+
+class EventBus:
+    """
+    In-process fan-out of events to subscriber queues (SSE streams, WebSockets, webhooks).
+    Slow subscribers lose events rather than blocking publishers.
+    """
+
+    def __init__(self, name: str):
+        self.name = name
+        self._subscribers: Set[asyncio.Queue] = set()
+
+    def subscribe(self, maxsize: int = 100) -> asyncio.Queue:
+        queue: asyncio.Queue = asyncio.Queue(maxsize=maxsize)
+        self._subscribers.add(queue)
+        return queue
+
+    def unsubscribe(self, queue: asyncio.Queue) -> None:
+        self._subscribers.discard(queue)
+
+    def publish(self, event: dict) -> None:
+        for queue in list(self._subscribers):
+            try:
+                queue.put_nowait(event)
+            except asyncio.QueueFull:
+                logger.warning("event_bus_subscriber_lagging", bus=self.name)
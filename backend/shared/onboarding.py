@@ -0,0 +1,144 @@
+"""
+QR-code onboarding: the guest scans a code from the key card wallet or the in-room tablet and is
+signed in, instead of typing a room number and PIN (or a guest ID).
+
+Front desk staff issue a code for a stay, by guest or by room (the guest staying there now). The code
+is a link carrying a single-use token; only its hash is stored. Redeeming it returns a guest JWT bound
+to the stay: it names the room and the stay and expires at checkout, and it stops working as soon as the
+guest checks out or the stay on their profile changes (stay_is_current()). A code expires after
+ONBOARDING_CODE_TTL_HOURS or at checkout, whichever is first, and issuing a new code for the stay
+voids the older ones, so a lost card can be replaced without the old code staying usable.
+"""
+import hashlib
+import os
+import secrets
+from datetime import datetime, timedelta, timezone
+from typing import Optional
+
+import segno
+import structlog
+from pydantic import BaseModel, Field
+from pymongo import ReturnDocument
+
+from shared.db.database import DatabaseConnection
+from shared.rooms import linked_rooms
+from shared.security.device_auth import current_guest
+from shared.surveys import stay_id
+
+logger = structlog.get_logger()
+
+ONBOARDING_CODE_TTL_HOURS = int(os.getenv("ONBOARDING_CODE_TTL_HOURS", "72"))
+ONBOARDING_URL_TEMPLATE = os.getenv("ONBOARDING_URL_TEMPLATE", "https://butler.example.com/onboard/{token}")
+# Stays without a checkout date in the profile get a token this long
+ONBOARDING_DEFAULT_SESSION_HOURS = int(os.getenv("ONBOARDING_DEFAULT_SESSION_HOURS", "24"))
+
+class OnboardingError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+class OnboardingCodeRequest(BaseModel):
+    guest_id: Optional[str] = Field(None, description="The guest the code is for; or give room_number")
+    room_number: Optional[str] = Field(None, description="Defaults to the guest's primary room")
+
+class OnboardingRedemption(BaseModel):
+    token: str = Field(..., min_length=16, max_length=100)
+
+def hash_token(token: str) -> str:
+    return hashlib.sha256(token.encode()).hexdigest()
+
+def onboarding_link(token: str) -> str:
+    return ONBOARDING_URL_TEMPLATE.format(token=token)
+
+def _aware(value: Optional[datetime]) -> Optional[datetime]:
+    return value.replace(tzinfo=timezone.utc) if value and value.tzinfo is None else value
+
+def code_expiry(now: datetime, check_out: Optional[datetime]) -> datetime:
+    expires_at = now + timedelta(hours=ONBOARDING_CODE_TTL_HOURS)
+    return min(expires_at, _aware(check_out)) if check_out else expires_at
+
+def session_ttl(now: datetime, check_out: Optional[datetime]) -> timedelta:
+    """The guest JWT lasts until checkout."""
+    if not check_out:
+        return timedelta(hours=ONBOARDING_DEFAULT_SESSION_HOURS)
+    return _aware(check_out) - now
+
+def qr_svg(url: str) -> str:
+    return segno.make(url, error="m").svg_inline(scale=4)
+
+async def _staying_guest(guest_id: str, now: datetime) -> dict:
+    async with DatabaseConnection.get_connection() as conn:
+        guest = await conn["virtualbutler"]["guest_profiles"].find_one(
+            {"guest_id": guest_id}, {"_id": 0, "guest_id": 1, "room_number": 1, "rooms": 1, "check_out_date": 1}
+        )
+    if not guest:
+        raise OnboardingError("Guest not found", 404)
+    if guest.get("check_out_date") and _aware(guest["check_out_date"]) <= now:
+        raise OnboardingError("The guest has checked out", 409)
+    return guest
+
+async def issue_code(request: OnboardingCodeRequest, created_by: Optional[str] = None) -> dict:
+    now = datetime.now(timezone.utc)
+    guest_id = request.guest_id
+    if not guest_id:
+        if not request.room_number:
+            raise OnboardingError("guest_id or room_number is required", 422)
+        guest_id = await current_guest(request.room_number, now)
+        if not guest_id:
+            raise OnboardingError(f"No guest is checked in to room {request.room_number}", 404)
+    guest = await _staying_guest(guest_id, now)
+    rooms = linked_rooms(guest)
+    room_number = request.room_number or (rooms[0] if rooms else None)
+    if room_number not in rooms:
+        raise OnboardingError(f"Room {room_number} is not linked to this guest", 422)
+    token = secrets.token_urlsafe(24)
+    expires_at = code_expiry(now, guest.get("check_out_date"))
+    async with DatabaseConnection.get_connection() as conn:
+        codes = conn["virtualbutler"]["onboarding_codes"]
+        voided = await codes.update_many({"guest_id": guest_id, "room_number": room_number, "status": "issued"},
+                                         {"$set": {"status": "void", "voided_at": now}})
+        await codes.insert_one({"token_hash": hash_token(token), "guest_id": guest_id, "room_number": room_number,
+                                "status": "issued", "created_at": now, "created_by": created_by,
+                                "expires_at": expires_at})
+    logger.info("onboarding_code_issued", guest_id=guest_id, room_number=room_number, created_by=created_by,
+                voided=voided.modified_count)
+    url = onboarding_link(token)
+    return {"guest_id": guest_id, "room_number": room_number, "url": url, "expires_at": expires_at,
+            "qr_svg": qr_svg(url)}
+
+async def redeem_code(token: str) -> dict:
+    """Uses up the code; returns the claims and lifetime for the stay's guest JWT."""
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        code = await conn["virtualbutler"]["onboarding_codes"].find_one_and_update(
+            {"token_hash": hash_token(token), "status": "issued", "expires_at": {"$gt": now}},
+            {"$set": {"status": "redeemed", "redeemed_at": now}},
+            return_document=ReturnDocument.AFTER
+        )
+    if not code:
+        raise OnboardingError("Invalid, used or expired onboarding code", 401)
+    guest = await _staying_guest(code["guest_id"], now)
+    if code["room_number"] not in linked_rooms(guest):
+        # Moved rooms since the code was printed
+        raise OnboardingError("The code's room is no longer linked to the guest", 409)
+    check_out = guest.get("check_out_date")
+    claims = {"sub": guest["guest_id"], "role": "guest", "room": code["room_number"], "onboarding": True}
+    if check_out:
+        claims["stay"] = stay_id(guest["guest_id"], _aware(check_out))
+    logger.info("onboarding_code_redeemed", guest_id=guest["guest_id"], room_number=code["room_number"])
+    return {"claims": claims, "ttl": session_ttl(now, check_out)}
+
+async def stay_is_current(guest_id: str, stay: str, now: Optional[datetime] = None) -> bool:
+    """Whether a guest JWT's `stay` claim still names the guest's stay: not checked out, not another booking."""
+    now = now or datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        guest = await conn["virtualbutler"]["guest_profiles"].find_one({"guest_id": guest_id}, {"check_out_date": 1})
+    check_out = (guest or {}).get("check_out_date")
+    return bool(check_out) and _aware(check_out) > now and stay_id(guest_id, _aware(check_out)) == stay
+
+async def ensure_onboarding_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        await db["onboarding_codes"].create_index("token_hash", unique=True)
+        await db["onboarding_codes"].create_index([("guest_id", 1), ("room_number", 1), ("status", 1)])
+        await db["onboarding_codes"].create_index("expires_at", expireAfterSeconds=7 * 24 * 3600)
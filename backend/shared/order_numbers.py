@@ -0,0 +1,55 @@
+"""
+Human-friendly work-order numbers (HK-2045) that staff and guests can read over the phone.
+
+Each department has a two-letter prefix and its own counter per hotel in `counters`, incremented
+atomically so concurrent replicas never hand out the same number. Subtasks take their parent's number
+with a suffix (HK-2045.1), like their IDs. An order keeps its number if it is later re-routed.
+
+Every endpoint that takes a work_order_id also accepts an order number, in any letter case.
+"""
+import os
+import re
+from typing import Optional
+
+from pymongo import ReturnDocument
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import DepartmentEnum
+
+HOTEL_ID = os.getenv("HOTEL_ID", "default")
+ORDER_NUMBER_START = int(os.getenv("ORDER_NUMBER_START", "1000"))
+ORDER_NUMBER_PREFIXES = {
+    DepartmentEnum.HOUSEKEEPING.value: "HK",
+    DepartmentEnum.MAINTENANCE.value: "MT",
+    DepartmentEnum.FRONT_DESK.value: "FD",
+    DepartmentEnum.ROOM_SERVICE.value: "RS",
+    DepartmentEnum.IT.value: "IT",
+    DepartmentEnum.SECURITY.value: "SE",
+    DepartmentEnum.CONCIERGE.value: "CO",
+}
+ORDER_NUMBER_PATTERN = re.compile(r"^[A-Z]{2}-\d+(\.\d+)?$")
+
+def normalize_order_number(value: str) -> str:
+    return value.strip().upper()
+
+def is_order_number(value: Optional[str]) -> bool:
+    return bool(value) and bool(ORDER_NUMBER_PATTERN.match(normalize_order_number(value)))
+
+def order_number_prefix(department: str) -> str:
+    return ORDER_NUMBER_PREFIXES.get(DepartmentEnum(department).value, "WO")
+
+async def next_order_number(department: str) -> str:
+    prefix = order_number_prefix(department)
+    async with DatabaseConnection.get_connection() as conn:
+        counter = await conn["virtualbutler"]["counters"].find_one_and_update(
+            {"_id": f"{HOTEL_ID}:work_order:{prefix}"}, {"$inc": {"seq": 1}},
+            upsert=True, return_document=ReturnDocument.AFTER
+        )
+    return f"{prefix}-{ORDER_NUMBER_START + counter['seq'] - 1}"
+
+async def work_order_id_for(order_number: str) -> Optional[str]:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["work_orders"].find_one(
+            {"order_number": normalize_order_number(order_number)}, {"work_order_id": 1}
+        )
+    return doc["work_order_id"] if doc else None
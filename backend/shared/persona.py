@@ -0,0 +1,147 @@
+"""
+The bot's voice per hotel: a formal luxury property and a casual boutique say the same thing differently.
+
+A persona is applied to each reply just before it goes out. Replies are stored neutral, so a persona
+change also applies to conversations already under way.
+- Greeting: none, formal ("Good evening, Anna Laurent.", by the hotel's local time) or casual ("Hi Anna!"),
+  or the hotel's own wording per language. `{name}` in a custom greeting becomes ", <name>", or nothing
+  when the guest's name isn't known.
+- Sign-off per language, on its own line.
+- Emoji: keep the templates' own, strip them all, or add one for the department.
+Greetings and sign-offs are only added in languages they exist in; a French reply never gets an English
+greeting. Emergency replies are always sent exactly as written.
+"""
+import asyncio
+import os
+import re
+from datetime import datetime, timezone
+from enum import Enum
+from typing import Dict, Optional
+
+import structlog
+from pydantic import BaseModel, Field
+
+from shared.db.database import DatabaseConnection
+
+logger = structlog.get_logger()
+
+HOTEL_ID = os.getenv("HOTEL_ID", "default")
+
+class GreetingStyleEnum(str, Enum):
+    NONE = "none"
+    FORMAL = "formal"
+    CASUAL = "casual"
+
+class EmojiPolicyEnum(str, Enum):
+    KEEP = "keep"              # as the templates have them
+    NONE = "none"              # stripped from every reply
+    EXPRESSIVE = "expressive"  # plus one for the department
+
+FORMAL_GREETINGS = {
+    "en": {"morning": "Good morning{name}.", "afternoon": "Good afternoon{name}.", "evening": "Good evening{name}."},
+    "fr": {"morning": "Bonjour{name}.", "afternoon": "Bonjour{name}.", "evening": "Bonsoir{name}."},
+    "es": {"morning": "Buenos días{name}.", "afternoon": "Buenas tardes{name}.", "evening": "Buenas noches{name}."},
+}
+CASUAL_GREETINGS = {"en": "Hi{name}!", "fr": "Salut{name} !", "es": "¡Hola{name}!"}
+DEPARTMENT_EMOJI = {"housekeeping": "🧺", "maintenance": "🔧", "room_service": "🍽️", "it": "📶", "front_desk": "🛎️",
+                    "security": "🛡️", "concierge": "✨"}
+EMOJI = re.compile("[\U0001F000-\U0001FAFF\u2600-\u27BF\u2B00-\u2BFF\uFE0F\u200D]+")
+
+class PersonaError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+class PersonaUpdate(BaseModel):
+    greeting_style: GreetingStyleEnum = GreetingStyleEnum.NONE
+    greetings: Dict[str, str] = Field(default_factory=dict, description="Custom greeting by language, overriding the style's")
+    sign_offs: Dict[str, str] = Field(default_factory=dict, description="Sign-off by language")
+    emoji_policy: EmojiPolicyEnum = EmojiPolicyEnum.KEEP
+
+class ResponsePersona(PersonaUpdate):
+    hotel_id: str = HOTEL_ID
+    updated_by: Optional[str] = None
+    updated_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+
+def daypart(local_time: datetime) -> str:
+    if 5 <= local_time.hour < 12:
+        return "morning"
+    return "afternoon" if 12 <= local_time.hour < 18 else "evening"
+
+def greeting(persona: ResponsePersona, language: str, local_time: datetime, name: Optional[str] = None) -> Optional[str]:
+    template, separator = persona.greetings.get(language), ", "
+    if template is None and persona.greeting_style == GreetingStyleEnum.FORMAL:
+        template = FORMAL_GREETINGS.get(language, {}).get(daypart(local_time))
+    elif template is None and persona.greeting_style == GreetingStyleEnum.CASUAL:
+        template, separator = CASUAL_GREETINGS.get(language), " "
+    if not template:
+        return None
+    return template.replace("{name}", f"{separator}{name}" if name else "")
+
+def apply_persona(text: Optional[str], persona: Optional[ResponsePersona], language: str, local_time: datetime,
+                  name: Optional[str] = None, department: Optional[str] = None) -> Optional[str]:
+    if not text or persona is None:
+        return text
+    if persona.emoji_policy == EmojiPolicyEnum.NONE:
+        text = re.sub(r"\s{2,}", " ", EMOJI.sub("", text)).strip()
+    elif persona.emoji_policy == EmojiPolicyEnum.EXPRESSIVE and department in DEPARTMENT_EMOJI:
+        text = f"{text} {DEPARTMENT_EMOJI[department]}"
+    opening = greeting(persona, language, local_time, name)
+    if opening:
+        text = f"{opening} {text}"
+    sign_off = persona.sign_offs.get(language)
+    if sign_off:
+        text = f"{text}\n\n{sign_off}"
+    return text
+
+# --- Persona in use ---
+
+class ResponsePersonas:
+    """In-memory copy of this hotel's persona; call refresh() (or run refresh_loop()) to pick up admin changes."""
+
+    def __init__(self, hotel_id: str = HOTEL_ID, refresh_interval: int = 30):
+        self.hotel_id = hotel_id
+        self.refresh_interval = refresh_interval
+        self.persona: Optional[ResponsePersona] = None
+
+    async def refresh(self) -> None:
+        self.persona = await get_persona(self.hotel_id)
+
+    async def refresh_loop(self) -> None:
+        while True:
+            try:
+                await self.refresh()
+            except Exception as e:
+                logger.error("response_persona_refresh_failed", error=str(e))
+            await asyncio.sleep(self.refresh_interval)
+
+response_personas = ResponsePersonas()
+
+# --- Persona administration ---
+
+async def get_persona(hotel_id: str = HOTEL_ID) -> Optional[ResponsePersona]:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["response_personas"].find_one({"hotel_id": hotel_id}, {"_id": 0})
+    return ResponsePersona(**doc) if doc else None
+
+async def save_persona(persona: ResponsePersona) -> ResponsePersona:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["response_personas"].replace_one(
+            {"hotel_id": persona.hotel_id}, persona.model_dump(mode="json") | {"updated_at": persona.updated_at}, upsert=True
+        )
+    await response_personas.refresh()
+    logger.info("response_persona_saved", hotel_id=persona.hotel_id, greeting_style=persona.greeting_style.value,
+                emoji_policy=persona.emoji_policy.value)
+    return persona
+
+async def delete_persona(hotel_id: str = HOTEL_ID) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["response_personas"].delete_one({"hotel_id": hotel_id})
+    if not result.deleted_count:
+        raise PersonaError("No persona configured for this hotel", 404)
+    await response_personas.refresh()
+    logger.info("response_persona_deleted", hotel_id=hotel_id)
+
+async def ensure_persona_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["response_personas"].create_index("hotel_id", unique=True)
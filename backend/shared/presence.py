@@ -0,0 +1,118 @@
+"""
+Staff presence, so dispatch knows who is actually reachable rather than just rostered (`on_shift`, see
+shared/zones.py).
+
+The staff app sends a heartbeat every STAFF_HEARTBEAT_SECONDS for each session (phone, tablet) and ends
+the session on sign-out. A member is online while any session was heard from in the last
+STAFF_OFFLINE_AFTER_SECONDS. Staff who have never sent a heartbeat (an app without presence support)
+count as online, so auto-assignment keeps working while the app rolls out.
+
+Orders assigned to someone offline for more than STAFF_REASSIGN_AFTER_MINUTES are handed to the nearest
+online attendant, or put back in the queue (work-order service, checked every STAFF_PRESENCE_CHECK_SECONDS).
+Orders they have already started are left with them and flagged to the dispatcher once.
+"""
+import os
+from datetime import datetime, timedelta, timezone
+from typing import List, Optional
+
+import structlog
+from pydantic import BaseModel, Field
+
+from shared.clock import as_utc
+from shared.db.database import DatabaseConnection
+
+logger = structlog.get_logger()
+
+STAFF_HEARTBEAT_SECONDS = int(os.getenv("STAFF_HEARTBEAT_SECONDS", "30"))
+STAFF_OFFLINE_AFTER_SECONDS = int(os.getenv("STAFF_OFFLINE_AFTER_SECONDS", "90"))
+STAFF_REASSIGN_AFTER_MINUTES = int(os.getenv("STAFF_REASSIGN_AFTER_MINUTES", "5"))
+STAFF_PRESENCE_CHECK_SECONDS = int(os.getenv("STAFF_PRESENCE_CHECK_SECONDS", "60"))
+# Sessions that stopped without signing out are forgotten after a day
+SESSION_TTL_SECONDS = 86400
+
+class PresenceError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+class Heartbeat(BaseModel):
+    session_id: str = Field(..., min_length=1, max_length=80)
+    client: Optional[str] = Field(None, max_length=80, description="App and version, e.g. ios/2.3.1")
+
+def is_online(member: dict, now: datetime) -> bool:
+    if "last_seen_at" not in member:
+        return True
+    last_seen = member["last_seen_at"]
+    # None: signed out of every session
+    return last_seen is not None and as_utc(last_seen) >= now - timedelta(seconds=STAFF_OFFLINE_AFTER_SECONDS)
+
+def presence_view(member: dict, now: datetime) -> dict:
+    return {
+        "staff_id": member["staff_id"],
+        "name": member.get("name"),
+        "department": member.get("department"),
+        "on_shift": member.get("on_shift", False),
+        "online": is_online(member, now),
+        "last_seen_at": member.get("last_seen_at"),
+        "current_zone": member.get("current_zone"),
+    }
+
+async def record_heartbeat(staff_id: str, heartbeat: Heartbeat, now: Optional[datetime] = None) -> dict:
+    now = now or datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        profile = await db["staff_profiles"].find_one_and_update(
+            {"staff_id": staff_id}, {"$set": {"last_seen_at": now}}, projection={"_id": 0}
+        )
+        if not profile:
+            raise PresenceError("Staff profile not found", 404)
+        await db["staff_sessions"].update_one(
+            {"staff_id": staff_id, "session_id": heartbeat.session_id},
+            {"$set": {"last_seen_at": now, "client": heartbeat.client}, "$setOnInsert": {"started_at": now}},
+            upsert=True
+        )
+    if not is_online(profile, now):
+        logger.info("staff_back_online", staff_id=staff_id, session_id=heartbeat.session_id)
+    return {**profile, "last_seen_at": now}
+
+async def end_session(staff_id: str, session_id: str) -> dict:
+    """Signs a session out; the member goes offline straight away unless another session is still live."""
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        result = await db["staff_sessions"].delete_one({"staff_id": staff_id, "session_id": session_id})
+        if not result.deleted_count:
+            raise PresenceError("Session not found", 404)
+        remaining = await db["staff_sessions"].find_one({"staff_id": staff_id}, sort=[("last_seen_at", -1)])
+        profile = await db["staff_profiles"].find_one_and_update(
+            {"staff_id": staff_id}, {"$set": {"last_seen_at": remaining["last_seen_at"] if remaining else None}},
+            projection={"_id": 0}, return_document=True
+        )
+    logger.info("staff_session_ended", staff_id=staff_id, session_id=session_id, other_sessions=bool(remaining))
+    return profile
+
+async def list_presence(department: Optional[str] = None, now: Optional[datetime] = None) -> List[dict]:
+    now = now or datetime.now(timezone.utc)
+    query = {"role": "staff"}
+    if department:
+        query["department"] = department
+    async with DatabaseConnection.get_connection() as conn:
+        members = await conn["virtualbutler"]["staff_profiles"].find(query, {"_id": 0}).sort("staff_id", 1).to_list(length=None)
+    return [presence_view(member, now) for member in members]
+
+async def offline_staff(now: datetime, minutes: int = STAFF_REASSIGN_AFTER_MINUTES) -> List[str]:
+    """Staff not heard from in `minutes` (or signed out); those who never sent a heartbeat aren't included."""
+    async with DatabaseConnection.get_connection() as conn:
+        cursor = conn["virtualbutler"]["staff_profiles"].find(
+            {"last_seen_at": {"$exists": True},
+             "$or": [{"last_seen_at": None}, {"last_seen_at": {"$lt": now - timedelta(minutes=minutes)}}]},
+            {"staff_id": 1}
+        )
+        return [doc["staff_id"] async for doc in cursor]
+
+async def ensure_presence_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        sessions = conn["virtualbutler"]["staff_sessions"]
+        await sessions.create_index([("staff_id", 1), ("session_id", 1)], unique=True)
+        await sessions.create_index([("last_seen_at", 1)], expireAfterSeconds=SESSION_TTL_SECONDS,
+                                    name="ttl_last_seen_at")
+        await conn["virtualbutler"]["staff_profiles"].create_index("last_seen_at", sparse=True)
@@ -0,0 +1,195 @@
+"""
+Preventive maintenance. Recurring tasks are defined per asset or room ("replace the AC filter every
+90 days"); the scheduler turns each one into a maintenance work order when it comes due, and
+completing that order records the completion and moves the next due date forward from it.
+
+A schedule only ever has one open work order, so a task that is overdue doesn't pile up duplicates.
+While a replica generates it the schedule holds a "generating" placeholder; one left behind by a replica
+that died mid-way is taken over after PM_CLAIM_TIMEOUT_SECONDS, so the task isn't stuck for good.
+"""
+import os
+import uuid
+from datetime import datetime, timedelta, timezone
+from typing import List, Optional
+
+import structlog
+from pymongo import ReturnDocument
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import MaintenanceSchedule
+
+logger = structlog.get_logger()
+
+PM_COMPLETIONS_KEPT = int(os.getenv("PM_COMPLETIONS_KEPT", "50"))
+PM_DUE_SOON_DAYS = int(os.getenv("PM_DUE_SOON_DAYS", "7"))
+PM_CLAIM_TIMEOUT_SECONDS = int(os.getenv("PM_CLAIM_TIMEOUT_SECONDS", "600"))
+GENERATING = "generating"
+# Generated orders have no guest behind them
+PM_GUEST_ID = "system:preventive_maintenance"
+
+class MaintenanceScheduleError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+def next_due_after(completed_at: datetime, interval_days: int) -> datetime:
+    """Intervals run from the actual completion, so a late filter change isn't followed by an early one."""
+    return completed_at + timedelta(days=interval_days)
+
+def claim_expired(schedule: dict, now: datetime, timeout_seconds: int = PM_CLAIM_TIMEOUT_SECONDS) -> bool:
+    """A "generating" placeholder whose replica never replaced it; claims from before claimed_at count too."""
+    if schedule.get("open_work_order_id") != GENERATING:
+        return False
+    claimed_at = schedule.get("claimed_at")
+    return claimed_at is None or _aware(claimed_at) + timedelta(seconds=timeout_seconds) <= now
+
+def generation_due(schedule: dict, now: datetime) -> bool:
+    return (schedule.get("active", True) and (not schedule.get("open_work_order_id") or claim_expired(schedule, now))
+            and schedule["next_due_at"] - timedelta(days=schedule.get("lead_days", 0)) <= now)
+
+def days_overdue(schedule: dict, now: datetime) -> int:
+    return max((now - schedule["next_due_at"]).days, 0)
+
+def _aware(value: datetime) -> datetime:
+    return value if value.tzinfo else value.replace(tzinfo=timezone.utc)
+
+async def create_schedule(task: str, interval_days: int, asset_id: Optional[str] = None,
+                          room_number: Optional[str] = None, lead_days: int = 0, priority: str = "low",
+                          first_due_at: Optional[datetime] = None) -> MaintenanceSchedule:
+    if not asset_id and not room_number:
+        raise MaintenanceScheduleError("A schedule needs an asset_id or a room_number", 422)
+    now = datetime.now(timezone.utc)
+    schedule = MaintenanceSchedule(
+        schedule_id=f"pm_{uuid.uuid4().hex[:12]}",
+        asset_id=asset_id,
+        room_number=room_number,
+        task=task,
+        interval_days=interval_days,
+        lead_days=lead_days,
+        priority=priority,
+        next_due_at=first_due_at.astimezone(timezone.utc) if first_due_at else next_due_after(now, interval_days),
+        created_at=now,
+        updated_at=now
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["maintenance_schedules"].insert_one(schedule.model_dump(exclude={"id"}))
+    logger.info("pm_schedule_created", schedule_id=schedule.schedule_id, asset_id=asset_id,
+                room_number=room_number, interval_days=interval_days)
+    return schedule
+
+async def get_schedule(schedule_id: str) -> dict:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["maintenance_schedules"].find_one({"schedule_id": schedule_id})
+    if not doc:
+        raise MaintenanceScheduleError("Maintenance schedule not found", 404)
+    return doc
+
+async def list_schedules(asset_id: Optional[str] = None, room_number: Optional[str] = None,
+                         active_only: bool = True) -> List[MaintenanceSchedule]:
+    query = {k: v for k, v in {"asset_id": asset_id, "room_number": room_number}.items() if v}
+    if active_only:
+        query["active"] = True
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["maintenance_schedules"].find(query).sort("next_due_at", 1).to_list(length=1000)
+    return [MaintenanceSchedule(**doc) for doc in docs]
+
+async def update_schedule(schedule_id: str, changes: dict) -> dict:
+    if "next_due_at" in changes:
+        changes["next_due_at"] = changes["next_due_at"].astimezone(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["maintenance_schedules"].find_one_and_update(
+            {"schedule_id": schedule_id},
+            {"$set": {**changes, "updated_at": datetime.now(timezone.utc)}},
+            return_document=ReturnDocument.AFTER
+        )
+    if not doc:
+        raise MaintenanceScheduleError("Maintenance schedule not found", 404)
+    return doc
+
+async def delete_schedule(schedule_id: str) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["maintenance_schedules"].delete_one({"schedule_id": schedule_id})
+    if not result.deleted_count:
+        raise MaintenanceScheduleError("Maintenance schedule not found", 404)
+
+async def claim_due_schedule(now: datetime) -> Optional[dict]:
+    """
+    Reserves one schedule that needs a work order. The placeholder is replaced by the real
+    work-order ID once it exists, and keeps other replicas from generating a second one meanwhile.
+    """
+    async with DatabaseConnection.get_connection() as conn:
+        candidates = conn["virtualbutler"]["maintenance_schedules"].find(
+            {"active": True, "open_work_order_id": {"$in": [None, GENERATING]},
+             "next_due_at": {"$lte": now + timedelta(days=90)}}
+        ).sort("next_due_at", 1)
+        async for schedule in candidates:
+            if not generation_due({**schedule, "next_due_at": _aware(schedule["next_due_at"])}, now):
+                continue
+            # Matching the claim we read means two replicas can't both take over the same stale one
+            claimed = await conn["virtualbutler"]["maintenance_schedules"].find_one_and_update(
+                {"schedule_id": schedule["schedule_id"], "open_work_order_id": schedule.get("open_work_order_id"),
+                 "claimed_at": schedule.get("claimed_at")},
+                {"$set": {"open_work_order_id": GENERATING, "claimed_at": now, "updated_at": now}},
+                return_document=ReturnDocument.AFTER
+            )
+            if claimed:
+                if schedule.get("open_work_order_id") == GENERATING:
+                    logger.warning("pm_stale_claim_taken_over", schedule_id=claimed["schedule_id"],
+                                   claimed_at=schedule.get("claimed_at"))
+                return claimed
+    return None
+
+async def set_open_work_order(schedule_id: str, work_order_id: Optional[str]) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["maintenance_schedules"].update_one(
+            {"schedule_id": schedule_id},
+            {"$set": {"open_work_order_id": work_order_id, "claimed_at": None, "updated_at": datetime.now(timezone.utc)}}
+        )
+
+async def release_schedule(schedule_id: str, work_order_id: str) -> None:
+    """Frees the slot after a cancelled order, so the still-due task is generated again."""
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["maintenance_schedules"].update_one(
+            {"schedule_id": schedule_id, "open_work_order_id": work_order_id},
+            {"$set": {"open_work_order_id": None, "updated_at": datetime.now(timezone.utc)}}
+        )
+
+async def record_completion(schedule_id: str, work_order_id: str, completed_at: datetime,
+                            by: Optional[str] = None) -> Optional[dict]:
+    """Called when a generated order completes; the next due date counts from this completion."""
+    schedule = await get_schedule(schedule_id)
+    completion = {"work_order_id": work_order_id, "completed_at": completed_at, "by": by}
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["maintenance_schedules"].find_one_and_update(
+            {"schedule_id": schedule_id, "open_work_order_id": work_order_id},
+            {"$set": {"last_completed_at": completed_at, "open_work_order_id": None,
+                      "next_due_at": next_due_after(completed_at, schedule["interval_days"]),
+                      "updated_at": datetime.now(timezone.utc)},
+             "$push": {"completions": {"$each": [completion], "$slice": -PM_COMPLETIONS_KEPT}}},
+            return_document=ReturnDocument.AFTER
+        )
+    if doc:
+        logger.info("pm_completed", schedule_id=schedule_id, work_order_id=work_order_id,
+                    next_due_at=doc["next_due_at"].isoformat())
+    return doc
+
+async def pm_status(now: datetime) -> dict:
+    """Overdue and soon-due items for the engineering dashboard, most overdue first."""
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["maintenance_schedules"].find(
+            {"active": True, "next_due_at": {"$lte": now + timedelta(days=PM_DUE_SOON_DAYS)}}
+        ).sort("next_due_at", 1).to_list(length=1000)
+    overdue, due_soon = [], []
+    for doc in docs:
+        doc["next_due_at"] = _aware(doc["next_due_at"])
+        item = {**MaintenanceSchedule(**doc).model_dump(mode="json", exclude={"id", "completions"}),
+                "days_overdue": days_overdue(doc, now)}
+        (overdue if doc["next_due_at"] < now else due_soon).append(item)
+    return {"overdue": overdue, "due_soon": due_soon, "as_of": now}
+
+async def ensure_pm_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["maintenance_schedules"]
+        await coll.create_index("schedule_id", unique=True)
+        await coll.create_index([("active", 1), ("next_due_at", 1)])
+        await coll.create_index("asset_id", sparse=True)
@@ -0,0 +1,238 @@
+"""
+Upsell offers made in chat: a wine pairing with a room service order, late checkout after a spa
+booking. Each promotion is a rule, so hotels configure them without a release.
+
+A promotion fires on a trigger (room_service, spa_booking, restaurant_booking) and is offered when:
+- it's active and within its valid_from/valid_until window,
+- the hotel is in `hotel_ids` (empty means every hotel),
+- the guest is in one of its `segments` (empty means everyone; see guest_segments()),
+- the guest hasn't been offered it in the last PROMOTION_COOLDOWN_HOURS.
+At most one offer is made per trigger, the highest `priority` first, so a guest isn't buried in them.
+
+Every offer is recorded in `promotion_offers`. Accepting one raises a request for the promotion's
+department (the wine goes up, front desk extends the checkout); declines are recorded too, so the
+report shows offers, acceptances and conversion per promotion.
+"""
+import asyncio
+import os
+import uuid
+from datetime import datetime, timedelta, timezone
+from enum import Enum
+from typing import Dict, List, Optional, Set
+
+import structlog
+from pydantic import BaseModel, Field
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import DepartmentEnum, PriorityEnum
+
+logger = structlog.get_logger()
+
+HOTEL_ID = os.getenv("HOTEL_ID", "default")
+PROMOTION_COOLDOWN_HOURS = int(os.getenv("PROMOTION_COOLDOWN_HOURS", "24"))
+PROMOTION_TAG = "promotion"
+
+class PromotionTriggerEnum(str, Enum):
+    ROOM_SERVICE = "room_service"
+    SPA_BOOKING = "spa_booking"
+    RESTAURANT_BOOKING = "restaurant_booking"
+
+class OfferStatusEnum(str, Enum):
+    OFFERED = "offered"
+    ACCEPTED = "accepted"
+    DECLINED = "declined"
+
+class PromotionError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+class PromotionUpdate(BaseModel):
+    name: str = Field(..., min_length=1, max_length=80)
+    trigger: PromotionTriggerEnum
+    message: str = Field(..., min_length=1, max_length=300, description="The offer as the guest reads it")
+    messages: Dict[str, str] = Field(default_factory=dict, description="Offer by language code")
+    department: DepartmentEnum = Field(..., description="Who fulfils it when accepted")
+    fulfilment: str = Field(..., min_length=1, max_length=500, description="Work order description on acceptance")
+    price: Optional[float] = Field(None, ge=0)
+    hotel_ids: List[str] = Field(default_factory=list)
+    segments: List[str] = Field(default_factory=list)
+    priority: int = 0
+    valid_from: Optional[datetime] = None
+    valid_until: Optional[datetime] = None
+    active: bool = True
+
+class Promotion(PromotionUpdate):
+    promotion_id: str = Field(..., pattern=r"^[a-z0-9][a-z0-9_-]{0,39}$")
+    updated_by: Optional[str] = None
+    updated_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+
+def guest_segments(profile: Optional[dict]) -> Set[str]:
+    """vip or standard, plus any segments the PMS sync put in the profile's preferences."""
+    profile = profile or {}
+    segments = {"vip" if profile.get("vip_status") else "standard"}
+    segments.update((profile.get("preferences") or {}).get("segments") or [])
+    return segments
+
+def _aware(value: Optional[datetime]) -> Optional[datetime]:
+    return value.replace(tzinfo=timezone.utc) if value and value.tzinfo is None else value
+
+def eligible(promotion: Promotion, trigger: str, hotel_id: str, segments: Set[str], now: datetime) -> bool:
+    if not promotion.active or promotion.trigger != trigger:
+        return False
+    if promotion.hotel_ids and hotel_id not in promotion.hotel_ids:
+        return False
+    if promotion.segments and not segments.intersection(promotion.segments):
+        return False
+    if promotion.valid_from and now < _aware(promotion.valid_from):
+        return False
+    return not (promotion.valid_until and now >= _aware(promotion.valid_until))
+
+def choose(promotions: List[Promotion], trigger: str, hotel_id: str, segments: Set[str], now: datetime,
+           recently_offered: Set[str] = frozenset()) -> Optional[Promotion]:
+    candidates = [p for p in promotions if p.promotion_id not in recently_offered
+                  and eligible(p, trigger, hotel_id, segments, now)]
+    return max(candidates, key=lambda p: (p.priority, p.promotion_id), default=None)
+
+def offer_text(promotion: Promotion, language: str) -> str:
+    return promotion.messages.get(language) or promotion.message
+
+def conversion(offered: int, accepted: int) -> Optional[float]:
+    return round(accepted / offered, 3) if offered else None
+
+# --- Promotions in use ---
+
+class Promotions:
+    """In-memory copy of the promotions; call refresh() (or run refresh_loop()) to pick up admin changes."""
+
+    def __init__(self, refresh_interval: int = 60):
+        self.refresh_interval = refresh_interval
+        self.promotions: List[Promotion] = []
+
+    async def refresh(self) -> None:
+        self.promotions = await list_promotions()
+
+    async def refresh_loop(self) -> None:
+        while True:
+            try:
+                await self.refresh()
+            except Exception as e:
+                logger.error("promotions_refresh_failed", error=str(e))
+            await asyncio.sleep(self.refresh_interval)
+
+    async def offer(self, trigger: str, guest_id: str, profile: Optional[dict], language: str,
+                    request_id: Optional[str] = None, hotel_id: str = HOTEL_ID) -> Optional[dict]:
+        """Records and returns the offer to make for this trigger, if any."""
+        now = datetime.now(timezone.utc)
+        async with DatabaseConnection.get_connection() as conn:
+            offers = conn["virtualbutler"]["promotion_offers"]
+            recent = await offers.distinct("promotion_id", {
+                "guest_id": guest_id, "offered_at": {"$gte": now - timedelta(hours=PROMOTION_COOLDOWN_HOURS)}
+            })
+            promotion = choose(self.promotions, trigger, hotel_id, guest_segments(profile), now, set(recent))
+            if promotion is None:
+                return None
+            offer = {"offer_id": f"off_{uuid.uuid4().hex[:12]}", "promotion_id": promotion.promotion_id,
+                     "name": promotion.name, "trigger": trigger, "guest_id": guest_id, "hotel_id": hotel_id,
+                     "request_id": request_id, "message": offer_text(promotion, language), "price": promotion.price,
+                     "language": language, "status": OfferStatusEnum.OFFERED.value, "offered_at": now}
+            await offers.insert_one(dict(offer))
+        logger.info("promotion_offered", offer_id=offer["offer_id"], promotion_id=promotion.promotion_id,
+                    guest_id=guest_id, trigger=trigger)
+        return offer
+
+promotions = Promotions()
+
+# --- Offers ---
+
+def offer_view(offer: dict) -> dict:
+    return {"offer_id": offer["offer_id"], "message": offer["message"], "price": offer.get("price")}
+
+async def respond_to_offer(offer_id: str, guest_id: str, accepted: bool) -> dict:
+    """Records the guest's answer; each offer can be answered once."""
+    status = OfferStatusEnum.ACCEPTED if accepted else OfferStatusEnum.DECLINED
+    async with DatabaseConnection.get_connection() as conn:
+        offers = conn["virtualbutler"]["promotion_offers"]
+        result = await offers.update_one(
+            {"offer_id": offer_id, "guest_id": guest_id, "status": OfferStatusEnum.OFFERED.value},
+            {"$set": {"status": status.value, "responded_at": datetime.now(timezone.utc)}}
+        )
+        offer = await offers.find_one({"offer_id": offer_id, "guest_id": guest_id}, {"_id": 0})
+    if not offer:
+        raise PromotionError("Offer not found", 404)
+    if not result.modified_count:
+        raise PromotionError(f"Offer was already {offer['status']}", 409)
+    logger.info("promotion_answered", offer_id=offer_id, promotion_id=offer["promotion_id"], status=status.value)
+    return offer
+
+def fulfilment_request(promotion: Promotion) -> dict:
+    """What the accepted offer's work order needs."""
+    return {"department": promotion.department, "priority": PriorityEnum.MEDIUM,
+            "description": promotion.fulfilment, "tags": [PROMOTION_TAG, f"{PROMOTION_TAG}:{promotion.promotion_id}"]}
+
+async def offer_report(since: datetime, until: datetime, hotel_id: Optional[str] = None) -> List[dict]:
+    """Offers, acceptances, declines and conversion per promotion for offers made in [since, until)."""
+    match = {"offered_at": {"$gte": since, "$lt": until}}
+    if hotel_id:
+        match["hotel_id"] = hotel_id
+    pipeline = [
+        {"$match": match},
+        {"$group": {"_id": "$promotion_id", "name": {"$last": "$name"}, "offered": {"$sum": 1},
+                    "accepted": {"$sum": {"$cond": [{"$eq": ["$status", OfferStatusEnum.ACCEPTED.value]}, 1, 0]}},
+                    "declined": {"$sum": {"$cond": [{"$eq": ["$status", OfferStatusEnum.DECLINED.value]}, 1, 0]}},
+                    "revenue": {"$sum": {"$cond": [{"$eq": ["$status", OfferStatusEnum.ACCEPTED.value]},
+                                                   {"$ifNull": ["$price", 0]}, 0]}}}},
+        {"$sort": {"_id": 1}},
+    ]
+    async with DatabaseConnection.get_connection() as conn:
+        rows = await conn["virtualbutler"]["promotion_offers"].aggregate(pipeline).to_list(length=None)
+    return [{"promotion_id": row["_id"], "name": row["name"], "offered": row["offered"], "accepted": row["accepted"],
+             "declined": row["declined"], "conversion_rate": conversion(row["offered"], row["accepted"]),
+             "revenue": round(row["revenue"], 2)} for row in rows]
+
+# --- Promotion administration ---
+
+async def list_promotions(include_inactive: bool = False) -> List[Promotion]:
+    query = {} if include_inactive else {"active": True}
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["promotions"].find(query, {"_id": 0}).sort("promotion_id", 1).to_list(length=None)
+    return [Promotion(**doc) for doc in docs]
+
+async def get_promotion(promotion_id: str) -> Promotion:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["promotions"].find_one({"promotion_id": promotion_id}, {"_id": 0})
+    if not doc:
+        raise PromotionError("Promotion not found", 404)
+    return Promotion(**doc)
+
+async def save_promotion(promotion: Promotion) -> Promotion:
+    if promotion.valid_from and promotion.valid_until and _aware(promotion.valid_until) <= _aware(promotion.valid_from):
+        raise PromotionError("valid_until must be after valid_from", 422)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["promotions"].replace_one(
+            {"promotion_id": promotion.promotion_id},
+            promotion.model_dump(mode="json") | {"updated_at": promotion.updated_at,
+                                                 "valid_from": promotion.valid_from,
+                                                 "valid_until": promotion.valid_until},
+            upsert=True
+        )
+    await promotions.refresh()
+    logger.info("promotion_saved", promotion_id=promotion.promotion_id, trigger=promotion.trigger.value,
+                active=promotion.active)
+    return promotion
+
+async def delete_promotion(promotion_id: str) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["promotions"].delete_one({"promotion_id": promotion_id})
+    if not result.deleted_count:
+        raise PromotionError("Promotion not found", 404)
+    await promotions.refresh()
+    logger.info("promotion_deleted", promotion_id=promotion_id)
+
+async def ensure_promotion_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        await db["promotions"].create_index("promotion_id", unique=True)
+        await db["promotion_offers"].create_index("offer_id", unique=True)
+        await db["promotion_offers"].create_index([("guest_id", 1), ("offered_at", -1)])
+        await db["promotion_offers"].create_index([("offered_at", 1), ("promotion_id", 1)])
@@ -0,0 +1,94 @@
+"""
+Quick actions: one-tap requests ("Extra towels", "Late checkout", "Iron & board") configured per hotel.
+
+Each action carries everything the work order needs (department, priority, description, tags), so
+tapping one skips free-text classification entirely. Labels are per language, falling back to the
+default label. Disabled actions stay configured but aren't offered to guests.
+"""
+import os
+from datetime import datetime, timezone
+from typing import Dict, List, Optional
+
+import structlog
+from pydantic import BaseModel, Field
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import DepartmentEnum, PriorityEnum
+
+logger = structlog.get_logger()
+
+HOTEL_ID = os.getenv("HOTEL_ID", "default")
+QUICK_ACTION_TAG = "quick_action"
+
+class QuickActionError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+class QuickActionUpdate(BaseModel):
+    label: str = Field(..., min_length=1, max_length=60)
+    labels: Dict[str, str] = Field(default_factory=dict, description="Label by language code")
+    department: DepartmentEnum
+    priority: PriorityEnum = PriorityEnum.MEDIUM
+    description: str = Field(..., min_length=1, max_length=500, description="Work order description")
+    tags: List[str] = Field(default_factory=list)
+    icon: Optional[str] = Field(None, max_length=40)
+    sort_order: int = 0
+    enabled: bool = True
+
+class QuickAction(QuickActionUpdate):
+    action_id: str = Field(..., pattern=r"^[a-z0-9][a-z0-9_-]{0,39}$")
+    hotel_id: str = HOTEL_ID
+    updated_by: Optional[str] = None
+    updated_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+
+def label_for(action: QuickAction, language: str) -> str:
+    return action.labels.get(language) or action.label
+
+def guest_view(action: QuickAction, language: str) -> dict:
+    """What the guest app needs to render the button."""
+    return {"action_id": action.action_id, "label": label_for(action, language),
+            "department": action.department.value, "icon": action.icon}
+
+def work_order_tags(action: QuickAction) -> List[str]:
+    return [QUICK_ACTION_TAG, f"{QUICK_ACTION_TAG}:{action.action_id}",
+            *[t for t in action.tags if t != QUICK_ACTION_TAG]]
+
+async def list_quick_actions(hotel_id: str = HOTEL_ID, include_disabled: bool = False) -> List[QuickAction]:
+    query = {"hotel_id": hotel_id}
+    if not include_disabled:
+        query["enabled"] = True
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["quick_actions"].find(query, {"_id": 0}).sort(
+            [("sort_order", 1), ("action_id", 1)]
+        ).to_list(length=None)
+    return [QuickAction(**doc) for doc in docs]
+
+async def get_quick_action(action_id: str, hotel_id: str = HOTEL_ID) -> QuickAction:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["quick_actions"].find_one({"hotel_id": hotel_id, "action_id": action_id},
+                                                                    {"_id": 0})
+    if not doc or not doc.get("enabled", True):
+        raise QuickActionError("Quick action not found", 404)
+    return QuickAction(**doc)
+
+async def save_quick_action(action: QuickAction) -> QuickAction:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["quick_actions"].replace_one(
+            {"hotel_id": action.hotel_id, "action_id": action.action_id},
+            action.model_dump(mode="json") | {"updated_at": action.updated_at}, upsert=True
+        )
+    logger.info("quick_action_saved", hotel_id=action.hotel_id, action_id=action.action_id,
+                department=action.department.value, enabled=action.enabled)
+    return action
+
+async def delete_quick_action(action_id: str, hotel_id: str = HOTEL_ID) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["quick_actions"].delete_one({"hotel_id": hotel_id, "action_id": action_id})
+    if not result.deleted_count:
+        raise QuickActionError("Quick action not found", 404)
+    logger.info("quick_action_deleted", hotel_id=hotel_id, action_id=action_id)
+
+async def ensure_quick_action_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["quick_actions"].create_index([("hotel_id", 1), ("action_id", 1)], unique=True)
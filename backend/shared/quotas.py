@@ -0,0 +1,29 @@
+import os
+from typing import Tuple
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import StatusEnum
+
+GUEST_OPEN_ORDER_LIMIT = int(os.getenv("GUEST_OPEN_ORDER_LIMIT", "10"))
+OPEN_STATUSES = [StatusEnum.PENDING, StatusEnum.ASSIGNED, StatusEnum.IN_PROGRESS, StatusEnum.ON_HOLD]
+
+async def guest_open_order_limit(guest_id: str) -> int:
+    """Per-guest override set by admins (guest_profiles.open_order_limit), else the global cap. 0 = unlimited."""
+    async with DatabaseConnection.get_connection() as conn:
+        guest = await conn["virtualbutler"]["guest_profiles"].find_one({"guest_id": guest_id}, {"open_order_limit": 1})
+    override = (guest or {}).get("open_order_limit")
+    return GUEST_OPEN_ORDER_LIMIT if override is None else override
+
+async def open_order_count(guest_id: str) -> int:
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["work_orders"].count_documents(
+            {"guest_id": guest_id, "parent_id": None, "status": {"$in": OPEN_STATUSES}}
+        )
+
+async def check_open_order_quota(guest_id: str) -> Tuple[bool, int, int]:
+    """Returns (allowed, open_count, limit)."""
+    limit = await guest_open_order_limit(guest_id)
+    if limit <= 0:
+        return True, 0, limit
+    count = await open_order_count(guest_id)
+    return count < limit, count, limit
@@ -0,0 +1,235 @@
+"""
+Read models for the dashboards (CQRS): small denormalized views kept current from domain events, so
+dashboard requests read a few documents instead of aggregating `work_orders`.
+
+- `rm_department_stats`: per department, open orders by status and running totals (created,
+  completed, cancelled, SLA breaches).
+- `rm_sla_timers`: one document per open order with its SLA deadline (shared.reporting targets,
+  counted in the department's working hours).
+- `rm_leaderboard`: orders completed per member of staff, department and day.
+
+Only top-level orders count; subtasks are staff bookkeeping. Each replica projects the events it
+publishes (every event is published by exactly one replica, so together they cover everything), and
+an event is applied at most once: its ID is kept in `rm_applied_events` for READ_MODEL_EVENT_TTL_DAYS.
+
+The in-process bus drops events for a subscriber that falls behind, so the views can drift.
+POST /api/v1/admin/read-models/rebuild recomputes them from scratch by replaying events synthesized
+from `work_orders` through the same handlers. Run it when traffic is low; orders that change while it
+runs may be counted twice until the next rebuild.
+"""
+import os
+from datetime import datetime, timezone
+from typing import Dict, List, Optional
+
+import structlog
+from pymongo.errors import DuplicateKeyError
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import StatusEnum
+from shared.reporting import sla_due_at
+
+logger = structlog.get_logger()
+
+READ_MODEL_EVENT_TTL_DAYS = int(os.getenv("READ_MODEL_EVENT_TTL_DAYS", "7"))
+READ_MODEL_QUEUE_SIZE = int(os.getenv("READ_MODEL_QUEUE_SIZE", "1000"))
+CLOSED_STATUSES = {StatusEnum.COMPLETED.value, StatusEnum.CANCELLED.value}
+READ_MODEL_COLLECTIONS = ("rm_department_stats", "rm_sla_timers", "rm_leaderboard")
+
+def _at(value) -> datetime:
+    moment = datetime.fromisoformat(value) if isinstance(value, str) else value
+    return moment.replace(tzinfo=timezone.utc) if moment.tzinfo is None else moment
+
+def minutes_remaining(due_at: datetime, now: datetime) -> int:
+    """Negative once the order is past its target."""
+    return int((_at(due_at) - now).total_seconds() // 60)
+
+# --- Handlers: one per event type, shared by live projection and rebuilds ---
+
+async def _count(db, department: str, inc: Dict[str, int], at: datetime) -> None:
+    await db["rm_department_stats"].update_one(
+        {"_id": department}, {"$inc": inc, "$set": {"updated_at": at}}, upsert=True
+    )
+
+async def on_created(db, data: dict, at: datetime) -> None:
+    if data.get("parent_id"):
+        return
+    status = data["status"]
+    if status in CLOSED_STATUSES:
+        await _count(db, data["department"], {"created": 1, status: 1}, at)
+        return
+    tier = data.get("loyalty_tier")
+    result = await db["rm_sla_timers"].update_one({"_id": data["work_order_id"]}, {"$setOnInsert": {
+        "department": data["department"], "priority": data["priority"], "status": status,
+        "room_number": data.get("room_number"), "assigned_staff": None, "created_at": at, "loyalty_tier": tier,
+        "due_at": await sla_due_at(data["department"], at, tier), "breached_at": None,
+    }}, upsert=True)
+    if result.upserted_id is not None:
+        await _count(db, data["department"], {"created": 1, f"open.{status}": 1}, at)
+
+async def on_status_changed(db, data: dict, at: datetime) -> None:
+    timer = await db["rm_sla_timers"].find_one({"_id": data["work_order_id"]})
+    if not timer:
+        # A subtask, or an order the views don't know about (caught up by the next rebuild)
+        return
+    status, department = data["status"], data["department"]
+    if timer["status"] == status and timer["department"] == department:
+        return
+    await _count(db, timer["department"], {f"open.{timer['status']}": -1}, at)
+    if status in CLOSED_STATUSES:
+        await db["rm_sla_timers"].delete_one({"_id": timer["_id"]})
+        await _count(db, department, {status: 1}, at)
+        if status == StatusEnum.COMPLETED.value and timer.get("assigned_staff"):
+            day = at.date().isoformat()
+            await db["rm_leaderboard"].update_one(
+                {"_id": f"{day}:{department}:{timer['assigned_staff']}"},
+                {"$inc": {"completed": 1},
+                 "$set": {"day": day, "department": department, "staff_id": timer["assigned_staff"]}},
+                upsert=True
+            )
+        return
+    changes = {"status": status, "department": department}
+    if department != timer["department"]:
+        # Re-routed orders restart the clock against the new department's target
+        changes["due_at"] = await sla_due_at(department, at, timer.get("loyalty_tier"))
+    await db["rm_sla_timers"].update_one({"_id": timer["_id"]}, {"$set": changes})
+    await _count(db, department, {f"open.{status}": 1}, at)
+
+async def on_assigned(db, data: dict, at: datetime) -> None:
+    await db["rm_sla_timers"].update_one({"_id": data["work_order_id"]},
+                                         {"$set": {"assigned_staff": data["assigned_staff"]}})
+
+async def on_sla_breached(db, data: dict, at: datetime) -> None:
+    result = await db["rm_sla_timers"].update_one({"_id": data["work_order_id"], "breached_at": None},
+                                                  {"$set": {"breached_at": at}})
+    if result.modified_count:
+        await _count(db, data["department"], {"sla_breaches": 1}, at)
+
+HANDLERS = {
+    "work_order.created": on_created,
+    "work_order.status_changed": on_status_changed,
+    "work_order.assigned": on_assigned,
+    "work_order.sla_breached": on_sla_breached,
+}
+
+async def apply_event(envelope: dict) -> bool:
+    """Projects one domain event; False if it isn't one the views use or was already applied."""
+    handler = HANDLERS.get(envelope.get("type"))
+    if handler is None:
+        return False
+    at = _at(envelope["occurred_at"])
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        try:
+            await db["rm_applied_events"].insert_one({"_id": envelope["id"], "applied_at": at})
+        except DuplicateKeyError:
+            return False
+        await handler(db, envelope["data"], at)
+    return True
+
+class ReadModelProjector:
+    """Feeds the events this replica publishes into the read models."""
+
+    def __init__(self, bus):
+        self.bus = bus
+
+    async def project_loop(self):
+        queue = self.bus.subscribe(maxsize=READ_MODEL_QUEUE_SIZE)
+        try:
+            while True:
+                envelope = await queue.get()
+                try:
+                    await apply_event(envelope)
+                except Exception as e:
+                    logger.error("read_model_projection_failed", event_id=envelope.get("id"),
+                                 event_type=envelope.get("type"), error=str(e))
+        finally:
+            self.bus.unsubscribe(queue)
+
+# --- Rebuild ---
+
+def synthesized_events(order: dict) -> List[tuple]:
+    """(type, data, occurred_at) in the order they would have been published for `order`."""
+    department = order["department"]
+    closed = order["status"] in CLOSED_STATUSES
+    events = [("work_order.created", {
+        "work_order_id": order["work_order_id"], "department": department, "priority": order.get("priority"),
+        "status": StatusEnum.PENDING.value if closed else order["status"],
+        "room_number": (order.get("metadata") or {}).get("room_number"), "parent_id": order.get("parent_id"),
+    }, order["created_at"])]
+    if order.get("assigned_staff"):
+        events.append(("work_order.assigned", {"work_order_id": order["work_order_id"],
+                                               "assigned_staff": order["assigned_staff"]},
+                       order.get("assigned_at") or order["created_at"]))
+    if order.get("sla_breached_at"):
+        events.append(("work_order.sla_breached", {"work_order_id": order["work_order_id"], "department": department},
+                       order["sla_breached_at"]))
+    if closed:
+        events.append(("work_order.status_changed", {"work_order_id": order["work_order_id"], "department": department,
+                                                     "status": order["status"]},
+                       order.get("completed_at") or order.get("updated_at") or order["created_at"]))
+    return events
+
+async def rebuild_read_models() -> dict:
+    rebuilt = 0
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        for name in READ_MODEL_COLLECTIONS:
+            await db[name].delete_many({})
+        async for order in db["work_orders"].find({"parent_id": None}).sort("created_at", 1):
+            for event_type, data, at in synthesized_events(order):
+                await HANDLERS[event_type](db, data, _at(at))
+            rebuilt += 1
+    logger.info("read_models_rebuilt", orders=rebuilt)
+    return {"orders": rebuilt}
+
+# --- Queries ---
+
+async def department_dashboard(now: datetime) -> List[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        stats = await db["rm_department_stats"].find({}).sort("_id", 1).to_list(length=None)
+        overdue = await db["rm_sla_timers"].aggregate([
+            {"$match": {"due_at": {"$lte": now}}},
+            {"$group": {"_id": "$department", "count": {"$sum": 1}}}
+        ]).to_list(length=None)
+    overdue_by = {d["_id"]: d["count"] for d in overdue}
+    return [{
+        "department": s["_id"],
+        "open": {k: v for k, v in (s.get("open") or {}).items() if v},
+        "open_total": sum((s.get("open") or {}).values()),
+        "overdue": overdue_by.get(s["_id"], 0),
+        "created": s.get("created", 0),
+        "completed": s.get("completed", 0),
+        "cancelled": s.get("cancelled", 0),
+        "sla_breaches": s.get("sla_breaches", 0),
+        "updated_at": s.get("updated_at"),
+    } for s in stats]
+
+async def sla_timers(now: datetime, department: Optional[str] = None, limit: int = 50) -> List[dict]:
+    """Open orders, soonest deadline first."""
+    query = {"department": department} if department else {}
+    async with DatabaseConnection.get_connection() as conn:
+        timers = await conn["virtualbutler"]["rm_sla_timers"].find(query).sort("due_at", 1).limit(limit).to_list(length=None)
+    return [{"work_order_id": t.pop("_id"), **t, "minutes_remaining": minutes_remaining(t["due_at"], now)}
+            for t in timers]
+
+async def leaderboard(start_day: str, end_day: str, department: Optional[str] = None, limit: int = 10) -> List[dict]:
+    match = {"day": {"$gte": start_day, "$lte": end_day}}
+    if department:
+        match["department"] = department
+    async with DatabaseConnection.get_connection() as conn:
+        rows = await conn["virtualbutler"]["rm_leaderboard"].aggregate([
+            {"$match": match},
+            {"$group": {"_id": "$staff_id", "completed": {"$sum": "$completed"}}},
+            {"$sort": {"completed": -1, "_id": 1}},
+            {"$limit": limit}
+        ]).to_list(length=None)
+    return [{"rank": i + 1, "staff_id": r["_id"], "completed": r["completed"]} for i, r in enumerate(rows)]
+
+async def ensure_read_model_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        await db["rm_sla_timers"].create_index([("department", 1), ("due_at", 1)])
+        await db["rm_sla_timers"].create_index("due_at")
+        await db["rm_leaderboard"].create_index([("day", 1), ("department", 1)])
+        await db["rm_applied_events"].create_index("applied_at", expireAfterSeconds=READ_MODEL_EVENT_TTL_DAYS * 86400)
@@ -0,0 +1,180 @@
+"""
+Local recommendations (restaurants, attractions, transport) curated by the hotel's admins.
+
+Questions like "where should I eat tonight?" are answered from this list rather than raised as
+work orders: places that are open at the time the guest means are ranked by distance from the
+hotel (or the guest's shared location) and curated priority, then returned as structured cards
+the chat client can render.
+"""
+import math
+import os
+import re
+from datetime import datetime, time, timedelta, timezone, tzinfo
+from typing import List, Optional
+
+import structlog
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import Recommendation, RecommendationCategoryEnum
+
+logger = structlog.get_logger()
+
+HOTEL_LATITUDE = float(os.getenv("HOTEL_LATITUDE")) if os.getenv("HOTEL_LATITUDE") else None
+HOTEL_LONGITUDE = float(os.getenv("HOTEL_LONGITUDE")) if os.getenv("HOTEL_LONGITUDE") else None
+RECOMMENDATION_LIMIT = int(os.getenv("RECOMMENDATION_LIMIT", "3"))
+
+WEEKDAYS = ("mon", "tue", "wed", "thu", "fri", "sat", "sun")
+
+ASKING_PATTERN = re.compile(
+    r"\b(where (should|can|could) (i|we)|how (do|can|could|should) (i|we) get|recommend(ations?)?"
+    r"|suggest(ions?)?|any (good|nice)|what('?s| is) (there|good)|what (should|can|could) (i|we)"
+    r"|places? to|ideas? for)\b"
+)
+CATEGORY_PATTERNS = [
+    (re.compile(r"\b(eat|food|restaurants?|dinner|lunch|brunch|breakfast|cafe|bars?|drinks?)\b"),
+     RecommendationCategoryEnum.RESTAURANT.value),
+    (re.compile(r"\b(get (to|around)|transport|metro|subway|train|bus|bikes?|airport)\b"),
+     RecommendationCategoryEnum.TRANSPORT.value),
+    (re.compile(r"\b(see|visit|do|sightseeing|attractions?|museums?|tours?|things)\b"),
+     RecommendationCategoryEnum.ATTRACTION.value),
+]
+
+class RecommendationError(Exception):
+    def __init__(self, message: str, status_code: int = 404):
+        super().__init__(message)
+        self.status_code = status_code
+
+def detect_recommendation(message: str) -> Optional[str]:
+    """Returns the category a guest is asking recommendations for, None otherwise."""
+    text = message.lower()
+    if not ASKING_PATTERN.search(text):
+        return None
+    for pattern, category in CATEGORY_PATTERNS:
+        if pattern.search(text):
+            return category
+    return None
+
+def _parse_range(value: str) -> tuple:
+    start, end = value.split("-")
+    return time.fromisoformat(start.strip()), time.fromisoformat(end.strip())
+
+def validate_opening_hours(opening_hours: dict) -> None:
+    for day, ranges in opening_hours.items():
+        if day not in WEEKDAYS:
+            raise ValueError(f"Unknown weekday '{day}'; use {list(WEEKDAYS)}")
+        for value in ranges:
+            try:
+                _parse_range(value)
+            except ValueError:
+                raise ValueError(f"Opening hours must look like 'HH:MM-HH:MM', got '{value}'")
+
+def open_until(rec: Recommendation, at: datetime) -> Optional[datetime]:
+    """
+    When the place closes if it is open at `at` (hotel-local, tz-aware), None if it is closed.
+    Ranges ending before they start run past midnight and count for the day they start on.
+    Only meaningful for places with opening hours; callers treat an empty schedule as always open.
+    """
+    for offset in (0, -1):
+        day = at.date() + timedelta(days=offset)
+        for value in rec.opening_hours.get(WEEKDAYS[day.weekday()], []):
+            start, end = _parse_range(value)
+            opens = datetime.combine(day, start, tzinfo=at.tzinfo)
+            closes = datetime.combine(day, end, tzinfo=at.tzinfo)
+            if closes <= opens:
+                closes += timedelta(days=1)
+            if opens <= at < closes:
+                return closes
+    return None
+
+def distance_km(lat1: float, lon1: float, lat2: float, lon2: float) -> float:
+    """Great-circle (haversine) distance."""
+    phi1, phi2 = math.radians(lat1), math.radians(lat2)
+    dphi = math.radians(lat2 - lat1)
+    dlambda = math.radians(lon2 - lon1)
+    a = math.sin(dphi / 2) ** 2 + math.cos(phi1) * math.cos(phi2) * math.sin(dlambda / 2) ** 2
+    return 6371.0 * 2 * math.asin(math.sqrt(a))
+
+def to_card(rec: Recommendation, distance: Optional[float], closes_at: Optional[datetime]) -> dict:
+    """The structured card the chat client renders; actions are only included when there's data for them."""
+    actions = []
+    if rec.latitude is not None and rec.longitude is not None:
+        actions.append({"type": "map", "label": "Directions",
+                        "url": f"https://www.google.com/maps/dir/?api=1&destination={rec.latitude},{rec.longitude}"})
+    if rec.phone:
+        actions.append({"type": "call", "label": "Call", "value": rec.phone})
+    if rec.url:
+        actions.append({"type": "link", "label": "Website", "url": rec.url})
+    return {
+        "type": "recommendation",
+        "id": rec.recommendation_id,
+        "title": rec.name,
+        "category": rec.category,
+        "description": rec.description,
+        "address": rec.address,
+        "image_url": rec.image_url,
+        "tags": rec.tags,
+        "price_level": rec.price_level,
+        "distance_km": round(distance, 1) if distance is not None else None,
+        "open_until": closes_at.strftime("%H:%M") if closes_at else None,
+        "actions": actions
+    }
+
+def rank(recs: List[Recommendation], at: datetime, latitude: Optional[float] = None,
+         longitude: Optional[float] = None, tag: Optional[str] = None, limit: int = RECOMMENDATION_LIMIT) -> List[dict]:
+    """Open places first by distance (rounded to 500 m so curated priority breaks near-ties), as cards."""
+    origin_lat = latitude if latitude is not None else HOTEL_LATITUDE
+    origin_lon = longitude if longitude is not None else HOTEL_LONGITUDE
+    candidates = []
+    for rec in recs:
+        if tag and tag not in rec.tags:
+            continue
+        closes_at = open_until(rec, at) if rec.opening_hours else None
+        if rec.opening_hours and closes_at is None:
+            continue
+        distance = None
+        if None not in (origin_lat, origin_lon, rec.latitude, rec.longitude):
+            distance = distance_km(origin_lat, origin_lon, rec.latitude, rec.longitude)
+        bucket = round(distance * 2) if distance is not None else math.inf
+        candidates.append(((bucket, -rec.priority, rec.name), to_card(rec, distance, closes_at)))
+    candidates.sort(key=lambda c: c[0])
+    return [card for _, card in candidates[:limit]]
+
+# --- Storage ---
+
+async def list_recommendations(category: Optional[str] = None, active_only: bool = True) -> List[Recommendation]:
+    query = {"active": True} if active_only else {}
+    if category:
+        query["category"] = category
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["recommendations"].find(query).to_list(length=500)
+    return [Recommendation(**doc) for doc in docs]
+
+async def recommend(category: str, at: datetime, tz: tzinfo, latitude: Optional[float] = None,
+                    longitude: Optional[float] = None, tag: Optional[str] = None,
+                    limit: int = RECOMMENDATION_LIMIT) -> List[dict]:
+    return rank(await list_recommendations(category), at.astimezone(tz), latitude, longitude, tag, limit)
+
+async def save_recommendation(rec: Recommendation) -> Recommendation:
+    validate_opening_hours(rec.opening_hours)
+    data = rec.model_dump(exclude={"id", "created_at"})
+    data["updated_at"] = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["recommendations"].update_one(
+            {"recommendation_id": rec.recommendation_id},
+            {"$set": data, "$setOnInsert": {"created_at": datetime.now(timezone.utc)}},
+            upsert=True
+        )
+    logger.info("recommendation_saved", recommendation_id=rec.recommendation_id, category=rec.category)
+    return rec
+
+async def delete_recommendation(recommendation_id: str) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["recommendations"].delete_one({"recommendation_id": recommendation_id})
+    if not result.deleted_count:
+        raise RecommendationError(f"Recommendation '{recommendation_id}' not found")
+    logger.info("recommendation_deleted", recommendation_id=recommendation_id)
+
+async def ensure_recommendation_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["recommendations"].create_index("recommendation_id", unique=True)
+        await conn["virtualbutler"]["recommendations"].create_index([("category", 1), ("active", 1)])
@@ -0,0 +1,120 @@
+import json
+import os
+from datetime import datetime, timedelta
+from typing import Dict, List, Optional
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import DepartmentEnum, StatusEnum
+from shared.business_hours import add_business_minutes, business_calendars, business_minutes_between
+from shared.loyalty import tier_sla_minutes
+from shared.runtime_config import runtime_config
+
+# Minutes from creation to completion before an order counts as an SLA breach.
+# Override with SLA_TARGET_MINUTES='{"maintenance": 240, "housekeeping": 45}', or at runtime through
+# `sla_target_minutes` in the runtime config (shared/runtime_config.py).
+DEFAULT_SLA_MINUTES = 60
+SLA_TARGET_MINUTES: Dict[str, int] = {
+    DepartmentEnum.HOUSEKEEPING.value: 45,
+    DepartmentEnum.MAINTENANCE.value: 240,
+    DepartmentEnum.ROOM_SERVICE.value: 45,
+    DepartmentEnum.SECURITY.value: 15,
+    **json.loads(os.getenv("SLA_TARGET_MINUTES", "{}"))
+}
+
+def sla_minutes(department: str, loyalty_tier: Optional[str] = None) -> int:
+    target = runtime_config.sla_minutes(department) or SLA_TARGET_MINUTES.get(department, DEFAULT_SLA_MINUTES)
+    return tier_sla_minutes(target, loyalty_tier)
+
+async def sla_due_at(department: str, start: datetime, loyalty_tier: Optional[str] = None) -> datetime:
+    """When an order started at `start` breaches, counting the department's working hours only."""
+    calendar = await business_calendars.get(department)
+    target = sla_minutes(department, loyalty_tier)
+    return add_business_minutes(calendar, start, target) or start + timedelta(minutes=target)
+
+def survey_ratings(surveys: List[dict]) -> Dict[str, float]:
+    """Average survey score per department, from answered surveys."""
+    scores: Dict[str, List[int]] = {}
+    for survey in surveys:
+        for department in survey.get("departments", []):
+            scores.setdefault(department, []).append((survey.get("department_scores") or {}).get(department, survey["score"]))
+    return {department: round(sum(s) / len(s), 2) for department, s in scores.items()}
+
+async def department_summaries(start: datetime, end: datetime, now: datetime,
+                               departments: Optional[List[str]] = None) -> List[dict]:
+    """
+    Per-department figures for orders created in [start, end) (naive UTC):
+    completed, pending (still open at `now`), SLA breaches (completed late, or open past the target,
+    in working minutes where the department has a calendar) and the average checkout survey score (0-10)
+    from surveys answered in the window: the guest's score for the team, else their overall score, for
+    stays in which the team handled a request (as shared.surveys counts NPS).
+    """
+    match = {"created_at": {"$gte": start, "$lt": end}}
+    if departments:
+        match["department"] = {"$in": departments}
+    open_statuses = [StatusEnum.PENDING, StatusEnum.ASSIGNED, StatusEnum.IN_PROGRESS, StatusEnum.ON_HOLD]
+    pipeline = [
+        {"$match": match},
+        {"$project": {
+            "department": 1,
+            "status": 1,
+            "created_at": 1,
+            "elapsed_minutes": {"$divide": [
+                {"$subtract": [{"$ifNull": ["$completed_at", now]}, "$created_at"]}, 60000
+            ]}
+        }},
+        {"$group": {
+            "_id": "$department",
+            "total": {"$sum": 1},
+            "completed": {"$sum": {"$cond": [{"$eq": ["$status", StatusEnum.COMPLETED.value]}, 1, 0]}},
+            "pending": {"$sum": {"$cond": [{"$in": ["$status", [s.value for s in open_statuses]]}, 1, 0]}},
+            "elapsed": {"$push": {"status": "$status", "minutes": "$elapsed_minutes", "created_at": "$created_at",
+                                  "completed_at": "$completed_at"}}
+        }},
+        {"$sort": {"_id": 1}}
+    ]
+    async with DatabaseConnection.get_connection() as conn:
+        groups = await conn["virtualbutler"]["work_orders"].aggregate(pipeline).to_list(length=None)
+        surveys = await conn["virtualbutler"]["nps_surveys"].find(
+            {"answered_at": {"$gte": start, "$lt": end}, "score": {"$ne": None}},
+            {"_id": 0, "score": 1, "department_scores": 1, "departments": 1}
+        ).to_list(length=None)
+    ratings = survey_ratings(surveys)
+    summaries = []
+    for group in groups:
+        target = sla_minutes(group["_id"])
+        calendar = await business_calendars.get(group["_id"])
+        if calendar:
+            for e in group["elapsed"]:
+                e["minutes"] = business_minutes_between(calendar, e["created_at"], e.get("completed_at") or now)
+        breaches = sum(
+            1 for e in group["elapsed"]
+            if e["status"] != StatusEnum.CANCELLED.value and (e["minutes"] or 0) > target
+        )
+        summaries.append({
+            "department": group["_id"],
+            "total": group["total"],
+            "completed": group["completed"],
+            "pending": group["pending"],
+            "sla_target_minutes": target,
+            "sla_breaches": breaches,
+            "average_rating": ratings.get(group["_id"])
+        })
+    return summaries
+
+def format_department_digest(summaries: List[dict], report_date: str) -> tuple:
+    """Returns (subject, plain-text body) for the morning digest email."""
+    subject = f"Virtual Butler daily summary - {report_date}"
+    if not summaries:
+        return subject, f"No work orders were created on {report_date}."
+    lines = [f"Work orders created on {report_date}", ""]
+    for s in summaries:
+        rating = f"{s['average_rating']:.2f} / 10" if s["average_rating"] is not None else "n/a"
+        lines += [
+            s["department"].replace("_", " ").title(),
+            f"  Completed:    {s['completed']} of {s['total']}",
+            f"  Still open:   {s['pending']}",
+            f"  SLA breaches: {s['sla_breaches']} (target {s['sla_target_minutes']} min)",
+            f"  Avg rating:   {rating}",
+            ""
+        ]
+    return subject, "\n".join(lines)
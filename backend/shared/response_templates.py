@@ -0,0 +1,92 @@
+import asyncio
+import string
+from datetime import datetime, timezone
+from typing import Dict, List, Optional, Tuple
+
+import structlog
+from pydantic import BaseModel, Field, validator
+
+from shared.db.database import DatabaseConnection
+
+logger = structlog.get_logger()
+
+TEMPLATE_PLACEHOLDERS = {"guest_name", "room_number", "department", "request_id"}
+
+class TemplateError(Exception): pass
+
+def template_placeholders(text: str) -> set:
+    return {field for _, field, _, _ in string.Formatter().parse(text) if field is not None}
+
+class ResponseTemplate(BaseModel):
+    intent: str = Field(..., description="Department/intent the acknowledgement is sent for")
+    language: str = Field(..., pattern=r"^[a-z]{2}$")
+    text: str = Field(..., min_length=1, max_length=500)
+    updated_by: Optional[str] = None
+    updated_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+
+    @validator("text")
+    def validate_placeholders(cls, v):
+        try:
+            unknown = template_placeholders(v) - TEMPLATE_PLACEHOLDERS
+        except ValueError as e:
+            raise ValueError(f"Invalid template: {e}")
+        if unknown:
+            raise ValueError(f"Unknown placeholders {sorted(unknown)}; allowed: {sorted(TEMPLATE_PLACEHOLDERS)}")
+        return v
+
+class _Blank(dict):
+    def __missing__(self, key):
+        return ""
+
+class ResponseTemplates:
+    """
+    In-memory cache of admin-managed acknowledgement templates keyed by (intent, language).
+    Lookups fall back to the English template for the intent, then to the caller's default.
+    """
+
+    def __init__(self, refresh_interval: int = 30):
+        self.refresh_interval = refresh_interval
+        self.templates: Dict[Tuple[str, str], str] = {}
+
+    async def refresh(self) -> None:
+        async with DatabaseConnection.get_connection() as conn:
+            cursor = conn["virtualbutler"]["response_templates"].find({}, {"_id": 0, "intent": 1, "language": 1, "text": 1})
+            self.templates = {(doc["intent"], doc["language"]): doc["text"] async for doc in cursor}
+
+    async def refresh_loop(self) -> None:
+        while True:
+            try:
+                await self.refresh()
+            except Exception as e:
+                logger.error("response_templates_refresh_failed", error=str(e))
+            await asyncio.sleep(self.refresh_interval)
+
+    def render(self, intent: str, language: str, default: str, **values) -> str:
+        text = self.templates.get((intent, language)) or self.templates.get((intent, "en")) or default
+        return text.format_map(_Blank({k: v if v is not None else "" for k, v in values.items()}))
+
+# --- Template administration ---
+
+async def list_templates(intent: Optional[str] = None) -> List[ResponseTemplate]:
+    query = {"intent": intent} if intent else {}
+    async with DatabaseConnection.get_connection() as conn:
+        cursor = conn["virtualbutler"]["response_templates"].find(query).sort([("intent", 1), ("language", 1)])
+        return [ResponseTemplate(**doc) async for doc in cursor]
+
+async def upsert_template(template: ResponseTemplate) -> ResponseTemplate:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["response_templates"].update_one(
+            {"intent": template.intent, "language": template.language},
+            {"$set": template.model_dump()},
+            upsert=True
+        )
+    logger.info("response_template_saved", intent=template.intent, language=template.language,
+                updated_by=template.updated_by)
+    return template
+
+async def delete_template(intent: str, language: str) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["response_templates"].delete_one({"intent": intent, "language": language})
+    if result.deleted_count == 0:
+        raise TemplateError(f"No template for intent '{intent}' in '{language}'")
+    logger.info("response_template_deleted", intent=intent, language=language)
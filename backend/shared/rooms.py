@@ -0,0 +1,129 @@
+"""
+Guest accounts linked to more than one room: a family across connecting rooms, a suite booked as two
+keys, a group leader holding several rooms.
+
+A profile's `room_number` is its primary room and `rooms` lists the others. For a single-room guest
+nothing changes. For a multi-room guest a request has to say which room it's for, by:
+- `room_number` in the message metadata (the app's room picker), or
+- naming exactly one of the linked rooms in the text ("towels for 1204").
+Otherwise the bot holds the message and asks, offering the rooms as quick replies; the guest's
+answer releases the held message with that room. Work orders carry the resolved room, never the
+primary one by default.
+"""
+import os
+import re
+from datetime import datetime, timedelta, timezone
+from typing import Iterable, List, Optional
+
+import structlog
+from pydantic import BaseModel, Field
+
+from shared.db.database import DatabaseConnection
+
+logger = structlog.get_logger()
+
+ROOM_CHOICE_TTL_MINUTES = int(os.getenv("ROOM_CHOICE_TTL_MINUTES", "15"))
+ROOM_CHOICE_TAG = "room_choice"
+MAX_LINKED_ROOMS = 10
+
+class RoomError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+class LinkedRoomsUpdate(BaseModel):
+    rooms: List[str] = Field(..., min_length=1, max_length=MAX_LINKED_ROOMS,
+                             description="The first is the primary room")
+
+def linked_rooms(profile) -> List[str]:
+    """The primary room first, then the other linked rooms, without duplicates."""
+    if profile is None:
+        return []
+    if isinstance(profile, dict):
+        primary, others = profile.get("room_number"), profile.get("rooms") or []
+    else:
+        primary, others = profile.room_number, getattr(profile, "rooms", None) or []
+    rooms = [primary] if primary else []
+    return rooms + [room for i, room in enumerate(others) if room and room not in rooms and room not in others[:i]]
+
+def mentioned_room(text: str, rooms: Iterable[str]) -> Optional[str]:
+    """The one linked room named in the text; None when none or several are."""
+    found = {room for room in rooms
+             if re.search(rf"(?<![\w]){re.escape(room)}(?![\w])", text or "", re.IGNORECASE)}
+    return found.pop() if len(found) == 1 else None
+
+def resolve_room(rooms: List[str], text: str, explicit: Optional[str] = None) -> Optional[str]:
+    """The room a request is for, or None when the guest has to be asked."""
+    if explicit:
+        # A guest with no linked rooms can't name one either
+        if explicit not in rooms:
+            raise RoomError(f"Room {explicit} is not linked to this guest", 403)
+        return explicit
+    if len(rooms) <= 1:
+        return rooms[0] if rooms else None
+    return mentioned_room(text, rooms)
+
+def chosen_room(answer: str, rooms: Iterable[str]) -> Optional[str]:
+    """The room picked by a short answer ("1204", "room 1204"); a longer message is a new request."""
+    if not answer or len(answer.split()) > 3:
+        return None
+    return mentioned_room(answer, rooms)
+
+# --- Held messages ---
+
+async def hold_for_room_choice(guest_id: str, message: dict, rooms: List[str]) -> None:
+    """Keeps the message until the guest says which room; a newer question replaces an older one."""
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["room_choices"].replace_one(
+            {"guest_id": guest_id},
+            {"guest_id": guest_id, "message": message, "rooms": rooms, "created_at": now,
+             "expires_at": now + timedelta(minutes=ROOM_CHOICE_TTL_MINUTES)},
+            upsert=True
+        )
+    logger.info("room_choice_requested", guest_id=guest_id, rooms=len(rooms))
+
+async def take_held_message(guest_id: str, answer: str, rooms: List[str]) -> Optional[tuple]:
+    """(message, room) when the answer picks a room for a held message; the hold is released."""
+    room = chosen_room(answer, rooms)
+    if not room:
+        return None
+    async with DatabaseConnection.get_connection() as conn:
+        held = await conn["virtualbutler"]["room_choices"].find_one_and_delete(
+            {"guest_id": guest_id, "expires_at": {"$gt": datetime.now(timezone.utc)}}
+        )
+    if not held:
+        return None
+    logger.info("room_choice_made", guest_id=guest_id, room_number=room)
+    return held["message"], room
+
+# --- Linking ---
+
+async def link_rooms(guest_id: str, rooms: List[str]) -> dict:
+    """Sets the guest's rooms; the first becomes the primary room."""
+    rooms = linked_rooms({"rooms": [room.strip() for room in rooms]})
+    if not rooms:
+        raise RoomError("At least one room is required", 422)
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        taken = await db["guest_profiles"].find_one(
+            {"guest_id": {"$ne": guest_id}, "$or": [{"room_number": {"$in": rooms}}, {"rooms": {"$in": rooms}}],
+             "check_out_date": {"$not": {"$lt": datetime.now(timezone.utc)}}},
+            {"_id": 0, "guest_id": 1}
+        )
+        if taken:
+            raise RoomError(f"A room is already linked to guest {taken['guest_id']}", 409)
+        result = await db["guest_profiles"].update_one(
+            {"guest_id": guest_id}, {"$set": {"room_number": rooms[0], "rooms": rooms[1:]}}
+        )
+    if not result.matched_count:
+        raise RoomError("Guest not found", 404)
+    logger.info("guest_rooms_linked", guest_id=guest_id, rooms=rooms)
+    return {"guest_id": guest_id, "room_number": rooms[0], "rooms": rooms}
+
+async def ensure_room_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        await db["room_choices"].create_index("guest_id", unique=True)
+        await db["room_choices"].create_index("expires_at", expireAfterSeconds=0)
+        await db["guest_profiles"].create_index("rooms", sparse=True)
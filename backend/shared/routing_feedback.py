@@ -0,0 +1,149 @@
+"""
+Routing-accuracy feedback: staff mark work orders that landed in the wrong department, and the
+corrections flow back into routing.
+
+- Every correction is kept in `routing_corrections` with the order's text, where it was routed, where
+  it should have gone, and how the chatbot decided (CLU or keyword rules, with its confidence).
+- The misrouting report gives accuracy overall, per department and per classifier, plus the most
+  common wrong-to-right pairs.
+- Phrases corrected to the same department at least ROUTING_FEEDBACK_MIN_CORRECTIONS times become
+  exact-phrase rules in a draft ruleset (ahead of the current rules), which admins roll out through
+  the usual shadow/canary steps. The corrections are also exported as labelled utterances for
+  retraining the CLU model.
+"""
+import os
+import re
+import uuid
+from collections import Counter, defaultdict
+from datetime import datetime, timezone
+from typing import Dict, Iterable, List, Optional
+
+import structlog
+from pydantic import BaseModel, Field
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import DepartmentEnum
+from shared.routing_rules import RoutingRule
+
+logger = structlog.get_logger()
+
+ROUTING_FEEDBACK_MIN_CORRECTIONS = int(os.getenv("ROUTING_FEEDBACK_MIN_CORRECTIONS", "2"))
+# Longer texts are too specific to ever match again as a phrase
+MAX_PHRASE_LENGTH = 120
+
+class RoutingCorrection(BaseModel):
+    correction_id: str = Field(default_factory=lambda: f"rc_{uuid.uuid4().hex}")
+    work_order_id: str
+    request_id: Optional[str] = None
+    text: str
+    predicted: DepartmentEnum
+    corrected: DepartmentEnum
+    intent_source: Optional[str] = Field(None, description="How the chatbot chose the department (clu, rules, ...)")
+    confidence: Optional[float] = None
+    language: Optional[str] = None
+    reported_by: Optional[str] = None
+    reason: Optional[str] = None
+    ruleset_version: Optional[int] = Field(None, description="Draft ruleset the correction was turned into")
+    created_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+
+def normalize_phrase(text: str) -> str:
+    return " ".join(re.findall(r"[a-z0-9']+", text.lower()))
+
+def phrase_pattern(phrase: str) -> str:
+    """Matches the phrase on its own, whatever the spacing, case and trailing punctuation."""
+    return r"^\W*" + r"\W+".join(re.escape(word) for word in phrase.split()) + r"\W*$"
+
+def phrase_rules(corrections: Iterable[dict], min_count: int = ROUTING_FEEDBACK_MIN_CORRECTIONS) -> List[RoutingRule]:
+    """A rule per phrase staff keep moving to the same department (a clear majority of its corrections)."""
+    by_phrase: Dict[str, Counter] = defaultdict(Counter)
+    for correction in corrections:
+        phrase = normalize_phrase(correction["text"])
+        if phrase and len(phrase) <= MAX_PHRASE_LENGTH:
+            by_phrase[phrase][DepartmentEnum(correction["corrected"]).value] += 1
+    rules = []
+    for phrase, counts in sorted(by_phrase.items()):
+        department, count = counts.most_common(1)[0]
+        if count >= min_count and count * 2 > sum(counts.values()):
+            rules.append(RoutingRule(department=DepartmentEnum(department), pattern=phrase_pattern(phrase)))
+    return rules
+
+def summarize(routed: Dict[str, int], corrections: List[dict], top: int = 10) -> dict:
+    """Accuracy from orders routed per department and the corrections made to them."""
+    misrouted = Counter(DepartmentEnum(c["predicted"]).value for c in corrections)
+    total = sum(routed.values())
+    departments = sorted(set(routed) | set(misrouted))
+    sources: Dict[str, Counter] = defaultdict(Counter)
+    for c in corrections:
+        sources[c.get("intent_source") or "unknown"]["misrouted"] += 1
+    pairs = Counter((DepartmentEnum(c["predicted"]).value, DepartmentEnum(c["corrected"]).value) for c in corrections)
+    return {
+        "orders": total,
+        "misrouted": len(corrections),
+        "accuracy": round(1 - len(corrections) / total, 4) if total else None,
+        "by_department": [{
+            "department": d,
+            "routed": routed.get(d, 0),
+            "misrouted": misrouted.get(d, 0),
+            "misrouting_rate": round(misrouted.get(d, 0) / routed[d], 4) if routed.get(d) else None,
+        } for d in departments],
+        "by_source": {source: counts["misrouted"] for source, counts in sorted(sources.items())},
+        "top_confusions": [{"from": f, "to": t, "count": n} for (f, t), n in pairs.most_common(top)],
+    }
+
+def clu_utterances(corrections: Iterable[dict]) -> List[dict]:
+    """Labelled utterances in the CLU project import format, with the corrected department as the intent."""
+    return [{"text": c["text"], "intent": DepartmentEnum(c["corrected"]).value, "language": c.get("language") or "en",
+             "dataset": "Train"} for c in corrections]
+
+# --- Storage ---
+
+async def record_correction(order: dict, corrected: DepartmentEnum, reported_by: Optional[str],
+                            reason: Optional[str] = None) -> RoutingCorrection:
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        chat = await db["chat_requests"].find_one({"request_id": order["request_id"]}, {"metadata.intent": 1}) \
+            if order.get("request_id") else None
+        intent = ((chat or {}).get("metadata") or {}).get("intent") or {}
+        correction = RoutingCorrection(
+            work_order_id=order["work_order_id"], request_id=order.get("request_id"), text=order.get("description") or "",
+            predicted=DepartmentEnum(order["department"]), corrected=corrected,
+            intent_source=intent.get("source") or (order.get("metadata") or {}).get("source"),
+            confidence=intent.get("confidence"), language=(order.get("metadata") or {}).get("language"),
+            reported_by=reported_by, reason=reason
+        )
+        await db["routing_corrections"].insert_one(correction.model_dump(mode="json") | {"created_at": correction.created_at})
+    logger.info("work_order_misrouted", work_order_id=correction.work_order_id, predicted=correction.predicted.value,
+                corrected=corrected.value, source=correction.intent_source)
+    return correction
+
+async def list_corrections(start: Optional[datetime] = None, end: Optional[datetime] = None,
+                           limit: Optional[int] = None) -> List[dict]:
+    query: dict = {}
+    if start or end:
+        query["created_at"] = {**({"$gte": start} if start else {}), **({"$lt": end} if end else {})}
+    async with DatabaseConnection.get_connection() as conn:
+        cursor = conn["virtualbutler"]["routing_corrections"].find(query, {"_id": 0}).sort("created_at", -1)
+        if limit:
+            cursor = cursor.limit(limit)
+        return await cursor.to_list(length=None)
+
+async def misrouting_report(start: datetime, end: datetime) -> dict:
+    async with DatabaseConnection.get_connection() as conn:
+        rows = await conn["virtualbutler"]["work_orders"].aggregate([
+            {"$match": {"parent_id": None, "created_at": {"$gte": start, "$lt": end}}},
+            {"$group": {"_id": "$department", "count": {"$sum": 1}}}
+        ]).to_list(length=None)
+    return {"start": start, "end": end, **summarize({r["_id"]: r["count"] for r in rows}, await list_corrections(start, end))}
+
+async def mark_used(corrections: List[dict], ruleset_version: int) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["routing_corrections"].update_many(
+            {"correction_id": {"$in": [c["correction_id"] for c in corrections]}},
+            {"$set": {"ruleset_version": ruleset_version}}
+        )
+
+async def ensure_routing_feedback_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        corrections = conn["virtualbutler"]["routing_corrections"]
+        await corrections.create_index("created_at")
+        await corrections.create_index("work_order_id")
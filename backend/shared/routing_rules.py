@@ -0,0 +1,306 @@
+"""
+Keyword routing: each rule is a regex naming a department, tried in order on the lowercased message.
+
+Guests misspell ("towle", "cleanning") and split words ("wi fi"), so a message no rule matches gets a
+second, fuzzy pass. Synonyms are rewritten to their canonical keyword first (`synonyms` on a ruleset,
+or BUILTIN_SYNONYMS), then every word, and every pair of adjacent words run together, is compared
+with the rules' plain keywords by edit distance (transpositions count as one edit). The closest keyword
+within ROUTING_FUZZY_MAX_EDITS wins, with a similarity below 1.0 that the caller turns into a lower
+confidence; the chatbot asks "did you mean ...?" instead of routing when it is too low.
+"""
+import asyncio
+import hashlib
+import os
+import re
+from datetime import datetime, timezone
+from enum import Enum
+from typing import Dict, List, NamedTuple, Optional
+
+import structlog
+from pydantic import BaseModel, Field, validator
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import DepartmentEnum
+
+logger = structlog.get_logger()
+
+ROUTING_FUZZY_MAX_EDITS = int(os.getenv("ROUTING_FUZZY_MAX_EDITS", "2"))
+# Short keywords ("ac", "tv", "key") are too close to ordinary words to match fuzzily
+FUZZY_MIN_LENGTH = 4
+# Ordinary words within an edit or two of a keyword ("night" and "light", "plate" and "late")
+FUZZY_IGNORED_WORDS = {"night", "right", "might", "sight", "fight", "tight", "plate", "later", "sheer", "safely",
+                       "please", "thanks", "there", "their", "where", "would", "could", "should", "about", "order"}
+BUILTIN_SYNONYMS = {
+    "wifi": ["wi-fi", "wireless", "wlan", "hotspot"],
+    "clean": ["hoover", "vacuum", "tidy", "mop"],
+    "towel": ["serviette", "bath sheet"],
+    # Ahead of "plumbing", so "toilet paper" isn't read as a toilet to fix
+    "toiletries": ["toilet paper", "toilet roll", "loo roll", "tissues", "shampoo", "soap", "toothbrush",
+                   "toothpaste"],
+    "ac": ["aircon", "air con", "a/c", "heating", "heater", "radiator"],
+    "plumbing": ["toilet", "loo", "drain", "clogged", "blocked"],
+    "taxi": ["cab", "uber", "ride"],
+}
+
+class RuleSetModeEnum(str, Enum):
+    DRAFT = "draft"
+    SHADOW = "shadow"      # evaluated and logged, never used for live routing
+    CANARY = "canary"      # used for rollout_percent of traffic
+    ACTIVE = "active"      # the stable ruleset
+    RETIRED = "retired"
+
+class RoutingRule(BaseModel):
+    department: DepartmentEnum
+    pattern: str
+
+    @validator("pattern")
+    def validate_pattern(cls, v):
+        try:
+            re.compile(v)
+        except re.error as e:
+            raise ValueError(f"Invalid regex pattern: {e}")
+        return v
+
+class RoutingRuleSet(BaseModel):
+    version: int
+    rules: List[RoutingRule]
+    synonyms: Dict[str, List[str]] = Field(default_factory=dict, description="Keyword -> other words for it, "
+                                           "rewritten before the rules run")
+    mode: RuleSetModeEnum = RuleSetModeEnum.DRAFT
+    rollout_percent: int = Field(0, ge=0, le=100)
+    previous_version: Optional[int] = Field(None, description="Active version this one replaced (used for rollback)")
+    notes: Optional[str] = None
+    created_by: Optional[str] = None
+    created_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+    activated_at: Optional[datetime] = None
+
+class RuleSetError(Exception): pass
+
+class RuleMatch(NamedTuple):
+    department: DepartmentEnum
+    similarity: float = 1.0
+    # For a fuzzy match: the word as the guest wrote it, and the message with the keyword in its place
+    word: Optional[str] = None
+    corrected: Optional[str] = None
+
+def evaluate_rules(rules: List[RoutingRule], text: str) -> Optional[DepartmentEnum]:
+    text = text.lower()
+    for rule in rules:
+        if re.search(rule.pattern, text):
+            return DepartmentEnum(rule.department)
+    return None
+
+def edit_distance(a: str, b: str) -> int:
+    """Levenshtein distance, counting a swap of adjacent letters as one edit."""
+    previous, current = None, list(range(len(b) + 1))
+    for i in range(1, len(a) + 1):
+        before, previous, current = previous, current, [i] + [0] * len(b)
+        for j in range(1, len(b) + 1):
+            current[j] = min(previous[j] + 1, current[j - 1] + 1, previous[j - 1] + (a[i - 1] != b[j - 1]))
+            if i > 1 and j > 1 and a[i - 1] == b[j - 2] and a[i - 2] == b[j - 1]:
+                current[j] = min(current[j], before[j - 2] + 1)
+    return current[len(b)]
+
+def max_edits(keyword: str) -> int:
+    return min(ROUTING_FUZZY_MAX_EDITS, 1 if len(keyword) <= 6 else 2)
+
+def rule_keywords(rules: List[RoutingRule]) -> List[tuple]:
+    """(keyword, department) for the plain-word alternatives of each rule, in rule order."""
+    return [(keyword, DepartmentEnum(rule.department)) for rule in rules for keyword in rule.pattern.split("|")
+            if re.fullmatch(r"[a-z]+", keyword) and len(keyword) >= FUZZY_MIN_LENGTH]
+
+def apply_synonyms(text: str, synonyms: Dict[str, List[str]]) -> str:
+    text = text.lower()
+    for keyword, words in synonyms.items():
+        for word in sorted(words, key=len, reverse=True):
+            text = re.sub(rf"(?<![\w-]){re.escape(word.lower())}(?![\w-])", keyword, text)
+    return text
+
+def fuzzy_match(rules: List[RoutingRule], text: str) -> Optional[RuleMatch]:
+    """The closest keyword to a word (or two words run together) in the text, if within the edit budget."""
+    words = [(m.group(0), m.start(), m.end()) for m in re.finditer(r"[a-z]+", text)]
+    candidates = words + [(a[0] + b[0], a[1], b[2]) for a, b in zip(words, words[1:])]
+    best = None
+    for word, start, end in candidates:
+        if len(word) < FUZZY_MIN_LENGTH or word in FUZZY_IGNORED_WORDS:
+            continue
+        for keyword, department in rule_keywords(rules):
+            if abs(len(word) - len(keyword)) > max_edits(keyword):
+                continue
+            distance = edit_distance(word, keyword)
+            if distance > max_edits(keyword) or (distance == 0 and end - start == len(word)):
+                # Too far off, or an exact word the regex pass would already have caught
+                continue
+            similarity = 1 - distance / max(len(word), len(keyword))
+            if best is None or similarity > best.similarity:
+                best = RuleMatch(department, similarity, text[start:end], text[:start] + keyword + text[end:])
+    return best
+
+def match_rules(rules: List[RoutingRule], text: str, synonyms: Optional[Dict[str, List[str]]] = None) -> Optional[RuleMatch]:
+    text = apply_synonyms(text, synonyms or {})
+    department = evaluate_rules(rules, text)
+    if department:
+        return RuleMatch(department)
+    return fuzzy_match(rules, text)
+
+def in_rollout(routing_key: str, percent: int) -> bool:
+    """Sticky bucketing: the same key always lands in the same bucket for a given percentage."""
+    bucket = int(hashlib.sha256(routing_key.encode()).hexdigest()[:8], 16) % 100
+    return bucket < percent
+
+def rules_from_keywords(keywords: Dict[DepartmentEnum, List[str]]) -> List[RoutingRule]:
+    return [RoutingRule(department=dept, pattern=pat) for dept, patterns in keywords.items() for pat in patterns]
+
+class RoutingRules:
+    """
+    In-memory view of the stable and candidate rulesets stored in Mongo.
+    Evaluation is synchronous; call refresh() (or run refresh_loop()) to pick up admin changes.
+    The builtin rules are used until an admin activates a ruleset.
+    """
+
+    def __init__(self, builtin: List[RoutingRule], refresh_interval: int = 30,
+                 builtin_synonyms: Optional[Dict[str, List[str]]] = None):
+        self.builtin = builtin
+        self.builtin_synonyms = BUILTIN_SYNONYMS if builtin_synonyms is None else builtin_synonyms
+        self.refresh_interval = refresh_interval
+        self.stable: Optional[RoutingRuleSet] = None
+        self.candidate: Optional[RoutingRuleSet] = None
+
+    async def refresh(self) -> None:
+        async with DatabaseConnection.get_connection() as conn:
+            coll = conn["virtualbutler"]["routing_rulesets"]
+            stable = await coll.find_one({"mode": RuleSetModeEnum.ACTIVE}, sort=[("version", -1)])
+            candidate = await coll.find_one(
+                {"mode": {"$in": [RuleSetModeEnum.CANARY, RuleSetModeEnum.SHADOW]}}, sort=[("version", -1)]
+            )
+        self.stable = RoutingRuleSet(**stable) if stable else None
+        self.candidate = RoutingRuleSet(**candidate) if candidate else None
+
+    async def refresh_loop(self) -> None:
+        while True:
+            try:
+                await self.refresh()
+            except Exception as e:
+                logger.error("routing_rules_refresh_failed", error=str(e))
+            await asyncio.sleep(self.refresh_interval)
+
+    def synonyms(self, ruleset: Optional[RoutingRuleSet]) -> Dict[str, List[str]]:
+        return {**self.builtin_synonyms, **ruleset.synonyms} if ruleset else self.builtin_synonyms
+
+    def match(self, text: str, routing_key: Optional[str] = None) -> Optional[RuleMatch]:
+        stable_rules = self.stable.rules if self.stable else self.builtin
+        stable_version = self.stable.version if self.stable else 0
+        decision = match_rules(stable_rules, text, self.synonyms(self.stable))
+        candidate = self.candidate
+        if not candidate:
+            return decision
+        candidate_decision = match_rules(candidate.rules, text, self.synonyms(candidate))
+        if candidate.mode == RuleSetModeEnum.SHADOW:
+            if (candidate_decision and candidate_decision.department) != (decision and decision.department):
+                logger.info("routing_shadow_mismatch", stable_version=stable_version,
+                            candidate_version=candidate.version, stable=decision and decision.department,
+                            candidate=candidate_decision and candidate_decision.department)
+            return decision
+        if in_rollout(routing_key or text, candidate.rollout_percent):
+            logger.info("routing_canary_decision", candidate_version=candidate.version,
+                        department=candidate_decision and candidate_decision.department)
+            return candidate_decision
+        return decision
+
+    def decide(self, text: str, routing_key: Optional[str] = None) -> Optional[DepartmentEnum]:
+        match = self.match(text, routing_key)
+        return match.department if match else None
+
+# --- Ruleset administration ---
+
+async def list_rulesets() -> List[RoutingRuleSet]:
+    async with DatabaseConnection.get_connection() as conn:
+        cursor = conn["virtualbutler"]["routing_rulesets"].find().sort("version", -1)
+        return [RoutingRuleSet(**doc) async for doc in cursor]
+
+async def create_ruleset(rules: List[RoutingRule], created_by: Optional[str], notes: Optional[str] = None,
+                         synonyms: Optional[Dict[str, List[str]]] = None) -> RoutingRuleSet:
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["routing_rulesets"]
+        latest = await coll.find_one(sort=[("version", -1)])
+        ruleset = RoutingRuleSet(
+            version=(latest["version"] + 1) if latest else 1,
+            rules=rules,
+            synonyms=synonyms or {},
+            created_by=created_by,
+            notes=notes
+        )
+        await coll.insert_one(ruleset.model_dump())
+    logger.info("routing_ruleset_created", version=ruleset.version, created_by=created_by)
+    return ruleset
+
+async def start_rollout(version: int, mode: RuleSetModeEnum, percent: int = 0) -> RoutingRuleSet:
+    if mode not in (RuleSetModeEnum.CANARY, RuleSetModeEnum.SHADOW):
+        raise RuleSetError("Rollout mode must be 'canary' or 'shadow'")
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["routing_rulesets"]
+        other = await coll.find_one({
+            "mode": {"$in": [RuleSetModeEnum.CANARY, RuleSetModeEnum.SHADOW]},
+            "version": {"$ne": version}
+        })
+        if other:
+            raise RuleSetError(f"Version {other['version']} is already rolling out; roll it back or promote it first")
+        doc = await coll.find_one_and_update(
+            {"version": version, "mode": {"$in": [RuleSetModeEnum.DRAFT, RuleSetModeEnum.CANARY, RuleSetModeEnum.SHADOW]}},
+            {"$set": {"mode": mode, "rollout_percent": percent if mode == RuleSetModeEnum.CANARY else 0}},
+            return_document=True
+        )
+    if not doc:
+        raise RuleSetError(f"Version {version} not found or not eligible for rollout")
+    logger.info("routing_ruleset_rollout", version=version, mode=mode, percent=percent)
+    return RoutingRuleSet(**doc)
+
+async def promote_ruleset(version: int) -> RoutingRuleSet:
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["routing_rulesets"]
+        target = await coll.find_one({"version": version})
+        if not target or target["mode"] in (RuleSetModeEnum.ACTIVE, RuleSetModeEnum.RETIRED):
+            raise RuleSetError(f"Version {version} not found or not eligible for promotion")
+        current = await coll.find_one({"mode": RuleSetModeEnum.ACTIVE})
+        if current:
+            await coll.update_one({"_id": current["_id"]}, {"$set": {"mode": RuleSetModeEnum.RETIRED}})
+        doc = await coll.find_one_and_update(
+            {"version": version},
+            {"$set": {"mode": RuleSetModeEnum.ACTIVE, "rollout_percent": 100, "activated_at": now,
+                      "previous_version": current["version"] if current else None}},
+            return_document=True
+        )
+    logger.info("routing_ruleset_promoted", version=version, previous_version=current["version"] if current else None)
+    return RoutingRuleSet(**doc)
+
+async def rollback_ruleset() -> Optional[RoutingRuleSet]:
+    """
+    One-call rollback:
+    - if a canary/shadow ruleset is in flight, it is retired and live routing is untouched;
+    - otherwise the active ruleset is retired and the version it replaced is reactivated
+      (or the builtin rules if there is none).
+    Returns the ruleset now in effect, or None for the builtin rules.
+    """
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["routing_rulesets"]
+        candidate = await coll.find_one({"mode": {"$in": [RuleSetModeEnum.CANARY, RuleSetModeEnum.SHADOW]}})
+        if candidate:
+            await coll.update_one({"_id": candidate["_id"]}, {"$set": {"mode": RuleSetModeEnum.RETIRED, "rollout_percent": 0}})
+            logger.info("routing_ruleset_rollback", retired_version=candidate["version"])
+            current = await coll.find_one({"mode": RuleSetModeEnum.ACTIVE})
+            return RoutingRuleSet(**current) if current else None
+        current = await coll.find_one({"mode": RuleSetModeEnum.ACTIVE})
+        if not current:
+            raise RuleSetError("Nothing to roll back")
+        await coll.update_one({"_id": current["_id"]}, {"$set": {"mode": RuleSetModeEnum.RETIRED}})
+        restored = None
+        if current.get("previous_version"):
+            restored = await coll.find_one_and_update(
+                {"version": current["previous_version"]},
+                {"$set": {"mode": RuleSetModeEnum.ACTIVE, "activated_at": datetime.now(timezone.utc)}},
+                return_document=True
+            )
+    logger.info("routing_ruleset_rollback", retired_version=current["version"],
+                restored_version=restored["version"] if restored else None)
+    return RoutingRuleSet(**restored) if restored else None
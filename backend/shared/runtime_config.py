@@ -0,0 +1,268 @@
+"""
+Settings that can change without a restart: SLA targets, rate limits, loyalty tier rules, feature
+flags, the hotel's timezone, the item synonyms and chat shorthand used to read requests, and which
+orders need proof of completion. Routing rules reload from their own collection and are refreshed
+along with these.
+
+Layers, later ones winning key by key:
+1. Built-in defaults (SLA_TARGET_MINUTES and the limits below).
+2. RUNTIME_CONFIG_FILE, a JSON file (e.g. a mounted ConfigMap), re-read when it changes.
+3. The `runtime_config` document in Mongo, edited with PUT /api/v1/admin/config.
+4. Azure App Configuration (APP_CONFIG_CONNECTION_STRING): the JSON value stored under APP_CONFIG_KEY.
+
+Every RUNTIME_CONFIG_REFRESH_SECONDS, or on POST /api/v1/admin/config/reload, the layers are merged and
+validated as a whole. Only a valid result replaces the current settings, and it does so in a single
+assignment, so a request never sees half an update. A broken source leaves the previous settings in
+place and shows up as `last_error` in the status. The status also carries a hash of the active settings,
+so a deploy can check that every replica picked up the same version.
+"""
+import asyncio
+import base64
+import hashlib
+import hmac
+import json
+import os
+from datetime import datetime, timezone
+from email.utils import format_datetime
+from typing import Awaitable, Callable, Dict, List, Optional, Tuple
+from urllib.parse import quote, urlsplit
+from zoneinfo import ZoneInfo, ZoneInfoNotFoundError
+
+import structlog
+from pydantic import BaseModel, Field, validator
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import DepartmentEnum
+from shared.http_client import ResilientClient
+from shared.leases import REPLICA_ID
+
+logger = structlog.get_logger()
+
+RUNTIME_CONFIG_FILE = os.getenv("RUNTIME_CONFIG_FILE")
+RUNTIME_CONFIG_REFRESH_SECONDS = int(os.getenv("RUNTIME_CONFIG_REFRESH_SECONDS", "30"))
+APP_CONFIG_CONNECTION_STRING = os.getenv("APP_CONFIG_CONNECTION_STRING")
+APP_CONFIG_KEY = os.getenv("APP_CONFIG_KEY", "virtual-butler:runtime")
+APP_CONFIG_LABEL = os.getenv("APP_CONFIG_LABEL")
+
+app_config_client = ResilientClient("app_configuration", timeout=10.0)
+
+class RateLimits(BaseModel):
+    chat_per_minute: int = Field(10, ge=1, le=1000, description="Chat and quick-action requests per guest")
+    work_orders_per_minute: int = Field(5, ge=1, le=1000, description="POST /work-orders per client")
+    inquiries_per_minute: int = Field(5, ge=1, le=1000, description="Anonymous inquiries per client address")
+
+class LoyaltyTier(BaseModel):
+    """What guests in a loyalty tier get; see shared/loyalty.py."""
+    priority_boost: int = Field(0, ge=0, le=2, description="Priority levels added to chat orders, never to urgent")
+    sla_percent: int = Field(100, ge=10, le=100, description="The order's SLA target as a share of the department's")
+    rank: int = Field(0, ge=0, le=100, description="Among orders of equal priority, higher ranks are served first")
+
+class CompletionProofPolicy(BaseModel):
+    departments: List[DepartmentEnum] = Field(default_factory=list, description="Every order in these departments")
+    tags: List[str] = Field(default_factory=list, description="Orders carrying any of these tags, e.g. vip_amenity")
+
+class RuntimeSettings(BaseModel):
+    sla_target_minutes: Dict[str, int] = Field(default_factory=dict, description="Overrides by department")
+    rate_limits: RateLimits = Field(default_factory=RateLimits)
+    loyalty_tiers: Dict[str, LoyaltyTier] = Field(default_factory=dict, description="Rules by tier, e.g. platinum")
+    feature_flags: Dict[str, bool] = Field(default_factory=dict)
+    hotel_timezone: Optional[str] = Field(None, description="IANA name guests read times in, e.g. Europe/Paris; "
+                                                            "defaults to HOTEL_TIMEZONE")
+    item_synonyms: Dict[str, List[str]] = Field(default_factory=dict, description="Canonical item -> other names, "
+                                                "e.g. towels: [bath sheet]; replaces the built-in entry")
+    shorthand: Dict[str, str] = Field(default_factory=dict, description="Chat shorthand -> what it stands for, "
+                                      "e.g. hk: housekeeping; added to the built-in list")
+    completion_proof: CompletionProofPolicy = Field(default_factory=CompletionProofPolicy,
+                                                    description="Orders that need a photo or note to be completed")
+
+    @validator("sla_target_minutes")
+    def validate_sla_targets(cls, v):
+        for department, minutes in v.items():
+            DepartmentEnum(department)
+            if minutes <= 0:
+                raise ValueError(f"SLA target for {department} must be positive")
+        return v
+
+    @validator("hotel_timezone")
+    def validate_timezone(cls, v):
+        if v:
+            try:
+                ZoneInfo(v)
+            except (ZoneInfoNotFoundError, ValueError):
+                raise ValueError(f"Unknown timezone '{v}'")
+        return v
+
+    @validator("item_synonyms")
+    def normalize_item_synonyms(cls, v):
+        return {item.strip().lower(): [name.strip().lower() for name in names if name.strip()]
+                for item, names in v.items() if item.strip()}
+
+    @validator("shorthand")
+    def normalize_shorthand(cls, v):
+        return {short.strip().lower(): expansion.strip() for short, expansion in v.items() if short.strip()}
+
+    @validator("loyalty_tiers")
+    def normalize_tiers(cls, v):
+        return {tier.strip().lower(): rule for tier, rule in v.items()}
+
+class RuntimeConfigError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+def merge_layers(*layers: dict) -> dict:
+    """Later layers win; nested sections (rate_limits, feature_flags, ...) merge key by key."""
+    merged: dict = {}
+    for layer in layers:
+        for key, value in (layer or {}).items():
+            if isinstance(value, dict) and isinstance(merged.get(key), dict):
+                merged[key] = {**merged[key], **value}
+            else:
+                merged[key] = value
+    return merged
+
+def settings_hash(settings: dict) -> str:
+    return hashlib.sha256(json.dumps(settings, sort_keys=True, default=str).encode()).hexdigest()[:12]
+
+# --- Azure App Configuration ---
+
+def parse_connection_string(value: str) -> Dict[str, str]:
+    """Endpoint=https://...;Id=...;Secret=... as a dict."""
+    return dict(part.split("=", 1) for part in value.split(";") if "=" in part)
+
+def app_config_headers(method: str, url: str, credential: str, secret: str, now: datetime, body: bytes = b"") -> dict:
+    """HMAC-SHA256 request signing for the App Configuration REST API."""
+    parts = urlsplit(url)
+    path_and_query = parts.path + (f"?{parts.query}" if parts.query else "")
+    content_hash = base64.b64encode(hashlib.sha256(body).digest()).decode()
+    date = format_datetime(now, usegmt=True)
+    signed = f"{method.upper()}\n{path_and_query}\n{date};{parts.netloc};{content_hash}"
+    signature = base64.b64encode(hmac.new(base64.b64decode(secret), signed.encode(), hashlib.sha256).digest()).decode()
+    return {
+        "x-ms-date": date,
+        "x-ms-content-sha256": content_hash,
+        "Authorization": f"HMAC-SHA256 Credential={credential}&SignedHeaders=x-ms-date;host;x-ms-content-sha256"
+                         f"&Signature={signature}",
+    }
+
+async def app_config_get(path: str, label: Optional[str] = APP_CONFIG_LABEL,
+                         connection_string: Optional[str] = APP_CONFIG_CONNECTION_STRING) -> Optional[dict]:
+    """A signed GET of e.g. "/kv/<key>"; None when App Configuration isn't set up or nothing is there."""
+    if not connection_string:
+        return None
+    parts = parse_connection_string(connection_string)
+    url = f"{parts['Endpoint'].rstrip('/')}{path}{'&' if '?' in path else '?'}api-version=1.0"
+    if label:
+        url += f"&label={quote(label, safe='')}"
+    headers = app_config_headers("GET", url, parts["Id"], parts["Secret"], datetime.now(timezone.utc))
+    response = await app_config_client.get(url, headers=headers)
+    if response.status_code == 404:
+        return None
+    response.raise_for_status()
+    return response.json()
+
+async def fetch_app_config(key: str = APP_CONFIG_KEY) -> Optional[dict]:
+    """The JSON value stored under `key`."""
+    item = await app_config_get(f"/kv/{quote(key, safe='')}")
+    return json.loads(item["value"]) if item else None
+
+# --- Settings in use ---
+
+class RuntimeConfig:
+    """The merged, validated settings this replica uses; see the module docstring for the layers."""
+
+    def __init__(self, refresh_interval: int = RUNTIME_CONFIG_REFRESH_SECONDS, path: Optional[str] = RUNTIME_CONFIG_FILE):
+        self.refresh_interval = refresh_interval
+        self.path = path
+        self.settings = RuntimeSettings()
+        self.version = settings_hash(self.settings.model_dump(mode="json"))
+        self.sources: List[str] = ["defaults"]
+        self.loaded_at: Optional[datetime] = None
+        self.last_attempt_at: Optional[datetime] = None
+        self.last_error: Optional[str] = None
+        self.listeners: List[Callable[[], Awaitable[None]]] = []
+        self._file: Tuple[Optional[float], dict] = (None, {})
+        self._lock = asyncio.Lock()
+
+    def on_reload(self, listener: Callable[[], Awaitable[None]]) -> None:
+        """Runs after a reload that changed the settings, and after every admin-triggered reload."""
+        self.listeners.append(listener)
+
+    def sla_minutes(self, department: str) -> Optional[int]:
+        return self.settings.sla_target_minutes.get(department)
+
+    def flag(self, name: str, default: bool = False) -> bool:
+        return self.settings.feature_flags.get(name, default)
+
+    def _read_file(self) -> dict:
+        if not self.path:
+            return {}
+        mtime = os.stat(self.path).st_mtime
+        if mtime != self._file[0]:
+            with open(self.path, encoding="utf-8") as f:
+                self._file = (mtime, json.load(f))
+        return self._file[1]
+
+    async def layers(self) -> List[Tuple[str, dict]]:
+        layers = [("file", self._read_file())]
+        async with DatabaseConnection.get_connection() as conn:
+            doc = await conn["virtualbutler"]["runtime_config"].find_one({"_id": "current"})
+        layers.append(("mongo", (doc or {}).get("settings") or {}))
+        layers.append(("app_configuration", await fetch_app_config() or {}))
+        return [(name, layer) for name, layer in layers if layer]
+
+    async def reload(self, reason: str = "scheduled") -> dict:
+        async with self._lock:
+            self.last_attempt_at = datetime.now(timezone.utc)
+            try:
+                layers = await self.layers()
+                settings = RuntimeSettings(**merge_layers(*(layer for _, layer in layers)))
+            except Exception as e:
+                # Keep serving with the last good settings
+                self.last_error = str(e)
+                logger.error("runtime_config_reload_failed", reason=reason, error=str(e))
+                return self.status()
+            version = settings_hash(settings.model_dump(mode="json"))
+            changed = version != self.version
+            self.settings = settings
+            self.version, self.sources = version, ["defaults", *(name for name, _ in layers)]
+            self.loaded_at, self.last_error = self.last_attempt_at, None
+        if changed:
+            logger.info("runtime_config_reloaded", version=version, sources=self.sources, reason=reason)
+        if changed or reason != "scheduled":
+            for listener in self.listeners:
+                try:
+                    await listener()
+                except Exception as e:
+                    logger.error("runtime_config_listener_failed", error=str(e))
+        return self.status()
+
+    async def refresh_loop(self) -> None:
+        """Call reload() once at startup first; this only picks up later changes."""
+        while True:
+            await asyncio.sleep(self.refresh_interval)
+            await self.reload()
+
+    def status(self) -> dict:
+        return {"replica": REPLICA_ID, "version": self.version, "sources": self.sources, "loaded_at": self.loaded_at,
+                "last_attempt_at": self.last_attempt_at, "last_error": self.last_error,
+                "settings": self.settings.model_dump(mode="json")}
+
+    async def save_overrides(self, overrides: dict, updated_by: Optional[str]) -> dict:
+        """Replaces the Mongo layer, refusing anything that wouldn't validate together with the other layers."""
+        layers = dict(await self.layers())
+        try:
+            RuntimeSettings(**merge_layers(layers.get("file"), overrides, layers.get("app_configuration")))
+        except ValueError as e:
+            raise RuntimeConfigError(f"Invalid settings: {e}", 422)
+        async with DatabaseConnection.get_connection() as conn:
+            await conn["virtualbutler"]["runtime_config"].replace_one(
+                {"_id": "current"},
+                {"settings": overrides, "updated_by": updated_by, "updated_at": datetime.now(timezone.utc)},
+                upsert=True
+            )
+        logger.info("runtime_config_saved", updated_by=updated_by)
+        return await self.reload(reason="admin")
+
+# One per process, shared by the modules that read settings
+runtime_config = RuntimeConfig()
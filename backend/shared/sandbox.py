@@ -0,0 +1,157 @@
+"""
+Sandbox mode, for a hotel soft-launching on production infrastructure: staff train and integrations are
+tested against the real services without any of it counting.
+
+Sandbox is the `sandbox` feature flag, usually turned on per hotel with a hotel override. While it is on:
+- Everything guests and staff create (requests, orders, conversations, notifications, ...) is marked
+  `synthetic: true` as it is written, and requests and orders also carry the `synthetic` tag so the
+  staff app can filter on it. The marking happens in a client wrapper, like field encryption, so no
+  write path can forget it.
+- Alerts and actions that reach past the apps (incident webhooks and emails, printed tickets, department
+  and group digests, the order-completed webhook, commands to room devices) are logged and dropped
+  instead of sent.
+- Synthetic documents are purged every night at SANDBOX_PURGE_AT hotel time; whatever was created
+  before then goes, so each day of training starts clean. The purged orders' event streams go with
+  them, and the dashboard read models are rebuilt so they stop counting them.
+
+Turning the flag off stops the marking; documents already marked are still purged.
+"""
+import asyncio
+import os
+from datetime import datetime, time, timedelta, timezone
+from typing import Dict, Optional
+
+import structlog
+
+from shared import metrics
+from shared.clock import hotel_timezone
+from shared.db.database import DatabaseConnection
+from shared.event_store import EVENTS_COLLECTION
+from shared.feature_flags import feature_flags
+from shared.leases import Lease
+from shared.read_models import rebuild_read_models
+
+logger = structlog.get_logger()
+
+SANDBOX_FLAG = "sandbox"
+SYNTHETIC_TAG = "synthetic"
+SANDBOX_PURGE_AT = os.getenv("SANDBOX_PURGE_AT", "03:00")
+SANDBOX_PURGE_POLL_SECONDS = int(os.getenv("SANDBOX_PURGE_POLL_SECONDS", "900"))
+# Collections whose new documents are marked while sandbox is on; the first two also get the tag
+SANDBOX_COLLECTIONS = ["work_orders", "chat_requests", "work_order_activity", "agent_conversations", "notifications",
+                       "incidents", "wake_up_calls", "transport_requests", "lost_reports", "nps_surveys"]
+TAGGED_COLLECTIONS = {"work_orders", "chat_requests"}
+
+def sandbox_active() -> bool:
+    return feature_flags.is_enabled(SANDBOX_FLAG)
+
+def mark_synthetic(collection: str, document: dict, now: datetime) -> dict:
+    """Marks `document` in place, as Mongo does when it adds the _id."""
+    document["synthetic"] = True
+    document.setdefault("synthetic_at", now)
+    if collection in TAGGED_COLLECTIONS and SYNTHETIC_TAG not in document.get("tags", []):
+        document["tags"] = [*document.get("tags", []), SYNTHETIC_TAG]
+    return document
+
+def suppress_staff_alert(channel: str, **context) -> bool:
+    """True (and logged) when an alert to staff should be dropped because this is a sandbox."""
+    if not sandbox_active():
+        return False
+    metrics.increment("butler_sandbox_alerts_suppressed_total", channel=channel)
+    logger.info("sandbox_staff_alert_suppressed", channel=channel, **context)
+    return True
+
+def purge_cutoff(now: datetime, tz=None, at: str = SANDBOX_PURGE_AT) -> datetime:
+    """The most recent purge time (hotel time `at`) at or before `now`, in UTC."""
+    tz = tz or hotel_timezone()
+    local_now = now.astimezone(tz)
+    hour, minute = (int(part) for part in at.split(":"))
+    cutoff = datetime.combine(local_now.date(), time(hour, minute), tzinfo=tz)
+    if cutoff > local_now:
+        cutoff = datetime.combine(local_now.date() - timedelta(days=1), time(hour, minute), tzinfo=tz)
+    return cutoff.astimezone(timezone.utc)
+
+async def purge_synthetic(now: Optional[datetime] = None) -> Dict[str, int]:
+    """Deletes synthetic documents created before the last purge time; run as often as you like."""
+    cutoff = purge_cutoff(now or datetime.now(timezone.utc))
+    purged = {"synthetic": True, "synthetic_at": {"$lt": cutoff}}
+    counts = {}
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        order_ids = await db["work_orders"].distinct("work_order_id", purged)
+        if order_ids:
+            result = await db[EVENTS_COLLECTION].delete_many({"work_order_id": {"$in": order_ids}})
+            if result.deleted_count:
+                counts[EVENTS_COLLECTION] = result.deleted_count
+        for collection in SANDBOX_COLLECTIONS:
+            result = await db[collection].delete_many(purged)
+            if result.deleted_count:
+                counts[collection] = result.deleted_count
+    if "work_orders" in counts:
+        await rebuild_read_models()
+    if counts:
+        logger.info("sandbox_data_purged", cutoff=cutoff.isoformat(), counts=counts)
+    return counts
+
+sandbox_purge_lease = Lease("sandbox_purge", SANDBOX_PURGE_POLL_SECONDS)
+
+async def sandbox_purge_loop():
+    while True:
+        try:
+            await sandbox_purge_lease.run(purge_synthetic)
+        except Exception as e:
+            logger.error("sandbox_purge_failed", error=str(e))
+        await asyncio.sleep(SANDBOX_PURGE_POLL_SECONDS)
+
+# --- Transparent client wrapper ---
+
+class SandboxCollection:
+    """Marks inserted documents synthetic while sandbox is on; everything else passes through."""
+
+    def __init__(self, collection, name: str):
+        self._coll = collection
+        self._name = name
+
+    def __getattr__(self, name):
+        return getattr(self._coll, name)
+
+    async def insert_one(self, document, *args, **kwargs):
+        if sandbox_active():
+            mark_synthetic(self._name, document, datetime.now(timezone.utc))
+        return await self._coll.insert_one(document, *args, **kwargs)
+
+    async def insert_many(self, documents, *args, **kwargs):
+        if sandbox_active():
+            now = datetime.now(timezone.utc)
+            documents = [mark_synthetic(self._name, d, now) for d in documents]
+        return await self._coll.insert_many(documents, *args, **kwargs)
+
+class _SandboxDatabase:
+    def __init__(self, database):
+        self._db = database
+
+    def __getitem__(self, name):
+        collection = self._db[name]
+        return SandboxCollection(collection, name) if name in SANDBOX_COLLECTIONS else collection
+
+    def __getattr__(self, name):
+        if name.startswith("_") or hasattr(type(self._db), name):
+            return getattr(self._db, name)
+        return self[name]
+
+class SandboxClient:
+    def __init__(self, client):
+        self._client = client
+
+    def __getitem__(self, name):
+        return _SandboxDatabase(self._client[name])
+
+    def __getattr__(self, name):
+        if name.startswith("_") or hasattr(type(self._client), name):
+            return getattr(self._client, name)
+        return self[name]
+
+def install() -> None:
+    """Wraps the client so sandbox writes are marked; call after shared.event_store's install, before encryption."""
+    inner = DatabaseConnection.client_wrapper
+    DatabaseConnection.client_wrapper = lambda client: SandboxClient(inner(client) if inner else client)
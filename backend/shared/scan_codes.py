@@ -0,0 +1,96 @@
+"""
+Scan codes, so staff pull an order up by scanning its ticket rather than searching for it.
+
+Every order has a short code: eight Crockford base32 characters derived from its work_order_id, plus a
+check character, printed in groups (7KQ-2MX-W4D). The QR on the ticket and in notifications carries
+WORK_ORDER_SCAN_URL_TEMPLATE filled with the code, so a phone camera opens it straight in the staff app.
+
+The code is built to survive bad conditions. The check character catches any single mistyped character
+and most swapped pairs, so the app can reject a bad code without a round trip. That lets it queue a scan
+while offline and send the update later with the version it saw. Lowercase, hyphens, spaces and the
+look-alikes I/L (1) and O (0) are all accepted, so a code read off a smudged ticket still works.
+"""
+import hashlib
+import os
+from datetime import datetime
+from typing import Optional
+
+import segno
+import structlog
+from pymongo import UpdateOne
+
+from shared.db.database import DatabaseConnection
+
+logger = structlog.get_logger()
+
+CROCKFORD_ALPHABET = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+LOOKALIKES = str.maketrans({"I": "1", "L": "1", "O": "0", "U": "V"})
+SHORT_CODE_LENGTH = 8
+CLOSED_STATUSES = ("completed", "cancelled")
+WORK_ORDER_SCAN_URL_TEMPLATE = os.getenv("WORK_ORDER_SCAN_URL_TEMPLATE", "https://butler.example.com/staff/scan/{code}")
+
+def check_character(payload: str) -> str:
+    """Luhn mod 32 over the Crockford alphabet."""
+    base = len(CROCKFORD_ALPHABET)
+    total, factor = 0, 2
+    for char in reversed(payload):
+        addend = factor * CROCKFORD_ALPHABET.index(char)
+        total += addend // base + addend % base
+        factor = 1 if factor == 2 else 2
+    return CROCKFORD_ALPHABET[(base - total % base) % base]
+
+def short_code_for(work_order_id: str) -> str:
+    number = int.from_bytes(hashlib.sha256(work_order_id.encode()).digest()[:5], "big")
+    payload = "".join(CROCKFORD_ALPHABET[(number >> shift) & 31] for shift in range(35, -1, -5))
+    return payload + check_character(payload)
+
+def normalize_code(raw: str) -> Optional[str]:
+    """The short code in `raw` (a bare code or a scanned link), or None if it doesn't check out."""
+    code = raw.strip().rstrip("/").rsplit("/", 1)[-1]
+    code = code.upper().replace("-", "").replace(" ", "").translate(LOOKALIKES)
+    if len(code) != SHORT_CODE_LENGTH + 1 or any(c not in CROCKFORD_ALPHABET for c in code):
+        return None
+    return code if check_character(code[:-1]) == code[-1] else None
+
+def format_code(code: str) -> str:
+    return "-".join(code[i:i + 3] for i in range(0, len(code), 3))
+
+def scan_url(code: str) -> str:
+    return WORK_ORDER_SCAN_URL_TEMPLATE.format(code=code)
+
+def qr_svg(code: str) -> str:
+    return segno.make(scan_url(code), error="m").svg_inline(scale=4)
+
+def pick_match(matches: list) -> Optional[dict]:
+    """Two orders can share a code (40 bits); the open one, then the newest, is the one being scanned."""
+    if not matches:
+        return None
+    return max(matches, key=lambda doc: (str(doc.get("status")) not in CLOSED_STATUSES, doc.get("created_at") or datetime.min))
+
+async def find_by_code(code: str) -> Optional[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        matches = await conn["virtualbutler"]["work_orders"].find({"short_code": code}).to_list(length=10)
+    return pick_match(matches)
+
+async def backfill_short_codes(batch_size: int = 500) -> int:
+    """Stores codes on orders created before scan codes; their code is the one they already show."""
+    count = 0
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["work_orders"]
+        cursor = coll.find({"short_code": None}, {"work_order_id": 1})
+        updates = []
+        async for doc in cursor:
+            updates.append(UpdateOne({"_id": doc["_id"]},
+                                     {"$set": {"short_code": short_code_for(doc["work_order_id"])}}))
+            if len(updates) >= batch_size:
+                count += (await coll.bulk_write(updates, ordered=False)).modified_count
+                updates = []
+        if updates:
+            count += (await coll.bulk_write(updates, ordered=False)).modified_count
+    if count:
+        logger.info("short_codes_backfilled", count=count)
+    return count
+
+async def ensure_scan_code_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["work_orders"].create_index("short_code", sparse=True)
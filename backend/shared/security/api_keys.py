@@ -0,0 +1,306 @@
+"""
+API keys for machine-to-machine integrations (POS, PMS, kiosks) that can't obtain a guest or staff
+JWT. Stored in Mongo (`api_keys`); only a hash of each key is kept, and the plaintext is returned
+once, at issuance or rotation.
+
+- A key looks like `vb_<key_id>_<secret>` and is sent as `X-API-Key` (or as a bearer token).
+- Keys carry scopes ("work_orders:write", "notifications:*"); endpoints name the scope they need.
+- Rotating a key issues a new secret; the old one keeps working for API_KEY_ROTATION_GRACE_HOURS
+  so the integration can be reconfigured without downtime. Revocation takes effect once each
+  service refreshes its key cache (API_KEY_REFRESH_SECONDS).
+- Each key has its own per-minute rate limit; usage is counted per minute in `api_key_usage`.
+"""
+import asyncio
+import hashlib
+import hmac
+import os
+import secrets
+from datetime import datetime, timedelta, timezone
+from enum import Enum
+from typing import Dict, List, Optional
+
+import structlog
+from fastapi import Depends, HTTPException, Request
+from fastapi.security import APIKeyHeader, HTTPAuthorizationCredentials, HTTPBearer
+from jose.exceptions import JWTError
+from pydantic import BaseModel, Field
+from pymongo import ReturnDocument
+
+from shared import metrics
+from shared.db.database import DatabaseConnection
+
+logger = structlog.get_logger()
+
+API_KEY_REFRESH_SECONDS = int(os.getenv("API_KEY_REFRESH_SECONDS", "15"))
+API_KEY_ROTATION_GRACE_HOURS = int(os.getenv("API_KEY_ROTATION_GRACE_HOURS", "24"))
+API_KEY_DEFAULT_RATE_LIMIT = int(os.getenv("API_KEY_DEFAULT_RATE_LIMIT", "120"))
+API_KEY_USAGE_RETENTION_DAYS = int(os.getenv("API_KEY_USAGE_RETENTION_DAYS", "90"))
+API_KEY_PREFIX = "vb_"
+INTEGRATION_ROLE = "integration"
+
+SCOPES = {
+    "work_orders:read",
+    "work_orders:write",
+    "rooms:write",
+    "notifications:write",
+    "stays:write",
+    "guests:write",
+}
+
+class ApiKeyStatusEnum(str, Enum):
+    ACTIVE = "active"
+    REVOKED = "revoked"
+
+class ApiKey(BaseModel):
+    key_id: str
+    name: str
+    integration: str
+    scopes: List[str]
+    rate_limit_per_minute: int = API_KEY_DEFAULT_RATE_LIMIT
+    status: ApiKeyStatusEnum = ApiKeyStatusEnum.ACTIVE
+    key_hash: str
+    previous_key_hash: Optional[str] = None
+    previous_valid_until: Optional[datetime] = None
+    expires_at: Optional[datetime] = None
+    created_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+    created_by: Optional[str] = None
+    rotated_at: Optional[datetime] = None
+    revoked_at: Optional[datetime] = None
+    revoked_reason: Optional[str] = None
+    last_used_at: Optional[datetime] = None
+
+    def public_view(self) -> dict:
+        return self.model_dump(exclude={"key_hash", "previous_key_hash"})
+
+class ApiKeyError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+def hash_key(raw: str) -> str:
+    # The secret is random and long, so a plain digest is enough; no need for a slow password hash
+    return hashlib.sha256(raw.encode()).hexdigest()
+
+def generate_key(key_id: str) -> str:
+    return f"{API_KEY_PREFIX}{key_id}_{secrets.token_urlsafe(32)}"
+
+def parse_key_id(raw: str) -> Optional[str]:
+    if not raw or not raw.startswith(API_KEY_PREFIX):
+        return None
+    parts = raw.split("_", 2)
+    return parts[1] if len(parts) == 3 and parts[1] and parts[2] else None
+
+def has_scope(granted: List[str], required: str) -> bool:
+    """`*` grants everything and `resource:*` every action on that resource."""
+    resource = required.split(":", 1)[0]
+    return any(s in ("*", required, f"{resource}:*") for s in granted)
+
+def validate_scopes(scopes: List[str]) -> List[str]:
+    resources = {s.split(":", 1)[0] for s in SCOPES}
+    unknown = [s for s in scopes if s not in SCOPES and not (s.endswith(":*") and s[:-2] in resources)]
+    if unknown:
+        raise ApiKeyError(f"Unknown scope(s): {', '.join(unknown)}", 422)
+    if not scopes:
+        raise ApiKeyError("An API key needs at least one scope", 422)
+    return sorted(set(scopes))
+
+def matches_key(key: ApiKey, raw: str, now: datetime) -> bool:
+    digest = hash_key(raw)
+    if hmac.compare_digest(digest, key.key_hash):
+        return True
+    return bool(key.previous_key_hash and key.previous_valid_until and key.previous_valid_until > now
+                and hmac.compare_digest(digest, key.previous_key_hash))
+
+def principal_for(key: ApiKey) -> dict:
+    """Shaped like a JWT payload so handlers can treat both callers alike."""
+    return {"sub": f"apikey:{key.key_id}", "role": INTEGRATION_ROLE, "scopes": key.scopes,
+            "integration": key.integration, "key_id": key.key_id}
+
+class ApiKeyRing:
+    def __init__(self):
+        self.keys: Dict[str, ApiKey] = {}
+
+    async def refresh(self) -> None:
+        async with DatabaseConnection.get_connection() as conn:
+            docs = await conn["virtualbutler"]["api_keys"].find({"status": ApiKeyStatusEnum.ACTIVE.value}).to_list(length=None)
+        self.keys = {doc["key_id"]: ApiKey(**doc) for doc in docs}
+
+    async def refresh_loop(self) -> None:
+        while True:
+            await asyncio.sleep(API_KEY_REFRESH_SECONDS)
+            try:
+                await self.refresh()
+            except Exception as e:
+                logger.error("api_keys_refresh_failed", error=str(e))
+
+    async def _lookup(self, key_id: str, cached: bool = True) -> Optional[ApiKey]:
+        key = self.keys.get(key_id) if cached else None
+        if key is None:
+            # Issued or rotated on another replica since the last refresh
+            async with DatabaseConnection.get_connection() as conn:
+                doc = await conn["virtualbutler"]["api_keys"].find_one(
+                    {"key_id": key_id, "status": ApiKeyStatusEnum.ACTIVE.value}
+                )
+            if doc:
+                key = self.keys[key_id] = ApiKey(**doc)
+            else:
+                self.keys.pop(key_id, None)
+        return key
+
+    async def authenticate(self, raw: str) -> ApiKey:
+        key_id = parse_key_id(raw)
+        key = await self._lookup(key_id) if key_id else None
+        now = datetime.now(timezone.utc)
+        if key is not None and not matches_key(key, raw, now):
+            key = await self._lookup(key_id, cached=False)
+        if key is None or not matches_key(key, raw, now):
+            metrics.increment("butler_api_key_requests_total", outcome="invalid")
+            raise ApiKeyError("Invalid API key", 401)
+        if key.expires_at and key.expires_at <= now:
+            metrics.increment("butler_api_key_requests_total", key_id=key.key_id, outcome="expired")
+            raise ApiKeyError("API key has expired", 401)
+        count = await record_usage(key, now)
+        if count > key.rate_limit_per_minute:
+            metrics.increment("butler_api_key_requests_total", key_id=key.key_id, outcome="throttled")
+            raise ApiKeyError("API key rate limit exceeded", 429)
+        metrics.increment("butler_api_key_requests_total", key_id=key.key_id, outcome="allowed")
+        return key
+
+# --- Usage ---
+
+async def record_usage(key: ApiKey, now: datetime) -> int:
+    """Counts the request in the key's current one-minute window and returns the window's total."""
+    window = now.replace(second=0, microsecond=0)
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        doc = await db["api_key_usage"].find_one_and_update(
+            {"key_id": key.key_id, "window": window},
+            {"$inc": {"count": 1},
+             "$setOnInsert": {"expires_at": window + timedelta(days=API_KEY_USAGE_RETENTION_DAYS)}},
+            upsert=True, return_document=ReturnDocument.AFTER
+        )
+        if doc["count"] > key.rate_limit_per_minute:
+            await db["api_key_usage"].update_one({"_id": doc["_id"]}, {"$inc": {"throttled": 1}})
+        elif doc["count"] == 1:
+            # Once per window is precise enough for "last used" and keeps writes off the key document
+            await db["api_keys"].update_one({"key_id": key.key_id}, {"$max": {"last_used_at": now}})
+    return doc["count"]
+
+async def key_usage(key_id: str, days: int = 7) -> List[dict]:
+    """Requests and throttled requests per day, oldest first."""
+    since = datetime.now(timezone.utc) - timedelta(days=days)
+    async with DatabaseConnection.get_connection() as conn:
+        rows = await conn["virtualbutler"]["api_key_usage"].aggregate([
+            {"$match": {"key_id": key_id, "window": {"$gte": since}}},
+            {"$group": {"_id": {"$dateToString": {"format": "%Y-%m-%d", "date": "$window"}},
+                        "requests": {"$sum": "$count"}, "throttled": {"$sum": {"$ifNull": ["$throttled", 0]}},
+                        "peak_per_minute": {"$max": "$count"}}},
+            {"$sort": {"_id": 1}}
+        ]).to_list(length=None)
+    return [{"date": row.pop("_id"), **row} for row in rows]
+
+# --- Key administration ---
+
+async def list_api_keys() -> List[ApiKey]:
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["api_keys"].find().sort("created_at", -1).to_list(length=None)
+    return [ApiKey(**doc) for doc in docs]
+
+async def issue_api_key(name: str, integration: str, scopes: List[str], rate_limit_per_minute: Optional[int] = None,
+                        expires_at: Optional[datetime] = None, created_by: Optional[str] = None) -> tuple:
+    """Returns (key, plaintext); the plaintext is not stored and can't be shown again."""
+    key_id = secrets.token_hex(6)
+    if expires_at and expires_at.tzinfo:
+        expires_at = expires_at.astimezone(timezone.utc).replace(tzinfo=None)
+    raw = generate_key(key_id)
+    key = ApiKey(key_id=key_id, name=name, integration=integration.lower(), scopes=validate_scopes(scopes),
+                 rate_limit_per_minute=rate_limit_per_minute or API_KEY_DEFAULT_RATE_LIMIT,
+                 key_hash=hash_key(raw), expires_at=expires_at, created_by=created_by)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["api_keys"].insert_one(key.model_dump())
+    logger.info("api_key_issued", key_id=key_id, integration=key.integration, scopes=key.scopes, created_by=created_by)
+    return key, raw
+
+async def rotate_api_key(key_id: str, rotated_by: Optional[str] = None) -> tuple:
+    """New secret for an active key; the previous one stays valid for the grace period."""
+    raw = generate_key(key_id)
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["api_keys"]
+        current = await coll.find_one({"key_id": key_id, "status": ApiKeyStatusEnum.ACTIVE.value})
+        if not current:
+            raise ApiKeyError(f"API key '{key_id}' not found or revoked", 404)
+        doc = await coll.find_one_and_update(
+            {"key_id": key_id, "key_hash": current["key_hash"]},
+            {"$set": {"key_hash": hash_key(raw), "previous_key_hash": current["key_hash"],
+                      "previous_valid_until": now + timedelta(hours=API_KEY_ROTATION_GRACE_HOURS),
+                      "rotated_at": now}},
+            return_document=ReturnDocument.AFTER
+        )
+    if not doc:
+        raise ApiKeyError("Another rotation is in progress; retry shortly", 409)
+    logger.info("api_key_rotated", key_id=key_id, rotated_by=rotated_by)
+    return ApiKey(**doc), raw
+
+async def revoke_api_key(key_id: str, reason: Optional[str] = None, revoked_by: Optional[str] = None) -> ApiKey:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["api_keys"].find_one_and_update(
+            {"key_id": key_id, "status": ApiKeyStatusEnum.ACTIVE.value},
+            {"$set": {"status": ApiKeyStatusEnum.REVOKED.value, "revoked_at": datetime.now(timezone.utc), "revoked_reason": reason}},
+            return_document=ReturnDocument.AFTER
+        )
+    if not doc:
+        raise ApiKeyError(f"API key '{key_id}' not found or already revoked", 404)
+    logger.warning("api_key_revoked", key_id=key_id, reason=reason, revoked_by=revoked_by)
+    return ApiKey(**doc)
+
+async def ensure_api_key_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["api_keys"].create_index("key_id", unique=True)
+        await conn["virtualbutler"]["api_key_usage"].create_index([("key_id", 1), ("window", 1)], unique=True)
+        await conn["virtualbutler"]["api_key_usage"].create_index("expires_at", expireAfterSeconds=0)
+
+# --- Request authentication ---
+
+bearer = HTTPBearer(auto_error=False)
+api_key_header = APIKeyHeader(name="X-API-Key", auto_error=False)
+
+class Authenticator:
+    """
+    FastAPI dependencies accepting either a bearer JWT or an integration API key. JWT callers are
+    checked by role as before; API-key callers only by the scope the endpoint asks for.
+    """
+    def __init__(self, key_ring, api_keys: ApiKeyRing):
+        self.key_ring = key_ring
+        self.api_keys = api_keys
+
+    async def principal(self, request: Request,
+                        credentials: Optional[HTTPAuthorizationCredentials] = Depends(bearer),
+                        api_key: Optional[str] = Depends(api_key_header)) -> dict:
+        token = credentials.credentials if credentials else None
+        raw = api_key or (token if token and token.startswith(API_KEY_PREFIX) else None)
+        if raw:
+            try:
+                key = await self.api_keys.authenticate(raw)
+            except ApiKeyError as e:
+                headers = {"Retry-After": "60"} if e.status_code == 429 else None
+                raise HTTPException(e.status_code, detail=str(e), headers=headers)
+            request.state.api_key_id = key.key_id
+            return principal_for(key)
+        if not token:
+            raise HTTPException(status_code=401, detail="Not authenticated")
+        try:
+            return await self.key_ring.verify(token)
+        except JWTError:
+            raise HTTPException(status_code=401, detail="Invalid or expired token")
+
+    def require(self, scope: str, roles: Optional[tuple] = ("staff", "admin")):
+        """roles=None lets any valid JWT through, e.g. for endpoints guests already use."""
+        async def dependency(payload: dict = Depends(self.principal)) -> dict:
+            if payload.get("role") == INTEGRATION_ROLE:
+                if not has_scope(payload.get("scopes", []), scope):
+                    raise HTTPException(status_code=403, detail=f"API key lacks the '{scope}' scope")
+            elif roles is not None and payload.get("role") not in roles:
+                raise HTTPException(status_code=403, detail="Insufficient privileges")
+            return payload
+        return dependency
@@ -0,0 +1,188 @@
+"""
+Device tokens for lobby kiosks and in-room tablets, which can't hold a guest JWT.
+
+Admins register a device and get its token once (`vbd_<device_id>_<secret>`, sent to the chatbot as a
+bearer token); only a hash is kept. Two kinds:
+- kiosk: a shared lobby device. It acts as itself (`device:<device_id>`), never as a guest.
+- tablet: bound to a room. The room identifies the requester: each request acts for the guest
+  currently staying there (shared/rooms.py linked rooms included), as if they had logged in.
+  With nobody checked in, the tablet can read its session but not make requests.
+
+Each device has a session (`session_id`) that chat requests are filed under. A tablet's session
+resets whenever the room's guest changes, so it ends at checkout: once the guest's check_out_date
+passes (set by POST /api/v1/stays/checkout, or the PMS date itself) the room has nobody in it and
+the next request starts a fresh session. The next guest never sees the previous guest's conversation.
+The tablet polls GET /api/v1/device/session and clears its screen when the session_id changes.
+"""
+import hashlib
+import hmac
+import secrets
+import uuid
+from datetime import datetime, timezone
+from enum import Enum
+from typing import List, Optional
+
+import structlog
+from pydantic import BaseModel, Field
+from pymongo import ReturnDocument
+
+from shared.db.database import DatabaseConnection
+
+logger = structlog.get_logger()
+
+DEVICE_TOKEN_PREFIX = "vbd_"
+DEVICE_ROLE = "kiosk"
+
+class DeviceKindEnum(str, Enum):
+    KIOSK = "kiosk"
+    TABLET = "tablet"
+
+class DeviceStatusEnum(str, Enum):
+    ACTIVE = "active"
+    REVOKED = "revoked"
+
+class DeviceError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+class DeviceRegistration(BaseModel):
+    name: str = Field(..., min_length=1, max_length=80, description="e.g. Lobby kiosk 2")
+    kind: DeviceKindEnum
+    room_number: Optional[str] = Field(None, description="Required for tablets; kiosks have none")
+
+class Device(DeviceRegistration):
+    device_id: str
+    status: DeviceStatusEnum = DeviceStatusEnum.ACTIVE
+    token_hash: str
+    session_id: Optional[str] = None
+    session_guest_id: Optional[str] = None
+    session_started_at: Optional[datetime] = None
+    created_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+    created_by: Optional[str] = None
+    last_seen_at: Optional[datetime] = None
+    revoked_at: Optional[datetime] = None
+
+    def public_view(self) -> dict:
+        return self.model_dump(exclude={"token_hash"})
+
+def hash_token(raw: str) -> str:
+    return hashlib.sha256(raw.encode()).hexdigest()
+
+def parse_device_id(raw: str) -> Optional[str]:
+    if not raw or not raw.startswith(DEVICE_TOKEN_PREFIX):
+        return None
+    parts = raw.split("_", 2)
+    return parts[1] if len(parts) == 3 and parts[1] and parts[2] else None
+
+def check_registration(kind: str, room_number: Optional[str]) -> None:
+    if kind == DeviceKindEnum.TABLET and not room_number:
+        raise DeviceError("A tablet must be bound to a room", 422)
+    if kind == DeviceKindEnum.KIOSK and room_number:
+        raise DeviceError("A kiosk is not bound to a room", 422)
+
+def session_needs_reset(device: Device, guest_id: Optional[str]) -> bool:
+    return not device.session_id or device.session_guest_id != guest_id
+
+def device_principal(device: Device, guest_id: Optional[str]) -> dict:
+    """Shaped like a JWT payload; a tablet acts as the room's guest, a kiosk as itself."""
+    principal = {"device_id": device.device_id, "device_kind": device.kind, "session_id": device.session_id}
+    if device.kind == DeviceKindEnum.KIOSK:
+        return principal | {"sub": f"device:{device.device_id}", "role": DEVICE_ROLE}
+    return principal | {"sub": guest_id, "role": "guest", "room": device.room_number}
+
+# --- Authentication ---
+
+async def current_guest(room_number: str, now: datetime) -> Optional[str]:
+    """The guest staying in the room now, if any."""
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["guest_profiles"].find(
+            {"$or": [{"room_number": room_number}, {"rooms": room_number}],
+             "check_out_date": {"$not": {"$lte": now}}, "check_in_date": {"$not": {"$gt": now}}},
+            {"_id": 0, "guest_id": 1}
+        ).sort("check_in_date", -1).limit(1).to_list(length=1)
+    return docs[0]["guest_id"] if docs else None
+
+async def start_session(device: Device, guest_id: Optional[str], now: datetime) -> Device:
+    async with DatabaseConnection.get_connection() as conn:
+        devices = conn["virtualbutler"]["devices"]
+        doc = await devices.find_one_and_update(
+            {"device_id": device.device_id, "session_id": device.session_id},
+            {"$set": {"session_id": f"dev_{uuid.uuid4().hex[:16]}", "session_guest_id": guest_id,
+                      "session_started_at": now}},
+            projection={"_id": 0}, return_document=ReturnDocument.AFTER
+        )
+        if doc is None:
+            # A concurrent request from the device started it first
+            return Device(**await devices.find_one({"device_id": device.device_id}, {"_id": 0}))
+    logger.info("device_session_started", device_id=device.device_id, room_number=device.room_number,
+                guest_id=guest_id)
+    return Device(**doc)
+
+async def authenticate_device(raw: str) -> dict:
+    device_id = parse_device_id(raw)
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["devices"].find_one(
+            {"device_id": device_id, "status": DeviceStatusEnum.ACTIVE.value}, {"_id": 0}
+        ) if device_id else None
+    if not doc or not hmac.compare_digest(hash_token(raw), doc["token_hash"]):
+        raise DeviceError("Invalid device token", 401)
+    device, now = Device(**doc), datetime.now(timezone.utc)
+    guest_id = await current_guest(device.room_number, now) if device.kind == DeviceKindEnum.TABLET else None
+    if session_needs_reset(device, guest_id):
+        # Someone new in the room, the room emptied at checkout, or an admin reset
+        device = await start_session(device, guest_id, now)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["devices"].update_one({"device_id": device.device_id},
+                                                          {"$set": {"last_seen_at": now}})
+    return device_principal(device, guest_id)
+
+# --- Device administration ---
+
+async def list_devices(include_revoked: bool = False) -> List[Device]:
+    query = {} if include_revoked else {"status": DeviceStatusEnum.ACTIVE.value}
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["devices"].find(query, {"_id": 0}).sort("name", 1).to_list(length=None)
+    return [Device(**doc) for doc in docs]
+
+async def register_device(registration: DeviceRegistration, created_by: Optional[str] = None) -> tuple:
+    """Returns (device, token); the token is not stored and can't be shown again."""
+    check_registration(registration.kind, registration.room_number)
+    device_id = secrets.token_hex(6)
+    raw = f"{DEVICE_TOKEN_PREFIX}{device_id}_{secrets.token_urlsafe(32)}"
+    device = Device(device_id=device_id, token_hash=hash_token(raw), created_by=created_by,
+                    **registration.model_dump())
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["devices"].insert_one(device.model_dump(mode="json") | {"created_at": device.created_at})
+    logger.info("device_registered", device_id=device_id, kind=device.kind, room_number=device.room_number,
+                created_by=created_by)
+    return device, raw
+
+async def revoke_device(device_id: str, revoked_by: Optional[str] = None) -> Device:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["devices"].find_one_and_update(
+            {"device_id": device_id, "status": DeviceStatusEnum.ACTIVE.value},
+            {"$set": {"status": DeviceStatusEnum.REVOKED.value, "revoked_at": datetime.now(timezone.utc),
+                      "session_id": None, "session_guest_id": None}},
+            projection={"_id": 0}, return_document=ReturnDocument.AFTER
+        )
+    if not doc:
+        raise DeviceError(f"Device '{device_id}' not found or already revoked", 404)
+    logger.warning("device_revoked", device_id=device_id, revoked_by=revoked_by)
+    return Device(**doc)
+
+async def reset_device_session(device_id: str) -> None:
+    """Ends the current session; the device starts a new one on its next request."""
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["devices"].update_one(
+            {"device_id": device_id, "status": DeviceStatusEnum.ACTIVE.value},
+            {"$set": {"session_id": None, "session_guest_id": None}}
+        )
+    if not result.matched_count:
+        raise DeviceError(f"Device '{device_id}' not found or revoked", 404)
+    logger.info("device_session_reset", device_id=device_id)
+
+async def ensure_device_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["devices"].create_index("device_id", unique=True)
+        await conn["virtualbutler"]["devices"].create_index("room_number", sparse=True)
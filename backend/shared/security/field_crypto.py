@@ -0,0 +1,415 @@
+"""
+Field-level encryption at rest for guest PII and free-text requests.
+
+- Designated fields (ENCRYPTED_FIELDS) are encrypted with AES-256-GCM before they reach Mongo and
+  decrypted on the way out, by wrapping the client DatabaseConnection hands out. Handlers keep
+  reading and writing plain values.
+- Each value is stored as `enc:1:<kid>:<base64 nonce+ciphertext>`, bound to its collection and field
+  so a ciphertext can't be copied into another field. Plaintext written before encryption was
+  enabled still reads fine until reencrypt_collection migrates it.
+- Data keys live in `field_keys`, wrapped by a key-encryption key: a local master key
+  (FIELD_ENCRYPTION_MASTER_KEY) or an Azure Key Vault key (FIELD_ENCRYPTION_KEYVAULT_KEY_ID).
+- Rotation adds a new data key for new writes; old keys keep decrypting until re-encryption has
+  moved everything onto the new one. Changing the master key only needs the data keys rewrapped.
+
+Encrypted fields can't be matched in queries (e.g. guest search by name); lookups use IDs instead.
+"""
+import asyncio
+import base64
+import hashlib
+import os
+import secrets
+from datetime import datetime, timezone
+from typing import Callable, Dict, List, Optional
+
+import structlog
+from cryptography.hazmat.primitives.ciphers.aead import AESGCM
+from pymongo.errors import DuplicateKeyError
+
+from shared.db.database import DatabaseConnection
+
+logger = structlog.get_logger()
+
+FIELD_ENCRYPTION_ENABLED = os.getenv("FIELD_ENCRYPTION_ENABLED", "false").lower() == "true"
+FIELD_ENCRYPTION_MASTER_KEY = os.getenv("FIELD_ENCRYPTION_MASTER_KEY")   # base64, 32 bytes
+FIELD_ENCRYPTION_PREVIOUS_MASTER_KEYS = [k for k in os.getenv("FIELD_ENCRYPTION_PREVIOUS_MASTER_KEYS", "").split(",") if k]
+FIELD_ENCRYPTION_KEYVAULT_KEY_ID = os.getenv("FIELD_ENCRYPTION_KEYVAULT_KEY_ID")
+FIELD_KEY_REFRESH_SECONDS = int(os.getenv("FIELD_KEY_REFRESH_SECONDS", "60"))
+
+PREFIX = "enc:1:"
+ENCRYPTED_FIELDS: Dict[str, tuple] = {
+    "guest_profiles": ("name", "phone"),
+    "chat_requests": ("message", "voice_transcript", "guest_profile.name", "guest_profile.phone", "edit_history.message"),
+    "work_orders": ("description", "metadata.edit_history.message"),
+    "agent_conversations": ("messages.text",),
+    "audit_logs": ("data.message", "data.voice_transcript", "data.guest_profile.name", "data.guest_profile.phone"),
+    "jwt_keys": ("secret",),
+}
+
+class FieldEncryptionError(Exception): pass
+class UnknownFieldKeyError(FieldEncryptionError): pass
+
+# --- Document traversal ---
+
+def _apply(obj, parts: List[str], fn: Callable, path: str):
+    if isinstance(obj, list):
+        return [_apply(item, parts, fn, path) for item in obj]
+    if not isinstance(obj, dict) or parts[0] not in obj:
+        return obj
+    out = dict(obj)
+    if len(parts) == 1:
+        if isinstance(out[parts[0]], str):
+            out[parts[0]] = fn(out[parts[0]], path)
+    else:
+        out[parts[0]] = _apply(out[parts[0]], parts[1:], fn, path)
+    return out
+
+def transform_document(doc, paths, fn: Callable):
+    """Returns a copy of doc with fn(value, path) applied to every string at the given dotted paths."""
+    for path in paths:
+        doc = _apply(doc, path.split("."), fn, path)
+    return doc
+
+def _transform_fields(fields: dict, paths, fn: Callable, pushed: bool = False) -> dict:
+    out = {}
+    for key, value in fields.items():
+        for path in paths:
+            if key == path and isinstance(value, str):
+                value = fn(value, path)
+            elif path.startswith(key + "."):
+                rest = path[len(key) + 1:].split(".")
+                if pushed and isinstance(value, dict) and "$each" in value:
+                    value = {**value, "$each": [_apply(v, rest, fn, path) for v in value["$each"]]}
+                else:
+                    value = _apply(value, rest, fn, path)
+        out[key] = value
+    return out
+
+def transform_update(update, paths, fn: Callable):
+    """Applies fn to designated values in $set/$setOnInsert/$push; aggregation-pipeline updates pass through."""
+    if not isinstance(update, dict):
+        return update
+    out = dict(update)
+    for op in ("$set", "$setOnInsert"):
+        if op in out:
+            out[op] = _transform_fields(out[op], paths, fn)
+    if "$push" in out:
+        out["$push"] = _transform_fields(out["$push"], paths, fn, pushed=True)
+    return out
+
+def key_id_of(value: str) -> Optional[str]:
+    return value[len(PREFIX):].split(":", 1)[0] if value.startswith(PREFIX) else None
+
+# --- Key-encryption keys ---
+
+class LocalKek:
+    """AES-GCM wrap under a master key from the environment; kek_id is a fingerprint, never the key."""
+    def __init__(self, key_b64: str):
+        self.key = base64.b64decode(key_b64)
+        if len(self.key) != 32:
+            raise FieldEncryptionError("Master keys must be 32 bytes, base64-encoded")
+        self.kek_id = "local:" + hashlib.sha256(self.key).hexdigest()[:16]
+
+    async def wrap(self, dek: bytes) -> bytes:
+        nonce = secrets.token_bytes(12)
+        return nonce + AESGCM(self.key).encrypt(nonce, dek, self.kek_id.encode())
+
+    async def unwrap(self, wrapped: bytes) -> bytes:
+        return AESGCM(self.key).decrypt(wrapped[:12], wrapped[12:], self.kek_id.encode())
+
+class KeyVaultKek:
+    """RSA-OAEP-256 wrap with an Azure Key Vault key; the key never leaves the vault."""
+    def __init__(self, key_id: str):
+        self.kek_id = key_id
+
+    def _client(self):
+        from azure.identity.aio import DefaultAzureCredential
+        from azure.keyvault.keys.crypto.aio import CryptographyClient
+        return CryptographyClient(self.kek_id, DefaultAzureCredential())
+
+    async def wrap(self, dek: bytes) -> bytes:
+        from azure.keyvault.keys.crypto import KeyWrapAlgorithm
+        async with self._client() as client:
+            return (await client.wrap_key(KeyWrapAlgorithm.rsa_oaep_256, dek)).encrypted_key
+
+    async def unwrap(self, wrapped: bytes) -> bytes:
+        from azure.keyvault.keys.crypto import KeyWrapAlgorithm
+        async with self._client() as client:
+            return (await client.unwrap_key(KeyWrapAlgorithm.rsa_oaep_256, wrapped)).key
+
+def current_kek():
+    if FIELD_ENCRYPTION_KEYVAULT_KEY_ID:
+        return KeyVaultKek(FIELD_ENCRYPTION_KEYVAULT_KEY_ID)
+    if FIELD_ENCRYPTION_MASTER_KEY:
+        return LocalKek(FIELD_ENCRYPTION_MASTER_KEY)
+    return None
+
+def kek_for(kek_id: str):
+    """The KEK a data key was wrapped with: the current one, a previous local key, or a vault key version."""
+    candidates = [current_kek()] + [LocalKek(k) for k in FIELD_ENCRYPTION_PREVIOUS_MASTER_KEYS]
+    for kek in candidates:
+        if kek and kek.kek_id == kek_id:
+            return kek
+    if kek_id.startswith("https://"):
+        return KeyVaultKek(kek_id)
+    raise FieldEncryptionError(f"No key-encryption key available for {kek_id}")
+
+# --- Data keys & cipher ---
+
+class FieldCipher:
+    def __init__(self):
+        self.keys: Dict[str, bytes] = {}
+        self.active_kid: Optional[str] = None
+        self.enabled = False
+
+    def encrypt(self, value: str, aad: str) -> str:
+        if value.startswith(PREFIX):
+            return value
+        if not self.active_kid:
+            raise FieldEncryptionError("No active field-encryption key")
+        nonce = secrets.token_bytes(12)
+        sealed = AESGCM(self.keys[self.active_kid]).encrypt(nonce, value.encode(), aad.encode())
+        return f"{PREFIX}{self.active_kid}:{base64.b64encode(nonce + sealed).decode()}"
+
+    def decrypt(self, value: str, aad: str) -> str:
+        kid = key_id_of(value)
+        if kid is None:
+            return value
+        if kid not in self.keys:
+            raise UnknownFieldKeyError(kid)
+        blob = base64.b64decode(value[len(PREFIX) + len(kid) + 1:])
+        return AESGCM(self.keys[kid]).decrypt(blob[:12], blob[12:], aad.encode()).decode()
+
+    def encrypt_document(self, collection: str, doc):
+        return transform_document(doc, ENCRYPTED_FIELDS[collection], lambda v, p: self.encrypt(v, f"{collection}.{p}"))
+
+    def encrypt_update(self, collection: str, update):
+        return transform_update(update, ENCRYPTED_FIELDS[collection], lambda v, p: self.encrypt(v, f"{collection}.{p}"))
+
+    async def decrypt_document(self, collection: str, doc):
+        if doc is None:
+            return None
+        decrypt = lambda v, p: self.decrypt(v, f"{collection}.{p}")
+        try:
+            return transform_document(doc, ENCRYPTED_FIELDS[collection], decrypt)
+        except UnknownFieldKeyError:
+            # Another replica rotated since our last refresh
+            await self.refresh()
+            return transform_document(doc, ENCRYPTED_FIELDS[collection], decrypt)
+
+    async def refresh(self) -> None:
+        async with DatabaseConnection.get_connection() as conn:
+            docs = await conn["virtualbutler"]["field_keys"].find().sort("generation", 1).to_list(length=None)
+        for doc in docs:
+            if doc["kid"] not in self.keys:
+                self.keys[doc["kid"]] = await kek_for(doc["kek_id"]).unwrap(base64.b64decode(doc["wrapped_key"]))
+        active = [d["kid"] for d in docs if d["status"] == "active"]
+        self.active_kid = active[-1] if active else None
+
+    async def refresh_loop(self) -> None:
+        while self.enabled:
+            await asyncio.sleep(FIELD_KEY_REFRESH_SECONDS)
+            try:
+                await self.refresh()
+            except Exception as e:
+                logger.error("field_keys_refresh_failed", error=str(e))
+
+    async def start(self) -> None:
+        """Loads the data keys (creating the first one) and starts encrypting; call after DatabaseConnection.connect."""
+        if not FIELD_ENCRYPTION_ENABLED:
+            return
+        if current_kek() is None:
+            raise FieldEncryptionError("FIELD_ENCRYPTION_ENABLED needs FIELD_ENCRYPTION_MASTER_KEY or FIELD_ENCRYPTION_KEYVAULT_KEY_ID")
+        async with DatabaseConnection.get_connection() as conn:
+            await conn["virtualbutler"]["field_keys"].create_index("kid", unique=True)
+            await conn["virtualbutler"]["field_keys"].create_index("generation", unique=True)
+        await self.refresh()
+        if not self.active_kid:
+            await rotate_field_key(created_by="bootstrap")
+            await self.refresh()
+        self.enabled = True
+        # Encrypt outermost, so any wrapper already installed (shared.event_store) only sees ciphertext
+        inner = DatabaseConnection.client_wrapper
+        DatabaseConnection.client_wrapper = lambda client: EncryptingClient(inner(client) if inner else client, self)
+        asyncio.create_task(self.refresh_loop())
+        logger.info("field_encryption_enabled", active_kid=self.active_kid, kek=current_kek().kek_id)
+
+field_cipher = FieldCipher()
+
+# --- Transparent client wrapper ---
+
+class _DecryptingCursor:
+    def __init__(self, cursor, decrypt: Callable):
+        self._cursor = cursor
+        self._decrypt = decrypt
+
+    def __getattr__(self, name):
+        attr = getattr(self._cursor, name)
+        if not callable(attr):
+            return attr
+        def chained(*args, **kwargs):
+            result = attr(*args, **kwargs)
+            return self if result is self._cursor else result
+        return chained
+
+    def __aiter__(self):
+        return self
+
+    async def __anext__(self):
+        return await self._decrypt(await self._cursor.__anext__())
+
+    async def next(self):
+        return await self.__anext__()
+
+    async def to_list(self, *args, **kwargs):
+        return [await self._decrypt(doc) for doc in await self._cursor.to_list(*args, **kwargs)]
+
+class EncryptingCollection:
+    def __init__(self, collection, name: str, cipher: FieldCipher):
+        self._coll = collection
+        self._name = name
+        self._cipher = cipher
+
+    def __getattr__(self, name):
+        return getattr(self._coll, name)
+
+    def _decrypt(self, doc):
+        return self._cipher.decrypt_document(self._name, doc)
+
+    async def insert_one(self, document, *args, **kwargs):
+        return await self._coll.insert_one(self._cipher.encrypt_document(self._name, document), *args, **kwargs)
+
+    async def insert_many(self, documents, *args, **kwargs):
+        return await self._coll.insert_many([self._cipher.encrypt_document(self._name, d) for d in documents],
+                                            *args, **kwargs)
+
+    async def replace_one(self, filter, replacement, *args, **kwargs):
+        return await self._coll.replace_one(filter, self._cipher.encrypt_document(self._name, replacement),
+                                            *args, **kwargs)
+
+    async def update_one(self, filter, update, *args, **kwargs):
+        return await self._coll.update_one(filter, self._cipher.encrypt_update(self._name, update), *args, **kwargs)
+
+    async def update_many(self, filter, update, *args, **kwargs):
+        return await self._coll.update_many(filter, self._cipher.encrypt_update(self._name, update), *args, **kwargs)
+
+    async def find_one(self, *args, **kwargs):
+        return await self._decrypt(await self._coll.find_one(*args, **kwargs))
+
+    async def find_one_and_update(self, filter, update, *args, **kwargs):
+        return await self._decrypt(await self._coll.find_one_and_update(
+            filter, self._cipher.encrypt_update(self._name, update), *args, **kwargs))
+
+    async def find_one_and_replace(self, filter, replacement, *args, **kwargs):
+        return await self._decrypt(await self._coll.find_one_and_replace(
+            filter, self._cipher.encrypt_document(self._name, replacement), *args, **kwargs))
+
+    async def find_one_and_delete(self, *args, **kwargs):
+        return await self._decrypt(await self._coll.find_one_and_delete(*args, **kwargs))
+
+    def find(self, *args, **kwargs):
+        return _DecryptingCursor(self._coll.find(*args, **kwargs), self._decrypt)
+
+    def aggregate(self, *args, **kwargs):
+        return _DecryptingCursor(self._coll.aggregate(*args, **kwargs), self._decrypt)
+
+class _EncryptingDatabase:
+    def __init__(self, database, cipher: FieldCipher):
+        self._db = database
+        self._cipher = cipher
+
+    def __getitem__(self, name):
+        collection = self._db[name]
+        return EncryptingCollection(collection, name, self._cipher) if name in ENCRYPTED_FIELDS else collection
+
+    def __getattr__(self, name):
+        if name.startswith("_") or hasattr(type(self._db), name):
+            return getattr(self._db, name)
+        return self[name]
+
+class EncryptingClient:
+    def __init__(self, client, cipher: FieldCipher):
+        self._client = client
+        self._cipher = cipher
+
+    def __getitem__(self, name):
+        return _EncryptingDatabase(self._client[name], self._cipher)
+
+    def __getattr__(self, name):
+        if name.startswith("_") or hasattr(type(self._client), name):
+            return getattr(self._client, name)
+        return self[name]
+
+# --- Key administration ---
+
+async def list_field_keys() -> List[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["field_keys"].find(
+            {}, {"_id": 0, "wrapped_key": 0}
+        ).sort("generation", -1).to_list(length=None)
+
+async def rotate_field_key(created_by: Optional[str] = None) -> Optional[dict]:
+    """Adds a data key for new writes and retires the previous ones (they keep decrypting)."""
+    kek = current_kek()
+    dek = AESGCM.generate_key(bit_length=256)
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["field_keys"]
+        latest = await coll.find_one(sort=[("generation", -1)])
+        generation = (latest["generation"] + 1) if latest else 1
+        doc = {
+            "kid": f"f{generation}-{secrets.token_hex(4)}",
+            "generation": generation,
+            "wrapped_key": base64.b64encode(await kek.wrap(dek)).decode(),
+            "kek_id": kek.kek_id,
+            "status": "active",
+            "created_at": datetime.now(timezone.utc),
+            "created_by": created_by
+        }
+        try:
+            await coll.insert_one(dict(doc))
+        except DuplicateKeyError:
+            return None
+        await coll.update_many({"status": "active", "kid": {"$ne": doc["kid"]}}, {"$set": {"status": "retired"}})
+    logger.info("field_key_rotated", kid=doc["kid"], generation=generation, created_by=created_by)
+    return {k: v for k, v in doc.items() if k != "wrapped_key"}
+
+async def rewrap_field_keys() -> int:
+    """Re-wraps every data key under the current KEK, e.g. after a new master key or vault key version."""
+    kek = current_kek()
+    rewrapped = 0
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["field_keys"]
+        async for doc in coll.find({"kek_id": {"$ne": kek.kek_id}}):
+            dek = await kek_for(doc["kek_id"]).unwrap(base64.b64decode(doc["wrapped_key"]))
+            await coll.update_one(
+                {"kid": doc["kid"], "kek_id": doc["kek_id"]},
+                {"$set": {"wrapped_key": base64.b64encode(await kek.wrap(dek)).decode(), "kek_id": kek.kek_id}}
+            )
+            rewrapped += 1
+    logger.info("field_keys_rewrapped", count=rewrapped, kek=kek.kek_id)
+    return rewrapped
+
+async def reencrypt_collection(collection: str, cipher: FieldCipher = field_cipher, batch_size: int = 500) -> int:
+    """
+    Moves a collection's designated fields onto the active key, encrypting plaintext from before
+    encryption was enabled. Each document is only rewritten if it hasn't changed meanwhile.
+    """
+    paths = ENCRYPTED_FIELDS[collection]
+    top_level = sorted({p.split(".")[0] for p in paths})
+
+    def refresh_value(value: str, path: str) -> str:
+        if key_id_of(value) == cipher.active_kid:
+            return value
+        return cipher.encrypt(cipher.decrypt(value, f"{collection}.{path}"), f"{collection}.{path}")
+
+    updated = 0
+    # The raw client: this job needs to see the stored ciphertext
+    coll = DatabaseConnection.client["virtualbutler"][collection]
+    async for doc in coll.find({}, {k: 1 for k in top_level}).batch_size(batch_size):
+        new = transform_document(doc, paths, refresh_value)
+        changes = {k: new[k] for k in top_level if k in doc and new[k] != doc[k]}
+        if changes:
+            result = await coll.update_one({"_id": doc["_id"], **{k: doc[k] for k in changes}}, {"$set": changes})
+            updated += result.modified_count
+    logger.info("field_reencryption_done", collection=collection, updated=updated, kid=cipher.active_kid)
+    return updated
@@ -0,0 +1,218 @@
+"""
+JWT signing keys shared by all services through Mongo (`jwt_keys`).
+
+- Tokens carry the signing key's id in the `kid` header; verification picks the matching key.
+- The newest active key signs; older keys keep verifying until `verify_until` so tokens issued
+  before a rotation stay valid for their lifetime.
+- Revoking a key rejects its tokens as soon as each service refreshes its key ring
+  (JWT_KEY_REFRESH_SECONDS), without a redeploy. A token naming a key the ring hasn't loaded yet
+  (another replica just rotated) triggers one early refresh before it is rejected.
+- Secrets are encrypted at rest with the field-encryption keys (shared.security.field_crypto) when
+  field encryption is enabled, like other sensitive fields.
+- Tokens without a `kid` are verified against the legacy JWT_SECRET while JWT_ALLOW_LEGACY_SECRET
+  is enabled, so existing sessions survive the switch-over, but only until the secret retires:
+  JWT_LEGACY_SECRET_RETIRES_AT if set, otherwise one token lifetime after the first key was created,
+  by which time every token it signed has expired.
+- Tokens issued by a hotel's identity provider are passed to the federated verifier
+  (shared.security.oidc) instead.
+- Guest tokens from QR onboarding carry a `stay` claim and stop verifying once that stay ends
+  (shared.onboarding.stay_is_current), in every service and on the WebSockets alike.
+"""
+import asyncio
+import os
+import secrets
+from datetime import datetime, timedelta, timezone
+from enum import Enum
+from typing import Dict, List, Optional
+
+import structlog
+from jose import jwt
+from jose.exceptions import JWTError
+from pydantic import BaseModel, Field
+from pymongo.errors import DuplicateKeyError
+
+from shared.db.database import DatabaseConnection
+from shared.onboarding import stay_is_current
+
+logger = structlog.get_logger()
+
+JWT_KEY_REFRESH_SECONDS = int(os.getenv("JWT_KEY_REFRESH_SECONDS", "15"))
+JWT_KEY_ROTATION_DAYS = int(os.getenv("JWT_KEY_ROTATION_DAYS", "30"))
+JWT_TOKEN_TTL_HOURS = int(os.getenv("JWT_TOKEN_TTL_HOURS", "24"))
+JWT_ALLOW_LEGACY_SECRET = os.getenv("JWT_ALLOW_LEGACY_SECRET", "true").lower() == "true"
+JWT_LEGACY_SECRET_RETIRES_AT = os.getenv("JWT_LEGACY_SECRET_RETIRES_AT")   # ISO 8601
+# An unknown kid refreshes the ring early at most this often, so forged kids can't hammer Mongo
+JWT_KEY_MISS_REFRESH_SECONDS = float(os.getenv("JWT_KEY_MISS_REFRESH_SECONDS", "2"))
+
+class KeyStatusEnum(str, Enum):
+    ACTIVE = "active"      # signs new tokens (newest only) and verifies
+    RETIRED = "retired"    # verifies until verify_until
+    REVOKED = "revoked"    # never verifies
+
+class SigningKey(BaseModel):
+    kid: str
+    generation: int
+    secret: str
+    algorithm: str = "HS256"
+    status: KeyStatusEnum = KeyStatusEnum.ACTIVE
+    created_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+    verify_until: Optional[datetime] = None
+    revoked_at: Optional[datetime] = None
+    revoked_reason: Optional[str] = None
+    created_by: Optional[str] = None
+
+    def public_view(self) -> dict:
+        return self.model_dump(exclude={"secret"})
+
+class SigningKeyError(Exception): pass
+class UnknownSigningKeyError(JWTError): pass
+
+def _aware(value: datetime) -> datetime:
+    return value if value.tzinfo else value.replace(tzinfo=timezone.utc)
+
+def legacy_retirement(keys: List[SigningKey], configured: Optional[str] = JWT_LEGACY_SECRET_RETIRES_AT) -> Optional[datetime]:
+    """When tokens without a kid stop verifying; None while no key has been created yet."""
+    if configured:
+        return _aware(datetime.fromisoformat(configured))
+    if not keys:
+        return None
+    return _aware(min(k.created_at for k in keys)) + timedelta(hours=JWT_TOKEN_TTL_HOURS)
+
+class KeyRing:
+    def __init__(self, legacy_secret: Optional[str] = None, legacy_algorithm: str = "HS256", federated=None):
+        self.legacy_secret = legacy_secret
+        self.legacy_algorithm = legacy_algorithm
+        self.federated = federated
+        self.keys: Dict[str, SigningKey] = {}
+        self.signing: Optional[SigningKey] = None
+        self.legacy_retires_at: Optional[datetime] = legacy_retirement([])
+        self.refreshed_at: Optional[datetime] = None
+
+    async def refresh(self) -> None:
+        async with DatabaseConnection.get_connection() as conn:
+            docs = await conn["virtualbutler"]["jwt_keys"].find().to_list(length=None)
+        keys = [SigningKey(**doc) for doc in docs]
+        # Revoked keys still date the first rotation, so they count towards the legacy retirement
+        self.legacy_retires_at = legacy_retirement(keys)
+        keys = [k for k in keys if k.status != KeyStatusEnum.REVOKED]
+        self.keys = {k.kid: k for k in keys}
+        active = [k for k in keys if k.status == KeyStatusEnum.ACTIVE]
+        self.signing = max(active, key=lambda k: k.generation) if active else None
+        self.refreshed_at = datetime.now(timezone.utc)
+
+    async def refresh_loop(self) -> None:
+        while True:
+            try:
+                await self.refresh()
+            except Exception as e:
+                logger.error("jwt_keys_refresh_failed", error=str(e))
+            await asyncio.sleep(JWT_KEY_REFRESH_SECONDS)
+
+    def encode(self, claims: dict, ttl: timedelta = timedelta(hours=JWT_TOKEN_TTL_HOURS)) -> str:
+        now = datetime.now(timezone.utc)
+        payload = {"iat": now, "exp": now + ttl, **claims}
+        if self.signing:
+            return jwt.encode(payload, self.signing.secret, algorithm=self.signing.algorithm,
+                              headers={"kid": self.signing.kid})
+        if not self.legacy_secret:
+            raise JWTError("No signing key available")
+        return jwt.encode(payload, self.legacy_secret, algorithm=self.legacy_algorithm)
+
+    def decode(self, token: str) -> dict:
+        """Verifies a token against the key named by its `kid`; raises JWTError if unknown, revoked or expired."""
+        if self.federated is not None:
+            payload = self.federated.decode(token)
+            if payload is not None:
+                return payload
+        kid = jwt.get_unverified_header(token).get("kid")
+        if kid is None:
+            if not (JWT_ALLOW_LEGACY_SECRET and self.legacy_secret):
+                raise JWTError("Token has no key id")
+            if self.legacy_retires_at and self.legacy_retires_at <= datetime.now(timezone.utc):
+                raise JWTError("Tokens without a key id are no longer accepted")
+            return jwt.decode(token, self.legacy_secret, algorithms=[self.legacy_algorithm])
+        key = self.keys.get(kid)
+        if key is None:
+            raise UnknownSigningKeyError("Unknown or revoked signing key")
+        if key.status == KeyStatusEnum.RETIRED and key.verify_until and key.verify_until < datetime.now(timezone.utc):
+            raise JWTError("Signing key has expired")
+        return jwt.decode(token, key.secret, algorithms=[key.algorithm])
+
+    async def verify(self, token: str) -> dict:
+        """
+        decode(), but a key minted by another replica since the last refresh is loaded rather than rejected,
+        and a token bound to a stay is rejected once the guest has checked out or the stay has changed.
+        """
+        try:
+            payload = self.decode(token)
+        except UnknownSigningKeyError:
+            now = datetime.now(timezone.utc)
+            if self.refreshed_at and (now - self.refreshed_at).total_seconds() < JWT_KEY_MISS_REFRESH_SECONDS:
+                raise
+            await self.refresh()
+            payload = self.decode(token)
+        if payload.get("stay") and not await stay_is_current(payload.get("sub"), payload["stay"]):
+            logger.info("jwt_stay_ended", guest_id=payload.get("sub"))
+            raise JWTError("The stay this token was issued for has ended")
+        return payload
+
+    async def rotation_loop(self) -> None:
+        """Rotates the signing key once it is older than JWT_KEY_ROTATION_DAYS (safe to run on several replicas)."""
+        while True:
+            try:
+                current = self.signing
+                if current is None or current.created_at < datetime.now(timezone.utc) - timedelta(days=JWT_KEY_ROTATION_DAYS):
+                    await rotate_key(created_by="scheduler")
+                    await self.refresh()
+            except Exception as e:
+                logger.error("jwt_key_rotation_failed", error=str(e))
+            await asyncio.sleep(3600)
+
+# --- Key administration ---
+
+async def list_keys() -> List[SigningKey]:
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["jwt_keys"].find().sort("generation", -1).to_list(length=None)
+    return [SigningKey(**doc) for doc in docs]
+
+async def rotate_key(created_by: Optional[str] = None) -> Optional[SigningKey]:
+    """
+    Creates a new signing key and retires the previous active keys, which keep verifying for one
+    token lifetime. Returns None if another replica rotated at the same moment.
+    """
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["jwt_keys"]
+        latest = await coll.find_one(sort=[("generation", -1)])
+        generation = (latest["generation"] + 1) if latest else 1
+        key = SigningKey(kid=f"k{generation}-{secrets.token_hex(4)}", generation=generation,
+                         secret=secrets.token_urlsafe(48), created_by=created_by)
+        try:
+            await coll.insert_one(key.model_dump())
+        except DuplicateKeyError:
+            return None
+        await coll.update_many(
+            {"status": KeyStatusEnum.ACTIVE, "kid": {"$ne": key.kid}},
+            {"$set": {"status": KeyStatusEnum.RETIRED, "verify_until": now + timedelta(hours=JWT_TOKEN_TTL_HOURS)}}
+        )
+    logger.info("jwt_key_rotated", kid=key.kid, generation=generation, created_by=created_by)
+    return key
+
+async def revoke_key(kid: str, reason: Optional[str] = None, revoked_by: Optional[str] = None) -> SigningKey:
+    """Revokes a key immediately; revoking the signing key also rotates so new tokens can still be issued."""
+    async with DatabaseConnection.get_connection() as conn:
+        before = await conn["virtualbutler"]["jwt_keys"].find_one_and_update(
+            {"kid": kid, "status": {"$ne": KeyStatusEnum.REVOKED}},
+            {"$set": {"status": KeyStatusEnum.REVOKED, "revoked_at": datetime.now(timezone.utc), "revoked_reason": reason}}
+        )
+    if not before:
+        raise SigningKeyError(f"Key '{kid}' not found or already revoked")
+    logger.warning("jwt_key_revoked", kid=kid, reason=reason, revoked_by=revoked_by)
+    if before["status"] == KeyStatusEnum.ACTIVE:
+        await rotate_key(created_by=revoked_by)
+    return SigningKey(**{**before, "status": KeyStatusEnum.REVOKED})
+
+async def ensure_key_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["jwt_keys"].create_index("kid", unique=True)
+        await conn["virtualbutler"]["jwt_keys"].create_index("generation", unique=True)
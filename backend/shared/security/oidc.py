@@ -0,0 +1,239 @@
+"""
+Staff sign-in through the hotel's identity provider (OIDC; Azure AD / Entra ID in practice).
+
+Each tenant (hotel) registers its IdP in `oidc_providers`: issuer, the audience our API is
+registered as, and which IdP groups map to butler roles and departments. Staff apps sign in with
+the IdP directly and send its access token as the bearer token; KeyRing.decode hands any token from
+a registered issuer to the verifier here, which checks it against the IdP's published keys and
+turns its claims into the same payload shape our own JWTs have.
+
+Single logout: the IdP's back-channel logout (or the staff app's own logout call) records the
+session in `oidc_logouts`, and every service rejects tokens from that session once it refreshes
+(OIDC_REFRESH_SECONDS).
+"""
+import asyncio
+import os
+import time
+from datetime import datetime, timedelta, timezone
+from typing import Dict, List, Optional, Set, Tuple
+from urllib.parse import urlencode
+
+import httpx
+import structlog
+from jose import jwt
+from jose.exceptions import JWTError
+from pydantic import BaseModel, Field
+
+from shared.db.database import DatabaseConnection
+
+logger = structlog.get_logger()
+
+OIDC_REFRESH_SECONDS = int(os.getenv("OIDC_REFRESH_SECONDS", "15"))
+OIDC_JWKS_REFRESH_SECONDS = int(os.getenv("OIDC_JWKS_REFRESH_SECONDS", "3600"))
+# Long enough to outlive any access token issued before the logout
+OIDC_LOGOUT_RETENTION_HOURS = int(os.getenv("OIDC_LOGOUT_RETENTION_HOURS", "24"))
+BACKCHANNEL_LOGOUT_EVENT = "http://schemas.openid.net/event/backchannel-logout"
+ROLE_RANK = {"staff": 1, "admin": 2}
+
+class OidcProvider(BaseModel):
+    tenant: str
+    issuer: str
+    audience: str
+    client_id: Optional[str] = Field(None, description="Client the staff app signs in as; defaults to the audience")
+    discovery_url: Optional[str] = None
+    groups_claim: str = Field("groups", description="'groups' for security groups, 'roles' for app roles")
+    group_roles: Dict[str, str] = Field(default_factory=dict, description="IdP group -> staff | admin")
+    group_departments: Dict[str, str] = Field(default_factory=dict, description="IdP group -> department")
+    post_logout_redirect_uri: Optional[str] = None
+    enabled: bool = True
+    updated_at: datetime = Field(default_factory=lambda: datetime.now(timezone.utc))
+    updated_by: Optional[str] = None
+
+class OidcError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+def validate_provider(provider: OidcProvider) -> None:
+    invalid = sorted({r for r in provider.group_roles.values() if r not in ROLE_RANK})
+    if invalid:
+        raise OidcError(f"Unknown role(s) {', '.join(invalid)}; use one of {', '.join(ROLE_RANK)}", 422)
+    if not provider.issuer.startswith("https://"):
+        raise OidcError("The issuer must be an https URL", 422)
+
+def map_claims(provider: OidcProvider, claims: dict) -> dict:
+    """Butler payload for a verified IdP token; accounts in no mapped group get no access."""
+    if provider.groups_claim == "groups" and "groups" not in claims and "_claim_names" in claims:
+        # Entra leaves groups out when the user is in too many; app roles avoid the overage
+        raise JWTError("Token has too many groups to list; map app roles with groups_claim='roles'")
+    groups = claims.get(provider.groups_claim) or []
+    roles = [provider.group_roles[g] for g in groups if g in provider.group_roles]
+    if not roles:
+        raise JWTError("Account is not in any group mapped to a butler role")
+    departments = sorted({provider.group_departments[g] for g in groups if g in provider.group_departments})
+    return {
+        "sub": f"oidc:{claims.get('oid') or claims['sub']}",
+        "role": max(roles, key=ROLE_RANK.get),
+        "departments": departments,
+        "name": claims.get("name"),
+        "email": claims.get("preferred_username") or claims.get("email"),
+        "tenant": provider.tenant,
+        "iss": claims["iss"],
+        "idp_sub": claims["sub"],
+        "sid": claims.get("sid"),
+        "iat": claims.get("iat"),
+        "exp": claims.get("exp"),
+        "auth": "oidc",
+    }
+
+def is_logged_out(claims: dict, sids: Set[Tuple[str, str]], subs: Dict[Tuple[str, str], float]) -> bool:
+    """A token is dead if its session was logged out, or its subject logged out everywhere after it was issued."""
+    iss = claims.get("iss")
+    if claims.get("sid") and (iss, claims["sid"]) in sids:
+        return True
+    logged_out_at = subs.get((iss, claims.get("sub")))
+    return logged_out_at is not None and claims.get("iat", 0) <= logged_out_at
+
+class OidcVerifier:
+    def __init__(self):
+        self.providers: Dict[str, OidcProvider] = {}
+        self.metadata: Dict[str, dict] = {}
+        self.jwks: Dict[str, Dict[str, dict]] = {}
+        self.jwks_fetched_at: Dict[str, float] = {}
+        self.stale: Set[str] = set()
+        self.logged_out_sids: Set[Tuple[str, str]] = set()
+        self.logged_out_subs: Dict[Tuple[str, str], float] = {}
+
+    async def refresh(self) -> None:
+        async with DatabaseConnection.get_connection() as conn:
+            db = conn["virtualbutler"]
+            docs = await db["oidc_providers"].find({"enabled": True}).to_list(length=None)
+            logouts = await db["oidc_logouts"].find(
+                {"at": {"$gte": datetime.now(timezone.utc) - timedelta(hours=OIDC_LOGOUT_RETENTION_HOURS)}}
+            ).to_list(length=None)
+        self.providers = {doc["issuer"]: OidcProvider(**doc) for doc in docs}
+        for issuer, provider in self.providers.items():
+            fetched = self.jwks_fetched_at.get(issuer, 0)
+            if issuer in self.stale or time.monotonic() - fetched > OIDC_JWKS_REFRESH_SECONDS:
+                try:
+                    await self._fetch_keys(provider)
+                except (httpx.HTTPError, KeyError, ValueError) as e:
+                    logger.error("oidc_jwks_fetch_failed", tenant=provider.tenant, issuer=issuer, error=str(e))
+        self.logged_out_sids = {(d["issuer"], d["sid"]) for d in logouts if d.get("sid")}
+        subs: Dict[Tuple[str, str], float] = {}
+        for d in logouts:
+            if d.get("sub") and not d.get("sid"):
+                key = (d["issuer"], d["sub"])
+                subs[key] = max(subs.get(key, 0), d["at"].timestamp())
+        self.logged_out_subs = subs
+
+    async def _fetch_keys(self, provider: OidcProvider) -> None:
+        url = provider.discovery_url or f"{provider.issuer.rstrip('/')}/.well-known/openid-configuration"
+        async with httpx.AsyncClient(timeout=10.0) as client:
+            response = await client.get(url)
+            response.raise_for_status()
+            metadata = response.json()
+            response = await client.get(metadata["jwks_uri"])
+            response.raise_for_status()
+            jwks = response.json()
+        self.metadata[provider.issuer] = metadata
+        self.jwks[provider.issuer] = {k["kid"]: k for k in jwks.get("keys", []) if k.get("kid")}
+        self.jwks_fetched_at[provider.issuer] = time.monotonic()
+        self.stale.discard(provider.issuer)
+
+    async def refresh_loop(self) -> None:
+        while True:
+            await asyncio.sleep(OIDC_REFRESH_SECONDS)
+            try:
+                await self.refresh()
+            except Exception as e:
+                logger.error("oidc_refresh_failed", error=str(e))
+
+    def _verify(self, token: str, provider: OidcProvider) -> dict:
+        kid = jwt.get_unverified_header(token).get("kid")
+        key = self.jwks.get(provider.issuer, {}).get(kid)
+        if key is None:
+            # The IdP rotated its keys; picked up on the next refresh
+            self.stale.add(provider.issuer)
+            raise JWTError("Unknown identity provider signing key")
+        return jwt.decode(token, key, algorithms=[key.get("alg", "RS256")], audience=provider.audience,
+                          issuer=provider.issuer, options={"verify_at_hash": False})
+
+    def decode(self, token: str) -> Optional[dict]:
+        """The butler payload for a token from a registered IdP, or None if the issuer isn't one of ours."""
+        provider = self.providers.get(jwt.get_unverified_claims(token).get("iss"))
+        if provider is None:
+            return None
+        claims = self._verify(token, provider)
+        if is_logged_out(claims, self.logged_out_sids, self.logged_out_subs):
+            raise JWTError("Session has been logged out")
+        return map_claims(provider, claims)
+
+    def verify_logout_token(self, token: str) -> Tuple[OidcProvider, dict]:
+        provider = self.providers.get(jwt.get_unverified_claims(token).get("iss"))
+        if provider is None:
+            raise JWTError("Unknown issuer")
+        claims = self._verify(token, provider)
+        if BACKCHANNEL_LOGOUT_EVENT not in (claims.get("events") or {}) or "nonce" in claims:
+            raise JWTError("Not a logout token")
+        if not claims.get("sid") and not claims.get("sub"):
+            raise JWTError("Logout token names no session or subject")
+        return provider, claims
+
+    async def record_logout(self, issuer: str, sid: Optional[str] = None, sub: Optional[str] = None) -> None:
+        now = datetime.now(timezone.utc)
+        async with DatabaseConnection.get_connection() as conn:
+            await conn["virtualbutler"]["oidc_logouts"].insert_one(
+                {"issuer": issuer, "sid": sid, "sub": sub, "at": now,
+                 "expires_at": now + timedelta(hours=OIDC_LOGOUT_RETENTION_HOURS)}
+            )
+        # Takes effect here at once; other replicas follow on their next refresh
+        if sid:
+            self.logged_out_sids.add((issuer, sid))
+        elif sub:
+            self.logged_out_subs[(issuer, sub)] = now.timestamp()
+        logger.info("oidc_logout_recorded", issuer=issuer, sid=sid, sub=sub)
+
+    def end_session_url(self, provider: OidcProvider) -> Optional[str]:
+        endpoint = self.metadata.get(provider.issuer, {}).get("end_session_endpoint")
+        if not endpoint:
+            return None
+        if not provider.post_logout_redirect_uri:
+            return endpoint
+        return f"{endpoint}?{urlencode({'post_logout_redirect_uri': provider.post_logout_redirect_uri})}"
+
+# --- Provider administration ---
+
+async def get_provider(tenant: str) -> Optional[OidcProvider]:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["oidc_providers"].find_one({"tenant": tenant})
+    return OidcProvider(**doc) if doc else None
+
+async def list_providers() -> List[OidcProvider]:
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["oidc_providers"].find().sort("tenant", 1).to_list(length=None)
+    return [OidcProvider(**doc) for doc in docs]
+
+async def save_provider(provider: OidcProvider) -> OidcProvider:
+    validate_provider(provider)
+    provider.updated_at = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["oidc_providers"]
+        clash = await coll.find_one({"issuer": provider.issuer, "tenant": {"$ne": provider.tenant}})
+        if clash:
+            raise OidcError(f"Issuer is already registered for tenant '{clash['tenant']}'", 409)
+        await coll.update_one({"tenant": provider.tenant}, {"$set": provider.model_dump()}, upsert=True)
+    logger.info("oidc_provider_saved", tenant=provider.tenant, issuer=provider.issuer, by=provider.updated_by)
+    return provider
+
+async def delete_provider(tenant: str) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["oidc_providers"].delete_one({"tenant": tenant})
+    if not result.deleted_count:
+        raise OidcError("No identity provider configured for this tenant", 404)
+
+async def ensure_oidc_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["oidc_providers"].create_index("tenant", unique=True)
+        await conn["virtualbutler"]["oidc_providers"].create_index("issuer", unique=True)
+        await conn["virtualbutler"]["oidc_logouts"].create_index("expires_at", expireAfterSeconds=0)
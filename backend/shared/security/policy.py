@@ -0,0 +1,68 @@
+from typing import Optional
+
+import structlog
+from fastapi import HTTPException
+
+logger = structlog.get_logger()
+
+# Roles allowed to act on behalf of another guest (e.g. front desk entering a request for a caller)
+GUEST_OVERRIDE_ROLES = {"admin"}
+
+def resolve_guest_id(user: dict, requested_guest_id: Optional[str] = None) -> str:
+    """
+    Returns the guest a request acts for. The JWT subject is authoritative; a different guest_id in
+    the request body is only honoured for override roles and is rejected (403) otherwise.
+    """
+    subject = user.get("sub")
+    if not requested_guest_id or requested_guest_id == subject:
+        if not subject:
+            raise HTTPException(status_code=401, detail="Token has no subject")
+        return subject
+    if user.get("role") in GUEST_OVERRIDE_ROLES:
+        logger.info("guest_identity_override", actor=subject, role=user.get("role"), guest_id=requested_guest_id)
+        return requested_guest_id
+    logger.warning("guest_identity_mismatch", subject=subject, requested_guest_id=requested_guest_id)
+    raise HTTPException(status_code=403, detail="guest_id does not match the authenticated guest")
+
+# --- Work-order read policy ---
+# guest: own orders only (guest_id == sub)
+# staff: orders in their department(s), from the `department` / `departments` claims
+# admin: everything
+# integration (API key): everything, if the key has the work_orders:read scope
+
+def staff_departments(user: dict) -> set:
+    claims = user.get("departments") or ([user["department"]] if user.get("department") else [])
+    return {str(d).lower() for d in claims}
+
+def integration_can_read(user: dict) -> bool:
+    return any(s in ("*", "work_orders:*", "work_orders:read") for s in user.get("scopes", []))
+
+def work_order_read_filter(user: dict) -> Optional[dict]:
+    """Mongo filter restricting work orders to those the caller may read; None means no access."""
+    role = user.get("role")
+    if role == "admin":
+        return {}
+    if role == "integration":
+        return {} if integration_can_read(user) else None
+    if role == "staff":
+        departments = staff_departments(user)
+        return {"department": {"$in": sorted(departments)}} if departments else None
+    if user.get("sub"):
+        return {"guest_id": user["sub"]}
+    return None
+
+def can_read_work_order(user: dict, work_order: dict) -> bool:
+    role = user.get("role")
+    if role == "admin":
+        return True
+    if role == "integration":
+        return integration_can_read(user)
+    if role == "staff":
+        return str(work_order.get("department", "")).lower() in staff_departments(user)
+    return bool(user.get("sub")) and work_order.get("guest_id") == user.get("sub")
+
+def ensure_can_read_work_order(user: dict, work_order: Optional[dict]) -> dict:
+    """Raises 404 for missing or unreadable orders so callers cannot probe for other guests' IDs."""
+    if not work_order or not can_read_work_order(user, work_order):
+        raise HTTPException(status_code=404, detail="Work order not found")
+    return work_order
@@ -0,0 +1,56 @@
+import os
+import re
+from typing import Optional
+
+import structlog
+from azure.ai.textanalytics.aio import TextAnalyticsClient
+from azure.core.credentials import AzureKeyCredential
+
+from shared.db.models import PriorityEnum
+
+logger = structlog.get_logger()
+
+AZURE_LANGUAGE_ENDPOINT = os.getenv("AZURE_LANGUAGE_ENDPOINT")
+AZURE_LANGUAGE_KEY = os.getenv("AZURE_LANGUAGE_KEY")
+# Scores run from -1 (very negative) to 1 (very positive)
+SENTIMENT_PRIORITY_THRESHOLD = float(os.getenv("SENTIMENT_PRIORITY_THRESHOLD", "-0.3"))
+SENTIMENT_ALERT_THRESHOLD = float(os.getenv("SENTIMENT_ALERT_THRESHOLD", "-0.6"))
+
+# Local fallback when Azure Language is not configured or unavailable
+NEGATIVE_WORDS = re.compile(
+    r"\b(terrible|awful|horrible|disgusting|dirty|filthy|worst|angry|furious|unacceptable|ridiculous|"
+    r"rude|disappointed|disappointing|complain|complaint|refund|hate|useless|still waiting|again|stinks)\b"
+)
+POSITIVE_WORDS = re.compile(
+    r"\b(thanks|thank you|great|lovely|perfect|excellent|amazing|wonderful|appreciate|happy|fantastic|helpful)\b"
+)
+
+def score_locally(text: str) -> float:
+    """Damped word-count score: one negative word gives -0.5, two give -0.67, and so on."""
+    lowered = text.lower()
+    negative = len(NEGATIVE_WORDS.findall(lowered))
+    positive = len(POSITIVE_WORDS.findall(lowered))
+    return round((positive - negative) / (positive + negative + 1), 2)
+
+async def score_sentiment(text: str, language: str = "en") -> Optional[float]:
+    """Returns a sentiment score in [-1, 1] using Azure Language, falling back to the local lexicon."""
+    if not text.strip():
+        return None
+    if AZURE_LANGUAGE_ENDPOINT and AZURE_LANGUAGE_KEY:
+        try:
+            async with TextAnalyticsClient(AZURE_LANGUAGE_ENDPOINT, AzureKeyCredential(AZURE_LANGUAGE_KEY)) as client:
+                [result] = await client.analyze_sentiment([{"id": "1", "text": text, "language": language}])
+            if not result.is_error:
+                scores = result.confidence_scores
+                return round(scores.positive - scores.negative, 2)
+            logger.warning("sentiment_analysis_error", error=result.error.message)
+        except Exception as e:
+            logger.error("sentiment_analysis_failed", error=str(e))
+    return score_locally(text)
+
+def priority_for_sentiment(priority: str, sentiment: Optional[float]) -> str:
+    """Bumps the priority one level for frustrated guests (never to urgent; that stays a human call)."""
+    if sentiment is None or sentiment > SENTIMENT_PRIORITY_THRESHOLD:
+        return priority
+    bump = {PriorityEnum.LOW: PriorityEnum.MEDIUM, PriorityEnum.MEDIUM: PriorityEnum.HIGH}
+    return bump.get(PriorityEnum(priority), PriorityEnum(priority)).value
@@ -0,0 +1,74 @@
+"""
+How the services are served. One builder for all three, so HTTP and connection settings stay the same.
+
+With SERVER_HTTP2 on (the default), services run on hypercorn:
+- With SERVER_CERTFILE/SERVER_KEYFILE, clients negotiate h2 over TLS (ALPN), falling back to HTTP/1.1.
+- Without TLS (internal traffic behind the ingress), hypercorn accepts h2c: prior knowledge or an
+  Upgrade from HTTP/1.1.
+
+Keep-alive outlasts the load balancer's idle timeout (60s on Azure), so the proxy closes idle
+connections rather than the service. Otherwise a request can race the close and fail. Mobile clients
+multiplex their polling and chat calls over one connection, up to SERVER_H2_MAX_STREAMS at a time.
+That avoids a TCP and TLS handshake per request on flaky hotel Wi-Fi.
+
+SERVER_HTTP2=false keeps the previous uvicorn server (HTTP/1.1 only) with the same keep-alive.
+"""
+import os
+from typing import Optional
+
+import structlog
+
+logger = structlog.get_logger()
+
+SERVER_HTTP2 = os.getenv("SERVER_HTTP2", "true").lower() == "true"
+SERVER_CERTFILE = os.getenv("SERVER_CERTFILE")
+SERVER_KEYFILE = os.getenv("SERVER_KEYFILE")
+SERVER_KEEP_ALIVE_SECONDS = int(os.getenv("SERVER_KEEP_ALIVE_SECONDS", "75"))
+SERVER_H2_MAX_STREAMS = int(os.getenv("SERVER_H2_MAX_STREAMS", "100"))
+SERVER_H2_MAX_FRAME_BYTES = int(os.getenv("SERVER_H2_MAX_FRAME_BYTES", str(64 * 1024)))
+SERVER_GRACEFUL_TIMEOUT_SECONDS = int(os.getenv("SERVER_GRACEFUL_TIMEOUT_SECONDS", "10"))
+SERVER_RELOAD = os.getenv("SERVER_RELOAD", "true").lower() == "true"
+
+def server_settings(host: str, port: int, http2: bool = SERVER_HTTP2, certfile: Optional[str] = SERVER_CERTFILE,
+                    keyfile: Optional[str] = SERVER_KEYFILE) -> dict:
+    """The hypercorn Config attributes for a service; `alpn_protocols` only matter with TLS."""
+    tls = bool(certfile and keyfile)
+    return {
+        "bind": [f"{host}:{port}"],
+        "certfile": certfile if tls else None,
+        "keyfile": keyfile if tls else None,
+        "alpn_protocols": ["h2", "http/1.1"] if http2 else ["http/1.1"],
+        "keep_alive_timeout": SERVER_KEEP_ALIVE_SECONDS,
+        "h2_max_concurrent_streams": SERVER_H2_MAX_STREAMS,
+        "h2_max_inbound_frame_size": SERVER_H2_MAX_FRAME_BYTES,
+        "graceful_timeout": SERVER_GRACEFUL_TIMEOUT_SECONDS,
+        "use_reloader": SERVER_RELOAD,
+    }
+
+def protocols(http2: bool = SERVER_HTTP2, certfile: Optional[str] = SERVER_CERTFILE,
+              keyfile: Optional[str] = SERVER_KEYFILE) -> str:
+    if not http2:
+        return "http/1.1"
+    return "h2, http/1.1" if certfile and keyfile else "h2c, http/1.1"
+
+def serve(name: str, module: str, host: str, port: int) -> None:
+    """Runs `module` ("chatbot.main:app") until the process is stopped."""
+    logger.info("service_starting", service=name, port=port, protocols=protocols(),
+                keep_alive_seconds=SERVER_KEEP_ALIVE_SECONDS)
+    if not SERVER_HTTP2:
+        import uvicorn
+        uvicorn.run(module, host=host, port=port, reload=SERVER_RELOAD, reload_dirs=["backend"], log_level="info",
+                    ssl_certfile=SERVER_CERTFILE, ssl_keyfile=SERVER_KEYFILE, timeout_keep_alive=SERVER_KEEP_ALIVE_SECONDS,
+                    timeout_graceful_shutdown=SERVER_GRACEFUL_TIMEOUT_SECONDS)
+        return
+
+    from hypercorn.config import Config
+    from hypercorn.run import run
+
+    config = Config()
+    for key, value in server_settings(host, port).items():
+        setattr(config, key, value)
+    config.application_path = module
+    config.loglevel = "INFO"
+    config.accesslog = "-"
+    run(config)
@@ -0,0 +1,145 @@
+"""
+Dark launch of a second intent classifier.
+
+With SHADOW_CLASSIFIER set ("rules", "clu" or "llm"), the chatbot also runs that classifier on
+SHADOW_CLASSIFIER_SAMPLE_PERCENT of the messages it routes, after replying, so guests never wait for
+it and its answer never routes anything. Both decisions go to `classifier_decisions`; the final
+outcome is the department the work order ended up in (after any re-routing or misrouting report),
+read when the comparison report runs. The report shows how often the two agree and how accurate each
+was, overall and per department, so a hotel can judge a new model before switching to it.
+
+The "llm" classifier asks an Azure OpenAI chat deployment (AZURE_OPENAI_ENDPOINT, AZURE_OPENAI_KEY,
+AZURE_OPENAI_DEPLOYMENT) for the department as JSON.
+"""
+import json
+import os
+from collections import Counter, defaultdict
+from datetime import datetime, timezone
+from typing import Dict, List, Optional
+
+import structlog
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import DepartmentEnum
+from shared.intents import IntentMatch
+
+logger = structlog.get_logger()
+
+SHADOW_CLASSIFIER = os.getenv("SHADOW_CLASSIFIER", "").lower() or None
+SHADOW_CLASSIFIER_SAMPLE_PERCENT = int(os.getenv("SHADOW_CLASSIFIER_SAMPLE_PERCENT", "100"))
+SHADOW_DECISION_TTL_DAYS = int(os.getenv("SHADOW_DECISION_TTL_DAYS", "90"))
+AZURE_OPENAI_ENDPOINT = os.getenv("AZURE_OPENAI_ENDPOINT")
+AZURE_OPENAI_KEY = os.getenv("AZURE_OPENAI_KEY")
+AZURE_OPENAI_DEPLOYMENT = os.getenv("AZURE_OPENAI_DEPLOYMENT")
+AZURE_OPENAI_API_VERSION = os.getenv("AZURE_OPENAI_API_VERSION", "2024-02-01")
+
+# --- LLM classifier ---
+
+def llm_messages(text: str) -> List[dict]:
+    departments = ", ".join(d.value for d in DepartmentEnum)
+    return [
+        {"role": "system", "content": (
+            "You route hotel guest requests to a department. "
+            f"Answer with JSON only: {{\"department\": one of [{departments}] or null, \"confidence\": 0 to 1}}."
+        )},
+        {"role": "user", "content": text},
+    ]
+
+def parse_llm_reply(content: str) -> IntentMatch:
+    """Anything that isn't the JSON asked for counts as no decision."""
+    try:
+        answer = json.loads(content.strip().removeprefix("```json").removesuffix("```"))
+        department = DepartmentEnum(answer["department"]) if answer.get("department") else None
+        confidence = float(answer["confidence"]) if answer.get("confidence") is not None else None
+    except (ValueError, TypeError, KeyError, AttributeError):
+        return IntentMatch(None, None, "llm")
+    return IntentMatch(department, min(max(confidence, 0.0), 1.0) if confidence is not None else None, "llm")
+
+async def classify_with_llm(text: str, client) -> IntentMatch:
+    if not (AZURE_OPENAI_ENDPOINT and AZURE_OPENAI_KEY and AZURE_OPENAI_DEPLOYMENT):
+        logger.warning("llm_classifier_not_configured")
+        return IntentMatch(None, None, "llm")
+    response = await client.post(
+        f"{AZURE_OPENAI_ENDPOINT}/openai/deployments/{AZURE_OPENAI_DEPLOYMENT}/chat/completions"
+        f"?api-version={AZURE_OPENAI_API_VERSION}",
+        json={"messages": llm_messages(text), "temperature": 0, "max_tokens": 50},
+        headers={"api-key": AZURE_OPENAI_KEY}
+    )
+    response.raise_for_status()
+    return parse_llm_reply(response.json()["choices"][0]["message"]["content"])
+
+# --- Decisions and comparison ---
+
+def _decision(match: IntentMatch) -> dict:
+    return {"source": match.source, "department": DepartmentEnum(match.department).value if match.department else None,
+            "confidence": match.confidence}
+
+async def record_decision(request_id: str, primary: IntentMatch, shadow: IntentMatch, shadow_name: str,
+                          latency_ms: float) -> None:
+    primary_doc, shadow_doc = _decision(primary), _decision(shadow)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["classifier_decisions"].insert_one({
+            "request_id": request_id,
+            "primary": primary_doc,
+            "shadow": {**shadow_doc, "classifier": shadow_name, "latency_ms": round(latency_ms, 1)},
+            "agree": primary_doc["department"] == shadow_doc["department"],
+            "created_at": datetime.now(timezone.utc),
+        })
+
+def _accuracy(correct: int, total: int) -> Optional[float]:
+    return round(correct / total, 4) if total else None
+
+def compare(decisions: List[dict], outcomes: Dict[str, str]) -> dict:
+    """`outcomes` maps request_id to the department the work order finally ended up in."""
+    agreed = sum(1 for d in decisions if d["agree"])
+    evaluated = [d for d in decisions if d["request_id"] in outcomes]
+    correct = Counter()
+    per_department: Dict[str, Counter] = defaultdict(Counter)
+    for d in evaluated:
+        final = outcomes[d["request_id"]]
+        per_department[final]["orders"] += 1
+        for side in ("primary", "shadow"):
+            if d[side]["department"] == final:
+                correct[side] += 1
+                per_department[final][side] += 1
+        if d["primary"]["department"] != final and d["shadow"]["department"] == final:
+            correct["shadow_only"] += 1
+        elif d["primary"]["department"] == final and d["shadow"]["department"] != final:
+            correct["primary_only"] += 1
+    latencies = [d["shadow"].get("latency_ms") for d in decisions if d["shadow"].get("latency_ms") is not None]
+    return {
+        "decisions": len(decisions),
+        "agreement": _accuracy(agreed, len(decisions)),
+        "evaluated": len(evaluated),
+        "primary_accuracy": _accuracy(correct["primary"], len(evaluated)),
+        "shadow_accuracy": _accuracy(correct["shadow"], len(evaluated)),
+        "only_primary_right": correct["primary_only"],
+        "only_shadow_right": correct["shadow_only"],
+        "shadow_avg_latency_ms": round(sum(latencies) / len(latencies), 1) if latencies else None,
+        "by_department": [{
+            "department": department,
+            "orders": counts["orders"],
+            "primary_accuracy": _accuracy(counts["primary"], counts["orders"]),
+            "shadow_accuracy": _accuracy(counts["shadow"], counts["orders"]),
+        } for department, counts in sorted(per_department.items())],
+    }
+
+async def comparison_report(start: datetime, end: datetime, classifier: Optional[str] = None) -> dict:
+    query = {"created_at": {"$gte": start, "$lt": end}}
+    if classifier:
+        query["shadow.classifier"] = classifier
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        decisions = await db["classifier_decisions"].find(query, {"_id": 0}).to_list(length=None)
+        orders = await db["work_orders"].find(
+            {"request_id": {"$in": [d["request_id"] for d in decisions]}, "parent_id": None},
+            {"_id": 0, "request_id": 1, "department": 1}
+        ).to_list(length=None)
+    return {"start": start, "end": end, "classifier": classifier,
+            **compare(decisions, {o["request_id"]: o["department"] for o in orders})}
+
+async def ensure_shadow_classifier_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        decisions = conn["virtualbutler"]["classifier_decisions"]
+        await decisions.create_index("created_at", expireAfterSeconds=SHADOW_DECISION_TTL_DAYS * 86400)
+        await decisions.create_index("request_id")
@@ -0,0 +1,107 @@
+"""
+Parent/child work orders. A large guest request ("prepare the room for an event") stays one parent
+order that the guest follows, while staff work its departmental child tasks. Children may depend on
+siblings; a child can't start or complete until everything it depends on is completed.
+
+The parent's status is never set directly while it has children: it is rolled up from them.
+"""
+from typing import Dict, List, Optional
+
+from shared.db.models import StatusEnum
+
+DONE_STATUSES = (StatusEnum.COMPLETED.value, StatusEnum.CANCELLED.value)
+GATED_STATUSES = (StatusEnum.IN_PROGRESS.value, StatusEnum.COMPLETED.value)
+
+class SubtaskError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+def rollup_status(statuses: List[str]) -> str:
+    """
+    The parent's status given its children's: completed once every live task is done, in progress as
+    soon as any work has started or finished, otherwise the least advanced open status.
+    """
+    live = [s for s in statuses if s != StatusEnum.CANCELLED.value]
+    if not statuses:
+        return StatusEnum.PENDING.value
+    if not live:
+        return StatusEnum.CANCELLED.value
+    if all(s == StatusEnum.COMPLETED.value for s in live):
+        return StatusEnum.COMPLETED.value
+    if any(s in GATED_STATUSES for s in live):
+        return StatusEnum.IN_PROGRESS.value
+    if all(s == StatusEnum.ON_HOLD.value for s in live):
+        return StatusEnum.ON_HOLD.value
+    if any(s == StatusEnum.PENDING.value for s in live):
+        return StatusEnum.PENDING.value
+    return StatusEnum.ASSIGNED.value
+
+def rollup_summary(children: List[dict]) -> dict:
+    by_id = {c["work_order_id"]: c for c in children}
+    return {
+        "total": len(children),
+        "completed": sum(c["status"] == StatusEnum.COMPLETED.value for c in children),
+        "cancelled": sum(c["status"] == StatusEnum.CANCELLED.value for c in children),
+        "blocked": sum(bool(blocked_by(c, by_id)) for c in children if c["status"] not in DONE_STATUSES)
+    }
+
+def blocked_by(child: dict, siblings: Dict[str, dict]) -> List[str]:
+    """Dependencies that are not completed yet. A cancelled dependency still blocks; staff must drop it."""
+    return [d for d in child.get("depends_on", [])
+            if siblings.get(d, {}).get("status") != StatusEnum.COMPLETED.value]
+
+def check_can_move(child: dict, new_status: str, siblings: Dict[str, dict]) -> None:
+    if new_status not in GATED_STATUSES:
+        return
+    waiting = blocked_by(child, siblings)
+    if waiting:
+        raise SubtaskError(f"Waiting on {', '.join(waiting)}", 409)
+
+def resolve_dependencies(tasks: List[dict], new_ids: List[str], existing_ids: List[str]) -> List[List[str]]:
+    """
+    Maps each new task's depends_on entries (another new task's key, or an existing sibling's
+    work_order_id) to work-order IDs, rejecting unknown references and cycles.
+    """
+    keys = {t["key"]: new_ids[i] for i, t in enumerate(tasks) if t.get("key")}
+    if len(keys) != len([t for t in tasks if t.get("key")]):
+        raise SubtaskError("Task keys must be unique", 422)
+    resolved = []
+    for task in tasks:
+        ids = []
+        for ref in task.get("depends_on", []):
+            if ref in keys:
+                ids.append(keys[ref])
+            elif ref in existing_ids:
+                ids.append(ref)
+            else:
+                raise SubtaskError(f"Unknown dependency '{ref}'", 422)
+        resolved.append(list(dict.fromkeys(ids)))
+    graph = dict(zip(new_ids, resolved))
+    visiting, done = set(), set()
+
+    def visit(node: str) -> None:
+        if node in done:
+            return
+        if node in visiting:
+            raise SubtaskError("Task dependencies form a cycle", 422)
+        visiting.add(node)
+        for dep in graph.get(node, []):
+            visit(dep)
+        visiting.discard(node)
+        done.add(node)
+
+    for node in new_ids:
+        visit(node)
+    return resolved
+
+def parent_changes(parent: dict, children: List[dict], now) -> Optional[dict]:
+    """The $set for the parent after a child changed, or None when nothing about it moved."""
+    status = rollup_status([c["status"] for c in children])
+    summary = rollup_summary(children)
+    if status == parent.get("status") and summary == parent.get("subtasks"):
+        return None
+    changes = {"status": status, "subtasks": summary, "updated_at": now}
+    if status == StatusEnum.COMPLETED.value and not parent.get("completed_at"):
+        changes["completed_at"] = now
+    return changes
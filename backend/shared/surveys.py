@@ -0,0 +1,198 @@
+"""
+Net Promoter Score surveys at checkout.
+
+A survey is created once per stay, when the PMS reports a checkout (POST /api/v1/stays/checkout) or,
+failing that, when the sweep finds a guest whose check_out_date has passed. It records which teams
+handled requests during the stay and goes out on the guest's preferred channel with a link carrying a
+single-use token (only its hash is stored). Guests answer 0-10 overall, optionally per team, within
+SURVEY_RESPONSE_DAYS.
+
+NPS is the percentage of promoters (9-10) minus the percentage of detractors (0-6). A department's NPS
+uses the guest's score for that team when they gave one, otherwise their overall score, counted only
+for stays in which the team handled a request.
+"""
+import hashlib
+import os
+import secrets
+import uuid
+from datetime import datetime, timedelta, timezone
+from typing import Dict, Iterable, List, Optional
+
+import structlog
+from pydantic import BaseModel, Field
+from pymongo import ReturnDocument
+from pymongo.errors import DuplicateKeyError
+
+from shared import metrics
+from shared.db.database import DatabaseConnection
+from shared.db.models import DepartmentEnum, NpsSurvey, SurveyStatusEnum
+
+logger = structlog.get_logger()
+
+HOTEL_ID = os.getenv("HOTEL_ID", "default")
+SURVEY_RESPONSE_DAYS = int(os.getenv("SURVEY_RESPONSE_DAYS", "14"))
+# How far back the sweep looks for stays that ended without a checkout event
+SURVEY_LOOKBACK_HOURS = int(os.getenv("SURVEY_LOOKBACK_HOURS", "24"))
+SURVEY_URL_TEMPLATE = os.getenv("SURVEY_URL_TEMPLATE", "https://butler.example.com/survey/{token}")
+TREND_INTERVALS = {"day", "week", "month"}
+
+class SurveyError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+class SurveyResponse(BaseModel):
+    score: int = Field(..., ge=0, le=10, description="How likely are you to recommend us to a friend?")
+    department_scores: Dict[DepartmentEnum, int] = Field(default_factory=dict)
+    comment: Optional[str] = Field(None, max_length=2000)
+
+def hash_token(token: str) -> str:
+    return hashlib.sha256(token.encode()).hexdigest()
+
+def survey_link(token: str) -> str:
+    return SURVEY_URL_TEMPLATE.format(token=token)
+
+def stay_id(guest_id: str, check_out: datetime) -> str:
+    return f"{guest_id}:{check_out.date().isoformat()}"
+
+def classify(score: int) -> str:
+    if score >= 9:
+        return "promoter"
+    return "passive" if score >= 7 else "detractor"
+
+def nps(scores: Iterable[int]) -> dict:
+    counts = {"promoter": 0, "passive": 0, "detractor": 0}
+    for score in scores:
+        counts[classify(score)] += 1
+    total = sum(counts.values())
+    value = round(100 * (counts["promoter"] - counts["detractor"]) / total, 1) if total else None
+    return {"nps": value, "responses": total, "promoters": counts["promoter"], "passives": counts["passive"],
+            "detractors": counts["detractor"]}
+
+def period_start(moment: datetime, interval: str) -> str:
+    day = moment.date()
+    if interval == "week":
+        day -= timedelta(days=day.weekday())
+    elif interval == "month":
+        day = day.replace(day=1)
+    return day.isoformat()
+
+def nps_summary(responses: List[dict], interval: str = "week") -> dict:
+    """Overall, per-department and per-period NPS for answered surveys."""
+    by_department: Dict[str, List[int]] = {}
+    by_period: Dict[str, List[int]] = {}
+    for r in responses:
+        for department in r.get("departments", []):
+            by_department.setdefault(department, []).append(r.get("department_scores", {}).get(department, r["score"]))
+        by_period.setdefault(period_start(r["answered_at"], interval), []).append(r["score"])
+    return {
+        "overall": nps(r["score"] for r in responses),
+        "departments": {d: nps(scores) for d, scores in sorted(by_department.items())},
+        "trend": [{"period": p, **nps(scores)} for p, scores in sorted(by_period.items())],
+    }
+
+async def departments_during_stay(guest_id: str, check_in: Optional[datetime], check_out: datetime) -> List[str]:
+    query = {"guest_id": guest_id, "parent_id": None, "created_at": {"$lte": check_out}}
+    if check_in:
+        query["created_at"]["$gte"] = check_in
+    async with DatabaseConnection.get_connection() as conn:
+        return sorted(await conn["virtualbutler"]["work_orders"].distinct("department", query))
+
+async def create_survey(guest: dict, check_out: datetime, language: str = "en",
+                        now: Optional[datetime] = None) -> Optional[tuple]:
+    """Creates the stay's survey; returns (survey, token), or None if the stay already has one."""
+    now = now or datetime.now(timezone.utc)
+    token = secrets.token_urlsafe(24)
+    survey = NpsSurvey(
+        survey_id=f"nps_{uuid.uuid4().hex[:12]}",
+        stay_id=stay_id(guest["guest_id"], check_out),
+        hotel_id=HOTEL_ID,
+        guest_id=guest["guest_id"],
+        room_number=guest.get("room_number"),
+        check_in_date=guest.get("check_in_date"),
+        check_out_date=check_out,
+        departments=await departments_during_stay(guest["guest_id"], guest.get("check_in_date"), check_out),
+        language=language,
+        expires_at=now + timedelta(days=SURVEY_RESPONSE_DAYS),
+        created_at=now,
+        updated_at=now
+    )
+    try:
+        async with DatabaseConnection.get_connection() as conn:
+            await conn["virtualbutler"]["nps_surveys"].insert_one(
+                {**survey.model_dump(exclude={"id"}), "token_hash": hash_token(token)}
+            )
+    except DuplicateKeyError:
+        return None
+    logger.info("nps_survey_created", survey_id=survey.survey_id, guest_id=survey.guest_id, departments=survey.departments)
+    return survey, token
+
+async def mark_delivery(survey_id: str, channel: Optional[str]) -> None:
+    status = SurveyStatusEnum.SENT if channel else SurveyStatusEnum.UNDELIVERED
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["nps_surveys"].update_one(
+            {"survey_id": survey_id}, {"$set": {"channel": channel, "status": status}}
+        )
+    metrics.increment("butler_nps_surveys_sent_total", channel=channel or "none")
+
+async def stays_ended(now: datetime) -> List[dict]:
+    """Guests whose stay ended within SURVEY_LOOKBACK_HOURS and who haven't had a survey for it."""
+    async with DatabaseConnection.get_connection() as conn:
+        guests = await conn["virtualbutler"]["guest_profiles"].find(
+            {"check_out_date": {"$lte": now, "$gte": now - timedelta(hours=SURVEY_LOOKBACK_HOURS)}},
+            {"_id": 0, "guest_id": 1, "room_number": 1, "check_in_date": 1, "check_out_date": 1}
+        ).to_list(length=None)
+        surveyed = set(await conn["virtualbutler"]["nps_surveys"].distinct(
+            "stay_id", {"stay_id": {"$in": [stay_id(g["guest_id"], g["check_out_date"]) for g in guests]}}
+        ))
+    return [g for g in guests if stay_id(g["guest_id"], g["check_out_date"]) not in surveyed]
+
+async def get_survey_by_token(token: str, now: Optional[datetime] = None) -> dict:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["nps_surveys"].find_one({"token_hash": hash_token(token)}, {"token_hash": 0})
+    if not doc:
+        raise SurveyError("Survey not found", 404)
+    expires_at = doc["expires_at"].replace(tzinfo=timezone.utc) if doc["expires_at"].tzinfo is None else doc["expires_at"]
+    if doc["status"] != SurveyStatusEnum.ANSWERED and expires_at <= (now or datetime.now(timezone.utc)):
+        raise SurveyError("This survey has closed", 410)
+    return doc
+
+async def record_response(token: str, response: SurveyResponse, now: Optional[datetime] = None) -> dict:
+    now = now or datetime.now(timezone.utc)
+    survey = await get_survey_by_token(token, now)
+    if survey["status"] == SurveyStatusEnum.ANSWERED:
+        raise SurveyError("This survey has already been answered", 409)
+    department_scores = {DepartmentEnum(d).value: s for d, s in response.department_scores.items()}
+    if any(not 0 <= s <= 10 for s in department_scores.values()):
+        raise SurveyError("Scores run from 0 to 10", 422)
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["nps_surveys"].find_one_and_update(
+            {"survey_id": survey["survey_id"], "status": {"$ne": SurveyStatusEnum.ANSWERED}},
+            {"$set": {"status": SurveyStatusEnum.ANSWERED, "score": response.score, "comment": response.comment,
+                      "department_scores": department_scores, "answered_at": now, "updated_at": now}},
+            projection={"token_hash": 0},
+            return_document=ReturnDocument.AFTER
+        )
+    if not doc:
+        raise SurveyError("This survey has already been answered", 409)
+    metrics.increment("butler_nps_responses_total", category=classify(response.score))
+    logger.info("nps_survey_answered", survey_id=doc["survey_id"], guest_id=doc["guest_id"], score=response.score)
+    return doc
+
+async def nps_report(start: datetime, end: datetime, interval: str = "week", hotel_id: str = HOTEL_ID) -> dict:
+    if interval not in TREND_INTERVALS:
+        raise SurveyError(f"interval must be one of {', '.join(sorted(TREND_INTERVALS))}", 422)
+    async with DatabaseConnection.get_connection() as conn:
+        responses = await conn["virtualbutler"]["nps_surveys"].find(
+            {"hotel_id": hotel_id, "status": SurveyStatusEnum.ANSWERED, "answered_at": {"$gte": start, "$lt": end}},
+            {"_id": 0, "score": 1, "department_scores": 1, "departments": 1, "answered_at": 1}
+        ).to_list(length=None)
+    return {"hotel_id": hotel_id, "start": start, "end": end, "interval": interval, **nps_summary(responses, interval)}
+
+async def ensure_survey_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        surveys = conn["virtualbutler"]["nps_surveys"]
+        await surveys.create_index("survey_id", unique=True)
+        await surveys.create_index("stay_id", unique=True)
+        await surveys.create_index("token_hash", unique=True)
+        await surveys.create_index([("hotel_id", 1), ("status", 1), ("answered_at", 1)])
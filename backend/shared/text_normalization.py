@@ -0,0 +1,56 @@
+"""
+Text normalization ahead of classification: guests write "🧻 pls", "AC 🥵" or "need towels 2nite",
+which neither CLU nor the keyword rules read well.
+
+- Emoji with a clear meaning in a hotel are replaced by the words the classifiers route on (🧻 becomes
+  "toilet paper", 🥵 "ac too hot"); skin-tone and variation modifiers are dropped first, and a run of
+  the same emoji counts once. Emoji not in EMOJI_WORDS are left alone.
+- Common chat shorthand (SHORTHAND, plus runtime_config's `shorthand`) is expanded word by word.
+
+Only the text the bot classifies and parses is normalized; the guest's message is stored as sent.
+"""
+import re
+from typing import Dict, Optional
+
+EMOJI_WORDS = {
+    # Housekeeping
+    "🧻": "toilet paper", "🧼": "soap", "🧴": "shampoo", "🛁": "towel", "🛏": "linen", "🧹": "clean",
+    "🪥": "toothbrush",
+    # Maintenance
+    "🥵": "ac too hot", "🥶": "ac too cold", "❄": "ac", "💡": "light", "🚿": "shower broken",
+    "🚽": "toilet", "💧": "leak", "🔧": "repair", "🛠": "repair",
+    # IT
+    "📶": "wifi", "📺": "tv", "🔌": "connect",
+    # Room service
+    "🍔": "food", "🍕": "food", "🥪": "food", "🍳": "breakfast", "☕": "coffee", "🍷": "drink",
+    "🍺": "drink", "🍾": "drink", "🥤": "drink", "🚰": "water",
+    # Front desk, concierge
+    "🔑": "key", "🧾": "bill", "🚕": "taxi", "🚖": "taxi", "💆": "spa",
+}
+# Skin tones and the emoji/text presentation selectors
+EMOJI_MODIFIERS = re.compile("[\U0001F3FB-\U0001F3FF\uFE0E\uFE0F]")
+EMOJI = re.compile("(" + "|".join(sorted(map(re.escape, EMOJI_WORDS), key=len, reverse=True)) + r")(\1)*")
+SHORTHAND = {
+    "pls": "please", "plz": "please", "thx": "thanks", "ty": "thank you", "rm": "room", "rms": "rooms",
+    "tmrw": "tomorrow", "tmr": "tomorrow", "tmrrw": "tomorrow", "2moro": "tomorrow", "2day": "today",
+    "2nite": "tonight", "tonite": "tonight", "asap": "as soon as possible", "b4": "before",
+    "w/": "with", "w/o": "without", "u": "you", "ur": "your", "r": "are", "cuz": "because", "bc": "because",
+    "hrs": "hours", "mins": "minutes", "msg": "message", "hk": "housekeeping", "bfast": "breakfast",
+    "brekkie": "breakfast", "aircon": "ac", "rm svc": "room service", "wud": "would", "cud": "could",
+    "abt": "about", "smth": "something", "sth": "something", "nvm": "never mind",
+}
+
+def replace_emoji(text: str) -> str:
+    text = EMOJI_MODIFIERS.sub("", text)
+    return EMOJI.sub(lambda m: f" {EMOJI_WORDS[m.group(1)]} ", text)
+
+def expand_shorthand(text: str, shorthand: Optional[Dict[str, str]] = None) -> str:
+    table = {**SHORTHAND, **{k.lower(): v for k, v in (shorthand or {}).items()}}
+    words = "|".join(sorted(map(re.escape, table), key=len, reverse=True))
+    # Whole words only, so "u" in "menu" and "rm" in "firm" stay put
+    return re.sub(rf"(?<![\w/])({words})(?![\w/])", lambda m: table[m.group(1).lower()], text, flags=re.IGNORECASE)
+
+def normalize_message(text: str, shorthand: Optional[Dict[str, str]] = None) -> str:
+    """The message as the classifiers should read it; see the module docstring."""
+    text = expand_shorthand(replace_emoji(text), shorthand)
+    return re.sub(r"\s+", " ", text).strip()
@@ -0,0 +1,119 @@
+"""
+Printable work tickets for back-of-house teams that still work from paper.
+
+A ticket is a one-page A6 PDF: the order number in large type with a Code 128 barcode of it, the QR
+and short code staff scan or type to open the order in the staff app (shared/scan_codes.py),
+department and priority, room or off-site area, when it was requested in hotel time, the guest's
+request and items, and a tick box per checklist step.
+
+Setting PRINT_SERVER_URL turns on auto-print: new orders for PRINT_TICKET_DEPARTMENTS (comma-separated;
+empty for all) are POSTed to the print server as application/pdf, with the department in X-Department so
+the server can pick the printer. A failed print is logged and never holds up the order.
+"""
+import io
+import os
+import textwrap
+from datetime import datetime, tzinfo
+from typing import List, Optional, Tuple
+
+from reportlab.graphics import renderPDF
+from reportlab.graphics.barcode import code128
+from reportlab.graphics.barcode.qr import QrCodeWidget
+from reportlab.graphics.shapes import Drawing
+from reportlab.lib.pagesizes import A6
+from reportlab.lib.units import mm
+from reportlab.pdfgen import canvas
+
+from shared.clock import format_local
+from shared.scan_codes import format_code, scan_url, short_code_for
+
+PRINT_SERVER_URL = os.getenv("PRINT_SERVER_URL")
+PRINT_TICKET_DEPARTMENTS = {d.strip() for d in os.getenv("PRINT_TICKET_DEPARTMENTS", "").split(",") if d.strip()}
+# Characters per line of body text at 9pt on A6 with the margins below
+TICKET_LINE_WIDTH = 48
+MARGIN = 8 * mm
+QR_SIZE = 24 * mm
+
+def _value(value) -> str:
+    return str(getattr(value, "value", value) or "")
+
+def ticket_code(work_order: dict) -> str:
+    """What the barcode carries: the order number, or the ID for orders numbered before numbering existed."""
+    return work_order.get("order_number") or work_order["work_order_id"]
+
+def scan_code(work_order: dict) -> str:
+    return work_order.get("short_code") or short_code_for(work_order["work_order_id"])
+
+def should_auto_print(work_order: dict, departments: Optional[set] = None) -> bool:
+    departments = PRINT_TICKET_DEPARTMENTS if departments is None else departments
+    return not departments or _value(work_order.get("department")) in departments
+
+def ticket_lines(work_order: dict, tz: Optional[tzinfo] = None) -> List[Tuple[str, str]]:
+    """The ticket body as (style, text) rows; style is heading, text, box (a tick box) or indent."""
+    metadata = work_order.get("metadata") or {}
+    location = metadata.get("location") or {}
+    where = metadata.get("room_number") and f"Room {metadata['room_number']}"
+    where = where or location.get("area_name") or work_order.get("location") or "No room given"
+    lines = [
+        ("heading", f"{_value(work_order.get('department')).replace('_', ' ').title()} - "
+                    f"{_value(work_order.get('priority')).upper()} priority"),
+        ("text", where),
+    ]
+    created_at = work_order.get("created_at")
+    if isinstance(created_at, datetime):
+        lines.append(("text", f"Requested {format_local(created_at, '%d/%m %H:%M', tz)}"))
+    lines.append(("heading", "Request"))
+    lines += [("text", line) for line in textwrap.wrap(work_order.get("description") or "", TICKET_LINE_WIDTH)]
+    for item in work_order.get("line_items") or []:
+        quantity = f"{item['quantity']} x " if item.get("quantity") else ""
+        lines.append(("text", f"- {quantity}{item['item']}"))
+    checklist = work_order.get("checklist")
+    if checklist:
+        lines.append(("heading", checklist["name"]))
+        for step in checklist["steps"]:
+            label = step["title"] + ("" if step.get("required", True) else " (optional)")
+            wrapped = textwrap.wrap(label, TICKET_LINE_WIDTH - 4)
+            lines += [("box", wrapped[0])] + [("indent", line) for line in wrapped[1:]]
+    return lines
+
+def render_ticket(work_order: dict, tz: Optional[tzinfo] = None) -> bytes:
+    buffer = io.BytesIO()
+    width, height = A6
+    pdf = canvas.Canvas(buffer, pagesize=A6)
+    pdf.setTitle(f"Work ticket {ticket_code(work_order)}")
+    y = height - MARGIN - 16
+    pdf.setFont("Helvetica-Bold", 20)
+    pdf.drawString(MARGIN, y, ticket_code(work_order))
+    pdf.setFont("Helvetica", 10)
+    pdf.drawString(MARGIN, y - 14, f"Code {format_code(scan_code(work_order))}")
+    qr = QrCodeWidget(scan_url(scan_code(work_order)), barLevel="M")
+    x0, y0, x1, y1 = qr.getBounds()
+    drawing = Drawing(QR_SIZE, QR_SIZE, transform=[QR_SIZE / (x1 - x0), 0, 0, QR_SIZE / (y1 - y0), 0, 0])
+    drawing.add(qr)
+    renderPDF.draw(drawing, pdf, width - MARGIN - QR_SIZE, height - MARGIN - QR_SIZE)
+    y -= 18 + 14 * mm
+    barcode = code128.Code128(ticket_code(work_order), barHeight=12 * mm, barWidth=0.3 * mm, humanReadable=False)
+    barcode.drawOn(pdf, MARGIN - barcode.lquiet, y)
+    y -= 8
+    for style, text in ticket_lines(work_order, tz):
+        if y < MARGIN:
+            # One page is what the printers take; what doesn't fit is in the app
+            pdf.setFont("Helvetica-Oblique", 8)
+            pdf.drawString(MARGIN, MARGIN - 8, "Continued in the staff app")
+            break
+        if style == "heading":
+            y -= 6
+            pdf.setFont("Helvetica-Bold", 10)
+            pdf.drawString(MARGIN, y, text)
+        elif style in ("box", "indent"):
+            if style == "box":
+                pdf.rect(MARGIN, y - 1, 7, 7)
+            pdf.setFont("Helvetica", 9)
+            pdf.drawString(MARGIN + 12, y, text)
+        else:
+            pdf.setFont("Helvetica", 9)
+            pdf.drawString(MARGIN, y, text)
+        y -= 12
+    pdf.showPage()
+    pdf.save()
+    return buffer.getvalue()
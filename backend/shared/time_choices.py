@@ -0,0 +1,53 @@
+"""
+Asking which time a guest meant. "Towels at 7" said at 03:00 could be 07:00 or 19:00; rather than
+guess, the bot holds the message and asks, offering both times as quick replies. The guest's answer
+("19:30", "7pm", "evening") releases the held message with that time, and it is scheduled as if the
+guest had been explicit. A hold lapses after TIME_CHOICE_TTL_MINUTES.
+"""
+import os
+from datetime import datetime, timedelta, timezone, tzinfo
+from typing import List, Optional
+
+import structlog
+
+from shared.db.database import DatabaseConnection
+from shared.timeparse import pick_time
+
+logger = structlog.get_logger()
+
+TIME_CHOICE_TTL_MINUTES = int(os.getenv("TIME_CHOICE_TTL_MINUTES", "15"))
+TIME_CHOICE_TAG = "time_choice"
+
+async def hold_for_time_choice(guest_id: str, message: dict, options: List[datetime]) -> None:
+    """Keeps the message until the guest says which time; a newer question replaces an older one."""
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["time_choices"].replace_one(
+            {"guest_id": guest_id},
+            {"guest_id": guest_id, "message": message, "options": options, "created_at": now,
+             "expires_at": now + timedelta(minutes=TIME_CHOICE_TTL_MINUTES)},
+            upsert=True
+        )
+    logger.info("time_choice_requested", guest_id=guest_id, options=len(options))
+
+async def take_time_choice(guest_id: str, answer: str, tz: tzinfo) -> Optional[tuple]:
+    """(message, time) when the answer picks one of the held message's times; the hold is released."""
+    if not answer or len(answer.split()) > 4:
+        # A longer message is a new request, not an answer
+        return None
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        choices = conn["virtualbutler"]["time_choices"]
+        held = await choices.find_one({"guest_id": guest_id, "expires_at": {"$gt": now}})
+        chosen = pick_time(answer, held["options"], tz) if held else None
+        if not chosen:
+            return None
+        await choices.delete_one({"_id": held["_id"]})
+    logger.info("time_choice_made", guest_id=guest_id, scheduled_for=chosen.isoformat())
+    return held["message"], chosen
+
+async def ensure_time_choice_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        db = conn["virtualbutler"]
+        await db["time_choices"].create_index("guest_id", unique=True)
+        await db["time_choices"].create_index("expires_at", expireAfterSeconds=0)
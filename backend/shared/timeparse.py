@@ -0,0 +1,212 @@
+"""
+Small natural-language time parser for future-dated guest requests
+("wake me at 6am", "extra pillows tonight", "in 2 hours", "tomorrow at 7:30", "at half past six on Friday").
+It only recognises explicit time expressions and returns None otherwise, so ordinary messages
+are never accidentally scheduled.
+
+A time only counts as a request for later when the message asks for something (a scheduling verb such
+as "bring" or "wake", "more", "please") or points ahead ("tomorrow", "tonight", "in 2 hours"). Messages that
+report something that happened ("the shower broke at 2pm", "no heat since noon") never resolve to a
+time, unless they also point ahead ("there was a leak, send someone tomorrow at 9"), so a fault report
+is never held until the same time tomorrow. Callers that already know the message is about a time (a
+taxi booking, a wake-up call) pass `explicit` and skip the first check.
+
+Times are read in the hotel's timezone. A clock time without am/pm ("at 7") is ambiguous when both
+readings are still ahead; resolve_time() returns the likelier one along with the other, so the bot can
+ask which was meant instead of guessing (wake-up calls pass prefer_morning and are never asked).
+"""
+import re
+from datetime import date, datetime, time, timedelta, tzinfo
+from typing import List, NamedTuple, Optional, Tuple
+
+RELATIVE = re.compile(r"\bin (\d+|an?|half an) (minute|min|hour|hr)s?\b")
+MERIDIEM = r"(am|pm|a\.m\.?|p\.m\.?)"
+CLOCK = re.compile(
+    rf"\b(?:at|by|around)\s+(\d{{1,2}})(?:[:.h](\d{{2}}))?\s*{MERIDIEM}?(?!\w)"
+    # A bare "6am" counts too, unless it describes the past ("broken since 6am")
+    rf"|(?<!since )(?<!from )(?<!until )\b(\d{{1,2}})(?:[:.](\d{{2}}))?\s*{MERIDIEM}(?!\w)"
+)
+NAMED_TIMES = {"noon": time(12, 0), "midday": time(12, 0), "midnight": time(0, 0)}
+HOUR_WORDS = {"one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6, "seven": 7, "eight": 8,
+              "nine": 9, "ten": 10, "eleven": 11, "twelve": 12}
+MINUTE_WORDS = {"fifteen": 15, "thirty": 30, "forty-five": 45, "forty five": 45}
+_HOUR = r"(\d{1,2}|" + "|".join(HOUR_WORDS) + r")"
+# Spoken forms, rewritten as a clock time: (pattern, (hour, minute) from the matched hour)
+SPOKEN_TIMES = [
+    (re.compile(rf"\bhalf past {_HOUR}\b"), lambda h: (h, 30)),
+    (re.compile(rf"\b(?:a )?quarter past {_HOUR}\b"), lambda h: (h, 15)),
+    (re.compile(rf"\b(?:a )?quarter to {_HOUR}\b"), lambda h: ((h - 1) or 12, 45)),
+    (re.compile(rf"\b{_HOUR} o'?clock\b"), lambda h: (h, 0)),
+]
+SPOKEN_HOUR = re.compile(r"(?:(?<=\bat )|(?<=\bby )|(?<=\baround ))(" + "|".join(HOUR_WORDS) + r")"
+                         r"(?: (" + "|".join(MINUTE_WORDS) + r"))?\b")
+WEEKDAYS = ["monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"]
+WEEKDAY = re.compile(r"\b(next )?(" + "|".join(WEEKDAYS) + r")\b")
+DAY_PART_TIMES = {"morning": time(8, 0), "afternoon": time(14, 0), "evening": time(18, 0), "night": time(20, 0)}
+DAY_PART = re.compile(r"\btonight\b|\b(this|tomorrow|" + "|".join(WEEKDAYS) + r") (morning|afternoon|evening|night)\b")
+EVENING_WORDS = ("tonight", "afternoon", "evening", "night")
+# The message asks for something to happen
+SCHEDULE_CUE = re.compile(r"\b(bring|send|deliver|wake|book|reserve|schedule|arrange|order|pick ?up|collect|come|"
+                          r"clean|make up|turn ?down|set up|prepare|remind|call me|can (?:i|we|you)|"
+                          r"could (?:i|we|you)|would like|i'?d like|need|want|more|extra|another|please)\b")
+# The message reports something that already happened or is going wrong
+REPORT_CUE = re.compile(r"\b(since|ago|earlier|broke|broken|stopped|started|happened|was(?! wondering)|were|"
+                        r"has been|have been|had|leaked|flooded|went|failed|still|not working|isn'?t working|"
+                        r"doesn'?t work|won'?t work|leaking|dripping|stuck)\b")
+# Points ahead whatever else the message says
+FUTURE_CUE = re.compile(r"\b(tonight|later|next)\b")
+# Answers to "06:30 or 18:30?" that name the half of the day rather than the time
+AM_WORDS = ("morning", "am", "a.m")
+PM_WORDS = ("evening", "afternoon", "night", "tonight", "pm", "p.m")
+
+class TimeResolution(NamedTuple):
+    time: datetime
+    # Other readings of the same words; non-empty means the guest should be asked
+    alternatives: Tuple[datetime, ...] = ()
+
+def _hour_value(value: str) -> int:
+    return int(value) if value.isdigit() else HOUR_WORDS[value]
+
+def spoken_times(text: str) -> str:
+    """Rewrites "half past six", "quarter to 7", "at six thirty" as clock times the parser reads."""
+    def clock(match: re.Match, hour: int, minute: int) -> str:
+        preceding = text[:match.start()].split()[-1:]
+        if preceding and preceding[0] in ("since", "from", "until"):
+            return match.group(0)
+        value = f"{hour}:{minute:02d}"
+        return value if preceding and preceding[0] in ("at", "by", "around") else f"at {value}"
+
+    for pattern, to_clock in SPOKEN_TIMES:
+        text = pattern.sub(lambda m: clock(m, *to_clock(_hour_value(m.group(1)))), text)
+    return SPOKEN_HOUR.sub(lambda m: f"{HOUR_WORDS[m.group(1)]}:{MINUTE_WORDS.get(m.group(2), 0):02d}", text)
+
+def _relative(text: str, now: datetime) -> Optional[datetime]:
+    match = RELATIVE.search(text)
+    if not match:
+        return None
+    amount, unit = match.groups()
+    value = {"a": 1.0, "an": 1.0, "half an": 0.5}.get(amount) or float(amount)
+    return now + (timedelta(hours=value) if unit.startswith("h") else timedelta(minutes=value))
+
+def _clock(text: str) -> Optional[tuple]:
+    """Returns (hour, minute, meridiem-or-None) for the first explicit clock time."""
+    for name, value in NAMED_TIMES.items():
+        if re.search(rf"\b{name}\b", text):
+            return value.hour, value.minute, "24h"
+    match = CLOCK.search(text)
+    if not match:
+        return None
+    hour, minute, meridiem = match.group(1, 2, 3) if match.group(1) else match.group(4, 5, 6)
+    # "07:30", "00:15" and "0:30" are written the 24-hour way
+    meridiem = meridiem or ("24h" if hour.startswith("0") else None)
+    hour, minute = int(hour), int(minute or 0)
+    if hour > 23 or minute > 59:
+        return None
+    if meridiem and meridiem != "24h":
+        meridiem = meridiem.replace(".", "")
+        if hour > 12:
+            return None
+        hour = hour % 12 + (12 if meridiem == "pm" else 0)
+        return hour, minute, "24h"
+    return hour, minute, meridiem
+
+def _day_offset(text: str, today: date) -> Optional[int]:
+    """Days from today to the day the message names, or None when it names none."""
+    if "day after tomorrow" in text:
+        return 2
+    if "tomorrow" in text:
+        return 1
+    match = WEEKDAY.search(text)
+    if not match:
+        return None
+    offset = (WEEKDAYS.index(match.group(2)) - today.weekday()) % 7
+    return 7 if offset == 0 and match.group(1) else offset
+
+def _day_part(text: str) -> Optional[time]:
+    match = DAY_PART.search(text)
+    if not match:
+        return None
+    if match.group(0) == "tonight":
+        return DAY_PART_TIMES["night"]
+    if match.group(1) == "this" and match.group(2) == "morning":
+        # Already under way; not a time to schedule for
+        return None
+    return DAY_PART_TIMES[match.group(2)]
+
+def resolve_time(message: str, now: datetime, tz: tzinfo, prefer_morning: bool = False,
+                 explicit: bool = False) -> Optional[TimeResolution]:
+    """
+    The requested (timezone-aware) time if the message asks for one in the future, else None.
+    `now` must be timezone-aware; clock times are read in `tz` (the hotel's timezone).
+    """
+    text = spoken_times(message.lower())
+    local_now = now.astimezone(tz)
+    relative = _relative(text, local_now)
+    if relative:
+        return TimeResolution(relative)
+
+    offset = _day_offset(text, local_now.date())
+    later = bool(offset)
+    ahead = later or bool(FUTURE_CUE.search(text))
+    if not ahead and (REPORT_CUE.search(text) or not (explicit or SCHEDULE_CUE.search(text))):
+        return None
+    clock = _clock(text)
+    day_part = _day_part(text)
+    if clock is None and day_part is None:
+        if not later:
+            return None
+        day_part = time(9, 0)
+
+    day = local_now.date() + timedelta(days=offset or 0)
+    ambiguous = False
+    if clock is not None:
+        hour, minute, meridiem = clock
+        if meridiem is None and hour < 12:
+            if any(w in text for w in EVENING_WORDS):
+                hour, meridiem = hour + 12, "24h"
+            elif "morning" in text or prefer_morning:
+                meridiem = "24h"
+        ambiguous = meridiem is None and 1 <= hour <= 11
+        candidate = datetime.combine(day, time(hour, minute), tzinfo=tz)
+        if meridiem is None and hour < 12 and not later and candidate <= local_now:
+            # "at 7" said at 10:00 most likely means 19:00 today
+            afternoon = candidate + timedelta(hours=12)
+            if afternoon > local_now:
+                candidate = afternoon
+    else:
+        candidate = datetime.combine(day, day_part, tzinfo=tz)
+
+    if candidate <= local_now:
+        if later or (clock is None and "this " in text):
+            # Tomorrow's 7:00 that has passed, or "this afternoon" said in the evening
+            return None
+        candidate += timedelta(days=7 if offset == 0 else 1)
+    if ambiguous:
+        other = datetime.combine(candidate.date(), time((candidate.hour + 12) % 24, candidate.minute), tzinfo=tz)
+        if other > local_now:
+            return TimeResolution(candidate, (other,))
+    return TimeResolution(candidate)
+
+def parse_requested_time(message: str, now: datetime, tz: tzinfo, prefer_morning: bool = False,
+                         explicit: bool = False) -> Optional[datetime]:
+    """The likelier reading of the requested time; see resolve_time()."""
+    resolution = resolve_time(message, now, tz, prefer_morning, explicit)
+    return resolution.time if resolution else None
+
+def pick_time(answer: str, options: List[datetime], tz: tzinfo) -> Optional[datetime]:
+    """The option a short answer picks ("18:30", "6:30 pm", "the evening one"); None if it picks none."""
+    if not answer or len(answer.split()) > 4:
+        return None
+    text = spoken_times(answer.lower())
+    local = [option.astimezone(tz) for option in options]
+    clock = _clock(text) or _clock(f"at {text}")
+    if clock:
+        hour, minute, _ = clock
+        matches = [o for o, l in zip(options, local) if (l.hour, l.minute) == (hour, minute)]
+    elif any(re.search(rf"\b{re.escape(w)}\b", text) for w in PM_WORDS):
+        matches = [o for o, l in zip(options, local) if l.hour >= 12]
+    elif any(re.search(rf"\b{re.escape(w)}\b", text) for w in AM_WORDS):
+        matches = [o for o, l in zip(options, local) if l.hour < 12]
+    else:
+        return None
+    return matches[0] if len(matches) == 1 else None
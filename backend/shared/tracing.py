@@ -0,0 +1,42 @@
+"""
+Request IDs for correlating one guest interaction across the chatbot, Service Bus and work orders.
+
+Every request gets an ID (the caller's X-Request-ID when it looks sane, else a new one; see
+shared.middleware.RequestIdMiddleware). It is returned in the X-Request-ID response header and as
+`traceID` in error bodies, bound as `trace_id` on every log line, forwarded on calls to the other
+services and on queued chat requests, and stored on the work orders it creates.
+"""
+import contextvars
+import re
+import uuid
+from typing import Optional
+
+REQUEST_ID_HEADER = "X-Request-ID"
+# Service Bus application property carrying the ID from the chatbot to the work-order consumer
+REQUEST_ID_PROPERTY = "trace_id"
+
+_VALID_REQUEST_ID = re.compile(r"[A-Za-z0-9._:\-]{1,128}")
+
+current_request_id: contextvars.ContextVar[Optional[str]] = contextvars.ContextVar("current_request_id", default=None)
+
+def new_request_id() -> str:
+    return uuid.uuid4().hex
+
+def clean_request_id(value) -> Optional[str]:
+    """The propagated ID if it is safe to echo into headers and logs, otherwise None."""
+    if isinstance(value, bytes):
+        value = value.decode("latin-1")
+    if not isinstance(value, str):
+        return None
+    value = value.strip()
+    return value if _VALID_REQUEST_ID.fullmatch(value) else None
+
+def from_message_properties(properties: Optional[dict]) -> Optional[str]:
+    """The ID a queued message was published with; the SDK may hand property keys back as bytes."""
+    properties = properties or {}
+    return clean_request_id(properties.get(REQUEST_ID_PROPERTY) or properties.get(REQUEST_ID_PROPERTY.encode()))
+
+def trace_headers(fallback: Optional[str] = None) -> dict:
+    """Headers for outbound calls to the other services; `fallback` covers work done outside a request."""
+    request_id = current_request_id.get() or fallback
+    return {REQUEST_ID_HEADER: request_id} if request_id else {}
@@ -0,0 +1,171 @@
+"""
+Conversation transcript retention. Chat requests, the per-guest chat context and live-agent
+conversations hold what guests wrote; the hotel's retention policy decides how long that content
+is kept and what happens to it afterwards:
+
+- delete: the documents are removed.
+- anonymize: message content and guest identifiers are stripped, while department, status, tags,
+  sentiment, language and message counts stay so reporting still adds up.
+
+Enforcement is pluggable. The scheduled strategy runs a purge job; the ttl strategy hands deletion
+to Mongo TTL indexes instead (delete mode only, since TTL can only remove whole documents).
+"""
+import hashlib
+import hmac
+import os
+from datetime import datetime, timedelta, timezone
+from typing import Dict, Optional
+
+import structlog
+from pymongo.errors import OperationFailure
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import RetentionModeEnum, RetentionPolicy, RetentionStrategyEnum
+
+logger = structlog.get_logger()
+
+HOTEL_ID = os.getenv("HOTEL_ID", "default")
+TRANSCRIPT_RETENTION_DAYS = int(os.getenv("TRANSCRIPT_RETENTION_DAYS", "365"))
+TRANSCRIPT_RETENTION_MODE = os.getenv("TRANSCRIPT_RETENTION_MODE", RetentionModeEnum.ANONYMIZE.value)
+TRANSCRIPT_ANONYMIZATION_KEY = os.getenv("TRANSCRIPT_ANONYMIZATION_KEY") or os.getenv("JWT_SECRET") or ""
+REDACTED = "[redacted]"
+TTL_INDEX_NAME = "transcript_ttl"
+
+# collection -> the date after which its content counts as expired
+TRANSCRIPT_COLLECTIONS = {
+    "chat_requests": "created_at",
+    "chat_contexts": "updated_at",
+    "agent_conversations": "closed_at",   # open conversations are never touched
+}
+
+class RetentionError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+def pseudonymize(guest_id: str) -> str:
+    """Stable per guest, so distinct-guest counts survive, but not reversible without the key."""
+    digest = hmac.new(TRANSCRIPT_ANONYMIZATION_KEY.encode(), guest_id.encode(), hashlib.sha256).hexdigest()
+    return f"anon_{digest[:16]}"
+
+def validate_policy(policy: RetentionPolicy) -> None:
+    if policy.strategy == RetentionStrategyEnum.TTL and policy.mode != RetentionModeEnum.DELETE:
+        raise RetentionError("TTL indexes can only delete; use the scheduled strategy to anonymize", 422)
+
+def default_policy() -> RetentionPolicy:
+    return RetentionPolicy(hotel_id=HOTEL_ID, retention_days=TRANSCRIPT_RETENTION_DAYS, mode=TRANSCRIPT_RETENTION_MODE)
+
+async def get_policy(hotel_id: str = HOTEL_ID) -> RetentionPolicy:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["retention_policies"].find_one({"hotel_id": hotel_id})
+    return RetentionPolicy(**doc) if doc else default_policy()
+
+async def save_policy(policy: RetentionPolicy) -> RetentionPolicy:
+    validate_policy(policy)
+    policy.updated_at = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["retention_policies"].update_one(
+            {"hotel_id": policy.hotel_id},
+            {"$set": policy.model_dump(exclude={"id", "created_at", "last_run_at", "last_run"})},
+            upsert=True
+        )
+    await enforcer_for(policy).apply(policy)
+    logger.info("retention_policy_saved", hotel_id=policy.hotel_id, retention_days=policy.retention_days,
+                mode=policy.mode, strategy=policy.strategy, by=policy.updated_by)
+    return policy
+
+# --- Enforcement strategies ---
+
+class ScheduledPurge:
+    name = RetentionStrategyEnum.SCHEDULED.value
+
+    async def apply(self, policy: RetentionPolicy) -> None:
+        # Leftover TTL indexes from a previous policy would keep deleting behind the purge job's back
+        async with DatabaseConnection.get_connection() as conn:
+            for collection in TRANSCRIPT_COLLECTIONS:
+                try:
+                    await conn["virtualbutler"][collection].drop_index(TTL_INDEX_NAME)
+                except OperationFailure:
+                    pass
+
+    async def run(self, policy: RetentionPolicy, now: datetime) -> Dict[str, int]:
+        cutoff = now - timedelta(days=policy.retention_days)
+        if policy.mode == RetentionModeEnum.DELETE:
+            return await self._delete(cutoff)
+        return await self._anonymize(cutoff, now)
+
+    async def _delete(self, cutoff: datetime) -> Dict[str, int]:
+        counts = {}
+        async with DatabaseConnection.get_connection() as conn:
+            for collection, field in TRANSCRIPT_COLLECTIONS.items():
+                result = await conn["virtualbutler"][collection].delete_many({field: {"$lt": cutoff}})
+                counts[collection] = result.deleted_count
+        return counts
+
+    async def _anonymize(self, cutoff: datetime, now: datetime) -> Dict[str, int]:
+        counts = {"chat_requests": 0, "chat_contexts": 0, "agent_conversations": 0}
+        async with DatabaseConnection.get_connection() as conn:
+            db = conn["virtualbutler"]
+            expired = {"created_at": {"$lt": cutoff}, "anonymized_at": {"$exists": False}}
+            for guest_id in await db["chat_requests"].distinct("guest_id", expired):
+                result = await db["chat_requests"].update_many(
+                    {**expired, "guest_id": guest_id},
+                    {"$set": {"guest_id": pseudonymize(guest_id), "message": REDACTED, "metadata": {},
+                              "anonymized_at": now},
+                     "$unset": {"voice_transcript": "", "guest_profile": "", "edit_history": ""}}
+                )
+                counts["chat_requests"] += result.modified_count
+            expired = {"closed_at": {"$lt": cutoff}, "anonymized_at": {"$exists": False}}
+            for guest_id in await db["agent_conversations"].distinct("guest_id", expired):
+                result = await db["agent_conversations"].update_many(
+                    {**expired, "guest_id": guest_id},
+                    [{"$set": {"message_count": {"$size": {"$ifNull": ["$messages", []]}}, "messages": [],
+                               "guest_id": pseudonymize(guest_id), "anonymized_at": now}},
+                     {"$unset": ["room_number", "session_id", "disposition_note"]}]
+                )
+                counts["agent_conversations"] += result.modified_count
+            # The context is working memory for the bot; only its sentiment history is worth keeping
+            result = await db["chat_contexts"].update_many(
+                {"updated_at": {"$lt": cutoff}, "history": {"$exists": True}}, {"$unset": {"history": ""}}
+            )
+            counts["chat_contexts"] = result.modified_count
+        return counts
+
+class TtlIndexes(ScheduledPurge):
+    name = RetentionStrategyEnum.TTL.value
+
+    async def apply(self, policy: RetentionPolicy) -> None:
+        seconds = policy.retention_days * 86400
+        async with DatabaseConnection.get_connection() as conn:
+            db = conn["virtualbutler"]
+            for collection, field in TRANSCRIPT_COLLECTIONS.items():
+                try:
+                    await db[collection].create_index(field, name=TTL_INDEX_NAME, expireAfterSeconds=seconds)
+                except OperationFailure:
+                    # The index exists with another expiry; change it in place rather than rebuild
+                    await db.command("collMod", collection, index={"name": TTL_INDEX_NAME, "expireAfterSeconds": seconds})
+
+    async def run(self, policy: RetentionPolicy, now: datetime) -> Dict[str, int]:
+        return {}
+
+def enforcer_for(policy: RetentionPolicy) -> ScheduledPurge:
+    return TtlIndexes() if policy.strategy == RetentionStrategyEnum.TTL else ScheduledPurge()
+
+async def enforce_retention(now: Optional[datetime] = None, hotel_id: str = HOTEL_ID) -> Dict[str, int]:
+    now = now or datetime.now(timezone.utc)
+    policy = await get_policy(hotel_id)
+    counts = await enforcer_for(policy).run(policy, now)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["retention_policies"].update_one(
+            {"hotel_id": hotel_id}, {"$set": {"last_run_at": now, "last_run": counts}}
+        )
+    if any(counts.values()):
+        logger.info("transcripts_retention_applied", hotel_id=hotel_id, mode=policy.mode, **counts)
+    return counts
+
+async def ensure_retention_indexes() -> None:
+    policy = await get_policy()
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["retention_policies"].create_index("hotel_id", unique=True)
+        await conn["virtualbutler"]["chat_requests"].create_index([("created_at", 1), ("guest_id", 1)])
+    await enforcer_for(policy).apply(policy)
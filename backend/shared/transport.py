@@ -0,0 +1,316 @@
+"""
+Taxi and airport-shuttle requests. A request is recorded first, then handed to the configured
+ride connector (TRANSPORT_CONNECTOR=rest posts to a ride-hailing/shuttle dispatcher). Without a
+connector, or if the provider refuses, the concierge arranges it from a work order instead.
+
+Status, driver details and ETA arrive later, either as provider callbacks or staff updates, and
+are relayed to the guest's conversation by the chatbot. A ride the concierge arranges is linked to its
+work order (work_order_id, set when the consumer creates the order), and the two move together: a
+driver update starts the order, finishing or cancelling either side finishes or cancels the other.
+"""
+import os
+import re
+import uuid
+from datetime import datetime, timedelta, timezone
+from typing import List, Optional
+
+import structlog
+from pymongo import ReturnDocument
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import StatusEnum, TransportModeEnum, TransportRequest, TransportStatusEnum
+from shared.http_client import ResilientClient
+
+logger = structlog.get_logger()
+
+TRANSPORT_CONNECTOR = os.getenv("TRANSPORT_CONNECTOR", "none").lower()
+TRANSPORT_REST_URL = os.getenv("TRANSPORT_REST_URL")
+TRANSPORT_REST_TOKEN = os.getenv("TRANSPORT_REST_TOKEN")
+TRANSPORT_PICKUP_LOCATION = os.getenv("TRANSPORT_PICKUP_LOCATION", "Hotel main entrance")
+
+transport_client = ResilientClient("transport")
+
+TAXI_PATTERN = re.compile(r"\b(taxi|cab|uber|ride|car service|car to)\b")
+SHUTTLE_PATTERN = re.compile(r"\bshuttle\b")
+REQUEST_PATTERN = re.compile(r"\b(book|call|order|get|need|arrange|request|want|organi[sz]e|reserve)\b")
+DESTINATION_PATTERN = re.compile(
+    r"\bto (?:go to )?(?:the )?(?P<dest>[a-z0-9'&. -]+?)"
+    r"(?= at \d| at noon| around| tomorrow| tonight| this | in \d| for \d|\s*(?:please|[?.,!]|$))"
+)
+PASSENGERS_PATTERN = re.compile(r"\bfor (\d{1,2}) (?:people|persons|passengers|of us)\b")
+TERMINAL_STATUSES = (TransportStatusEnum.COMPLETED, TransportStatusEnum.CANCELLED, TransportStatusEnum.FAILED)
+WORK_ORDER_STATUS_FOR = {
+    TransportStatusEnum.CONFIRMED: StatusEnum.IN_PROGRESS,
+    TransportStatusEnum.DRIVER_ASSIGNED: StatusEnum.IN_PROGRESS,
+    TransportStatusEnum.ARRIVED: StatusEnum.IN_PROGRESS,
+    TransportStatusEnum.COMPLETED: StatusEnum.COMPLETED,
+    TransportStatusEnum.CANCELLED: StatusEnum.CANCELLED,
+    TransportStatusEnum.FAILED: StatusEnum.CANCELLED,
+}
+# Staff starting or holding the order says nothing about the driver, so only the end is mirrored
+TRANSPORT_STATUS_FOR = {
+    StatusEnum.COMPLETED: TransportStatusEnum.COMPLETED,
+    StatusEnum.CANCELLED: TransportStatusEnum.CANCELLED,
+}
+
+class TransportError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+def detect_transport(message: str) -> Optional[dict]:
+    """Returns {mode, destination, passengers} for taxi/shuttle booking requests, None otherwise."""
+    text = message.lower()
+    if SHUTTLE_PATTERN.search(text):
+        mode = TransportModeEnum.SHUTTLE.value
+    elif TAXI_PATTERN.search(text):
+        mode = TransportModeEnum.TAXI.value
+    else:
+        return None
+    if not REQUEST_PATTERN.search(text):
+        return None
+    match = DESTINATION_PATTERN.search(text)
+    destination = message[match.start("dest"):match.end("dest")].strip() if match else None
+    if not destination and "airport" in text:
+        destination = "airport"
+    passengers = PASSENGERS_PATTERN.search(text)
+    return {"mode": mode, "destination": destination, "passengers": int(passengers.group(1)) if passengers else 1}
+
+def _event(status: str, now: datetime, by: Optional[str], **details) -> dict:
+    return {"status": status, "at": now, "by": by, **{k: v for k, v in details.items() if v is not None}}
+
+# --- Connectors ---
+
+class TransportConnector:
+    name = "none"
+
+    async def request_ride(self, request: TransportRequest) -> Optional[dict]:
+        """Returns {ride_id, status, eta_minutes, driver} when the provider accepted, None to fall back."""
+        return None
+
+    async def cancel_ride(self, provider_ref: str) -> None:
+        return None
+
+class RestTransportConnector(TransportConnector):
+    """Generic adapter for a dispatcher exposing POST /rides and POST /rides/{id}/cancel."""
+    name = "rest"
+
+    def _headers(self) -> dict:
+        return {"Authorization": f"Bearer {TRANSPORT_REST_TOKEN}"} if TRANSPORT_REST_TOKEN else {}
+
+    async def request_ride(self, request: TransportRequest) -> Optional[dict]:
+        resp = await transport_client.post(
+            f"{TRANSPORT_REST_URL.rstrip('/')}/rides",
+            json={
+                "reference": request.transport_id,
+                "mode": request.mode,
+                "pickup_at": request.pickup_at.isoformat(),
+                "pickup_location": request.pickup_location,
+                "destination": request.destination,
+                "passengers": request.passengers
+            },
+            headers=self._headers()
+        )
+        resp.raise_for_status()
+        return resp.json()
+
+    async def cancel_ride(self, provider_ref: str) -> None:
+        # Cancelling twice is harmless, so this one may be retried
+        resp = await transport_client.post(f"{TRANSPORT_REST_URL.rstrip('/')}/rides/{provider_ref}/cancel",
+                                           headers=self._headers(), idempotent=True)
+        resp.raise_for_status()
+
+def get_transport_connector() -> TransportConnector:
+    if TRANSPORT_CONNECTOR == "rest" and TRANSPORT_REST_URL:
+        return RestTransportConnector()
+    return TransportConnector()
+
+transport_connector = get_transport_connector()
+
+# --- Requests ---
+
+async def create_transport_request(guest_id: str, mode: str, pickup_at: datetime, destination: Optional[str],
+                                   passengers: int = 1, room_number: Optional[str] = None,
+                                   pickup_location: Optional[str] = None, language: str = "en",
+                                   request_id: Optional[str] = None) -> TransportRequest:
+    now = datetime.now(timezone.utc)
+    pickup_at = pickup_at.astimezone(timezone.utc)
+    if pickup_at < now - timedelta(minutes=5):
+        raise TransportError("Pickup time must not be in the past")
+    request = TransportRequest(
+        transport_id=f"trn_{uuid.uuid4().hex[:12]}",
+        guest_id=guest_id,
+        room_number=room_number,
+        mode=mode,
+        pickup_at=max(pickup_at, now),
+        pickup_location=pickup_location or TRANSPORT_PICKUP_LOCATION,
+        destination=destination,
+        passengers=passengers,
+        request_id=request_id,
+        language=language,
+        history=[_event(TransportStatusEnum.REQUESTED.value, now, guest_id)]
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["transport_requests"].insert_one(request.model_dump(exclude={"id"}))
+    logger.info("transport_requested", transport_id=request.transport_id, mode=mode, pickup_at=pickup_at.isoformat())
+    return request
+
+async def dispatch(request: TransportRequest) -> Optional[dict]:
+    """Offers the ride to the connector; returns the updated record, or None if the concierge must arrange it."""
+    try:
+        ride = await transport_connector.request_ride(request)
+    except Exception as e:
+        logger.error("transport_dispatch_failed", transport_id=request.transport_id,
+                     connector=transport_connector.name, error=str(e))
+        return None
+    if not ride:
+        return None
+    return await apply_update(
+        request.transport_id,
+        status=ride.get("status") or TransportStatusEnum.CONFIRMED.value,
+        eta_minutes=ride.get("eta_minutes"),
+        driver=ride.get("driver"),
+        provider=transport_connector.name,
+        provider_ref=ride.get("ride_id"),
+        by=transport_connector.name
+    )
+
+async def get_transport_request(transport_id: str, guest_id: Optional[str] = None) -> dict:
+    query = {"transport_id": transport_id}
+    if guest_id:
+        query["guest_id"] = guest_id
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["transport_requests"].find_one(query)
+    if not doc:
+        raise TransportError("Transport request not found", 404)
+    return doc
+
+async def list_transport_requests(guest_id: Optional[str] = None, status: Optional[str] = None,
+                                  limit: int = 100) -> List[TransportRequest]:
+    query = {k: v for k, v in {"guest_id": guest_id, "status": status}.items() if v}
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["transport_requests"].find(query).sort("pickup_at", -1).to_list(length=limit)
+    return [TransportRequest(**doc) for doc in docs]
+
+async def apply_update(transport_id: Optional[str] = None, provider_ref: Optional[str] = None,
+                       status: Optional[str] = None, eta_minutes: Optional[int] = None,
+                       driver: Optional[dict] = None, by: Optional[str] = None, sync_work_order: bool = True,
+                       **fields) -> dict:
+    """
+    Records a status/driver/ETA change from the provider or staff, looked up by transport_id or, for
+    provider callbacks, provider_ref. Finished requests are left alone, so a late callback can't
+    reopen a completed or cancelled ride. A status change is carried over to the linked work order
+    unless it came from there (sync_work_order=False).
+    """
+    if status and status not in [s.value for s in TransportStatusEnum]:
+        raise TransportError(f"Unknown transport status '{status}'", 422)
+    query = {"transport_id": transport_id} if transport_id else {"provider_ref": provider_ref}
+    now = datetime.now(timezone.utc)
+    changes = {**fields, "updated_at": now}
+    if transport_id and provider_ref:
+        changes["provider_ref"] = provider_ref
+    if status:
+        changes["status"] = status
+    if driver:
+        changes["driver"] = driver
+    if eta_minutes is not None:
+        changes["eta_at"] = now + timedelta(minutes=eta_minutes)
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["transport_requests"].find_one_and_update(
+            {**query, "status": {"$nin": list(TERMINAL_STATUSES)}},
+            {"$set": changes,
+             "$push": {"history": _event(status or "updated", now, by, eta_minutes=eta_minutes)}},
+            return_document=ReturnDocument.AFTER
+        )
+        if not doc:
+            existing = await conn["virtualbutler"]["transport_requests"].find_one(query)
+            if not existing:
+                raise TransportError("Transport request not found", 404)
+            raise TransportError(f"Transport request is already {existing['status']}", 409)
+    logger.info("transport_updated", transport_id=doc["transport_id"], status=doc["status"],
+                eta_at=doc.get("eta_at"), by=by)
+    if status and sync_work_order and doc.get("work_order_id"):
+        await update_work_order_status(doc, WORK_ORDER_STATUS_FOR.get(status), by)
+    return doc
+
+# --- Work orders ---
+
+async def link_work_order(request_id: str, work_order_id: str) -> Optional[str]:
+    """Called by the work-order consumer once the concierge's order exists; returns the linked transport_id."""
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["transport_requests"].find_one_and_update(
+            {"request_id": request_id},
+            {"$set": {"work_order_id": work_order_id, "updated_at": datetime.now(timezone.utc)}},
+            projection={"transport_id": 1}
+        )
+    if not doc:
+        return None
+    logger.info("transport_work_order_linked", transport_id=doc["transport_id"], work_order_id=work_order_id)
+    return doc["transport_id"]
+
+async def update_work_order_status(transport: dict, status: Optional[str], by: Optional[str]) -> Optional[dict]:
+    """
+    Moves the linked order along with the ride. Same shape of update and activity entry as the work-order
+    service's own; an order that is already finished, or already in that status, is left alone.
+    """
+    if not status:
+        return None
+    now = datetime.now(timezone.utc)
+    work_order_id = transport["work_order_id"]
+    async with DatabaseConnection.get_connection() as conn:
+        before = await conn["virtualbutler"]["work_orders"].find_one_and_update(
+            {"work_order_id": work_order_id,
+             "status": {"$nin": [StatusEnum.COMPLETED, StatusEnum.CANCELLED, status]}},
+            {"$set": {"status": status, "updated_at": now}, "$inc": {"version": 1}}
+        )
+        if not before:
+            return None
+        await conn["virtualbutler"]["work_order_activity"].insert_one({
+            "work_order_id": work_order_id,
+            "action": "transport_updated",
+            "actor": by,
+            "changes": {"status": {"from": before["status"], "to": status}},
+            "reason": f"transport {transport['transport_id']} is {transport['status']}",
+            "timestamp": now
+        })
+    logger.info("transport_work_order_updated", transport_id=transport["transport_id"],
+                work_order_id=work_order_id, status=status)
+    return before
+
+async def sync_from_work_order(work_order: dict, status: str, by: Optional[str]) -> Optional[dict]:
+    """The work-order side of the link: a finished or cancelled order finishes or cancels its ride."""
+    transport_status = TRANSPORT_STATUS_FOR.get(status)
+    if not transport_status:
+        return None
+    async with DatabaseConnection.get_connection() as conn:
+        transport = await conn["virtualbutler"]["transport_requests"].find_one(
+            {"work_order_id": work_order["work_order_id"]}, {"transport_id": 1}
+        )
+    if not transport:
+        return None
+    try:
+        return await apply_update(transport["transport_id"], status=transport_status.value, by=by,
+                                  sync_work_order=False)
+    except TransportError:
+        # The ride already ended on its own (provider callback or guest cancellation)
+        return None
+
+async def cancel_transport_request(transport_id: str, guest_id: Optional[str] = None,
+                                   by: Optional[str] = None) -> dict:
+    doc = await get_transport_request(transport_id, guest_id)
+    if doc.get("provider_ref") and doc.get("provider") == transport_connector.name:
+        try:
+            await transport_connector.cancel_ride(doc["provider_ref"])
+        except Exception as e:
+            # The provider may still send a driver; keep going so staff see the cancellation
+            logger.error("transport_cancel_failed", transport_id=transport_id, error=str(e))
+    return await apply_update(transport_id, status=TransportStatusEnum.CANCELLED.value, by=by or guest_id)
+
+async def ensure_transport_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["transport_requests"]
+        await coll.create_index("transport_id", unique=True)
+        await coll.create_index("provider_ref", sparse=True)
+        await coll.create_index("request_id", sparse=True)
+        await coll.create_index("work_order_id", sparse=True)
+        await coll.create_index([("guest_id", 1), ("pickup_at", -1)])
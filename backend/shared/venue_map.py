@@ -0,0 +1,142 @@
+"""
+Venue map for resorts whose guests ask for things away from their room: a villa, the beach, the pool deck.
+
+Areas are outlines of (latitude, longitude) points; service hubs are where staff set out from (the beach
+hut, the villa pantry), each serving some departments. The guest app may send its location with a
+request, and it is only kept when the guest agreed to share it (`consent`); otherwise it is dropped
+before anything is stored. A located request is placed in the area it falls in and sent to the nearest
+hub that serves its department. Hubs listing areas are preferred for requests from those areas whatever
+the distance, e.g. the beach hut for the whole beach. Coordinates are stored to 5 decimals (about a metre).
+"""
+from typing import List, Optional, Tuple
+
+import structlog
+from pydantic import BaseModel, Field
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import DepartmentEnum, GuestLocation
+from shared.recommendations import distance_km
+
+logger = structlog.get_logger()
+
+COORDINATE_DECIMALS = 5
+
+class VenueMapError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+class GeoPoint(BaseModel):
+    latitude: float = Field(..., ge=-90, le=90)
+    longitude: float = Field(..., ge=-180, le=180)
+
+class VenueArea(BaseModel):
+    area_id: str = Field(..., pattern=r"^[a-z0-9_-]{1,40}$")
+    name: str = Field(..., min_length=1, max_length=80)
+    kind: Optional[str] = Field(None, max_length=40, description="e.g. villa, beach, pool")
+    boundary: List[GeoPoint] = Field(..., min_length=3, max_length=200, description="Outline, in order")
+
+class ServiceHub(BaseModel):
+    hub_id: str = Field(..., pattern=r"^[a-z0-9_-]{1,40}$")
+    name: str = Field(..., min_length=1, max_length=80)
+    latitude: float = Field(..., ge=-90, le=90)
+    longitude: float = Field(..., ge=-180, le=180)
+    departments: List[DepartmentEnum] = Field(..., min_length=1)
+    areas: List[str] = Field(default_factory=list, description="Areas this hub covers ahead of nearer hubs")
+
+    class Config:
+        use_enum_values = True
+
+def shared_location(location: Optional[GuestLocation]) -> Optional[dict]:
+    """The location as stored with the request, or None without the guest's consent."""
+    if location is None or not location.consent:
+        return None
+    return {"latitude": round(location.latitude, COORDINATE_DECIMALS),
+            "longitude": round(location.longitude, COORDINATE_DECIMALS),
+            "accuracy_m": location.accuracy_m}
+
+def contains(area: VenueArea, latitude: float, longitude: float) -> bool:
+    """Ray casting; fine at resort scale, where the outline is close enough to flat."""
+    inside = False
+    points = area.boundary
+    for a, b in zip(points, points[1:] + points[:1]):
+        if (a.latitude > latitude) != (b.latitude > latitude):
+            crossing = a.longitude + (latitude - a.latitude) * (b.longitude - a.longitude) / (b.latitude - a.latitude)
+            if longitude < crossing:
+                inside = not inside
+    return inside
+
+def area_at(areas: List[VenueArea], latitude: float, longitude: float) -> Optional[VenueArea]:
+    return next((area for area in areas if contains(area, latitude, longitude)), None)
+
+def nearest_hub(hubs: List[ServiceHub], department: str, latitude: float, longitude: float,
+                area_id: Optional[str] = None) -> Optional[Tuple[ServiceHub, float]]:
+    """The hub to send the order to and its distance in km; None if no hub serves the department."""
+    serving = [h for h in hubs if department in h.departments]
+    covering = [h for h in serving if area_id and area_id in h.areas]
+    candidates = covering or serving
+    if not candidates:
+        return None
+    return min(((h, distance_km(latitude, longitude, h.latitude, h.longitude)) for h in candidates),
+               key=lambda pair: (pair[1], pair[0].hub_id))
+
+def locate(location: dict, department: str, areas: List[VenueArea], hubs: List[ServiceHub]) -> dict:
+    """The stored location plus the area it falls in and the hub routed to."""
+    latitude, longitude = location["latitude"], location["longitude"]
+    area = area_at(areas, latitude, longitude)
+    hub = nearest_hub(hubs, department, latitude, longitude, area.area_id if area else None)
+    return {
+        **location,
+        "area_id": area.area_id if area else None,
+        "area_name": area.name if area else None,
+        "hub_id": hub[0].hub_id if hub else None,
+        "hub_name": hub[0].name if hub else None,
+        "hub_distance_m": round(hub[1] * 1000) if hub else None,
+    }
+
+async def list_areas() -> List[VenueArea]:
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["venue_areas"].find({}, {"_id": 0}).sort("area_id", 1).to_list(length=None)
+    return [VenueArea(**doc) for doc in docs]
+
+async def save_area(area: VenueArea) -> VenueArea:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["venue_areas"].replace_one({"area_id": area.area_id}, area.model_dump(), upsert=True)
+    logger.info("venue_area_saved", area_id=area.area_id, points=len(area.boundary))
+    return area
+
+async def delete_area(area_id: str) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["venue_areas"].delete_one({"area_id": area_id})
+    if not result.deleted_count:
+        raise VenueMapError("Area not found", 404)
+    logger.info("venue_area_deleted", area_id=area_id)
+
+async def list_hubs() -> List[ServiceHub]:
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["service_hubs"].find({}, {"_id": 0}).sort("hub_id", 1).to_list(length=None)
+    return [ServiceHub(**doc) for doc in docs]
+
+async def save_hub(hub: ServiceHub) -> ServiceHub:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["service_hubs"].replace_one({"hub_id": hub.hub_id}, hub.model_dump(), upsert=True)
+    logger.info("service_hub_saved", hub_id=hub.hub_id, departments=hub.departments)
+    return hub
+
+async def delete_hub(hub_id: str) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["service_hubs"].delete_one({"hub_id": hub_id})
+    if not result.deleted_count:
+        raise VenueMapError("Service hub not found", 404)
+    logger.info("service_hub_deleted", hub_id=hub_id)
+
+async def locate_request(location: Optional[dict], department: str) -> Optional[dict]:
+    """Area and hub for a located request; the bare location while no hubs are set up."""
+    if not location:
+        return None
+    return locate(location, department, await list_areas(), await list_hubs())
+
+async def ensure_venue_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["venue_areas"].create_index("area_id", unique=True)
+        await conn["virtualbutler"]["service_hubs"].create_index("hub_id", unique=True)
@@ -0,0 +1,204 @@
+"""
+Wake-up calls: scheduled from chat or the API, rung through the hotel's voice gateway (or the
+in-room device when no phone integration is configured), retried until the guest confirms, and
+escalated to the front desk after WAKEUP_MAX_ATTEMPTS unanswered attempts.
+"""
+import os
+import re
+import uuid
+from datetime import datetime, timedelta, timezone
+from typing import List, Optional
+
+import httpx
+import structlog
+from pymongo import ReturnDocument
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import WakeUpCall, WakeUpCallStatusEnum
+
+logger = structlog.get_logger()
+
+WAKEUP_VOICE_URL = os.getenv("WAKEUP_VOICE_URL")
+WAKEUP_ROOM_DEVICE_URL = os.getenv("WAKEUP_ROOM_DEVICE_URL")
+WAKEUP_GATEWAY_TOKEN = os.getenv("WAKEUP_GATEWAY_TOKEN")
+WAKEUP_MAX_ATTEMPTS = int(os.getenv("WAKEUP_MAX_ATTEMPTS", "3"))
+WAKEUP_RETRY_MINUTES = int(os.getenv("WAKEUP_RETRY_MINUTES", "3"))
+
+WAKEUP_PATTERN = re.compile(r"wake.?up call|wake me|morning call|alarm call")
+WAKEUP_CANCEL_PATTERN = re.compile(r"cancel|no longer|don'?t need|do not need")
+AWAKE_PATTERN = re.compile(
+    r"^\s*(ok(ay)?,? )?((i'?m|i am) (up|awake)|je suis r[ée]veill[ée]e?|estoy despiert[oa])[\s.!]*$"
+)
+PENDING_STATUSES = [WakeUpCallStatusEnum.SCHEDULED, WakeUpCallStatusEnum.RINGING]
+
+class WakeUpCallError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+def detect_wake_up_command(message: str) -> Optional[str]:
+    """Returns 'schedule', 'cancel' or 'confirm' for wake-up call messages, None otherwise."""
+    text = message.lower()
+    if AWAKE_PATTERN.search(text):
+        return "confirm"
+    if not WAKEUP_PATTERN.search(text):
+        return None
+    return "cancel" if WAKEUP_CANCEL_PATTERN.search(text) else "schedule"
+
+async def schedule_wake_up_call(guest_id: str, room_number: str, scheduled_for: datetime, tz_name: str,
+                                language: str = "en", created_by: Optional[str] = None) -> WakeUpCall:
+    """Schedules a call; a guest has at most one pending call per room, so a new time replaces the old one."""
+    scheduled_for = scheduled_for.astimezone(timezone.utc)
+    if scheduled_for <= datetime.now(timezone.utc):
+        raise WakeUpCallError("Wake-up time must be in the future")
+    call = WakeUpCall(
+        call_id=f"wake_{uuid.uuid4().hex[:12]}",
+        guest_id=guest_id,
+        room_number=room_number,
+        scheduled_for=scheduled_for,
+        timezone=tz_name,
+        language=language,
+        next_attempt_at=scheduled_for,
+        created_by=created_by
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["wake_up_calls"]
+        await coll.update_many(
+            {"guest_id": guest_id, "room_number": room_number, "status": WakeUpCallStatusEnum.SCHEDULED},
+            {"$set": {"status": WakeUpCallStatusEnum.CANCELLED, "updated_at": datetime.now(timezone.utc)}}
+        )
+        await coll.insert_one(call.model_dump(exclude={"id"}))
+    logger.info("wake_up_call_scheduled", call_id=call.call_id, room_number=room_number,
+                scheduled_for=scheduled_for.isoformat())
+    return call
+
+async def list_wake_up_calls(guest_id: Optional[str] = None, room_number: Optional[str] = None,
+                             status: Optional[WakeUpCallStatusEnum] = None) -> List[WakeUpCall]:
+    query = {}
+    if guest_id:
+        query["guest_id"] = guest_id
+    if room_number:
+        query["room_number"] = room_number
+    if status:
+        query["status"] = status
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["wake_up_calls"].find(query).sort("scheduled_for", 1).to_list(length=200)
+    return [WakeUpCall(**doc) for doc in docs]
+
+async def get_wake_up_call(call_id: str) -> dict:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["wake_up_calls"].find_one({"call_id": call_id})
+    if not doc:
+        raise WakeUpCallError("Wake-up call not found", 404)
+    return doc
+
+async def cancel_wake_up_calls(guest_id: str, call_id: Optional[str] = None) -> int:
+    """Cancels the guest's pending calls (or just call_id); returns how many were cancelled."""
+    query = {"guest_id": guest_id, "status": {"$in": PENDING_STATUSES}}
+    if call_id:
+        query["call_id"] = call_id
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["wake_up_calls"].update_many(
+            query, {"$set": {"status": WakeUpCallStatusEnum.CANCELLED, "updated_at": datetime.now(timezone.utc)}}
+        )
+    logger.info("wake_up_calls_cancelled", guest_id=guest_id, call_id=call_id, count=result.modified_count)
+    return result.modified_count
+
+async def confirm_wake_up_call(via: str, call_id: Optional[str] = None,
+                               guest_id: Optional[str] = None) -> Optional[dict]:
+    """Marks a ringing call as confirmed, by id or (from chat) the guest's currently ringing call."""
+    query = {"status": WakeUpCallStatusEnum.RINGING}
+    if call_id:
+        query["call_id"] = call_id
+    if guest_id:
+        query["guest_id"] = guest_id
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["wake_up_calls"].find_one_and_update(
+            query,
+            {"$set": {"status": WakeUpCallStatusEnum.CONFIRMED, "confirmed_at": now, "confirmed_via": via,
+                      "updated_at": now},
+             "$unset": {"next_attempt_at": ""}},
+            sort=[("scheduled_for", 1)],
+            return_document=ReturnDocument.AFTER
+        )
+    if doc:
+        logger.info("wake_up_call_confirmed", call_id=doc["call_id"], via=via, attempts=doc.get("attempts"))
+    return doc
+
+# --- Scheduler ---
+
+async def claim_due_call(now: datetime) -> Optional[dict]:
+    """
+    Atomically takes the next call due for an attempt and pushes its next attempt out by
+    WAKEUP_RETRY_MINUTES, so several replicas never ring the same room twice.
+    """
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["wake_up_calls"].find_one_and_update(
+            {"status": {"$in": PENDING_STATUSES}, "next_attempt_at": {"$lte": now},
+             "attempts": {"$lt": WAKEUP_MAX_ATTEMPTS}},
+            {"$set": {"status": WakeUpCallStatusEnum.RINGING, "updated_at": now,
+                      "next_attempt_at": now + timedelta(minutes=WAKEUP_RETRY_MINUTES)},
+             "$inc": {"attempts": 1}},
+            sort=[("next_attempt_at", 1)],
+            return_document=ReturnDocument.AFTER
+        )
+
+async def claim_missed_call(now: datetime) -> Optional[dict]:
+    """Takes the next call whose final attempt went unconfirmed and marks it escalated."""
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["wake_up_calls"].find_one_and_update(
+            {"status": WakeUpCallStatusEnum.RINGING, "next_attempt_at": {"$lte": now},
+             "attempts": {"$gte": WAKEUP_MAX_ATTEMPTS}},
+            {"$set": {"status": WakeUpCallStatusEnum.ESCALATED, "escalated_at": now, "updated_at": now},
+             "$unset": {"next_attempt_at": ""}},
+            return_document=ReturnDocument.AFTER
+        )
+
+async def mark_escalated(call_id: str, work_order_id: Optional[str]) -> None:
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["wake_up_calls"].update_one(
+            {"call_id": call_id},
+            {"$set": {"status": WakeUpCallStatusEnum.ESCALATED, "escalated_at": now,
+                      "work_order_id": work_order_id, "updated_at": now},
+             "$unset": {"next_attempt_at": ""}}
+        )
+
+async def ring_room(call: dict, callback_url: Optional[str] = None) -> Optional[str]:
+    """
+    Delivers one attempt through the voice gateway, falling back to the room device.
+    Returns the channel used, or None if neither integration accepted the call.
+    """
+    payload = {
+        "call_id": call["call_id"],
+        "room_number": call["room_number"],
+        "language": call.get("language", "en"),
+        "attempt": call.get("attempts", 1),
+        "callback_url": callback_url
+    }
+    headers = {"Authorization": f"Bearer {WAKEUP_GATEWAY_TOKEN}"} if WAKEUP_GATEWAY_TOKEN else {}
+    for channel, url in (("voice", WAKEUP_VOICE_URL), ("room_device", WAKEUP_ROOM_DEVICE_URL)):
+        if not url:
+            continue
+        try:
+            async with httpx.AsyncClient(timeout=10.0) as client:
+                resp = await client.post(url, json={**payload, "channel": channel}, headers=headers)
+                resp.raise_for_status()
+        except Exception as e:
+            logger.error("wake_up_delivery_failed", call_id=call["call_id"], channel=channel, error=str(e))
+            continue
+        async with DatabaseConnection.get_connection() as conn:
+            await conn["virtualbutler"]["wake_up_calls"].update_one(
+                {"call_id": call["call_id"]}, {"$set": {"last_channel": channel}}
+            )
+        logger.info("wake_up_call_rung", call_id=call["call_id"], channel=channel, attempt=payload["attempt"])
+        return channel
+    return None
+
+async def ensure_wake_up_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["wake_up_calls"]
+        await coll.create_index("call_id", unique=True)
+        await coll.create_index([("status", 1), ("next_attempt_at", 1)])
+        await coll.create_index([("guest_id", 1), ("status", 1)])
@@ -0,0 +1,84 @@
+"""
+Labor time and material costs per work order, and the per-department totals operations managers report on.
+
+Staff start a timer when they begin working on an order and stop it when they leave; each run is a
+`labor` entry, and several staff can time the same order at once. Timers still running when the order
+is completed or cancelled are stopped then. Materials and other costs are booked as `costs` entries in
+the hotel's currency. The order keeps running totals (`labor_minutes`, `material_cost`) so the reports
+can aggregate without unwinding the entries.
+"""
+import uuid
+from datetime import datetime
+from typing import List, Optional
+
+from shared.clock import as_utc
+from shared.db.database import DatabaseConnection
+from shared.db.models import StatusEnum
+
+class WorkCostError(Exception):
+    def __init__(self, message: str, status_code: int = 409):
+        super().__init__(message)
+        self.status_code = status_code
+
+def running_entry(labor: List[dict], staff_id: str) -> Optional[dict]:
+    return next((e for e in labor if e["staff_id"] == staff_id and not e.get("stopped_at")), None)
+
+def start_timer(labor: List[dict], staff_id: str, now: datetime) -> dict:
+    if running_entry(labor, staff_id):
+        raise WorkCostError("Timer is already running")
+    return {"staff_id": staff_id, "started_at": now, "stopped_at": None, "minutes": None}
+
+def stopped(entry: dict, now: datetime) -> dict:
+    minutes = max((now - as_utc(entry["started_at"])).total_seconds() / 60, 0)
+    return {**entry, "stopped_at": now, "minutes": round(minutes, 1)}
+
+def stop_timer(labor: List[dict], staff_id: str, now: datetime) -> List[dict]:
+    entry = running_entry(labor, staff_id)
+    if not entry:
+        raise WorkCostError("No timer running")
+    return [stopped(e, now) if e is entry else e for e in labor]
+
+def stop_all(labor: List[dict], now: datetime) -> List[dict]:
+    return [e if e.get("stopped_at") else stopped(e, now) for e in labor]
+
+def labor_minutes(labor: List[dict]) -> float:
+    return round(sum(e.get("minutes") or 0 for e in labor), 1)
+
+def cost_entry(description: str, quantity: float, unit_cost: float, staff_id: str, now: datetime) -> dict:
+    return {"cost_id": f"cost_{uuid.uuid4().hex[:12]}", "description": description, "quantity": quantity,
+            "unit_cost": unit_cost, "total": round(quantity * unit_cost, 2), "added_by": staff_id, "added_at": now}
+
+def material_cost(costs: List[dict]) -> float:
+    return round(sum(c["total"] for c in costs), 2)
+
+async def cost_report(start: datetime, end: datetime, departments: Optional[List[str]] = None) -> List[dict]:
+    """Labor and cost totals per department for orders created in [start, end)."""
+    match = {"created_at": {"$gte": start, "$lt": end}, "status": {"$ne": StatusEnum.CANCELLED.value}}
+    if departments:
+        match["department"] = {"$in": departments}
+    pipeline = [
+        {"$match": match},
+        {"$group": {
+            "_id": "$department",
+            "orders": {"$sum": 1},
+            "completed": {"$sum": {"$cond": [{"$eq": ["$status", StatusEnum.COMPLETED.value]}, 1, 0]}},
+            "labor_minutes": {"$sum": {"$ifNull": ["$labor_minutes", 0]}},
+            "material_cost": {"$sum": {"$ifNull": ["$material_cost", 0]}},
+        }},
+        {"$sort": {"_id": 1}}
+    ]
+    async with DatabaseConnection.get_connection() as conn:
+        groups = await conn["virtualbutler"]["work_orders"].aggregate(pipeline).to_list(length=None)
+    return [department_costs(group) for group in groups]
+
+def department_costs(group: dict) -> dict:
+    completed = group["completed"]
+    return {
+        "department": group["_id"],
+        "orders": group["orders"],
+        "completed": completed,
+        "labor_hours": round(group["labor_minutes"] / 60, 2),
+        "material_cost": round(group["material_cost"], 2),
+        "labor_minutes_per_completed": round(group["labor_minutes"] / completed, 1) if completed else None,
+        "material_cost_per_completed": round(group["material_cost"] / completed, 2) if completed else None,
+    }
@@ -0,0 +1,114 @@
+"""
+Multi-step guest service workflows (valet, luggage) carried on a work order as `workflow`.
+
+Each workflow type has a fixed sequence of steps. Staff move an order forward one step at a time;
+each step may have a target duration, and the order is flagged overdue when a step runs past it.
+The current step drives the work-order status and the progress the guest sees.
+"""
+import re
+from dataclasses import dataclass
+from datetime import datetime, timedelta
+from typing import Dict, List, Optional
+
+from shared.db.models import StatusEnum, WorkflowTypeEnum
+
+@dataclass(frozen=True)
+class WorkflowStep:
+    name: str
+    target_minutes: Optional[int] = None   # None: no timer (e.g. bags stored until the guest asks)
+
+WORKFLOWS: Dict[str, List[WorkflowStep]] = {
+    WorkflowTypeEnum.VALET.value: [
+        WorkflowStep("requested", 5),
+        WorkflowStep("retrieving", 15),
+        WorkflowStep("at_door", 10),
+        WorkflowStep("handed_over"),
+    ],
+    WorkflowTypeEnum.LUGGAGE.value: [
+        WorkflowStep("requested", 15),
+        WorkflowStep("picked_up", 20),
+        WorkflowStep("stored"),
+        WorkflowStep("delivered"),
+    ],
+}
+
+VALET_PATTERN = re.compile(r"\bvalet\b|\b(bring|get|fetch) (round )?my car\b|\bmy car (ready|brought|round)\b")
+LUGGAGE_NOUN = r"(luggage|baggage|bags?|suitcases?)"
+# A handling verb with the luggage a few words after it ("store our two bags"), or the other way round
+LUGGAGE_REQUEST_PATTERN = re.compile(
+    r"\b(pick ?up|collect|store|hold|keep|take down|bring down|carry|porter)\b(\W+\w+){0,4}?\W+" + LUGGAGE_NOUN + r"\b"
+    r"|\b" + LUGGAGE_NOUN + r"\b(\W+\w+){0,3}?\W+(picked up|collected|stored|held|kept|taken down|brought down)\b"
+)
+# Lost, stolen or late luggage is a complaint for the front desk, not a porter job
+LUGGAGE_PROBLEM_PATTERN = re.compile(r"\b(stolen|stole|theft|lost|missing|can'?t find|cannot find|damaged|broken|"
+                                     r"(hasn'?t|has not|didn'?t|did not|never) (arrived|come|turned up))\b")
+
+class WorkflowError(ValueError): pass
+
+def detect_workflow(message: str) -> Optional[str]:
+    text = message.lower()
+    if VALET_PATTERN.search(text):
+        return WorkflowTypeEnum.VALET.value
+    if LUGGAGE_REQUEST_PATTERN.search(text) and not LUGGAGE_PROBLEM_PATTERN.search(text):
+        return WorkflowTypeEnum.LUGGAGE.value
+    return None
+
+def steps_for(workflow_type: str) -> List[WorkflowStep]:
+    if workflow_type not in WORKFLOWS:
+        raise WorkflowError(f"Unknown workflow '{workflow_type}'")
+    return WORKFLOWS[workflow_type]
+
+def _due_at(step: WorkflowStep, started_at: datetime) -> Optional[datetime]:
+    return started_at + timedelta(minutes=step.target_minutes) if step.target_minutes else None
+
+def start_workflow(workflow_type: str, now: datetime, actor: Optional[str] = None) -> dict:
+    first = steps_for(workflow_type)[0]
+    return {
+        "type": workflow_type,
+        "step": first.name,
+        "step_started_at": now,
+        "due_at": _due_at(first, now),
+        "overdue": False,
+        "history": [{"step": first.name, "at": now, "by": actor}],
+    }
+
+def status_for_step(workflow_type: str, step: str) -> StatusEnum:
+    names = [s.name for s in steps_for(workflow_type)]
+    if step == names[0]:
+        return StatusEnum.PENDING
+    if step == names[-1]:
+        return StatusEnum.COMPLETED
+    return StatusEnum.IN_PROGRESS
+
+def advance_workflow(state: dict, to_step: str, now: datetime, actor: Optional[str] = None) -> dict:
+    """Moves to the next step; skipping or going back raises WorkflowError."""
+    steps = steps_for(state["type"])
+    names = [s.name for s in steps]
+    current = names.index(state["step"])
+    if current == len(names) - 1:
+        raise WorkflowError(f"Workflow is already {state['step']}")
+    if to_step != names[current + 1]:
+        raise WorkflowError(f"Next step after '{state['step']}' is '{names[current + 1]}', not '{to_step}'")
+    step = steps[current + 1]
+    return {
+        **state,
+        "step": step.name,
+        "step_started_at": now,
+        "due_at": _due_at(step, now),
+        "overdue": False,
+        "history": state.get("history", []) + [{"step": step.name, "at": now, "by": actor}],
+    }
+
+def workflow_progress(state: dict) -> dict:
+    """Guest-visible view: the steps, where the order is, and when the current step should be done."""
+    names = [s.name for s in steps_for(state["type"])]
+    reached = {h["step"]: h["at"] for h in state.get("history", [])}
+    return {
+        "type": state["type"],
+        "step": state["step"],
+        "step_index": names.index(state["step"]) + 1,
+        "total_steps": len(names),
+        "steps": [{"name": n, "reached_at": reached.get(n)} for n in names],
+        "due_at": state.get("due_at"),
+        "overdue": state.get("overdue", False),
+    }
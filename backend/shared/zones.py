@@ -0,0 +1,152 @@
+"""
+Optional zone model (floors and wings) so new orders go to the nearest on-shift attendant.
+
+A zone is a floor, optionally split into wings, plus the rooms it covers. Rooms not listed anywhere
+fall into a zone on their floor, read from the room number (1204 -> floor 12). Attendants report
+their shift and current zone from the staff app (PUT /staff/me/location). A report older than
+STAFF_ZONE_STALE_MINUTES still counts the attendant as on shift, but not as being anywhere in particular.
+Attendants whose app has gone quiet (offline, see shared/presence.py) are skipped.
+
+Distance between zones is ZONE_FLOOR_DISTANCE per floor apart, plus one for a different wing. Ties go
+to whoever has the fewest open orders. With no zones defined nothing is auto-assigned.
+"""
+import os
+import re
+from datetime import datetime, timedelta, timezone
+from typing import Dict, List, Optional
+
+import structlog
+from pydantic import BaseModel, Field
+from pymongo import ReturnDocument
+
+from shared.db.database import DatabaseConnection
+from shared.db.models import StatusEnum
+from shared.presence import is_online
+
+logger = structlog.get_logger()
+
+STAFF_ZONE_STALE_MINUTES = int(os.getenv("STAFF_ZONE_STALE_MINUTES", "30"))
+# Changing floors (lifts, service stairs) costs more than walking to the other wing
+ZONE_FLOOR_DISTANCE = 2
+UNKNOWN_DISTANCE = 1000
+OPEN_ASSIGNED_STATUSES = [StatusEnum.ASSIGNED, StatusEnum.IN_PROGRESS]
+ROOM_FLOOR_PATTERN = re.compile(r"^(\d+)\d{2}[a-zA-Z]?$")
+
+class ZoneError(Exception):
+    def __init__(self, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.status_code = status_code
+
+class Zone(BaseModel):
+    zone_id: str = Field(..., pattern=r"^[a-z0-9_-]{1,40}$")
+    name: str = Field(..., min_length=1, max_length=80)
+    floor: int
+    wing: Optional[str] = None
+    rooms: List[str] = Field(default_factory=list, description="Rooms in the zone; others are placed by floor")
+
+class StaffLocationUpdate(BaseModel):
+    on_shift: bool
+    zone_id: Optional[str] = None
+
+def floor_of(room_number: Optional[str]) -> Optional[int]:
+    match = ROOM_FLOOR_PATTERN.match(room_number or "")
+    return int(match.group(1)) if match else None
+
+def zone_for_room(zones: List[Zone], room_number: Optional[str]) -> Optional[Zone]:
+    for zone in zones:
+        if room_number in zone.rooms:
+            return zone
+    floor = floor_of(room_number)
+    on_floor = [z for z in zones if z.floor == floor]
+    # Prefer zones without a room list (they take the rest of the floor), then whole-floor zones over wings
+    return min(on_floor, key=lambda z: (bool(z.rooms), z.wing is not None, z.zone_id)) if on_floor else None
+
+def zone_distance(a: Optional[Zone], b: Optional[Zone]) -> int:
+    if a is None or b is None:
+        return UNKNOWN_DISTANCE
+    if a.zone_id == b.zone_id:
+        return 0
+    return abs(a.floor - b.floor) * ZONE_FLOOR_DISTANCE + (1 if a.wing != b.wing else 0)
+
+def nearest_attendant(staff: List[dict], target: Optional[Zone], zones: Dict[str, Zone],
+                      open_orders: Dict[str, int], now: datetime) -> Optional[str]:
+    """The online, on-shift attendant closest to `target`, then least busy; None if nobody is available."""
+    stale_before = now - timedelta(minutes=STAFF_ZONE_STALE_MINUTES)
+
+    def current_zone(member: dict) -> Optional[Zone]:
+        reported_at = member.get("zone_updated_at")
+        if reported_at is None:
+            return None
+        if reported_at.tzinfo is None:
+            reported_at = reported_at.replace(tzinfo=timezone.utc)
+        return zones.get(member.get("current_zone")) if reported_at >= stale_before else None
+
+    on_shift = [m for m in staff if m.get("on_shift") and is_online(m, now)]
+    if not on_shift:
+        return None
+    best = min(on_shift, key=lambda m: (zone_distance(current_zone(m), target),
+                                        open_orders.get(m["staff_id"], 0), m["staff_id"]))
+    return best["staff_id"]
+
+async def list_zones() -> List[Zone]:
+    async with DatabaseConnection.get_connection() as conn:
+        docs = await conn["virtualbutler"]["zones"].find({}, {"_id": 0}).sort([("floor", 1), ("zone_id", 1)]).to_list(length=None)
+    return [Zone(**doc) for doc in docs]
+
+async def save_zone(zone: Zone) -> Zone:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["zones"].replace_one({"zone_id": zone.zone_id}, zone.model_dump(), upsert=True)
+    logger.info("zone_saved", zone_id=zone.zone_id, floor=zone.floor, wing=zone.wing)
+    return zone
+
+async def delete_zone(zone_id: str) -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["zones"].delete_one({"zone_id": zone_id})
+    if not result.deleted_count:
+        raise ZoneError("Zone not found", 404)
+    logger.info("zone_deleted", zone_id=zone_id)
+
+async def update_staff_location(staff_id: str, update: StaffLocationUpdate, now: Optional[datetime] = None) -> dict:
+    now = now or datetime.now(timezone.utc)
+    changes = {"on_shift": update.on_shift, "updated_at": now}
+    if update.zone_id is not None:
+        async with DatabaseConnection.get_connection() as conn:
+            if not await conn["virtualbutler"]["zones"].find_one({"zone_id": update.zone_id}):
+                raise ZoneError("Unknown zone", 422)
+        changes.update({"current_zone": update.zone_id, "zone_updated_at": now})
+    elif not update.on_shift:
+        # Off shift means nowhere in particular
+        changes.update({"current_zone": None, "zone_updated_at": None})
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["staff_profiles"].find_one_and_update(
+            {"staff_id": staff_id}, {"$set": changes}, projection={"_id": 0},
+            return_document=ReturnDocument.AFTER
+        )
+    if not doc:
+        raise ZoneError("Staff profile not found", 404)
+    logger.info("staff_location_updated", staff_id=staff_id, on_shift=update.on_shift, zone_id=doc.get("current_zone"))
+    return doc
+
+async def pick_attendant(department: str, room_number: Optional[str], now: Optional[datetime] = None) -> Optional[str]:
+    """Nearest on-shift attendant in `department` for an order in `room_number`; None when zones aren't set up."""
+    zones = await list_zones()
+    if not zones:
+        return None
+    async with DatabaseConnection.get_connection() as conn:
+        staff = await conn["virtualbutler"]["staff_profiles"].find(
+            {"department": department, "role": "staff", "on_shift": True}, {"_id": 0}
+        ).to_list(length=None)
+        if not staff:
+            return None
+        counts = await conn["virtualbutler"]["work_orders"].aggregate([
+            {"$match": {"assigned_staff": {"$in": [m["staff_id"] for m in staff]},
+                        "status": {"$in": OPEN_ASSIGNED_STATUSES}}},
+            {"$group": {"_id": "$assigned_staff", "count": {"$sum": 1}}}
+        ]).to_list(length=None)
+    return nearest_attendant(staff, zone_for_room(zones, room_number), {z.zone_id: z for z in zones},
+                             {d["_id"]: d["count"] for d in counts}, now or datetime.now(timezone.utc))
+
+async def ensure_zone_indexes() -> None:
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["zones"].create_index("zone_id", unique=True)
+        await conn["virtualbutler"]["staff_profiles"].create_index([("department", 1), ("on_shift", 1)])
@@ -0,0 +1,22 @@
+import sys
+from pathlib import Path
+
+import pytest
+
+# Services import their dependencies as top-level packages (shared.*), same as backend/main.py
+backend_dir = Path(__file__).resolve().parent.parent
+sys.path.append(str(backend_dir))
+
+def pytest_addoption(parser):
+    parser.addoption("--integration", action="store_true", help="Run the end-to-end tests in tests/integration (needs Docker)")
+
+def pytest_configure(config):
+    config.addinivalue_line("markers", "integration: end-to-end test against real MongoDB, run with --integration")
+
+def pytest_collection_modifyitems(config, items):
+    if config.getoption("--integration"):
+        return
+    skip = pytest.mark.skip(reason="integration test; run with --integration")
+    for item in items:
+        if "integration" in item.keywords:
+            item.add_marker(skip)
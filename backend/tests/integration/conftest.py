@@ -0,0 +1,93 @@
+"""
+End-to-end tests: the chatbot and work-order apps run in-process against a real MongoDB, with the
+work-order consumer draining the chat-request queue.
+
+Run: pytest backend/tests/integration --integration
+
+- MongoDB is started with testcontainers (needs Docker), unless INTEGRATION_MONGODB_URL points at one
+  CI already runs. The `virtualbutler` database there is dropped before every test.
+- Service Bus is the in-memory bus in service_bus.py, unless SERVICE_BUS_EMULATOR_CONN_STR points at
+  the Azure Service Bus emulator with a `chat-requests` queue.
+"""
+import asyncio
+import contextlib
+import os
+
+os.environ.setdefault("JWT_SECRET", "integration-test-secret")
+
+import httpx
+import pytest
+import pytest_asyncio
+
+import chatbot.main as chatbot_service
+import work_orders.main as work_orders_service
+from shared.db.database import DatabaseConnection
+from service_bus import InMemoryMessage, InMemoryServiceBus
+
+@pytest.fixture(scope="session")
+def mongodb_url():
+    if os.getenv("INTEGRATION_MONGODB_URL"):
+        yield os.environ["INTEGRATION_MONGODB_URL"]
+        return
+    from testcontainers.mongodb import MongoDbContainer
+    with MongoDbContainer("mongo:7.0") as mongo:
+        yield mongo.get_connection_url()
+
+@pytest_asyncio.fixture
+async def database(mongodb_url, monkeypatch):
+    monkeypatch.setenv("MONGODB_URL", mongodb_url)
+    await DatabaseConnection.connect()
+    async with DatabaseConnection.get_connection() as conn:
+        await conn.drop_database("virtualbutler")
+    await work_orders_service.ensure_dedup_indexes()
+    yield
+    await DatabaseConnection.close()
+
+@pytest.fixture
+def service_bus(monkeypatch):
+    emulator = os.getenv("SERVICE_BUS_EMULATOR_CONN_STR")
+    for service in (chatbot_service, work_orders_service):
+        monkeypatch.setattr(service, "AZURE_SERVICE_BUS_CONN_STR", emulator or "Endpoint=sb://in-memory/")
+        monkeypatch.setattr(service, "SERVICE_BUS_SESSIONS_ENABLED", False)
+    if emulator:
+        yield None
+        return
+    bus = InMemoryServiceBus()
+    monkeypatch.setattr(chatbot_service, "ServiceBusClient", bus)
+    monkeypatch.setattr(chatbot_service, "ServiceBusMessage", InMemoryMessage)
+    monkeypatch.setattr(work_orders_service, "ServiceBusClient", bus)
+    yield bus
+
+@pytest_asyncio.fixture
+async def consumer(database, service_bus):
+    task = asyncio.create_task(work_orders_service.consume_chat_requests())
+    yield task
+    task.cancel()
+    with contextlib.suppress(asyncio.CancelledError):
+        await task
+
+@pytest_asyncio.fixture
+async def chatbot(database):
+    transport = httpx.ASGITransport(app=chatbot_service.app)
+    async with httpx.AsyncClient(transport=transport, base_url="http://chatbot") as client:
+        yield client
+
+@pytest_asyncio.fixture
+async def work_orders(database):
+    transport = httpx.ASGITransport(app=work_orders_service.app)
+    async with httpx.AsyncClient(transport=transport, base_url="http://work-orders") as client:
+        yield client
+
+@pytest.fixture
+def guest_headers():
+    def headers(guest_id: str = "guest_it", room: str = "301") -> dict:
+        token = chatbot_service.key_ring.encode({"sub": guest_id, "role": "guest", "room": room})
+        return {"Authorization": f"Bearer {token}"}
+    return headers
+
+@pytest.fixture
+def admin_headers():
+    def headers(staff_id: str = "admin_it") -> dict:
+        token = chatbot_service.key_ring.encode({"sub": staff_id, "role": "admin"})
+        return {"Authorization": f"Bearer {token}"}
+    return headers
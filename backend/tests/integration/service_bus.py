@@ -0,0 +1,90 @@
+"""
+In-memory stand-in for the parts of azure.servicebus.aio the services use: queue senders and
+receivers with complete/abandon, enough to run the chatbot -> work-order path without a broker.
+"""
+import asyncio
+from collections import defaultdict
+from typing import Dict, List
+
+class InMemoryMessage:
+    def __init__(self, body, content_type=None, message_id=None, correlation_id=None, session_id=None,
+                 application_properties=None, **_):
+        self.body = body
+        self.content_type = content_type
+        self.message_id = message_id
+        self.correlation_id = correlation_id
+        self.session_id = session_id
+        self.application_properties = application_properties
+        self.scheduled_enqueue_time_utc = None
+        self.delivery_count = 0
+
+    def __str__(self):
+        return self.body
+
+class InMemoryServiceBus:
+    """Patched in for ServiceBusClient; `from_connection_string` ignores the connection string."""
+
+    def __init__(self):
+        self.queues: Dict[str, asyncio.Queue] = defaultdict(asyncio.Queue)
+        self.completed: List[InMemoryMessage] = []
+        self.settled = asyncio.Condition()
+
+    def from_connection_string(self, conn_str, **kwargs):
+        return _Client(self)
+
+    def send(self, queue_name: str, message: InMemoryMessage) -> None:
+        self.queues[queue_name].put_nowait(message)
+
+    async def wait_completed(self, count: int, timeout: float = 10.0) -> None:
+        async with self.settled:
+            await asyncio.wait_for(self.settled.wait_for(lambda: len(self.completed) >= count), timeout)
+
+    async def _settle(self, message: InMemoryMessage) -> None:
+        async with self.settled:
+            self.completed.append(message)
+            self.settled.notify_all()
+
+class _Context:
+    async def __aenter__(self):
+        return self
+
+    async def __aexit__(self, *exc):
+        return False
+
+class _Client(_Context):
+    def __init__(self, bus: InMemoryServiceBus):
+        self.bus = bus
+
+    def get_queue_sender(self, queue_name: str, **kwargs):
+        return _Sender(self.bus, queue_name)
+
+    def get_queue_receiver(self, queue_name: str, **kwargs):
+        return _Receiver(self.bus, queue_name)
+
+class _Sender(_Context):
+    def __init__(self, bus: InMemoryServiceBus, queue_name: str):
+        self.bus = bus
+        self.queue_name = queue_name
+
+    async def send_messages(self, messages, timeout=None):
+        for message in messages if isinstance(messages, list) else [messages]:
+            self.bus.send(self.queue_name, message)
+
+class _Receiver(_Context):
+    def __init__(self, bus: InMemoryServiceBus, queue_name: str):
+        self.bus = bus
+        self.queue_name = queue_name
+
+    def __aiter__(self):
+        return self
+
+    async def __anext__(self):
+        message = await self.bus.queues[self.queue_name].get()
+        message.delivery_count += 1
+        return message
+
+    async def complete_message(self, message):
+        await self.bus._settle(message)
+
+    async def abandon_message(self, message):
+        self.bus.send(self.queue_name, message)
@@ -0,0 +1,104 @@
+import os
+
+import pytest
+
+import work_orders.main as work_orders_service
+from shared.db.database import DatabaseConnection
+
+pytestmark = [pytest.mark.integration, pytest.mark.asyncio]
+
+async def post_chat(chatbot, headers, text: str, trace_id: str = None) -> dict:
+    if trace_id:
+        headers = {**headers, "X-Request-ID": trace_id}
+    response = await chatbot.post("/api/v1/chat", json={"text": text}, headers=headers)
+    assert response.status_code == 201, response.text
+    return response.json()
+
+async def test_chat_request_becomes_a_work_order(chatbot, work_orders, consumer, guest_headers):
+    chat = await post_chat(chatbot, guest_headers(), "Could I get some extra towels please?", trace_id="it-trace-1")
+    assert chat["department"] == "housekeeping"
+
+    # Long-polls until the consumer has picked the request off the queue
+    response = await work_orders.get(f"/api/v1/workorder/status/{chat['request_id']}",
+                                     params={"wait": "15s"}, headers=guest_headers())
+    assert response.status_code == 200, response.text
+    status = response.json()
+    assert status["status"] == "pending"
+    assert status["department"] == "housekeeping"
+
+    async with DatabaseConnection.get_connection() as conn:
+        order = await conn["virtualbutler"]["work_orders"].find_one({"request_id": chat["request_id"]})
+    assert order["work_order_id"] == status["work_order_id"]
+    assert order["guest_id"] == "guest_it"
+    assert order["metadata"]["source"] == "chat"
+    assert order["order_number"].startswith("HK-") and status["order_number"] == order["order_number"]
+    # The chatbot's request ID travels with the queued message onto the order
+    assert order["trace_id"] == "it-trace-1"
+
+async def test_order_numbers_work_wherever_ids_do(chatbot, work_orders, consumer, guest_headers, admin_headers):
+    chat = await post_chat(chatbot, guest_headers(), "Please bring two extra pillows")
+    response = await work_orders.get(f"/api/v1/workorder/status/{chat['request_id']}", params={"wait": "15s"},
+                                     headers=guest_headers())
+    number = response.json()["order_number"]
+
+    response = await work_orders.get(f"/api/v1/workorder/status/{number.lower()}", headers=guest_headers())
+    assert response.json()["request_id"] == chat["request_id"]
+    response = await work_orders.get(f"/work-orders/{number}", headers=admin_headers())
+    assert response.status_code == 200 and response.json()["order_number"] == number
+
+async def test_stale_if_match_gets_the_current_order(chatbot, work_orders, consumer, guest_headers, admin_headers):
+    chat = await post_chat(chatbot, guest_headers(), "The bathroom tap is dripping")
+    response = await work_orders.get(f"/api/v1/workorder/status/{chat['request_id']}", params={"wait": "15s"},
+                                     headers=guest_headers())
+    work_order_id = response.json()["work_order_id"]
+    response = await work_orders.get(f"/work-orders/{work_order_id}", headers=admin_headers())
+    seen = response.headers["ETag"]
+
+    first = await work_orders.patch(f"/work-orders/{work_order_id}/estimate", json={"estimated_duration": 20},
+                                    headers={**admin_headers(), "If-Match": seen})
+    assert first.status_code == 200, first.text
+    assert first.headers["ETag"] != seen
+    second = await work_orders.patch(f"/work-orders/{work_order_id}/estimate", json={"estimated_duration": 45},
+                                     headers={**admin_headers(), "If-Match": seen})
+    assert second.status_code == 409
+    assert second.headers["ETag"] == first.headers["ETag"]
+    assert second.json()["error"]["current"]["estimated_duration"] == 20
+
+async def test_other_guests_cannot_see_the_status(chatbot, work_orders, consumer, guest_headers):
+    chat = await post_chat(chatbot, guest_headers(), "The wifi keeps disconnecting")
+    await work_orders.get(f"/api/v1/workorder/status/{chat['request_id']}", params={"wait": "15s"},
+                          headers=guest_headers())
+
+    response = await work_orders.get(f"/api/v1/workorder/status/{chat['request_id']}",
+                                     headers=guest_headers("guest_other", "402"))
+    assert response.status_code == 404
+
+@pytest.mark.skipif(bool(os.getenv("SERVICE_BUS_EMULATOR_CONN_STR")), reason="redelivers through the in-memory bus")
+async def test_redelivered_message_creates_one_work_order(chatbot, work_orders, consumer, service_bus, guest_headers):
+    chat = await post_chat(chatbot, guest_headers(), "Please send up a bottle of water")
+    await service_bus.wait_completed(1)
+
+    # Same message again, as after a lost lock: the processed-message ledger drops it
+    service_bus.send(work_orders_service.AZURE_SERVICE_BUS_QUEUE, service_bus.completed[0])
+    await service_bus.wait_completed(2)
+
+    async with DatabaseConnection.get_connection() as conn:
+        count = await conn["virtualbutler"]["work_orders"].count_documents({"request_id": chat["request_id"]})
+    assert count == 1
+
+async def test_status_reads_queued_before_the_consumer_runs(chatbot, work_orders, service_bus, guest_headers):
+    chat = await post_chat(chatbot, guest_headers(), "Can someone bring an iron and ironing board?")
+
+    # No consumer yet: the stored chat request stands in for the order
+    response = await work_orders.get(f"/api/v1/workorder/status/{chat['request_id']}", headers=guest_headers())
+    assert response.status_code == 200, response.text
+    assert response.json()["status"] == "queued"
+    assert response.json()["work_order_id"] is None
+
+    response = await work_orders.post("/api/v1/workorder/status/batch", json={"request_ids": [chat["request_id"]]},
+                                      headers=guest_headers())
+    assert [r["status"] for r in response.json()["results"]] == ["queued"]
+
+    response = await work_orders.get(f"/api/v1/workorder/status/{chat['request_id']}",
+                                     headers=guest_headers("guest_other", "402"))
+    assert response.status_code == 404
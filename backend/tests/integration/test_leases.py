@@ -0,0 +1,42 @@
+import asyncio
+from datetime import datetime, timedelta, timezone
+
+import pytest
+
+from shared.leases import Lease, acquire_lease, ensure_lease_indexes, release_held_leases
+
+pytestmark = [pytest.mark.integration, pytest.mark.asyncio]
+
+TTL = timedelta(seconds=30)
+
+async def test_only_one_replica_holds_a_lease(database):
+    await ensure_lease_indexes()
+    now = datetime.now(timezone.utc)
+    results = await asyncio.gather(*(acquire_lease("job", f"replica-{i}", TTL, now) for i in range(5)))
+    assert results.count(True) == 1
+
+async def test_holder_renews_and_others_take_over_after_expiry(database):
+    await ensure_lease_indexes()
+    now = datetime.now(timezone.utc)
+    assert await acquire_lease("job", "a", TTL, now)
+    assert await acquire_lease("job", "a", TTL, now + timedelta(seconds=20))
+    assert not await acquire_lease("job", "b", TTL, now + timedelta(seconds=40))
+    assert await acquire_lease("job", "b", TTL, now + timedelta(seconds=51))
+
+async def test_released_leases_are_free_at_once(database):
+    await ensure_lease_indexes()
+    assert await acquire_lease("job", "a", TTL)
+    await release_held_leases("a")
+    assert await acquire_lease("job", "b", TTL)
+
+async def test_run_skips_the_job_without_the_lease(database):
+    await ensure_lease_indexes()
+    assert await acquire_lease("digests", "other-replica", TTL)
+    ran = []
+
+    async def job():
+        ran.append(True)
+        return "done"
+
+    assert await Lease("digests", 60).run(job) is None
+    assert ran == []
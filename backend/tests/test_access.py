@@ -0,0 +1,31 @@
+from datetime import datetime
+from zoneinfo import ZoneInfo
+
+import pytest
+
+from shared.access import detect_access_request, default_extension_until, extension_limit
+
+TZ = ZoneInfo("Europe/London")
+
+@pytest.mark.parametrize("message,expected", [
+    ("I'm locked out of my room", "lockout"),
+    ("my key card doesn't work", "lockout"),
+    ("I lost my key", "lockout"),
+    ("can you extend my key until 3pm?", "extension"),
+    ("I have a late checkout, please update my key", "extension"),
+    ("can I have a late checkout?", None),
+    ("2 towels please", None),
+])
+def test_detects_access_requests(message, expected):
+    assert detect_access_request(message) == expected
+
+def test_default_extension_is_next_late_checkout_time():
+    assert default_extension_until(datetime(2025, 7, 22, 9, 0, tzinfo=TZ), TZ) == datetime(2025, 7, 22, 14, 0, tzinfo=TZ)
+    assert default_extension_until(datetime(2025, 7, 22, 15, 0, tzinfo=TZ), TZ) == datetime(2025, 7, 23, 14, 0, tzinfo=TZ)
+
+def test_extensions_stop_shortly_after_the_booked_checkout():
+    now = datetime(2025, 7, 22, 9, 0, tzinfo=TZ)
+    check_out = datetime(2025, 7, 22, 11, 0, tzinfo=TZ)
+    assert extension_limit(now, check_out, TZ) == datetime(2025, 7, 22, 14, 0, tzinfo=TZ)
+    # Without a booking on file, the usual late checkout is the limit
+    assert extension_limit(now, None, TZ) == default_extension_until(now, TZ)
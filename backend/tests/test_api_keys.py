@@ -0,0 +1,42 @@
+from datetime import datetime, timedelta
+
+import pytest
+
+from shared.security.api_keys import (ApiKey, ApiKeyError, generate_key, hash_key, has_scope, matches_key,
+                                      parse_key_id, validate_scopes)
+
+NOW = datetime(2025, 9, 1, 12, 0)
+
+def test_generated_keys_carry_their_id():
+    raw = generate_key("a1b2c3d4e5f6")
+    assert raw.startswith("vb_a1b2c3d4e5f6_")
+    assert parse_key_id(raw) == "a1b2c3d4e5f6"
+
+@pytest.mark.parametrize("raw", ["", "Bearer xyz", "vb_", "vb_abc", "vb__secret", "sk_abc_secret"])
+def test_malformed_keys_have_no_id(raw):
+    assert parse_key_id(raw) is None
+
+def test_scope_wildcards():
+    assert has_scope(["work_orders:read"], "work_orders:read")
+    assert not has_scope(["work_orders:read"], "work_orders:write")
+    assert has_scope(["work_orders:*"], "work_orders:write")
+    assert not has_scope(["work_orders:*"], "notifications:write")
+    assert has_scope(["*"], "rooms:write")
+
+def test_unknown_scopes_are_rejected():
+    assert validate_scopes(["work_orders:write", "work_orders:read", "work_orders:write"]) == [
+        "work_orders:read", "work_orders:write"]
+    assert validate_scopes(["notifications:*"]) == ["notifications:*"]
+    with pytest.raises(ApiKeyError):
+        validate_scopes(["billing:write"])
+    with pytest.raises(ApiKeyError):
+        validate_scopes([])
+
+def test_previous_secret_works_only_during_grace_period():
+    old, new = generate_key("k1"), generate_key("k1")
+    key = ApiKey(key_id="k1", name="POS", integration="pos", scopes=["work_orders:write"], key_hash=hash_key(new),
+                 previous_key_hash=hash_key(old), previous_valid_until=NOW + timedelta(hours=1))
+    assert matches_key(key, new, NOW)
+    assert matches_key(key, old, NOW)
+    assert not matches_key(key, old, NOW + timedelta(hours=2))
+    assert not matches_key(key, generate_key("k1"), NOW)
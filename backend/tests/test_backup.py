@@ -0,0 +1,48 @@
+import asyncio
+
+import pytest
+
+from shared.backup import BACKUP_GROUPS, BackupError, OrderRenumbering, collections_for, remap_ids
+from shared.scan_codes import short_code_for
+
+def test_groups_and_collections_resolve_in_order_without_repeats():
+    chosen = collections_for(["config", "rooms", "work_orders"])
+    assert chosen == BACKUP_GROUPS["config"] + BACKUP_GROUPS["work_orders"]
+    assert collections_for(["guest_profiles"]) == ["guest_profiles"]
+
+def test_everything_but_keys_by_default():
+    chosen = collections_for()
+    assert "work_orders" in chosen and "knowledge_articles" in chosen
+    assert not {"jwt_keys", "field_keys", "api_keys", "leases"} & set(chosen)
+
+def test_unknown_selection_is_rejected():
+    with pytest.raises(BackupError):
+        collections_for(["jwt_keys"])
+
+def test_tenant_move_prefixes_ids_and_references():
+    doc = {"work_order_id": "wo_1", "request_id": "req_1", "guest_id": "g_1", "parent_id": None,
+           "depends_on": ["wo_0"], "hotel_id": "default", "assigned_staff": "staff_9",
+           "metadata": {"guest_id": "g_1", "room_number": "301"}}
+    moved = remap_ids(doc, "north_", {"default": "north"})
+    assert moved["work_order_id"] == "north_wo_1" and moved["request_id"] == "north_req_1"
+    assert moved["depends_on"] == ["north_wo_0"] and moved["parent_id"] is None
+    assert moved["metadata"] == {"guest_id": "north_g_1", "room_number": "301"}
+    assert moved["hotel_id"] == "north" and moved["assigned_staff"] == "staff_9"
+
+def test_no_remapping_leaves_documents_alone():
+    doc = {"work_order_id": "wo_1", "hotel_id": "default"}
+    assert remap_ids(doc) == doc
+
+def test_renumbering_gives_subtasks_their_parents_new_number():
+    issued = iter(["HK-9000", "MT-9001"])
+    async def next_number(department):
+        return next(issued)
+    renumbering = OrderRenumbering(next_number)
+    subtask = {"work_order_id": "north_wo_2", "department": "maintenance", "order_number": "HK-2045.1"}
+    parent = {"work_order_id": "north_wo_1", "department": "housekeeping", "order_number": "HK-2045",
+              "short_code": "OLDCODE0"}
+    assert asyncio.run(renumbering.apply(subtask))["order_number"] == "HK-9000.1"
+    assert asyncio.run(renumbering.apply(parent))["order_number"] == "HK-9000"
+    assert parent["short_code"] == short_code_for("north_wo_1")
+    # Event snapshots of the same order get the same number
+    assert asyncio.run(renumbering.apply({"order_number": "HK-2045"})) == {"order_number": "HK-9000"}
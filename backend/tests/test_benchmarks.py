@@ -0,0 +1,35 @@
+"""
+Benchmarks for the routing and consumer hot paths.
+Run: pytest backend/tests/test_benchmarks.py --benchmark-only
+"""
+from work_orders.main import route_department, build_work_order_from_chat
+from shared.contracts import ChatRequestMessage
+from shared.db.models import DepartmentEnum
+
+CHAT_MESSAGE = ChatRequestMessage(
+    request_id="req_bench",
+    guest_id="guest_bench",
+    message="Could you send up some extra towels and a blanket please",
+    department=DepartmentEnum.HOUSEKEEPING,
+    room_number="301",
+    session_id="sess_bench"
+)
+
+def test_route_department_match(benchmark):
+    result = benchmark(route_department, "The bathroom tap has a leak")
+    assert result == DepartmentEnum.MAINTENANCE
+
+def test_route_department_fallthrough(benchmark):
+    # Worst case: every pattern is tried before falling back to the default
+    result = benchmark(route_department, "Hello there, how are you doing today?")
+    assert result == DepartmentEnum.FRONT_DESK
+
+def test_build_work_order_from_chat(benchmark):
+    work_order = benchmark(build_work_order_from_chat, CHAT_MESSAGE)
+    assert work_order.department == DepartmentEnum.HOUSEKEEPING
+    assert work_order.request_id == "req_bench"
+
+def test_build_work_order_from_chat_unrouted(benchmark):
+    message = CHAT_MESSAGE.model_copy(update={"department": None, "message": "The AC is making a noise"})
+    work_order = benchmark(build_work_order_from_chat, message)
+    assert work_order.department == DepartmentEnum.MAINTENANCE
@@ -0,0 +1,50 @@
+from datetime import date, datetime, timezone
+from zoneinfo import ZoneInfo
+
+import pytest
+
+from shared.bookings import CONFIRM_PATTERN, detect_booking, pick_venue, slot_starts, reservation_ics
+from shared.db.models import Venue
+
+PARIS = ZoneInfo("Europe/Paris")
+
+@pytest.mark.parametrize("message,expected", [
+    ("Can you book a table for 4 at 8pm?", ("restaurant", 4)),
+    ("reserve dinner for two tonight", ("restaurant", 2)),
+    ("I'd like to book a massage at 3pm", ("spa", 1)),
+    ("book a table at 7pm", ("restaurant", 2)),
+    ("what time is dinner served?", None),
+    ("book me a taxi", None),
+])
+def test_detects_bookings(message, expected):
+    assert detect_booking(message) == expected
+
+@pytest.mark.parametrize("message,expected", [("yes", True), ("Yes please!", True), ("oui", True),
+                                              ("yes but at 9pm instead", False)])
+def test_confirmation_replies(message, expected):
+    assert bool(CONFIRM_PATTERN.match(message.lower())) is expected
+
+def test_slots_cover_opening_hours_in_hotel_time():
+    venue = Venue(venue_id="rest", name="Brasserie", venue_type="restaurant", capacity=20,
+                  slot_minutes=90, opens_at="18:00", closes_at="22:30")
+    starts = slot_starts(venue, date(2025, 7, 22), PARIS)
+    # 18:00, 19:30 and 21:00 local (UTC+2); a 22:30 slot would end after closing
+    assert starts == [datetime(2025, 7, 22, h, m, tzinfo=timezone.utc) for h, m in ((16, 0), (17, 30), (19, 0))]
+
+def test_slots_run_past_midnight():
+    venue = Venue(venue_id="bar", name="Late Bar", venue_type="restaurant", capacity=10,
+                  opens_at="22:00", closes_at="01:00")
+    assert len(slot_starts(venue, date(2025, 7, 22), PARIS)) == 3
+
+def test_calendar_invite_uses_utc_times():
+    ics = reservation_ics({"reservation_id": "res_1", "venue_name": "Spa", "party_size": 1,
+                           "starts_at": datetime(2025, 7, 22, 13, 0), "ends_at": datetime(2025, 7, 22, 14, 0)})
+    assert "DTSTART:20250722T130000Z" in ics and "DTEND:20250722T140000Z" in ics
+    assert ics.startswith("BEGIN:VCALENDAR\r\n")
+
+def test_venue_is_picked_by_name_or_asked_for():
+    spa = Venue(venue_id="spa", name="Spa", venue_type="spa", capacity=4)
+    garden = Venue(venue_id="garden", name="Garden Spa", venue_type="spa", capacity=4)
+    assert pick_venue([spa, garden], "Book a massage at the garden spa at 3pm") is garden
+    assert pick_venue([spa, garden], "Book a massage at 3pm") is None
+    assert pick_venue([spa], "Book a massage at 3pm") is spa
@@ -0,0 +1,21 @@
+from datetime import datetime
+
+from shared.bootstrap import hotel_info, merge_open_requests
+
+def test_pending_chat_requests_without_orders_show_as_queued():
+    orders = [{"request_id": "r1", "work_order_id": "wo1", "status": "assigned", "created_at": datetime(2025, 3, 1, 9)}]
+    chat_requests = [
+        {"request_id": "r1", "message": "towels", "created_at": datetime(2025, 3, 1, 9)},
+        {"request_id": "r2", "message": "ice please", "department": "room_service", "created_at": datetime(2025, 3, 1, 10)},
+        # Its order is already completed, so it isn't open any more
+        {"request_id": "r0", "message": "extra pillow", "created_at": datetime(2025, 3, 1, 8)},
+    ]
+    merged = merge_open_requests(orders, chat_requests, {"r0", "r1"})
+    assert [r["request_id"] for r in merged] == ["r2", "r1"]
+    assert merged[0]["status"] == "queued" and merged[0]["description"] == "ice please"
+    assert merged[0]["updated_at"] == datetime(2025, 3, 1, 10)
+
+def test_hotel_info_lists_languages():
+    info = hotel_info("Europe/Paris", ["fr", "en"])
+    assert info["timezone"] == "Europe/Paris"
+    assert info["languages"] == ["en", "fr"]
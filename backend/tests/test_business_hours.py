@@ -0,0 +1,44 @@
+from datetime import date, datetime, timedelta, timezone
+from zoneinfo import ZoneInfo
+
+from shared.business_hours import (BusinessCalendar, Holiday, OpeningWindow, WeekdayEnum, add_business_minutes,
+                                   business_minutes_between, is_open, next_open)
+from shared.db.models import DepartmentEnum
+
+PARIS = ZoneInfo("Europe/Paris")
+
+def calendar(holidays=()):
+    weekdays = [WeekdayEnum.MON, WeekdayEnum.TUE, WeekdayEnum.WED, WeekdayEnum.THU, WeekdayEnum.FRI]
+    hours = {d: [OpeningWindow(open="09:00", close="18:00")] for d in weekdays}
+    hours[WeekdayEnum.SAT] = [OpeningWindow(open="22:00", close="02:00")]
+    return BusinessCalendar(department=DepartmentEnum.CONCIERGE, timezone="Europe/Paris", hours=hours,
+                            holidays=[Holiday(date=d) for d in holidays])
+
+def paris(day, hour, minute=0):
+    # 8 July 2024 is a Monday
+    return datetime(2024, 7, day, hour, minute, tzinfo=PARIS)
+
+def test_open_only_inside_windows_in_local_time():
+    assert is_open(calendar(), paris(8, 10))
+    assert not is_open(calendar(), paris(8, 3))
+    assert not is_open(calendar(), paris(8, 18))
+    # Saturday's window runs past midnight
+    assert is_open(calendar(), paris(13, 23))
+    assert is_open(calendar(), paris(14, 1, 30))
+    assert not is_open(calendar(), paris(14, 3))
+    assert is_open(None, paris(8, 3))
+
+def test_next_open_skips_holidays():
+    assert next_open(calendar(), paris(8, 20)) == paris(9, 9)
+    assert next_open(calendar(holidays=[date(2024, 7, 9)]), paris(8, 20)) == paris(10, 9)
+    assert next_open(calendar(), paris(8, 10)) == paris(8, 10)
+
+def test_sla_clock_only_counts_working_minutes():
+    assert business_minutes_between(calendar(), paris(8, 17), paris(9, 10)) == 120
+    assert business_minutes_between(calendar(), paris(8, 19), paris(9, 8)) == 0
+    assert add_business_minutes(calendar(), paris(8, 17, 30), 45) == paris(9, 9, 15)
+    # Naive values are UTC, as Mongo returns them
+    naive = paris(8, 17).astimezone(timezone.utc).replace(tzinfo=None)
+    assert business_minutes_between(calendar(), naive, paris(8, 18)) == 60
+    assert business_minutes_between(None, paris(8, 17), paris(9, 10)) == 17 * 60
+    assert add_business_minutes(None, paris(8, 17), 30) == paris(8, 17) + timedelta(minutes=30)
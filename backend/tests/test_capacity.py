@@ -0,0 +1,25 @@
+from shared.capacity import department_load, should_defer
+
+def test_wait_uses_recent_throughput_and_falls_back_to_the_configured_rate():
+    # 12 completions in the last hour: 6 queued orders is half an hour's work
+    assert department_load("housekeeping", 6, 12, window_minutes=60)["estimated_wait_minutes"] == 30
+    # Nothing completed recently; housekeeping's default rate is 12/hour as well
+    assert department_load("housekeeping", 6, 0)["estimated_wait_minutes"] == 30
+    assert department_load("housekeeping", 0, 0)["estimated_wait_minutes"] == 0
+
+def test_levels_follow_the_sla_target():
+    # Housekeeping's target is 45 minutes; busy from 75% of it
+    assert department_load("housekeeping", 6, 12, window_minutes=60)["level"] == "normal"
+    assert department_load("housekeeping", 7, 12, window_minutes=60)["level"] == "busy"
+    assert department_load("housekeeping", 9, 12, window_minutes=60)["level"] == "overloaded"
+
+def test_only_overloaded_non_urgent_requests_are_deferred():
+    overloaded = department_load("housekeeping", 20, 12, window_minutes=60)
+    busy = department_load("housekeeping", 7, 12, window_minutes=60)
+    assert should_defer(overloaded)
+    assert should_defer(overloaded, sentiment=0.4)
+    assert not should_defer(overloaded, sentiment=-0.6)
+    assert not should_defer(overloaded, vip=True)
+    assert not should_defer(busy)
+    assert not should_defer(None)
+    assert not should_defer(department_load("security", 50, 0))
@@ -0,0 +1,41 @@
+from datetime import datetime, timedelta, timezone
+
+import pytest
+
+from shared.chat_edits import RECALLED_TAG, ChatEditError, check_editable, check_same_department, edited_priority
+from shared.db.models import StatusEnum
+
+NOW = datetime(2026, 10, 16, 9, 0, tzinfo=timezone.utc)
+
+def chat_doc(seconds_ago=10, status=StatusEnum.PENDING, tags=()):
+    return {"request_id": "req_1", "status": status, "tags": list(tags), "message": "2 towels",
+            "created_at": (NOW - timedelta(seconds=seconds_ago)).replace(tzinfo=None)}
+
+@pytest.mark.parametrize("work_order", [None, {"work_order_id": "wo_1", "status": StatusEnum.PENDING}])
+def test_a_fresh_request_nobody_has_started_on_is_editable(work_order):
+    check_editable(chat_doc(), work_order, NOW, grace_seconds=60)
+
+@pytest.mark.parametrize("doc,work_order,reason", [
+    (chat_doc(seconds_ago=90), None, "within 60 seconds"),
+    (chat_doc(), {"work_order_id": "wo_1", "status": StatusEnum.ASSIGNED}, "already handling"),
+    (chat_doc(status=StatusEnum.COMPLETED), None, "waiting for staff"),
+    (chat_doc(status=StatusEnum.CANCELLED, tags=[RECALLED_TAG]), None, "already recalled"),
+])
+def test_late_or_dispatched_requests_are_locked(doc, work_order, reason):
+    with pytest.raises(ChatEditError) as e:
+        check_editable(doc, work_order, NOW, grace_seconds=60)
+    assert reason in str(e.value)
+    assert e.value.status_code == 409
+
+def test_an_edit_that_reads_as_another_teams_job_is_refused():
+    doc = {**chat_doc(), "department": "housekeeping"}
+    check_same_department(doc, "housekeeping")
+    check_same_department(doc, None)
+    with pytest.raises(ChatEditError) as e:
+        check_same_department(doc, "maintenance")
+    assert e.value.status_code == 422
+
+def test_the_priority_follows_the_new_wording():
+    order = {"work_order_id": "wo_1", "status": StatusEnum.PENDING, "priority": "high"}
+    assert edited_priority(chat_doc(), order, 0.4) == "medium"
+    assert edited_priority(chat_doc(), order, -0.8) == "high"
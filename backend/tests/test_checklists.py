@@ -0,0 +1,42 @@
+from datetime import datetime, timezone
+
+import pytest
+
+from shared.checklists import (ChecklistError, check_completion, instantiate, open_required_steps, template_for,
+                               tick)
+from shared.db.models import StatusEnum
+
+DEEP_CLEAN = {"order_type": "deep_clean", "name": "Deep clean", "department": "housekeeping", "active": True,
+              "steps": [{"title": "Strip beds", "required": True}, {"title": "Descale shower", "required": False}]}
+NOW = datetime(2026, 5, 1, 10, 0, tzinfo=timezone.utc)
+
+@pytest.mark.parametrize("order,matches", [
+    ({"department": "housekeeping", "tags": ["deep_clean"]}, True),
+    ({"department": "housekeeping", "tags": [], "metadata": {"quick_action": "deep_clean"}}, True),
+    ({"department": "housekeeping", "tags": [], "metadata": {"tags": ["deep_clean"]}}, True),
+    ({"department": "maintenance", "tags": ["deep_clean"]}, False),
+    ({"department": "housekeeping", "tags": ["turndown"]}, False),
+])
+def test_template_matches_order_type_and_department(order, matches):
+    assert (template_for(order, [DEEP_CLEAN]) is not None) == matches
+
+def test_inactive_templates_are_ignored():
+    assert template_for({"department": "housekeeping", "tags": ["deep_clean"]}, [{**DEEP_CLEAN, "active": False}]) is None
+
+def test_completion_waits_for_required_steps():
+    order = {"checklist": instantiate(DEEP_CLEAN)}
+    assert open_required_steps(order["checklist"]) == ["Strip beds"]
+    with pytest.raises(ChecklistError):
+        check_completion(order, StatusEnum.COMPLETED)
+    check_completion(order, StatusEnum.IN_PROGRESS)
+    step = tick(order["checklist"], "step_1", True, "staff_1", NOW)
+    assert step["done"] and step["done_by"] == "staff_1"
+    order["checklist"]["steps"][0] = step
+    check_completion(order, StatusEnum.COMPLETED)
+    check_completion({}, StatusEnum.COMPLETED)
+
+def test_unticking_clears_who_and_when():
+    step = tick(instantiate(DEEP_CLEAN), "step_2", False, "staff_1", NOW)
+    assert not step["done"] and step["done_by"] is None and step["done_at"] is None
+    with pytest.raises(ChecklistError):
+        tick(instantiate(DEEP_CLEAN), "step_9", True, "staff_1", NOW)
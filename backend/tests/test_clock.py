@@ -0,0 +1,41 @@
+from datetime import datetime, timezone
+from zoneinfo import ZoneInfo
+
+import pytest
+
+from shared.clock import as_utc, format_local, hotel_timezone, resolve_timezone, utc_offset
+from shared.runtime_config import RuntimeSettings, runtime_config
+
+PARIS = ZoneInfo("Europe/Paris")
+
+def test_naive_values_are_taken_as_utc():
+    assert as_utc(datetime(2026, 10, 16, 8, 30)) == datetime(2026, 10, 16, 8, 30, tzinfo=timezone.utc)
+    assert as_utc(datetime(2026, 10, 16, 10, 30, tzinfo=PARIS)).tzinfo == timezone.utc
+    assert as_utc(None) is None
+
+def test_guest_facing_times_are_in_the_hotel_timezone():
+    eta = datetime(2026, 10, 16, 8, 30, tzinfo=timezone.utc)
+    assert format_local(eta, tz=PARIS) == "10:30"
+    assert format_local(eta.replace(tzinfo=None), "%d/%m %H:%M", tz=PARIS) == "16/10 10:30"
+
+@pytest.mark.parametrize("at,offset", [
+    (datetime(2026, 7, 1, tzinfo=timezone.utc), "+02:00"),
+    (datetime(2026, 12, 1, tzinfo=timezone.utc), "+01:00"),
+])
+def test_offset_follows_daylight_saving(at, offset):
+    assert utc_offset(PARIS, at) == offset
+
+def test_runtime_config_sets_the_hotel_timezone():
+    previous = runtime_config.settings
+    try:
+        runtime_config.settings = RuntimeSettings(hotel_timezone="America/New_York")
+        assert hotel_timezone().key == "America/New_York"
+        assert resolve_timezone("not/a_zone").key == "America/New_York"
+        assert resolve_timezone("Asia/Tokyo").key == "Asia/Tokyo"
+        assert utc_offset(at=datetime(2026, 1, 15, tzinfo=timezone.utc)) == "-05:00"
+    finally:
+        runtime_config.settings = previous
+
+def test_unknown_timezone_is_rejected():
+    with pytest.raises(ValueError):
+        RuntimeSettings(hotel_timezone="Mars/Olympus_Mons")
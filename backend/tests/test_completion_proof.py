@@ -0,0 +1,30 @@
+import pytest
+
+from shared.completion_proof import CompletionProofError, check_completion, guest_view, proof_required
+from shared.db.models import StatusEnum
+from shared.runtime_config import CompletionProofPolicy
+
+POLICY = CompletionProofPolicy(departments=["maintenance"], tags=["vip_amenity"])
+
+@pytest.mark.parametrize("order,required", [
+    ({"department": "maintenance", "tags": []}, True),
+    ({"department": "housekeeping", "tags": ["vip_amenity"]}, True),
+    ({"department": "housekeeping", "tags": ["late_checkout"]}, False),
+    ({"department": "maintenance", "tags": [], "subtasks": {"total": 2}}, False),
+])
+def test_policy_names_departments_and_tags(order, required):
+    assert proof_required(order, POLICY) == required
+
+def test_completing_without_proof_is_refused():
+    order = {"department": "maintenance", "tags": []}
+    with pytest.raises(CompletionProofError):
+        check_completion(order, StatusEnum.COMPLETED, POLICY)
+    check_completion(order, StatusEnum.IN_PROGRESS, POLICY)
+    check_completion({**order, "completion_proof": {"note": "Replaced the fuse"}}, StatusEnum.COMPLETED, POLICY)
+    check_completion(order, StatusEnum.COMPLETED, CompletionProofPolicy())
+
+def test_guests_only_see_shared_proof():
+    private = {"work_order_id": "wo_1", "completion_proof": {"note": "Replaced the fuse", "shared_with_guest": False}}
+    shared = {"work_order_id": "wo_2", "completion_proof": {"note": "Roses on the bed", "shared_with_guest": True}}
+    assert guest_view(private)["completion_proof"] is None
+    assert guest_view(shared) == shared
@@ -0,0 +1,30 @@
+import pytest
+
+from shared.middleware import is_compressible, negotiate_encoding
+
+@pytest.mark.parametrize("header,expected", [
+    ("gzip, deflate, br", "gzip"),
+    ("deflate", "deflate"),
+    ("deflate;q=1.0, gzip;q=0.5", "deflate"),
+    ("gzip;q=0, deflate", "deflate"),
+    ("*", "gzip"),
+    ("*;q=0.2, gzip;q=0", "deflate"),
+    ("identity", None),
+    ("br", None),
+    ("gzip;q=bogus", None),
+    ("", None),
+    (None, None),
+])
+def test_negotiates_encoding_from_accept_encoding(header, expected):
+    assert negotiate_encoding(header) == expected
+
+@pytest.mark.parametrize("content_type,expected", [
+    ("application/json", True),
+    ("text/csv; charset=utf-8", True),
+    ("application/x-ndjson", True),
+    ("image/png", False),
+    ("application/pdf", False),
+    ("", False),
+])
+def test_only_text_like_responses_are_compressed(content_type, expected):
+    assert is_compressible(content_type) == expected
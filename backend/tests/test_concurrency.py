@@ -0,0 +1,34 @@
+import pytest
+
+from shared.concurrency import etag, expected_version, parse_if_match, version_filter, versioned
+from shared.errors import ApiError, ErrorCode
+
+def test_etag_round_trips_through_if_match():
+    assert etag({"version": 3}) == '"3"'
+    assert etag({}) == '"0"'
+    assert parse_if_match(etag({"version": 3})) == 3
+    assert parse_if_match('W/"3"') == 3
+    assert parse_if_match(None) is None
+    assert parse_if_match("*") is None
+    with pytest.raises(ApiError) as info:
+        parse_if_match('"abc"')
+    assert info.value.status_code == 400
+
+def test_if_match_can_be_required():
+    assert expected_version(None, required=False) is None
+    with pytest.raises(ApiError) as info:
+        expected_version(None, required=True)
+    assert info.value.status_code == 428
+    assert info.value.code == ErrorCode.PRECONDITION_REQUIRED
+    assert expected_version('"2"', required=True) == 2
+
+def test_unversioned_orders_match_version_zero():
+    assert version_filter(None) == {}
+    assert version_filter(4) == {"version": 4}
+    assert version_filter(0) == {"version": {"$in": [0, None]}}
+
+def test_versioned_keeps_existing_increments():
+    assert versioned({"$set": {"a": 1}}) == {"$set": {"a": 1}, "$inc": {"version": 1}}
+    assert versioned({"$inc": {"metadata.requeue_count": 1}}) == {
+        "$inc": {"metadata.requeue_count": 1, "version": 1}
+    }
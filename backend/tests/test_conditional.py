@@ -0,0 +1,33 @@
+from datetime import datetime, timezone
+
+from shared.conditional import body_etag, etag_matches, http_date, is_not_modified, parse_http_date
+
+UPDATED = datetime(2025, 3, 1, 9, 30, 15, 250000)
+
+def test_body_etag_is_weak_and_stable():
+    tag = body_etag({"status": "pending", "department": "housekeeping"})
+    assert tag.startswith('W/"')
+    assert tag == body_etag({"department": "housekeeping", "status": "pending"})
+    assert tag != body_etag({"status": "in_progress", "department": "housekeeping"})
+
+def test_if_none_match_uses_weak_comparison():
+    assert etag_matches('W/"3"', '"3"')
+    assert etag_matches('"2", "3"', 'W/"3"')
+    assert etag_matches("*", '"3"')
+    assert not etag_matches('"2"', '"3"')
+    assert not etag_matches(None, '"3"')
+
+def test_http_dates_round_trip_in_whole_seconds():
+    assert http_date(UPDATED) == "Sat, 01 Mar 2025 09:30:15 GMT"
+    assert parse_http_date(http_date(UPDATED)) == datetime(2025, 3, 1, 9, 30, 15, tzinfo=timezone.utc)
+    assert parse_http_date("yesterday") is None
+
+def test_not_modified_since_the_last_change():
+    assert is_not_modified({"if-modified-since": http_date(UPDATED)}, '"3"', UPDATED)
+    assert not is_not_modified({"if-modified-since": "Sat, 01 Mar 2025 09:30:14 GMT"}, '"3"', UPDATED)
+    # Without a date there is nothing to compare against
+    assert not is_not_modified({"if-modified-since": http_date(UPDATED)}, '"3"')
+
+def test_if_none_match_wins_over_if_modified_since():
+    headers = {"if-none-match": '"2"', "if-modified-since": http_date(UPDATED)}
+    assert not is_not_modified(headers, '"3"', UPDATED)
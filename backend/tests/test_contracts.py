@@ -0,0 +1,105 @@
+import json
+from datetime import datetime, timezone
+
+import pytest
+from pydantic import ValidationError
+
+from shared.contracts import ChatRequestMessage, WorkOrderStatusEvent, CHAT_REQUEST_CONTRACT_VERSION
+from shared.db.models import ChatRequest, DepartmentEnum, PriorityEnum, StatusEnum
+from work_orders.main import CHAT_MESSAGE_FIELDS_HANDLED, build_work_order_from_chat
+
+NOW = datetime(2025, 7, 22, 12, 0, tzinfo=timezone.utc)
+
+ROUND_TRIP_CASES = [
+    ("minimal", ChatRequestMessage(request_id="req_1", guest_id="g1", message="towels", created_at=NOW)),
+    ("routed", ChatRequestMessage(request_id="req_2", guest_id="g2", message="AC broken",
+                                  department=DepartmentEnum.MAINTENANCE, room_number="512", created_at=NOW)),
+    ("full", ChatRequestMessage(request_id="req_3", guest_id="g3", message="Photo of the leak",
+                                department=DepartmentEnum.MAINTENANCE, language="fr", tags=["quick"],
+                                room_number="101", session_id="sess_1", attachment_ids=["att_1", "att_2"],
+                                created_at=NOW)),
+]
+
+def full_chat_request() -> ChatRequest:
+    """A chat request with every optional field the chatbot can populate."""
+    return ChatRequest(
+        request_id="req_full",
+        guest_id="g1",
+        message="The bathroom tap is leaking",
+        department=DepartmentEnum.MAINTENANCE,
+        status=StatusEnum.PENDING,
+        tags=["quick_reply"],
+        language="es",
+        sentiment=-0.7,
+        created_at=NOW,
+        metadata={"session_id": "sess_1", "room_number": "101", "images": ["att_1"],
+                  "scheduled_for": datetime(2025, 7, 22, 20, 0, tzinfo=timezone.utc), "workflow": "luggage",
+                  "priority": "medium", "quick_action": "extra_towels",
+                  "line_items": [{"item": "towels", "quantity": 3}],
+                  "location": {"latitude": -4.29012, "longitude": 39.59341, "accuracy_m": 12.0}}
+    )
+
+@pytest.mark.parametrize("name,message", ROUND_TRIP_CASES, ids=[c[0] for c in ROUND_TRIP_CASES])
+def test_chat_request_message_round_trip(name, message):
+    assert ChatRequestMessage.from_json(message.to_json()) == message
+
+def test_contract_version_is_stamped():
+    assert ROUND_TRIP_CASES[0][1].contract_version == CHAT_REQUEST_CONTRACT_VERSION
+
+def test_consumer_ignores_fields_from_a_newer_publisher():
+    payload = ROUND_TRIP_CASES[0][1].model_dump(mode="json")
+    payload["unexpected"] = "value"
+    assert ChatRequestMessage.from_json(json.dumps(payload)) == ROUND_TRIP_CASES[0][1]
+
+def test_consumer_still_rejects_invalid_fields():
+    payload = ROUND_TRIP_CASES[0][1].model_dump(mode="json")
+    payload["message"] = ""
+    with pytest.raises(ValidationError):
+        ChatRequestMessage.from_json(json.dumps(payload))
+
+class ReadRecorder:
+    """Stands in for a message and notes which of its fields the consumer reads."""
+
+    def __init__(self, message):
+        self.message = message
+        self.read = set()
+
+    def __getattr__(self, name):
+        self.read.add(name)
+        return getattr(self.message, name)
+
+def test_consumer_handles_every_published_field():
+    # Fails when a field is added to the contract without teaching the consumer about it
+    message = ReadRecorder(ChatRequestMessage.from_chat_request(full_chat_request()))
+    build_work_order_from_chat(message)
+    assert not CHAT_MESSAGE_FIELDS_HANDLED - message.read, \
+        f"consumer never reads: {sorted(CHAT_MESSAGE_FIELDS_HANDLED - message.read)}"
+
+def test_chatbot_emits_every_field_the_consumer_reads():
+    # Fails when the consumer relies on a field the chatbot never populates
+    emitted = ChatRequestMessage.from_chat_request(full_chat_request()).model_dump(exclude_defaults=True)
+    missing = CHAT_MESSAGE_FIELDS_HANDLED - set(emitted) - {"contract_version"}
+    assert not missing, f"chatbot never populates: {sorted(missing)}"
+
+def test_chatbot_message_builds_work_order():
+    message = ChatRequestMessage.from_json(ChatRequestMessage.from_chat_request(full_chat_request()).to_json())
+    work_order = build_work_order_from_chat(message)
+    assert work_order.request_id == "req_full"
+    assert work_order.department == DepartmentEnum.MAINTENANCE
+    assert work_order.metadata["room_number"] == "101"
+    assert work_order.metadata["attachment_ids"] == ["att_1"]
+    assert work_order.priority == PriorityEnum.HIGH  # frustrated guest, bumped from medium
+    assert work_order.workflow["step"] == "requested"
+    assert [(li.item, li.quantity) for li in work_order.line_items] == [("towels", 3)]
+    assert work_order.metadata["location"]["latitude"] == -4.29012
+
+def test_work_order_status_event_round_trip():
+    event = WorkOrderStatusEvent.from_work_order({
+        "request_id": "req_1",
+        "work_order_id": "wo_1",
+        "guest_id": "g1",
+        "department": "housekeeping",
+        "status": "completed",
+        "metadata": {"session_id": "sess_1"}
+    })
+    assert WorkOrderStatusEvent.model_validate(event.model_dump(mode="json")) == event
@@ -0,0 +1,35 @@
+import pytest
+
+from shared.security.device_auth import (Device, DeviceError, check_registration, device_principal, parse_device_id,
+                                         session_needs_reset)
+
+def device(**fields) -> Device:
+    defaults = {"device_id": "a1b2c3", "name": "Room 1204 tablet", "kind": "tablet", "room_number": "1204",
+                "token_hash": "x", "session_id": "dev_1", "session_guest_id": "guest_1"}
+    return Device(**(defaults | fields))
+
+def test_token_names_its_device():
+    assert parse_device_id("vbd_a1b2c3_s3cr3t") == "a1b2c3"
+    assert parse_device_id("vb_a1b2c3_s3cr3t") is None
+    assert parse_device_id("vbd_a1b2c3") is None
+
+def test_tablets_are_bound_to_a_room_and_kiosks_are_not():
+    check_registration("tablet", "1204")
+    check_registration("kiosk", None)
+    with pytest.raises(DeviceError):
+        check_registration("tablet", None)
+    with pytest.raises(DeviceError):
+        check_registration("kiosk", "1204")
+
+def test_session_resets_when_the_guest_changes():
+    assert not session_needs_reset(device(), "guest_1")
+    assert session_needs_reset(device(), "guest_2")
+    assert session_needs_reset(device(), None)
+    assert session_needs_reset(device(session_id=None, session_guest_id=None), None)
+
+def test_room_identifies_the_requester():
+    principal = device_principal(device(), "guest_1")
+    assert (principal["sub"], principal["role"], principal["room"]) == ("guest_1", "guest", "1204")
+    assert principal["session_id"] == "dev_1"
+    kiosk = device_principal(device(kind="kiosk", room_number=None, session_guest_id=None), None)
+    assert (kiosk["sub"], kiosk["role"]) == ("device:a1b2c3", "kiosk")
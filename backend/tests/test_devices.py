@@ -0,0 +1,23 @@
+import pytest
+
+from shared.devices import parse_device_command, THERMOSTAT_STEP
+
+@pytest.mark.parametrize("message,device,action,value", [
+    ("Could you turn up the AC please", "thermostat", "up", THERMOSTAT_STEP),
+    ("set the thermostat to 21.5", "thermostat", "set", 21.5),
+    ("lights off", "lights", "off", None),
+    ("dim the lights", "lights", "down", None),
+    ("open the curtains", "curtains", "open", None),
+    ("turn on the TV", "tv", "on", None),
+])
+def test_parses_device_commands(message, device, action, value):
+    command = parse_device_command(message)
+    assert (command.device, command.action, command.value) == (device, action, value)
+
+@pytest.mark.parametrize("message", [
+    "the AC is broken",
+    "the lights won't turn on",
+    "2 towels please",
+])
+def test_faults_and_other_requests_are_not_device_commands(message):
+    assert parse_device_command(message) is None
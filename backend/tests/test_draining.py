@@ -0,0 +1,45 @@
+import asyncio
+
+import pytest
+
+from shared.draining import Drain
+from shared.errors import ApiError
+
+def test_rejects_new_work_while_draining():
+    drain = Drain()
+    drain.ensure_accepting()
+    drain.draining = True
+    with pytest.raises(ApiError) as error:
+        drain.ensure_accepting()
+    assert error.value.status_code == 503
+    assert "Retry-After" in error.value.headers
+
+def test_waits_for_messages_and_pending_sends():
+    async def scenario():
+        drain = Drain()
+        release = asyncio.Event()
+
+        async def message():
+            async with drain.processing():
+                await release.wait()
+
+        handler = asyncio.create_task(message())
+        drain.track(release.wait())
+        await asyncio.sleep(0)
+        assert drain.status()["in_flight_messages"] == 1 and drain.status()["pending_sends"] == 1
+        assert not await drain.wait_idle(timeout=0.05)
+        release.set()
+        assert await drain.wait_idle(timeout=1)
+        await handler
+
+    asyncio.run(scenario())
+
+def test_drain_starts_once_and_finishes_when_idle():
+    async def scenario():
+        drain = Drain()
+        assert drain.start("test", exit_when_done=False)
+        assert not drain.start("again", exit_when_done=False)
+        await asyncio.sleep(0.01)
+        assert drain.status()["drained"] and drain.status()["reason"] == "test"
+
+    asyncio.run(scenario())
@@ -0,0 +1,45 @@
+from datetime import datetime, timedelta, timezone
+
+import pytest
+
+from shared.event_store import (CHANGED, DELETED, EventStoreError, apply_update, changes_between, document_event,
+                                replay, stream_event)
+
+def test_apply_update_follows_mongo_semantics():
+    doc = {"status": "pending", "metadata": {"requeue_count": 1}, "attachments": ["a"], "version": 2}
+    after = apply_update(doc, {
+        "$set": {"status": "assigned", "metadata.held_status": "pending"},
+        "$inc": {"metadata.requeue_count": 1, "version": 1},
+        "$addToSet": {"attachments": {"$each": ["a", "b"]}},
+        "$unset": {"metadata.missing.deep": ""},
+    })
+    assert after == {"status": "assigned", "metadata": {"requeue_count": 2, "held_status": "pending"},
+                     "attachments": ["a", "b"], "version": 3}
+    assert doc["metadata"] == {"requeue_count": 1}
+    with pytest.raises(EventStoreError):
+        apply_update(doc, {"$rename": {"status": "state"}})
+
+def test_changes_record_top_level_fields_only():
+    before = {"_id": 1, "status": "pending", "metadata": {"a": 1}, "hold_reason": "dnd", "version": 1}
+    after = {"_id": 1, "status": "pending", "metadata": {"a": 2}, "assigned_staff": "s1", "version": 2}
+    assert changes_between(before, after) == {"set": {"metadata": {"a": 2}, "assigned_staff": "s1"},
+                                              "unset": ["hold_reason"]}
+
+def test_replay_gives_the_state_at_any_moment():
+    created = document_event({"_id": "x", "work_order_id": "wo_1", "status": "pending", "version": 0})
+    assigned = stream_event("wo_1", 1, CHANGED, {"set": {"status": "assigned", "assigned_staff": "s1"}, "unset": []})
+    deleted = stream_event("wo_1", 2, DELETED)
+    assigned["occurred_at"] = created["occurred_at"] + timedelta(minutes=5)
+    deleted["occurred_at"] = created["occurred_at"] + timedelta(minutes=10)
+    events = [created, assigned, deleted]
+
+    assert "_id" not in created["data"]["document"]
+    assert replay(events, as_of=created["occurred_at"] + timedelta(minutes=1)) == {
+        "work_order_id": "wo_1", "status": "pending", "version": 0}
+    assert replay(events, as_of=assigned["occurred_at"]) == {
+        "work_order_id": "wo_1", "status": "assigned", "assigned_staff": "s1", "version": 1}
+    assert replay(events) is None
+    # Naive moments (as Mongo returns them) are read as UTC
+    naive = (created["occurred_at"] - timedelta(minutes=1)).astimezone(timezone.utc).replace(tzinfo=None)
+    assert replay(events, as_of=naive) is None
+    assert replay(events[:1], as_of=datetime.now(timezone.utc) + timedelta(days=1))["status"] == "pending"
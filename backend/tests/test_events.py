@@ -0,0 +1,46 @@
+import hashlib
+import hmac
+from datetime import datetime, timezone
+
+import pytest
+
+from shared.events import (EVENT_TYPES, EventContractError, StatusChanged, decode, event_catalog, sign,
+                           webhook_targets)
+
+HOOKS = [
+    {"url": "https://pms.example/hooks", "types": ["work_order.*"]},
+    {"url": "https://bi.example/all"},
+    {"url": "https://nps.example/in", "types": ["feedback.received"]},
+]
+
+def test_webhook_type_filters():
+    assert webhook_targets("work_order.sla_breached", HOOKS) == ["https://pms.example/hooks", "https://bi.example/all"]
+    assert webhook_targets("feedback.received", HOOKS) == ["https://bi.example/all", "https://nps.example/in"]
+    assert webhook_targets("incident.opened", HOOKS) == ["https://bi.example/all"]
+
+def test_signature_is_hmac_of_body():
+    expected = hmac.new(b"secret", b'{"id": "1"}', hashlib.sha256).hexdigest()
+    assert sign(b'{"id": "1"}', "secret") == f"sha256={expected}"
+
+def test_envelope_round_trip():
+    event = StatusChanged(work_order_id="wo_1", request_id="req_1", department="housekeeping",
+                          previous_status="pending", status="in_progress", actor="staff_7",
+                          occurred_at=datetime(2025, 9, 1, 12, 0, tzinfo=timezone.utc), trace_id="abc")
+    envelope = event.encode()
+    assert envelope["type"] == "work_order.status_changed" and envelope["version"] == 1
+    assert envelope["data"] == {"work_order_id": "wo_1", "request_id": "req_1", "department": "housekeeping",
+                                "previous_status": "pending", "status": "in_progress", "actor": "staff_7"}
+    assert decode(envelope) == event
+
+def test_rejects_newer_versions_and_unknown_types():
+    envelope = StatusChanged(work_order_id="wo_1", request_id="req_1", department="it", status="pending").encode()
+    with pytest.raises(EventContractError):
+        decode({**envelope, "version": 2})
+    with pytest.raises(EventContractError):
+        decode({**envelope, "type": "work_order.teleported"})
+
+def test_catalog_lists_every_event_without_envelope_fields():
+    catalog = {entry["type"]: entry for entry in event_catalog()}
+    assert set(catalog) == set(EVENT_TYPES)
+    assert "event_id" not in catalog["feedback.received"]["data_schema"]["properties"]
+    assert "score" in catalog["feedback.received"]["data_schema"]["required"]
@@ -0,0 +1,23 @@
+from datetime import datetime
+from zoneinfo import ZoneInfo
+
+import pytest
+
+from shared.export import format_cell
+
+UTC = ZoneInfo("UTC")
+
+@pytest.mark.parametrize("value,expected", [
+    ("=HYPERLINK(\"http://evil\")", "'=HYPERLINK(\"http://evil\")"),
+    ("+1 555 0100", "'+1 555 0100"),
+    ("-rf", "'-rf"),
+    ("@SUM(A1)", "'@SUM(A1)"),
+    (["=1+1", "towels"], "'=1+1;towels"),
+    ("Leaking tap", "Leaking tap"),
+    (-3, -3),
+])
+def test_cells_that_would_run_as_formulas_are_escaped(value, expected):
+    assert format_cell(value, UTC) == expected
+
+def test_timestamps_are_shown_in_the_export_timezone():
+    assert format_cell(datetime(2025, 7, 22, 13, 0), ZoneInfo("Europe/Paris")) == "2025-07-22T15:00:00+02:00"
@@ -0,0 +1,38 @@
+from shared.feature_flags import FeatureFlag, evaluate, from_app_config
+
+def flag(**fields) -> FeatureFlag:
+    return FeatureFlag(key="llm_replies", **fields)
+
+def test_unconfigured_flags_keep_todays_behaviour():
+    assert evaluate(None, "auto_assignment", "g1", "default")
+    assert not evaluate(None, "llm_replies", "g1", "default")
+
+def test_runtime_override_wins_over_everything():
+    assert not evaluate(flag(enabled=True, hotel_overrides={"default": True}), "llm_replies", "g1", "default", False)
+    assert evaluate(None, "llm_replies", "g1", "default", True)
+
+def test_hotel_override_wins_over_the_rollout():
+    pilot = flag(enabled=False, hotel_overrides={"pilot": True, "legacy": False})
+    assert evaluate(pilot, "llm_replies", "g1", "pilot")
+    assert not evaluate(pilot, "llm_replies", "g1", "default")
+    assert not evaluate(flag(enabled=True, hotel_overrides={"legacy": False}), "llm_replies", "g1", "legacy")
+
+def test_percentage_rollout_is_sticky_per_guest():
+    half = flag(enabled=True, rollout_percent=50)
+    guests = [f"guest-{i}" for i in range(200)]
+    first = [evaluate(half, "llm_replies", g, "default") for g in guests]
+    assert first == [evaluate(half, "llm_replies", g, "default") for g in guests]
+    assert 60 < sum(first) < 140
+    assert not evaluate(half, "llm_replies", None, "default")
+    assert evaluate(flag(enabled=True), "llm_replies", None, "default")
+
+def test_reads_app_configuration_feature_flags():
+    parsed = from_app_config({
+        "id": "websocket_chat", "description": "Guest chat socket", "enabled": True,
+        "conditions": {"client_filters": [
+            {"name": "Microsoft.Percentage", "parameters": {"Value": "25"}},
+            {"name": "Hotels", "parameters": {"Allow": ["pilot"], "Deny": ["legacy"]}},
+        ]},
+    })
+    assert (parsed.key, parsed.enabled, parsed.rollout_percent) == ("websocket_chat", True, 25)
+    assert parsed.hotel_overrides == {"pilot": True, "legacy": False}
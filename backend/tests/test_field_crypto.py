@@ -0,0 +1,51 @@
+import base64
+
+import pytest
+
+from shared.security.field_crypto import (PREFIX, FieldCipher, UnknownFieldKeyError, key_id_of,
+                                          transform_document, transform_update)
+
+def cipher_with(*kids):
+    cipher = FieldCipher()
+    cipher.keys = {kid: bytes([i + 1]) * 32 for i, kid in enumerate(kids)}
+    cipher.active_kid = kids[-1]
+    return cipher
+
+def test_round_trip_and_field_binding():
+    cipher = cipher_with("f1-a")
+    sealed = cipher.encrypt("Jane Doe", "guest_profiles.name")
+    assert sealed.startswith(PREFIX) and "Jane" not in sealed and key_id_of(sealed) == "f1-a"
+    assert cipher.decrypt(sealed, "guest_profiles.name") == "Jane Doe"
+    with pytest.raises(Exception):
+        cipher.decrypt(sealed, "guest_profiles.phone")
+
+def test_old_keys_still_decrypt_after_rotation():
+    old = cipher_with("f1-a")
+    sealed = old.encrypt("+44 20 7946 0000", "guest_profiles.phone")
+    rotated = cipher_with("f1-a", "f2-b")
+    assert rotated.decrypt(sealed, "guest_profiles.phone") == "+44 20 7946 0000"
+    assert key_id_of(rotated.encrypt("x", "guest_profiles.phone")) == "f2-b"
+    with pytest.raises(UnknownFieldKeyError):
+        cipher_with("f3-c").decrypt(sealed, "guest_profiles.phone")
+
+def test_plaintext_from_before_encryption_reads_through():
+    assert cipher_with("f1-a").decrypt("Need towels", "chat_requests.message") == "Need towels"
+
+def test_transforms_nested_and_array_paths():
+    mark = lambda value, path: f"<{path}>"
+    doc = {"message": "hi", "guest_profile": {"name": "Jane", "room_number": "12"},
+           "messages": [{"text": "a"}, {"text": "b", "sender": "agent"}]}
+    out = transform_document(doc, ("message", "guest_profile.name", "messages.text"), mark)
+    assert out["message"] == "<message>" and out["guest_profile"] == {"name": "<guest_profile.name>", "room_number": "12"}
+    assert [m["text"] for m in out["messages"]] == ["<messages.text>", "<messages.text>"]
+    assert doc["message"] == "hi"
+
+def test_transforms_update_operators():
+    mark = lambda value, path: f"<{path}>"
+    update = {"$set": {"description": "leak", "status": "pending"},
+              "$push": {"messages": {"$each": [{"text": "a"}]}}}
+    out = transform_update(update, ("description", "messages.text"), mark)
+    assert out["$set"] == {"description": "<description>", "status": "pending"}
+    assert out["$push"]["messages"]["$each"] == [{"text": "<messages.text>"}]
+    pipeline = [{"$set": {"messages": []}}]
+    assert transform_update(pipeline, ("messages.text",), mark) is pipeline
@@ -0,0 +1,23 @@
+from shared.groups import BulkRequestItem, EventGroup, can_manage, digest_text, group_tags, item_description, summarize
+
+GROUP = EventGroup(group_id="acme-summit", name="ACME Summit", coordinator_id="guest_coord", location="Ballroom A")
+
+def test_bulk_lines_read_like_requests():
+    assert item_description(BulkRequestItem(description="extra chairs", quantity=30), "Ballroom A") == \
+        "30 x extra chairs to Ballroom A"
+    assert item_description(BulkRequestItem(description="projector"), None, "by 8am") == "projector | Note: by 8am"
+    assert group_tags("acme-summit") == ["group", "group:acme-summit"]
+
+def test_coordinator_and_staff_manage_the_group():
+    assert can_manage(GROUP, {"sub": "guest_coord"})
+    assert can_manage(GROUP, {"sub": "staff_1", "role": "staff"})
+    assert not can_manage(GROUP, {"sub": "guest_other"})
+
+def test_consolidated_summary():
+    orders = [{"status": "completed"}, {"status": "completed"}, {"status": "in_progress"}, {"status": "pending"},
+              {"status": "cancelled"}]
+    summary = summarize(orders)
+    assert summary["total"] == 5 and summary["done"] == 3 and summary["open"] == 2
+    assert summary["percent_complete"] == 60
+    assert digest_text(GROUP, summary) == "ACME Summit: 3 of 5 requests done, 1 in progress."
+    assert summarize([])["percent_complete"] == 100
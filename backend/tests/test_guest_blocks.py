@@ -0,0 +1,28 @@
+from datetime import datetime, timedelta, timezone
+
+from shared.guest_blocks import audit_entry, default_expiry, is_active
+
+NOW = datetime(2024, 7, 10, 12, 0, tzinfo=timezone.utc)
+
+def test_restriction_without_expiry_stays_active_until_ended():
+    assert is_active({"level": "blocked", "expires_at": None}, NOW)
+    assert not is_active({"level": "blocked", "expires_at": None, "ended_at": NOW - timedelta(minutes=1)}, NOW)
+
+def test_restriction_lapses_at_its_expiry():
+    assert is_active({"expires_at": NOW + timedelta(seconds=1)}, NOW)
+    assert not is_active({"expires_at": NOW}, NOW)
+    # Mongo hands back naive UTC datetimes
+    assert not is_active({"expires_at": datetime(2024, 7, 10, 11, 0)}, NOW)
+
+def test_default_expiry_is_the_upcoming_checkout():
+    check_out = datetime(2024, 7, 12, 11, 0)
+    assert default_expiry({"check_out_date": check_out}, NOW) == check_out.replace(tzinfo=timezone.utc)
+
+def test_no_default_expiry_for_a_missing_or_past_checkout():
+    assert default_expiry({}, NOW) is None
+    assert default_expiry(None, NOW) is None
+    assert default_expiry({"check_out_date": NOW - timedelta(days=3)}, NOW) is None
+
+def test_audit_entry_keeps_actor_and_details():
+    entry = audit_entry("guest_1", "blocked", "admin_1", NOW, reason="Prank calls")
+    assert entry == {"guest_id": "guest_1", "action": "blocked", "actor": "admin_1", "at": NOW, "reason": "Prank calls"}
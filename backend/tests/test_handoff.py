@@ -0,0 +1,22 @@
+from shared.handoff import message_index, unread_count
+
+def conversation(*senders, read_markers=None):
+    messages = [{"message_id": f"m{i}", "sender": sender, "text": "..."} for i, sender in enumerate(senders)]
+    return {"conversation_id": "conv_1", "messages": messages, "read_markers": read_markers or {}}
+
+def test_everything_from_the_other_side_is_unread_without_a_marker():
+    doc = conversation("guest", "system", "agent", "guest")
+    assert unread_count(doc, "guest") == 2
+    assert unread_count(doc, "agent") == 3
+
+def test_unread_counts_from_the_read_marker():
+    doc = conversation("guest", "agent", "agent", "guest", "agent",
+                       read_markers={"guest": {"message_id": "m2"}, "agent": {"message_id": "m3"}})
+    assert unread_count(doc, "guest") == 1
+    assert unread_count(doc, "agent") == 0
+
+def test_unknown_messages_have_no_position():
+    doc = conversation("guest")
+    assert message_index(doc, "m0") == 0
+    assert message_index(doc, "m9") == -1
+    assert message_index(doc, None) == -1
@@ -0,0 +1,50 @@
+import random
+
+from shared.http_client import CircuitBreaker, RetryBudget, backoff_delay, destination
+
+def test_breaker_opens_after_consecutive_failures_and_trials_one_request():
+    breaker = CircuitBreaker(failure_threshold=3, reset_seconds=30)
+    for _ in range(2):
+        breaker.record_failure(now=0)
+    assert breaker.allow(now=0)
+    assert breaker.record_failure(now=0)
+    assert not breaker.allow(now=10)
+
+    # After the reset period a single trial goes through; the rest still fail fast
+    assert breaker.allow(now=31)
+    assert not breaker.allow(now=31)
+    breaker.record_success()
+    assert breaker.allow(now=32)
+
+def test_failed_trial_reopens_the_circuit():
+    breaker = CircuitBreaker(failure_threshold=1, reset_seconds=30)
+    breaker.record_failure(now=0)
+    assert breaker.allow(now=30)
+    assert breaker.record_failure(now=30)
+    assert not breaker.allow(now=59)
+
+def test_success_resets_the_failure_count():
+    breaker = CircuitBreaker(failure_threshold=2)
+    breaker.record_failure(now=0)
+    breaker.record_success()
+    assert not breaker.record_failure(now=0)
+
+def test_retry_budget_limits_retries_to_a_share_of_requests():
+    budget = RetryBudget(ratio=0.2, max_tokens=2)
+    assert budget.withdraw() and budget.withdraw()
+    assert not budget.withdraw()
+    for _ in range(5):
+        budget.deposit()
+    assert budget.withdraw()
+    assert not budget.withdraw()
+
+def test_services_on_one_host_are_separate_destinations():
+    assert destination("http://localhost:8002/internal/capacity") == "localhost:8002"
+    assert destination("http://localhost:8003/notify") == "localhost:8003"
+    assert destination("https://user:pw@pms.example.com/api") == "pms.example.com:443"
+
+def test_backoff_is_jittered_and_capped():
+    rng = random.Random(1)
+    delays = [backoff_delay(attempt, base=0.5, cap=2.0, rng=rng) for attempt in range(10)]
+    assert all(0 <= d <= 2.0 for d in delays)
+    assert len(set(delays)) == len(delays)
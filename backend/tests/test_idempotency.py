@@ -0,0 +1,27 @@
+import pytest
+
+from shared.idempotency import IdempotencyError, check_key, fingerprint, replay_of
+
+def test_keys_must_be_visible_ascii():
+    assert check_key("  7f3c9a1e-offline-2  ") == "7f3c9a1e-offline-2"
+    for bad in ("", "has space", "x" * 256, "café"):
+        with pytest.raises(IdempotencyError):
+            check_key(bad)
+
+def test_fingerprint_ignores_key_order():
+    assert fingerprint({"text": "towels", "language": "en"}) == fingerprint({"language": "en", "text": "towels"})
+    assert fingerprint({"text": "towels"}) != fingerprint({"text": "two towels"})
+
+def test_retry_gets_the_stored_response():
+    record = {"fingerprint": fingerprint({"text": "towels"}), "status_code": 201, "response": {"request_id": "r1"}}
+    assert replay_of(record, fingerprint({"text": "towels"}))["response"] == {"request_id": "r1"}
+
+def test_retry_while_the_first_attempt_runs_is_told_to_wait():
+    with pytest.raises(IdempotencyError) as error:
+        replay_of({"fingerprint": "abc", "response": None}, "abc")
+    assert error.value.status_code == 409 and "Retry-After" in error.value.headers
+
+def test_key_reused_for_a_different_body_is_rejected():
+    with pytest.raises(IdempotencyError) as error:
+        replay_of({"fingerprint": "abc", "response": {"request_id": "r1"}}, "def")
+    assert error.value.status_code == 422
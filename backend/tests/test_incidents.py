@@ -0,0 +1,36 @@
+from urllib.parse import urlsplit
+
+import pytest
+
+from main import ServiceManager
+from shared.incidents import INCIDENT_EMAIL_ALERT_URL, IncidentError, check_transition, classify_emergency
+
+@pytest.mark.parametrize("message,expected", [
+    ("There's smoke coming from under the door next to mine!", "fire"),
+    ("FIRE on the 3rd floor", "fire"),
+    ("my husband collapsed and is not breathing", "medical"),
+    ("please send an ambulance to room 214", "medical"),
+    ("someone is trying to get into my room", "security"),
+    ("a man with a knife in the lobby", "security"),
+    ("Can I get a non-smoking room?", None),
+    ("is there a fireplace in the suite?", None),
+    ("the steak knife is missing from my tray", None),
+    ("can you send more towels", None),
+])
+def test_classifies_emergencies(message, expected):
+    assert classify_emergency(message) == expected
+
+def test_allowed_incident_transitions():
+    check_transition("reported", "acknowledged")
+    check_transition("responding", "resolved")
+    check_transition("false_alarm", "closed")
+
+@pytest.mark.parametrize("current,new", [("closed", "reported"), ("resolved", "reported"), ("reported", "closed")])
+def test_rejects_invalid_incident_transitions(current, new):
+    with pytest.raises(IncidentError) as exc:
+        check_transition(current, new)
+    assert exc.value.status_code == 409
+
+def test_email_alerts_default_to_the_notifications_service():
+    notifications = next(s for s in ServiceManager().services if s["name"] == "notifications")
+    assert urlsplit(INCIDENT_EMAIL_ALERT_URL).netloc == f"localhost:{notifications['port']}"
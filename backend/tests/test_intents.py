@@ -0,0 +1,59 @@
+from datetime import datetime, timezone
+
+from shared.intents import (ChatEntities, clu_confidence, direct_intent, extract_entities, extract_line_items,
+                            merge_clu_entities)
+
+NOW = datetime(2025, 7, 22, 12, 0, tzinfo=timezone.utc)
+
+def test_quantity_and_item():
+    entities = extract_entities("Could I get 2 extra towels please", NOW, timezone.utc)
+    assert (entities.quantity, entities.item, entities.time) == (2, "towels", None)
+
+def test_number_words_and_of_phrases():
+    entities = extract_entities("Two bottles of water to my room", NOW, timezone.utc)
+    assert (entities.quantity, entities.item) == (2, "bottles of water")
+
+def test_times_are_not_quantities():
+    entities = extract_entities("More pillows at 8pm", NOW, timezone.utc)
+    assert entities.quantity is None
+    assert entities.item == "pillows"
+    assert entities.time == datetime(2025, 7, 22, 20, 0, tzinfo=timezone.utc)
+
+def test_nothing_to_extract():
+    entities = extract_entities("The wifi is down", NOW, timezone.utc)
+    assert (entities.quantity, entities.item, entities.time) == (None, None, None)
+
+def test_clu_confidence_reads_the_top_intent():
+    prediction = {"topIntent": "Housekeeping", "intents": [{"category": "Maintenance", "confidenceScore": 0.1},
+                                                           {"category": "Housekeeping", "confidenceScore": 0.87}]}
+    assert clu_confidence(prediction) == 0.87
+    assert clu_confidence({"topIntent": "IT", "intents": {"IT": {"score": 0.5}}}) == 0.5
+    assert clu_confidence({}) is None
+
+def test_clu_entities_override_the_regex():
+    entities = ChatEntities(quantity=1, item="towel")
+    merge_clu_entities(entities, {"entities": [{"category": "Quantity", "text": "three"},
+                                               {"category": "Item", "text": "Bath Towels"}]})
+    assert (entities.quantity, entities.item) == (3, "bath towels")
+
+def test_direct_intent_from_tags():
+    assert direct_intent(["dnd_off"]) == "dnd"
+    assert direct_intent(["wake_up_scheduled"]) == "wake_up"
+    assert direct_intent(["access_code_sent"]) == "door_access"
+    assert direct_intent(["flagged_guest"]) is None
+
+def test_line_items_from_a_list():
+    items = extract_line_items("Could I have 3 extra towels and 2 pillows, and a toothbrush")
+    assert [(li.item, li.quantity) for li in items] == [("towels", 3), ("pillows", 2), ("toothbrushes", 1)]
+
+def test_line_items_use_synonyms_and_add_up():
+    items = extract_line_items("2 bath towels, two bottles of water and 1 more towel")
+    assert [(li.item, li.quantity) for li in items] == [("towels", 3), ("water", 2)]
+
+def test_configured_synonyms():
+    items = extract_line_items("4 bath sheets please", {"towels": ["bath sheet", "bath sheets"]})
+    assert [(li.item, li.quantity) for li in items] == [("towels", 4)]
+
+def test_no_line_items_from_unrelated_numbers():
+    assert extract_line_items("The AC in room 512 is broken and the lights flicker") == []
+    assert [(li.item, li.quantity) for li in extract_line_items("more blankets tonight")] == [("blankets", None)]
@@ -0,0 +1,24 @@
+from shared.knowledge_base import KnowledgeArticle, answer_for, best_match
+
+def article(article_id, question, keywords, **fields) -> KnowledgeArticle:
+    return KnowledgeArticle(article_id=article_id, question=question, answer=f"{article_id} answer",
+                            keywords=keywords, **fields)
+
+PARKING = article("parking", "Do you have parking?", ["parking", "garage", "car"],
+                  answers={"fr": "Oui, un parking souterrain."})
+POOL = article("pool", "When is the pool open?", ["pool", "swimming"])
+WIFI = article("staff-wifi", "What is the staff wifi password?", ["wifi", "password"], public=False)
+
+def test_best_article_by_shared_words():
+    assert best_match([PARKING, POOL], "Is there a garage for my car?").article_id == "parking"
+    assert best_match([PARKING, POOL], "What time does the swimming pool open?").article_id == "pool"
+    assert best_match([PARKING, POOL], "Tell me a joke") is None
+
+def test_public_only_hides_internal_articles():
+    assert best_match([WIFI], "wifi password please").article_id == "staff-wifi"
+    assert best_match([WIFI], "wifi password please", public_only=True) is None
+    assert best_match([article("off", "Parking?", ["parking"], enabled=False)], "parking") is None
+
+def test_answer_in_the_guests_language():
+    assert answer_for(PARKING, "fr") == "Oui, un parking souterrain."
+    assert answer_for(PARKING, "es") == "parking answer"
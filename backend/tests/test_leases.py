@@ -0,0 +1,35 @@
+import asyncio
+
+from shared.leases import Lease
+
+class FlakyLease(Lease):
+    """Holds the lease for the first `renewals` acquisitions, then loses it."""
+
+    def __init__(self, renewals: int):
+        super().__init__("digests", 0.03, ttl_seconds=0.03)
+        self.renewals = renewals
+
+    async def acquire(self) -> bool:
+        self.renewals -= 1
+        return self.renewals >= 0
+
+def test_the_job_is_cancelled_when_the_lease_is_lost():
+    cancelled = []
+
+    async def job():
+        try:
+            await asyncio.sleep(1)
+        except asyncio.CancelledError:
+            cancelled.append(True)
+            raise
+        return "done"
+
+    assert asyncio.run(FlakyLease(renewals=2).run(job)) is None
+    assert cancelled == [True]
+
+def test_a_job_that_finishes_while_held_returns_its_result():
+    async def job():
+        await asyncio.sleep(0.02)
+        return "done"
+
+    assert asyncio.run(FlakyLease(renewals=5).run(job)) == "done"
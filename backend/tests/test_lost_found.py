@@ -0,0 +1,31 @@
+from datetime import datetime
+
+import pytest
+
+from shared.lost_found import categorize, detect_lost_item, match_score, rank_matches
+
+@pytest.mark.parametrize("message,expected", [
+    ("I left my phone charger in room 204", ("phone charger", "room 204")),
+    ("I think I lost my black wallet at the pool yesterday", ("black wallet", "pool")),
+    ("forgot our teddy bear!", ("teddy bear", None)),
+    ("my room is cold", None),
+])
+def test_detects_lost_items(message, expected):
+    assert detect_lost_item(message) == expected
+
+def test_category_follows_the_noun():
+    assert categorize("phone charger") == "electronics"
+    assert categorize("gold ring") == "jewellery"
+    assert categorize("a book") is None
+
+def test_matches_rank_by_description_category_and_place():
+    report = {"description": "black leather wallet", "category": "wallet", "lost_location": "pool",
+              "room_number": "204", "lost_at": datetime(2025, 7, 20)}
+    wallet = {"item_id": "f1", "description": "Brown leather wallet with cards", "category": "wallet",
+              "found_location": "Pool deck", "found_at": datetime(2025, 7, 21)}
+    charger = {"item_id": "f2", "description": "iPhone charger", "category": "electronics",
+               "found_location": "204", "found_at": datetime(2025, 7, 21)}
+    old_wallet = {"item_id": "f3", "description": "leather wallet", "category": "wallet",
+                  "found_location": "lobby", "found_at": datetime(2025, 7, 1)}
+    assert match_score(report, old_wallet) == 0.0
+    assert [m["item"]["item_id"] for m in rank_matches(report, [charger, old_wallet, wallet])] == ["f1"]
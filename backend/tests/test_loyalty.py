@@ -0,0 +1,43 @@
+from contextlib import contextmanager
+from datetime import datetime
+
+from shared.loyalty import boost_priority, queue_key, shortest_sla_minutes, tier_sla_minutes
+from shared.runtime_config import LoyaltyTier, RuntimeSettings, runtime_config
+
+@contextmanager
+def tier_rules():
+    previous = runtime_config.settings
+    runtime_config.settings = RuntimeSettings(loyalty_tiers={
+        "platinum": LoyaltyTier(priority_boost=1, sla_percent=50, rank=2),
+        "gold": LoyaltyTier(sla_percent=75, rank=1),
+    })
+    try:
+        yield
+    finally:
+        runtime_config.settings = previous
+
+def test_priority_boost_stops_short_of_urgent():
+    with tier_rules():
+        assert boost_priority("low", "platinum") == "medium"
+        assert boost_priority("high", "PLATINUM") == "high"
+        assert boost_priority("urgent", "platinum") == "urgent"
+        assert boost_priority("low", "gold") == "low"
+        assert boost_priority("low", "bronze") == "low"
+        assert boost_priority("low", None) == "low"
+
+def test_dedicated_sla_targets():
+    with tier_rules():
+        assert tier_sla_minutes(45, "platinum") == 23
+        assert tier_sla_minutes(45, "gold") == 34
+        assert tier_sla_minutes(45, None) == 45
+        assert shortest_sla_minutes(45) == 23
+
+def test_queue_order_priority_then_tier_then_age():
+    with tier_rules():
+        orders = [
+            {"id": "old", "priority": "medium", "created_at": datetime(2026, 1, 1, 9, 0)},
+            {"id": "gold", "priority": "medium", "loyalty_tier": "gold", "created_at": datetime(2026, 1, 1, 9, 5)},
+            {"id": "platinum", "priority": "medium", "loyalty_tier": "platinum", "created_at": datetime(2026, 1, 1, 9, 10)},
+            {"id": "high", "priority": "high", "created_at": datetime(2026, 1, 1, 9, 15)},
+        ]
+        assert [o["id"] for o in sorted(orders, key=queue_key)] == ["high", "platinum", "gold", "old"]
@@ -0,0 +1,32 @@
+from shared.messages import MessageCatalog, fallback_chain, plural_category, render
+
+BASE = {
+    "en": {"greeting": "Hello!", "updates": {"one": "You have 1 update.", "other": "You have {count} updates."},
+           "closed": "Closed until {time}."},
+    "fr": {"greeting": "Bonjour !", "updates": {"one": "{count} mise à jour.", "other": "{count} mises à jour."}},
+}
+
+def test_fallback_chain():
+    assert fallback_chain("fr-CA") == ["fr-ca", "fr", "en"]
+    assert fallback_chain("fr_ca") == ["fr-ca", "fr", "en"]
+    assert fallback_chain("en") == ["en"]
+    assert fallback_chain(None) == ["en"]
+
+def test_plural_rules():
+    assert plural_category("en", 1) == "one" and plural_category("en", 0) == "other"
+    assert plural_category("fr", 0) == "one" and plural_category("fr-CA", 2) == "other"
+    assert plural_category("zh", 1) == "other"
+
+def test_lookup_falls_back_and_hotel_overrides_win():
+    catalog = MessageCatalog(base=BASE)
+    assert catalog.translate("greeting", "fr-CA") == "Bonjour !"
+    assert catalog.translate("closed", "fr", time="18:00") == "Closed until 18:00."
+    assert catalog.translate("updates", "fr", count=0) == "0 mise à jour."
+    assert catalog.translate("updates", "en", count=3) == "You have 3 updates."
+    assert catalog.translate("missing_key", "fr") == "missing_key"
+    catalog.overrides = {"fr": {"greeting": "Bienvenue au Grand !"}}
+    assert catalog.translate("greeting", "fr-CA") == "Bienvenue au Grand !"
+    assert catalog.translate("greeting", "en") == "Hello!"
+
+def test_missing_placeholders_are_left_alone():
+    assert render("Closed until {time} on {day}.", "en", time="18:00") == "Closed until 18:00 on {day}."
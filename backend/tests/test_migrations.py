@@ -0,0 +1,54 @@
+from datetime import datetime
+
+from shared.migrations.indexes import INDEXES, IndexSpec, plan_indexes
+from shared.migrations.versions import Migration, pending, renamed_duplicates
+
+async def noop(db):
+    return None
+
+UNIQUE_REQUEST = IndexSpec("work_orders", [("request_id", 1)], {"unique": True})
+TEXT = IndexSpec("work_orders", [("description", "text")])
+TTL = IndexSpec("notification_logs", [("timestamp", 1)], {"expireAfterSeconds": 3600}, name="ttl_timestamp")
+
+def test_default_names_match_mongo():
+    assert UNIQUE_REQUEST.name == "request_id_1"
+    assert IndexSpec("c", [("guest_id", 1), ("status", -1)]).name == "guest_id_1_status_-1"
+
+def test_missing_index_is_created():
+    plan = plan_indexes([UNIQUE_REQUEST], {"work_orders": {"_id_": {"key": [("_id", 1)]}}})
+    assert [(s["action"], s["name"]) for s in plan] == [("create", "request_id_1")]
+
+def test_matching_index_needs_nothing_whatever_its_name():
+    existing = {"work_orders": {
+        "by_request": {"key": [("request_id", 1.0)], "unique": True, "v": 2},
+        "description_text": {"key": [("_fts", "text"), ("_ftsx", 1)], "weights": {"description": 1}},
+    }}
+    assert plan_indexes([UNIQUE_REQUEST, TEXT], existing) == []
+
+def test_changed_options_rebuild_the_index():
+    plan = plan_indexes([UNIQUE_REQUEST], {"work_orders": {"request_id_1": {"key": [("request_id", 1)]}}})
+    assert plan[0]["action"] == "replace" and plan[0]["drop"] == "request_id_1"
+
+def test_sparse_order_number_index_becomes_partial():
+    spec = next(s for s in INDEXES if s.keys == [("order_number", 1)])
+    plan = plan_indexes([spec], {"work_orders": {"order_number_1": {"key": [("order_number", 1)], "unique": True,
+                                                                    "sparse": True}}})
+    assert plan[0]["action"] == "replace" and plan[0]["drop"] == "order_number_1"
+
+def test_ttl_change_is_applied_in_place():
+    existing = {"notification_logs": {"ttl_timestamp": {"key": [("timestamp", 1)], "expireAfterSeconds": 60}}}
+    plan = plan_indexes([TTL], existing)
+    assert [(s["action"], s["from"], s["to"]) for s in plan] == [("update_ttl", 60, 3600)]
+
+def test_pending_migrations_run_in_version_order():
+    migrations = [Migration(3, "c", noop), Migration(1, "a", noop), Migration(2, "b", noop)]
+    assert [m.version for m in pending(migrations, {2})] == [1, 3]
+    assert pending(migrations, {1, 2, 3}) == []
+
+def test_oldest_document_keeps_its_request_id():
+    docs = [
+        {"_id": "b", "request_id": "req_1", "created_at": datetime(2024, 1, 2)},
+        {"_id": "a", "request_id": "req_1", "created_at": datetime(2024, 1, 1)},
+        {"_id": "c", "request_id": "req_1", "created_at": None},
+    ]
+    assert renamed_duplicates(docs) == [("b", "req_1~1"), ("c", "req_1~2")]
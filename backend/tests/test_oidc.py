@@ -0,0 +1,51 @@
+import pytest
+from jose.exceptions import JWTError
+
+from shared.security.oidc import OidcError, OidcProvider, is_logged_out, map_claims, validate_provider
+
+ISSUER = "https://login.microsoftonline.com/tid/v2.0"
+
+def provider(**overrides):
+    return OidcProvider(**{
+        "tenant": "grand-hotel",
+        "issuer": ISSUER,
+        "audience": "api://virtual-butler",
+        "group_roles": {"g-managers": "admin", "g-engineering": "staff", "g-housekeeping": "staff"},
+        "group_departments": {"g-engineering": "maintenance", "g-housekeeping": "housekeeping"},
+        **overrides
+    })
+
+def claims(**overrides):
+    return {"iss": ISSUER, "sub": "idp-sub", "oid": "object-id", "sid": "session-1", "iat": 1000,
+            "name": "Sam Doe", "preferred_username": "sam@hotel.example", **overrides}
+
+def test_groups_map_to_highest_role_and_departments():
+    payload = map_claims(provider(), claims(groups=["g-engineering", "g-managers", "g-other"]))
+    assert payload["sub"] == "oidc:object-id"
+    assert payload["role"] == "admin"
+    assert payload["departments"] == ["maintenance"]
+    assert payload["tenant"] == "grand-hotel"
+
+def test_unmapped_accounts_get_no_access():
+    with pytest.raises(JWTError):
+        map_claims(provider(), claims(groups=["g-other"]))
+
+def test_group_overage_is_rejected_with_a_hint():
+    with pytest.raises(JWTError):
+        map_claims(provider(), claims(_claim_names={"groups": "src1"}))
+
+def test_app_roles_claim():
+    payload = map_claims(provider(groups_claim="roles", group_roles={"Butler.Staff": "staff"}),
+                         claims(roles=["Butler.Staff"]))
+    assert payload["role"] == "staff"
+
+def test_logout_by_session_or_subject():
+    assert is_logged_out(claims(), {(ISSUER, "session-1")}, {})
+    assert not is_logged_out(claims(), {(ISSUER, "session-2")}, {})
+    # Logging a subject out everywhere kills tokens issued before, not after
+    assert is_logged_out(claims(sid=None), set(), {(ISSUER, "idp-sub"): 1500})
+    assert not is_logged_out(claims(sid=None, iat=2000), set(), {(ISSUER, "idp-sub"): 1500})
+
+def test_rejects_unknown_roles():
+    with pytest.raises(OidcError):
+        validate_provider(provider(group_roles={"g": "superuser"}))
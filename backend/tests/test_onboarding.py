@@ -0,0 +1,17 @@
+from datetime import datetime, timedelta, timezone
+
+from shared.onboarding import code_expiry, onboarding_link, session_ttl
+
+NOW = datetime(2026, 6, 1, 15, 0, tzinfo=timezone.utc)
+
+def test_code_expires_at_checkout_at_the_latest():
+    assert code_expiry(NOW, None) == NOW + timedelta(hours=72)
+    assert code_expiry(NOW, datetime(2026, 6, 2, 11, 0)) == datetime(2026, 6, 2, 11, 0, tzinfo=timezone.utc)
+    assert code_expiry(NOW, datetime(2026, 6, 10, 11, 0, tzinfo=timezone.utc)) == NOW + timedelta(hours=72)
+
+def test_guest_token_lasts_the_stay():
+    assert session_ttl(NOW, datetime(2026, 6, 3, 11, 0, tzinfo=timezone.utc)) == timedelta(hours=44)
+    assert session_ttl(NOW, None) == timedelta(hours=24)
+
+def test_link_carries_the_token():
+    assert onboarding_link("abc123") == "https://butler.example.com/onboard/abc123"
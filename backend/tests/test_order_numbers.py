@@ -0,0 +1,16 @@
+from shared.db.models import DepartmentEnum
+from shared.order_numbers import is_order_number, normalize_order_number, order_number_prefix
+
+def test_order_numbers_are_recognised_in_any_case():
+    assert is_order_number("HK-2045")
+    assert is_order_number(" hk-2045 ")
+    assert is_order_number("MT-1001.2")
+    assert normalize_order_number(" hk-2045 ") == "HK-2045"
+    for value in ("wo_1715000000.12", "req_1715000000.5", "HK2045", "HK-", "", None):
+        assert not is_order_number(value)
+
+def test_every_department_has_its_own_prefix():
+    assert order_number_prefix("housekeeping") == "HK"
+    assert order_number_prefix("room_service") == "RS"
+    prefixes = [order_number_prefix(d.value) for d in DepartmentEnum]
+    assert len(set(prefixes)) == len(prefixes)
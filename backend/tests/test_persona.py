@@ -0,0 +1,36 @@
+from datetime import datetime
+
+from shared.persona import ResponsePersona, apply_persona, daypart, greeting
+
+EVENING = datetime(2026, 3, 14, 19, 30)
+
+def test_dayparts():
+    assert daypart(datetime(2026, 3, 14, 8)) == "morning"
+    assert daypart(datetime(2026, 3, 14, 13)) == "afternoon"
+    assert daypart(EVENING) == "evening"
+    assert daypart(datetime(2026, 3, 14, 2)) == "evening"
+
+def test_greetings_by_style_and_language():
+    formal = ResponsePersona(greeting_style="formal")
+    assert greeting(formal, "en", EVENING, "Anna Laurent") == "Good evening, Anna Laurent."
+    assert greeting(formal, "fr", EVENING) == "Bonsoir."
+    assert greeting(ResponsePersona(greeting_style="casual"), "en", EVENING, "Anna") == "Hi Anna!"
+    assert greeting(formal, "de", EVENING) is None
+    custom = ResponsePersona(greeting_style="formal", greetings={"en": "Welcome back{name}."})
+    assert greeting(custom, "en", EVENING, "Anna") == "Welcome back, Anna."
+
+def test_no_persona_sends_replies_as_written():
+    assert apply_persona("Towels are on the way 🧺", None, "en", EVENING) == "Towels are on the way 🧺"
+    assert apply_persona("Towels are on the way", ResponsePersona(), "en", EVENING) == "Towels are on the way"
+
+def test_applies_greeting_sign_off_and_emoji_policy():
+    persona = ResponsePersona(greeting_style="formal", sign_offs={"en": "— The Concierge Team"}, emoji_policy="none")
+    reply = apply_persona("Towels 🧺 are on the way ✨", persona, "en", EVENING, name="Anna")
+    assert reply == "Good evening, Anna. Towels are on the way\n\n— The Concierge Team"
+    assert apply_persona("Des serviettes arrivent", persona, "fr", EVENING) == "Bonsoir. Des serviettes arrivent"
+
+def test_expressive_adds_the_department_emoji():
+    persona = ResponsePersona(emoji_policy="expressive")
+    assert apply_persona("Towels are on the way", persona, "en", EVENING, department="housekeeping") == \
+        "Towels are on the way 🧺"
+    assert apply_persona("Noted", persona, "en", EVENING, department="unknown") == "Noted"
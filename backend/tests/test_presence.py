@@ -0,0 +1,22 @@
+from datetime import datetime, timedelta, timezone
+
+from shared.presence import STAFF_OFFLINE_AFTER_SECONDS, is_online, presence_view
+
+NOW = datetime(2026, 10, 16, 9, 0, tzinfo=timezone.utc)
+
+def test_online_while_a_heartbeat_is_recent():
+    assert is_online({"last_seen_at": NOW - timedelta(seconds=STAFF_OFFLINE_AFTER_SECONDS - 1)}, NOW)
+    assert not is_online({"last_seen_at": NOW - timedelta(seconds=STAFF_OFFLINE_AFTER_SECONDS + 1)}, NOW)
+    # Naive values are UTC
+    assert is_online({"last_seen_at": (NOW - timedelta(seconds=5)).replace(tzinfo=None)}, NOW)
+
+def test_signed_out_staff_are_offline_and_untracked_staff_are_not():
+    assert not is_online({"last_seen_at": None}, NOW)
+    assert is_online({}, NOW)
+
+def test_presence_view_tells_rostered_from_reachable():
+    member = {"staff_id": "s1", "department": "housekeeping", "on_shift": True,
+              "last_seen_at": NOW - timedelta(minutes=30)}
+    assert presence_view(member, NOW) == {"staff_id": "s1", "name": None, "department": "housekeeping",
+                                          "on_shift": True, "online": False, "last_seen_at": member["last_seen_at"],
+                                          "current_zone": None}
@@ -0,0 +1,29 @@
+from datetime import datetime, timedelta, timezone
+
+from shared.preventive_maintenance import GENERATING, days_overdue, generation_due, next_due_after
+
+NOW = datetime(2025, 7, 22, 9, 0, tzinfo=timezone.utc)
+
+def schedule(**kwargs):
+    return {"schedule_id": "pm_1", "interval_days": 90, "lead_days": 0, "active": True,
+            "open_work_order_id": None, "next_due_at": NOW, **kwargs}
+
+def test_next_due_counts_from_actual_completion():
+    late = NOW + timedelta(days=10)
+    assert next_due_after(late, 90) == late + timedelta(days=90)
+
+def test_generation_respects_lead_time_and_open_orders():
+    assert generation_due(schedule(), NOW)
+    assert not generation_due(schedule(next_due_at=NOW + timedelta(days=3)), NOW)
+    assert generation_due(schedule(next_due_at=NOW + timedelta(days=3), lead_days=3), NOW)
+    assert not generation_due(schedule(open_work_order_id="wo_1"), NOW)
+    assert not generation_due(schedule(active=False), NOW)
+
+def test_a_claim_left_by_a_dead_replica_is_taken_over():
+    assert not generation_due(schedule(open_work_order_id=GENERATING, claimed_at=NOW - timedelta(minutes=1)), NOW)
+    assert generation_due(schedule(open_work_order_id=GENERATING, claimed_at=NOW - timedelta(hours=1)), NOW)
+    assert generation_due(schedule(open_work_order_id=GENERATING), NOW)
+
+def test_days_overdue():
+    assert days_overdue(schedule(next_due_at=NOW - timedelta(days=5, hours=2)), NOW) == 5
+    assert days_overdue(schedule(next_due_at=NOW + timedelta(days=1)), NOW) == 0
@@ -0,0 +1,37 @@
+from datetime import datetime, timezone
+
+from shared.promotions import Promotion, choose, conversion, eligible, guest_segments, offer_text
+
+NOW = datetime(2026, 6, 1, 19, 0, tzinfo=timezone.utc)
+
+def promotion(promotion_id="wine", **fields) -> Promotion:
+    defaults = {"name": "Wine pairing", "trigger": "room_service", "message": "Add a glass of Rioja for 12?",
+                "department": "room_service", "fulfilment": "Glass of Rioja with the order"}
+    return Promotion(promotion_id=promotion_id, **(defaults | fields))
+
+def test_segments_from_the_profile():
+    assert guest_segments(None) == {"standard"}
+    assert guest_segments({"vip_status": True, "preferences": {"segments": ["platinum"]}}) == {"vip", "platinum"}
+
+def test_eligibility_rules():
+    assert eligible(promotion(), "room_service", "default", {"standard"}, NOW)
+    assert not eligible(promotion(), "spa_booking", "default", {"standard"}, NOW)
+    assert not eligible(promotion(active=False), "room_service", "default", {"standard"}, NOW)
+    assert not eligible(promotion(hotel_ids=["paris"]), "room_service", "default", {"standard"}, NOW)
+    assert not eligible(promotion(segments=["vip"]), "room_service", "default", {"standard"}, NOW)
+    assert eligible(promotion(segments=["vip"]), "room_service", "default", {"vip"}, NOW)
+    assert not eligible(promotion(valid_until=datetime(2026, 5, 31)), "room_service", "default", {"standard"}, NOW)
+    assert not eligible(promotion(valid_from=datetime(2026, 6, 2)), "room_service", "default", {"standard"}, NOW)
+
+def test_one_offer_per_trigger_by_priority_skipping_recent_ones():
+    wine, dessert = promotion("wine", priority=2), promotion("dessert", priority=1)
+    assert choose([dessert, wine], "room_service", "default", {"standard"}, NOW).promotion_id == "wine"
+    assert choose([dessert, wine], "room_service", "default", {"standard"}, NOW, {"wine"}).promotion_id == "dessert"
+    assert choose([dessert, wine], "room_service", "default", {"standard"}, NOW, {"wine", "dessert"}) is None
+
+def test_offer_text_and_conversion():
+    localized = promotion(messages={"fr": "Un verre de Rioja pour 12 ?"})
+    assert offer_text(localized, "fr") == "Un verre de Rioja pour 12 ?"
+    assert offer_text(localized, "es") == "Add a glass of Rioja for 12?"
+    assert conversion(8, 2) == 0.25
+    assert conversion(0, 0) is None
@@ -0,0 +1,18 @@
+from shared.db.models import DepartmentEnum, PriorityEnum
+from shared.quick_actions import QUICK_ACTION_TAG, QuickAction, guest_view, label_for, work_order_tags
+
+def towels(**overrides):
+    return QuickAction(**{"action_id": "extra_towels", "label": "Extra towels", "labels": {"fr": "Serviettes"},
+                          "department": DepartmentEnum.HOUSEKEEPING, "priority": PriorityEnum.MEDIUM,
+                          "description": "Extra towels", "tags": [], **overrides})
+
+def test_label_falls_back_to_the_default():
+    assert label_for(towels(), "fr") == "Serviettes"
+    assert label_for(towels(), "es") == "Extra towels"
+
+def test_guest_view_has_only_what_the_button_needs():
+    assert guest_view(towels(icon="towel"), "fr") == {"action_id": "extra_towels", "label": "Serviettes",
+                                                      "department": "housekeeping", "icon": "towel"}
+
+def test_work_order_tags_name_the_action_once():
+    assert work_order_tags(towels(tags=["linen", QUICK_ACTION_TAG])) == [QUICK_ACTION_TAG, "quick_action:extra_towels", "linen"]
@@ -0,0 +1,30 @@
+from datetime import datetime, timedelta, timezone
+
+from shared.read_models import minutes_remaining, synthesized_events
+
+CREATED = datetime(2024, 5, 1, 9, 0)
+
+def order(**fields):
+    return {"work_order_id": "wo_1", "department": "housekeeping", "priority": "medium", "status": "pending",
+            "metadata": {"room_number": "204"}, "parent_id": None, "created_at": CREATED, **fields}
+
+def test_open_orders_replay_as_created_in_their_current_status():
+    events = synthesized_events(order(status="assigned", assigned_staff="staff_1",
+                                      assigned_at=CREATED + timedelta(minutes=3)))
+    assert [e[0] for e in events] == ["work_order.created", "work_order.assigned"]
+    assert events[0][1]["status"] == "assigned"
+    assert events[0][1]["room_number"] == "204"
+
+def test_closed_orders_replay_through_to_their_final_status():
+    done = CREATED + timedelta(minutes=70)
+    events = synthesized_events(order(status="completed", assigned_staff="staff_1",
+                                      sla_breached_at=CREATED + timedelta(minutes=45), completed_at=done))
+    assert [e[0] for e in events] == ["work_order.created", "work_order.assigned", "work_order.sla_breached",
+                                      "work_order.status_changed"]
+    assert events[0][1]["status"] == "pending"
+    assert events[-1][1]["status"] == "completed" and events[-1][2] == done
+
+def test_minutes_remaining_goes_negative_when_overdue():
+    now = datetime(2024, 5, 1, 10, 0, tzinfo=timezone.utc)
+    assert minutes_remaining(datetime(2024, 5, 1, 10, 30), now) == 30
+    assert minutes_remaining(datetime(2024, 5, 1, 9, 45, tzinfo=timezone.utc), now) == -15
@@ -0,0 +1,48 @@
+from datetime import datetime
+from zoneinfo import ZoneInfo
+
+import pytest
+
+from shared.db.models import Recommendation
+from shared.recommendations import detect_recommendation, open_until, rank
+
+PARIS = ZoneInfo("Europe/Paris")
+HOTEL = (48.8606, 2.3376)
+
+def place(rec_id, **kwargs):
+    return Recommendation(recommendation_id=rec_id, name=rec_id.title(), category="restaurant", **kwargs)
+
+@pytest.mark.parametrize("message,expected", [
+    ("Where should I eat tonight?", "restaurant"),
+    ("can you recommend a bar?", "restaurant"),
+    ("what should we do this afternoon", "attraction"),
+    ("how do I get to the airport?", "transport"),
+    ("I'd like dinner in my room", None),
+    ("the restaurant was great", None),
+])
+def test_detects_recommendation_requests(message, expected):
+    assert detect_recommendation(message) == expected
+
+def test_late_opening_hours_run_past_midnight():
+    bistro = place("bistro", opening_hours={"tue": ["19:00-01:00"]})
+    # Wednesday 00:30 is still Tuesday's service
+    assert open_until(bistro, datetime(2025, 7, 23, 0, 30, tzinfo=PARIS)) == datetime(2025, 7, 23, 1, 0, tzinfo=PARIS)
+    assert open_until(bistro, datetime(2025, 7, 23, 1, 30, tzinfo=PARIS)) is None
+
+def test_rank_skips_closed_places_and_orders_by_distance():
+    at = datetime(2025, 7, 22, 20, 0, tzinfo=PARIS)
+    recs = [
+        place("far", latitude=48.8867, longitude=2.3431, opening_hours={"tue": ["18:00-23:00"]}),
+        place("near", latitude=48.8610, longitude=2.3380),
+        place("lunch_only", latitude=48.8606, longitude=2.3376, opening_hours={"tue": ["12:00-15:00"]}),
+    ]
+    cards = rank(recs, at, *HOTEL)
+    assert [c["id"] for c in cards] == ["near", "far"]
+    assert cards[1]["open_until"] == "23:00" and cards[0]["open_until"] is None
+    assert cards[0]["actions"][0]["type"] == "map"
+
+def test_curated_priority_breaks_near_ties():
+    at = datetime(2025, 7, 22, 20, 0, tzinfo=PARIS)
+    recs = [place("plain", latitude=48.8610, longitude=2.3380),
+            place("featured", latitude=48.8620, longitude=2.3390, priority=10)]
+    assert [c["id"] for c in rank(recs, at, *HOTEL)] == ["featured", "plain"]
@@ -0,0 +1,34 @@
+import pytest
+
+from shared.db.models import GuestProfile
+from shared.rooms import RoomError, chosen_room, linked_rooms, mentioned_room, resolve_room
+
+FAMILY = ["1204", "1205", "1206"]
+
+def test_primary_room_first_without_duplicates():
+    profile = GuestProfile(guest_id="g1", room_number="1204", rooms=["1205", "1204", "1206", "1205"])
+    assert linked_rooms(profile) == FAMILY
+    assert linked_rooms({"room_number": "301"}) == ["301"]
+    assert linked_rooms(None) == []
+
+def test_room_named_in_the_text():
+    assert mentioned_room("Two towels for 1205 please", FAMILY) == "1205"
+    assert mentioned_room("Two towels please", FAMILY) is None
+    assert mentioned_room("Move the cot from 1204 to 1205", FAMILY) is None
+    assert mentioned_room("Call 12045 please", FAMILY) is None
+
+def test_resolution_asks_only_multi_room_guests():
+    assert resolve_room(["301"], "Two towels please") == "301"
+    assert resolve_room([], "Two towels please") is None
+    assert resolve_room(FAMILY, "Two towels please") is None
+    assert resolve_room(FAMILY, "Two towels for 1206") == "1206"
+    assert resolve_room(FAMILY, "Two towels for 1206", explicit="1204") == "1204"
+    with pytest.raises(RoomError):
+        resolve_room(FAMILY, "Two towels", explicit="999")
+    with pytest.raises(RoomError):
+        resolve_room([], "Two towels", explicit="301")
+
+def test_short_answers_pick_the_room():
+    assert chosen_room("1205", FAMILY) == "1205"
+    assert chosen_room("Room 1205", FAMILY) == "1205"
+    assert chosen_room("Actually can I also get a taxi to 1205 street", FAMILY) is None
@@ -0,0 +1,46 @@
+import re
+
+from shared.db.models import DepartmentEnum
+from shared.routing_feedback import normalize_phrase, phrase_pattern, phrase_rules, summarize
+
+def correction(text, predicted, corrected, source="rules"):
+    return {"text": text, "predicted": predicted, "corrected": corrected, "intent_source": source}
+
+def test_phrase_pattern_matches_the_phrase_alone():
+    pattern = phrase_pattern(normalize_phrase("The TV remote, please!"))
+    assert re.search(pattern, "the tv remote please")
+    assert re.search(pattern, "  the tv   remote, please?")
+    assert not re.search(pattern, "the tv remote please and towels")
+
+def test_repeated_corrections_become_rules():
+    rules = phrase_rules([
+        correction("Spare key card", "housekeeping", "front_desk"),
+        correction("spare key card!", "housekeeping", "front_desk"),
+        correction("Ice bucket", "maintenance", "room_service"),
+    ], min_count=2)
+    assert [(r.department, r.pattern) for r in rules] == [
+        (DepartmentEnum.FRONT_DESK, phrase_pattern("spare key card"))
+    ]
+
+def test_disputed_phrases_are_left_alone():
+    rules = phrase_rules([
+        correction("light", "housekeeping", "maintenance"),
+        correction("light", "housekeeping", "maintenance"),
+        correction("light", "maintenance", "it"),
+        correction("light", "maintenance", "it"),
+    ], min_count=2)
+    assert rules == []
+
+def test_summary_accuracy_and_confusions():
+    report = summarize({"housekeeping": 8, "maintenance": 2}, [
+        correction("bulb", "housekeeping", "maintenance", "clu"),
+        correction("lamp", "housekeeping", "maintenance", "rules"),
+    ])
+    assert report["orders"] == 10 and report["misrouted"] == 2 and report["accuracy"] == 0.8
+    assert report["by_department"][0] == {"department": "housekeeping", "routed": 8, "misrouted": 2,
+                                          "misrouting_rate": 0.25}
+    assert report["by_source"] == {"clu": 1, "rules": 1}
+    assert report["top_confusions"] == [{"from": "housekeeping", "to": "maintenance", "count": 2}]
+
+def test_summary_without_orders():
+    assert summarize({}, [])["accuracy"] is None
@@ -0,0 +1,50 @@
+import pytest
+
+from shared.db.models import DepartmentEnum
+from shared.routing_rules import RoutingRules, edit_distance, match_rules, rules_from_keywords
+
+RULES = rules_from_keywords({
+    DepartmentEnum.HOUSEKEEPING: [r"towel|clean|linen|pillow|blanket"],
+    DepartmentEnum.MAINTENANCE: [r"ac|repair|leak|light|plumbing"],
+    DepartmentEnum.IT: [r"wifi|internet|tv|network"],
+})
+
+def test_edit_distance_counts_a_swap_once():
+    assert edit_distance("towle", "towel") == 1
+    assert edit_distance("internte", "internet") == 1
+    assert edit_distance("blanket", "blanket") == 0
+    assert edit_distance("", "abc") == 3
+
+@pytest.mark.parametrize("text,department,corrected", [
+    ("can I get a towle", DepartmentEnum.HOUSEKEEPING, "can i get a towel"),
+    ("no internte in my room", DepartmentEnum.IT, "no internet in my room"),
+    ("extra blankte please", DepartmentEnum.HOUSEKEEPING, "extra blanket please"),
+])
+def test_misspellings_match_fuzzily(text, department, corrected):
+    match = match_rules(RULES, text)
+    assert (match.department, match.corrected) == (department, corrected)
+    assert match.similarity < 1.0
+
+def test_split_keywords_are_joined():
+    match = match_rules(RULES, "the wi fi keeps dropping")
+    assert (match.department, match.word, match.corrected) == (DepartmentEnum.IT, "wi fi", "the wifi keeps dropping")
+    assert match.similarity == 1.0
+
+def test_exact_matches_are_certain():
+    assert match_rules(RULES, "Need a clean room").similarity == 1.0
+
+@pytest.mark.parametrize("text", ["good night", "hello there", "what time is breakfast"])
+def test_ordinary_words_are_not_stretched_into_keywords(text):
+    assert match_rules(RULES, text) is None
+
+def test_synonyms_are_rewritten_before_matching():
+    rules = RoutingRules(builtin=RULES, builtin_synonyms={"wifi": ["wireless"]})
+    assert rules.decide("the wireless is down") == DepartmentEnum.IT
+    assert RoutingRules(builtin=RULES, builtin_synonyms={}).decide("the wireless is down") is None
+
+def test_toilet_paper_is_not_a_plumbing_job():
+    rules = RoutingRules(builtin=rules_from_keywords({
+        DepartmentEnum.HOUSEKEEPING: [r"towel|toiletries"], DepartmentEnum.MAINTENANCE: [r"plumbing"],
+    }))
+    assert rules.decide("toilet paper please") == DepartmentEnum.HOUSEKEEPING
+    assert rules.decide("the toilet is blocked") == DepartmentEnum.MAINTENANCE
@@ -0,0 +1,39 @@
+import base64
+import hashlib
+import hmac
+from datetime import datetime, timezone
+
+from shared.runtime_config import app_config_headers, merge_layers, parse_connection_string, settings_hash
+
+def test_later_layers_win_key_by_key():
+    merged = merge_layers(
+        {"sla_target_minutes": {"housekeeping": 30}, "rate_limits": {"chat_per_minute": 20}},
+        {},
+        {"sla_target_minutes": {"maintenance": 120}, "feature_flags": {"llm_replies": True}},
+        {"rate_limits": {"chat_per_minute": 5}},
+    )
+    assert merged == {
+        "sla_target_minutes": {"housekeeping": 30, "maintenance": 120},
+        "rate_limits": {"chat_per_minute": 5},
+        "feature_flags": {"llm_replies": True},
+    }
+
+def test_settings_hash_ignores_key_order():
+    assert settings_hash({"a": 1, "b": {"c": 2}}) == settings_hash({"b": {"c": 2}, "a": 1})
+    assert settings_hash({"a": 1}) != settings_hash({"a": 2})
+
+def test_parses_app_configuration_connection_string():
+    parts = parse_connection_string("Endpoint=https://butler.azconfig.io;Id=abc-l0-s0:xyz;Secret=c2VjcmV0PQ==")
+    assert parts == {"Endpoint": "https://butler.azconfig.io", "Id": "abc-l0-s0:xyz", "Secret": "c2VjcmV0PQ=="}
+
+def test_signs_app_configuration_requests():
+    secret = base64.b64encode(b"key").decode()
+    now = datetime(2025, 3, 1, 9, 30, tzinfo=timezone.utc)
+    headers = app_config_headers("get", "https://butler.azconfig.io/kv/runtime?api-version=1.0", "cred", secret, now)
+    empty_hash = base64.b64encode(hashlib.sha256(b"").digest()).decode()
+    signed = f"GET\n/kv/runtime?api-version=1.0\nSat, 01 Mar 2025 09:30:00 GMT;butler.azconfig.io;{empty_hash}"
+    signature = base64.b64encode(hmac.new(b"key", signed.encode(), hashlib.sha256).digest()).decode()
+    assert headers["x-ms-date"] == "Sat, 01 Mar 2025 09:30:00 GMT"
+    assert headers["x-ms-content-sha256"] == empty_hash
+    assert headers["Authorization"] == ("HMAC-SHA256 Credential=cred&SignedHeaders=x-ms-date;host;x-ms-content-sha256"
+                                        f"&Signature={signature}")
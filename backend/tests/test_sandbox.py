@@ -0,0 +1,25 @@
+from datetime import datetime, timezone
+from zoneinfo import ZoneInfo
+
+from shared.sandbox import SYNTHETIC_TAG, mark_synthetic, purge_cutoff
+
+NAIROBI = ZoneInfo("Africa/Nairobi")
+NOW = datetime(2026, 3, 14, 9, 0, tzinfo=timezone.utc)
+
+def test_orders_are_marked_and_tagged_once():
+    doc = {"work_order_id": "wo_1", "tags": ["vip"]}
+    mark_synthetic("work_orders", doc, NOW)
+    mark_synthetic("work_orders", doc, datetime(2026, 3, 15, tzinfo=timezone.utc))
+    assert doc == {"work_order_id": "wo_1", "tags": ["vip", SYNTHETIC_TAG], "synthetic": True, "synthetic_at": NOW}
+
+def test_untagged_collections_are_only_marked():
+    doc = mark_synthetic("notifications", {"notification_id": "n_1"}, NOW)
+    assert doc["synthetic"] is True and "tags" not in doc
+
+def test_cutoff_is_the_last_purge_time_in_hotel_time():
+    # 12:00 in Nairobi: this morning's 03:00, i.e. 00:00 UTC
+    assert purge_cutoff(NOW, NAIROBI, "03:00") == datetime(2026, 3, 14, 0, 0, tzinfo=timezone.utc)
+
+def test_before_tonights_purge_the_cutoff_is_last_night():
+    # 12:00 in Nairobi, purging at 23:30: last night's
+    assert purge_cutoff(NOW, NAIROBI, "23:30") == datetime(2026, 3, 13, 20, 30, tzinfo=timezone.utc)
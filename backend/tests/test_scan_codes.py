@@ -0,0 +1,39 @@
+from datetime import datetime
+
+import pytest
+
+from shared.scan_codes import CROCKFORD_ALPHABET, check_character, format_code, normalize_code, pick_match, scan_url, short_code_for
+
+CODE = short_code_for("wo_5f1c2a")
+
+def test_codes_are_stable_and_grouped():
+    assert short_code_for("wo_5f1c2a") == CODE
+    assert len(CODE) == 9 and set(CODE) <= set(CROCKFORD_ALPHABET)
+    assert format_code(CODE) == f"{CODE[:3]}-{CODE[3:6]}-{CODE[6:]}"
+
+@pytest.mark.parametrize("raw", [
+    CODE, format_code(CODE).lower(), f" {format_code(CODE)} ", scan_url(CODE), scan_url(CODE) + "/",
+])
+def test_typed_and_scanned_forms_normalize(raw):
+    assert normalize_code(raw) == CODE
+
+def test_lookalike_letters_read_as_digits():
+    code = "10ABC01Z" + check_character("10ABC01Z")
+    assert normalize_code("lO-abc-OIz" + code[-1]) == code
+
+def test_single_typos_and_swaps_are_caught():
+    for i in range(len(CODE)):
+        for char in CROCKFORD_ALPHABET:
+            if char != CODE[i]:
+                assert normalize_code(CODE[:i] + char + CODE[i + 1:]) is None
+    for i in range(len(CODE) - 1):
+        swapped = CODE[:i] + CODE[i + 1] + CODE[i] + CODE[i + 2:]
+        if swapped != CODE:
+            assert normalize_code(swapped) is None
+    assert normalize_code("HK-2045") is None
+
+def test_open_orders_win_a_shared_code():
+    closed = {"work_order_id": "wo_1", "status": "completed", "created_at": datetime(2026, 5, 2)}
+    open_order = {"work_order_id": "wo_2", "status": "pending", "created_at": datetime(2026, 5, 1)}
+    assert pick_match([closed, open_order])["work_order_id"] == "wo_2"
+    assert pick_match([]) is None
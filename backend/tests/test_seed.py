@@ -0,0 +1,39 @@
+from datetime import date, datetime, timezone
+
+from scripts.seed import build_dataset, room_numbers
+
+DAY = date(2026, 3, 14)
+END_OF_DAY = datetime(2026, 3, 15, tzinfo=timezone.utc)
+
+def test_same_seed_same_hotel():
+    first = build_dataset(40, 0.8, DAY, seed=3, now=END_OF_DAY)
+    assert first == build_dataset(40, 0.8, DAY, seed=3, now=END_OF_DAY)
+    assert first["work_orders"] != build_dataset(40, 0.8, DAY, seed=4, now=END_OF_DAY)["work_orders"]
+
+def test_scales_with_hotel_size():
+    small = build_dataset(40, 0.8, DAY, seed=1, now=END_OF_DAY)
+    large = build_dataset(400, 0.8, DAY, seed=1, now=END_OF_DAY)
+    assert len(small["rooms"]) == 40 and len(small["guest_profiles"]) == 32
+    assert len(large["work_orders"]) > 5 * len(small["work_orders"])
+    assert {s["department"] for s in small["staff_profiles"] if s["role"] == "staff"} == {
+        "housekeeping", "maintenance", "front_desk", "room_service", "it", "security", "concierge"}
+
+def test_work_orders_reference_their_guest_and_request():
+    data = build_dataset(150, 0.7, DAY, seed=2, now=END_OF_DAY)
+    guests = {g["guest_id"]: g for g in data["guest_profiles"]}
+    requests = {r["request_id"] for r in data["chat_requests"]}
+    for order in data["work_orders"]:
+        assert order["request_id"] in requests
+        assert order["location"] == guests[order["guest_id"]]["room_number"]
+        assert ("staff_id" in order) == (order["status"] != "pending")
+        if order["status"] == "completed":
+            assert order["created_at"] <= order["completed_at"] <= END_OF_DAY
+
+def test_only_generates_activity_up_to_now():
+    data = build_dataset(150, 0.8, DAY, seed=5, now=datetime(2026, 3, 14, 9, tzinfo=timezone.utc))
+    assert data["work_orders"]
+    assert all(o["created_at"].hour < 9 for o in data["work_orders"])
+
+def test_room_numbers_by_floor():
+    assert room_numbers(22)[:2] == ["101", "102"]
+    assert room_numbers(22)[-2:] == ["201", "202"]
@@ -0,0 +1,20 @@
+from shared.server import protocols, server_settings
+
+def test_tls_offers_h2_with_http1_fallback():
+    settings = server_settings("0.0.0.0", 8001, http2=True, certfile="cert.pem", keyfile="key.pem")
+    assert settings["bind"] == ["0.0.0.0:8001"]
+    assert settings["alpn_protocols"] == ["h2", "http/1.1"]
+    assert (settings["certfile"], settings["keyfile"]) == ("cert.pem", "key.pem")
+    assert protocols(True, "cert.pem", "key.pem") == "h2, http/1.1"
+
+def test_cleartext_serves_h2c():
+    settings = server_settings("0.0.0.0", 8002, http2=True, certfile="cert.pem", keyfile=None)
+    assert settings["certfile"] is None and settings["keyfile"] is None
+    assert protocols(True, None, None) == "h2c, http/1.1"
+
+def test_http2_can_be_switched_off():
+    assert server_settings("0.0.0.0", 8003, http2=False)["alpn_protocols"] == ["http/1.1"]
+    assert protocols(False, "cert.pem", "key.pem") == "http/1.1"
+
+def test_keep_alive_outlasts_the_load_balancer():
+    assert server_settings("0.0.0.0", 8001)["keep_alive_timeout"] > 60
@@ -0,0 +1,35 @@
+from shared.db.models import DepartmentEnum
+from shared.shadow_classifier import compare, parse_llm_reply
+
+def decision(request_id, primary, shadow, latency_ms=120.0):
+    return {"request_id": request_id, "agree": primary == shadow,
+            "primary": {"source": "clu", "department": primary, "confidence": 0.9},
+            "shadow": {"source": "llm", "department": shadow, "classifier": "llm", "latency_ms": latency_ms}}
+
+def test_llm_reply_parsing():
+    match = parse_llm_reply('{"department": "maintenance", "confidence": 0.82}')
+    assert (match.department, match.confidence, match.source) == (DepartmentEnum.MAINTENANCE, 0.82, "llm")
+    assert parse_llm_reply('```json\n{"department": "it", "confidence": 3}\n```').confidence == 1.0
+
+def test_unusable_llm_replies_are_no_decision():
+    for content in ("Housekeeping", '{"department": "spa"}', "[]", '{"department": null}'):
+        assert parse_llm_reply(content).department is None
+
+def test_compare_scores_both_against_the_final_department():
+    decisions = [
+        decision("r1", "housekeeping", "housekeeping"),
+        decision("r2", "housekeeping", "maintenance"),   # re-routed to maintenance: only the shadow was right
+        decision("r3", "it", "front_desk"),              # stayed in IT: only the live classifier was right
+        decision("r4", "concierge", "concierge"),        # no work order yet: not evaluated
+    ]
+    report = compare(decisions, {"r1": "housekeeping", "r2": "maintenance", "r3": "it"})
+    assert report["decisions"] == 4 and report["agreement"] == 0.5 and report["evaluated"] == 3
+    assert report["primary_accuracy"] == round(2 / 3, 4) and report["shadow_accuracy"] == round(2 / 3, 4)
+    assert (report["only_primary_right"], report["only_shadow_right"]) == (1, 1)
+    assert report["shadow_avg_latency_ms"] == 120.0
+    assert report["by_department"][1] == {"department": "it", "orders": 1, "primary_accuracy": 1.0,
+                                          "shadow_accuracy": 0.0}
+
+def test_compare_with_no_decisions():
+    report = compare([], {})
+    assert report["agreement"] is None and report["primary_accuracy"] is None
@@ -0,0 +1,36 @@
+import asyncio
+from datetime import datetime, timedelta, timezone
+
+import pytest
+from jose.exceptions import JWTError
+
+from shared.security import keys
+from shared.security.keys import JWT_TOKEN_TTL_HOURS, KeyRing, SigningKey, legacy_retirement
+
+FIRST = datetime(2026, 3, 1, 9, 0, tzinfo=timezone.utc)
+
+def key(kid: str, created_at: datetime) -> SigningKey:
+    return SigningKey(kid=kid, generation=int(kid[1:]), secret="s", created_at=created_at)
+
+def test_legacy_secret_retires_one_token_lifetime_after_the_first_key():
+    keys = [key("k2", FIRST + timedelta(days=30)), key("k1", FIRST)]
+    assert legacy_retirement(keys, configured=None) == FIRST + timedelta(hours=JWT_TOKEN_TTL_HOURS)
+
+def test_legacy_secret_stays_until_a_key_exists_unless_a_date_is_set():
+    assert legacy_retirement([], configured=None) is None
+    assert legacy_retirement([], configured="2026-06-30T00:00:00") == datetime(2026, 6, 30, tzinfo=timezone.utc)
+
+def test_a_token_bound_to_a_stay_stops_verifying_once_the_stay_ends(monkeypatch):
+    current = {"g1": True}
+
+    async def stay_is_current(guest_id, stay):
+        return current[guest_id]
+
+    monkeypatch.setattr(keys, "stay_is_current", stay_is_current)
+    ring = KeyRing(legacy_secret="legacy")
+    token = ring.encode({"sub": "g1", "role": "guest", "stay": "g1:2026-10-18"})
+    assert asyncio.run(ring.verify(token))["sub"] == "g1"
+    current["g1"] = False
+    # verify_jwt answers this with 401 and the WebSockets close with 4401
+    with pytest.raises(JWTError):
+        asyncio.run(ring.verify(token))
@@ -0,0 +1,39 @@
+import pytest
+
+from shared.subtasks import SubtaskError, check_can_move, resolve_dependencies, rollup_status
+
+@pytest.mark.parametrize("statuses,expected", [
+    ([], "pending"),
+    (["pending", "assigned"], "pending"),
+    (["assigned", "assigned"], "assigned"),
+    (["completed", "pending"], "in_progress"),
+    (["completed", "cancelled"], "completed"),
+    (["cancelled", "cancelled"], "cancelled"),
+    (["on_hold", "cancelled"], "on_hold"),
+])
+def test_rolls_up_parent_status(statuses, expected):
+    assert rollup_status(statuses) == expected
+
+def test_resolves_batch_keys_and_existing_siblings():
+    tasks = [{"key": "clean"}, {"key": "setup", "depends_on": ["clean", "wo_1.1"]}, {"depends_on": ["setup"]}]
+    resolved = resolve_dependencies(tasks, ["wo_1.2", "wo_1.3", "wo_1.4"], ["wo_1.1"])
+    assert resolved == [[], ["wo_1.2", "wo_1.1"], ["wo_1.3"]]
+
+@pytest.mark.parametrize("tasks", [
+    [{"key": "a", "depends_on": ["b"]}, {"key": "b", "depends_on": ["a"]}],
+    [{"key": "a", "depends_on": ["missing"]}],
+    [{"key": "a"}, {"key": "a"}],
+])
+def test_rejects_bad_dependencies(tasks):
+    with pytest.raises(SubtaskError):
+        resolve_dependencies(tasks, [f"wo_1.{i}" for i in range(len(tasks))], [])
+
+def test_dependencies_gate_start_and_completion():
+    siblings = {"wo_1.1": {"work_order_id": "wo_1.1", "status": "in_progress"}}
+    child = {"work_order_id": "wo_1.2", "depends_on": ["wo_1.1"], "status": "pending"}
+    check_can_move(child, "assigned", siblings)
+    with pytest.raises(SubtaskError) as exc:
+        check_can_move(child, "in_progress", siblings)
+    assert exc.value.status_code == 409
+    siblings["wo_1.1"]["status"] = "completed"
+    check_can_move(child, "completed", siblings)
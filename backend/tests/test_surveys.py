@@ -0,0 +1,28 @@
+from datetime import datetime
+
+from shared.surveys import classify, nps, nps_summary, period_start
+
+def test_nps_is_promoters_minus_detractors():
+    assert [classify(s) for s in (10, 9, 8, 7, 6, 0)] == ["promoter", "promoter", "passive", "passive", "detractor", "detractor"]
+    assert nps([10, 9, 8, 3]) == {"nps": 25.0, "responses": 4, "promoters": 2, "passives": 1, "detractors": 1}
+    assert nps([])["nps"] is None
+
+def test_periods_start_on_monday_or_the_first():
+    moment = datetime(2026, 5, 14, 18, 30)  # a Thursday
+    assert period_start(moment, "day") == "2026-05-14"
+    assert period_start(moment, "week") == "2026-05-11"
+    assert period_start(moment, "month") == "2026-05-01"
+
+def test_departments_use_their_own_score_and_only_count_stays_they_served():
+    responses = [
+        {"score": 9, "departments": ["housekeeping", "room_service"], "department_scores": {"room_service": 4},
+         "answered_at": datetime(2026, 5, 4, 10)},
+        {"score": 10, "departments": ["housekeeping"], "department_scores": {}, "answered_at": datetime(2026, 5, 12, 9)},
+        {"score": 5, "departments": [], "department_scores": {}, "answered_at": datetime(2026, 5, 13, 9)},
+    ]
+    summary = nps_summary(responses)
+    assert summary["overall"]["responses"] == 3 and summary["overall"]["nps"] == 33.3
+    assert summary["departments"]["housekeeping"]["nps"] == 100.0
+    assert summary["departments"]["room_service"] == {"nps": -100.0, "responses": 1, "promoters": 0, "passives": 0,
+                                                     "detractors": 1}
+    assert [(t["period"], t["responses"]) for t in summary["trend"]] == [("2026-05-04", 1), ("2026-05-11", 2)]
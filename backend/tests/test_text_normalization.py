@@ -0,0 +1,22 @@
+import pytest
+
+from shared.text_normalization import expand_shorthand, normalize_message, replace_emoji
+
+@pytest.mark.parametrize("text,normalized", [
+    ("🧻 please", "toilet paper please"),
+    ("AC 🥵", "AC ac too hot"),
+    ("📶 down again 😩", "wifi down again 😩"),
+    ("🥵🥵🥵", "ac too hot"),
+    ("need 🧼🏽 pls", "need soap please"),
+    ("2 towels 2nite plz", "2 towels tonight please"),
+    ("can u send hk to my rm tmrw", "can you send housekeeping to my room tomorrow"),
+    ("the menu is firm", "the menu is firm"),
+])
+def test_messages_are_spelled_out(text, normalized):
+    assert normalize_message(text) == normalized
+
+def test_variation_selector_is_ignored():
+    assert replace_emoji("❄️").strip() == "ac"
+
+def test_hotel_shorthand_extends_the_builtin_list():
+    assert expand_shorthand("HK pls, and a DNS", {"dns": "do not disturb"}) == "housekeeping please, and a do not disturb"
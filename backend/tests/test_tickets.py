@@ -0,0 +1,36 @@
+from datetime import datetime, timezone
+
+from shared.tickets import should_auto_print, ticket_code, ticket_lines
+
+ORDER = {
+    "work_order_id": "wo_1", "order_number": "HK-2045", "department": "housekeeping", "priority": "high",
+    "description": "Deep clean after late checkout, the balcony too please",
+    "created_at": datetime(2026, 5, 1, 9, 30, tzinfo=timezone.utc),
+    "metadata": {"room_number": "1204"},
+    "line_items": [{"item": "towels", "quantity": 3}, {"item": "slippers", "quantity": None}],
+    "checklist": {"name": "Deep clean", "steps": [
+        {"step_id": "step_1", "title": "Strip beds", "required": True},
+        {"step_id": "step_2", "title": "Descale shower", "required": False},
+    ]},
+}
+
+def test_ticket_lists_room_request_items_and_steps():
+    lines = ticket_lines(ORDER, timezone.utc)
+    assert lines[0] == ("heading", "Housekeeping - HIGH priority")
+    assert ("text", "Room 1204") in lines
+    assert ("text", "Requested 01/05 09:30") in lines
+    assert ("text", "- 3 x towels") in lines and ("text", "- slippers") in lines
+    assert [text for style, text in lines if style == "box"] == ["Strip beds", "Descale shower (optional)"]
+
+def test_off_site_orders_show_the_area():
+    order = {**ORDER, "metadata": {"location": {"area_name": "Main beach"}}, "checklist": None}
+    assert ("text", "Main beach") in ticket_lines(order, timezone.utc)
+
+def test_barcode_falls_back_to_the_id():
+    assert ticket_code(ORDER) == "HK-2045"
+    assert ticket_code({**ORDER, "order_number": None}) == "wo_1"
+
+def test_auto_print_departments():
+    assert should_auto_print(ORDER, set())
+    assert should_auto_print(ORDER, {"housekeeping", "maintenance"})
+    assert not should_auto_print(ORDER, {"maintenance"})
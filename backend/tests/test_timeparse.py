@@ -0,0 +1,79 @@
+from datetime import datetime
+from zoneinfo import ZoneInfo
+
+import pytest
+
+from shared.timeparse import parse_requested_time, pick_time, resolve_time, spoken_times
+
+TZ = ZoneInfo("Europe/London")
+NOW = datetime(2025, 7, 22, 10, 0, tzinfo=TZ)
+
+@pytest.mark.parametrize("message,expected", [
+    ("wake-up call at 6am", datetime(2025, 7, 23, 6, 0, tzinfo=TZ)),
+    ("extra pillows tonight", datetime(2025, 7, 22, 20, 0, tzinfo=TZ)),
+    ("more coffee in 2 hours", datetime(2025, 7, 22, 12, 0, tzinfo=TZ)),
+    ("taxi tomorrow at 7:30", datetime(2025, 7, 23, 7, 30, tzinfo=TZ)),
+    ("bring tea at 7", datetime(2025, 7, 22, 19, 0, tzinfo=TZ)),
+    ("table at 5 p.m. please", datetime(2025, 7, 22, 17, 0, tzinfo=TZ)),
+    ("send towels at half past six", datetime(2025, 7, 22, 18, 30, tzinfo=TZ)),
+    ("taxi at a quarter to eight tomorrow morning", datetime(2025, 7, 23, 7, 45, tzinfo=TZ)),
+    ("laundry pickup on friday at 9am", datetime(2025, 7, 25, 9, 0, tzinfo=TZ)),
+    ("turndown next tuesday evening", datetime(2025, 7, 29, 18, 0, tzinfo=TZ)),
+    ("breakfast the day after tomorrow at 07:30", datetime(2025, 7, 24, 7, 30, tzinfo=TZ)),
+    ("bring water at 0:30", datetime(2025, 7, 23, 0, 30, tzinfo=TZ)),
+    ("bring ice at 00:15", datetime(2025, 7, 23, 0, 15, tzinfo=TZ)),
+])
+def test_parses_future_times(message, expected):
+    assert parse_requested_time(message, NOW, TZ) == expected
+
+@pytest.mark.parametrize("message", [
+    "2 towels please",
+    "the AC in room 512 is broken",
+    "the shower has been leaking since 6am",
+    "dinner for 4",
+])
+def test_ignores_messages_without_a_requested_time(message):
+    assert parse_requested_time(message, NOW, TZ) is None
+
+@pytest.mark.parametrize("message", [
+    "the shower broke at 2pm",
+    "the heating broke this afternoon",
+    "AC not working since noon",
+    "there was a leak at 9am, still dripping",
+])
+def test_fault_reports_are_never_scheduled(message):
+    afternoon = datetime(2025, 7, 22, 16, 0, tzinfo=TZ)
+    assert resolve_time(message, afternoon, TZ) is None
+    assert resolve_time(message, afternoon, TZ, explicit=True) is None
+
+def test_a_bare_time_needs_a_request_unless_the_caller_knows():
+    assert parse_requested_time("at 7", NOW, TZ) is None
+    assert parse_requested_time("at 7", NOW, TZ, explicit=True) == datetime(2025, 7, 22, 19, 0, tzinfo=TZ)
+    # A report that also asks for later is still scheduled
+    assert parse_requested_time("there was a leak, send someone tomorrow at 9", NOW, TZ) == \
+        datetime(2025, 7, 23, 9, 0, tzinfo=TZ)
+
+def test_spoken_times_become_clock_times():
+    assert spoken_times("wake me at half past six tomorrow") == "wake me at 6:30 tomorrow"
+    assert spoken_times("coffee at seven thirty") == "coffee at 7:30"
+    assert spoken_times("leaking since half past six") == "leaking since half past six"
+
+def test_ambiguous_clock_time_offers_both_readings():
+    early = datetime(2025, 7, 22, 3, 0, tzinfo=TZ)
+    resolution = resolve_time("send towels at 5", early, TZ)
+    assert resolution.time == datetime(2025, 7, 22, 5, 0, tzinfo=TZ)
+    assert resolution.alternatives == (datetime(2025, 7, 22, 17, 0, tzinfo=TZ),)
+    # Only one reading is still ahead at 10:00
+    assert resolve_time("send towels at 5", NOW, TZ).alternatives == ()
+    assert resolve_time("send towels at 5pm", early, TZ).alternatives == ()
+
+def test_wake_up_times_prefer_the_morning():
+    resolution = resolve_time("wake me at half past six tomorrow", NOW, TZ, prefer_morning=True)
+    assert resolution == (datetime(2025, 7, 23, 6, 30, tzinfo=TZ), ())
+
+@pytest.mark.parametrize("answer,expected", [
+    ("18:30", 1), ("6:30", 0), ("6:30 pm", 1), ("the evening", 1), ("morning please", 0), ("yes", None),
+])
+def test_pick_time_from_answer(answer, expected):
+    options = [datetime(2025, 7, 23, 6, 30, tzinfo=TZ), datetime(2025, 7, 23, 18, 30, tzinfo=TZ)]
+    assert pick_time(answer, options, TZ) == (options[expected] if expected is not None else None)
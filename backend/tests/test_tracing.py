@@ -0,0 +1,30 @@
+import pytest
+
+from shared.tracing import clean_request_id, current_request_id, from_message_properties, trace_headers
+
+@pytest.mark.parametrize("value,expected", [
+    ("3f2a9c", "3f2a9c"),
+    (b"req-42.retry:1", "req-42.retry:1"),
+    ("  padded  ", "padded"),
+    ("", None),
+    ("has space", None),
+    ("line\r\nbreak", None),
+    ("x" * 129, None),
+    (None, None),
+])
+def test_only_safe_ids_are_propagated(value, expected):
+    assert clean_request_id(value) == expected
+
+def test_reads_ids_from_message_properties():
+    assert from_message_properties({"trace_id": "abc"}) == "abc"
+    assert from_message_properties({b"trace_id": b"abc"}) == "abc"
+    assert from_message_properties(None) is None
+
+def test_outbound_headers_prefer_the_current_request():
+    assert trace_headers() == {}
+    assert trace_headers("stored") == {"X-Request-ID": "stored"}
+    token = current_request_id.set("live")
+    try:
+        assert trace_headers("stored") == {"X-Request-ID": "live"}
+    finally:
+        current_request_id.reset(token)
@@ -0,0 +1,16 @@
+import pytest
+
+from shared.db.models import RetentionPolicy
+from shared.transcripts import RetentionError, pseudonymize, validate_policy
+
+def test_pseudonyms_are_stable_and_distinct():
+    assert pseudonymize("guest_1") == pseudonymize("guest_1")
+    assert pseudonymize("guest_1") != pseudonymize("guest_2")
+    assert pseudonymize("guest_1").startswith("anon_") and "guest_1" not in pseudonymize("guest_1")
+
+def test_ttl_strategy_requires_delete_mode():
+    validate_policy(RetentionPolicy(hotel_id="h", retention_days=30, mode="delete", strategy="ttl"))
+    validate_policy(RetentionPolicy(hotel_id="h", retention_days=30, mode="anonymize", strategy="scheduled"))
+    with pytest.raises(RetentionError) as exc:
+        validate_policy(RetentionPolicy(hotel_id="h", retention_days=30, mode="anonymize", strategy="ttl"))
+    assert exc.value.status_code == 422
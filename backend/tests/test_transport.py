@@ -0,0 +1,26 @@
+import pytest
+
+from shared.db.models import StatusEnum, TransportStatusEnum
+from shared.transport import TRANSPORT_STATUS_FOR, WORK_ORDER_STATUS_FOR, detect_transport
+
+@pytest.mark.parametrize("message,expected", [
+    ("Can you book me a taxi to the airport at 6am?", {"mode": "taxi", "destination": "airport", "passengers": 1}),
+    ("I need the airport shuttle tomorrow at 7", {"mode": "shuttle", "destination": "airport", "passengers": 1}),
+    ("book a taxi to Gare du Nord for 3 people", {"mode": "taxi", "destination": "Gare du Nord", "passengers": 3}),
+    ("call me a cab", {"mode": "taxi", "destination": None, "passengers": 1}),
+    ("is there a shuttle?", None),
+    ("the taxi driver was rude", None),
+])
+def test_detects_transport_requests(message, expected):
+    assert detect_transport(message) == expected
+
+def test_the_ride_and_its_work_order_end_together():
+    for status in TransportStatusEnum:
+        if status == TransportStatusEnum.REQUESTED:
+            assert status not in WORK_ORDER_STATUS_FOR
+            continue
+        order_status = WORK_ORDER_STATUS_FOR[status.value]
+        if order_status in TRANSPORT_STATUS_FOR:
+            # Mirroring back lands on the same end state (failed rides show as cancelled orders)
+            assert WORK_ORDER_STATUS_FOR[TRANSPORT_STATUS_FOR[order_status]] == order_status
+    assert TRANSPORT_STATUS_FOR.get(StatusEnum.IN_PROGRESS.value) is None
@@ -0,0 +1,39 @@
+from shared.db.models import GuestLocation
+from shared.venue_map import GeoPoint, ServiceHub, VenueArea, area_at, locate, nearest_hub, shared_location
+
+BEACH = VenueArea(area_id="beach", name="Main beach", kind="beach", boundary=[
+    GeoPoint(latitude=-4.2900, longitude=39.5930), GeoPoint(latitude=-4.2900, longitude=39.5960),
+    GeoPoint(latitude=-4.2920, longitude=39.5960), GeoPoint(latitude=-4.2920, longitude=39.5930),
+])
+VILLAS = VenueArea(area_id="villas", name="Garden villas", boundary=[
+    GeoPoint(latitude=-4.2850, longitude=39.5900), GeoPoint(latitude=-4.2850, longitude=39.5920),
+    GeoPoint(latitude=-4.2870, longitude=39.5910),
+])
+BEACH_HUT = ServiceHub(hub_id="beach-hut", name="Beach hut", latitude=-4.2925, longitude=39.5980,
+                       departments=["room_service"], areas=["beach"])
+MAIN_KITCHEN = ServiceHub(hub_id="kitchen", name="Main kitchen", latitude=-4.2910, longitude=39.5925,
+                          departments=["room_service", "housekeeping"])
+
+def test_consent_is_required_to_keep_a_location():
+    assert shared_location(GuestLocation(latitude=-4.29, longitude=39.59)) is None
+    assert shared_location(None) is None
+    kept = shared_location(GuestLocation(latitude=-4.2910123456, longitude=39.5945678, consent=True))
+    assert kept == {"latitude": -4.29101, "longitude": 39.59457, "accuracy_m": None}
+
+def test_points_fall_in_their_area():
+    assert area_at([VILLAS, BEACH], -4.2910, 39.5945).area_id == "beach"
+    assert area_at([VILLAS, BEACH], -4.2855, 39.5910).area_id == "villas"
+    assert area_at([VILLAS, BEACH], -4.3000, 39.6000) is None
+
+def test_hub_covering_the_area_wins_over_a_nearer_one():
+    hub, _ = nearest_hub([BEACH_HUT, MAIN_KITCHEN], "room_service", -4.2910, 39.5931, "beach")
+    assert hub.hub_id == "beach-hut"
+    hub, _ = nearest_hub([BEACH_HUT, MAIN_KITCHEN], "room_service", -4.2860, 39.5910, "villas")
+    assert hub.hub_id == "kitchen"
+    assert nearest_hub([BEACH_HUT, MAIN_KITCHEN], "maintenance", -4.2860, 39.5910) is None
+
+def test_locate_names_area_and_hub():
+    located = locate({"latitude": -4.2910, "longitude": 39.5945, "accuracy_m": 8.0}, "housekeeping",
+                     [BEACH], [BEACH_HUT, MAIN_KITCHEN])
+    assert located["area_name"] == "Main beach"
+    assert located["hub_id"] == "kitchen" and located["hub_distance_m"] > 0
@@ -0,0 +1,17 @@
+import pytest
+
+from shared.wakeup import detect_wake_up_command
+
+@pytest.mark.parametrize("message,expected", [
+    ("Can I get a wake-up call at 6am?", "schedule"),
+    ("please wake me up tomorrow at 7:30", "schedule"),
+    ("cancel my wake up call", "cancel"),
+    ("I no longer need the wake-up call", "cancel"),
+    ("I'm awake!", "confirm"),
+    ("ok, I am up", "confirm"),
+    ("je suis réveillée", "confirm"),
+    ("I'm up on the 5th floor and the AC is broken", None),
+    ("2 towels please", None),
+])
+def test_detects_wake_up_commands(message, expected):
+    assert detect_wake_up_command(message) == expected
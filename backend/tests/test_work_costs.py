@@ -0,0 +1,37 @@
+from datetime import datetime, timedelta, timezone
+
+import pytest
+
+from shared.work_costs import (WorkCostError, cost_entry, department_costs, labor_minutes, material_cost,
+                               start_timer, stop_all, stop_timer)
+
+NOW = datetime(2026, 5, 1, 10, 0, tzinfo=timezone.utc)
+
+def test_timer_runs_once_per_staff_member():
+    labor = [start_timer([], "staff_1", NOW)]
+    with pytest.raises(WorkCostError):
+        start_timer(labor, "staff_1", NOW)
+    labor.append(start_timer(labor, "staff_2", NOW))
+    labor = stop_timer(labor, "staff_1", NOW + timedelta(minutes=25))
+    assert labor[0]["minutes"] == 25.0 and labor[1]["stopped_at"] is None
+    with pytest.raises(WorkCostError):
+        stop_timer(labor, "staff_1", NOW + timedelta(minutes=30))
+
+def test_closing_the_order_stops_running_timers():
+    labor = stop_timer([start_timer([], "staff_1", NOW)], "staff_1", NOW + timedelta(minutes=10))
+    labor.append(start_timer(labor, "staff_2", NOW + timedelta(minutes=5)))
+    labor = stop_all(labor, NOW + timedelta(minutes=20))
+    assert labor_minutes(labor) == 25.0
+
+def test_costs_are_totalled():
+    costs = [cost_entry("Cartridge", 2, 12.5, "staff_1", NOW), cost_entry("Sealant", 1, 3.99, "staff_1", NOW)]
+    assert costs[0]["total"] == 25.0
+    assert material_cost(costs) == 28.99
+
+def test_department_costs_average_over_completed_orders():
+    report = department_costs({"_id": "maintenance", "orders": 3, "completed": 2, "labor_minutes": 150,
+                               "material_cost": 40})
+    assert report["labor_hours"] == 2.5
+    assert report["labor_minutes_per_completed"] == 75.0 and report["material_cost_per_completed"] == 20.0
+    assert department_costs({"_id": "it", "orders": 1, "completed": 0, "labor_minutes": 0,
+                             "material_cost": 0})["material_cost_per_completed"] is None
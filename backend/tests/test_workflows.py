@@ -0,0 +1,55 @@
+from datetime import datetime, timedelta, timezone
+
+import pytest
+
+from shared.db.models import StatusEnum
+from shared.workflows import (WorkflowError, detect_workflow, start_workflow, advance_workflow, status_for_step,
+                              workflow_progress)
+
+NOW = datetime(2025, 7, 22, 12, 0, tzinfo=timezone.utc)
+
+@pytest.mark.parametrize("message,expected", [
+    ("Can you bring my car round?", "valet"),
+    ("valet please, leaving in 10 minutes", "valet"),
+    ("please pick up my luggage from room 512", "luggage"),
+    ("can you store our bags until 6pm", "luggage"),
+    ("my luggage hasn't arrived", None),
+    ("could a porter take our suitcases down?", "luggage"),
+    ("our bags need to be picked up at 11", "luggage"),
+    ("someone stole my bags, can you help me collect the CCTV?", None),
+    ("I lost my suitcase, can you keep an eye out", None),
+    ("my shopping bags are in the household cupboard", None),
+    ("2 towels please", None),
+])
+def test_detects_workflows(message, expected):
+    assert detect_workflow(message) == expected
+
+def test_valet_steps_advance_in_order_with_timers():
+    state = start_workflow("valet", NOW)
+    assert state["due_at"] == NOW + timedelta(minutes=5)
+    assert status_for_step("valet", state["step"]) == StatusEnum.PENDING
+
+    state = advance_workflow(state, "retrieving", NOW + timedelta(minutes=2), actor="staff_1")
+    assert status_for_step("valet", state["step"]) == StatusEnum.IN_PROGRESS
+    assert state["due_at"] == NOW + timedelta(minutes=17)
+
+    state = advance_workflow(state, "at_door", NOW + timedelta(minutes=10))
+    state = advance_workflow(state, "handed_over", NOW + timedelta(minutes=12))
+    assert status_for_step("valet", state["step"]) == StatusEnum.COMPLETED
+    assert state["due_at"] is None
+    assert [h["step"] for h in state["history"]] == ["requested", "retrieving", "at_door", "handed_over"]
+
+def test_steps_cannot_be_skipped_or_repeated():
+    state = start_workflow("luggage", NOW)
+    with pytest.raises(WorkflowError):
+        advance_workflow(state, "stored", NOW)
+    for step in ("picked_up", "stored", "delivered"):
+        state = advance_workflow(state, step, NOW)
+    with pytest.raises(WorkflowError):
+        advance_workflow(state, "delivered", NOW)
+
+def test_progress_shows_reached_steps():
+    state = advance_workflow(start_workflow("luggage", NOW), "picked_up", NOW + timedelta(minutes=5))
+    progress = workflow_progress(state)
+    assert (progress["step_index"], progress["total_steps"]) == (2, 4)
+    assert [s["reached_at"] is not None for s in progress["steps"]] == [True, True, False, False]
@@ -0,0 +1,47 @@
+from datetime import datetime, timedelta, timezone
+
+from shared.zones import Zone, floor_of, nearest_attendant, zone_distance, zone_for_room
+
+NOW = datetime(2026, 5, 1, 10, 0, tzinfo=timezone.utc)
+ZONES = [
+    Zone(zone_id="f3-east", name="3rd floor east", floor=3, wing="east", rooms=["301", "302"]),
+    Zone(zone_id="f3-west", name="3rd floor west", floor=3, wing="west"),
+    Zone(zone_id="f5", name="5th floor", floor=5),
+]
+BY_ID = {z.zone_id: z for z in ZONES}
+
+def attendant(staff_id, zone_id=None, on_shift=True, minutes_ago=5):
+    return {"staff_id": staff_id, "on_shift": on_shift, "current_zone": zone_id,
+            "zone_updated_at": NOW - timedelta(minutes=minutes_ago) if zone_id else None}
+
+def test_rooms_are_placed_by_list_then_by_floor():
+    assert floor_of("1204") == 12 and floor_of("305b") == 3 and floor_of("PH") is None
+    assert zone_for_room(ZONES, "302").zone_id == "f3-east"
+    assert zone_for_room(ZONES, "340").zone_id == "f3-west"
+    assert zone_for_room(ZONES, "512").zone_id == "f5"
+    assert zone_for_room(ZONES, "901") is None
+
+def test_a_floor_change_costs_more_than_the_other_wing():
+    assert zone_distance(BY_ID["f3-east"], BY_ID["f3-east"]) == 0
+    assert zone_distance(BY_ID["f3-east"], BY_ID["f3-west"]) < zone_distance(BY_ID["f3-east"], BY_ID["f5"])
+
+def test_nearest_on_shift_attendant_wins_then_the_least_busy():
+    staff = [attendant("far", "f5"), attendant("wing", "f3-west"), attendant("off", "f3-east", on_shift=False)]
+    assert nearest_attendant(staff, BY_ID["f3-east"], BY_ID, {}, NOW) == "wing"
+
+    staff.append(attendant("busy", "f3-east"))
+    staff.append(attendant("free", "f3-east"))
+    assert nearest_attendant(staff, BY_ID["f3-east"], BY_ID, {"busy": 3, "free": 1}, NOW) == "free"
+
+def test_stale_or_missing_locations_rank_last_but_still_count():
+    staff = [attendant("stale", "f3-east", minutes_ago=90), attendant("far", "f5")]
+    assert nearest_attendant(staff, BY_ID["f3-east"], BY_ID, {}, NOW) == "far"
+    assert nearest_attendant([attendant("unknown")], BY_ID["f3-east"], BY_ID, {}, NOW) == "unknown"
+    assert nearest_attendant([attendant("off", "f3-east", on_shift=False)], BY_ID["f3-east"], BY_ID, {}, NOW) is None
+
+def test_attendants_whose_app_went_quiet_are_skipped():
+    quiet = {**attendant("quiet", "f3-east"), "last_seen_at": NOW - timedelta(minutes=10)}
+    signed_out = {**attendant("signed_out", "f3-east"), "last_seen_at": None}
+    live = {**attendant("live", "f5"), "last_seen_at": NOW - timedelta(seconds=20)}
+    assert nearest_attendant([quiet, signed_out, live], BY_ID["f3-east"], BY_ID, {}, NOW) == "live"
+    assert nearest_attendant([quiet, signed_out], BY_ID["f3-east"], BY_ID, {}, NOW) is None
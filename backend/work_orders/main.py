@@ -1,35 +1,178 @@
-from fastapi import FastAPI, HTTPException, Depends, status, Body, Path, Query
+from fastapi import FastAPI, HTTPException, Depends, status, Body, Path, Query, UploadFile, File, Form, Request, Header, Response
 from fastapi.middleware.cors import CORSMiddleware
+from fastapi.responses import PlainTextResponse, StreamingResponse
 from fastapi.security import HTTPBearer, HTTPAuthorizationCredentials
 from fastapi_limiter import FastAPILimiter
 from fastapi_limiter.depends import RateLimiter
 from pydantic import BaseModel, Field
-from typing import List, Optional, Dict, Any
+from typing import Annotated, List, Optional, Dict, Any
 from datetime import datetime, timedelta, timezone
 from shared.db.database import DatabaseConnection
-from shared.db.models import WorkOrder, StatusEnum, DepartmentEnum, PriorityEnum
+from shared.db.models import (WorkOrder, StatusEnum, DepartmentEnum, PriorityEnum, Asset,
+                              MaintenanceDetails, FaultCodeEnum, PartUsage, CustomFieldDefinition,
+                              WakeUpCall, WakeUpCallStatusEnum, WorkflowTypeEnum, Incident, IncidentStatusEnum,
+                              IncidentTypeEnum, MaintenanceSchedule, LineItem, CompletionProof,
+                              Checklist, ChecklistTemplate)
+from shared.blob_storage import upload_blob, generate_signed_url, BlobStorageError
+from shared.completion_proof import (CompletionProofError, COMPLETION_PROOF_URL_TTL_MINUTES, check_completion,
+                                     guest_view)
+from shared.work_costs import (WorkCostError, start_timer, stop_timer, stop_all, labor_minutes, cost_entry,
+                               material_cost, cost_report)
+from shared.checklists import (ChecklistError, check_completion as check_checklist_completion, checklist_for,
+                               deactivate_template, instantiate, list_templates, save_template, tick,
+                               ensure_checklist_indexes)
+from shared.chat_edits import RECALLED_TAG, current_request
+from shared.dnd import DND_HOLD_REASON, is_room_dnd, set_room_dnd, should_hold_for_dnd, release_dnd_holds
+from shared import fault_injection
+from shared.errors import ApiError, install_error_handlers, error_response
+from shared.concurrency import etag, expected_version, version_filter, versioned
+from shared.conditional import conditional_response
+from shared.draining import drain, DRAIN_RETRY_AFTER_SECONDS
+from shared.runtime_config import RuntimeConfigError, runtime_config
+from shared.clock import format_local, hotel_timezone, resolve_timezone
+from shared.feature_flags import feature_flags
+from shared.event_store import (EventStoreError, WORK_ORDER_PERSISTENCE, install as install_event_store, load_stream,
+                                state_as_of, rebuild_projections, ensure_event_store_indexes)
+from shared import metrics
+from shared.security.keys import KeyRing, SigningKeyError, list_keys, rotate_key, revoke_key, ensure_key_indexes
+from shared.security.oidc import (OidcVerifier, OidcProvider, OidcError, get_provider, list_providers, save_provider,
+                                  delete_provider, ensure_oidc_indexes)
+from shared.security.api_keys import (ApiKeyRing, Authenticator, ApiKeyError, INTEGRATION_ROLE, issue_api_key,
+                                      list_api_keys, rotate_api_key, revoke_api_key, key_usage, ensure_api_key_indexes)
+from shared.security.field_crypto import (ENCRYPTED_FIELDS, field_cipher, list_field_keys, rotate_field_key,
+                                          rewrap_field_keys, reencrypt_collection)
+from shared.security.policy import (ensure_can_read_work_order, work_order_read_filter, can_read_work_order,
+                                    resolve_guest_id)
+from shared.middleware import RequestIdMiddleware, TimeoutMiddleware, RecoveryMiddleware, CompressionMiddleware, REQUEST_TIMEOUT_SECONDS
+from shared.tracing import current_request_id, from_message_properties
+from shared.http_client import ResilientClient
+from shared.capacity import CapacityMonitor
+from shared.read_models import (ReadModelProjector, department_dashboard, sla_timers, leaderboard, rebuild_read_models,
+                                ensure_read_model_indexes)
+from shared.order_numbers import is_order_number, normalize_order_number, next_order_number, work_order_id_for
+from shared.zones import (ZoneError, Zone, StaffLocationUpdate, list_zones, save_zone, delete_zone,
+                          update_staff_location, pick_attendant, ensure_zone_indexes, OPEN_ASSIGNED_STATUSES)
+from shared.scan_codes import (find_by_code, format_code, normalize_code, qr_svg, scan_url, short_code_for,
+                               ensure_scan_code_indexes)
+from shared.tickets import PRINT_SERVER_URL, render_ticket, should_auto_print, ticket_code
+from shared.venue_map import (VenueMapError, VenueArea, ServiceHub, list_areas, save_area, delete_area, list_hubs,
+                              save_hub, delete_hub, locate_request, ensure_venue_indexes)
+from shared.presence import (PresenceError, Heartbeat, STAFF_HEARTBEAT_SECONDS, STAFF_PRESENCE_CHECK_SECONDS,
+                             STAFF_REASSIGN_AFTER_MINUTES, record_heartbeat, end_session, list_presence,
+                             offline_staff, ensure_presence_indexes)
+from shared.leases import REPLICA_ID, Lease, ensure_lease_indexes, list_leases, release_held_leases
+from shared.sandbox import install as install_sandbox, purge_synthetic, sandbox_purge_loop, suppress_staff_alert
+from shared.migrations.runner import MigrationError, migrate, migrate_on_startup, migration_status
+from shared.notifier import ChangeNotifier, EventBus
+from shared.quotas import check_open_order_quota
+from shared.sentiment import priority_for_sentiment
+from shared.transport import link_work_order, sync_from_work_order
+from shared.export import (ExportError, EXPORT_MEDIA_TYPES, resolve_timezone, parse_bound, select_columns,
+                           stream_csv, stream_xlsx, export_filename)
+from shared.custom_fields import (CustomFieldError, normalize_tags, list_field_definitions, validate_custom_fields,
+                                  custom_field_filter, save_field_definition, deactivate_field_definition)
+from shared.contracts import ChatRequestMessage, WorkOrderStatusEvent
+from shared.events import (EventPublisher, WorkOrderCreated, StatusChanged, WorkOrderAssigned, SLABreached,
+                           IncidentOpened, event_catalog)
+from shared.reporting import sla_minutes
+from shared.groups import (GROUP_DIGEST_SECONDS, BulkRequest, EventGroup, EventGroupUpdate, GroupError, can_manage,
+                           digest_text, ensure_group_indexes, get_group, group_orders, group_tags, item_description,
+                           list_groups, mark_changed, save_group, summarize, take_pending_digest)
+from shared.loyalty import boost_priority, guest_tier, queue_key, set_guest_tier, shortest_sla_minutes, tier_sla_minutes
+from shared.business_hours import (BusinessHoursError, BusinessCalendar, business_calendars, business_minutes_between,
+                                   is_open, next_open, list_calendars, save_calendar, delete_calendar,
+                                   ensure_business_hours_indexes)
+from shared.workflows import (WorkflowError, start_workflow, advance_workflow, status_for_step,
+                              workflow_progress)
+from shared.devices import DeviceCommand, room_devices, set_room_devices, actuate
+from shared.wakeup import (WakeUpCallError, schedule_wake_up_call, list_wake_up_calls, get_wake_up_call,
+                           cancel_wake_up_calls, confirm_wake_up_call, claim_due_call, claim_missed_call,
+                           mark_escalated, ring_room, ensure_wake_up_indexes)
+from shared.incidents import (IncidentError, open_incident, get_incident, list_incidents, update_incident_status,
+                              add_incident_note, claim_unacknowledged, send_incident_alerts, ensure_incident_indexes)
+from shared.subtasks import (SubtaskError, DONE_STATUSES, resolve_dependencies, check_can_move, parent_changes,
+                             blocked_by)
+from shared.preventive_maintenance import (MaintenanceScheduleError, PM_GUEST_ID, create_schedule, get_schedule,
+                                           list_schedules, update_schedule, delete_schedule, claim_due_schedule,
+                                           set_open_work_order, release_schedule, record_completion, pm_status,
+                                           ensure_pm_indexes)
+from shared.routing_rules import (RoutingRules, RoutingRule, RoutingRuleSet, RuleSetModeEnum, RuleSetError,
+                                  rules_from_keywords, list_rulesets, create_ruleset, start_rollout,
+                                  promote_ruleset, rollback_ruleset)
+from shared.routing_feedback import (record_correction, list_corrections, misrouting_report, phrase_rules, clu_utterances,
+                                     mark_used, ensure_routing_feedback_indexes)
 from jose import jwt, JWTError
+from azure.servicebus.aio import ServiceBusClient
+from azure.servicebus import NEXT_AVAILABLE_SESSION, ServiceBusMessage, ServiceBusSubQueue
+from azure.servicebus.exceptions import OperationTimeoutError
+from pymongo.errors import DuplicateKeyError, OperationFailure
 import asyncio
 import structlog
 import os
 import re
-import httpx
+import json
+import uuid
 
 # --- Setup ---
 logger = structlog.get_logger()
 app = FastAPI(title="Virtual Butler Work Orders API")
-app.add_middleware(CORSMiddleware, allow_origins=["*"], allow_credentials=True, allow_methods=["*"], allow_headers=["*"])
+app.add_middleware(CORSMiddleware, allow_origins=["*"], allow_credentials=True, allow_methods=["*"], allow_headers=["*"],
+                   expose_headers=["X-Request-ID", "ETag", "Last-Modified"])
+app.add_middleware(CompressionMiddleware)
+app.add_middleware(TimeoutMiddleware, exclude_paths=["/api/v1/workorder/events", "/api/v1/admin/export/",
+                                                    "/api/v1/admin/events/stream"])
+app.add_middleware(RecoveryMiddleware, service="work_orders")
+app.add_middleware(RequestIdMiddleware)
+install_error_handlers(app)
 
 security = HTTPBearer()
 JWT_SECRET = os.getenv("JWT_SECRET", "supersecret")
 JWT_ALGORITHM = os.getenv("JWT_ALGORITHM", "HS256")
 WORKORDER_TTL_DAYS = int(os.getenv("WORKORDER_TTL_DAYS", "7"))
 NOTIFICATION_SERVICE_URL = os.getenv("NOTIFICATION_SERVICE_URL", "http://localhost:8002/notify")
+CHATBOT_EVENTS_URL = os.getenv("CHATBOT_EVENTS_URL", "http://localhost:8001/internal/events/work-order")
+# Calls to the notification service, the chatbot and completion webhooks
+http_client = ResilientClient("work_orders", timeout=5.0)
+INTERNAL_EVENTS_TOKEN = os.getenv("INTERNAL_EVENTS_TOKEN")
+MAX_PHOTO_UPLOAD_BYTES = int(os.getenv("MAX_PHOTO_UPLOAD_MB", "10")) * 1024 * 1024
+ALLOWED_PHOTO_TYPES = {"image/jpeg", "image/png", "image/webp", "image/heic"}
+DND_RELEASE_INTERVAL_SECONDS = int(os.getenv("DND_RELEASE_INTERVAL_SECONDS", "60"))
+WAKEUP_POLL_SECONDS = int(os.getenv("WAKEUP_POLL_SECONDS", "20"))
+WORKFLOW_TIMER_SECONDS = int(os.getenv("WORKFLOW_TIMER_SECONDS", "30"))
+# Base URL the voice gateway calls back when the guest answers, e.g. http://work-orders:8000/internal/wakeup-calls
+WAKEUP_CALLBACK_BASE_URL = os.getenv("WAKEUP_CALLBACK_BASE_URL")
+AZURE_SERVICE_BUS_CONN_STR = os.getenv("AZURE_SERVICE_BUS_CONN_STR")
+AZURE_SERVICE_BUS_QUEUE = os.getenv("AZURE_SERVICE_BUS_QUEUE", "chat-requests")
+DEDUP_WINDOW_HOURS = int(os.getenv("DEDUP_WINDOW_HOURS", "24"))
+# Must match the queue: session-enabled queues only accept and deliver messages with a session id
+SERVICE_BUS_SESSIONS_ENABLED = os.getenv("SERVICE_BUS_SESSIONS_ENABLED", "false").lower() == "true"
+SERVICE_BUS_SESSION_CONCURRENCY = int(os.getenv("SERVICE_BUS_SESSION_CONCURRENCY", "8"))
+SERVICE_BUS_SESSION_IDLE_SECONDS = float(os.getenv("SERVICE_BUS_SESSION_IDLE_SECONDS", "5"))
+# Long polls must finish inside the request timeout budget
+LONG_POLL_MAX_SECONDS = min(float(os.getenv("LONG_POLL_MAX_SECONDS", "30")), max(1.0, REQUEST_TIMEOUT_SECONDS - 2))
+LONG_POLL_RECHECK_SECONDS = float(os.getenv("LONG_POLL_RECHECK_SECONDS", "2"))
+
+SSE_KEEPALIVE_SECONDS = float(os.getenv("SSE_KEEPALIVE_SECONDS", "15"))
+
+status_notifier = ChangeNotifier()
+work_order_events = EventBus("work_orders")
+# Set while watch_work_order_changes is tailing the change stream; until then status changes are published locally
+change_stream_active = False
+domain_events = EventPublisher("work_orders")
+SLA_CHECK_SECONDS = int(os.getenv("SLA_CHECK_SECONDS", "60"))
+# Lets admins mint short-lived tokens for any subject (butlerctl token mint); keep off in production
+ALLOW_TEST_TOKENS = os.getenv("ALLOW_TEST_TOKENS", "false").lower() == "true"
 
 # --- Auth ---
-def verify_jwt(credentials: HTTPAuthorizationCredentials = Depends(security)):
+oidc = OidcVerifier()
+key_ring = KeyRing(legacy_secret=JWT_SECRET, legacy_algorithm=JWT_ALGORITHM, federated=oidc)
+api_keys = ApiKeyRing()
+# For endpoints integrations call too: staff JWTs as before, or an API key with the named scope
+auth = Authenticator(key_ring, api_keys)
+
+async def verify_jwt(credentials: HTTPAuthorizationCredentials = Depends(security)):
     try:
-        payload = jwt.decode(credentials.credentials, JWT_SECRET, algorithms=[JWT_ALGORITHM])
+        payload = await key_ring.verify(credentials.credentials)
         return payload
     except JWTError:
         raise HTTPException(status_code=401, detail="Invalid or expired token")
@@ -55,14 +198,10 @@ DEPARTMENT_KEYWORDS = {
     DepartmentEnum.CONCIERGE: [r"taxi|spa|booking|restaurant"]
 }
 DEFAULT_DEPARTMENT = DepartmentEnum.FRONT_DESK
+routing_rules = RoutingRules(builtin=rules_from_keywords(DEPARTMENT_KEYWORDS))
 
-def route_department(msg: str) -> DepartmentEnum:
-    text = msg.lower()
-    for dept, patterns in DEPARTMENT_KEYWORDS.items():
-        for pat in patterns:
-            if re.search(pat, text):
-                return dept
-    return DEFAULT_DEPARTMENT
+def route_department(msg: str, routing_key: Optional[str] = None) -> DepartmentEnum:
+    return routing_rules.decide(msg, routing_key) or DEFAULT_DEPARTMENT
 
 # --- Models ---
 class WorkOrderCreate(BaseModel):
@@ -70,6 +209,14 @@ class WorkOrderCreate(BaseModel):
     room_number: Optional[str]
     message: str
     priority: Optional[PriorityEnum] = PriorityEnum.MEDIUM
+    asset_id: Optional[str] = None
+    fault_code: Optional[FaultCodeEnum] = None
+    tags: List[str] = Field(default_factory=list)
+    custom_fields: Dict[str, Any] = Field(default_factory=dict)
+    workflow: Optional[WorkflowTypeEnum] = None
+
+class WorkflowAdvance(BaseModel):
+    step: str
 
 class WorkOrderStatusUpdate(BaseModel):
     status: StatusEnum
@@ -80,6 +227,73 @@ class WorkOrderAssignUpdate(BaseModel):
 class WorkOrderEstimateUpdate(BaseModel):
     estimated_duration: int  # in minutes
 
+class MaintenanceUpdate(BaseModel):
+    asset_id: Optional[str] = None
+    fault_code: Optional[FaultCodeEnum] = None
+    parts_used: Optional[List[PartUsage]] = None
+
+class AssetUpdate(BaseModel):
+    room_number: Optional[str] = None
+    asset_type: Optional[str] = None
+    manufacturer: Optional[str] = None
+    model: Optional[str] = None
+    serial_number: Optional[str] = None
+    active: Optional[bool] = None
+    metadata: Optional[Dict[str, Any]] = None
+
+class RuleSetCreate(BaseModel):
+    rules: List[RoutingRule]
+    synonyms: Dict[str, List[str]] = Field(default_factory=dict, description="Keyword -> other words for it, e.g. wifi: [wireless]")
+    notes: Optional[str] = None
+
+class RuleSetRollout(BaseModel):
+    mode: RuleSetModeEnum
+    percent: int = Field(0, ge=0, le=100)
+
+class WorkOrderRequeue(BaseModel):
+    reason: Optional[str] = None
+
+class WorkOrderCorrection(BaseModel):
+    department: Optional[DepartmentEnum] = None
+    guest_id: Optional[str] = None
+    reason: str = Field(..., min_length=1)
+
+class MisroutingReport(BaseModel):
+    department: DepartmentEnum = Field(..., description="Where the order should have gone")
+    reason: Optional[str] = None
+
+class GuestQuotaUpdate(BaseModel):
+    open_order_limit: Optional[int] = Field(None, ge=0, description="0 = unlimited, null = use the global default")
+
+class GuestLoyaltyUpdate(BaseModel):
+    loyalty_tier: Optional[str] = Field(None, max_length=40, description="e.g. gold or platinum; null clears it")
+
+class StatusBatchRequest(BaseModel):
+    request_ids: List[str] = Field(..., min_length=1, max_length=100)
+
+class RoomDndUpdate(BaseModel):
+    active: bool
+
+class RoomDevicesUpdate(BaseModel):
+    smart_room: bool
+    devices: List[str] = Field(default_factory=list, description="Empty means every supported device type")
+
+class WakeUpCallCreate(BaseModel):
+    wake_at: datetime = Field(..., description="Local hotel time unless an offset is given")
+    room_number: Optional[str] = None
+    guest_id: Optional[str] = None
+    language: str = "en"
+
+class SubtaskCreate(BaseModel):
+    key: Optional[str] = Field(None, max_length=40, description="Lets other tasks in the same batch depend on this one")
+    department: DepartmentEnum
+    description: str = Field(..., min_length=1, max_length=500)
+    priority: Optional[PriorityEnum] = None
+    depends_on: List[str] = Field(default_factory=list, description="Keys from this batch or existing sibling IDs")
+
+class SubtaskBatch(BaseModel):
+    tasks: List[SubtaskCreate] = Field(..., min_length=1, max_length=20)
+
 class WorkOrderUpdate(BaseModel):
     description: Optional[str]
     priority: Optional[PriorityEnum]
@@ -87,9 +301,24 @@ class WorkOrderUpdate(BaseModel):
     status: Optional[StatusEnum]
     assigned_staff: Optional[str]
     estimated_duration: Optional[int]
+    tags: Optional[List[str]] = None
+    custom_fields: Optional[Dict[str, Any]] = None
 
 # --- Notifications & Events ---
 async def notify_status_change(work_order: dict):
+    # Wake long-polling status requests for this order
+    if work_order.get("request_id"):
+        status_notifier.publish(work_order["request_id"])
+    if not change_stream_active:
+        work_order_events.publish(status_event_from_document(work_order))
+    if work_order.get("parent_id") or work_order.get("guest_id") == PM_GUEST_ID:
+        # Guests follow the parent order (refresh_parent notifies them); preventive maintenance has no guest
+        return
+    group_id = (work_order.get("metadata") or {}).get("group_id")
+    if group_id:
+        # The coordinator gets one consolidated update for the group instead (group_digest_loop)
+        await mark_changed(group_id)
+        return
     # Enhanced: add guest name, room, assigned staff, overdue flag
     try:
         payload = dict(work_order)
@@ -101,7 +330,8 @@ async def notify_status_change(work_order: dict):
                 guest_name = guest.get("name")
                 room_number = guest.get("room_number")
         payload["guest_name"] = guest_name
-        payload["room_number"] = room_number
+        # A multi-room guest's order says which of their rooms it's for
+        payload["room_number"] = (work_order.get("metadata") or {}).get("room_number") or room_number
         payload["assigned_staff"] = work_order.get("assigned_staff")
         # Overdue logic: if pending and created_at + estimated_duration < now
         overdue = False
@@ -111,129 +341,2793 @@ async def notify_status_change(work_order: dict):
                 due = created + timedelta(minutes=work_order["estimated_duration"])
                 overdue = datetime.now(timezone.utc) > due
         payload["overdue"] = overdue
-        async with httpx.AsyncClient() as client:
-            await client.post(NOTIFICATION_SERVICE_URL, json=payload)
+        code = work_order.get("short_code") or short_code_for(work_order["work_order_id"])
+        payload.update({"short_code": format_code(code), "scan_url": scan_url(code), "qr_svg": qr_svg(code)})
+        if fault_injection.drop_notification("status_change"):
+            return
+        await http_client.post(NOTIFICATION_SERVICE_URL, json=payload, trace_id=work_order.get("trace_id"))
     except Exception as e:
         logger.error("notify_failed", error=str(e))
+    await publish_chatbot_event(work_order)
+
+async def publish_chatbot_event(work_order: dict):
+    """Lets the chatbot proactively tell the guest about progress on their request."""
+    if not INTERNAL_EVENTS_TOKEN:
+        return
+    try:
+        event = WorkOrderStatusEvent.from_work_order(work_order, event=work_order.get("event", "status_changed"))
+        await http_client.post(
+            CHATBOT_EVENTS_URL,
+            json=event.model_dump(mode="json"),
+            headers={"X-Internal-Token": INTERNAL_EVENTS_TOKEN},
+            trace_id=work_order.get("trace_id")
+        )
+    except Exception as e:
+        logger.error("chatbot_event_failed", work_order_id=work_order.get("work_order_id"), error=str(e))
+
+# --- Activity Log ---
+async def record_activity(work_order_id: str, action: str, actor: Optional[str],
+                          changes: Optional[Dict[str, Any]] = None, reason: Optional[str] = None):
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["work_order_activity"].insert_one({
+            "work_order_id": work_order_id,
+            "action": action,
+            "actor": actor,
+            "changes": changes or {},
+            "reason": reason,
+            "timestamp": datetime.now(timezone.utc)
+        })
+
+async def resolve_work_order_ref(work_order_id: str) -> str:
+    """Path IDs may be order numbers (HK-2045); unknown numbers fall through and 404 like unknown IDs."""
+    if is_order_number(work_order_id):
+        return await work_order_id_for(work_order_id) or work_order_id
+    return work_order_id
+
+WorkOrderRef = Annotated[str, Depends(resolve_work_order_ref)]
+
+def if_match_version(if_match: Optional[str] = Header(None)) -> Optional[int]:
+    return expected_version(if_match)
+
+IfMatch = Annotated[Optional[int], Depends(if_match_version)]
+
+def with_etag(response: Response, doc: dict) -> WorkOrder:
+    response.headers["ETag"] = etag(doc)
+    return WorkOrder(**doc)
+
+async def update_conflict(work_order_id: str) -> Exception:
+    """Why a conditional update matched nothing: 404 if the order is gone, else 409 with the version that won."""
+    async with DatabaseConnection.get_connection() as conn:
+        current = await conn["virtualbutler"]["work_orders"].find_one({"work_order_id": work_order_id})
+    if not current:
+        return HTTPException(404, detail="Work order not found")
+    return ApiError(409, "The work order was changed by someone else; review the current version and retry",
+                    headers={"ETag": etag(current)}, current=WorkOrder(**current).model_dump(mode="json"))
 
 # --- CRUD ---
-@app.post("/work-orders", response_model=WorkOrder, dependencies=[Depends(RateLimiter(times=5, seconds=60))])
-async def create_work_order(data: WorkOrderCreate, user=Depends(require_staff)):
+# `times` follows rate_limits.work_orders_per_minute in the runtime config
+create_work_order_limiter = RateLimiter(times=runtime_config.settings.rate_limits.work_orders_per_minute, seconds=60)
+
+async def apply_runtime_config() -> None:
+    create_work_order_limiter.times = runtime_config.settings.rate_limits.work_orders_per_minute
+    await routing_rules.refresh()
+
+@app.post("/work-orders", response_model=WorkOrder, dependencies=[Depends(create_work_order_limiter)])
+async def create_work_order(data: WorkOrderCreate, user=Depends(auth.require("work_orders:write"))):
     now = datetime.now(timezone.utc)
+    department = route_department(data.message, routing_key=data.guest_id)
+    maintenance = None
+    if data.asset_id or data.fault_code:
+        # Asset/fault details only make sense on maintenance tickets
+        department = DepartmentEnum.MAINTENANCE
+        if data.asset_id:
+            await get_asset_or_404(data.asset_id)
+        maintenance = MaintenanceDetails(asset_id=data.asset_id, fault_code=data.fault_code)
+    workflow = None
+    if data.workflow:
+        department = DepartmentEnum.CONCIERGE
+        workflow = start_workflow(data.workflow, now, actor=user.get("sub"))
+    try:
+        tags = normalize_tags(data.tags)
+        custom_fields = validate_custom_fields(data.custom_fields, await list_field_definitions())
+    except CustomFieldError as e:
+        raise HTTPException(422, detail=str(e))
+    metadata = {"room_number": data.room_number}
+    order_status = StatusEnum.PENDING
+    if should_hold_for_dnd(department, data.priority) and await is_room_dnd(data.room_number):
+        order_status = StatusEnum.ON_HOLD
+        metadata.update({"hold_reason": DND_HOLD_REASON, "held_status": StatusEnum.PENDING})
     work_order = WorkOrder(
         request_id=f"req_{now.timestamp()}",
         work_order_id=f"wo_{now.timestamp()}",
+        order_number=await next_order_number(department),
         guest_id=data.guest_id,
-        department=route_department(data.message),
+        department=department,
         description=data.message,
-        status=StatusEnum.PENDING,
+        status=order_status,
         priority=data.priority,
         created_at=now,
         updated_at=now,
-        metadata={"room_number": data.room_number},
+        maintenance=maintenance,
+        tags=tags,
+        custom_fields=custom_fields,
+        workflow=workflow,
+        checklist=await checklist_for({"department": department, "tags": tags, "metadata": metadata}),
+        metadata=metadata,
+        trace_id=current_request_id.get(),
+        loyalty_tier=await guest_tier(data.guest_id),
         estimated_duration=None
     )
     async with DatabaseConnection.get_connection() as conn:
         result = await conn["virtualbutler"]["work_orders"].insert_one(work_order.model_dump(by_alias=True))
         work_order.id = result.inserted_id
     await notify_status_change(work_order.model_dump())
+    await auto_print_ticket(work_order.model_dump())
+    origin = "integration" if user.get("role") == INTEGRATION_ROLE else "staff"
+    await domain_events.publish(WorkOrderCreated.from_work_order(work_order.model_dump(), origin))
     return work_order
 
 @app.get("/work-orders/{work_order_id}", response_model=WorkOrder)
-async def get_work_order(work_order_id: str, user=Depends(require_staff)):
+async def get_work_order(work_order_id: WorkOrderRef, request: Request, user=Depends(auth.require("work_orders:read"))):
+    """Answers If-None-Match with the order's version ETag (the one If-Match takes) with 304 while it's unchanged."""
     async with DatabaseConnection.get_connection() as conn:
         doc = await conn["virtualbutler"]["work_orders"].find_one({"work_order_id": work_order_id})
-        if not doc:
-            raise HTTPException(404, detail="Not found")
-        return WorkOrder(**doc)
+    order = proof_view(user, ensure_can_read_work_order(user, doc))
+    return conditional_response(request, WorkOrder(**order), order.get("updated_at"), tag=etag(order))
 
 @app.patch("/work-orders/{work_order_id}/assign", response_model=WorkOrder)
-async def assign_work_order(work_order_id: str, update: WorkOrderAssignUpdate, user=Depends(require_admin)):
+async def assign_work_order(work_order_id: WorkOrderRef, update: WorkOrderAssignUpdate, response: Response,
+                            version: IfMatch, user=Depends(require_admin)):
     async with DatabaseConnection.get_connection() as conn:
         doc = await conn["virtualbutler"]["work_orders"].find_one_and_update(
-            {"work_order_id": work_order_id},
-            {"$set": {"assigned_staff": update.assigned_staff, "updated_at": datetime.now(timezone.utc)}},
+            {"work_order_id": work_order_id, **version_filter(version)},
+            versioned({"$set": {"assigned_staff": update.assigned_staff, "updated_at": datetime.now(timezone.utc)}}),
             return_document=True
         )
         if not doc:
-            raise HTTPException(404, detail="Work order not found")
+            raise await update_conflict(work_order_id)
         await notify_status_change(doc)
-        return WorkOrder(**doc)
+        await domain_events.publish(WorkOrderAssigned(work_order_id=work_order_id, department=doc["department"],
+                                                      assigned_staff=update.assigned_staff))
+        return with_etag(response, doc)
 
 @app.patch("/work-orders/{work_order_id}/estimate", response_model=WorkOrder)
-async def set_estimated_completion(work_order_id: str, update: WorkOrderEstimateUpdate, user=Depends(require_admin)):
+async def set_estimated_completion(work_order_id: WorkOrderRef, update: WorkOrderEstimateUpdate, response: Response,
+                                   version: IfMatch, user=Depends(require_admin)):
     async with DatabaseConnection.get_connection() as conn:
         doc = await conn["virtualbutler"]["work_orders"].find_one_and_update(
-            {"work_order_id": work_order_id},
-            {"$set": {"estimated_duration": update.estimated_duration, "updated_at": datetime.now(timezone.utc)}},
+            {"work_order_id": work_order_id, **version_filter(version)},
+            versioned({"$set": {"estimated_duration": update.estimated_duration, "updated_at": datetime.now(timezone.utc)}}),
             return_document=True
         )
         if not doc:
-            raise HTTPException(404, detail="Work order not found")
+            raise await update_conflict(work_order_id)
         await notify_status_change(doc)
-        return WorkOrder(**doc)
+        return with_etag(response, doc)
 
 @app.put("/work-orders/{work_order_id}", response_model=WorkOrder)
-async def update_work_order(work_order_id: str, update: WorkOrderUpdate, user=Depends(require_staff)):
+async def update_work_order(work_order_id: WorkOrderRef, update: WorkOrderUpdate, response: Response, version: IfMatch,
+                            user=Depends(auth.require("work_orders:write"))):
     async with DatabaseConnection.get_connection() as conn:
         update_data = {k: v for k, v in update.dict(exclude_unset=True).items() if v is not None}
         if not update_data:
             raise HTTPException(400, detail="No data to update")
+        try:
+            if "tags" in update_data:
+                update_data["tags"] = normalize_tags(update_data["tags"])
+            if "custom_fields" in update_data:
+                # Only the supplied fields change; the others keep their values
+                values = validate_custom_fields(update_data.pop("custom_fields"), await list_field_definitions(), partial=True)
+                update_data.update({f"custom_fields.{k}": v for k, v in values.items()})
+        except CustomFieldError as e:
+            raise HTTPException(422, detail=str(e))
+        before = None
+        if "status" in update_data:
+            before = await conn["virtualbutler"]["work_orders"].find_one({"work_order_id": work_order_id})
+            await check_subtask_gate(before, update_data["status"])
+            await check_completion_proof(before, update_data["status"])
+            await check_checklist(before, update_data["status"])
+            if before and update_data["status"] in (StatusEnum.COMPLETED, StatusEnum.CANCELLED) and before.get("labor"):
+                # Timers nobody stopped end with the order
+                labor = stop_all(before["labor"], datetime.now(timezone.utc))
+                update_data.update({"labor": labor, "labor_minutes": labor_minutes(labor)})
         update_data["updated_at"] = datetime.now(timezone.utc)
         doc = await conn["virtualbutler"]["work_orders"].find_one_and_update(
-            {"work_order_id": work_order_id},
-            {"$set": update_data},
+            {"work_order_id": work_order_id, **version_filter(version)},
+            versioned({"$set": update_data}),
             return_document=True
         )
         if not doc:
-            raise HTTPException(404, detail="Work order not found")
+            raise await update_conflict(work_order_id)
         await notify_status_change(doc)
+        if before and before["status"] != doc["status"]:
+            await domain_events.publish(StatusChanged.from_work_order(doc, before["status"], user.get("sub")))
         # Webhook notification if completed
-        if update_data.get("status") == StatusEnum.COMPLETED:
+        if update_data.get("status") == StatusEnum.COMPLETED and not doc.get("parent_id"):
             await send_work_order_completed_webhook(doc)
-        return WorkOrder(**doc)
+        if "status" in update_data and (doc.get("maintenance") or {}).get("schedule_id"):
+            await sync_pm_schedule(doc, update_data["status"], user.get("sub"))
+        if "status" in update_data and "transport" in (doc.get("metadata") or {}).get("tags", []):
+            await sync_from_work_order(doc, update_data["status"], user.get("sub"))
+        if "status" in update_data:
+            if doc.get("parent_id"):
+                await refresh_parent(doc["parent_id"])
+            elif doc.get("subtasks") and update_data["status"] == StatusEnum.CANCELLED:
+                await cancel_subtasks(work_order_id, user.get("sub"))
+        return with_etag(response, doc)
+
+# --- Subtasks ---
+async def load_subtasks(parent_id: str) -> List[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        cursor = conn["virtualbutler"]["work_orders"].find({"parent_id": parent_id}).sort("created_at", 1)
+        return await cursor.to_list(length=200)
+
+async def check_subtask_gate(doc: Optional[dict], new_status: str):
+    """Parents follow their children; children wait for the siblings they depend on."""
+    if not doc:
+        return
+    if doc.get("subtasks") and new_status != StatusEnum.CANCELLED:
+        raise HTTPException(409, detail="Status is rolled up from the subtasks; update those instead")
+    if doc.get("parent_id"):
+        siblings = {c["work_order_id"]: c for c in await load_subtasks(doc["parent_id"])}
+        try:
+            check_can_move(doc, new_status, siblings)
+        except SubtaskError as e:
+            raise HTTPException(e.status_code, detail=str(e))
+
+async def refresh_parent(parent_id: str) -> Optional[dict]:
+    """Re-derives the parent's status and counts from its subtasks."""
+    now = datetime.now(timezone.utc)
+    children = await load_subtasks(parent_id)
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["work_orders"]
+        parent = await coll.find_one({"work_order_id": parent_id})
+        changes = parent_changes(parent, children, now) if parent else None
+        if not changes:
+            return parent
+        updated = await coll.find_one_and_update({"work_order_id": parent_id}, versioned({"$set": changes}),
+                                                 return_document=True)
+    if changes["status"] != parent["status"]:
+        await record_activity(parent_id, "status_rolled_up", None,
+                              changes={"status": {"from": parent["status"], "to": changes["status"]}})
+        await notify_status_change(updated)
+        await domain_events.publish(StatusChanged.from_work_order(updated, parent["status"]))
+        if changes["status"] == StatusEnum.COMPLETED:
+            await send_work_order_completed_webhook(updated)
+    return updated
+
+async def cancel_subtasks(parent_id: str, actor: Optional[str]):
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["work_orders"].update_many(
+            {"parent_id": parent_id, "status": {"$nin": list(DONE_STATUSES)}},
+            versioned({"$set": {"status": StatusEnum.CANCELLED, "updated_at": now}})
+        )
+    await record_activity(parent_id, "subtasks_cancelled", actor)
+    await refresh_parent(parent_id)
+
+@app.post("/work-orders/{work_order_id}/subtasks", response_model=List[WorkOrder], status_code=201)
+async def create_subtasks(work_order_id: WorkOrderRef, data: SubtaskBatch, user=Depends(require_staff)):
+    """Splits an order into departmental tasks; the guest keeps seeing only the parent."""
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["work_orders"]
+        parent = ensure_can_read_work_order(user, await coll.find_one({"work_order_id": work_order_id}))
+        if parent.get("parent_id"):
+            raise HTTPException(409, detail="Subtasks cannot have subtasks of their own")
+        if parent["status"] in DONE_STATUSES:
+            raise HTTPException(409, detail=f"Work order is {parent['status']}")
+        existing = [c["work_order_id"] for c in await load_subtasks(work_order_id)]
+        # Reserving the numbers on the parent keeps concurrent batches from reusing IDs
+        counter = await coll.find_one_and_update(
+            {"work_order_id": work_order_id}, versioned({"$inc": {"metadata.subtask_seq": len(data.tasks)}}),
+            return_document=True
+        )
+        first = counter["metadata"]["subtask_seq"] - len(data.tasks) + 1
+        numbers = range(first, first + len(data.tasks))
+        new_ids = [f"{work_order_id}.{n}" for n in numbers]
+        try:
+            depends = resolve_dependencies([t.model_dump() for t in data.tasks], new_ids, existing)
+        except SubtaskError as e:
+            raise HTTPException(e.status_code, detail=str(e))
+        children = [
+            WorkOrder(
+                request_id=f"{parent['request_id']}.{n}",
+                work_order_id=child_id,
+                order_number=f"{parent['order_number']}.{n}" if parent.get("order_number") else None,
+                parent_id=work_order_id,
+                depends_on=depends[i],
+                guest_id=parent["guest_id"],
+                department=task.department,
+                description=task.description,
+                priority=task.priority or parent.get("priority") or PriorityEnum.MEDIUM,
+                location=parent.get("location"),
+                metadata={"room_number": (parent.get("metadata") or {}).get("room_number")},
+                created_at=now,
+                updated_at=now
+            )
+            for i, (n, child_id, task) in enumerate(zip(numbers, new_ids, data.tasks))
+        ]
+        await coll.insert_many([c.model_dump(by_alias=True, exclude={"id"}) for c in children])
+    await record_activity(work_order_id, "subtasks_added", user.get("sub"), changes={"subtasks": new_ids})
+    for child in children:
+        await notify_status_change(child.model_dump())
+        await domain_events.publish(WorkOrderCreated.from_work_order(child.model_dump(), "staff"))
+    await refresh_parent(work_order_id)
+    return children
+
+@app.get("/work-orders/{work_order_id}/subtasks")
+async def get_subtasks(work_order_id: WorkOrderRef, user=Depends(require_staff)):
+    async with DatabaseConnection.get_connection() as conn:
+        parent = await conn["virtualbutler"]["work_orders"].find_one({"work_order_id": work_order_id})
+    ensure_can_read_work_order(user, parent)
+    children = await load_subtasks(work_order_id)
+    siblings = {c["work_order_id"]: c for c in children}
+    return [
+        {**WorkOrder(**c).model_dump(mode="json"),
+         "blocked_by": blocked_by(c, siblings) if c["status"] not in DONE_STATUSES else []}
+        for c in children
+    ]
+
+# --- Valet & Luggage Workflows ---
+@app.post("/work-orders/{work_order_id}/workflow", response_model=WorkOrder)
+async def advance_work_order_workflow(work_order_id: WorkOrderRef, data: WorkflowAdvance, response: Response,
+                                      version: IfMatch, user=Depends(require_staff)):
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["work_orders"]
+        doc = ensure_can_read_work_order(user, await coll.find_one({"work_order_id": work_order_id}))
+        if not doc.get("workflow"):
+            raise HTTPException(409, detail="Work order has no workflow")
+        if doc.get("status") == StatusEnum.CANCELLED:
+            raise HTTPException(409, detail="Work order is cancelled")
+        try:
+            workflow = advance_workflow(doc["workflow"], data.step, now, actor=user.get("sub"))
+        except WorkflowError as e:
+            raise HTTPException(409, detail=str(e))
+        order_status = status_for_step(workflow["type"], workflow["step"])
+        await check_completion_proof(doc, order_status)
+        await check_checklist(doc, order_status)
+        changes = {"workflow": workflow, "status": order_status, "updated_at": now}
+        if order_status == StatusEnum.COMPLETED:
+            changes["completed_at"] = now
+            if doc.get("labor"):
+                changes["labor"] = stop_all(doc["labor"], now)
+                changes["labor_minutes"] = labor_minutes(changes["labor"])
+        # Matching on the current step makes concurrent advances from two devices fail cleanly
+        updated = await coll.find_one_and_update(
+            {"work_order_id": work_order_id, "workflow.step": doc["workflow"]["step"], **version_filter(version)},
+            versioned({"$set": changes}),
+            return_document=True
+        )
+    if not updated:
+        raise await update_conflict(work_order_id)
+    await record_activity(work_order_id, "workflow_advanced", user.get("sub"),
+                          changes={"step": {"from": doc["workflow"]["step"], "to": workflow["step"]}})
+    await notify_status_change({**updated, "event": "workflow_step"})
+    if updated["status"] != doc["status"]:
+        await domain_events.publish(StatusChanged.from_work_order(updated, doc["status"], user.get("sub")))
+    return with_etag(response, updated)
+
+async def flag_overdue_workflow_steps() -> int:
+    now = datetime.now(timezone.utc)
+    flagged = 0
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["work_orders"]
+        query = {"workflow.due_at": {"$lte": now}, "workflow.overdue": False,
+                 "status": {"$nin": [StatusEnum.COMPLETED, StatusEnum.CANCELLED]}}
+        async for doc in coll.find(query):
+            updated = await coll.find_one_and_update(
+                {"_id": doc["_id"], "workflow.overdue": False, "workflow.step": doc["workflow"]["step"]},
+                versioned({"$set": {"workflow.overdue": True, "updated_at": now}}),
+                return_document=True
+            )
+            if updated:
+                flagged += 1
+                logger.warning("workflow_step_overdue", work_order_id=updated["work_order_id"],
+                               workflow=updated["workflow"]["type"], step=updated["workflow"]["step"])
+                await notify_status_change({**updated, "event": "workflow_overdue"})
+    return flagged
+
+workflow_timer_lease = Lease("workflow_timers", WORKFLOW_TIMER_SECONDS)
+
+async def workflow_timer_loop():
+    while True:
+        await asyncio.sleep(WORKFLOW_TIMER_SECONDS)
+        try:
+            await workflow_timer_lease.run(flag_overdue_workflow_steps)
+        except Exception as e:
+            logger.error("workflow_timer_failed", error=str(e))
+
+# --- SLA Breaches ---
+async def flag_sla_breaches(now: datetime) -> int:
+    """
+    Marks guest orders still open past their target (the department's, tightened for loyalty tiers)
+    and announces each breach once.
+    """
+    flagged = 0
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["work_orders"]
+        for department in DepartmentEnum:
+            department_target = sla_minutes(department.value)
+            calendar = await business_calendars.get(department.value)
+            # Working time never exceeds wall-clock time, so this narrows the candidates without missing any
+            candidates = coll.find(
+                {"department": department.value, "status": {"$nin": list(DONE_STATUSES)}, "parent_id": None,
+                 "guest_id": {"$ne": PM_GUEST_ID}, "sla_breached_at": None,
+                 "created_at": {"$lte": now - timedelta(minutes=shortest_sla_minutes(department_target))}},
+                {"_id": 1, "created_at": 1, "loyalty_tier": 1}
+            )
+            async for candidate in candidates:
+                target = tier_sla_minutes(department_target, candidate.get("loyalty_tier"))
+                if business_minutes_between(calendar, candidate["created_at"], now) < target:
+                    continue
+                doc = await coll.find_one_and_update(
+                    {"_id": candidate["_id"], "sla_breached_at": None},
+                    versioned({"$set": {"sla_breached_at": now}}),
+                    return_document=True
+                )
+                if not doc:
+                    continue
+                flagged += 1
+                await domain_events.publish(SLABreached.from_work_order(doc, target, now))
+    return flagged
+
+sla_breach_lease = Lease("sla_breach_check", SLA_CHECK_SECONDS)
+
+async def sla_breach_loop():
+    while True:
+        await asyncio.sleep(SLA_CHECK_SECONDS)
+        try:
+            flagged = await sla_breach_lease.run(lambda: flag_sla_breaches(datetime.now(timezone.utc)))
+            if flagged:
+                logger.info("sla_breaches_flagged", count=flagged)
+        except Exception as e:
+            logger.error("sla_breach_check_failed", error=str(e))
+
+@app.get("/api/v1/admin/events/stream")
+async def stream_domain_events(request: Request, user=Depends(require_admin)):
+    """Server-Sent Events stream of the domain events published by this replica."""
+    queue = domain_events.bus.subscribe()
+
+    async def event_stream():
+        try:
+            yield ": connected\n\n"
+            while not await request.is_disconnected():
+                try:
+                    envelope = await asyncio.wait_for(queue.get(), timeout=SSE_KEEPALIVE_SECONDS)
+                except asyncio.TimeoutError:
+                    yield ": keep-alive\n\n"
+                    continue
+                yield f"event: {envelope['type']}\ndata: {json.dumps(envelope, default=str)}\n\n"
+        finally:
+            domain_events.bus.unsubscribe(queue)
+
+    return StreamingResponse(event_stream(), media_type="text/event-stream",
+                             headers={"Cache-Control": "no-cache", "X-Accel-Buffering": "no"})
+
+@app.get("/api/v1/events/catalog")
+async def get_event_catalog():
+    """Public: every domain event type, its current version and the schema of its `data`."""
+    return {"envelope": ["id", "type", "version", "occurred_at", "source", "trace_id", "data"],
+            "events": event_catalog()}
+
+async def auto_print_ticket(work_order: dict):
+    """Sends new orders' tickets to the print server, when one is configured (shared/tickets.py)."""
+    if not PRINT_SERVER_URL or not should_auto_print(work_order):
+        return
+    if suppress_staff_alert("print", work_order_id=work_order["work_order_id"]):
+        return
+    try:
+        response = await http_client.post(
+            PRINT_SERVER_URL, content=render_ticket(work_order, hotel_timezone()), idempotent=False,
+            headers={"Content-Type": "application/pdf", "X-Department": str(work_order.get("department")),
+                     "X-Work-Order-Id": work_order["work_order_id"]},
+            trace_id=work_order.get("trace_id")
+        )
+        response.raise_for_status()
+        logger.info("work_ticket_printed", work_order_id=work_order["work_order_id"])
+    except Exception as e:
+        logger.error("work_ticket_print_failed", work_order_id=work_order["work_order_id"], error=str(e))
+
+@app.get("/api/v1/workorder/{work_order_id}/ticket.pdf")
+async def get_work_ticket(work_order_id: WorkOrderRef, user=Depends(require_staff)):
+    async with DatabaseConnection.get_connection() as conn:
+        doc = ensure_can_read_work_order(user, await conn["virtualbutler"]["work_orders"].find_one(
+            {"work_order_id": work_order_id}))
+    return Response(render_ticket(doc, hotel_timezone()), media_type="application/pdf",
+                    headers={"Content-Disposition": f'inline; filename="ticket-{ticket_code(doc)}.pdf"'})
+
+# --- Scan lookup (shared/scan_codes.py) ---
+class ScanStatusUpdate(BaseModel):
+    status: StatusEnum
+    version: Optional[int] = Field(None, description="The version the app saw when scanning, for queued offline scans")
+
+async def find_scanned_order(code: str, user: dict) -> dict:
+    """Accepts a short code, a scanned link or an order number."""
+    if is_order_number(code):
+        doc = await find_work_order_by_number(code)
+    else:
+        short_code = normalize_code(code)
+        if not short_code:
+            raise HTTPException(400, detail="Code doesn't check out; scan it again or retype it")
+        doc = await find_by_code(short_code)
+    if not doc:
+        raise HTTPException(404, detail="Work order not found")
+    return ensure_can_read_work_order(user, doc)
+
+async def find_work_order_by_number(order_number: str) -> Optional[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["work_orders"].find_one({"order_number": normalize_order_number(order_number)})
+
+@app.get("/api/v1/workorder/scan/{code}", response_model=WorkOrder)
+async def scan_work_order(code: str, response: Response, user=Depends(require_staff)):
+    return with_etag(response, await find_scanned_order(code, user))
+
+@app.post("/api/v1/workorder/scan/{code}/status", response_model=WorkOrder)
+async def update_scanned_work_order(code: str, data: ScanStatusUpdate, response: Response, user=Depends(require_staff)):
+    """Status change from a scan. Scanning an unassigned order to start it assigns it to whoever scanned it."""
+    doc = await find_scanned_order(code, user)
+    changes = {"status": data.status}
+    if data.status in (StatusEnum.ASSIGNED, StatusEnum.IN_PROGRESS) and not doc.get("assigned_staff"):
+        changes["assigned_staff"] = user.get("sub")
+    logger.info("work_order_scanned", work_order_id=doc["work_order_id"], staff=user.get("sub"), status=data.status,
+                queued=data.version is not None)
+    return await update_work_order(doc["work_order_id"], WorkOrderUpdate.model_construct(**changes), response,
+                                   data.version, user)
 
 async def send_work_order_completed_webhook(work_order: dict):
     webhook_url = os.getenv("WORKORDER_COMPLETED_WEBHOOK_URL")
-    if not webhook_url:
+    if not webhook_url or suppress_staff_alert("completed_webhook", work_order_id=work_order.get("work_order_id")):
         return
     try:
-        import httpx
-        async with httpx.AsyncClient() as client:
-            await client.post(webhook_url, json=work_order)
+        await http_client.post(webhook_url, json=work_order, trace_id=work_order.get("trace_id"))
     except Exception as e:
         logger.error("workorder_completed_webhook_failed", error=str(e))
 
 @app.delete("/work-orders/{work_order_id}", status_code=204)
-async def delete_work_order(work_order_id: str, user=Depends(require_admin)):
+async def delete_work_order(work_order_id: WorkOrderRef, user=Depends(require_admin)):
     async with DatabaseConnection.get_connection() as conn:
         result = await conn["virtualbutler"]["work_orders"].delete_one({"work_order_id": work_order_id})
         if result.deleted_count == 0:
             raise HTTPException(404, detail="Not found")
+        await conn["virtualbutler"]["work_orders"].delete_many({"parent_id": work_order_id})
     logger.info("work_order_deleted", work_order_id=work_order_id)
 
+# --- Chat Request Consumer ---
+# The consumer acts on every ChatRequestMessage field; tests/test_contracts.py checks it really reads them
+CHAT_MESSAGE_FIELDS_HANDLED = frozenset(ChatRequestMessage.model_fields)
+
+def build_work_order_from_chat(message: ChatRequestMessage) -> WorkOrder:
+    """Maps a chat request published by the chatbot onto a new work order (consumer hot path)."""
+    now = datetime.now(timezone.utc)
+    department = message.department or route_department(message.message, routing_key=message.guest_id)
+    if message.workflow:
+        department = DepartmentEnum.CONCIERGE
+    return WorkOrder(
+        request_id=message.request_id,
+        work_order_id=f"wo_{uuid.uuid4().hex}",
+        guest_id=message.guest_id,
+        department=department,
+        description=message.message[:500],
+        line_items=message.line_items,
+        status=StatusEnum.PENDING,
+        # Frustrated guests get bumped up the queue
+        priority=priority_for_sentiment(message.priority or PriorityEnum.MEDIUM, message.sentiment),
+        created_at=now,
+        updated_at=now,
+        workflow=start_workflow(message.workflow, now) if message.workflow else None,
+        trace_id=current_request_id.get(),
+        metadata={
+            "room_number": message.room_number,
+            "session_id": message.session_id,
+            "language": message.language,
+            "tags": message.tags,
+            "attachment_ids": message.attachment_ids,
+            "sentiment": message.sentiment,
+            "scheduled_for": message.scheduled_for,
+            "requested_at": message.created_at,
+            "contract_version": message.contract_version,
+            "quick_action": message.quick_action,
+            "location": message.location,
+            "source": "chat"
+        }
+    )
+
+# --- Redelivery Deduplication ---
+# Service Bus delivers at least once. The chatbot sends every message with message_id = request_id
+# so broker-side duplicate detection (when enabled on the queue) drops resends within its window;
+# the processed-message ledger below covers redeliveries after a consumer crash or lock expiry.
+# The order is written first and only then recorded, so a crash in between means a redelivery, never a
+# lost request; the unique request_id index on work_orders turns that redelivery into a no-op.
+async def message_processed(request_id: str) -> bool:
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["processed_messages"].find_one({"request_id": request_id}, {"_id": 1}) is not None
+
+async def record_processed_message(request_id: str, message_id: Optional[str]):
+    try:
+        async with DatabaseConnection.get_connection() as conn:
+            await conn["virtualbutler"]["processed_messages"].insert_one({
+                "request_id": request_id,
+                "message_id": message_id,
+                "processed_at": datetime.now(timezone.utc)
+            })
+    except DuplicateKeyError:
+        pass
+
+def duplicate_request(error: DuplicateKeyError) -> bool:
+    return "request_id" in ((error.details or {}).get("keyPattern") or {})
+
+async def ensure_dedup_indexes():
+    async with DatabaseConnection.get_connection() as conn:
+        ledger = conn["virtualbutler"]["processed_messages"]
+        await ledger.create_index("request_id", unique=True)
+        await ledger.create_index(
+            [("processed_at", 1)],
+            expireAfterSeconds=DEDUP_WINDOW_HOURS * 3600,
+            name="ttl_processed_at"
+        )
+
+async def process_chat_message(message: ChatRequestMessage, message_id: Optional[str] = None) -> Optional[WorkOrder]:
+    if await message_processed(message.request_id):
+        logger.info("duplicate_chat_message_skipped", request_id=message.request_id, message_id=message_id)
+        return None
+    current = await current_request(message.request_id)
+    if current and RECALLED_TAG in current.get("tags", []):
+        logger.info("recalled_chat_message_skipped", request_id=message.request_id)
+        await record_processed_message(message.request_id, message_id)
+        return None
+    if current and current.get("edit_history"):
+        # Reworded by the guest while the message waited on the queue
+        message.message = current["message"]
+        message.line_items = [LineItem(**item) for item in current.get("metadata", {}).get("line_items", [])]
+        message.sentiment = current.get("sentiment")
+    work_order = build_work_order_from_chat(message)
+    work_order.order_number = await next_order_number(work_order.department)
+    work_order.loyalty_tier = await guest_tier(work_order.guest_id)
+    if work_order.loyalty_tier:
+        work_order.priority = boost_priority(work_order.priority, work_order.loyalty_tier)
+    room_number = work_order.metadata.get("room_number")
+    if should_hold_for_dnd(work_order.department, work_order.priority) and await is_room_dnd(room_number):
+        work_order.status = StatusEnum.ON_HOLD
+        work_order.metadata.update({"hold_reason": DND_HOLD_REASON, "held_status": StatusEnum.PENDING})
+    if message.location:
+        # Off-site requests (villa, beach) go to the nearest service hub for the department
+        location = await locate_request(message.location, str(getattr(work_order.department, "value",
+                                                                      work_order.department)))
+        work_order.metadata["location"] = location
+        work_order.location = location["area_name"] or work_order.location
+    checklist = await checklist_for(work_order.model_dump())
+    if checklist:
+        work_order.checklist = Checklist(**checklist)
+    async with DatabaseConnection.get_connection() as conn:
+        # Attachments uploaded before the order existed are linked by request_id
+        cursor = conn["virtualbutler"]["chat_attachments"].find({"request_id": work_order.request_id,
+                                                                 "guest_id": work_order.guest_id})
+        work_order.attachments = [doc["blob_name"] async for doc in cursor]
+        try:
+            result = await conn["virtualbutler"]["work_orders"].insert_one(work_order.model_dump(by_alias=True, exclude={"id"}))
+        except DuplicateKeyError as e:
+            if not duplicate_request(e):
+                raise
+            # Redelivered after the order was written but before it was recorded
+            logger.info("duplicate_chat_message_skipped", request_id=message.request_id, message_id=message_id)
+            await record_processed_message(message.request_id, message_id)
+            return None
+        work_order.id = result.inserted_id
+        if "transport" in message.tags:
+            await link_work_order(message.request_id, work_order.work_order_id)
+        if work_order.attachments:
+            await conn["virtualbutler"]["chat_attachments"].update_many(
+                {"request_id": work_order.request_id, "guest_id": work_order.guest_id},
+                {"$set": {"work_order_id": work_order.work_order_id}}
+            )
+    await record_processed_message(message.request_id, message_id)
+    logger.info("work_order_created_from_chat", request_id=work_order.request_id,
+                work_order_id=work_order.work_order_id, department=work_order.department)
+    await domain_events.publish(WorkOrderCreated.from_work_order(work_order.model_dump(), "chat"))
+    await auto_print_ticket(work_order.model_dump())
+    assigned = await assign_nearest_attendant(work_order)
+    await notify_status_change(assigned or work_order.model_dump())
+    return WorkOrder(**assigned) if assigned else work_order
+
+async def assign_nearest_attendant(work_order: WorkOrder) -> Optional[dict]:
+    """Zone-based auto-assignment of a new order (shared/zones.py); a no-op until zones are set up."""
+    if work_order.status != StatusEnum.PENDING or work_order.metadata.get("scheduled_for"):
+        return None
+    if not feature_flags.is_enabled("auto_assignment", work_order.guest_id):
+        return None
+    try:
+        staff_id = await pick_attendant(work_order.department, work_order.metadata.get("room_number"))
+    except Exception as e:
+        # The order is already queued; someone can still assign it by hand
+        logger.error("auto_assignment_failed", work_order_id=work_order.work_order_id, error=str(e))
+        return None
+    if not staff_id:
+        return None
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["work_orders"].find_one_and_update(
+            {"work_order_id": work_order.work_order_id, "status": StatusEnum.PENDING},
+            versioned({"$set": {"status": StatusEnum.ASSIGNED, "assigned_staff": staff_id, "assigned_at": now,
+                                "updated_at": now}}),
+            return_document=True
+        )
+    if not doc:
+        return None
+    await record_activity(work_order.work_order_id, "auto_assigned", None,
+                          {"assigned_staff": {"from": None, "to": staff_id}}, "nearest on-shift attendant")
+    metrics.increment("butler_work_orders_auto_assigned_total", department=work_order.department)
+    logger.info("work_order_auto_assigned", work_order_id=work_order.work_order_id, assigned_staff=staff_id)
+    await domain_events.publish(WorkOrderAssigned(work_order_id=work_order.work_order_id,
+                                                  department=work_order.department, assigned_staff=staff_id))
+    await domain_events.publish(StatusChanged.from_work_order(doc, StatusEnum.PENDING))
+    return doc
+
+async def handle_received_message(receiver, msg):
+    if drain.draining:
+        # Straight back to the queue for another replica, rather than waiting out the lock
+        await receiver.abandon_message(msg)
+        return
+    # Carry on the chatbot request's ID, so the order and these log lines correlate with it
+    trace_id = from_message_properties(msg.application_properties)
+    token = current_request_id.set(trace_id)
+    try:
+        with structlog.contextvars.bound_contextvars(trace_id=trace_id):
+            async with drain.processing():
+                try:
+                    fault_injection.service_bus_error("receive")
+                    await process_chat_message(ChatRequestMessage.from_json(str(msg)), message_id=msg.message_id)
+                    await receiver.complete_message(msg)
+                except Exception as e:
+                    logger.error("chat_message_processing_failed", message_id=msg.message_id,
+                                 session_id=msg.session_id, error=str(e))
+                    await receiver.abandon_message(msg)
+    finally:
+        current_request_id.reset(token)
+
+async def consume_chat_requests():
+    if not AZURE_SERVICE_BUS_CONN_STR:
+        logger.warning("service_bus_not_configured")
+        return
+    if SERVICE_BUS_SESSIONS_ENABLED:
+        await asyncio.gather(*(consume_sessions(worker) for worker in range(SERVICE_BUS_SESSION_CONCURRENCY)))
+        return
+    while not drain.draining:
+        try:
+            async with ServiceBusClient.from_connection_string(AZURE_SERVICE_BUS_CONN_STR) as sb_client:
+                receiver = sb_client.get_queue_receiver(queue_name=AZURE_SERVICE_BUS_QUEUE)
+                async with receiver:
+                    async for msg in receiver:
+                        await handle_received_message(receiver, msg)
+                        if drain.draining:
+                            break
+        except Exception as e:
+            logger.error("service_bus_receiver_failed", error=str(e))
+            await asyncio.sleep(5)
+
+async def consume_sessions(worker: int):
+    """
+    Session mode: each worker locks one guest's session at a time and drains it in order, so two
+    messages from the same guest are never processed concurrently or out of order, while different
+    guests are handled in parallel by the other workers. The session is released after it has been
+    idle for SERVICE_BUS_SESSION_IDLE_SECONDS.
+    """
+    async with ServiceBusClient.from_connection_string(AZURE_SERVICE_BUS_CONN_STR) as sb_client:
+        while not drain.draining:
+            try:
+                receiver = sb_client.get_queue_receiver(
+                    queue_name=AZURE_SERVICE_BUS_QUEUE,
+                    session_id=NEXT_AVAILABLE_SESSION,
+                    max_wait_time=SERVICE_BUS_SESSION_IDLE_SECONDS
+                )
+                async with receiver:
+                    logger.debug("service_bus_session_acquired", worker=worker, session_id=receiver.session.session_id)
+                    async for msg in receiver:
+                        await handle_received_message(receiver, msg)
+                        if drain.draining:
+                            break
+            except OperationTimeoutError:
+                # No session had pending messages
+                continue
+            except Exception as e:
+                logger.error("service_bus_session_receiver_failed", worker=worker, error=str(e))
+                await asyncio.sleep(5)
+
+# --- Dead-Letter Queue ---
+class DeadLetterReplay(BaseModel):
+    max_messages: int = Field(10, ge=1, le=100)
+    message_ids: Optional[List[str]] = Field(None, description="Only replay these; others are left in the DLQ")
+
+def dead_letter_view(msg) -> dict:
+    return {
+        "message_id": msg.message_id,
+        "session_id": msg.session_id,
+        "enqueued_at": msg.enqueued_time_utc,
+        "delivery_count": msg.delivery_count,
+        "reason": msg.dead_letter_reason,
+        "description": msg.dead_letter_error_description,
+        "body": str(msg)[:2000]
+    }
+
+def require_service_bus():
+    if not AZURE_SERVICE_BUS_CONN_STR:
+        raise HTTPException(409, detail="Service Bus is not configured")
+
+@app.get("/api/v1/admin/dlq", dependencies=[Depends(require_service_bus)])
+async def peek_dead_letters(limit: int = Query(20, ge=1, le=100), user=Depends(require_admin)):
+    async with ServiceBusClient.from_connection_string(AZURE_SERVICE_BUS_CONN_STR) as sb_client:
+        receiver = sb_client.get_queue_receiver(queue_name=AZURE_SERVICE_BUS_QUEUE, sub_queue=ServiceBusSubQueue.DEAD_LETTER)
+        async with receiver:
+            messages = await receiver.peek_messages(max_message_count=limit)
+    return [dead_letter_view(msg) for msg in messages]
+
+@app.post("/api/v1/admin/dlq/replay", dependencies=[Depends(require_service_bus)])
+async def replay_dead_letters(data: DeadLetterReplay = Body(default=DeadLetterReplay()), user=Depends(require_admin)):
+    """Sends dead-lettered chat requests back to the main queue; the ledger still drops real duplicates."""
+    replayed, skipped = [], []
+    async with ServiceBusClient.from_connection_string(AZURE_SERVICE_BUS_CONN_STR) as sb_client:
+        receiver = sb_client.get_queue_receiver(queue_name=AZURE_SERVICE_BUS_QUEUE,
+                                                sub_queue=ServiceBusSubQueue.DEAD_LETTER, max_wait_time=5)
+        sender = sb_client.get_queue_sender(queue_name=AZURE_SERVICE_BUS_QUEUE)
+        async with receiver, sender:
+            for msg in await receiver.receive_messages(max_message_count=data.max_messages, max_wait_time=5):
+                if data.message_ids and msg.message_id not in data.message_ids:
+                    await receiver.abandon_message(msg)
+                    skipped.append(msg.message_id)
+                    continue
+                await sender.send_messages(ServiceBusMessage(
+                    str(msg), content_type=msg.content_type, message_id=msg.message_id,
+                    correlation_id=msg.correlation_id, session_id=msg.session_id,
+                    application_properties=msg.application_properties
+                ))
+                await receiver.complete_message(msg)
+                replayed.append(msg.message_id)
+    logger.info("dead_letters_replayed", count=len(replayed), admin=user.get("sub"))
+    return {"replayed": replayed, "skipped": skipped}
+
+# --- Guest Status ---
+@app.get("/api/v1/workorder/status/{request_id}")
+async def get_work_order_status(
+    request_id: str,
+    request: Request,
+    wait: Optional[str] = Query(None, description="Long-poll duration, e.g. 30s; returns early on change"),
+    since: Optional[datetime] = Query(None, description="Only return early for changes after this updated_at"),
+    user=Depends(verify_jwt)
+):
+    if is_order_number(request_id):
+        # Guests read order numbers off confirmations; carry on with that order's request ID
+        async with DatabaseConnection.get_connection() as conn:
+            numbered = await conn["virtualbutler"]["work_orders"].find_one(
+                {"order_number": normalize_order_number(request_id)}, {"request_id": 1})
+        request_id = numbered["request_id"] if numbered else request_id
+    doc = await find_work_order_by_request_id(request_id) or await find_queued_request(request_id)
+    if doc:
+        ensure_can_read_work_order(user, doc)
+    if wait:
+        doc = await wait_for_status_change(request_id, doc, parse_wait(wait), since)
+    ensure_can_read_work_order(user, doc)
+    # A long poll that times out with nothing new answers 304 to a client that sent its ETag
+    return conditional_response(request, serialize_status(doc), doc.get("updated_at"))
+
+async def find_work_order_by_request_id(request_id: str) -> Optional[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        return await conn["virtualbutler"]["work_orders"].find_one({"request_id": request_id})
+
+# The chatbot stores the chat request before publishing it, so until the consumer has created the order
+# the stored request stands in for it: the status reads "queued" rather than 404. Requests the chatbot
+# handled itself (bookings, wake-up calls, ...) never get an order and report their own status.
+QUEUED_STATUS = "queued"
+CHAT_REQUEST_STATUS_FIELDS = {"request_id": 1, "guest_id": 1, "department": 1, "status": 1, "created_at": 1, "updated_at": 1}
+
+def queued_placeholder(chat_request: dict) -> dict:
+    status = chat_request.get("status")
+    return {**chat_request, "status": QUEUED_STATUS if status in (None, StatusEnum.PENDING) else status,
+            "updated_at": chat_request.get("updated_at") or chat_request.get("created_at")}
+
+async def find_queued_request(request_id: str) -> Optional[dict]:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["chat_requests"].find_one({"request_id": request_id}, CHAT_REQUEST_STATUS_FIELDS)
+    return queued_placeholder(doc) if doc else None
+
+def parse_wait(value: str) -> float:
+    match = re.fullmatch(r"(\d+(?:\.\d+)?)(ms|s|m)?", value.strip())
+    if not match:
+        raise HTTPException(400, detail="wait must look like 500ms, 30s or 1m")
+    amount = float(match.group(1)) * {"ms": 0.001, "s": 1, "m": 60, None: 1}[match.group(2)]
+    return min(amount, LONG_POLL_MAX_SECONDS)
+
+def as_naive_utc(value: Optional[datetime]) -> Optional[datetime]:
+    if value is None or value.tzinfo is None:
+        return value
+    return value.astimezone(timezone.utc).replace(tzinfo=None)
+
+async def wait_for_status_change(request_id: str, initial: Optional[dict], timeout: float,
+                                 since: Optional[datetime] = None) -> Optional[dict]:
+    """Waits until the order is created or changes (status or updated_at), or the timeout elapses."""
+    def changed(current: Optional[dict]) -> bool:
+        if current is None:
+            return False
+        if since is not None:
+            return (as_naive_utc(current.get("updated_at")) or datetime.min) > as_naive_utc(since)
+        if initial is None:
+            return True
+        return (current.get("status"), current.get("updated_at")) != (initial.get("status"), initial.get("updated_at"))
+
+    loop = asyncio.get_running_loop()
+    deadline = loop.time() + timeout
+    current = initial
+    while not changed(current):
+        remaining = deadline - loop.time()
+        if remaining <= 0:
+            break
+        # Woken by local updates; the periodic re-check catches updates made by other replicas
+        await status_notifier.wait(request_id, min(remaining, LONG_POLL_RECHECK_SECONDS))
+        # Until the order exists, keep answering with the queued placeholder
+        current = await find_work_order_by_request_id(request_id) or current
+    return current
+
+@app.post("/api/v1/workorder/status/batch")
+async def get_work_order_status_batch(data: StatusBatchRequest, user=Depends(verify_jwt)):
+    """
+    Statuses for up to 100 requests (request IDs or order numbers) in one call; unknown or unreadable
+    IDs are listed in `not_found`.
+    """
+    request_ids = list(dict.fromkeys(data.request_ids))
+    numbers = {normalize_order_number(r): r for r in request_ids if is_order_number(r)}
+    scope = work_order_read_filter(user)
+    found = {}
+    if scope is not None:
+        async with DatabaseConnection.get_connection() as conn:
+            cursor = conn["virtualbutler"]["work_orders"].find({**scope, "$or": [
+                {"request_id": {"$in": request_ids}}, {"order_number": {"$in": list(numbers)}}
+            ]})
+            async for doc in cursor:
+                key = numbers.get(doc.get("order_number"), doc["request_id"])
+                found[key] = serialize_status(doc)
+            queued = [r for r in request_ids if r not in found]
+            if queued:
+                cursor = conn["virtualbutler"]["chat_requests"].find({**scope, "request_id": {"$in": queued}},
+                                                                     CHAT_REQUEST_STATUS_FIELDS)
+                async for doc in cursor:
+                    found[doc["request_id"]] = serialize_status(queued_placeholder(doc))
+    return {
+        "results": [found[r] for r in request_ids if r in found],
+        "not_found": [r for r in request_ids if r not in found]
+    }
+
+def serialize_status(doc: dict) -> dict:
+    return {
+        "request_id": doc.get("request_id"),
+        "work_order_id": doc.get("work_order_id"),
+        "order_number": doc.get("order_number"),
+        "status": doc.get("status"),
+        "department": doc.get("department"),
+        "estimated_duration": doc.get("estimated_duration"),
+        "progress": workflow_progress(doc["workflow"]) if doc.get("workflow") else None,
+        "subtasks": doc.get("subtasks"),
+        "updated_at": doc.get("updated_at")
+    }
+
+# --- Admin Corrections ---
+@app.post("/api/v1/admin/workorder/{work_order_id}/requeue", response_model=WorkOrder)
+async def requeue_work_order(work_order_id: WorkOrderRef, response: Response, version: IfMatch,
+                             data: WorkOrderRequeue = Body(default=WorkOrderRequeue()), user=Depends(require_admin)):
+    async with DatabaseConnection.get_connection() as conn:
+        current = await conn["virtualbutler"]["work_orders"].find_one({"work_order_id": work_order_id})
+        if not current:
+            raise HTTPException(404, detail="Work order not found")
+        if current.get("status") in (StatusEnum.COMPLETED, StatusEnum.CANCELLED):
+            raise HTTPException(409, detail=f"Cannot requeue a {current.get('status')} work order")
+        await check_subtask_gate(current, StatusEnum.PENDING)
+        doc = await conn["virtualbutler"]["work_orders"].find_one_and_update(
+            {"work_order_id": work_order_id, **version_filter(version)},
+            versioned({"$set": {"status": StatusEnum.PENDING, "assigned_staff": None, "assigned_at": None,
+                                "updated_at": datetime.now(timezone.utc)},
+                       "$inc": {"metadata.requeue_count": 1}}),
+            return_document=True
+        )
+    if not doc:
+        raise await update_conflict(work_order_id)
+    await record_activity(work_order_id, "requeued", user.get("sub"), {
+        "status": {"from": current.get("status"), "to": StatusEnum.PENDING},
+        "assigned_staff": {"from": current.get("assigned_staff"), "to": None}
+    }, data.reason)
+    logger.info("work_order_requeued", work_order_id=work_order_id, admin=user.get("sub"))
+    await notify_status_change({**doc, "event": "requeued"})
+    await domain_events.publish(StatusChanged.from_work_order(doc, current.get("status"), user.get("sub")))
+    if doc.get("parent_id"):
+        await refresh_parent(doc["parent_id"])
+    return with_etag(response, doc)
+
+@app.patch("/api/v1/admin/workorder/{work_order_id}", response_model=WorkOrder)
+async def correct_work_order(work_order_id: WorkOrderRef, data: WorkOrderCorrection, response: Response,
+                             version: IfMatch, user=Depends(require_admin)):
+    corrections = {k: v for k, v in data.dict(exclude={"reason"}).items() if v is not None}
+    if not corrections:
+        raise HTTPException(400, detail="Nothing to correct")
+    async with DatabaseConnection.get_connection() as conn:
+        current = await conn["virtualbutler"]["work_orders"].find_one({"work_order_id": work_order_id})
+        if not current:
+            raise HTTPException(404, detail="Work order not found")
+        if "guest_id" in corrections and not await conn["virtualbutler"]["guest_profiles"].find_one(
+                {"guest_id": corrections["guest_id"]}):
+            raise HTTPException(400, detail="Unknown guest")
+        changes = {k: {"from": current.get(k), "to": v} for k, v in corrections.items() if current.get(k) != v}
+        if not changes:
+            return with_etag(response, current)
+        update = {**{k: v["to"] for k, v in changes.items()}, "updated_at": datetime.now(timezone.utc)}
+        if "department" in changes:
+            # A re-routed order goes back to the new department's queue
+            await check_subtask_gate(current, StatusEnum.PENDING)
+            update.update({"status": StatusEnum.PENDING, "assigned_staff": None, "assigned_at": None})
+        doc = await conn["virtualbutler"]["work_orders"].find_one_and_update(
+            {"work_order_id": work_order_id, **version_filter(version)},
+            versioned({"$set": update}),
+            return_document=True
+        )
+    if not doc:
+        raise await update_conflict(work_order_id)
+    await record_activity(work_order_id, "corrected", user.get("sub"), changes, data.reason)
+    logger.info("work_order_corrected", work_order_id=work_order_id, fields=list(changes), admin=user.get("sub"))
+    await notify_status_change({**doc, "event": "rerouted" if "department" in changes else "corrected"})
+    if "department" in changes or doc["status"] != current["status"]:
+        await domain_events.publish(StatusChanged.from_work_order(doc, current["status"], user.get("sub")))
+    if doc.get("parent_id") and doc["status"] != current["status"]:
+        await refresh_parent(doc["parent_id"])
+    return with_etag(response, doc)
+
+@app.put("/api/v1/admin/guests/{guest_id}/quota")
+async def update_guest_quota(guest_id: str, data: GuestQuotaUpdate, user=Depends(require_admin)):
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["guest_profiles"].update_one(
+            {"guest_id": guest_id},
+            {"$set": {"open_order_limit": data.open_order_limit}}
+        )
+    if result.matched_count == 0:
+        raise HTTPException(404, detail="Guest not found")
+    logger.info("guest_quota_updated", guest_id=guest_id, open_order_limit=data.open_order_limit, admin=user.get("sub"))
+    allowed, count, limit = await check_open_order_quota(guest_id)
+    return {"guest_id": guest_id, "open_order_limit": limit, "open_orders": count, "allowed": allowed}
+
+@app.put("/api/v1/guests/{guest_id}/loyalty")
+async def update_guest_loyalty(guest_id: str, data: GuestLoyaltyUpdate,
+                               user=Depends(auth.require("guests:write", roles=("admin",)))):
+    """Called by the PMS when a guest's loyalty tier is known or changes; applies to orders created from now on."""
+    if not await set_guest_tier(guest_id, data.loyalty_tier):
+        raise HTTPException(404, detail="Guest not found")
+    return {"guest_id": guest_id, "loyalty_tier": await guest_tier(guest_id)}
+
+@app.get("/api/v1/admin/workorder/{work_order_id}/activity")
+async def get_work_order_activity(work_order_id: WorkOrderRef, user=Depends(require_admin)):
+    async with DatabaseConnection.get_connection() as conn:
+        cursor = conn["virtualbutler"]["work_order_activity"].find({"work_order_id": work_order_id}).sort("timestamp", 1)
+        entries = []
+        async for doc in cursor:
+            doc.pop("_id", None)
+            entries.append(doc)
+    return {"work_order_id": work_order_id, "activity": entries}
+
+@app.get("/api/v1/admin/workorder/{work_order_id}/events")
+async def get_work_order_events(work_order_id: WorkOrderRef, user=Depends(require_admin)):
+    try:
+        events = await load_stream(work_order_id)
+    except EventStoreError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    if not events:
+        raise HTTPException(404, detail="Work order not found")
+    return {"work_order_id": work_order_id, "events": events}
+
+@app.get("/api/v1/admin/workorder/{work_order_id}/as-of", response_model=WorkOrder)
+async def get_work_order_as_of(work_order_id: WorkOrderRef, at: datetime = Query(..., description="ISO 8601 moment"),
+                               user=Depends(require_admin)):
+    try:
+        state = await state_as_of(work_order_id, at)
+    except EventStoreError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    if not state:
+        raise HTTPException(404, detail="Work order did not exist at that time")
+    return WorkOrder(**state)
+
+@app.post("/api/v1/admin/event-store/rebuild")
+async def rebuild_work_order_projections(user=Depends(require_admin)):
+    try:
+        result = await rebuild_projections()
+    except EventStoreError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    logger.info("work_order_projections_rebuilt", admin=user.get("sub"), **result)
+    return {"persistence": WORK_ORDER_PERSISTENCE, **result}
+
+# --- Change Streams ---
+def status_event_from_document(doc: dict) -> dict:
+    return {
+        "request_id": doc.get("request_id"),
+        "work_order_id": doc.get("work_order_id"),
+        "guest_id": doc.get("guest_id"),
+        "department": doc.get("department"),
+        "status": doc.get("status"),
+        "updated_at": doc.get("updated_at")
+    }
+
+async def watch_work_order_changes():
+    """
+    Tails the work_orders change stream and publishes status transitions (inserts and updates that
+    touch `status`) to the in-process event bus. The resume token is persisted so a restart continues
+    where it left off. Requires a replica set; on standalone Mongo the service falls back to the
+    local notifications emitted by notify_status_change (which also publishes to the event bus
+    whenever the stream isn't running, so SSE clients still get status changes).
+    """
+    global change_stream_active
+    pipeline = [{"$match": {"$or": [
+        {"operationType": {"$in": ["insert", "replace"]}},
+        {"operationType": "update", "updateDescription.updatedFields.status": {"$exists": True}}
+    ]}}]
+    while True:
+        try:
+            async with DatabaseConnection.get_connection() as conn:
+                state = conn["virtualbutler"]["change_stream_state"]
+                saved = await state.find_one({"_id": "work_orders"})
+                async with conn["virtualbutler"]["work_orders"].watch(
+                    pipeline,
+                    full_document="updateLookup",
+                    resume_after=saved.get("resume_token") if saved else None
+                ) as stream:
+                    logger.info("work_order_change_stream_started", resumed=bool(saved))
+                    change_stream_active = True
+                    async for change in stream:
+                        doc = change.get("fullDocument")
+                        if doc:
+                            event = status_event_from_document(doc)
+                            status_notifier.publish(event["request_id"])
+                            work_order_events.publish(event)
+                        await state.update_one(
+                            {"_id": "work_orders"},
+                            {"$set": {"resume_token": stream.resume_token, "updated_at": datetime.now(timezone.utc)}},
+                            upsert=True
+                        )
+        except OperationFailure as e:
+            change_stream_active = False
+            if e.code in (40573, 40324):  # change streams unsupported (standalone / no replica set)
+                logger.warning("change_streams_unavailable", error=str(e))
+                return
+            if e.code == 286:  # resume token fell off the oplog; start fresh
+                async with DatabaseConnection.get_connection() as conn:
+                    await conn["virtualbutler"]["change_stream_state"].delete_one({"_id": "work_orders"})
+            logger.error("work_order_change_stream_failed", error=str(e))
+            await asyncio.sleep(5)
+        except Exception as e:
+            change_stream_active = False
+            logger.error("work_order_change_stream_failed", error=str(e))
+            await asyncio.sleep(5)
+
+@app.get("/api/v1/workorder/events")
+async def stream_work_order_events(request: Request, user=Depends(verify_jwt)):
+    """Server-Sent Events stream of status transitions for the orders the caller may read."""
+    queue = work_order_events.subscribe()
+
+    async def event_stream():
+        try:
+            yield ": connected\n\n"
+            while not await request.is_disconnected():
+                try:
+                    event = await asyncio.wait_for(queue.get(), timeout=SSE_KEEPALIVE_SECONDS)
+                except asyncio.TimeoutError:
+                    yield ": keep-alive\n\n"
+                    continue
+                if can_read_work_order(user, event):
+                    yield f"event: status_changed\ndata: {json.dumps(event, default=str)}\n\n"
+        finally:
+            work_order_events.unsubscribe(queue)
+
+    return StreamingResponse(event_stream(), media_type="text/event-stream",
+                             headers={"Cache-Control": "no-cache", "X-Accel-Buffering": "no"})
+
+# --- Routing Rules Administration ---
+@app.get("/api/v1/admin/routing-rules", response_model=List[RoutingRuleSet])
+async def get_routing_rulesets(user=Depends(require_admin)):
+    return await list_rulesets()
+
+@app.post("/api/v1/admin/routing-rules", response_model=RoutingRuleSet, status_code=201)
+async def create_routing_ruleset(data: RuleSetCreate, user=Depends(require_admin)):
+    if not data.rules:
+        raise HTTPException(400, detail="A ruleset needs at least one rule")
+    return await create_ruleset(data.rules, created_by=user.get("sub"), notes=data.notes, synonyms=data.synonyms)
+
+@app.post("/api/v1/admin/routing-rules/{version}/rollout", response_model=RoutingRuleSet)
+async def rollout_routing_ruleset(version: int, data: RuleSetRollout, user=Depends(require_admin)):
+    try:
+        ruleset = await start_rollout(version, data.mode, data.percent)
+    except RuleSetError as e:
+        raise HTTPException(409, detail=str(e))
+    await routing_rules.refresh()
+    return ruleset
+
+@app.post("/api/v1/admin/routing-rules/{version}/promote", response_model=RoutingRuleSet)
+async def promote_routing_ruleset(version: int, user=Depends(require_admin)):
+    try:
+        ruleset = await promote_ruleset(version)
+    except RuleSetError as e:
+        raise HTTPException(409, detail=str(e))
+    await routing_rules.refresh()
+    return ruleset
+
+@app.post("/api/v1/admin/routing-rules/rollback")
+async def rollback_routing_rules(user=Depends(require_admin)):
+    try:
+        ruleset = await rollback_ruleset()
+    except RuleSetError as e:
+        raise HTTPException(409, detail=str(e))
+    await routing_rules.refresh()
+    logger.info("routing_rules_rolled_back", admin=user.get("sub"))
+    return {"active_version": ruleset.version if ruleset else 0, "builtin": ruleset is None}
+
+@app.post("/api/v1/admin/routing-rules/reload")
+async def reload_routing_rules(user=Depends(require_admin)):
+    """Re-reads the rulesets now on this replica; the others pick changes up on their next refresh."""
+    await routing_rules.refresh()
+    return {"active_version": routing_rules.stable.version if routing_rules.stable else 0,
+            "candidate_version": routing_rules.candidate.version if routing_rules.candidate else None}
+
+@app.post("/api/v1/admin/routing-rules/from-corrections", response_model=RoutingRuleSet, status_code=201)
+async def create_ruleset_from_corrections(user=Depends(require_admin)):
+    """Drafts a ruleset with a phrase rule per repeated correction ahead of the current rules; roll it out as usual."""
+    corrections = [c for c in await list_corrections() if c.get("ruleset_version") is None]
+    learned = phrase_rules(corrections)
+    if not learned:
+        raise HTTPException(409, detail="No phrase has been corrected often enough to become a rule")
+    current = routing_rules.stable.rules if routing_rules.stable else routing_rules.builtin
+    ruleset = await create_ruleset(learned + current, created_by=user.get("sub"),
+                                   notes=f"{len(learned)} phrase rules from {len(corrections)} routing corrections",
+                                   synonyms=routing_rules.stable.synonyms if routing_rules.stable else None)
+    await mark_used(corrections, ruleset.version)
+    logger.info("routing_ruleset_drafted_from_corrections", version=ruleset.version, rules=len(learned))
+    return ruleset
+
+# --- Routing Feedback ---
+@app.post("/api/v1/workorder/{work_order_id}/misrouted", response_model=WorkOrder)
+async def mark_misrouted(work_order_id: WorkOrderRef, data: MisroutingReport, response: Response, version: IfMatch,
+                         user=Depends(require_staff)):
+    """Records the right department for routing feedback and, while the order is still open, moves it there."""
+    async with DatabaseConnection.get_connection() as conn:
+        current = await conn["virtualbutler"]["work_orders"].find_one({"work_order_id": work_order_id})
+    if not current:
+        raise HTTPException(404, detail="Work order not found")
+    if current["department"] == data.department.value:
+        raise HTTPException(400, detail="The work order is already in that department")
+    doc = current
+    if current.get("status") not in (StatusEnum.COMPLETED, StatusEnum.CANCELLED):
+        async with DatabaseConnection.get_connection() as conn:
+            doc = await conn["virtualbutler"]["work_orders"].find_one_and_update(
+                {"work_order_id": work_order_id, **version_filter(version)},
+                versioned({"$set": {"department": data.department, "status": StatusEnum.PENDING, "assigned_staff": None,
+                                    "assigned_at": None, "updated_at": datetime.now(timezone.utc)}}),
+                return_document=True
+            )
+        if not doc:
+            raise await update_conflict(work_order_id)
+    await record_correction(current, data.department, user.get("sub"), data.reason)
+    await record_activity(work_order_id, "misrouted", user.get("sub"),
+                          {"department": {"from": current["department"], "to": data.department.value}}, data.reason)
+    if doc is not current:
+        await notify_status_change({**doc, "event": "rerouted"})
+        await domain_events.publish(StatusChanged.from_work_order(doc, current["status"], user.get("sub")))
+    return with_etag(response, doc)
+
+@app.get("/api/v1/admin/routing/misrouting-report")
+async def get_misrouting_report(start: Optional[datetime] = None, end: Optional[datetime] = None,
+                                user=Depends(require_staff)):
+    """Routing accuracy for orders created in [start, end); defaults to the last 30 days."""
+    end = end or datetime.now(timezone.utc)
+    return await misrouting_report(start or end - timedelta(days=30), end)
+
+@app.get("/api/v1/admin/routing/corrections")
+async def get_routing_corrections(start: Optional[datetime] = None, end: Optional[datetime] = None,
+                                  limit: int = Query(100, ge=1, le=1000), user=Depends(require_admin)):
+    return await list_corrections(start, end, limit)
+
+@app.get("/api/v1/admin/routing/training-data")
+async def get_routing_training_data(user=Depends(require_admin)):
+    """Corrections as labelled utterances, ready to import into the CLU project."""
+    return {"utterances": clu_utterances(await list_corrections())}
+
+# --- Do-Not-Disturb ---
+async def notify_dnd_released(released: List[dict]):
+    for doc in released:
+        await notify_status_change({**doc, "event": "dnd_released"})
+        await domain_events.publish(StatusChanged.from_work_order(doc, StatusEnum.ON_HOLD))
+
+@app.put("/rooms/{room_number}/dnd")
+async def update_room_dnd(room_number: str, update: RoomDndUpdate, user=Depends(auth.require("rooms:write", roles=None))):
+    # Guests may only toggle DND for their own room; the PMS may set it for any
+    if user.get("role") not in ("staff", "admin", INTEGRATION_ROLE) and user.get("room") != room_number:
+        raise HTTPException(403, detail="Insufficient privileges")
+    room = await set_room_dnd(room_number, update.active, set_by=user.get("sub"))
+    released = []
+    if not update.active:
+        released = await release_dnd_holds(room_number)
+        await notify_dnd_released(released)
+    return {"room_number": room_number, "dnd_active": room.get("dnd_active"), "released_orders": len(released)}
+
+@app.get("/rooms/{room_number}/dnd")
+async def get_room_dnd(room_number: str, user=Depends(verify_jwt)):
+    if user.get("role") not in ("staff", "admin") and user.get("room") != room_number:
+        raise HTTPException(403, detail="Insufficient privileges")
+    return {"room_number": room_number, "dnd_active": await is_room_dnd(room_number)}
+
+# --- Room Devices ---
+@app.get("/rooms/{room_number}/devices")
+async def get_room_devices(room_number: str, user=Depends(require_staff)):
+    devices = await room_devices(room_number)
+    return {"room_number": room_number, "smart_room": bool(devices), "devices": devices}
+
+@app.put("/rooms/{room_number}/devices")
+async def update_room_devices(room_number: str, update: RoomDevicesUpdate, user=Depends(require_admin)):
+    try:
+        room = await set_room_devices(room_number, update.smart_room, update.devices)
+    except ValueError as e:
+        raise HTTPException(422, detail=str(e))
+    return {"room_number": room_number, "smart_room": room.get("smart_room"), "devices": room.get("devices")}
+
+@app.post("/rooms/{room_number}/devices/commands", status_code=202)
+async def send_room_device_command(room_number: str, command: DeviceCommand, user=Depends(require_staff)):
+    if not await actuate(room_number, command):
+        raise HTTPException(409, detail=f"Room {room_number} cannot control '{command.device}' remotely")
+    return {"room_number": room_number, "sent": True}
+
+dnd_release_lease = Lease("dnd_release", DND_RELEASE_INTERVAL_SECONDS)
+
+async def dnd_release_loop():
+    # Catches DND flags cleared outside this service (e.g. by the chatbot)
+    while True:
+        await asyncio.sleep(DND_RELEASE_INTERVAL_SECONDS)
+        try:
+            released = await dnd_release_lease.run(release_dnd_holds)
+            if released:
+                await notify_dnd_released(released)
+        except Exception as e:
+            logger.error("dnd_release_failed", error=str(e))
+
+# --- Wake-up Calls ---
+async def load_wake_up_call(call_id: str, user: dict) -> dict:
+    try:
+        doc = await get_wake_up_call(call_id)
+    except WakeUpCallError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    # Guests only see their own calls; 404 rather than 403 so ids can't be probed
+    if user.get("role") not in ("staff", "admin") and doc.get("guest_id") != user.get("sub"):
+        raise HTTPException(404, detail="Wake-up call not found")
+    return doc
+
+@app.post("/api/v1/wakeup-calls", response_model=WakeUpCall, status_code=201)
+async def create_wake_up_call(data: WakeUpCallCreate, user=Depends(verify_jwt)):
+    guest_id = resolve_guest_id(user, data.guest_id)
+    room_number = data.room_number if user.get("role") in ("staff", "admin") else user.get("room")
+    if not room_number:
+        raise HTTPException(422, detail="room_number is required")
+    tz = hotel_timezone()
+    wake_at = data.wake_at if data.wake_at.tzinfo else data.wake_at.replace(tzinfo=tz)
+    try:
+        return await schedule_wake_up_call(guest_id, room_number, wake_at, tz.key,
+                                           language=data.language, created_by=user.get("sub"))
+    except WakeUpCallError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.get("/api/v1/wakeup-calls", response_model=List[WakeUpCall])
+async def get_wake_up_calls(room_number: Optional[str] = None, status: Optional[WakeUpCallStatusEnum] = None,
+                            user=Depends(verify_jwt)):
+    if user.get("role") in ("staff", "admin"):
+        return await list_wake_up_calls(room_number=room_number, status=status)
+    return await list_wake_up_calls(guest_id=user.get("sub"), status=status)
+
+@app.delete("/api/v1/wakeup-calls/{call_id}", status_code=204)
+async def delete_wake_up_call(call_id: str, user=Depends(verify_jwt)):
+    call = await load_wake_up_call(call_id, user)
+    if not await cancel_wake_up_calls(call["guest_id"], call_id):
+        raise HTTPException(409, detail=f"Wake-up call is already {call['status']}")
+
+@app.post("/api/v1/wakeup-calls/{call_id}/confirm", response_model=WakeUpCall)
+async def confirm_wake_up_call_in_app(call_id: str, user=Depends(verify_jwt)):
+    await load_wake_up_call(call_id, user)
+    confirmed = await confirm_wake_up_call("app", call_id=call_id)
+    if not confirmed:
+        raise HTTPException(409, detail="Wake-up call is not ringing")
+    return confirmed
+
+def verify_internal_token(x_internal_token: Optional[str] = Header(None)):
+    if not INTERNAL_EVENTS_TOKEN or x_internal_token != INTERNAL_EVENTS_TOKEN:
+        raise HTTPException(status_code=401, detail="Invalid internal token")
+
+@app.post("/internal/wakeup-calls/{call_id}/confirm", status_code=202)
+async def confirm_wake_up_call_from_gateway(call_id: str, _=Depends(verify_internal_token)):
+    """Called by the voice gateway / room device when the guest answers or dismisses the alarm."""
+    confirmed = await confirm_wake_up_call("gateway", call_id=call_id)
+    return {"confirmed": bool(confirmed)}
+
+capacity_monitor = CapacityMonitor()
+
+# --- Zones and staff location ---
+@app.get("/zones", response_model=List[Zone])
+async def get_zones(user=Depends(require_staff)):
+    return await list_zones()
+
+@app.put("/zones/{zone_id}", response_model=Zone)
+async def put_zone(zone_id: str, zone: Zone, user=Depends(require_admin)):
+    if zone.zone_id != zone_id:
+        raise HTTPException(400, detail="zone_id in the body must match the URL")
+    return await save_zone(zone)
+
+@app.delete("/zones/{zone_id}", status_code=204)
+async def remove_zone(zone_id: str, user=Depends(require_admin)):
+    try:
+        await delete_zone(zone_id)
+    except ZoneError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+# --- Venue map (off-site areas and service hubs, see shared/venue_map.py) ---
+@app.get("/venue/areas", response_model=List[VenueArea])
+async def get_venue_areas(user=Depends(require_staff)):
+    return await list_areas()
+
+@app.put("/venue/areas/{area_id}", response_model=VenueArea)
+async def put_venue_area(area_id: str, area: VenueArea, user=Depends(require_admin)):
+    if area.area_id != area_id:
+        raise HTTPException(400, detail="area_id in the body must match the URL")
+    return await save_area(area)
+
+@app.delete("/venue/areas/{area_id}", status_code=204)
+async def remove_venue_area(area_id: str, user=Depends(require_admin)):
+    try:
+        await delete_area(area_id)
+    except VenueMapError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.get("/venue/hubs", response_model=List[ServiceHub])
+async def get_service_hubs(user=Depends(require_staff)):
+    return await list_hubs()
+
+@app.put("/venue/hubs/{hub_id}", response_model=ServiceHub)
+async def put_service_hub(hub_id: str, hub: ServiceHub, user=Depends(require_admin)):
+    if hub.hub_id != hub_id:
+        raise HTTPException(400, detail="hub_id in the body must match the URL")
+    return await save_hub(hub)
+
+@app.delete("/venue/hubs/{hub_id}", status_code=204)
+async def remove_service_hub(hub_id: str, user=Depends(require_admin)):
+    try:
+        await delete_hub(hub_id)
+    except VenueMapError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.put("/staff/me/location")
+async def put_my_location(update: StaffLocationUpdate, user=Depends(require_staff)):
+    """Called by the staff app when an attendant starts or ends a shift or moves to another zone."""
+    try:
+        doc = await update_staff_location(user.get("sub"), update)
+    except ZoneError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    return {"staff_id": doc["staff_id"], "on_shift": doc.get("on_shift", False), "current_zone": doc.get("current_zone"),
+            "zone_updated_at": doc.get("zone_updated_at")}
+
+@app.post("/staff/me/heartbeat")
+async def post_my_heartbeat(heartbeat: Heartbeat, user=Depends(require_staff)):
+    """Sent by the staff app every STAFF_HEARTBEAT_SECONDS while it is open (shared/presence.py)."""
+    try:
+        doc = await record_heartbeat(user.get("sub"), heartbeat)
+    except PresenceError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    return {"staff_id": doc["staff_id"], "online": True, "last_seen_at": doc["last_seen_at"],
+            "heartbeat_seconds": STAFF_HEARTBEAT_SECONDS}
+
+@app.delete("/staff/me/sessions/{session_id}", status_code=204)
+async def sign_out_session(session_id: str, user=Depends(require_staff)):
+    try:
+        await end_session(user.get("sub"), session_id)
+    except PresenceError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.get("/staff/presence")
+async def get_staff_presence(department: Optional[DepartmentEnum] = None, user=Depends(require_staff)):
+    """Who is on shift and who is actually online, for the dispatcher."""
+    return await list_presence(department.value if department else None)
+
+async def flag_offline_assignee(order: dict, staff_id: str, now: datetime) -> None:
+    """Work already started stays with its assignee; the dispatcher is told once that they went offline."""
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["work_orders"].find_one_and_update(
+            {"work_order_id": order["work_order_id"], "assigned_staff": staff_id, "status": StatusEnum.IN_PROGRESS,
+             "metadata.assignee_offline": {"$ne": staff_id}},
+            versioned({"$set": {"metadata.assignee_offline": staff_id, "metadata.assignee_offline_at": now,
+                                "updated_at": now}}),
+            return_document=True
+        )
+    if not doc:
+        return
+    await record_activity(order["work_order_id"], "assignee_offline", None,
+                          reason=f"{staff_id} offline for over {STAFF_REASSIGN_AFTER_MINUTES} minutes mid-job")
+    logger.warning("work_order_assignee_offline", work_order_id=order["work_order_id"], staff_id=staff_id)
+    await notify_status_change({**doc, "event": "assignee_offline"})
+
+async def reassign_from_offline_staff(now: datetime) -> int:
+    """
+    Hands assigned orders of staff offline past STAFF_REASSIGN_AFTER_MINUTES on, or back to the queue.
+    Orders they already started are flagged to the dispatcher instead, since someone may be in the room.
+    """
+    moved = 0
+    for staff_id in await offline_staff(now):
+        async with DatabaseConnection.get_connection() as conn:
+            orders = await conn["virtualbutler"]["work_orders"].find(
+                {"assigned_staff": staff_id, "status": {"$in": OPEN_ASSIGNED_STATUSES}}
+            ).to_list(length=None)
+        for order in orders:
+            if order["status"] == StatusEnum.IN_PROGRESS:
+                await flag_offline_assignee(order, staff_id, now)
+                continue
+            new_staff = await pick_attendant(order["department"], order.get("metadata", {}).get("room_number"), now)
+            changes = ({"assigned_staff": new_staff, "status": StatusEnum.ASSIGNED, "assigned_at": now}
+                       if new_staff else {"assigned_staff": None, "status": StatusEnum.PENDING})
+            async with DatabaseConnection.get_connection() as conn:
+                doc = await conn["virtualbutler"]["work_orders"].find_one_and_update(
+                    {"work_order_id": order["work_order_id"], "assigned_staff": staff_id, "status": order["status"]},
+                    versioned({"$set": {**changes, "updated_at": now}}),
+                    return_document=True
+                )
+            if not doc:
+                continue
+            moved += 1
+            await record_activity(order["work_order_id"], "reassigned_from_offline_staff", None,
+                                  {"assigned_staff": {"from": staff_id, "to": new_staff}},
+                                  f"offline for over {STAFF_REASSIGN_AFTER_MINUTES} minutes")
+            logger.info("work_order_reassigned_from_offline_staff", work_order_id=order["work_order_id"],
+                        from_staff=staff_id, to_staff=new_staff)
+            if new_staff:
+                await domain_events.publish(WorkOrderAssigned(work_order_id=order["work_order_id"],
+                                                              department=order["department"], assigned_staff=new_staff))
+            if doc["status"] != order["status"]:
+                await domain_events.publish(StatusChanged.from_work_order(doc, order["status"]))
+            await notify_status_change(doc)
+    return moved
+
+staff_presence_lease = Lease("staff_presence", STAFF_PRESENCE_CHECK_SECONDS)
+
+async def staff_presence_loop():
+    while True:
+        await asyncio.sleep(STAFF_PRESENCE_CHECK_SECONDS)
+        try:
+            moved = await staff_presence_lease.run(lambda: reassign_from_offline_staff(datetime.now(timezone.utc)))
+            if moved:
+                logger.info("offline_staff_orders_reassigned", count=moved)
+        except Exception as e:
+            logger.error("staff_presence_check_failed", error=str(e))
+
+@app.get("/internal/capacity")
+async def get_capacity_internal(_=Depends(verify_internal_token)):
+    """Per-department queue depth and expected wait; the chatbot checks it before acknowledging a request."""
+    return {"departments": await capacity_monitor.current(datetime.now(timezone.utc))}
+
+@app.get("/api/v1/admin/capacity")
+async def get_capacity(user=Depends(require_staff)):
+    return {"departments": await capacity_monitor.current(datetime.now(timezone.utc))}
+
+# --- Business Hours ---
+@app.get("/api/v1/admin/business-hours")
+async def get_business_calendars(user=Depends(require_staff)):
+    now = datetime.now(timezone.utc)
+    return {"calendars": [{**c.model_dump(mode="json"), "open_now": is_open(c, now), "next_open": next_open(c, now)}
+                          for c in await list_calendars()]}
+
+@app.put("/api/v1/admin/business-hours/{department}", response_model=BusinessCalendar)
+async def put_business_calendar(department: DepartmentEnum, calendar: BusinessCalendar, user=Depends(require_admin)):
+    if calendar.department != department:
+        raise HTTPException(400, detail="Department in the path and body must match")
+    try:
+        return await save_calendar(calendar)
+    except BusinessHoursError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.delete("/api/v1/admin/business-hours/{department}", status_code=204)
+async def delete_business_calendar(department: DepartmentEnum, user=Depends(require_admin)):
+    try:
+        await delete_calendar(department.value)
+    except BusinessHoursError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+# --- Dashboards (read models, see shared/read_models.py) ---
+read_model_projector = ReadModelProjector(domain_events.bus)
+
+@app.get("/api/v1/admin/dashboard/departments")
+async def get_department_dashboard(user=Depends(require_staff)):
+    return {"departments": await department_dashboard(datetime.now(timezone.utc))}
+
+@app.get("/api/v1/admin/dashboard/sla-timers")
+async def get_sla_timers(department: Optional[DepartmentEnum] = None, limit: int = Query(50, ge=1, le=500),
+                         user=Depends(require_staff)):
+    return {"timers": await sla_timers(datetime.now(timezone.utc), department.value if department else None, limit)}
+
+@app.get("/api/v1/admin/dashboard/queue")
+async def get_work_queue(department: Optional[DepartmentEnum] = None, limit: int = Query(50, ge=1, le=500),
+                         user=Depends(require_staff)):
+    """Unassigned orders in the order they should be taken: priority, then loyalty tier, then waiting time."""
+    query = {"status": StatusEnum.PENDING, "parent_id": None}
+    if department:
+        query["department"] = department.value
+    async with DatabaseConnection.get_connection() as conn:
+        orders = await conn["virtualbutler"]["work_orders"].find(query, {"_id": 0}).to_list(length=None)
+    return {"orders": sorted(orders, key=queue_key)[:limit]}
+
+@app.get("/api/v1/admin/dashboard/leaderboard")
+async def get_leaderboard(days: int = Query(7, ge=1, le=90), department: Optional[DepartmentEnum] = None,
+                          limit: int = Query(10, ge=1, le=100), user=Depends(require_staff)):
+    today = datetime.now(timezone.utc).date()
+    start = (today - timedelta(days=days - 1)).isoformat()
+    return {"start": start, "end": today.isoformat(),
+            "staff": await leaderboard(start, today.isoformat(), department.value if department else None, limit)}
+
+@app.post("/api/v1/admin/read-models/rebuild")
+async def rebuild_dashboard_read_models(user=Depends(require_admin)):
+    result = await rebuild_read_models()
+    logger.info("read_models_rebuild_requested", admin=user.get("sub"), **result)
+    return result
+
+async def escalate_wake_up_call(call: dict, reason: str):
+    """Creates a high-priority front-desk order so someone calls or knocks on the door."""
+    now = datetime.now(timezone.utc)
+    local_time = format_local(call["scheduled_for"], tz=resolve_timezone(call.get("timezone")))
+    detail = (f"not confirmed after {call.get('attempts', 0)} attempts" if reason == "not_confirmed"
+              else "could not be delivered")
+    work_order = WorkOrder(
+        request_id=call["call_id"],
+        work_order_id=f"wo_{now.timestamp()}",
+        order_number=await next_order_number(DepartmentEnum.FRONT_DESK),
+        guest_id=call["guest_id"],
+        department=DepartmentEnum.FRONT_DESK,
+        description=f"Wake-up call for room {call['room_number']} at {local_time} was {detail}. Please call or visit the room.",
+        priority=PriorityEnum.HIGH,
+        created_at=now,
+        updated_at=now,
+        tags=["wake_up_call"],
+        metadata={"room_number": call["room_number"], "wake_up_call_id": call["call_id"], "escalation_reason": reason}
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["work_orders"].insert_one(work_order.model_dump(by_alias=True))
+    await mark_escalated(call["call_id"], work_order.work_order_id)
+    logger.warning("wake_up_call_escalated", call_id=call["call_id"], room_number=call["room_number"],
+                   reason=reason, work_order_id=work_order.work_order_id)
+    await notify_status_change(work_order.model_dump())
+
+async def process_wake_up_calls():
+    now = datetime.now(timezone.utc)
+    while call := await claim_missed_call(now):
+        await escalate_wake_up_call(call, "not_confirmed")
+    while call := await claim_due_call(now):
+        callback_url = f"{WAKEUP_CALLBACK_BASE_URL}/{call['call_id']}/confirm" if WAKEUP_CALLBACK_BASE_URL else None
+        if not await ring_room(call, callback_url):
+            # Nothing could ring the room; a person has to do it rather than wait for the retry
+            await escalate_wake_up_call(call, "delivery_failed")
+
+wake_up_call_lease = Lease("wake_up_calls", WAKEUP_POLL_SECONDS)
+
+async def wake_up_call_loop():
+    while True:
+        await asyncio.sleep(WAKEUP_POLL_SECONDS)
+        try:
+            await wake_up_call_lease.run(process_wake_up_calls)
+        except Exception as e:
+            logger.error("wake_up_calls_failed", error=str(e))
+
+# --- Emergency Incidents ---
+INCIDENT_REALERT_POLL_SECONDS = int(os.getenv("INCIDENT_REALERT_POLL_SECONDS", "15"))
+
+class IncidentCreate(BaseModel):
+    incident_type: IncidentTypeEnum
+    message: str = Field(..., min_length=1, max_length=1000)
+    room_number: Optional[str] = None
+    location: Optional[str] = None
+
+class IncidentStatusUpdate(BaseModel):
+    status: IncidentStatusEnum
+    note: Optional[str] = Field(None, max_length=1000)
+
+class IncidentNote(BaseModel):
+    note: str = Field(..., min_length=1, max_length=1000)
+
+@app.post("/incidents", response_model=Incident, status_code=201)
+async def report_incident(data: IncidentCreate, user=Depends(require_staff)):
+    """Staff-raised incidents (e.g. a fire panel alarm relayed by the front desk) take the same alert path as chat."""
+    doc = await open_incident(data.incident_type.value, data.message, room_number=data.room_number,
+                              location=data.location, source=f"staff:{user.get('sub')}")
+    await domain_events.publish(IncidentOpened.from_incident(doc))
+    return Incident(**doc)
+
+@app.get("/incidents", response_model=List[Incident])
+async def get_incidents(status: Optional[IncidentStatusEnum] = None, active: bool = False,
+                        limit: int = Query(100, ge=1, le=500), user=Depends(require_staff)):
+    return await list_incidents(status.value if status else None, active_only=active, limit=limit)
+
+@app.get("/incidents/{incident_id}", response_model=Incident)
+async def get_incident_detail(incident_id: str, user=Depends(require_staff)):
+    try:
+        return Incident(**await get_incident(incident_id))
+    except IncidentError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.post("/incidents/{incident_id}/status", response_model=Incident)
+async def change_incident_status(incident_id: str, update: IncidentStatusUpdate, user=Depends(require_staff)):
+    try:
+        doc = await update_incident_status(incident_id, update.status.value, user.get("sub"), update.note)
+    except IncidentError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    return Incident(**doc)
+
+@app.post("/incidents/{incident_id}/notes", response_model=Incident)
+async def add_note_to_incident(incident_id: str, data: IncidentNote, user=Depends(require_staff)):
+    try:
+        return Incident(**await add_incident_note(incident_id, user.get("sub"), data.note))
+    except IncidentError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+async def incident_realert_loop():
+    """Repeats the alert on every channel until someone acknowledges the incident."""
+    while True:
+        await asyncio.sleep(INCIDENT_REALERT_POLL_SECONDS)
+        try:
+            while incident := await claim_unacknowledged(datetime.now(timezone.utc)):
+                logger.warning("incident_unacknowledged", incident_id=incident["incident_id"],
+                               alert_rounds=incident.get("alert_rounds"))
+                await send_incident_alerts(incident)
+        except Exception as e:
+            logger.error("incident_realert_failed", error=str(e))
+
+# --- Maintenance ---
+@app.patch("/work-orders/{work_order_id}/maintenance", response_model=WorkOrder)
+async def update_maintenance_details(work_order_id: WorkOrderRef, update: MaintenanceUpdate, response: Response,
+                                     version: IfMatch, user=Depends(require_staff)):
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["work_orders"].find_one({"work_order_id": work_order_id})
+        if not doc:
+            raise HTTPException(404, detail="Work order not found")
+        if doc.get("department") != DepartmentEnum.MAINTENANCE:
+            raise HTTPException(400, detail="Maintenance details can only be set on maintenance work orders")
+        if update.asset_id:
+            await get_asset_or_404(update.asset_id)
+        details = MaintenanceDetails(**(doc.get("maintenance") or {}))
+        if update.asset_id is not None:
+            details.asset_id = update.asset_id
+        if update.fault_code is not None:
+            details.fault_code = update.fault_code
+        if update.parts_used is not None:
+            details.parts_used = update.parts_used
+        doc = await conn["virtualbutler"]["work_orders"].find_one_and_update(
+            {"work_order_id": work_order_id, **version_filter(version)},
+            versioned({"$set": {"maintenance": details.model_dump(), "updated_at": datetime.now(timezone.utc)}}),
+            return_document=True
+        )
+    if not doc:
+        raise await update_conflict(work_order_id)
+    logger.info("maintenance_details_updated", work_order_id=work_order_id, staff=user.get("sub"))
+    return with_etag(response, doc)
+
+@app.post("/work-orders/{work_order_id}/photos", response_model=WorkOrder)
+async def upload_work_order_photo(work_order_id: WorkOrderRef, file: UploadFile = File(...), user=Depends(verify_jwt)):
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["work_orders"].find_one({"work_order_id": work_order_id})
+    if not doc:
+        raise HTTPException(404, detail="Work order not found")
+    # Guests may only attach photos to their own orders
+    if user.get("role") not in ("staff", "admin") and doc.get("guest_id") != user.get("sub"):
+        raise HTTPException(403, detail="Insufficient privileges")
+    if file.content_type not in ALLOWED_PHOTO_TYPES:
+        raise HTTPException(415, detail=f"Unsupported file type '{file.content_type}'")
+    data = await file.read(MAX_PHOTO_UPLOAD_BYTES + 1)
+    if len(data) > MAX_PHOTO_UPLOAD_BYTES:
+        raise HTTPException(413, detail="File too large")
+    if not data:
+        raise HTTPException(400, detail="Empty file")
+    extension = os.path.splitext(file.filename or "")[1].lower() or ".bin"
+    blob_name = f"work-orders/{work_order_id}/{datetime.now(timezone.utc).timestamp()}{extension}"
+    try:
+        await upload_blob(blob_name, data, file.content_type)
+    except BlobStorageError:
+        raise HTTPException(502, detail="Failed to store photo")
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["work_orders"].find_one_and_update(
+            {"work_order_id": work_order_id},
+            versioned({"$push": {"attachments": blob_name}, "$set": {"updated_at": datetime.now(timezone.utc)}}),
+            return_document=True
+        )
+    logger.info("work_order_photo_uploaded", work_order_id=work_order_id, blob=blob_name, uploaded_by=user.get("sub"))
+    return WorkOrder(**proof_view(user, doc))
+
+# --- Proof of Service ---
+def proof_view(user: dict, doc: dict) -> dict:
+    return doc if user.get("role") in ("staff", "admin", INTEGRATION_ROLE) else guest_view(doc)
+
+async def check_completion_proof(doc: Optional[dict], new_status: str):
+    if not doc:
+        return
+    try:
+        check_completion(doc, new_status, runtime_config.settings.completion_proof)
+    except CompletionProofError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.post("/work-orders/{work_order_id}/completion-proof", response_model=WorkOrder)
+async def add_completion_proof(work_order_id: WorkOrderRef, file: Optional[UploadFile] = File(None),
+                               note: Optional[str] = Form(None, max_length=1000),
+                               share_with_guest: bool = Form(False), user=Depends(require_staff)):
+    """A completion photo and/or note; departments and tags named in the proof policy can't complete without one."""
+    async with DatabaseConnection.get_connection() as conn:
+        doc = ensure_can_read_work_order(user, await conn["virtualbutler"]["work_orders"].find_one(
+            {"work_order_id": work_order_id}))
+    if doc.get("status") == StatusEnum.CANCELLED:
+        raise HTTPException(409, detail="Work order is cancelled")
+    note = (note or "").strip() or None
+    if not file and not note:
+        raise HTTPException(400, detail="Attach a photo or write a note")
+    now = datetime.now(timezone.utc)
+    blob_name = None
+    if file:
+        if file.content_type not in ALLOWED_PHOTO_TYPES:
+            raise HTTPException(415, detail=f"Unsupported file type '{file.content_type}'")
+        data = await file.read(MAX_PHOTO_UPLOAD_BYTES + 1)
+        if len(data) > MAX_PHOTO_UPLOAD_BYTES:
+            raise HTTPException(413, detail="File too large")
+        if not data:
+            raise HTTPException(400, detail="Empty file")
+        extension = os.path.splitext(file.filename or "")[1].lower() or ".bin"
+        blob_name = f"work-orders/{work_order_id}/proof/{now.timestamp()}{extension}"
+        try:
+            await upload_blob(blob_name, data, file.content_type)
+        except BlobStorageError:
+            raise HTTPException(502, detail="Failed to store photo")
+    proof = CompletionProof(blob_name=blob_name, note=note, submitted_by=user.get("sub"), submitted_at=now,
+                            shared_with_guest=share_with_guest)
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["work_orders"].find_one_and_update(
+            {"work_order_id": work_order_id},
+            versioned({"$set": {"completion_proof": proof.model_dump(), "updated_at": now}}),
+            return_document=True
+        )
+    await record_activity(work_order_id, "completion_proof_added", user.get("sub"),
+                          {"photo": bool(blob_name), "note": bool(note), "shared_with_guest": share_with_guest})
+    logger.info("completion_proof_added", work_order_id=work_order_id, staff=user.get("sub"), photo=bool(blob_name),
+                shared_with_guest=share_with_guest)
+    return WorkOrder(**doc)
+
+@app.get("/work-orders/{work_order_id}/completion-proof")
+async def get_completion_proof(work_order_id: WorkOrderRef, user=Depends(verify_jwt)):
+    """The proof with a short-lived photo link; guests only get it once it was shared with them."""
+    async with DatabaseConnection.get_connection() as conn:
+        doc = ensure_can_read_work_order(user, await conn["virtualbutler"]["work_orders"].find_one(
+            {"work_order_id": work_order_id}))
+    proof = proof_view(user, doc).get("completion_proof")
+    if not proof:
+        raise HTTPException(404, detail="No completion proof")
+    try:
+        url = generate_signed_url(proof["blob_name"], COMPLETION_PROOF_URL_TTL_MINUTES) if proof.get("blob_name") else None
+    except BlobStorageError:
+        raise HTTPException(502, detail="Photo link unavailable")
+    return {**proof, "photo_url": url}
+
+# --- Labor and Costs ---
+class CostCreate(BaseModel):
+    description: str = Field(..., min_length=1, max_length=200)
+    quantity: float = Field(1, gt=0)
+    unit_cost: float = Field(..., ge=0)
+
+async def load_open_order(work_order_id: str, user: dict) -> dict:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = ensure_can_read_work_order(user, await conn["virtualbutler"]["work_orders"].find_one(
+            {"work_order_id": work_order_id}))
+    if doc.get("status") in (StatusEnum.COMPLETED, StatusEnum.CANCELLED):
+        raise HTTPException(409, detail="Work order is closed")
+    return doc
+
+async def save_labor(doc: dict, labor: List[dict], now: datetime) -> dict:
+    async with DatabaseConnection.get_connection() as conn:
+        updated = await conn["virtualbutler"]["work_orders"].find_one_and_update(
+            {"work_order_id": doc["work_order_id"], **version_filter(doc.get("version") or 0)},
+            versioned({"$set": {"labor": labor, "labor_minutes": labor_minutes(labor), "updated_at": now}}),
+            return_document=True
+        )
+    if not updated:
+        raise await update_conflict(doc["work_order_id"])
+    return updated
+
+@app.post("/work-orders/{work_order_id}/timer/start", response_model=WorkOrder)
+async def start_work_timer(work_order_id: WorkOrderRef, user=Depends(require_staff)):
+    now = datetime.now(timezone.utc)
+    doc = await load_open_order(work_order_id, user)
+    try:
+        entry = start_timer(doc.get("labor", []), user.get("sub"), now)
+    except WorkCostError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    doc = await save_labor(doc, doc.get("labor", []) + [entry], now)
+    logger.info("work_timer_started", work_order_id=work_order_id, staff=user.get("sub"))
+    return WorkOrder(**doc)
+
+@app.post("/work-orders/{work_order_id}/timer/stop", response_model=WorkOrder)
+async def stop_work_timer(work_order_id: WorkOrderRef, user=Depends(require_staff)):
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        doc = ensure_can_read_work_order(user, await conn["virtualbutler"]["work_orders"].find_one(
+            {"work_order_id": work_order_id}))
+    try:
+        labor = stop_timer(doc.get("labor", []), user.get("sub"), now)
+    except WorkCostError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    doc = await save_labor(doc, labor, now)
+    await record_activity(work_order_id, "labor_recorded", user.get("sub"), {"labor_minutes": doc["labor_minutes"]})
+    logger.info("work_timer_stopped", work_order_id=work_order_id, staff=user.get("sub"),
+                labor_minutes=doc["labor_minutes"])
+    return WorkOrder(**doc)
+
+@app.post("/work-orders/{work_order_id}/costs", response_model=WorkOrder, status_code=201)
+async def add_work_order_cost(work_order_id: WorkOrderRef, data: CostCreate, user=Depends(require_staff)):
+    now = datetime.now(timezone.utc)
+    doc = await load_open_order(work_order_id, user)
+    entry = cost_entry(data.description, data.quantity, data.unit_cost, user.get("sub"), now)
+    costs = doc.get("costs", []) + [entry]
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["work_orders"].find_one_and_update(
+            {"work_order_id": work_order_id, **version_filter(doc.get("version") or 0)},
+            versioned({"$set": {"costs": costs, "material_cost": material_cost(costs), "updated_at": now}}),
+            return_document=True
+        )
+    if not doc:
+        raise await update_conflict(work_order_id)
+    await record_activity(work_order_id, "cost_added", user.get("sub"),
+                          {"description": data.description, "total": entry["total"]})
+    return WorkOrder(**doc)
+
+@app.delete("/work-orders/{work_order_id}/costs/{cost_id}", response_model=WorkOrder)
+async def delete_work_order_cost(work_order_id: WorkOrderRef, cost_id: str, user=Depends(require_staff)):
+    now = datetime.now(timezone.utc)
+    doc = await load_open_order(work_order_id, user)
+    entry = next((c for c in doc.get("costs", []) if c["cost_id"] == cost_id), None)
+    if not entry:
+        raise HTTPException(404, detail="Cost entry not found")
+    costs = [c for c in doc["costs"] if c is not entry]
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["work_orders"].find_one_and_update(
+            {"work_order_id": work_order_id, **version_filter(doc.get("version") or 0)},
+            versioned({"$set": {"costs": costs, "material_cost": material_cost(costs), "updated_at": now}}),
+            return_document=True
+        )
+    if not doc:
+        raise await update_conflict(work_order_id)
+    await record_activity(work_order_id, "cost_removed", user.get("sub"),
+                          {"description": entry["description"], "total": entry["total"]})
+    return WorkOrder(**doc)
+
+# --- Checklists ---
+class ChecklistAttach(BaseModel):
+    order_type: str
+
+class ChecklistTick(BaseModel):
+    done: bool = True
+
+async def check_checklist(doc: Optional[dict], new_status: str):
+    if not doc:
+        return
+    try:
+        check_checklist_completion(doc, new_status)
+    except ChecklistError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.post("/work-orders/{work_order_id}/checklist", response_model=WorkOrder)
+async def attach_checklist(work_order_id: WorkOrderRef, data: ChecklistAttach, user=Depends(require_staff)):
+    """Adds a checklist to an order created without one, e.g. when a routine clean turns into a deep clean."""
+    template = next((t for t in await list_templates() if t.order_type == data.order_type), None)
+    if not template:
+        raise HTTPException(404, detail=f"Checklist template '{data.order_type}' not found")
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["work_orders"]
+        ensure_can_read_work_order(user, await coll.find_one({"work_order_id": work_order_id}))
+        doc = await coll.find_one_and_update(
+            {"work_order_id": work_order_id, "checklist": None,
+             "status": {"$nin": [StatusEnum.COMPLETED, StatusEnum.CANCELLED]}},
+            versioned({"$set": {"checklist": instantiate(template.model_dump()),
+                                "updated_at": datetime.now(timezone.utc)}}),
+            return_document=True
+        )
+    if not doc:
+        raise HTTPException(409, detail="Work order is closed or already has a checklist")
+    await record_activity(work_order_id, "checklist_attached", user.get("sub"), {"order_type": data.order_type})
+    return WorkOrder(**doc)
+
+@app.patch("/work-orders/{work_order_id}/checklist/steps/{step_id}", response_model=WorkOrder)
+async def tick_checklist_step(work_order_id: WorkOrderRef, step_id: str, data: ChecklistTick,
+                              user=Depends(require_staff)):
+    now = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        coll = conn["virtualbutler"]["work_orders"]
+        doc = ensure_can_read_work_order(user, await coll.find_one({"work_order_id": work_order_id}))
+        if doc.get("status") in (StatusEnum.COMPLETED, StatusEnum.CANCELLED):
+            raise HTTPException(409, detail="Work order is closed")
+        try:
+            step = tick(doc.get("checklist"), step_id, data.done, user.get("sub"), now)
+        except ChecklistError as e:
+            raise HTTPException(e.status_code, detail=str(e))
+        doc = await coll.find_one_and_update(
+            {"work_order_id": work_order_id, "checklist.steps.step_id": step_id},
+            versioned({"$set": {"checklist.steps.$": step, "updated_at": now}}),
+            return_document=True
+        )
+    await record_activity(work_order_id, "checklist_step_done" if data.done else "checklist_step_reopened",
+                          user.get("sub"), {"step_id": step_id, "title": step["title"]})
+    logger.info("checklist_step_ticked", work_order_id=work_order_id, step_id=step_id, done=data.done,
+                staff=user.get("sub"))
+    return WorkOrder(**doc)
+
+# --- Preventive Maintenance ---
+PM_POLL_SECONDS = int(os.getenv("PM_POLL_SECONDS", "900"))
+
+class MaintenanceScheduleCreate(BaseModel):
+    asset_id: Optional[str] = None
+    room_number: Optional[str] = None
+    task: str = Field(..., min_length=1, max_length=300)
+    interval_days: int = Field(..., ge=1, le=3650)
+    lead_days: int = Field(0, ge=0, le=90)
+    priority: PriorityEnum = PriorityEnum.LOW
+    first_due_at: Optional[datetime] = Field(None, description="Defaults to one interval from now")
+
+class MaintenanceScheduleUpdate(BaseModel):
+    task: Optional[str] = Field(None, min_length=1, max_length=300)
+    interval_days: Optional[int] = Field(None, ge=1, le=3650)
+    lead_days: Optional[int] = Field(None, ge=0, le=90)
+    priority: Optional[PriorityEnum] = None
+    next_due_at: Optional[datetime] = None
+    active: Optional[bool] = None
+
+async def generate_pm_work_order(schedule: dict) -> WorkOrder:
+    now = datetime.now(timezone.utc)
+    room_number = schedule.get("room_number")
+    if schedule.get("asset_id") and not room_number:
+        async with DatabaseConnection.get_connection() as conn:
+            asset = await conn["virtualbutler"]["assets"].find_one({"asset_id": schedule["asset_id"]})
+        room_number = (asset or {}).get("room_number")
+    target = f" ({schedule['asset_id']})" if schedule.get("asset_id") else ""
+    metadata = {"room_number": room_number, "pm_due_at": schedule["next_due_at"]}
+    order_status = StatusEnum.PENDING
+    if should_hold_for_dnd(DepartmentEnum.MAINTENANCE, schedule["priority"]) and await is_room_dnd(room_number):
+        order_status = StatusEnum.ON_HOLD
+        metadata.update({"hold_reason": DND_HOLD_REASON, "held_status": StatusEnum.PENDING})
+    work_order = WorkOrder(
+        request_id=f"{schedule['schedule_id']}_{int(now.timestamp())}",
+        work_order_id=f"wo_{now.timestamp()}",
+        order_number=await next_order_number(DepartmentEnum.MAINTENANCE),
+        guest_id=PM_GUEST_ID,
+        department=DepartmentEnum.MAINTENANCE,
+        description=f"Preventive maintenance: {schedule['task']}{target}",
+        status=order_status,
+        priority=schedule["priority"],
+        location=f"Room {room_number}" if room_number else None,
+        maintenance=MaintenanceDetails(asset_id=schedule.get("asset_id"), schedule_id=schedule["schedule_id"]),
+        tags=["preventive_maintenance"],
+        metadata=metadata,
+        created_at=now,
+        updated_at=now
+    )
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["work_orders"].insert_one(work_order.model_dump(by_alias=True, exclude={"id"}))
+    await set_open_work_order(schedule["schedule_id"], work_order.work_order_id)
+    logger.info("pm_work_order_generated", schedule_id=schedule["schedule_id"],
+                work_order_id=work_order.work_order_id, asset_id=schedule.get("asset_id"))
+    await notify_status_change(work_order.model_dump())
+    await domain_events.publish(WorkOrderCreated.from_work_order(work_order.model_dump(), "preventive_maintenance"))
+    return work_order
+
+async def sync_pm_schedule(work_order: dict, new_status: str, actor: Optional[str]):
+    schedule_id = work_order["maintenance"]["schedule_id"]
+    try:
+        if new_status == StatusEnum.COMPLETED:
+            await record_completion(schedule_id, work_order["work_order_id"],
+                                    work_order.get("completed_at") or datetime.now(timezone.utc), by=actor)
+        elif new_status == StatusEnum.CANCELLED:
+            await release_schedule(schedule_id, work_order["work_order_id"])
+    except MaintenanceScheduleError:
+        # The schedule was deleted while its order was open; nothing left to track
+        pass
+
+async def process_due_pm_schedules() -> int:
+    now = datetime.now(timezone.utc)
+    generated = 0
+    if not is_open(await business_calendars.get(DepartmentEnum.MAINTENANCE), now):
+        # Due schedules wait for the team to be on shift rather than queue up orders overnight
+        return generated
+    while schedule := await claim_due_schedule(now):
+        try:
+            await generate_pm_work_order(schedule)
+            generated += 1
+        except Exception:
+            await set_open_work_order(schedule["schedule_id"], None)
+            raise
+    return generated
+
+pm_scheduler_lease = Lease("pm_scheduler", PM_POLL_SECONDS)
+
+async def pm_scheduler_loop():
+    while True:
+        try:
+            await pm_scheduler_lease.run(process_due_pm_schedules)
+        except Exception as e:
+            logger.error("pm_scheduler_failed", error=str(e))
+        await asyncio.sleep(PM_POLL_SECONDS)
+
+# --- Event Groups (see shared/groups.py) ---
+async def load_managed_group(group_id: str, user: dict) -> EventGroup:
+    try:
+        group = await get_group(group_id)
+    except GroupError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    if not can_manage(group, user):
+        raise HTTPException(404, detail="Group not found")
+    return group
+
+@app.get("/groups", response_model=List[EventGroup])
+async def get_groups(include_inactive: bool = False, user=Depends(auth.require("work_orders:read", roles=None))):
+    """Staff see every group; a coordinator sees their own."""
+    staff = user.get("role") in ("staff", "admin", INTEGRATION_ROLE)
+    return await list_groups(not include_inactive, None if staff else user.get("sub"))
+
+@app.put("/groups/{group_id}", response_model=EventGroup)
+async def put_group(group_id: str, data: EventGroupUpdate, user=Depends(require_staff)):
+    try:
+        return await save_group(EventGroup(group_id=group_id, updated_by=user.get("sub"), **data.model_dump()))
+    except ValueError as e:
+        raise HTTPException(422, detail=str(e))
+    except GroupError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.post("/groups/{group_id}/requests", response_model=List[WorkOrder], status_code=201,
+          dependencies=[Depends(create_work_order_limiter)])
+async def create_group_requests(group_id: str, data: BulkRequest,
+                                user=Depends(auth.require("work_orders:write", roles=None))):
+    """One work order per line, raised in the coordinator's name; e.g. 30 extra chairs to Ballroom A."""
+    group = await load_managed_group(group_id, user)
+    if not group.active:
+        raise HTTPException(409, detail="Group is no longer active")
+    now = datetime.now(timezone.utc)
+    orders = []
+    for i, item in enumerate(data.items, 1):
+        location = item.location or group.location
+        description = item_description(item, location, data.note)
+        department = item.department or route_department(description, routing_key=group.coordinator_id)
+        orders.append(WorkOrder(
+            request_id=f"grp_{group_id}_{now.timestamp()}.{i}",
+            work_order_id=f"wo_{uuid.uuid4().hex}",
+            order_number=await next_order_number(department),
+            guest_id=group.coordinator_id,
+            department=department,
+            description=description,
+            priority=item.priority,
+            location=location,
+            tags=group_tags(group_id),
+            created_at=now,
+            updated_at=now,
+            trace_id=current_request_id.get(),
+            metadata={"group_id": group_id, "quantity": item.quantity, "requested_by": user.get("sub"),
+                      "source": "group"}
+        ))
+    async with DatabaseConnection.get_connection() as conn:
+        await conn["virtualbutler"]["work_orders"].insert_many(
+            [o.model_dump(by_alias=True, exclude={"id"}) for o in orders]
+        )
+    for order in orders:
+        await domain_events.publish(WorkOrderCreated.from_work_order(order.model_dump(), "group"))
+    await mark_changed(group_id)
+    logger.info("group_requests_created", group_id=group_id, count=len(orders), requested_by=user.get("sub"))
+    return orders
+
+@app.get("/groups/{group_id}/requests")
+async def get_group_requests(group_id: str, user=Depends(auth.require("work_orders:read", roles=None))):
+    group = await load_managed_group(group_id, user)
+    orders = await group_orders(group_id)
+    return {"group": group, "summary": summarize(orders), "orders": [WorkOrder(**o) for o in orders]}
+
+async def send_group_digests() -> int:
+    sent = 0
+    while group := await take_pending_digest():
+        if suppress_staff_alert("group_digest", group_id=group.group_id):
+            continue
+        summary = summarize(await group_orders(group.group_id))
+        try:
+            await http_client.post(NOTIFICATION_SERVICE_URL, json={
+                "type": "group_status", "guest_id": group.coordinator_id, "group_id": group.group_id,
+                "message": digest_text(group, summary), "summary": summary
+            })
+            sent += 1
+        except Exception as e:
+            logger.error("group_digest_failed", group_id=group.group_id, error=str(e))
+            await mark_changed(group.group_id)
+            break
+    return sent
+
+group_digest_lease = Lease("group_digests", GROUP_DIGEST_SECONDS)
+
+async def group_digest_loop():
+    while True:
+        await asyncio.sleep(GROUP_DIGEST_SECONDS)
+        try:
+            sent = await group_digest_lease.run(send_group_digests)
+            if sent:
+                logger.info("group_digests_sent", count=sent)
+        except Exception as e:
+            logger.error("group_digest_loop_failed", error=str(e))
+
+@app.post("/maintenance/schedules", response_model=MaintenanceSchedule, status_code=201)
+async def create_maintenance_schedule(data: MaintenanceScheduleCreate, user=Depends(require_admin)):
+    room_number = data.room_number
+    if data.asset_id:
+        room_number = room_number or (await get_asset_or_404(data.asset_id)).get("room_number")
+    try:
+        return await create_schedule(data.task, data.interval_days, asset_id=data.asset_id, room_number=room_number,
+                                     lead_days=data.lead_days, priority=data.priority.value,
+                                     first_due_at=data.first_due_at)
+    except MaintenanceScheduleError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.get("/maintenance/schedules", response_model=List[MaintenanceSchedule])
+async def get_maintenance_schedules(asset_id: Optional[str] = None, room_number: Optional[str] = None,
+                                    include_inactive: bool = False, user=Depends(require_staff)):
+    return await list_schedules(asset_id, room_number, active_only=not include_inactive)
+
+@app.put("/maintenance/schedules/{schedule_id}", response_model=MaintenanceSchedule)
+async def update_maintenance_schedule(schedule_id: str, data: MaintenanceScheduleUpdate, user=Depends(require_admin)):
+    changes = data.model_dump(exclude_none=True)
+    if not changes:
+        raise HTTPException(400, detail="No data to update")
+    try:
+        return MaintenanceSchedule(**await update_schedule(schedule_id, changes))
+    except MaintenanceScheduleError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.delete("/maintenance/schedules/{schedule_id}", status_code=204)
+async def delete_maintenance_schedule(schedule_id: str, user=Depends(require_admin)):
+    """An order already generated stays open; it just no longer feeds a schedule."""
+    try:
+        await delete_schedule(schedule_id)
+    except MaintenanceScheduleError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    logger.info("pm_schedule_deleted", schedule_id=schedule_id, admin=user.get("sub"))
+
+@app.get("/maintenance/dashboard")
+async def get_engineering_dashboard(user=Depends(require_staff)):
+    """Overdue and due-soon preventive maintenance plus the open maintenance backlog."""
+    status_counts = {}
+    async with DatabaseConnection.get_connection() as conn:
+        cursor = conn["virtualbutler"]["work_orders"].aggregate([
+            {"$match": {"department": DepartmentEnum.MAINTENANCE,
+                        "status": {"$nin": [StatusEnum.COMPLETED, StatusEnum.CANCELLED]}}},
+            {"$group": {"_id": "$status", "count": {"$sum": 1}}}
+        ])
+        async for row in cursor:
+            status_counts[row["_id"]] = row["count"]
+    return {**await pm_status(datetime.now(timezone.utc)), "open_work_orders": status_counts}
+
+@app.get("/assets/{asset_id}/maintenance-schedules", response_model=List[MaintenanceSchedule])
+async def get_asset_pm_history(asset_id: str, user=Depends(require_staff)):
+    """The asset's recurring tasks, each with its completion history."""
+    await get_asset_or_404(asset_id)
+    return await list_schedules(asset_id=asset_id, active_only=False)
+
+# --- Asset Registry ---
+async def get_asset_or_404(asset_id: str) -> dict:
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["assets"].find_one({"asset_id": asset_id})
+    if not doc:
+        raise HTTPException(404, detail=f"Asset '{asset_id}' not found")
+    return doc
+
+@app.post("/assets", response_model=Asset, status_code=201)
+async def create_asset(asset: Asset, user=Depends(require_admin)):
+    async with DatabaseConnection.get_connection() as conn:
+        if await conn["virtualbutler"]["assets"].find_one({"asset_id": asset.asset_id}):
+            raise HTTPException(409, detail="Asset already exists")
+        result = await conn["virtualbutler"]["assets"].insert_one(asset.model_dump(by_alias=True, exclude={"id"}))
+        asset.id = result.inserted_id
+    logger.info("asset_created", asset_id=asset.asset_id, room_number=asset.room_number)
+    return asset
+
+@app.get("/assets", response_model=List[Asset])
+async def list_assets(
+    room_number: Optional[str] = None,
+    asset_type: Optional[str] = None,
+    skip: int = 0,
+    limit: int = 50,
+    user=Depends(require_staff)
+):
+    query = {}
+    if room_number: query["room_number"] = room_number
+    if asset_type: query["asset_type"] = asset_type
+    async with DatabaseConnection.get_connection() as conn:
+        cursor = conn["virtualbutler"]["assets"].find(query).skip(skip).limit(limit)
+        return [Asset(**doc) async for doc in cursor]
+
+@app.get("/assets/{asset_id}", response_model=Asset)
+async def get_asset(asset_id: str, user=Depends(require_staff)):
+    return Asset(**await get_asset_or_404(asset_id))
+
+@app.get("/assets/{asset_id}/work-orders", response_model=List[WorkOrder])
+async def get_asset_history(asset_id: str, user=Depends(require_staff)):
+    async with DatabaseConnection.get_connection() as conn:
+        cursor = conn["virtualbutler"]["work_orders"].find({"maintenance.asset_id": asset_id}).sort("created_at", -1)
+        return [WorkOrder(**doc) async for doc in cursor]
+
+@app.put("/assets/{asset_id}", response_model=Asset)
+async def update_asset(asset_id: str, update: AssetUpdate, user=Depends(require_admin)):
+    update_data = {k: v for k, v in update.dict(exclude_unset=True).items() if v is not None}
+    if not update_data:
+        raise HTTPException(400, detail="No data to update")
+    update_data["updated_at"] = datetime.now(timezone.utc)
+    async with DatabaseConnection.get_connection() as conn:
+        doc = await conn["virtualbutler"]["assets"].find_one_and_update(
+            {"asset_id": asset_id},
+            {"$set": update_data},
+            return_document=True
+        )
+    if not doc:
+        raise HTTPException(404, detail="Asset not found")
+    return Asset(**doc)
+
+@app.delete("/assets/{asset_id}", status_code=204)
+async def delete_asset(asset_id: str, user=Depends(require_admin)):
+    async with DatabaseConnection.get_connection() as conn:
+        result = await conn["virtualbutler"]["assets"].delete_one({"asset_id": asset_id})
+        if result.deleted_count == 0:
+            raise HTTPException(404, detail="Not found")
+    logger.info("asset_deleted", asset_id=asset_id)
+
 @app.get("/work-orders", response_model=List[WorkOrder])
 async def list_work_orders(
+    request: Request,
     status: Optional[StatusEnum] = None,
     department: Optional[DepartmentEnum] = None,
     guest_id: Optional[str] = None,
     priority: Optional[PriorityEnum] = None,
     assigned_staff: Optional[str] = None,
+    order_number: Optional[str] = Query(None, description="e.g. HK-2045"),
+    parent_id: Optional[str] = Query(None, description="Only subtasks of this order"),
+    top_level: bool = Query(False, description="Hide subtasks, listing orders as guests see them"),
+    tag: Optional[List[str]] = Query(None, description="Only orders carrying all of these tags"),
+    hub_id: Optional[str] = Query(None, description="Only off-site orders routed to this service hub"),
+    q: Optional[str] = Query(None, description="Words to search for in the description"),
     skip: int = 0,
     limit: int = 50,
-    user=Depends(require_admin)
+    user=Depends(auth.require("work_orders:read", roles=("admin",)))
 ):
+    """Custom fields are filtered with `cf.<key>=<value>` query parameters, e.g. `?cf.wing=north`."""
     query = {}
     if status: query["status"] = status
     if department: query["department"] = department
     if guest_id: query["guest_id"] = guest_id
     if priority: query["priority"] = priority
     if assigned_staff: query["assigned_staff"] = assigned_staff
+    if order_number: query["order_number"] = normalize_order_number(order_number)
+    if parent_id: query["parent_id"] = parent_id
+    elif top_level: query["parent_id"] = None
+    if tag: query["tags"] = {"$all": [t.strip().lower() for t in tag]}
+    if hub_id: query["metadata.location.hub_id"] = hub_id
+    if q:
+        if field_cipher.enabled:
+            # The text index only holds ciphertext, so a search would quietly find nothing
+            raise HTTPException(400, detail="Text search is unavailable while work-order descriptions are encrypted")
+        query["$text"] = {"$search": q}
+    try:
+        query.update(custom_field_filter(dict(request.query_params), await list_field_definitions(active_only=False)))
+    except CustomFieldError as e:
+        raise HTTPException(400, detail=str(e))
 
     async with DatabaseConnection.get_connection() as conn:
         cursor = conn["virtualbutler"]["work_orders"].find(query).skip(skip).limit(limit)
-        results = [WorkOrder(**doc) async for doc in cursor]
-    return results
+        results = [WorkOrder(**proof_view(user, doc)) async for doc in cursor]
+    return conditional_response(request, results)
+
+# --- Custom Field Definitions ---
+@app.get("/api/v1/admin/custom-fields", response_model=List[CustomFieldDefinition])
+async def get_custom_fields(include_inactive: bool = False, user=Depends(require_staff)):
+    return await list_field_definitions(active_only=not include_inactive)
+
+@app.put("/api/v1/admin/custom-fields/{key}", response_model=CustomFieldDefinition)
+async def put_custom_field(key: str, definition: CustomFieldDefinition, user=Depends(require_admin)):
+    if definition.key != key:
+        raise HTTPException(400, detail="Field key in the body must match the URL")
+    try:
+        return await save_field_definition(definition)
+    except CustomFieldError as e:
+        raise HTTPException(422, detail=str(e))
+
+@app.delete("/api/v1/admin/custom-fields/{key}", status_code=204)
+async def delete_custom_field(key: str, user=Depends(require_admin)):
+    try:
+        await deactivate_field_definition(key)
+    except CustomFieldError as e:
+        raise HTTPException(404, detail=str(e))
+
+# --- Cost Reports ---
+@app.get("/api/v1/admin/dashboard/costs")
+async def get_cost_dashboard(start: Optional[datetime] = None, end: Optional[datetime] = None,
+                             department: Optional[DepartmentEnum] = None, user=Depends(require_staff)):
+    """Labor hours and material cost per department for orders created in [start, end); defaults to the last 30 days."""
+    end = end or datetime.now(timezone.utc)
+    start = start or end - timedelta(days=30)
+    return {"start": start, "end": end,
+            "departments": await cost_report(start, end, [department.value] if department else None)}
+
+# --- Checklist Templates ---
+@app.get("/api/v1/admin/checklist-templates", response_model=List[ChecklistTemplate])
+async def get_checklist_templates(include_inactive: bool = False, user=Depends(require_staff)):
+    return await list_templates(active_only=not include_inactive)
+
+@app.put("/api/v1/admin/checklist-templates/{order_type}", response_model=ChecklistTemplate)
+async def put_checklist_template(order_type: str, template: ChecklistTemplate, user=Depends(require_admin)):
+    if template.order_type != order_type:
+        raise HTTPException(400, detail="Order type in the body must match the URL")
+    return await save_template(template)
+
+@app.delete("/api/v1/admin/checklist-templates/{order_type}", status_code=204)
+async def delete_checklist_template(order_type: str, user=Depends(require_admin)):
+    try:
+        await deactivate_template(order_type)
+    except ChecklistError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+# --- Exports ---
+EXPORT_COLUMNS = [
+    "work_order_id", "request_id", "guest_id", "room_number", "department", "status", "priority",
+    "description", "staff_id", "assigned_staff", "tags", "created_at", "assigned_at", "started_at",
+    "completed_at", "updated_at", "estimated_duration", "actual_duration", "sentiment", "asset_id", "fault_code",
+    "labor_minutes", "material_cost"
+]
+DEFAULT_EXPORT_COLUMNS = [
+    "work_order_id", "room_number", "department", "status", "priority", "description", "assigned_staff",
+    "tags", "created_at", "completed_at"
+]
+
+def export_value(doc: dict, column: str):
+    if column.startswith("cf."):
+        return (doc.get("custom_fields") or {}).get(column[3:])
+    if column in ("room_number", "sentiment"):
+        return (doc.get("metadata") or {}).get(column)
+    if column in ("asset_id", "fault_code"):
+        return (doc.get("maintenance") or {}).get(column)
+    return doc.get(column)
+
+@app.get("/api/v1/admin/export/workorders")
+async def export_work_orders(
+    from_: Optional[str] = Query(None, alias="from", description="ISO date/datetime, inclusive (created_at)"),
+    to: Optional[str] = Query(None, description="ISO date/datetime, exclusive (created_at)"),
+    format: str = Query("csv", pattern="^(csv|xlsx)$"),
+    columns: Optional[str] = Query(None, description="Comma-separated; custom fields as cf.<key>"),
+    tz: str = Query("UTC", description="IANA timezone for timestamps and naive from/to values"),
+    department: Optional[DepartmentEnum] = None,
+    status: Optional[StatusEnum] = None,
+    user=Depends(require_admin)
+):
+    try:
+        zone = resolve_timezone(tz)
+        start, end = parse_bound(from_, zone), parse_bound(to, zone)
+        default = DEFAULT_EXPORT_COLUMNS + [f"cf.{d.key}" for d in await list_field_definitions()]
+        selected = select_columns(columns, EXPORT_COLUMNS, default, dynamic_prefix="cf.")
+    except ExportError as e:
+        raise HTTPException(400, detail=str(e))
+    query = {}
+    if start or end:
+        query["created_at"] = {k: v for k, v in (("$gte", start), ("$lt", end)) if v}
+    if department: query["department"] = department
+    if status: query["status"] = status
+    logger.info("work_order_export_started", admin=user.get("sub"), format=format, query=str(query))
+
+    async def rows():
+        async with DatabaseConnection.get_connection() as conn:
+            cursor = conn["virtualbutler"]["work_orders"].find(query, {"_id": 0}).sort("created_at", 1).batch_size(500)
+            async for doc in cursor:
+                yield doc
+
+    stream = stream_xlsx(rows(), selected, export_value, zone, sheet_name="Work orders") if format == "xlsx" \
+        else stream_csv(rows(), selected, export_value, zone)
+    filename = export_filename("work_orders", format, zone)
+    return StreamingResponse(stream, media_type=EXPORT_MEDIA_TYPES[format],
+                             headers={"Content-Disposition": f'attachment; filename="{filename}"'})
+
+FEEDBACK_EXPORT_COLUMNS = [
+    "survey_id", "guest_id", "room_number", "check_in_date", "check_out_date", "departments", "language",
+    "channel", "status", "score", "department_scores", "comment", "answered_at", "created_at"
+]
+DEFAULT_FEEDBACK_EXPORT_COLUMNS = [
+    "room_number", "check_out_date", "departments", "score", "department_scores", "comment", "answered_at"
+]
+
+@app.get("/api/v1/admin/export/feedback")
+async def export_feedback(
+    from_: Optional[str] = Query(None, alias="from", description="ISO date/datetime, inclusive (answered_at)"),
+    to: Optional[str] = Query(None, description="ISO date/datetime, exclusive (answered_at)"),
+    format: str = Query("csv", pattern="^(csv|xlsx)$"),
+    columns: Optional[str] = Query(None, description="Comma-separated"),
+    tz: str = Query("UTC", description="IANA timezone for timestamps and naive from/to values"),
+    user=Depends(require_admin)
+):
+    """Answered checkout surveys, for the same nightly reports as the work-order export."""
+    try:
+        zone = resolve_timezone(tz)
+        start, end = parse_bound(from_, zone), parse_bound(to, zone)
+        selected = select_columns(columns, FEEDBACK_EXPORT_COLUMNS, DEFAULT_FEEDBACK_EXPORT_COLUMNS)
+    except ExportError as e:
+        raise HTTPException(400, detail=str(e))
+    query = {"score": {"$ne": None}}
+    if start or end:
+        query["answered_at"] = {k: v for k, v in (("$gte", start), ("$lt", end)) if v}
+    logger.info("feedback_export_started", admin=user.get("sub"), format=format, query=str(query))
+
+    async def rows():
+        async with DatabaseConnection.get_connection() as conn:
+            cursor = conn["virtualbutler"]["nps_surveys"].find(query, {"_id": 0, "token_hash": 0}) \
+                .sort("answered_at", 1).batch_size(500)
+            async for doc in cursor:
+                yield doc
+
+    stream = stream_xlsx(rows(), selected, lambda doc, column: doc.get(column), zone, sheet_name="Feedback") \
+        if format == "xlsx" else stream_csv(rows(), selected, lambda doc, column: doc.get(column), zone)
+    filename = export_filename("feedback", format, zone)
+    return StreamingResponse(stream, media_type=EXPORT_MEDIA_TYPES[format],
+                             headers={"Content-Disposition": f'attachment; filename="{filename}"'})
+
+# --- JWT Signing Keys ---
+class KeyRevocation(BaseModel):
+    reason: Optional[str] = None
+
+@app.get("/api/v1/admin/jwt-keys")
+async def get_jwt_keys(user=Depends(require_admin)):
+    return [key.public_view() for key in await list_keys()]
+
+@app.post("/api/v1/admin/jwt-keys/rotate", status_code=201)
+async def rotate_jwt_key(user=Depends(require_admin)):
+    key = await rotate_key(created_by=user.get("sub"))
+    if key is None:
+        raise HTTPException(409, detail="Another rotation is in progress; retry shortly")
+    await key_ring.refresh()
+    return key.public_view()
+
+@app.post("/api/v1/admin/jwt-keys/{kid}/revoke")
+async def revoke_jwt_key(kid: str, data: KeyRevocation = Body(default=KeyRevocation()), user=Depends(require_admin)):
+    try:
+        key = await revoke_key(kid, reason=data.reason, revoked_by=user.get("sub"))
+    except SigningKeyError as e:
+        raise HTTPException(404, detail=str(e))
+    await key_ring.refresh()
+    return key.public_view()
+
+class TestTokenRequest(BaseModel):
+    sub: str = Field(..., min_length=1)
+    role: str = Field("guest", pattern="^(guest|staff|admin)$")
+    room: Optional[str] = None
+    departments: List[str] = Field(default_factory=list)
+    ttl_minutes: int = Field(60, ge=1, le=1440)
+
+@app.post("/api/v1/admin/test-tokens", status_code=201)
+async def mint_test_token(data: TestTokenRequest, user=Depends(require_admin)):
+    if not ALLOW_TEST_TOKENS:
+        raise HTTPException(404, detail="Not found")
+    claims = {"sub": data.sub, "role": data.role, "test": True}
+    if data.room:
+        claims["room"] = data.room
+    if data.departments:
+        claims["departments"] = data.departments
+    token = key_ring.encode(claims, ttl=timedelta(minutes=data.ttl_minutes))
+    logger.warning("test_token_minted", sub=data.sub, role=data.role, admin=user.get("sub"))
+    return {"token": token, "expires_in": data.ttl_minutes * 60}
+
+# --- Staff Single Sign-On (OIDC) ---
+@app.get("/api/v1/auth/oidc/{tenant}")
+async def get_oidc_login_config(tenant: str):
+    """What the staff app needs to start sign-in with the hotel's identity provider."""
+    provider = await get_provider(tenant)
+    if not provider or not provider.enabled:
+        raise HTTPException(404, detail="Single sign-on is not configured for this tenant")
+    return {"tenant": tenant, "issuer": provider.issuer, "client_id": provider.client_id or provider.audience,
+            "audience": provider.audience, "end_session_url": oidc.end_session_url(provider)}
+
+@app.post("/api/v1/auth/oidc/logout")
+async def oidc_logout(user=Depends(require_staff)):
+    """Ends the caller's IdP session in every service; the app then redirects to `end_session_url`."""
+    if user.get("auth") != "oidc":
+        raise HTTPException(400, detail="Not signed in through an identity provider")
+    await oidc.record_logout(user["iss"], sid=user.get("sid"), sub=None if user.get("sid") else user.get("idp_sub"))
+    provider = oidc.providers.get(user["iss"])
+    return {"end_session_url": oidc.end_session_url(provider) if provider else None}
+
+@app.post("/api/v1/auth/oidc/backchannel-logout")
+async def oidc_backchannel_logout(logout_token: str = Form(...)):
+    """Back-channel logout endpoint registered with the IdP (OpenID Connect Back-Channel Logout 1.0)."""
+    try:
+        provider, claims = oidc.verify_logout_token(logout_token)
+    except JWTError as e:
+        logger.warning("oidc_logout_token_rejected", error=str(e))
+        raise HTTPException(400, detail="Invalid logout token")
+    await oidc.record_logout(provider.issuer, sid=claims.get("sid"), sub=None if claims.get("sid") else claims.get("sub"))
+    return PlainTextResponse("", headers={"Cache-Control": "no-store"})
+
+@app.get("/api/v1/admin/oidc-providers", response_model=List[OidcProvider])
+async def get_oidc_providers(user=Depends(require_admin)):
+    return await list_providers()
+
+@app.put("/api/v1/admin/oidc-providers/{tenant}", response_model=OidcProvider)
+async def put_oidc_provider(tenant: str, provider: OidcProvider, user=Depends(require_admin)):
+    provider.tenant = tenant
+    provider.updated_by = user.get("sub")
+    try:
+        saved = await save_provider(provider)
+    except OidcError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    await oidc.refresh()
+    return saved
+
+@app.delete("/api/v1/admin/oidc-providers/{tenant}", status_code=204)
+async def delete_oidc_provider(tenant: str, user=Depends(require_admin)):
+    try:
+        await delete_provider(tenant)
+    except OidcError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    await oidc.refresh()
+
+# --- Integration API Keys ---
+class ApiKeyCreate(BaseModel):
+    name: str = Field(..., min_length=1, max_length=100)
+    integration: str = Field(..., min_length=1, max_length=40, description="e.g. pos, pms, kiosk")
+    scopes: List[str]
+    rate_limit_per_minute: Optional[int] = Field(None, ge=1, le=10000)
+    expires_at: Optional[datetime] = None
+
+@app.post("/api/v1/admin/api-keys", status_code=201)
+async def create_api_key(data: ApiKeyCreate, user=Depends(require_admin)):
+    """The plaintext `api_key` is only returned here; store it in the integration's configuration."""
+    try:
+        key, raw = await issue_api_key(data.name, data.integration, data.scopes, data.rate_limit_per_minute,
+                                       data.expires_at, created_by=user.get("sub"))
+    except ApiKeyError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    await api_keys.refresh()
+    return {**key.public_view(), "api_key": raw}
+
+@app.get("/api/v1/admin/api-keys")
+async def get_api_keys(user=Depends(require_admin)):
+    return [key.public_view() for key in await list_api_keys()]
+
+@app.post("/api/v1/admin/api-keys/{key_id}/rotate")
+async def rotate_integration_key(key_id: str, user=Depends(require_admin)):
+    try:
+        key, raw = await rotate_api_key(key_id, rotated_by=user.get("sub"))
+    except ApiKeyError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    await api_keys.refresh()
+    return {**key.public_view(), "api_key": raw}
+
+@app.post("/api/v1/admin/api-keys/{key_id}/revoke")
+async def revoke_integration_key(key_id: str, data: KeyRevocation = Body(default=KeyRevocation()),
+                                 user=Depends(require_admin)):
+    try:
+        key = await revoke_api_key(key_id, reason=data.reason, revoked_by=user.get("sub"))
+    except ApiKeyError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    await api_keys.refresh()
+    return key.public_view()
+
+@app.get("/api/v1/admin/api-keys/{key_id}/usage")
+async def get_api_key_usage(key_id: str, days: int = Query(7, ge=1, le=90), user=Depends(require_admin)):
+    return {"key_id": key_id, "days": await key_usage(key_id, days)}
+
+# --- Field Encryption Keys ---
+def require_field_encryption():
+    if not field_cipher.enabled:
+        raise HTTPException(409, detail="Field-level encryption is not enabled")
+
+@app.get("/api/v1/admin/field-keys", dependencies=[Depends(require_field_encryption)])
+async def get_field_keys(user=Depends(require_admin)):
+    return await list_field_keys()
+
+@app.post("/api/v1/admin/field-keys/rotate", status_code=201, dependencies=[Depends(require_field_encryption)])
+async def rotate_field_encryption_key(user=Depends(require_admin)):
+    """New writes use the new key at once here and within FIELD_KEY_REFRESH_SECONDS on other replicas."""
+    key = await rotate_field_key(created_by=user.get("sub"))
+    if key is None:
+        raise HTTPException(409, detail="Another rotation is in progress; retry shortly")
+    await field_cipher.refresh()
+    return key
+
+@app.post("/api/v1/admin/field-keys/rewrap", dependencies=[Depends(require_field_encryption)])
+async def rewrap_field_encryption_keys(user=Depends(require_admin)):
+    return {"rewrapped": await rewrap_field_keys()}
+
+@app.post("/api/v1/admin/field-keys/reencrypt", status_code=202, dependencies=[Depends(require_field_encryption)])
+async def reencrypt_fields(user=Depends(require_admin)):
+    """Re-encrypts every designated field with the active key in the background (also migrates plaintext)."""
+    async def run():
+        await field_cipher.refresh()
+        for collection in ENCRYPTED_FIELDS:
+            try:
+                await reencrypt_collection(collection)
+            except Exception as e:
+                logger.error("field_reencryption_failed", collection=collection, error=str(e))
+    asyncio.create_task(run())
+    logger.info("field_reencryption_started", admin=user.get("sub"), active_kid=field_cipher.active_kid)
+    return {"collections": list(ENCRYPTED_FIELDS), "active_kid": field_cipher.active_kid}
+
+@app.get("/api/v1/admin/fault-injection", dependencies=[Depends(require_admin)])
+async def get_fault_injection_status():
+    return fault_injection.status()
+
+@app.get("/metrics", include_in_schema=False)
+async def metrics_endpoint():
+    return PlainTextResponse(metrics.render_prometheus(), media_type="text/plain; version=0.0.4")
+
+@app.get("/api/v1/admin/leases")
+async def get_leases(user=Depends(require_admin)):
+    """Which replica runs each background job, and whether its lease is still live."""
+    return {"replica": REPLICA_ID, "leases": await list_leases()}
+
+@app.post("/api/v1/admin/sandbox/purge")
+async def purge_sandbox(user=Depends(require_admin)):
+    """Runs the nightly sandbox purge now: synthetic documents from before the last purge time."""
+    return {"deleted": await purge_synthetic()}
+
+class MigrationRun(BaseModel):
+    dry_run: bool = Field(False, description="Report what would change without writing")
+
+@app.get("/api/v1/admin/migrations")
+async def get_migrations(user=Depends(require_admin)):
+    """Applied and pending schema migrations, and the index changes the next run would make."""
+    return await migration_status()
+
+@app.post("/api/v1/admin/migrations/run")
+async def run_migrations(data: MigrationRun, user=Depends(require_admin)):
+    try:
+        result = await migrate(dry_run=data.dry_run)
+    except MigrationError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+    logger.info("migrations_run", dry_run=data.dry_run, staff_id=user.get("sub"),
+                migrated=len(result.get("migrated", [])), indexes=len(result["indexes"]))
+    return result
+
+@app.get("/healthz")
+async def health_check():
+    try:
+        return await DatabaseConnection.health_check()
+    except Exception as e:
+        logger.error("health_check_failed", error=str(e))
+        return {"status": "unhealthy", "error": str(e)}
+
+@app.get("/readiness")
+async def readiness_check(request: Request):
+    if drain.draining:
+        return error_response(503, "Draining", request=request, headers={"Retry-After": str(DRAIN_RETRY_AFTER_SECONDS)})
+    return {"status": "ready"}
+
+@app.post("/api/v1/admin/drain", status_code=202)
+async def start_drain(user=Depends(require_admin)):
+    """Stops consuming chat requests, finishes the messages in hand and pending webhooks, then exits."""
+    if drain.start(f"admin {user.get('sub')}"):
+        logger.warning("drain_requested", admin=user.get("sub"))
+    return drain.status()
+
+@app.get("/api/v1/admin/drain")
+async def get_drain_status(user=Depends(require_admin)):
+    return drain.status()
+
+@app.get("/api/v1/admin/config")
+async def get_runtime_config(user=Depends(require_admin)):
+    """The settings this replica is using, their sources and version hash, and the last reload error."""
+    return runtime_config.status()
+
+@app.put("/api/v1/admin/config")
+async def put_runtime_config(overrides: Dict[str, Any], user=Depends(require_admin)):
+    try:
+        return await runtime_config.save_overrides(overrides, user.get("sub"))
+    except RuntimeConfigError as e:
+        raise HTTPException(e.status_code, detail=str(e))
+
+@app.post("/api/v1/admin/config/reload")
+async def reload_runtime_config(user=Depends(require_admin)):
+    """Reloads now instead of at the next refresh; `last_error` is set if the new settings were rejected."""
+    return await runtime_config.reload(reason="admin")
 
 @app.get("/reports/work-orders", dependencies=[Depends(require_admin)])
 async def report_work_orders():
@@ -244,6 +3138,8 @@ async def report_work_orders():
                 "total": {"$sum": 1},
                 "pending": {"$sum": {"$cond": [{"$eq": ["$status", "PENDING"]}, 1, 0]}},
                 "completed": {"$sum": {"$cond": [{"$eq": ["$status", "COMPLETED"]}, 1, 0]}},
+                "labor_minutes": {"$sum": {"$ifNull": ["$labor_minutes", 0]}},
+                "material_cost": {"$sum": {"$ifNull": ["$material_cost", 0]}},
             }}
         ]
         result = await conn["virtualbutler"]["work_orders"].aggregate(pipeline).to_list(length=100)
@@ -253,4 +3149,57 @@ async def report_work_orders():
 @app.on_event("startup")
 async def startup_event():
     await DatabaseConnection.connect()
+    install_event_store()
+    install_sandbox()
+    await field_cipher.start()
     await FastAPILimiter.init(DatabaseConnection.client["virtualbutler"]["ratelimits"])
+    await ensure_dedup_indexes()
+    await ensure_key_indexes()
+    await ensure_api_key_indexes()
+    await ensure_oidc_indexes()
+    await ensure_wake_up_indexes()
+    await ensure_incident_indexes()
+    await ensure_pm_indexes()
+    await ensure_lease_indexes()
+    await migrate_on_startup()
+    await ensure_zone_indexes()
+    await ensure_venue_indexes()
+    await ensure_presence_indexes()
+    await ensure_checklist_indexes()
+    await ensure_scan_code_indexes()
+    await ensure_event_store_indexes()
+    await ensure_read_model_indexes()
+    await ensure_business_hours_indexes()
+    await ensure_routing_feedback_indexes()
+    await ensure_group_indexes()
+    drain.install_signal_handler()
+    runtime_config.on_reload(apply_runtime_config)
+    await runtime_config.reload(reason="startup")
+    asyncio.create_task(runtime_config.refresh_loop())
+    await key_ring.refresh()
+    asyncio.create_task(key_ring.refresh_loop())
+    await oidc.refresh()
+    asyncio.create_task(oidc.refresh_loop())
+    asyncio.create_task(key_ring.rotation_loop())
+    await api_keys.refresh()
+    asyncio.create_task(api_keys.refresh_loop())
+    asyncio.create_task(dnd_release_loop())
+    asyncio.create_task(wake_up_call_loop())
+    asyncio.create_task(incident_realert_loop())
+    asyncio.create_task(pm_scheduler_loop())
+    asyncio.create_task(sla_breach_loop())
+    asyncio.create_task(group_digest_loop())
+    asyncio.create_task(workflow_timer_loop())
+    asyncio.create_task(staff_presence_loop())
+    asyncio.create_task(routing_rules.refresh_loop())
+    asyncio.create_task(feature_flags.refresh_loop())
+    asyncio.create_task(sandbox_purge_loop())
+    asyncio.create_task(consume_chat_requests())
+    asyncio.create_task(watch_work_order_changes())
+    asyncio.create_task(read_model_projector.project_loop())
+
+@app.on_event("shutdown")
+async def shutdown_event():
+    await release_held_leases()
+    await http_client.aclose()
+    await DatabaseConnection.close()